@@ -0,0 +1,45 @@
+package v1alpha1
+
+// ClusterReference names the MarkLogicCluster, in the namespace of the
+// referencing resource, whose MarkLogic cluster holds the configuration.
+type ClusterReference struct {
+	// Name of the MarkLogicCluster
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+}
+
+// DeletionPolicy controls what happens to the MarkLogic configuration when
+// the resource describing it is deleted.
+// +kubebuilder:validation:Enum=Retain;Delete
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyRetain keeps the configuration in MarkLogic.
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+	// DeletionPolicyDelete removes the configuration from MarkLogic.
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+)
+
+// DriftPolicy controls what happens when the MarkLogic configuration was
+// changed outside of the operator.
+// +kubebuilder:validation:Enum=Correct;Report
+type DriftPolicy string
+
+const (
+	// DriftPolicyCorrect reverts the configuration to the spec.
+	DriftPolicyCorrect DriftPolicy = "Correct"
+	// DriftPolicyReport only reports the drift in the status.
+	DriftPolicyReport DriftPolicy = "Report"
+)
+
+// ConditionInSync is true when the MarkLogic configuration matches the spec.
+const ConditionInSync = "InSync"
+
+// Permission grants a role a capability on the documents it applies to.
+type Permission struct {
+	// +kubebuilder:validation:MinLength=1
+	RoleName string `json:"roleName"`
+
+	// +kubebuilder:validation:Enum=read;update;insert;execute;node-update
+	Capability string `json:"capability"`
+}
@@ -0,0 +1,34 @@
+package v1alpha1
+
+// Default fills the unset fields of the App Server with the defaults of the CRD schema.
+// The group defaults to the group of the cluster, which the resource does not know.
+func (s *MarkLogicAppServer) Default() {
+	spec := &s.Spec
+	if spec.ServerName == "" {
+		spec.ServerName = s.Name
+	}
+	if spec.ServerType == "" {
+		spec.ServerType = ServerTypeHTTP
+	}
+	if spec.Root == "" {
+		spec.Root = "/"
+	}
+	if spec.ContentDatabase == "" {
+		spec.ContentDatabase = "Documents"
+	}
+	if spec.Authentication == "" {
+		spec.Authentication = "digest"
+	}
+	if spec.HAProxy.Enabled == nil {
+		spec.HAProxy.Enabled = boolPtr(true)
+	}
+	if spec.HAProxy.Port == 0 {
+		spec.HAProxy.Port = spec.Port
+	}
+	if spec.DriftPolicy == "" {
+		spec.DriftPolicy = DriftPolicyCorrect
+	}
+	if spec.DeletionPolicy == "" {
+		spec.DeletionPolicy = DeletionPolicyRetain
+	}
+}
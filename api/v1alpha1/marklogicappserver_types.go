@@ -0,0 +1,188 @@
+package v1alpha1
+
+import (
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// App Server types supported by MarkLogicAppServer.
+const (
+	ServerTypeHTTP = "http"
+	ServerTypeXDBC = "xdbc"
+	ServerTypeODBC = "odbc"
+)
+
+// MarkLogicAppServerSpec defines the desired state of MarkLogicAppServer.
+// Field names follow the App Server properties of the Management API.
+type MarkLogicAppServerSpec struct {
+	// The MarkLogicCluster hosting the App Server
+	ClusterRef ClusterReference `json:"clusterRef"`
+
+	// Name of the App Server in MarkLogic. Defaults to the name of the resource.
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="serverName is immutable"
+	// +optional
+	ServerName string `json:"serverName,omitempty"`
+
+	// +kubebuilder:validation:Enum=http;xdbc;odbc
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="serverType is immutable"
+	// +kubebuilder:default=http
+	// +optional
+	ServerType string `json:"serverType,omitempty"`
+
+	// Group of the App Server. Defaults to the group of the cluster.
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="groupName is immutable"
+	// +optional
+	GroupName string `json:"groupName,omitempty"`
+
+	// Port the App Server listens on in MarkLogic
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
+
+	// +kubebuilder:default="/"
+	// +optional
+	Root string `json:"root,omitempty"`
+
+	// +kubebuilder:default=Documents
+	// +optional
+	ContentDatabase string `json:"contentDatabase,omitempty"`
+
+	// Modules database of the App Server. Modules are read from the file system under root if empty.
+	// +optional
+	ModulesDatabase string `json:"modulesDatabase,omitempty"`
+
+	// +kubebuilder:validation:Enum=digest;basic;digestbasic;application-level;certificate;kerberos-ticket;saml;oauth
+	// +kubebuilder:default=digest
+	// +optional
+	Authentication string `json:"authentication,omitempty"`
+
+	// Further App Server properties in the JSON format of
+	// PUT /manage/v2/servers/{name}/properties. The fields above take precedence.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +optional
+	Properties *runtime.RawExtension `json:"properties,omitempty"`
+
+	// Load balancing of the App Server by the HAProxy of the cluster
+	// +kubebuilder:default={}
+	// +optional
+	HAProxy AppServerHAProxy `json:"haproxy,omitempty"`
+
+	// Ingress exposing the App Server
+	// +optional
+	Ingress AppServerIngress `json:"ingress,omitempty"`
+
+	// Whether changes made to the App Server outside of the operator are reverted or only reported
+	// +kubebuilder:default=Correct
+	// +optional
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+
+	// Whether the App Server is deleted from MarkLogic with the resource
+	// +kubebuilder:default=Retain
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// AppServerHAProxy configures how HAProxy exposes an App Server. It only
+// applies when HAProxy is enabled on the cluster.
+type AppServerHAProxy struct {
+	// +kubebuilder:default=true
+	// +optional
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// Port HAProxy listens on for the App Server. Defaults to the port of the App Server.
+	// Ignored by HTTP App Servers when path based routing is enabled on the cluster.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port int32 `json:"port,omitempty"`
+
+	// Path of the App Server on the HAProxy frontend when path based routing is enabled on the
+	// cluster, for example /app
+	// +kubebuilder:validation:Pattern=`^/.*`
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+// AppServerIngress configures the Ingress of an App Server. The Ingress
+// routes to HAProxy when the App Server is load balanced by it, otherwise to
+// the service of the cluster.
+type AppServerIngress struct {
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// +optional
+	ClassName *string `json:"className,omitempty"`
+
+	// +optional
+	Host string `json:"host,omitempty"`
+
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// +optional
+	TLS []networkingv1.IngressTLS `json:"tls,omitempty"`
+}
+
+// MarkLogicAppServerStatus defines the observed state of MarkLogicAppServer
+type MarkLogicAppServerStatus struct {
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Name of the App Server in MarkLogic
+	// +optional
+	ServerName string `json:"serverName,omitempty"`
+
+	// Addresses the App Server is reachable at from inside the Kubernetes cluster
+	// +optional
+	Endpoints []string `json:"endpoints,omitempty"`
+
+	// App Server properties that differed from the spec at the last check
+	// +optional
+	Drift []string `json:"drift,omitempty"`
+
+	// Last time the App Server properties were compared against the spec
+	// +optional
+	LastDriftCheck *metav1.Time `json:"lastDriftCheck,omitempty"`
+
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=mlas
+// +kubebuilder:printcolumn:name="Cluster",type=string,JSONPath=`.spec.clusterRef.name`
+// +kubebuilder:printcolumn:name="Type",type=string,JSONPath=`.spec.serverType`
+// +kubebuilder:printcolumn:name="Port",type=integer,JSONPath=`.spec.port`
+// +kubebuilder:printcolumn:name="In Sync",type=string,JSONPath=`.status.conditions[?(@.type=="InSync")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// MarkLogicAppServer is the Schema for the marklogicappservers API
+type MarkLogicAppServer struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MarkLogicAppServerSpec   `json:"spec,omitempty"`
+	Status MarkLogicAppServerStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// MarkLogicAppServerList contains a list of MarkLogicAppServer
+type MarkLogicAppServerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MarkLogicAppServer `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MarkLogicAppServer{}, &MarkLogicAppServerList{})
+}
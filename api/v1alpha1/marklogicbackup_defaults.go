@@ -0,0 +1,27 @@
+package v1alpha1
+
+// Default fills the unset fields of the backup template with the defaults of the CRD schema.
+func (t *BackupTemplate) Default() {
+	if t.IncludeReplicas == nil {
+		t.IncludeReplicas = boolPtr(true)
+	}
+	if t.IncrementalDir == "" {
+		t.IncrementalDir = t.BackupDir
+	}
+}
+
+// Default fills the unset fields of the backup with the defaults of the CRD schema.
+func (b *MarkLogicBackup) Default() {
+	b.Spec.BackupTemplate.Default()
+}
+
+// Default fills the unset fields of the schedule with the defaults of the CRD schema.
+func (s *MarkLogicBackupSchedule) Default() {
+	s.Spec.BackupTemplate.Default()
+	if s.Spec.Retention.Full == nil {
+		s.Spec.Retention.Full = int32Ptr(7)
+	}
+	if s.Spec.Retention.Failed == nil {
+		s.Spec.Retention.Failed = int32Ptr(3)
+	}
+}
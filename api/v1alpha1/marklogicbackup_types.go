@@ -0,0 +1,120 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BackupPhase is the lifecycle phase of a MarkLogicBackup.
+// +kubebuilder:validation:Enum=Pending;Running;Completed;Failed
+type BackupPhase string
+
+const (
+	// BackupPending is a backup that has not been started yet.
+	BackupPending BackupPhase = "Pending"
+	// BackupRunning is a backup job in progress in MarkLogic.
+	BackupRunning BackupPhase = "Running"
+	// BackupCompleted is a backup that finished successfully.
+	BackupCompleted BackupPhase = "Completed"
+	// BackupFailed is a backup that MarkLogic refused, failed or cancelled.
+	BackupFailed BackupPhase = "Failed"
+)
+
+// LabelBackupSchedule is set on the backups started by a MarkLogicBackupSchedule
+// to the name of the schedule.
+const LabelBackupSchedule = "marklogic.com/backup-schedule"
+
+// BackupTemplate describes what a backup saves and where.
+type BackupTemplate struct {
+	// Database to back up
+	// +kubebuilder:validation:MinLength=1
+	Database string `json:"database"`
+
+	// Directory the backup is written to. It has to exist on every host of the
+	// cluster, typically on a shared volume.
+	// +kubebuilder:validation:MinLength=1
+	BackupDir string `json:"backupDir"`
+
+	// Whether the replica forests of the database are backed up too
+	// +kubebuilder:default=true
+	// +optional
+	IncludeReplicas *bool `json:"includeReplicas,omitempty"`
+
+	// Directory incremental backups are written to. Defaults to backupDir.
+	// +optional
+	IncrementalDir string `json:"incrementalDir,omitempty"`
+}
+
+// MarkLogicBackupSpec defines the desired state of MarkLogicBackup. A backup
+// runs once; its spec can not be changed.
+// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="spec is immutable"
+type MarkLogicBackupSpec struct {
+	// The MarkLogicCluster holding the database
+	ClusterRef ClusterReference `json:"clusterRef"`
+
+	BackupTemplate `json:",inline"`
+
+	// Back up only the changes since the last full or incremental backup in
+	// backupDir, which must already hold a full backup
+	// +optional
+	Incremental bool `json:"incremental,omitempty"`
+}
+
+// MarkLogicBackupStatus defines the observed state of MarkLogicBackup
+type MarkLogicBackupStatus struct {
+	// +optional
+	Phase BackupPhase `json:"phase,omitempty"`
+
+	// ID of the backup job in MarkLogic
+	// +optional
+	JobID string `json:"jobID,omitempty"`
+
+	// Host running the backup job
+	// +optional
+	HostName string `json:"hostName,omitempty"`
+
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Reason of the failure of the backup
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=mlbackup
+// +kubebuilder:printcolumn:name="Cluster",type=string,JSONPath=`.spec.clusterRef.name`
+// +kubebuilder:printcolumn:name="Database",type=string,JSONPath=`.spec.database`
+// +kubebuilder:printcolumn:name="Incremental",type=boolean,JSONPath=`.spec.incremental`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// MarkLogicBackup is the Schema for the marklogicbackups API
+type MarkLogicBackup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MarkLogicBackupSpec   `json:"spec,omitempty"`
+	Status MarkLogicBackupStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// MarkLogicBackupList contains a list of MarkLogicBackup
+type MarkLogicBackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MarkLogicBackup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MarkLogicBackup{}, &MarkLogicBackupList{})
+}
@@ -0,0 +1,114 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MarkLogicBackupScheduleSpec defines the desired state of MarkLogicBackupSchedule
+type MarkLogicBackupScheduleSpec struct {
+	// The MarkLogicCluster holding the database
+	ClusterRef ClusterReference `json:"clusterRef"`
+
+	BackupTemplate `json:",inline"`
+
+	// Cron schedule of the full backups, for example "0 2 * * *"
+	// +kubebuilder:validation:MinLength=1
+	Schedule string `json:"schedule"`
+
+	// Cron schedule of the incremental backups taken between full backups.
+	// Incremental backups start once a full backup has completed.
+	// +optional
+	IncrementalSchedule string `json:"incrementalSchedule,omitempty"`
+
+	// Time zone of the schedules, for example "Europe/Berlin". Defaults to UTC.
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// Suspends the start of new backups. Running backups are not affected.
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+
+	// How many backups are kept
+	// +kubebuilder:default={}
+	// +optional
+	Retention BackupRetention `json:"retention,omitempty"`
+}
+
+// BackupRetention limits the backups kept by a MarkLogicBackupSchedule.
+type BackupRetention struct {
+	// Number of full backups kept in backupDir. Older backups are purged from
+	// MarkLogic, their MarkLogicBackups and those of their incremental backups deleted.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=7
+	// +optional
+	Full *int32 `json:"full,omitempty"`
+
+	// Number of failed MarkLogicBackups kept for inspection
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=3
+	// +optional
+	Failed *int32 `json:"failed,omitempty"`
+}
+
+// MarkLogicBackupScheduleStatus defines the observed state of MarkLogicBackupSchedule
+type MarkLogicBackupScheduleStatus struct {
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Last time a full backup was scheduled
+	// +optional
+	LastFullScheduleTime *metav1.Time `json:"lastFullScheduleTime,omitempty"`
+
+	// Last time an incremental backup was scheduled
+	// +optional
+	LastIncrementalScheduleTime *metav1.Time `json:"lastIncrementalScheduleTime,omitempty"`
+
+	// Last time a backup completed
+	// +optional
+	LastSuccessfulTime *metav1.Time `json:"lastSuccessfulTime,omitempty"`
+
+	// MarkLogicBackups in progress
+	// +optional
+	Active []string `json:"active,omitempty"`
+
+	// Last full backup older backups were purged for
+	// +optional
+	LastPurgedBackup string `json:"lastPurgedBackup,omitempty"`
+
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=mlbackupschedule
+// +kubebuilder:printcolumn:name="Cluster",type=string,JSONPath=`.spec.clusterRef.name`
+// +kubebuilder:printcolumn:name="Database",type=string,JSONPath=`.spec.database`
+// +kubebuilder:printcolumn:name="Schedule",type=string,JSONPath=`.spec.schedule`
+// +kubebuilder:printcolumn:name="Suspend",type=boolean,JSONPath=`.spec.suspend`
+// +kubebuilder:printcolumn:name="Last Success",type=date,JSONPath=`.status.lastSuccessfulTime`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// MarkLogicBackupSchedule is the Schema for the marklogicbackupschedules API
+type MarkLogicBackupSchedule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MarkLogicBackupScheduleSpec   `json:"spec,omitempty"`
+	Status MarkLogicBackupScheduleStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// MarkLogicBackupScheduleList contains a list of MarkLogicBackupSchedule
+type MarkLogicBackupScheduleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MarkLogicBackupSchedule `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MarkLogicBackupSchedule{}, &MarkLogicBackupScheduleList{})
+}
@@ -0,0 +1,216 @@
+package v1alpha1
+
+import (
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Default fills the unset fields of the cluster with the defaults of charts/values.yaml.
+// The CRD schema applies the same defaults on admission; this covers objects that never went
+// through the API server, such as the ones built in tests.
+func (c *MarkLogicCluster) Default() {
+	s := &c.Spec
+	if s.ReplicaCount == nil {
+		s.ReplicaCount = int32Ptr(1)
+	}
+	if s.UpdateStrategy.Type == "" {
+		s.UpdateStrategy.Type = appsv1.OnDeleteStatefulSetStrategyType
+	}
+	if s.UpdateStrategy.Managed == nil {
+		s.UpdateStrategy.Managed = boolPtr(true)
+	}
+	if s.UpdateStrategy.HostTimeout == nil {
+		s.UpdateStrategy.HostTimeout = &metav1.Duration{Duration: 15 * time.Minute}
+	}
+	if s.ScaleDown.Managed == nil {
+		s.ScaleDown.Managed = boolPtr(true)
+	}
+	if s.ScaleDown.DeleteVolumes == nil {
+		s.ScaleDown.DeleteVolumes = boolPtr(true)
+	}
+	if s.PodDisruptionBudget.Enabled == nil {
+		s.PodDisruptionBudget.Enabled = boolPtr(true)
+	}
+	if s.PodDisruptionBudget.MaxUnavailable == nil {
+		maxUnavailable := intstr.FromInt32(1)
+		s.PodDisruptionBudget.MaxUnavailable = &maxUnavailable
+	}
+	if s.PodDisruptionBudget.GuardEvictions == nil {
+		s.PodDisruptionBudget.GuardEvictions = boolPtr(true)
+	}
+	if s.TLS.Rotate == nil {
+		s.TLS.Rotate = boolPtr(true)
+	}
+	if s.TLS.RenewBefore == nil {
+		s.TLS.RenewBefore = &metav1.Duration{Duration: 30 * 24 * time.Hour}
+	}
+	if cm := s.TLS.CertManager; cm != nil {
+		if cm.PrivateKeyAlgorithm == "" {
+			cm.PrivateKeyAlgorithm = "RSA"
+		}
+		if cm.IssuerRef.Kind == "" {
+			cm.IssuerRef.Kind = "Issuer"
+		}
+		if cm.IssuerRef.Group == "" {
+			cm.IssuerRef.Group = "cert-manager.io"
+		}
+	}
+	if s.Auth.Provider == "" {
+		s.Auth.Provider = CredentialsProviderSecret
+	}
+	if v := s.Auth.Vault; v != nil && v.AuthMount == "" {
+		v.AuthMount = "kubernetes"
+	}
+	if s.TerminationGracePeriod == nil {
+		s.TerminationGracePeriod = int64Ptr(120)
+	}
+	if s.ClusterDomain == "" {
+		s.ClusterDomain = "cluster.local"
+	}
+	if s.Group.Name == "" {
+		s.Group.Name = "Default"
+	}
+	if s.Group.EnableXdqpSsl == nil {
+		s.Group.EnableXdqpSsl = boolPtr(true)
+	}
+	if s.Image.Repository == "" {
+		s.Image.Repository = "progressofficial/marklogic-db"
+	}
+	if s.Image.Tag == "" {
+		s.Image.Tag = "11.3.0-ubi-rootless"
+	}
+	if s.Image.PullPolicy == "" {
+		s.Image.PullPolicy = corev1.PullIfNotPresent
+	}
+	if s.InitContainers.UtilContainer.Image == "" {
+		s.InitContainers.UtilContainer.Image = "redhat/ubi9:9.4"
+	}
+	if s.InitContainers.UtilContainer.PullPolicy == "" {
+		s.InitContainers.UtilContainer.PullPolicy = corev1.PullIfNotPresent
+	}
+	if s.Agent.Image != "" && s.Agent.PullPolicy == "" {
+		s.Agent.PullPolicy = corev1.PullIfNotPresent
+	}
+	if s.Metrics.Port == 0 {
+		s.Metrics.Port = 9106
+	}
+	if s.Metrics.ServiceMonitor.Interval == "" {
+		s.Metrics.ServiceMonitor.Interval = "30s"
+	}
+	if s.Metrics.ServiceMonitor.ScrapeTimeout == "" {
+		s.Metrics.ServiceMonitor.ScrapeTimeout = "10s"
+	}
+	if s.LogCollection.Files.ErrorLogs == nil {
+		s.LogCollection.Files.ErrorLogs = boolPtr(true)
+	}
+	if s.LogCollection.Files.AccessLogs == nil {
+		s.LogCollection.Files.AccessLogs = boolPtr(true)
+	}
+	if s.LogCollection.Files.RequestLogs == nil {
+		s.LogCollection.Files.RequestLogs = boolPtr(true)
+	}
+	if s.LogCollection.Files.CrashLogs == nil {
+		s.LogCollection.Files.CrashLogs = boolPtr(true)
+	}
+	if s.LogCollection.Files.AuditLogs == nil {
+		s.LogCollection.Files.AuditLogs = boolPtr(true)
+	}
+	if s.HugePages.MountPath == "" {
+		s.HugePages.MountPath = "/dev/hugepages"
+	}
+	if s.Persistence.Enabled == nil {
+		s.Persistence.Enabled = boolPtr(true)
+	}
+	if s.Persistence.Size == nil {
+		size := resource.MustParse("10Gi")
+		s.Persistence.Size = &size
+	}
+	if len(s.Persistence.AccessModes) == 0 {
+		s.Persistence.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	}
+	if s.Service.Type == "" {
+		s.Service.Type = corev1.ServiceTypeClusterIP
+	}
+	defaultProbe(&s.LivenessProbe, 300, 10, 5, 15, 1)
+	defaultProbe(&s.ReadinessProbe, 30, 10, 5, 3, 1)
+	defaultProbe(&s.StartupProbe, 10, 10, 5, 180, 1)
+
+	h := &s.HAProxy
+	if h.Image.Repository == "" {
+		h.Image.Repository = "haproxytech/haproxy-alpine"
+	}
+	if h.Image.Tag == "" {
+		h.Image.Tag = "2.9.4"
+	}
+	if h.Image.PullPolicy == "" {
+		h.Image.PullPolicy = corev1.PullIfNotPresent
+	}
+	if h.ReplicaCount == nil {
+		h.ReplicaCount = int32Ptr(2)
+	}
+	if h.Stats.Port == 0 {
+		h.Stats.Port = 1024
+	}
+	if h.Service.Type == "" {
+		h.Service.Type = corev1.ServiceTypeClusterIP
+	}
+	if h.FrontendPort == 0 {
+		h.FrontendPort = 443
+	}
+	defaultRoute(&h.DefaultAppServers.AppServices, "/console", 8000)
+	defaultRoute(&h.DefaultAppServers.Admin, "/adminUI", 8001)
+	defaultRoute(&h.DefaultAppServers.Manage, "/manage", 8002)
+	for i := range h.AdditionalAppServers {
+		if h.AdditionalAppServers[i].Type == "" {
+			h.AdditionalAppServers[i].Type = "HTTP"
+		}
+	}
+	if h.Timeout.Client == "" {
+		h.Timeout.Client = "600s"
+	}
+	if h.Timeout.Connect == "" {
+		h.Timeout.Connect = "600s"
+	}
+	if h.Timeout.Server == "" {
+		h.Timeout.Server = "600s"
+	}
+}
+
+func defaultProbe(p *Probe, initialDelay, period, timeout, failure, success int32) {
+	if p.Enabled == nil {
+		p.Enabled = boolPtr(true)
+	}
+	if p.InitialDelaySeconds == nil {
+		p.InitialDelaySeconds = int32Ptr(initialDelay)
+	}
+	if p.PeriodSeconds == nil {
+		p.PeriodSeconds = int32Ptr(period)
+	}
+	if p.TimeoutSeconds == nil {
+		p.TimeoutSeconds = int32Ptr(timeout)
+	}
+	if p.FailureThreshold == nil {
+		p.FailureThreshold = int32Ptr(failure)
+	}
+	if p.SuccessThreshold == nil {
+		p.SuccessThreshold = int32Ptr(success)
+	}
+}
+
+func defaultRoute(r *AppServerRoute, path string, port int32) {
+	if r.Path == "" {
+		r.Path = path
+	}
+	if r.Port == 0 {
+		r.Port = port
+	}
+}
+
+func boolPtr(b bool) *bool    { return &b }
+func int32Ptr(i int32) *int32 { return &i }
+func int64Ptr(i int64) *int64 { return &i }
@@ -0,0 +1,1154 @@
+package v1alpha1
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// MarkLogicClusterSpec defines the desired state of MarkLogicCluster.
+// Field names follow charts/values.yaml so existing values files translate directly.
+type MarkLogicClusterSpec struct {
+	// Number of MarkLogic nodes
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	ReplicaCount *int32 `json:"replicaCount,omitempty"`
+
+	// Update strategy for MarkLogic upgrades. OnDelete is recommended as the bootstrap host (pod-0)
+	// needs to be upgraded first in the cluster.
+	// +kubebuilder:default={type: OnDelete}
+	// +optional
+	UpdateStrategy UpdateStrategy `json:"updateStrategy,omitempty"`
+
+	// How hosts leave the cluster when replicaCount is lowered
+	// +kubebuilder:default={}
+	// +optional
+	ScaleDown ScaleDown `json:"scaleDown,omitempty"`
+
+	// Voluntary disruptions of the MarkLogic pods, such as node drains
+	// +kubebuilder:default={}
+	// +optional
+	PodDisruptionBudget PodDisruptionBudget `json:"podDisruptionBudget,omitempty"`
+
+	// Termination grace period in seconds
+	// +kubebuilder:default=120
+	// +optional
+	TerminationGracePeriod *int64 `json:"terminationGracePeriod,omitempty"`
+
+	// Kubernetes cluster domain name
+	// +kubebuilder:default="cluster.local"
+	// +optional
+	ClusterDomain string `json:"clusterDomain,omitempty"`
+
+	// Allow deployment with hostname over 64 characters
+	// +optional
+	AllowLongHostnames bool `json:"allowLongHostnames,omitempty"`
+
+	// Annotations for the MarkLogic pods
+	// +optional
+	PodAnnotations map[string]string `json:"podAnnotations,omitempty"`
+
+	// Group related settings
+	// +kubebuilder:default={name: Default, enableXdqpSsl: true}
+	// +optional
+	Group Group `json:"group,omitempty"`
+
+	// The name of the host to join. If not provided, the deployment is a bootstrap host.
+	// +optional
+	BootstrapHostName string `json:"bootstrapHostName,omitempty"`
+
+	// MarkLogic image parameters
+	// +kubebuilder:default={}
+	// +optional
+	Image Image `json:"image,omitempty"`
+
+	// Init container image parameters
+	// +kubebuilder:default={}
+	// +optional
+	InitContainers InitContainers `json:"initContainers,omitempty"`
+
+	// The MarkLogic agent used by the lifecycle hooks instead of the scripts.
+	// The scripts are used when no agent image is set.
+	// +optional
+	Agent Agent `json:"agent,omitempty"`
+
+	// Prometheus exporter of the MarkLogic metrics, run by the agent next to
+	// each host. Needs agent.image.
+	// +kubebuilder:default={}
+	// +optional
+	Metrics Metrics `json:"metrics,omitempty"`
+
+	// Log shipper exporting the MarkLogic log files to an OpenTelemetry collector, run by
+	// the agent next to each host. Needs agent.image.
+	// +kubebuilder:default={}
+	// +optional
+	LogCollection LogCollection `json:"logCollection,omitempty"`
+
+	// Secrets used to pull images from a private registry
+	// +optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	// Huge pages settings. MarkLogic only supports 2Mi huge pages.
+	// +kubebuilder:default={}
+	// +optional
+	HugePages HugePages `json:"hugepages,omitempty"`
+
+	// MarkLogic pods' resource requests and limits
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// Admin credentials
+	// +optional
+	Auth Auth `json:"auth,omitempty"`
+
+	// TLS settings for the default App Servers (8000, 8001, 8002)
+	// +optional
+	TLS TLS `json:"tls,omitempty"`
+
+	// Install converters package on MarkLogic
+	// +optional
+	EnableConverters bool `json:"enableConverters,omitempty"`
+
+	// License information for MarkLogic server
+	// +optional
+	License License `json:"license,omitempty"`
+
+	// Realm used when initializing the security database
+	// +optional
+	Realm string `json:"realm,omitempty"`
+
+	// Affinity rules for scheduling MarkLogic pods
+	// +optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+
+	// Topology spread constraints for MarkLogic pods. When empty the chart defaults are used.
+	// +optional
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+
+	// Node selector for MarkLogic pods
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Priority class for MarkLogic pods
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+
+	// Persistence using persistent volume claims. When disabled an EmptyDir volume is used.
+	// +kubebuilder:default={}
+	// +optional
+	Persistence Persistence `json:"persistence,omitempty"`
+
+	// Service used to access the MarkLogic cluster
+	// +kubebuilder:default={}
+	// +optional
+	Service Service `json:"service,omitempty"`
+
+	// Name of an existing service account. When empty a service account is created for the cluster.
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// Pod level security context. When not set, fsGroup 2 with OnRootMismatch is used.
+	// +optional
+	PodSecurityContext *corev1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+
+	// Security context for the MarkLogic container. When not set, the container runs as the non-root user 1000.
+	// +optional
+	ContainerSecurityContext *corev1.SecurityContext `json:"containerSecurityContext,omitempty"`
+
+	// Liveness probe options
+	// +kubebuilder:default={}
+	// +optional
+	LivenessProbe Probe `json:"livenessProbe,omitempty"`
+
+	// Readiness probe options
+	// +kubebuilder:default={}
+	// +optional
+	ReadinessProbe Probe `json:"readinessProbe,omitempty"`
+
+	// Startup probe options, used with the agent. Liveness and readiness are only probed once
+	// the host completed its bootstrap.
+	// +kubebuilder:default={}
+	// +optional
+	StartupProbe Probe `json:"startupProbe,omitempty"`
+
+	// HAProxy load balancer in front of the cluster
+	// +optional
+	HAProxy HAProxy `json:"haproxy,omitempty"`
+}
+
+// UpdateStrategy configures how pods of the StatefulSet are replaced.
+type UpdateStrategy struct {
+	// +kubebuilder:validation:Enum=OnDelete;RollingUpdate
+	// +kubebuilder:default=OnDelete
+	// +optional
+	Type appsv1.StatefulSetUpdateStrategyType `json:"type,omitempty"`
+
+	// Let the operator replace the pods of an OnDelete StatefulSet one at a time when the pod
+	// template changes, bootstrap host first, upgrading the Security database after it
+	// +kubebuilder:default=true
+	// +optional
+	Managed *bool `json:"managed,omitempty"`
+
+	// Stop a managed upgrade before the next host is replaced
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+
+	// How long a replaced host may take to become ready and restart before the upgrade pauses
+	// +kubebuilder:default="15m"
+	// +optional
+	HostTimeout *metav1.Duration `json:"hostTimeout,omitempty"`
+}
+
+// ScaleDown configures how the operator removes hosts when replicaCount is lowered.
+type ScaleDown struct {
+	// Keep the pods above replicaCount until their forests are evacuated and their hosts
+	// removed from the MarkLogic cluster. Scaling to zero never evacuates.
+	// +kubebuilder:default=true
+	// +optional
+	Managed *bool `json:"managed,omitempty"`
+
+	// Delete the data volume claims of the removed pods, so hosts added later start empty
+	// +kubebuilder:default=true
+	// +optional
+	DeleteVolumes *bool `json:"deleteVolumes,omitempty"`
+}
+
+// PodDisruptionBudget configures the PodDisruptionBudget of the MarkLogic pods and the
+// eviction webhook guarding them.
+type PodDisruptionBudget struct {
+	// Create a PodDisruptionBudget for the MarkLogic pods
+	// +kubebuilder:default=true
+	// +optional
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// Number or percentage of MarkLogic pods that may be evicted at the same time
+	// +kubebuilder:default=1
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+
+	// Let the eviction webhook of the operator refuse evictions that would leave the
+	// cluster without a quorum of online hosts or a forest without an open copy
+	// +kubebuilder:default=true
+	// +optional
+	GuardEvictions *bool `json:"guardEvictions,omitempty"`
+}
+
+// Metrics configures the Prometheus exporter of the host, database and
+// forest metrics of the Management API.
+type Metrics struct {
+	// Run the exporter as a sidecar of the MarkLogic pods
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Port the metrics are served on
+	// +kubebuilder:default=9106
+	// +optional
+	Port int32 `json:"port,omitempty"`
+
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// ServiceMonitor of the Prometheus Operator scraping the exporters
+	// +kubebuilder:default={}
+	// +optional
+	ServiceMonitor ServiceMonitor `json:"serviceMonitor,omitempty"`
+}
+
+// ServiceMonitor configures the ServiceMonitor created for the Prometheus
+// Operator. It is only created when its CRD is installed.
+type ServiceMonitor struct {
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Labels added to the ServiceMonitor to be selected by the Prometheus instance
+	// +optional
+	ExtraLabels map[string]string `json:"extraLabels,omitempty"`
+
+	// +kubebuilder:default="30s"
+	// +optional
+	Interval string `json:"interval,omitempty"`
+
+	// +kubebuilder:default="10s"
+	// +optional
+	ScrapeTimeout string `json:"scrapeTimeout,omitempty"`
+}
+
+// LogCollection configures the shipping of the MarkLogic log files with OTLP, in place of
+// the fluent-bit sidecar of the chart.
+type LogCollection struct {
+	// Run the log shipper as a sidecar of the MarkLogic pods
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Log files shipped
+	// +kubebuilder:default={}
+	// +optional
+	Files LogFiles `json:"files,omitempty"`
+
+	// Base URL of the OTLP/HTTP receiver of the collector, e.g. http://otel-collector:4318
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Headers sent with every export, e.g. to authenticate to the collector
+	// +optional
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Do not verify the certificate of an https endpoint
+	// +optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// LogFiles selects the MarkLogic log files shipped, like logCollection.files of the chart.
+type LogFiles struct {
+	// ErrorLog.txt and the error logs of the App Servers
+	// +kubebuilder:default=true
+	// +optional
+	ErrorLogs *bool `json:"errorLogs,omitempty"`
+
+	// Access logs of the App Servers
+	// +kubebuilder:default=true
+	// +optional
+	AccessLogs *bool `json:"accessLogs,omitempty"`
+
+	// Request logs of the App Servers
+	// +kubebuilder:default=true
+	// +optional
+	RequestLogs *bool `json:"requestLogs,omitempty"`
+
+	// CrashLog.txt
+	// +kubebuilder:default=true
+	// +optional
+	CrashLogs *bool `json:"crashLogs,omitempty"`
+
+	// AuditLog.txt
+	// +kubebuilder:default=true
+	// +optional
+	AuditLogs *bool `json:"auditLogs,omitempty"`
+}
+
+// Group holds the MarkLogic group settings.
+type Group struct {
+	// The group name of the MarkLogic deployment
+	// +kubebuilder:default="Default"
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// XDQP encryption for intra cluster network traffic
+	// +kubebuilder:default=true
+	// +optional
+	EnableXdqpSsl *bool `json:"enableXdqpSsl,omitempty"`
+}
+
+// Image describes a container image.
+type Image struct {
+	// +kubebuilder:default="progressofficial/marklogic-db"
+	// +optional
+	Repository string `json:"repository,omitempty"`
+
+	// +kubebuilder:default="11.3.0-ubi-rootless"
+	// +optional
+	Tag string `json:"tag,omitempty"`
+
+	// +kubebuilder:default=IfNotPresent
+	// +optional
+	PullPolicy corev1.PullPolicy `json:"pullPolicy,omitempty"`
+}
+
+// InitContainers holds the images of the helper init containers.
+type InitContainers struct {
+	// Image used by the copy-certs and root-rootless-upgrade init containers
+	// +kubebuilder:default={}
+	// +optional
+	UtilContainer ContainerImage `json:"utilContainer,omitempty"`
+}
+
+// Agent configures the MarkLogic agent. The agent binary is copied from its image into the
+// MarkLogic pod by an init container.
+type Agent struct {
+	// Image containing the agent binary, usually the operator image
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// +optional
+	PullPolicy corev1.PullPolicy `json:"pullPolicy,omitempty"`
+}
+
+// ContainerImage is a fully qualified image reference with its pull policy.
+type ContainerImage struct {
+	// +kubebuilder:default="redhat/ubi9:9.4"
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// +kubebuilder:default=IfNotPresent
+	// +optional
+	PullPolicy corev1.PullPolicy `json:"pullPolicy,omitempty"`
+}
+
+// HugePages configures huge pages for MarkLogic.
+type HugePages struct {
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// +kubebuilder:default="/dev/hugepages"
+	// +optional
+	MountPath string `json:"mountPath,omitempty"`
+}
+
+// Auth configures the MarkLogic admin credentials.
+type Auth struct {
+	// Name of a secret with the keys username, password and wallet-password.
+	// If empty, a secret with generated credentials is created for the cluster.
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+
+	// Source of the admin credentials. Secret reads them from secretName,
+	// File from the files of a CSI volume and Vault from a key/value secret
+	// of a Vault server.
+	// +kubebuilder:default=Secret
+	// +optional
+	Provider CredentialsProvider `json:"provider,omitempty"`
+
+	// Volume holding the files username, password and wallet-password, such
+	// as a volume of the Secrets Store CSI Driver, for the File provider
+	// +optional
+	CSI *corev1.CSIVolumeSource `json:"csi,omitempty"`
+
+	// Secret of a Vault server holding the credentials, for the Vault provider
+	// +optional
+	Vault *VaultAuth `json:"vault,omitempty"`
+}
+
+// CredentialsProvider is the source of the admin credentials of a cluster.
+// +kubebuilder:validation:Enum=Secret;File;Vault
+type CredentialsProvider string
+
+const (
+	// CredentialsProviderSecret reads the credentials from a Kubernetes secret.
+	CredentialsProviderSecret CredentialsProvider = "Secret"
+	// CredentialsProviderFile reads the credentials from the files of a volume.
+	CredentialsProviderFile CredentialsProvider = "File"
+	// CredentialsProviderVault reads the credentials from a Vault server.
+	CredentialsProviderVault CredentialsProvider = "Vault"
+)
+
+// VaultAuth locates the admin credentials in the key/value secrets engine of
+// a Vault server. The pods and the operator log in with the Kubernetes auth
+// method, using the token of their service account.
+type VaultAuth struct {
+	// Base URL of the Vault server, e.g. https://vault.vault.svc:8200
+	// +kubebuilder:validation:MinLength=1
+	Address string `json:"address"`
+
+	// API path of the secret after /v1/, e.g. secret/data/marklogic for
+	// version 2 of the engine
+	// +kubebuilder:validation:MinLength=1
+	Path string `json:"path"`
+
+	// Role the pods log in as
+	// +kubebuilder:validation:MinLength=1
+	Role string `json:"role"`
+
+	// Role the operator logs in as, the role of the pods if empty
+	// +optional
+	OperatorRole string `json:"operatorRole,omitempty"`
+
+	// Mount path of the Kubernetes auth method
+	// +kubebuilder:default=kubernetes
+	// +optional
+	AuthMount string `json:"authMount,omitempty"`
+
+	// Vault Enterprise namespace of the secret
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Secret holding ca.crt, the CA of the certificate of the Vault server
+	// +optional
+	CASecretName string `json:"caSecretName,omitempty"`
+}
+
+// TLS configures TLS for the default App Servers.
+type TLS struct {
+	// +optional
+	EnableOnDefaultAppServers bool `json:"enableOnDefaultAppServers,omitempty"`
+
+	// Secrets holding one named certificate (tls.crt, tls.key) per host.
+	// If not provided, a self-signed temporary certificate will be generated.
+	// +optional
+	CertSecretNames []string `json:"certSecretNames,omitempty"`
+
+	// Secret holding the CA certificate (cacert.pem) of the named certificates
+	// +optional
+	CASecretName string `json:"caSecretName,omitempty"`
+
+	// Insert renewed named certificates into MarkLogic, one host at a time
+	// +kubebuilder:default=true
+	// +optional
+	Rotate *bool `json:"rotate,omitempty"`
+
+	// How long before their expiry certificates are reported as expiring
+	// +kubebuilder:default="720h"
+	// +optional
+	RenewBefore *metav1.Duration `json:"renewBefore,omitempty"`
+
+	// Request the named certificates of the hosts from cert-manager instead
+	// of certSecretNames. Requires cert-manager in the Kubernetes cluster.
+	// +optional
+	CertManager *CertManager `json:"certManager,omitempty"`
+}
+
+// CertManager configures the cert-manager Certificates of the hosts. The
+// operator creates one Certificate per pod and collects the issued
+// certificates into the secrets read when the pods start.
+type CertManager struct {
+	// Issuer signing the host certificates
+	IssuerRef IssuerRef `json:"issuerRef"`
+
+	// Requested lifetime of the certificates, the issuer default if empty
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+
+	// +kubebuilder:validation:Enum=RSA;ECDSA
+	// +kubebuilder:default=RSA
+	// +optional
+	PrivateKeyAlgorithm string `json:"privateKeyAlgorithm,omitempty"`
+}
+
+// IssuerRef references a cert-manager Issuer or ClusterIssuer.
+type IssuerRef struct {
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:Enum=Issuer;ClusterIssuer
+	// +kubebuilder:default=Issuer
+	// +optional
+	Kind string `json:"kind,omitempty"`
+
+	// +kubebuilder:default="cert-manager.io"
+	// +optional
+	Group string `json:"group,omitempty"`
+}
+
+// License holds the MarkLogic license information.
+type License struct {
+	// +optional
+	Key string `json:"key,omitempty"`
+	// +optional
+	Licensee string `json:"licensee,omitempty"`
+}
+
+// Persistence configures the data volume claim template.
+type Persistence struct {
+	// +kubebuilder:default=true
+	// +optional
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// The "" storageClass will use the default storage class for your cluster.
+	// +optional
+	StorageClass string `json:"storageClass,omitempty"`
+
+	// +kubebuilder:default="10Gi"
+	// +optional
+	Size *resource.Quantity `json:"size,omitempty"`
+
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// +kubebuilder:default={ReadWriteOnce}
+	// +optional
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+}
+
+// Service configures the ClusterIP service of the cluster.
+type Service struct {
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// Service type could be ClusterIP, NodePort or LoadBalancer
+	// +kubebuilder:default=ClusterIP
+	// +optional
+	Type corev1.ServiceType `json:"type,omitempty"`
+
+	// Additional service ports for App Servers added to MarkLogic
+	// +optional
+	AdditionalPorts []corev1.ServicePort `json:"additionalPorts,omitempty"`
+}
+
+// Probe holds the timing options of a probe.
+type Probe struct {
+	// +kubebuilder:default=true
+	// +optional
+	Enabled *bool `json:"enabled,omitempty"`
+	// +optional
+	InitialDelaySeconds *int32 `json:"initialDelaySeconds,omitempty"`
+	// +optional
+	PeriodSeconds *int32 `json:"periodSeconds,omitempty"`
+	// +optional
+	TimeoutSeconds *int32 `json:"timeoutSeconds,omitempty"`
+	// +optional
+	FailureThreshold *int32 `json:"failureThreshold,omitempty"`
+	// +optional
+	SuccessThreshold *int32 `json:"successThreshold,omitempty"`
+}
+
+// HAProxy configures the HAProxy load balancer deployed next to the cluster.
+type HAProxy struct {
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// +kubebuilder:default={}
+	// +optional
+	Image HAProxyImage `json:"image,omitempty"`
+
+	// Number of HAProxy replicas
+	// +kubebuilder:default=2
+	// +optional
+	ReplicaCount *int32 `json:"replicaCount,omitempty"`
+
+	// Stats page for HAProxy
+	// +optional
+	Stats HAProxyStats `json:"stats,omitempty"`
+
+	// The service of the HAProxy load balancer
+	// +kubebuilder:default={}
+	// +optional
+	Service HAProxyService `json:"service,omitempty"`
+
+	// Expose the default App Servers under sub paths of a single frontend
+	// +optional
+	PathBased HAProxyPathBased `json:"pathbased,omitempty"`
+
+	// The listening port of the frontend when path based routing is enabled
+	// +kubebuilder:default=443
+	// +optional
+	FrontendPort int32 `json:"frontendPort,omitempty"`
+
+	// Path and port used on HAProxy for the default App Servers
+	// +kubebuilder:default={}
+	// +optional
+	DefaultAppServers DefaultAppServers `json:"defaultAppServers,omitempty"`
+
+	// Additional HTTP App Servers load balanced by HAProxy
+	// +optional
+	AdditionalAppServers []HAProxyAppServer `json:"additionalAppServers,omitempty"`
+
+	// TCP ports load balanced by HAProxy, such as ODBC
+	// +optional
+	TCPPorts HAProxyTCPPorts `json:"tcpports,omitempty"`
+
+	// +kubebuilder:default={}
+	// +optional
+	Timeout HAProxyTimeout `json:"timeout,omitempty"`
+
+	// TLS termination on HAProxy
+	// +optional
+	TLS HAProxyTLS `json:"tls,omitempty"`
+
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// HAProxyImage describes the HAProxy container image.
+type HAProxyImage struct {
+	// +kubebuilder:default="haproxytech/haproxy-alpine"
+	// +optional
+	Repository string `json:"repository,omitempty"`
+
+	// +kubebuilder:default="2.9.4"
+	// +optional
+	Tag string `json:"tag,omitempty"`
+
+	// +kubebuilder:default=IfNotPresent
+	// +optional
+	PullPolicy corev1.PullPolicy `json:"pullPolicy,omitempty"`
+}
+
+// HAProxyStats configures the HAProxy stats page.
+type HAProxyStats struct {
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// +kubebuilder:default=1024
+	// +optional
+	Port int32 `json:"port,omitempty"`
+}
+
+// HAProxyService configures the service of the HAProxy load balancer.
+type HAProxyService struct {
+	// Could be ClusterIP or LoadBalancer. Set to LoadBalancer to expose to public internet.
+	// +kubebuilder:default=ClusterIP
+	// +optional
+	Type corev1.ServiceType `json:"type,omitempty"`
+}
+
+// HAProxyTCPPorts configures ports proxied in TCP (Layer 4) mode.
+type HAProxyTCPPorts struct {
+	// TCP ports have to be explicitly enabled
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+	// +optional
+	Ports []HAProxyAppServer `json:"ports,omitempty"`
+}
+
+// HAProxyPathBased configures path based routing.
+type HAProxyPathBased struct {
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+}
+
+// DefaultAppServers holds the HAProxy paths and ports of the default App Servers.
+type DefaultAppServers struct {
+	// +kubebuilder:default={path: "/console", port: 8000}
+	// +optional
+	AppServices AppServerRoute `json:"appservices,omitempty"`
+
+	// +kubebuilder:default={path: "/adminUI", port: 8001}
+	// +optional
+	Admin AppServerRoute `json:"admin,omitempty"`
+
+	// +kubebuilder:default={path: "/manage", port: 8002}
+	// +optional
+	Manage AppServerRoute `json:"manage,omitempty"`
+}
+
+// AppServerRoute is the path and port an App Server is exposed on.
+type AppServerRoute struct {
+	// +optional
+	Path string `json:"path,omitempty"`
+	// +optional
+	Port int32 `json:"port,omitempty"`
+}
+
+// HAProxyAppServer is an additional port load balanced by HAProxy.
+type HAProxyAppServer struct {
+	Name string `json:"name"`
+
+	// HTTP for Layer 7 proxy mode, TCP for Layer 4 proxy mode
+	// +kubebuilder:validation:Enum=HTTP;TCP
+	// +kubebuilder:default=HTTP
+	// +optional
+	Type string `json:"type,omitempty"`
+
+	Port int32 `json:"port"`
+
+	// +optional
+	TargetPort int32 `json:"targetPort,omitempty"`
+
+	// Path used for path based routing
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+// HAProxyTimeout holds the HAProxy timeouts. It is recommended to use the same timeout as the
+// MarkLogic App Server (default to 600 seconds).
+type HAProxyTimeout struct {
+	// +kubebuilder:default="600s"
+	// +optional
+	Client string `json:"client,omitempty"`
+	// +kubebuilder:default="600s"
+	// +optional
+	Connect string `json:"connect,omitempty"`
+	// +kubebuilder:default="600s"
+	// +optional
+	Server string `json:"server,omitempty"`
+}
+
+// HAProxyTLS configures TLS termination on HAProxy.
+type HAProxyTLS struct {
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Secret holding the PEM-formatted certificate and private key
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+
+	// The name of the certificate file in the secret
+	// +optional
+	CertFileName string `json:"certFileName,omitempty"`
+}
+
+// Condition types of a MarkLogicCluster.
+const (
+	// ConditionAvailable is true when all MarkLogic pods are ready.
+	ConditionAvailable = "Available"
+	// ConditionReconciled is false when the last reconciliation failed.
+	ConditionReconciled = "Reconciled"
+	// ConditionUpgrading is true while a managed upgrade replaces the MarkLogic pods.
+	ConditionUpgrading = "Upgrading"
+	// ConditionCertificatesValid is false when a named certificate or its CA
+	// expires within spec.tls.renewBefore.
+	ConditionCertificatesValid = "CertificatesValid"
+	// ConditionAdminPasswordSynced is false while the admin password in
+	// MarkLogic differs from the one in the auth secret.
+	ConditionAdminPasswordSynced = "AdminPasswordSynced"
+	// ConditionBootstrapped is true when every host completed its bootstrap.
+	// Its message names the hosts still in progress and the step they are at.
+	ConditionBootstrapped = "Bootstrapped"
+	// ConditionCredentialsAvailable is false while the operator cannot read
+	// the admin credentials of a cluster using the File provider. The pods
+	// run, but the resources the operator configures in MarkLogic wait.
+	ConditionCredentialsAvailable = "CredentialsAvailable"
+	// ConditionScaleDownBlocked is true while forests the operator does not
+	// delete keep hosts from leaving. Its message names them.
+	ConditionScaleDownBlocked = "ScaleDownBlocked"
+)
+
+// Reasons of the Events the agent records on a MarkLogic pod and on its
+// MarkLogicCluster for the lifecycle transitions of the host. They do not
+// change between releases, alerts may select Events by them.
+const (
+	// EventHostInitialized is recorded when MarkLogic is initialized on the host.
+	EventHostInitialized = "HostInitialized"
+	// EventSecurityInstalled is recorded when the bootstrap host installed the Security database.
+	EventSecurityInstalled = "SecurityInstalled"
+	// EventGroupConfigured is recorded when the group of the host is created or updated.
+	EventGroupConfigured = "GroupConfigured"
+	// EventGroupConfigurationFailed is a warning recorded when the group of
+	// the host could not be created or updated. The bootstrap goes on.
+	EventGroupConfigurationFailed = "GroupConfigurationFailed"
+	// EventHostJoined is recorded when the host joined the cluster of the bootstrap host.
+	EventHostJoined = "HostJoined"
+	// EventTLSEnabled is recorded when the default App Servers of the host serve https.
+	EventTLSEnabled = "TLSEnabled"
+	// EventHostRestarted is recorded when MarkLogic restarted after a configuration change.
+	EventHostRestarted = "HostRestarted"
+	// EventGroupRestarting is recorded when a group change restarts all hosts of the group.
+	EventGroupRestarting = "GroupRestarting"
+	// EventBootstrapCompleted is recorded when the host completed its bootstrap.
+	EventBootstrapCompleted = "BootstrapCompleted"
+	// EventBootstrapFailed is a warning recorded when a bootstrap step failed.
+	EventBootstrapFailed = "BootstrapFailed"
+	// EventForestsFailedOver is recorded when the replicas of the forests of a
+	// stopping host took over.
+	EventForestsFailedOver = "ForestsFailedOver"
+	// EventForestsUnavailable is a warning recorded when forests of a stopping
+	// host have no replica, or one that did not take over in time.
+	EventForestsUnavailable = "ForestsUnavailable"
+	// EventHostShutDown is recorded when MarkLogic stopped before the pod.
+	EventHostShutDown = "HostShutDown"
+	// EventDrainFailed is a warning recorded when the host could not be shut down.
+	EventDrainFailed = "DrainFailed"
+)
+
+// MarkLogicClusterStatus defines the observed state of MarkLogicCluster
+type MarkLogicClusterStatus struct {
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Number of pods created by the StatefulSet
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// Number of pods with a Ready condition
+	// +optional
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+
+	// Label selector of the MarkLogic pods, used by the scale subresource
+	// +optional
+	Selector string `json:"selector,omitempty"`
+
+	// Fully qualified name of the bootstrap host
+	// +optional
+	BootstrapHost string `json:"bootstrapHost,omitempty"`
+
+	// Progress of the last managed upgrade
+	// +optional
+	Upgrade *UpgradeStatus `json:"upgrade,omitempty"`
+
+	// Progress of the last managed scale down
+	// +optional
+	ScaleDown *ScaleDownStatus `json:"scaleDown,omitempty"`
+
+	// Named certificates of the hosts
+	// +optional
+	Certificates *CertificatesStatus `json:"certificates,omitempty"`
+
+	// Rotation of the admin password
+	// +optional
+	AdminPassword *AdminPasswordStatus `json:"adminPassword,omitempty"`
+
+	// Bootstrap progress of the hosts, by ordinal. Reported by the agent.
+	// +optional
+	Bootstrap []HostBootstrapStatus `json:"bootstrap,omitempty"`
+
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// UpgradePhase is the phase of a managed upgrade.
+// +kubebuilder:validation:Enum=Progressing;Paused;Completed
+type UpgradePhase string
+
+const (
+	// UpgradeProgressing is an upgrade replacing pods.
+	UpgradeProgressing UpgradePhase = "Progressing"
+	// UpgradePaused is an upgrade stopped by spec.updateStrategy.paused or by a
+	// host that failed its health gate.
+	UpgradePaused UpgradePhase = "Paused"
+	// UpgradeCompleted is an upgrade whose pods all run the new revision.
+	UpgradeCompleted UpgradePhase = "Completed"
+)
+
+// UpgradeStatus is the progress of a managed upgrade of the MarkLogic pods.
+type UpgradeStatus struct {
+	Phase UpgradePhase `json:"phase"`
+
+	// StatefulSet revision the pods are upgraded to
+	Revision string `json:"revision"`
+
+	// MarkLogic image before the upgrade
+	// +optional
+	FromImage string `json:"fromImage,omitempty"`
+
+	// MarkLogic image after the upgrade
+	// +optional
+	ToImage string `json:"toImage,omitempty"`
+
+	// Pod being replaced
+	// +optional
+	CurrentPod string `json:"currentPod,omitempty"`
+
+	// When the current pod was deleted, or its host asked to restart
+	// +optional
+	CurrentPodSince *metav1.Time `json:"currentPodSince,omitempty"`
+
+	// Startup timestamp of the host of the current pod before it was replaced
+	// +optional
+	LastStartup string `json:"lastStartup,omitempty"`
+
+	// Whether the Security database was upgraded after the bootstrap host
+	// +optional
+	SecurityUpgraded bool `json:"securityUpgraded,omitempty"`
+
+	// Pods upgraded so far
+	// +optional
+	UpgradedPods []string `json:"upgradedPods,omitempty"`
+
+	// Generation of the cluster when a failing host paused the upgrade. The
+	// upgrade resumes once the spec changes.
+	// +optional
+	PausedGeneration int64 `json:"pausedGeneration,omitempty"`
+
+	// Reason of the pause
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// ScaleDownPhase is the phase of a managed scale down.
+// +kubebuilder:validation:Enum=Evacuating;Shrinking;Completed;Failed
+type ScaleDownPhase string
+
+const (
+	// ScaleDownEvacuating is a scale down moving forests off the leaving hosts
+	// and removing them from the MarkLogic cluster.
+	ScaleDownEvacuating ScaleDownPhase = "Evacuating"
+	// ScaleDownShrinking is a scale down whose hosts left the MarkLogic
+	// cluster, waiting for their pods to go away.
+	ScaleDownShrinking ScaleDownPhase = "Shrinking"
+	// ScaleDownCompleted is a scale down whose pods are gone.
+	ScaleDownCompleted ScaleDownPhase = "Completed"
+	// ScaleDownFailed is a scale down MarkLogic refused a step of. It is
+	// retried while replicaCount stays lowered.
+	ScaleDownFailed ScaleDownPhase = "Failed"
+)
+
+// LeavingHostPhase is the phase of a host leaving the cluster.
+// +kubebuilder:validation:Enum=Evacuating;Removing;Removed
+type LeavingHostPhase string
+
+const (
+	// HostEvacuating is a host whose forests are retired, emptied and deleted.
+	HostEvacuating LeavingHostPhase = "Evacuating"
+	// HostRemoving is a host without forests waiting for the hosts above it
+	// to be removed first.
+	HostRemoving LeavingHostPhase = "Removing"
+	// HostRemoved is a host removed from the MarkLogic cluster. Its pod can go.
+	HostRemoved LeavingHostPhase = "Removed"
+)
+
+// LeavingHostStatus is the progress of a host leaving the cluster.
+type LeavingHostStatus struct {
+	Pod string `json:"pod"`
+
+	Phase LeavingHostPhase `json:"phase"`
+
+	// Forests left on the host
+	// +optional
+	Forests []string `json:"forests,omitempty"`
+
+	// Documents left in the retired forests of the host
+	// +optional
+	Documents int64 `json:"documents,omitempty"`
+
+	// Local-disk replica forests of the host detached from their master
+	// forest before they were deleted
+	// +optional
+	DetachedReplicas []string `json:"detachedReplicas,omitempty"`
+
+	// Forests of the host attached to no database that are not a
+	// synchronized local-disk replica, e.g. a replica acting as master after
+	// a failover. They are not deleted, the host stays until they are moved
+	// or deleted.
+	// +optional
+	BlockingForests []string `json:"blockingForests,omitempty"`
+}
+
+// ScaleDownStatus is the progress of a managed scale down.
+type ScaleDownStatus struct {
+	Phase ScaleDownPhase `json:"phase"`
+
+	// Number of pods before the scale down
+	FromReplicas int32 `json:"fromReplicas"`
+
+	// Number of pods after the scale down
+	ToReplicas int32 `json:"toReplicas"`
+
+	// Hosts leaving the cluster, highest ordinal first
+	// +optional
+	Hosts []LeavingHostStatus `json:"hosts,omitempty"`
+
+	// Reason of the failure of the last step
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// HostCertificateStatus is the named certificate of a host.
+type HostCertificateStatus struct {
+	Pod string `json:"pod"`
+
+	// Secret holding the certificate
+	Secret string `json:"secret"`
+
+	// SHA-256 fingerprint of the certificate in the secret
+	Fingerprint string `json:"fingerprint"`
+
+	// Expiry of the certificate in the secret
+	NotAfter metav1.Time `json:"notAfter"`
+
+	// SHA-256 fingerprint of the certificate last inserted into MarkLogic.
+	// The certificate first seen is the one inserted by the bootstrap of the host.
+	// +optional
+	InsertedFingerprint string `json:"insertedFingerprint,omitempty"`
+
+	// When the operator last inserted the certificate
+	// +optional
+	InsertTime *metav1.Time `json:"insertTime,omitempty"`
+}
+
+// CertificatesStatus is the state of the named certificates of the cluster.
+type CertificatesStatus struct {
+	// SHA-256 fingerprint of the CA certificate in caSecretName
+	// +optional
+	CAFingerprint string `json:"caFingerprint,omitempty"`
+
+	// Expiry of the CA certificate
+	// +optional
+	CANotAfter *metav1.Time `json:"caNotAfter,omitempty"`
+
+	// SHA-256 fingerprint of the CA certificate last trusted in MarkLogic
+	// +optional
+	InsertedCAFingerprint string `json:"insertedCAFingerprint,omitempty"`
+
+	// Certificates of the hosts, by ordinal
+	// +optional
+	Hosts []HostCertificateStatus `json:"hosts,omitempty"`
+}
+
+// AdminPasswordStatus is the state of the admin password rotation.
+type AdminPasswordStatus struct {
+	// Last time the admin password was changed in MarkLogic
+	// +optional
+	RotationTime *metav1.Time `json:"rotationTime,omitempty"`
+
+	// Resource version of the auth secret whose password was rolled back
+	// because a host did not accept it. It is retried once the secret changes.
+	// +optional
+	RolledBackVersion string `json:"rolledBackVersion,omitempty"`
+}
+
+// BootstrapPhase is the last bootstrap step a host completed.
+// +kubebuilder:validation:Enum=Pending;Initialized;SecurityInstalled;GroupConfigured;Joined;TLSConfigured;Completed
+type BootstrapPhase string
+
+const (
+	// BootstrapPending is a host that completed no step yet.
+	BootstrapPending BootstrapPhase = "Pending"
+	// BootstrapInitialized is a host whose MarkLogic server is initialized.
+	BootstrapInitialized BootstrapPhase = "Initialized"
+	// BootstrapSecurityInstalled is a bootstrap host with an initialized
+	// Security database.
+	BootstrapSecurityInstalled BootstrapPhase = "SecurityInstalled"
+	// BootstrapGroupConfigured is a host whose group is created and configured.
+	BootstrapGroupConfigured BootstrapPhase = "GroupConfigured"
+	// BootstrapJoined is a host that joined the cluster of the bootstrap host.
+	BootstrapJoined BootstrapPhase = "Joined"
+	// BootstrapTLSConfigured is a host serving the default app servers over https.
+	BootstrapTLSConfigured BootstrapPhase = "TLSConfigured"
+	// BootstrapCompleted is a host that completed its bootstrap.
+	BootstrapCompleted BootstrapPhase = "Completed"
+)
+
+// HostBootstrapStatus is the bootstrap progress of a host.
+type HostBootstrapStatus struct {
+	Pod string `json:"pod"`
+
+	Phase BootstrapPhase `json:"phase"`
+
+	// Reason of the failure of the step after the phase
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// When the phase was reached or the step failed
+	// +optional
+	LastTransitionTime *metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:subresource:scale:specpath=.spec.replicaCount,statuspath=.status.replicas,selectorpath=.status.selector
+// +kubebuilder:resource:shortName=mlc
+// +kubebuilder:printcolumn:name="Replicas",type=integer,JSONPath=`.spec.replicaCount`
+// +kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=`.status.readyReplicas`
+// +kubebuilder:printcolumn:name="Group",type=string,JSONPath=`.spec.group.name`
+// +kubebuilder:printcolumn:name="Bootstrap",type=string,JSONPath=`.status.conditions[?(@.type=="Bootstrapped")].message`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// MarkLogicCluster is the Schema for the marklogicclusters API
+type MarkLogicCluster struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MarkLogicClusterSpec   `json:"spec,omitempty"`
+	Status MarkLogicClusterStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// MarkLogicClusterList contains a list of MarkLogicCluster
+type MarkLogicClusterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MarkLogicCluster `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MarkLogicCluster{}, &MarkLogicClusterList{})
+}
@@ -0,0 +1,54 @@
+package v1alpha1
+
+// codepointCollation is the collation MarkLogic uses for string indexes by default.
+const codepointCollation = "http://marklogic.com/collation/codepoint"
+
+// Default fills the unset fields of the database with the defaults of the CRD schema.
+func (d *MarkLogicDatabase) Default() {
+	s := &d.Spec
+	if s.DatabaseName == "" {
+		s.DatabaseName = d.Name
+	}
+	if s.Enabled == nil {
+		s.Enabled = boolPtr(true)
+	}
+	if s.SecurityDatabase == "" {
+		s.SecurityDatabase = "Security"
+	}
+	if s.SchemaDatabase == "" {
+		s.SchemaDatabase = "Schemas"
+	}
+	if s.TriggersDatabase == "" {
+		s.TriggersDatabase = "Triggers"
+	}
+	for i := range s.RangeElementIndexes {
+		idx := &s.RangeElementIndexes[i]
+		idx.Collation = defaultCollation(idx.ScalarType, idx.Collation)
+		if idx.InvalidValues == "" {
+			idx.InvalidValues = "reject"
+		}
+	}
+	for i := range s.RangePathIndexes {
+		idx := &s.RangePathIndexes[i]
+		idx.Collation = defaultCollation(idx.ScalarType, idx.Collation)
+		if idx.InvalidValues == "" {
+			idx.InvalidValues = "reject"
+		}
+	}
+	if s.Forests.PerHost == nil {
+		s.Forests.PerHost = int32Ptr(1)
+	}
+	if s.DriftPolicy == "" {
+		s.DriftPolicy = DriftPolicyCorrect
+	}
+	if s.DeletionPolicy == "" {
+		s.DeletionPolicy = DeletionPolicyRetain
+	}
+}
+
+func defaultCollation(scalarType, collation string) string {
+	if collation == "" && scalarType == "string" {
+		return codepointCollation
+	}
+	return collation
+}
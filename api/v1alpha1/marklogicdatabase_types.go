@@ -0,0 +1,183 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// MarkLogicDatabaseSpec defines the desired state of MarkLogicDatabase.
+// Field names follow the database properties of the Management API.
+type MarkLogicDatabaseSpec struct {
+	// The MarkLogicCluster hosting the database
+	ClusterRef ClusterReference `json:"clusterRef"`
+
+	// Name of the database in MarkLogic. Defaults to the name of the resource.
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="databaseName is immutable"
+	// +optional
+	DatabaseName string `json:"databaseName,omitempty"`
+
+	// +kubebuilder:default=true
+	// +optional
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// +kubebuilder:default=Security
+	// +optional
+	SecurityDatabase string `json:"securityDatabase,omitempty"`
+
+	// +kubebuilder:default=Schemas
+	// +optional
+	SchemaDatabase string `json:"schemaDatabase,omitempty"`
+
+	// +kubebuilder:default=Triggers
+	// +optional
+	TriggersDatabase string `json:"triggersDatabase,omitempty"`
+
+	// Default language of the content
+	// +optional
+	Language string `json:"language,omitempty"`
+
+	// Range element indexes of the database
+	// +optional
+	RangeElementIndexes []RangeElementIndex `json:"rangeElementIndexes,omitempty"`
+
+	// Range path indexes of the database
+	// +optional
+	RangePathIndexes []RangePathIndex `json:"rangePathIndexes,omitempty"`
+
+	// Further database properties in the JSON format of
+	// PUT /manage/v2/databases/{name}/properties, for example
+	// {"triple-index": true}. The fields above take precedence.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +optional
+	Properties *runtime.RawExtension `json:"properties,omitempty"`
+
+	// Forests to create for the database
+	// +kubebuilder:default={}
+	// +optional
+	Forests Forests `json:"forests,omitempty"`
+
+	// Whether changes made to the database outside of the operator are reverted or only reported
+	// +kubebuilder:default=Correct
+	// +optional
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+
+	// Whether the database and its forests are deleted from MarkLogic with the resource
+	// +kubebuilder:default=Retain
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// RangeElementIndex is a range element index of a database.
+type RangeElementIndex struct {
+	// +kubebuilder:validation:Enum=int;unsignedInt;long;unsignedLong;float;double;decimal;dateTime;time;date;gYearMonth;gYear;gMonth;gDay;yearMonthDuration;dayTimeDuration;string;anyURI;point;longLatPoint
+	ScalarType string `json:"scalarType"`
+	// +optional
+	NamespaceURI string `json:"namespaceURI,omitempty"`
+	Localname    string `json:"localname"`
+	// Collation of string indexes. Defaults to the codepoint collation for strings.
+	// +optional
+	Collation string `json:"collation,omitempty"`
+	// +optional
+	RangeValuePositions bool `json:"rangeValuePositions,omitempty"`
+	// +kubebuilder:validation:Enum=reject;ignore
+	// +kubebuilder:default=reject
+	// +optional
+	InvalidValues string `json:"invalidValues,omitempty"`
+}
+
+// RangePathIndex is a range path index of a database.
+type RangePathIndex struct {
+	// +kubebuilder:validation:Enum=int;unsignedInt;long;unsignedLong;float;double;decimal;dateTime;time;date;gYearMonth;gYear;gMonth;gDay;yearMonthDuration;dayTimeDuration;string;anyURI;point;longLatPoint
+	ScalarType     string `json:"scalarType"`
+	PathExpression string `json:"pathExpression"`
+	// Collation of string indexes. Defaults to the codepoint collation for strings.
+	// +optional
+	Collation string `json:"collation,omitempty"`
+	// +optional
+	RangeValuePositions bool `json:"rangeValuePositions,omitempty"`
+	// +kubebuilder:validation:Enum=reject;ignore
+	// +kubebuilder:default=reject
+	// +optional
+	InvalidValues string `json:"invalidValues,omitempty"`
+}
+
+// Forests configures the forests of a database. Forests are spread over the
+// pods of the cluster by ordinal and named <database>-<ordinal>-<n>.
+type Forests struct {
+	// Number of forests on each host
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	PerHost *int32 `json:"perHost,omitempty"`
+
+	// Data directory of the forests. Defaults to the MarkLogic data directory.
+	// +optional
+	DataDirectory string `json:"dataDirectory,omitempty"`
+}
+
+// ForestStatus is the observed state of a forest of the database.
+type ForestStatus struct {
+	Name string `json:"name"`
+	Host string `json:"host"`
+	// Whether the forest is attached to the database
+	Attached bool `json:"attached"`
+}
+
+// MarkLogicDatabaseStatus defines the observed state of MarkLogicDatabase
+type MarkLogicDatabaseStatus struct {
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Name of the database in MarkLogic
+	// +optional
+	DatabaseName string `json:"databaseName,omitempty"`
+
+	// Forests of the database managed by the operator
+	// +optional
+	Forests []ForestStatus `json:"forests,omitempty"`
+
+	// Database properties that differed from the spec at the last check
+	// +optional
+	Drift []string `json:"drift,omitempty"`
+
+	// Last time the database properties were compared against the spec
+	// +optional
+	LastDriftCheck *metav1.Time `json:"lastDriftCheck,omitempty"`
+
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=mldb
+// +kubebuilder:printcolumn:name="Cluster",type=string,JSONPath=`.spec.clusterRef.name`
+// +kubebuilder:printcolumn:name="Database",type=string,JSONPath=`.status.databaseName`
+// +kubebuilder:printcolumn:name="In Sync",type=string,JSONPath=`.status.conditions[?(@.type=="InSync")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// MarkLogicDatabase is the Schema for the marklogicdatabases API
+type MarkLogicDatabase struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MarkLogicDatabaseSpec   `json:"spec,omitempty"`
+	Status MarkLogicDatabaseStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// MarkLogicDatabaseList contains a list of MarkLogicDatabase
+type MarkLogicDatabaseList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MarkLogicDatabase `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MarkLogicDatabase{}, &MarkLogicDatabaseList{})
+}
@@ -0,0 +1,34 @@
+package v1alpha1
+
+// Default fills the unset fields of the external security configuration with
+// the defaults of the CRD schema. The group of the App Servers defaults to the
+// group of the cluster, which the resource does not know.
+func (s *MarkLogicExternalSecurity) Default() {
+	spec := &s.Spec
+	if spec.ExternalSecurityName == "" {
+		spec.ExternalSecurityName = s.Name
+	}
+	if spec.Authentication == "" {
+		spec.Authentication = "ldap"
+	}
+	if spec.Authorization == "" {
+		spec.Authorization = "internal"
+	}
+	if spec.CacheTimeout == nil {
+		spec.CacheTimeout = int32Ptr(300)
+	}
+	if ldap := spec.LDAP; ldap != nil {
+		if ldap.Attribute == "" {
+			ldap.Attribute = "uid"
+		}
+		if ldap.BindMethod == "" {
+			ldap.BindMethod = "simple"
+		}
+	}
+	if spec.DriftPolicy == "" {
+		spec.DriftPolicy = DriftPolicyCorrect
+	}
+	if spec.DeletionPolicy == "" {
+		spec.DeletionPolicy = DeletionPolicyRetain
+	}
+}
@@ -0,0 +1,180 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// MarkLogicExternalSecuritySpec defines the desired state of
+// MarkLogicExternalSecurity. Field names follow the external security
+// properties of the Management API.
+type MarkLogicExternalSecuritySpec struct {
+	// The MarkLogicCluster the external security configuration belongs to
+	ClusterRef ClusterReference `json:"clusterRef"`
+
+	// Name of the external security configuration in MarkLogic. Defaults to the name of the resource.
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="externalSecurityName is immutable"
+	// +optional
+	ExternalSecurityName string `json:"externalSecurityName,omitempty"`
+
+	// +optional
+	Description string `json:"description,omitempty"`
+
+	// How users are authenticated. Kerberos needs the keytab of the cluster
+	// in the data directory of every host.
+	// +kubebuilder:validation:Enum=ldap;kerberos;certificate;saml;oauth
+	// +kubebuilder:default=ldap
+	// +optional
+	Authentication string `json:"authentication,omitempty"`
+
+	// Where the roles of users are looked up
+	// +kubebuilder:validation:Enum=internal;ldap;certificate;saml;oauth
+	// +kubebuilder:default=internal
+	// +optional
+	Authorization string `json:"authorization,omitempty"`
+
+	// Seconds a login is cached before the external server is asked again
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=300
+	// +optional
+	CacheTimeout *int32 `json:"cacheTimeout,omitempty"`
+
+	// LDAP server used for ldap authentication or authorization
+	// +optional
+	LDAP *ExternalSecurityLDAP `json:"ldap,omitempty"`
+
+	// Further external security properties in the JSON format of
+	// PUT /manage/v2/external-security/{name}/properties. The fields above take precedence.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +optional
+	Properties *runtime.RawExtension `json:"properties,omitempty"`
+
+	// App Servers using the external security configuration, which is added
+	// to their external-security list
+	// +optional
+	AppServers []ExternalSecurityAppServer `json:"appServers,omitempty"`
+
+	// Whether changes made to the configuration outside of the operator are reverted or only reported
+	// +kubebuilder:default=Correct
+	// +optional
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+
+	// Whether the configuration is deleted from MarkLogic with the resource
+	// +kubebuilder:default=Retain
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// ExternalSecurityLDAP configures the LDAP server of an external security
+// configuration.
+type ExternalSecurityLDAP struct {
+	// URI of the LDAP server, e.g. ldaps://ldap.example.com:636
+	// +kubebuilder:validation:Pattern=`^ldaps?://`
+	ServerURI string `json:"serverURI"`
+
+	// Base DN of the users, e.g. dc=example,dc=com
+	// +optional
+	Base string `json:"base,omitempty"`
+
+	// Attribute matched against the user name
+	// +kubebuilder:default=uid
+	// +optional
+	Attribute string `json:"attribute,omitempty"`
+
+	// Secret with the bind DN as username and its password as password.
+	// The server is searched anonymously if empty.
+	// +optional
+	BindSecretName string `json:"bindSecretName,omitempty"`
+
+	// +kubebuilder:validation:Enum=simple;MD5
+	// +kubebuilder:default=simple
+	// +optional
+	BindMethod string `json:"bindMethod,omitempty"`
+
+	// Attribute of the user listing its groups, used for ldap authorization
+	// +optional
+	MemberOfAttribute string `json:"memberOfAttribute,omitempty"`
+
+	// Attribute of the group listing its members, used for ldap authorization
+	// +optional
+	MemberAttribute string `json:"memberAttribute,omitempty"`
+
+	// Whether ldap:// connections are upgraded with StartTLS
+	// +optional
+	StartTLS bool `json:"startTLS,omitempty"`
+}
+
+// ExternalSecurityAppServer names an App Server of the cluster.
+type ExternalSecurityAppServer struct {
+	// Name of the App Server in MarkLogic
+	// +kubebuilder:validation:MinLength=1
+	ServerName string `json:"serverName"`
+
+	// Group of the App Server. Defaults to the group of the cluster.
+	// +optional
+	GroupName string `json:"groupName,omitempty"`
+}
+
+// MarkLogicExternalSecurityStatus defines the observed state of MarkLogicExternalSecurity
+type MarkLogicExternalSecurityStatus struct {
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Name of the external security configuration in MarkLogic
+	// +optional
+	ExternalSecurityName string `json:"externalSecurityName,omitempty"`
+
+	// App Servers the configuration was assigned to
+	// +optional
+	AppServers []ExternalSecurityAppServer `json:"appServers,omitempty"`
+
+	// Resource version of the bind secret whose password was last set
+	// +optional
+	BindSecretVersion string `json:"bindSecretVersion,omitempty"`
+
+	// Properties that differed from the spec at the last check
+	// +optional
+	Drift []string `json:"drift,omitempty"`
+
+	// Last time the properties were compared against the spec
+	// +optional
+	LastDriftCheck *metav1.Time `json:"lastDriftCheck,omitempty"`
+
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=mlexsec
+// +kubebuilder:printcolumn:name="Cluster",type=string,JSONPath=`.spec.clusterRef.name`
+// +kubebuilder:printcolumn:name="Authentication",type=string,JSONPath=`.spec.authentication`
+// +kubebuilder:printcolumn:name="Authorization",type=string,JSONPath=`.spec.authorization`
+// +kubebuilder:printcolumn:name="In Sync",type=string,JSONPath=`.status.conditions[?(@.type=="InSync")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// MarkLogicExternalSecurity is the Schema for the marklogicexternalsecurities API
+type MarkLogicExternalSecurity struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MarkLogicExternalSecuritySpec   `json:"spec,omitempty"`
+	Status MarkLogicExternalSecurityStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// MarkLogicExternalSecurityList contains a list of MarkLogicExternalSecurity
+type MarkLogicExternalSecurityList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MarkLogicExternalSecurity `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MarkLogicExternalSecurity{}, &MarkLogicExternalSecurityList{})
+}
@@ -0,0 +1,15 @@
+package v1alpha1
+
+// Default fills the unset fields of the restore with the defaults of the CRD schema.
+func (r *MarkLogicRestore) Default() {
+	s := &r.Spec
+	if s.IncludeReplicas == nil {
+		s.IncludeReplicas = boolPtr(true)
+	}
+	if s.IncrementalDir == "" {
+		s.IncrementalDir = s.BackupDir
+	}
+	if s.BlockTraffic == nil {
+		s.BlockTraffic = boolPtr(true)
+	}
+}
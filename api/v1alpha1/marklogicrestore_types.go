@@ -0,0 +1,177 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RestorePhase is the lifecycle phase of a MarkLogicRestore.
+// +kubebuilder:validation:Enum=Pending;Validating;Restoring;Completed;Failed
+type RestorePhase string
+
+const (
+	// RestorePending is a restore waiting for its backup or cluster.
+	RestorePending RestorePhase = "Pending"
+	// RestoreValidating is a restore whose backup is being validated.
+	RestoreValidating RestorePhase = "Validating"
+	// RestoreRestoring is a restore job in progress in MarkLogic.
+	RestoreRestoring RestorePhase = "Restoring"
+	// RestoreCompleted is a restore that finished successfully.
+	RestoreCompleted RestorePhase = "Completed"
+	// RestoreFailed is a restore whose backup failed validation, or whose job
+	// MarkLogic refused, failed or cancelled.
+	RestoreFailed RestorePhase = "Failed"
+)
+
+// AnnotationBlockedByRestore is set on the MarkLogicAppServers whose traffic a
+// MarkLogicRestore blocks, to the name of the restore. The services and
+// HAProxy of the cluster do not route to them while it is set.
+const AnnotationBlockedByRestore = "marklogic.com/blocked-by-restore"
+
+// BackupReference names a MarkLogicBackup in the namespace of the restore.
+type BackupReference struct {
+	// Name of the MarkLogicBackup
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+}
+
+// MarkLogicRestoreSpec defines the desired state of MarkLogicRestore. A
+// restore runs once; its spec can not be changed.
+// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="spec is immutable"
+// +kubebuilder:validation:XValidation:rule="has(self.backupRef) != has(self.backupDir)",message="exactly one of backupRef and backupDir is required"
+// +kubebuilder:validation:XValidation:rule="has(self.backupRef) || has(self.database)",message="database is required with backupDir"
+type MarkLogicRestoreSpec struct {
+	// The MarkLogicCluster holding the database
+	ClusterRef ClusterReference `json:"clusterRef"`
+
+	// Database to restore. Defaults to the database of the referenced backup.
+	// +optional
+	Database string `json:"database,omitempty"`
+
+	// MarkLogicBackup to restore. It has to be completed; an incremental
+	// backup is restored together with the full backup it was taken on.
+	// +optional
+	BackupRef *BackupReference `json:"backupRef,omitempty"`
+
+	// Directory holding the backup to restore, for backups not taken by a MarkLogicBackup
+	// +optional
+	BackupDir string `json:"backupDir,omitempty"`
+
+	// Restore from the incremental backups in incrementalDir on top of the full backup
+	// in backupDir. Ignored with backupRef.
+	// +optional
+	Incremental bool `json:"incremental,omitempty"`
+
+	// Directory of the incremental backups. Defaults to backupDir. Ignored with backupRef.
+	// +optional
+	IncrementalDir string `json:"incrementalDir,omitempty"`
+
+	// Whether the replica forests of the database are restored too
+	// +kubebuilder:default=true
+	// +optional
+	IncludeReplicas *bool `json:"includeReplicas,omitempty"`
+
+	// Restore the database to its state at this time instead of the time of
+	// the backup. Requires journal archiving to be enabled on the database.
+	// +optional
+	RestoreToTime *metav1.Time `json:"restoreToTime,omitempty"`
+
+	// Whether the MarkLogicAppServers using the database as content or modules
+	// database are taken off the services and HAProxy of the cluster during the restore
+	// +kubebuilder:default=true
+	// +optional
+	BlockTraffic *bool `json:"blockTraffic,omitempty"`
+}
+
+// HostRestoreStatus is the progress of a restore on one host.
+type HostRestoreStatus struct {
+	Host string `json:"host"`
+
+	// Status of the restore on the host: in-progress, completed or failed
+	Status string `json:"status"`
+
+	// Number of forests of the host restored so far
+	CompletedForests int32 `json:"completedForests"`
+
+	// Number of forests restored on the host
+	Forests int32 `json:"forests"`
+}
+
+// MarkLogicRestoreStatus defines the observed state of MarkLogicRestore
+type MarkLogicRestoreStatus struct {
+	// +optional
+	Phase RestorePhase `json:"phase,omitempty"`
+
+	// Database being restored
+	// +optional
+	Database string `json:"database,omitempty"`
+
+	// Directory the backup is restored from
+	// +optional
+	BackupDir string `json:"backupDir,omitempty"`
+
+	// When the backup passed validation. It is not validated again while the
+	// restore waits for its traffic to be blocked.
+	// +optional
+	ValidationTime *metav1.Time `json:"validationTime,omitempty"`
+
+	// ID of the restore job in MarkLogic
+	// +optional
+	JobID string `json:"jobID,omitempty"`
+
+	// Host running the restore job
+	// +optional
+	HostName string `json:"hostName,omitempty"`
+
+	// Progress of the restore per host
+	// +optional
+	Hosts []HostRestoreStatus `json:"hosts,omitempty"`
+
+	// MarkLogicAppServers whose traffic is blocked during the restore
+	// +optional
+	BlockedAppServers []string `json:"blockedAppServers,omitempty"`
+
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Reason of the failure of the restore
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=mlrestore
+// +kubebuilder:printcolumn:name="Cluster",type=string,JSONPath=`.spec.clusterRef.name`
+// +kubebuilder:printcolumn:name="Database",type=string,JSONPath=`.status.database`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// MarkLogicRestore is the Schema for the marklogicrestores API
+type MarkLogicRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MarkLogicRestoreSpec   `json:"spec,omitempty"`
+	Status MarkLogicRestoreStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// MarkLogicRestoreList contains a list of MarkLogicRestore
+type MarkLogicRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MarkLogicRestore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MarkLogicRestore{}, &MarkLogicRestoreList{})
+}
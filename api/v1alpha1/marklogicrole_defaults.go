@@ -0,0 +1,20 @@
+package v1alpha1
+
+// Default fills the unset fields of the role with the defaults of the CRD schema.
+func (r *MarkLogicRole) Default() {
+	spec := &r.Spec
+	if spec.RoleName == "" {
+		spec.RoleName = r.Name
+	}
+	for i := range spec.Privileges {
+		if spec.Privileges[i].Kind == "" {
+			spec.Privileges[i].Kind = "execute"
+		}
+	}
+	if spec.DriftPolicy == "" {
+		spec.DriftPolicy = DriftPolicyCorrect
+	}
+	if spec.DeletionPolicy == "" {
+		spec.DeletionPolicy = DeletionPolicyRetain
+	}
+}
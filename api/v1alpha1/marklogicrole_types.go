@@ -0,0 +1,132 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// MarkLogicRoleSpec defines the desired state of MarkLogicRole.
+// Field names follow the role properties of the Management API.
+type MarkLogicRoleSpec struct {
+	// The MarkLogicCluster the role belongs to
+	ClusterRef ClusterReference `json:"clusterRef"`
+
+	// Name of the role in MarkLogic. Defaults to the name of the resource.
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="roleName is immutable"
+	// +optional
+	RoleName string `json:"roleName,omitempty"`
+
+	// +optional
+	Description string `json:"description,omitempty"`
+
+	// Roles whose privileges and permissions the role inherits
+	// +optional
+	Roles []string `json:"roles,omitempty"`
+
+	// Execute and URI privileges granted to the role
+	// +optional
+	Privileges []RolePrivilege `json:"privileges,omitempty"`
+
+	// Default permissions of the documents created by users with the role
+	// +optional
+	Permissions []Permission `json:"permissions,omitempty"`
+
+	// Default collections of the documents created by users with the role
+	// +optional
+	Collections []string `json:"collections,omitempty"`
+
+	// Compartment of the role, which can only be set when it is created
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="compartment is immutable"
+	// +optional
+	Compartment string `json:"compartment,omitempty"`
+
+	// External names, such as LDAP group DNs, mapped to the role
+	// +optional
+	ExternalNames []string `json:"externalNames,omitempty"`
+
+	// Further role properties in the JSON format of
+	// PUT /manage/v2/roles/{name}/properties. The fields above take precedence.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +optional
+	Properties *runtime.RawExtension `json:"properties,omitempty"`
+
+	// Whether changes made to the role outside of the operator are reverted or only reported
+	// +kubebuilder:default=Correct
+	// +optional
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+
+	// Whether the role is deleted from MarkLogic with the resource
+	// +kubebuilder:default=Retain
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// RolePrivilege names a privilege of the security database.
+type RolePrivilege struct {
+	// +kubebuilder:validation:MinLength=1
+	PrivilegeName string `json:"privilegeName"`
+
+	// Action of the privilege, e.g. http://marklogic.com/xdmp/privileges/any-uri
+	// +kubebuilder:validation:MinLength=1
+	Action string `json:"action"`
+
+	// +kubebuilder:validation:Enum=execute;uri
+	// +kubebuilder:default=execute
+	// +optional
+	Kind string `json:"kind,omitempty"`
+}
+
+// MarkLogicRoleStatus defines the observed state of MarkLogicRole
+type MarkLogicRoleStatus struct {
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Name of the role in MarkLogic
+	// +optional
+	RoleName string `json:"roleName,omitempty"`
+
+	// Role properties that differed from the spec at the last check
+	// +optional
+	Drift []string `json:"drift,omitempty"`
+
+	// Last time the role properties were compared against the spec
+	// +optional
+	LastDriftCheck *metav1.Time `json:"lastDriftCheck,omitempty"`
+
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=mlrole
+// +kubebuilder:printcolumn:name="Cluster",type=string,JSONPath=`.spec.clusterRef.name`
+// +kubebuilder:printcolumn:name="Role",type=string,JSONPath=`.status.roleName`
+// +kubebuilder:printcolumn:name="In Sync",type=string,JSONPath=`.status.conditions[?(@.type=="InSync")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// MarkLogicRole is the Schema for the marklogicroles API
+type MarkLogicRole struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MarkLogicRoleSpec   `json:"spec,omitempty"`
+	Status MarkLogicRoleStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// MarkLogicRoleList contains a list of MarkLogicRole
+type MarkLogicRoleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MarkLogicRole `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MarkLogicRole{}, &MarkLogicRoleList{})
+}
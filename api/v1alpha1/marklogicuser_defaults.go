@@ -0,0 +1,15 @@
+package v1alpha1
+
+// Default fills the unset fields of the user with the defaults of the CRD schema.
+func (u *MarkLogicUser) Default() {
+	spec := &u.Spec
+	if spec.UserName == "" {
+		spec.UserName = u.Name
+	}
+	if spec.DriftPolicy == "" {
+		spec.DriftPolicy = DriftPolicyCorrect
+	}
+	if spec.DeletionPolicy == "" {
+		spec.DeletionPolicy = DeletionPolicyRetain
+	}
+}
@@ -0,0 +1,117 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// MarkLogicUserSpec defines the desired state of MarkLogicUser.
+// Field names follow the user properties of the Management API.
+type MarkLogicUserSpec struct {
+	// The MarkLogicCluster the user belongs to
+	ClusterRef ClusterReference `json:"clusterRef"`
+
+	// Name of the user in MarkLogic. Defaults to the name of the resource.
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="userName is immutable"
+	// +optional
+	UserName string `json:"userName,omitempty"`
+
+	// +optional
+	Description string `json:"description,omitempty"`
+
+	// Secret holding the password of the user as password. The password of
+	// the user is changed whenever the secret changes.
+	// +kubebuilder:validation:MinLength=1
+	PasswordSecretName string `json:"passwordSecretName"`
+
+	// Roles granted to the user
+	// +optional
+	Roles []string `json:"roles,omitempty"`
+
+	// Default permissions of the documents created by the user
+	// +optional
+	Permissions []Permission `json:"permissions,omitempty"`
+
+	// Default collections of the documents created by the user
+	// +optional
+	Collections []string `json:"collections,omitempty"`
+
+	// External names, such as LDAP DNs or Kerberos principals, mapped to the user
+	// +optional
+	ExternalNames []string `json:"externalNames,omitempty"`
+
+	// Further user properties in the JSON format of
+	// PUT /manage/v2/users/{name}/properties. The fields above take precedence.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +optional
+	Properties *runtime.RawExtension `json:"properties,omitempty"`
+
+	// Whether changes made to the user outside of the operator are reverted or only reported
+	// +kubebuilder:default=Correct
+	// +optional
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+
+	// Whether the user is deleted from MarkLogic with the resource
+	// +kubebuilder:default=Retain
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// MarkLogicUserStatus defines the observed state of MarkLogicUser
+type MarkLogicUserStatus struct {
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Name of the user in MarkLogic
+	// +optional
+	UserName string `json:"userName,omitempty"`
+
+	// Resource version of the password secret whose password was last set
+	// +optional
+	PasswordSecretVersion string `json:"passwordSecretVersion,omitempty"`
+
+	// User properties that differed from the spec at the last check
+	// +optional
+	Drift []string `json:"drift,omitempty"`
+
+	// Last time the user properties were compared against the spec
+	// +optional
+	LastDriftCheck *metav1.Time `json:"lastDriftCheck,omitempty"`
+
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=mluser
+// +kubebuilder:printcolumn:name="Cluster",type=string,JSONPath=`.spec.clusterRef.name`
+// +kubebuilder:printcolumn:name="User",type=string,JSONPath=`.status.userName`
+// +kubebuilder:printcolumn:name="In Sync",type=string,JSONPath=`.status.conditions[?(@.type=="InSync")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// MarkLogicUser is the Schema for the marklogicusers API
+type MarkLogicUser struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MarkLogicUserSpec   `json:"spec,omitempty"`
+	Status MarkLogicUserStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// MarkLogicUserList contains a list of MarkLogicUser
+type MarkLogicUserList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MarkLogicUser `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MarkLogicUser{}, &MarkLogicUserList{})
+}
@@ -0,0 +1,573 @@
+// Package main is the entrypoint of the MarkLogic agent, the binary that runs
+// inside MarkLogic pods to initialize hosts and join them to the cluster.
+//
+// Usage:
+//
+//	agent install <dir>   copy the agent binary into dir, run as init container
+//	agent copy-certs      select the named certificate of the host, run as init container
+//	agent credentials     write the admin credentials read from Vault, run as init container
+//	agent bootstrap       initialize the host, run as postStart hook
+//	agent drain           fail the forests over and shut the host down, run as preStop hook
+//	agent probe <mode>    check the health of the host, run as liveness, readiness or startup probe
+//	agent exporter        serve the metrics of the host to Prometheus, run as sidecar
+//	agent ship-logs       export the log files of the host to an OpenTelemetry collector, run as sidecar
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/marklogic/marklogic-kubernetes/internal/bootstrap"
+	"github.com/marklogic/marklogic-kubernetes/internal/certs"
+	"github.com/marklogic/marklogic-kubernetes/internal/credentials"
+	"github.com/marklogic/marklogic-kubernetes/internal/drain"
+	"github.com/marklogic/marklogic-kubernetes/internal/events"
+	"github.com/marklogic/marklogic-kubernetes/internal/exporter"
+	"github.com/marklogic/marklogic-kubernetes/internal/logship"
+	"github.com/marklogic/marklogic-kubernetes/internal/probe"
+)
+
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// markLogicProcess is the command line of the MarkLogic server process.
+const markLogicProcess = "/opt/MarkLogic/bin/MarkLogic"
+
+const usage = `Usage: agent <command> [flags]
+
+Commands:
+  install <dir>  copy the agent binary into dir
+  copy-certs     select the named certificate of the host and copy it for MarkLogic
+  credentials    read the admin credentials from Vault and write them for MarkLogic
+  bootstrap      initialize the MarkLogic host and join it to the cluster
+  drain          fail the forests of the host over to their replicas and shut it down
+  probe <mode>   check the liveness, readiness or startup of the MarkLogic host
+  exporter       serve the metrics of the MarkLogic host in Prometheus format
+  ship-logs      parse the MarkLogic log files and export them with OTLP
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "install":
+		err = install(os.Args[2:])
+	case "copy-certs":
+		err = runCopyCerts(os.Args[2:])
+	case "credentials":
+		err = runCredentials(os.Args[2:])
+	case "bootstrap":
+		err = runBootstrap(os.Args[2:])
+	case "drain":
+		err = runDrain(os.Args[2:])
+	case "probe":
+		err = runProbe(os.Args[2:])
+	case "exporter":
+		err = runExporter(os.Args[2:])
+	case "ship-logs":
+		err = runShipLogs(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+// install copies the running binary into the given directory, which is an
+// emptyDir shared with the MarkLogic container.
+func install(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("install takes the target directory as single argument")
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(self)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	target := filepath.Join(args[0], filepath.Base(self))
+	dst, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+func runCopyCerts(args []string) error {
+	fs := flag.NewFlagSet("copy-certs", flag.ExitOnError)
+	serverCertsDir := fs.String("server-certs-dir", certs.DefaultServerCertsDir, "Directory holding the tls_<n>.crt and tls_<n>.key named certificates.")
+	caCertDir := fs.String("ca-cert-dir", certs.DefaultCACertDir, "Directory holding cacert.pem, the CA of the named certificates.")
+	certsDir := fs.String("certs-dir", certs.DefaultCertsDir, "Directory receiving the certificate of the host.")
+	timeout := fs.Duration("timeout", time.Minute, "Maximum duration of fetching the CA of the bootstrap host, 0 for no limit.")
+	opts := zap.Options{}
+	opts.BindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := zap.New(zap.UseFlagOptions(&opts)).WithName("copy-certs")
+
+	hostname := os.Getenv("POD_NAME")
+	if hostname == "" {
+		var err error
+		if hostname, err = os.Hostname(); err != nil {
+			return err
+		}
+	}
+	copyOpts := certs.Options{
+		HostName:       hostname,
+		FQDNSuffix:     os.Getenv("MARKLOGIC_FQDN_SUFFIX"),
+		BootstrapHost:  os.Getenv("MARKLOGIC_BOOTSTRAP_HOST"),
+		NonBootstrap:   os.Getenv("MARKLOGIC_CLUSTER_TYPE") == bootstrap.ClusterTypeNonBootstrap,
+		ServerCertsDir: *serverCertsDir,
+		CACertDir:      *caCertDir,
+		CertsDir:       *certsDir,
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+	if *timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	if err := certs.Copy(ctx, copyOpts, log); err != nil {
+		log.Error(err, "copying certificates failed")
+		return err
+	}
+	return nil
+}
+
+// runCredentials reads the admin credentials from Vault, configured through
+// the standard VAULT_* environment variables, and writes them into the
+// in-memory volume the MarkLogic container reads them from.
+func runCredentials(args []string) error {
+	fs := flag.NewFlagSet("credentials", flag.ExitOnError)
+	dir := fs.String("dir", bootstrap.DefaultSecretsDir, "Directory receiving the username, password and wallet-password files.")
+	timeout := fs.Duration("timeout", time.Minute, "Maximum duration of reading the credentials, 0 for no limit.")
+	opts := zap.Options{}
+	opts.BindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := zap.New(zap.UseFlagOptions(&opts)).WithName("credentials")
+
+	provider := &credentials.VaultProvider{
+		Address:   os.Getenv("VAULT_ADDR"),
+		Path:      os.Getenv("VAULT_SECRET_PATH"),
+		Token:     os.Getenv("VAULT_TOKEN"),
+		Role:      os.Getenv("VAULT_ROLE"),
+		AuthMount: os.Getenv("VAULT_AUTH_MOUNT"),
+		Namespace: os.Getenv("VAULT_NAMESPACE"),
+	}
+	if caFile := os.Getenv("VAULT_CACERT"); caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return fmt.Errorf("%s has no PEM certificate", caFile)
+		}
+		provider.HTTPClient = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}}}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+	if *timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	creds, err := provider.Credentials(ctx)
+	if err != nil {
+		log.Error(err, "reading credentials failed")
+		return err
+	}
+	if err := credentials.WriteFiles(*dir, creds); err != nil {
+		log.Error(err, "writing credentials failed")
+		return err
+	}
+	log.Info("wrote admin credentials", "dir", *dir, "path", provider.Path)
+	return nil
+}
+
+func runBootstrap(args []string) error {
+	fs := flag.NewFlagSet("bootstrap", flag.ExitOnError)
+	secretsDir := fs.String("secrets-dir", bootstrap.DefaultSecretsDir, "Directory holding the admin username and password files.")
+	statusDir := fs.String("status-dir", bootstrap.DefaultStatusDir, "Directory of the status file recording the applied configuration.")
+	certsDir := fs.String("certs-dir", bootstrap.DefaultCertsDir, "Directory holding the named certificate of the host.")
+	timeout := fs.Duration("timeout", 30*time.Minute, "Maximum duration of the bootstrap, 0 for no limit.")
+	opts := zap.Options{}
+	opts.BindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := zap.New(zap.UseFlagOptions(&opts), zap.WriteTo(logWriter())).WithName("postStart")
+
+	cfg, err := bootstrap.ConfigFromEnv(*secretsDir)
+	if err != nil {
+		log.Error(err, "invalid configuration")
+		return err
+	}
+	cfg.StatusDir = *statusDir
+	cfg.CertsDir = *certsDir
+	if cfg.CAConfigMap != "" {
+		publisher, err := caPublisher(cfg.CAConfigMap)
+		if err != nil {
+			// The cluster works without, only clients cannot verify it.
+			log.Error(err, "cannot publish the CA certificate")
+		} else {
+			cfg.CAPublisher = publisher
+		}
+	}
+	if pod := os.Getenv("POD_NAME"); pod != "" {
+		reporter, err := podReporter(pod)
+		if err != nil {
+			// Only the status of the cluster misses the progress of the host.
+			log.Error(err, "cannot report the bootstrap phases")
+		} else {
+			cfg.Reporter = reporter
+		}
+		recorder, err := podRecorder(pod, "postStart")
+		if err != nil {
+			log.Error(err, "cannot record events")
+		} else {
+			cfg.Events = recorder
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+	if *timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	if err := bootstrap.New(cfg, log, nil).Run(ctx); err != nil {
+		log.Error(err, "bootstrap failed")
+		return err
+	}
+	return nil
+}
+
+// runDrain takes the host out of service before the pod stops. The grace
+// period must match the terminationGracePeriodSeconds of the pod, the kubelet
+// kills the hook when it runs out.
+func runDrain(args []string) error {
+	fs := flag.NewFlagSet("drain", flag.ExitOnError)
+	secretsDir := fs.String("secrets-dir", bootstrap.DefaultSecretsDir, "Directory holding the admin username and password files.")
+	gracePeriod := fs.Duration("grace-period", 120*time.Second, "Termination grace period of the pod, which bounds the drain.")
+	opts := zap.Options{}
+	opts.BindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := zap.New(zap.UseFlagOptions(&opts), zap.WriteTo(logWriter())).WithName("preStop")
+
+	cfg, err := bootstrap.ConfigFromEnv(*secretsDir)
+	if err != nil {
+		log.Error(err, "invalid configuration")
+		return err
+	}
+	drainOpts := drain.Options{
+		Host:        cfg.FQDN(),
+		Username:    cfg.Username,
+		Password:    cfg.Password,
+		TLSEnabled:  cfg.TLSEnabled,
+		GracePeriod: *gracePeriod,
+	}
+	if pod := os.Getenv("POD_NAME"); pod != "" {
+		recorder, err := podRecorder(pod, "preStop")
+		if err != nil {
+			log.Error(err, "cannot record events")
+		} else {
+			drainOpts.Events = recorder
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+	ctx, cancel = context.WithTimeout(ctx, *gracePeriod)
+	defer cancel()
+	if _, err := drain.New(drainOpts, log, nil).Run(ctx); err != nil {
+		log.Error(err, "drain failed")
+		return err
+	}
+	return nil
+}
+
+// runProbe checks the health of the host for the kubelet, which records the
+// reason of a failure in the events of the pod. Liveness failures are also
+// logged to the MarkLogic container log like the chart script does.
+func runProbe(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("probe takes the mode as first argument: %s, %s or %s", probe.Liveness, probe.Readiness, probe.Startup)
+	}
+	mode := args[0]
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	secretsDir := fs.String("secrets-dir", bootstrap.DefaultSecretsDir, "Directory holding the admin username and password files.")
+	statusDir := fs.String("status-dir", bootstrap.DefaultStatusDir, "Directory of the status file written by the bootstrap.")
+	timeout := fs.Duration("timeout", 5*time.Second, "Maximum duration of the probe, the timeoutSeconds of the probe.")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	opts := probe.Options{
+		Host:       hostname + "." + os.Getenv("MARKLOGIC_FQDN_SUFFIX"),
+		TLSEnabled: os.Getenv("MARKLOGIC_JOIN_TLS_ENABLED") == "true",
+		StatusDir:  *statusDir,
+		Running:    func() bool { return findProcess(markLogicProcess) != "" },
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+	ctx, cancel = context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	if mode == probe.Readiness {
+		creds, err := (&credentials.FileProvider{Dir: *secretsDir}).Credentials(ctx)
+		if err != nil {
+			return err
+		}
+		opts.Username, opts.Password = creds.Username, creds.Password
+	}
+	if err := probe.New(opts, nil).Run(ctx, mode); err != nil {
+		if mode == probe.Liveness {
+			log := zap.New(zap.WriteTo(logWriter())).WithName("livenessProbe")
+			log.Error(err, "MarkLogic is not alive")
+		}
+		return err
+	}
+	return nil
+}
+
+// runExporter serves the metrics of the host on /metrics until the pod
+// stops. Only the bootstrap host of the cluster exports the metrics of the
+// databases, which every host reports alike.
+func runExporter(args []string) error {
+	fs := flag.NewFlagSet("exporter", flag.ExitOnError)
+	secretsDir := fs.String("secrets-dir", bootstrap.DefaultSecretsDir, "Directory holding the admin username and password files.")
+	listen := fs.String("listen-address", fmt.Sprintf(":%d", exporter.DefaultPort), "Address the metrics are served on.")
+	timeout := fs.Duration("timeout", 10*time.Second, "Maximum duration of a scrape of the Management API.")
+	opts := zap.Options{}
+	opts.BindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := zap.New(zap.UseFlagOptions(&opts)).WithName("exporter")
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	provider := &credentials.FileProvider{Dir: *secretsDir}
+	collector := exporter.New(exporter.Options{
+		Host: hostname + "." + os.Getenv("MARKLOGIC_FQDN_SUFFIX"),
+		Credentials: func() (string, string, error) {
+			creds, err := provider.Credentials(context.Background())
+			if err != nil {
+				return "", "", err
+			}
+			return creds.Username, creds.Password, nil
+		},
+		TLSEnabled: os.Getenv("MARKLOGIC_JOIN_TLS_ENABLED") == "true",
+		Databases:  os.Getenv("MARKLOGIC_CLUSTER_TYPE") == bootstrap.ClusterTypeBootstrap && strings.HasSuffix(hostname, "-0"),
+		Timeout:    *timeout,
+	}, log, nil)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	srv := &http.Server{Addr: *listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	log.Info("serving metrics", "address", *listen)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Error(err, "serving metrics failed")
+		return err
+	}
+	return nil
+}
+
+// runShipLogs follows the MarkLogic log files and exports their records to an
+// OpenTelemetry collector until the pod stops.
+func runShipLogs(args []string) error {
+	fs := flag.NewFlagSet("ship-logs", flag.ExitOnError)
+	logsDir := fs.String("logs-dir", logship.DefaultLogsDir, "Directory of the MarkLogic log files.")
+	files := fs.String("files", "error,access,request,crash,audit", "Comma separated kinds of log files shipped.")
+	endpoint := fs.String("endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "Base URL of the OTLP/HTTP receiver of the collector.")
+	insecure := fs.Bool("insecure-skip-verify", false, "Do not verify the certificate of the collector.")
+	flushInterval := fs.Duration("flush-interval", 5*time.Second, "How often the new log lines are exported.")
+	headers := map[string]string{}
+	fs.Func("header", "Header sent with every export as name=value, may be repeated.", func(s string) error {
+		name, value, ok := strings.Cut(s, "=")
+		if !ok {
+			return fmt.Errorf("header %q is not name=value", s)
+		}
+		headers[name] = value
+		return nil
+	})
+	opts := zap.Options{}
+	opts.BindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := zap.New(zap.UseFlagOptions(&opts)).WithName("ship-logs")
+
+	if *endpoint == "" {
+		return fmt.Errorf("the endpoint of the collector is required")
+	}
+	var kinds []logship.Kind
+	for _, name := range strings.Split(*files, ",") {
+		kind, ok := logship.ParseKind(strings.TrimSpace(name))
+		if !ok {
+			return fmt.Errorf("unknown kind of log file %q", name)
+		}
+		kinds = append(kinds, kind)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	resource := []logship.Attribute{
+		{Key: "service.name", Value: "marklogic"},
+		{Key: "host.name", Value: hostname + "." + os.Getenv("MARKLOGIC_FQDN_SUFFIX")},
+	}
+	if pod := os.Getenv("POD_NAME"); pod != "" {
+		resource = append(resource, logship.Attribute{Key: "k8s.pod.name", Value: pod})
+	}
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		resource = append(resource, logship.Attribute{Key: "k8s.namespace.name", Value: namespace})
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+	log.Info("shipping logs", "dir", *logsDir, "files", kinds, "endpoint", logship.LogsURL(*endpoint))
+	return logship.New(logship.Options{
+		LogsDir:            *logsDir,
+		Kinds:              kinds,
+		Endpoint:           *endpoint,
+		Headers:            headers,
+		InsecureSkipVerify: *insecure,
+		Resource:           resource,
+		FlushInterval:      *flushInterval,
+	}, log).Run(ctx)
+}
+
+// caPublisher returns a publisher of the CA certificate into the named config
+// map of the namespace of the pod, using the credentials of its service account.
+func caPublisher(name string) (*bootstrap.ConfigMapPublisher, error) {
+	c, namespace, err := inClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	return &bootstrap.ConfigMapPublisher{Client: c, Namespace: namespace, Name: name}, nil
+}
+
+// podReporter returns a reporter of the bootstrap phases on the annotations
+// of the named pod, using the credentials of its service account.
+func podReporter(name string) (*bootstrap.PodReporter, error) {
+	c, namespace, err := inClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	return &bootstrap.PodReporter{Client: c, Namespace: namespace, Name: name}, nil
+}
+
+// podRecorder returns a recorder of the Events of the hook on the named pod
+// and its cluster, using the credentials of its service account.
+func podRecorder(name, hook string) (*events.PodRecorder, error) {
+	c, namespace, err := inClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	return &events.PodRecorder{Client: c, Namespace: namespace, Name: name, Component: "marklogic-agent/" + hook}, nil
+}
+
+// inClusterClient returns a client using the credentials of the service
+// account of the pod, with the namespace of the pod.
+func inClusterClient() (client.Client, string, error) {
+	namespace, err := os.ReadFile(serviceAccountNamespaceFile)
+	if err != nil {
+		return nil, "", err
+	}
+	restCfg, err := config.GetConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := client.New(restCfg, client.Options{})
+	if err != nil {
+		return nil, "", err
+	}
+	return c, strings.TrimSpace(string(namespace)), nil
+}
+
+// logWriter returns where the agent logs. Output of lifecycle hooks is not
+// part of the container logs, so like the chart scripts the agent also writes
+// to the standard output of the MarkLogic server process when it is found.
+func logWriter() io.Writer {
+	writers := []io.Writer{os.Stdout}
+	if pid := findProcess("start.marklogic"); pid != "" {
+		if f, err := os.OpenFile(filepath.Join("/proc", pid, "fd", "1"), os.O_WRONLY|os.O_APPEND, 0); err == nil {
+			writers = append(writers, f)
+		}
+	}
+	return io.MultiWriter(writers...)
+}
+
+// findProcess returns the pid of the first process whose command line
+// contains name, or an empty string.
+func findProcess(name string) string {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return ""
+	}
+	self := fmt.Sprint(os.Getpid())
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == self || strings.Trim(e.Name(), "0123456789") != "" {
+			continue
+		}
+		cmdline, err := os.ReadFile(filepath.Join("/proc", e.Name(), "cmdline"))
+		if err == nil && strings.Contains(string(cmdline), name) {
+			return e.Name()
+		}
+	}
+	return ""
+}
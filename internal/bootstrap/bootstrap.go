@@ -0,0 +1,208 @@
+// Package bootstrap initializes a MarkLogic host and joins it to its cluster.
+//
+// It is the Go implementation of the postStart hook of the chart: the
+// bootstrap host initializes security and configures its group, every other
+// host waits for the bootstrap host and joins the cluster, and TLS is
+// configured on the default app servers when requested.
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/internal/hostclient"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// Bootstrapper runs the bootstrap steps of one host.
+type Bootstrapper struct {
+	cfg     Config
+	log     logr.Logger
+	clients hostclient.Factory
+	// phase is the last phase the host completed.
+	phase Phase
+}
+
+// New returns a Bootstrapper for cfg. newClient may be nil to use mlclient.New.
+func New(cfg Config, log logr.Logger, newClient mlclient.Factory) *Bootstrapper {
+	clients := hostclient.Factory{New: newClient}
+	if cfg.CertsDir != "" {
+		clients.CAFile = filepath.Join(cfg.CertsDir, "cacert.pem")
+	}
+	return &Bootstrapper{cfg: cfg, log: log, clients: clients, phase: PhasePending}
+}
+
+// Run performs all the bootstrap steps of the host and reports the phases
+// it completes to cfg.Reporter.
+func (b *Bootstrapper) Run(ctx context.Context) error {
+	b.report(ctx, nil)
+	if err := b.run(ctx); err != nil {
+		b.failed(ctx, err)
+		return err
+	}
+	b.reached(ctx, PhaseCompleted)
+	return nil
+}
+
+func (b *Bootstrapper) run(ctx context.Context) error {
+	b.log.Info("start configuring MarkLogic", "host", b.cfg.FQDN(), "bootstrapHost", b.cfg.BootstrapHost)
+
+	if b.cfg.IsBootstrapHost() {
+		changed, err := b.statusChanged()
+		if err != nil {
+			return err
+		}
+		if !changed {
+			b.log.Info("no change in configuration, skip configuration")
+			return nil
+		}
+		if err := b.initMarkLogic(ctx); err != nil {
+			return err
+		}
+		b.reached(ctx, PhaseInitialized)
+		if b.cfg.ClusterType == ClusterTypeBootstrap {
+			if err := b.initSecurityDB(ctx); err != nil {
+				return err
+			}
+			b.reached(ctx, PhaseSecurityInstalled)
+			b.groupConfigured(ctx)
+		} else {
+			b.groupConfigured(ctx)
+			if err := b.joinCluster(ctx); err != nil {
+				return err
+			}
+			b.reached(ctx, PhaseJoined)
+		}
+		b.configurePathBasedRouting(ctx)
+	} else {
+		exists, err := b.statusExists()
+		if err != nil {
+			return err
+		}
+		if exists {
+			b.log.Info("status file exists, skip configuration")
+			return nil
+		}
+		if err := b.initMarkLogic(ctx); err != nil {
+			return err
+		}
+		b.reached(ctx, PhaseInitialized)
+		if err := b.waitBootstrapReady(ctx); err != nil {
+			return err
+		}
+		if err := b.joinCluster(ctx); err != nil {
+			return err
+		}
+		b.reached(ctx, PhaseJoined)
+	}
+
+	if b.cfg.TLSEnabled {
+		if err := b.configureTLS(ctx); err != nil {
+			return err
+		}
+		b.reached(ctx, PhaseTLSConfigured)
+		if b.cfg.IsBootstrapHost() && b.cfg.ClusterType == ClusterTypeBootstrap {
+			// Clients fall back to not verifying certificates without it.
+			if err := b.publishCA(ctx); err != nil {
+				b.log.Error(err, "failed to publish CA certificate")
+			}
+		}
+	}
+	if err := b.writeStatus(); err != nil {
+		return err
+	}
+	b.log.Info("bootstrap completed")
+	return nil
+}
+
+// groupConfigured configures the group and records the phase when it was
+// applied. A failure is reported on the pod and recorded as a warning without
+// stopping the bootstrap.
+func (b *Bootstrapper) groupConfigured(ctx context.Context) {
+	applied, err := b.configureGroup(ctx)
+	switch {
+	case err != nil:
+		b.log.Error(err, "failed to configure group", "group", b.cfg.Group)
+		b.report(ctx, fmt.Errorf("configuring group %s: %w", b.cfg.Group, err))
+		b.event(ctx, corev1.EventTypeWarning, marklogicv1alpha1.EventGroupConfigurationFailed, "configuring group %s failed: %v", b.cfg.Group, err)
+	case applied:
+		b.reached(ctx, PhaseGroupConfigured)
+	}
+}
+
+// client returns a client for host, using https when secure is set.
+func (b *Bootstrapper) client(host string, secure, auth bool) (*mlclient.Client, error) {
+	cfg := mlclient.Config{Host: host, HTTPS: secure}
+	if auth {
+		cfg.Username = b.cfg.Username
+		cfg.Password = b.cfg.Password
+	}
+	name := host
+	if host == hostclient.Localhost {
+		name = b.cfg.FQDN()
+	}
+	return b.clients.Client(cfg, name)
+}
+
+func (b *Bootstrapper) local(secure bool) (*mlclient.Client, error) {
+	return b.client(hostclient.Localhost, secure, true)
+}
+
+// bootstrapHost returns an authenticated client for the bootstrap host using
+// https when TLS is enabled for the cluster.
+func (b *Bootstrapper) bootstrapHost() (*mlclient.Client, error) {
+	return b.client(b.cfg.BootstrapHost, b.cfg.TLSEnabled, true)
+}
+
+// wait pauses for d or until ctx is done.
+func wait(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// usesHTTPS reports whether the Admin app server of host only accepts https.
+// A plain http request is answered with 403 in that case.
+func (b *Bootstrapper) usesHTTPS(ctx context.Context, host string) bool {
+	c, err := b.client(host, false, false)
+	if err != nil {
+		return false
+	}
+	_, err = c.Timestamp(ctx)
+	return mlclient.IsForbidden(err)
+}
+
+// describe turns the outcome of a call to the bootstrap host into a reason a
+// user can act on, following the response codes handled by the chart scripts.
+func describe(host string, err error) string {
+	var dnsErr *net.DNSError
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		return fmt.Sprintf("host %q cannot be resolved, check that bootstrapHostName names an existing MarkLogic pod", host)
+	case mlclient.IsUnreachable(err):
+		return fmt.Sprintf("host %q is not reachable yet", host)
+	case mlclient.IsUnauthorized(err):
+		return fmt.Sprintf("host %q rejected the admin credentials or its security database is not initialized", host)
+	case mlclient.IsForbidden(err):
+		return fmt.Sprintf("host %q requires https, check that TLS settings match the bootstrap cluster", host)
+	case mlclient.IsNotFound(err):
+		return fmt.Sprintf("host %q does not know the requested resource", host)
+	case mlclient.StatusCode(err) >= http.StatusInternalServerError:
+		return fmt.Sprintf("host %q is not ready: %v", host, err)
+	}
+	return err.Error()
+}
@@ -0,0 +1,165 @@
+package bootstrap
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/marklogic/marklogic-kubernetes/internal/events"
+)
+
+// Cluster types of MARKLOGIC_CLUSTER_TYPE.
+const (
+	ClusterTypeBootstrap    = "bootstrap"
+	ClusterTypeNonBootstrap = "non-bootstrap"
+)
+
+// Default locations inside the MarkLogic container.
+const (
+	DefaultSecretsDir = "/run/secrets/ml-secrets"
+	DefaultCertsDir   = "/run/secrets/marklogic-certs"
+	DefaultStatusDir  = "/var/opt/MarkLogic/Kubernetes"
+)
+
+// Config is the configuration of a bootstrap run. It carries the same
+// settings the chart passes to poststart-hook.sh through the environment.
+type Config struct {
+	// HostName is the short host name of the pod, e.g. dnode-0.
+	HostName string
+	// FQDNSuffix is appended to HostName to build the host FQDN.
+	FQDNSuffix string
+	// BootstrapHost is the FQDN of the first host of the cluster.
+	BootstrapHost string
+	// ClusterType is ClusterTypeBootstrap when this StatefulSet owns the
+	// bootstrap host, ClusterTypeNonBootstrap when it joins another one.
+	ClusterType string
+	Group       string
+
+	XdqpSSLEnabled   bool
+	TLSEnabled       bool
+	PathBasedRouting bool
+
+	Username       string
+	Password       string
+	LicenseKey     string
+	Licensee       string
+	Realm          string
+	WalletPassword string
+
+	// CertsDir holds the named certificate of the host, if any.
+	CertsDir string
+	// StatusDir holds the status file recording the applied configuration.
+	StatusDir string
+	// CAConfigMap is the config map the CA certificate generated for the
+	// temporary certificates is published in.
+	CAConfigMap string
+	// CAPublisher publishes the generated CA certificate, if set.
+	CAPublisher CAPublisher
+	// Reporter records the phases of the bootstrap, if set.
+	Reporter Reporter
+	// Events records the transitions of the host as Kubernetes Events, if set.
+	Events events.Recorder
+
+	// RetryInterval is the delay between two checks of a condition.
+	RetryInterval time.Duration
+	// RestartRetries is how many times a restart is checked for.
+	RestartRetries int
+	// SettleDelay is the pause after changes that restart app servers.
+	SettleDelay time.Duration
+}
+
+// FQDN returns the fully qualified name of the host.
+func (c *Config) FQDN() string {
+	return c.HostName + "." + c.FQDNSuffix
+}
+
+// IsBootstrapHost reports whether the host is the first pod of its StatefulSet.
+func (c *Config) IsBootstrapHost() bool {
+	return strings.HasSuffix(c.HostName, "-0")
+}
+
+// ConfigFromEnv builds the configuration from the environment of the
+// MarkLogic container and the admin credentials mounted in secretsDir.
+func ConfigFromEnv(secretsDir string) (Config, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return Config{}, err
+	}
+	cfg := Config{
+		HostName:         hostname,
+		FQDNSuffix:       os.Getenv("MARKLOGIC_FQDN_SUFFIX"),
+		BootstrapHost:    os.Getenv("MARKLOGIC_BOOTSTRAP_HOST"),
+		ClusterType:      os.Getenv("MARKLOGIC_CLUSTER_TYPE"),
+		Group:            os.Getenv("MARKLOGIC_GROUP"),
+		XdqpSSLEnabled:   os.Getenv("XDQP_SSL_ENABLED") == "true",
+		TLSEnabled:       os.Getenv("MARKLOGIC_JOIN_TLS_ENABLED") == "true",
+		PathBasedRouting: os.Getenv("PATH_BASED_ROUTING") == "true",
+		LicenseKey:       os.Getenv("LICENSE_KEY"),
+		Licensee:         os.Getenv("LICENSEE"),
+		Realm:            os.Getenv("REALM"),
+		WalletPassword:   os.Getenv("MARKLOGIC_WALLET_PASSWORD"),
+		CAConfigMap:      os.Getenv("MARKLOGIC_CA_CONFIGMAP"),
+		CertsDir:         DefaultCertsDir,
+		StatusDir:        DefaultStatusDir,
+	}
+	if cfg.Username, err = readSecret(secretsDir, "username"); err != nil {
+		return Config{}, err
+	}
+	if cfg.Password, err = readSecret(secretsDir, "password"); err != nil {
+		return Config{}, err
+	}
+	// The credentials provider of the cluster may supply the wallet
+	// password next to the admin credentials.
+	if cfg.WalletPassword == "" {
+		if cfg.WalletPassword, err = readSecret(secretsDir, "wallet-password"); err != nil {
+			return Config{}, err
+		}
+	}
+	return cfg, cfg.Validate()
+}
+
+func readSecret(dir, name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Validate checks the configuration and fills in defaults.
+func (c *Config) Validate() error {
+	if c.Username == "" || c.Password == "" {
+		return errors.New("MARKLOGIC_ADMIN_USERNAME and MARKLOGIC_ADMIN_PASSWORD must be set")
+	}
+	if c.FQDNSuffix == "" {
+		return errors.New("MARKLOGIC_FQDN_SUFFIX must be set")
+	}
+	if errs := validation.IsDNS1123Subdomain(c.BootstrapHost); len(errs) > 0 {
+		return fmt.Errorf("bootstrap host %q is not a valid host name, check bootstrapHostName: %s",
+			c.BootstrapHost, strings.Join(errs, "; "))
+	}
+	if c.ClusterType != ClusterTypeBootstrap && c.ClusterType != ClusterTypeNonBootstrap {
+		return fmt.Errorf("MARKLOGIC_CLUSTER_TYPE must be %q or %q, got %q", ClusterTypeBootstrap, ClusterTypeNonBootstrap, c.ClusterType)
+	}
+	if c.Group == "" {
+		c.Group = "Default"
+	}
+	if c.Realm == "" {
+		c.Realm = "public"
+	}
+	if c.RetryInterval == 0 {
+		c.RetryInterval = 10 * time.Second
+	}
+	if c.RestartRetries == 0 {
+		c.RestartRetries = 10
+	}
+	if c.SettleDelay == 0 {
+		c.SettleDelay = 5 * time.Second
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// fakeHost emulates the Admin and Management APIs of one MarkLogic host on a
+// single port.
+type fakeHost struct {
+	mu  sync.Mutex
+	srv *httptest.Server
+	// name is the host name of the host in the cluster, if any.
+	name        string
+	startup     int
+	initialized bool
+	secured     bool
+	tls         bool
+	// initStatus overrides the status of POST /admin/v1/init.
+	initStatus int
+	// hostStatus overrides the status of GET /manage/v2/hosts/{host}/properties.
+	hostStatus int
+	hosts      map[string]string
+	groups     map[string]bool
+	calls      []string
+	bodies     map[string]string
+	// ca is the PEM encoded CA certificate of the certificate template.
+	ca string
+}
+
+func newFakeHost(t *testing.T) *fakeHost {
+	f := &fakeHost{hosts: map[string]string{}, groups: map[string]bool{"Default": true}, bodies: map[string]string{}}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+// securedHost returns a fake of a host that is already part of a cluster.
+func securedHost(t *testing.T, members ...string) *fakeHost {
+	f := newFakeHost(t)
+	f.initialized, f.secured = true, true
+	for _, m := range members {
+		f.hosts[m] = "Default"
+	}
+	return f
+}
+
+func (f *fakeHost) port() int {
+	u, _ := url.Parse(f.srv.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+func (f *fakeHost) called(call string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.calls {
+		if c == call {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeHost) restart(w http.ResponseWriter) {
+	old := f.timestamp()
+	f.startup++
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, `{"restart":{"last-startup":[{"value":%q}]}}`, old)
+}
+
+func (f *fakeHost) timestamp() string {
+	return fmt.Sprintf("2024-01-01T00:00:%02d", f.startup)
+}
+
+func (f *fakeHost) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	call := r.Method + " " + r.URL.Path
+	f.calls = append(f.calls, call)
+	f.bodies[call] = string(body)
+
+	if f.tls && r.Header.Get("Authorization") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if f.secured && r.Header.Get("Authorization") == "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="public"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case call == "GET /admin/v1/timestamp":
+		fmt.Fprint(w, f.timestamp())
+	case call == "POST /admin/v1/init":
+		if f.initStatus != 0 {
+			w.WriteHeader(f.initStatus)
+			return
+		}
+		if f.initialized {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		f.initialized = true
+		f.restart(w)
+	case call == "POST /admin/v1/instance-admin":
+		f.secured = true
+		if f.name != "" {
+			f.hosts[f.name] = "Default"
+		}
+		f.restart(w)
+	case call == "GET /admin/v1/server-config":
+		fmt.Fprint(w, "<host>joining</host>")
+	case call == "POST /admin/v1/cluster-config" && r.Header.Get("Content-Type") == "application/zip":
+		f.secured = true
+		f.restart(w)
+	case call == "POST /admin/v1/cluster-config":
+		form, _ := url.ParseQuery(string(body))
+		f.hosts["joining"] = form.Get("group")
+		fmt.Fprint(w, "cluster.zip")
+	case r.Method == http.MethodGet && len(parts) == 5 && parts[2] == "hosts":
+		group, ok := f.hosts[parts[3]]
+		switch {
+		case f.hostStatus != 0:
+			w.WriteHeader(f.hostStatus)
+		case ok:
+			_ = json.NewEncoder(w).Encode(map[string]string{"host-name": parts[3], "group": group})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.Method == http.MethodGet && len(parts) == 5 && parts[2] == "groups":
+		if !f.groups[parts[3]] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"group-name":%q}`, parts[3])
+	case call == "POST /manage/v2/groups":
+		var props mlclient.GroupProperties
+		_ = json.Unmarshal(body, &props)
+		f.groups[props.GroupName] = true
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut:
+		w.WriteHeader(http.StatusNoContent)
+	case call == "POST /manage/v2/certificate-templates":
+		w.WriteHeader(http.StatusCreated)
+	case call == "POST /v1/eval" && strings.Contains(string(body), "get-template-certificate-authority"):
+		w.Header().Set("Content-Type", "multipart/mixed; boundary=BOUNDARY")
+		fmt.Fprintf(w, "\r\n--BOUNDARY\r\nContent-Type: text/plain\r\nX-Primitive: element()\r\n\r\n%s\r\n--BOUNDARY--\r\n", f.ca)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// fakeCluster routes the clients of a Bootstrapper to fake hosts by host name.
+type fakeCluster struct {
+	hosts map[string]*fakeHost
+	// https records, per host, whether a client asked for https.
+	https map[string]bool
+}
+
+func (c *fakeCluster) newClient(cfg mlclient.Config) (*mlclient.Client, error) {
+	host, ok := c.hosts[cfg.Host]
+	if !ok {
+		return nil, fmt.Errorf("unknown host %s", cfg.Host)
+	}
+	c.https[cfg.Host] = c.https[cfg.Host] || cfg.HTTPS
+	cfg.HTTPS = false
+	cfg.TLSConfig = nil
+	cfg.Host = "127.0.0.1"
+	cfg.AdminPort, cfg.ManagePort, cfg.AppServicesPort = host.port(), host.port(), host.port()
+	cfg.RetryInterval = time.Millisecond
+	return mlclient.New(cfg)
+}
+
+func testConfig(t *testing.T, hostname string) Config {
+	return Config{
+		HostName:       hostname,
+		FQDNSuffix:     "dnode.marklogic.svc.cluster.local",
+		BootstrapHost:  "dnode-0.dnode.marklogic.svc.cluster.local",
+		ClusterType:    ClusterTypeBootstrap,
+		Group:          "Default",
+		XdqpSSLEnabled: true,
+		Username:       "admin",
+		Password:       "admin",
+		Realm:          "public",
+		CertsDir:       t.TempDir(),
+		StatusDir:      t.TempDir(),
+		RetryInterval:  time.Millisecond,
+		RestartRetries: 3,
+		SettleDelay:    time.Millisecond,
+	}
+}
+
+func newTestBootstrapper(t *testing.T, cfg Config, hosts map[string]*fakeHost) (*Bootstrapper, *fakeCluster) {
+	cluster := &fakeCluster{hosts: hosts, https: map[string]bool{}}
+	for name, host := range hosts {
+		if name != "localhost" {
+			host.name = name
+		}
+	}
+	return New(cfg, testr.New(t), cluster.newClient), cluster
+}
+
+func requireNoCalls(t *testing.T, f *fakeHost) {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	require.Empty(t, f.calls)
+}
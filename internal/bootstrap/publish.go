@@ -0,0 +1,46 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CAKey is the key of the CA certificate in the published config map.
+const CAKey = "ca.crt"
+
+// CAPublisher publishes the PEM encoded CA certificate of the cluster.
+type CAPublisher interface {
+	PublishCA(ctx context.Context, pem []byte) error
+}
+
+// ConfigMapPublisher publishes the CA certificate into a config map created
+// by the operator, which only allows the pods to read and patch it.
+type ConfigMapPublisher struct {
+	Client    client.Client
+	Namespace string
+	Name      string
+}
+
+// PublishCA sets the ca.crt key of the config map.
+func (p *ConfigMapPublisher) PublishCA(ctx context.Context, pem []byte) error {
+	cm := &corev1.ConfigMap{}
+	if err := p.Client.Get(ctx, types.NamespacedName{Namespace: p.Namespace, Name: p.Name}, cm); err != nil {
+		return fmt.Errorf("reading config map %s: %w", p.Name, err)
+	}
+	if cm.Data[CAKey] == string(pem) {
+		return nil
+	}
+	patch := client.MergeFrom(cm.DeepCopy())
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[CAKey] = string(pem)
+	if err := p.Client.Patch(ctx, cm, patch); err != nil {
+		return fmt.Errorf("updating config map %s: %w", p.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package bootstrap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/internal/events"
+)
+
+// Phase is the last bootstrap step a host completed.
+type Phase string
+
+// Phases of the bootstrap, replacing the steps the chart scripts record in
+// their status file. A host goes through the ones of its role only.
+const (
+	PhasePending           Phase = "pending"
+	PhaseInitialized       Phase = "initialized"
+	PhaseSecurityInstalled Phase = "security-installed"
+	PhaseJoined            Phase = "joined"
+	PhaseGroupConfigured   Phase = "group-configured"
+	PhaseTLSConfigured     Phase = "tls-configured"
+	PhaseCompleted         Phase = "completed"
+)
+
+// Annotations of the pod recording the progress of its bootstrap.
+const (
+	// PhaseAnnotation holds the last phase the host completed.
+	PhaseAnnotation = "marklogic.com/bootstrap-phase"
+	// ErrorAnnotation holds why the step after the phase failed.
+	ErrorAnnotation = "marklogic.com/bootstrap-error"
+	// TimeAnnotation holds when the phase was reached or the step failed,
+	// in RFC 3339 format.
+	TimeAnnotation = "marklogic.com/bootstrap-time"
+)
+
+// maxErrorLength bounds the error recorded on the pod.
+const maxErrorLength = 1024
+
+// reportTimeout bounds the report of a failure, which may happen after the
+// context of the bootstrap expired.
+const reportTimeout = 10 * time.Second
+
+// Reporter records the progress of the bootstrap outside of the host.
+type Reporter interface {
+	// ReportPhase records that the host completed phase. A non nil failure
+	// tells that the next step failed.
+	ReportPhase(ctx context.Context, phase Phase, failure error) error
+}
+
+// PodReporter records the progress on the annotations of the pod, where the
+// operator collects it into the status of the cluster. A Role created by the
+// operator only allows the pods to patch the pods of their StatefulSet.
+type PodReporter struct {
+	Client    client.Client
+	Namespace string
+	Name      string
+	// Now returns the time of a report. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// ReportPhase sets the bootstrap annotations of the pod with a merge patch,
+// which needs no read access to the pod.
+func (r *PodReporter) ReportPhase(ctx context.Context, phase Phase, failure error) error {
+	now := time.Now
+	if r.Now != nil {
+		now = r.Now
+	}
+	annotations := map[string]interface{}{
+		PhaseAnnotation: string(phase),
+		TimeAnnotation:  now().UTC().Format(time.RFC3339),
+		// null removes the error of a previous attempt
+		ErrorAnnotation: nil,
+	}
+	if failure != nil {
+		msg := failure.Error()
+		if len(msg) > maxErrorLength {
+			msg = msg[:maxErrorLength] + "..."
+		}
+		annotations[ErrorAnnotation] = msg
+	}
+	patch, err := json.Marshal(map[string]interface{}{"metadata": map[string]interface{}{"annotations": annotations}})
+	if err != nil {
+		return err
+	}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: r.Namespace, Name: r.Name}}
+	if err := r.Client.Patch(ctx, pod, client.RawPatch(types.MergePatchType, patch)); err != nil {
+		return fmt.Errorf("updating pod %s: %w", r.Name, err)
+	}
+	return nil
+}
+
+// reached records that the host completed phase.
+func (b *Bootstrapper) reached(ctx context.Context, phase Phase) {
+	b.phase = phase
+	b.report(ctx, nil)
+	switch phase {
+	case PhaseInitialized:
+		b.event(ctx, corev1.EventTypeNormal, marklogicv1alpha1.EventHostInitialized, "initialized MarkLogic on %s", b.cfg.FQDN())
+	case PhaseSecurityInstalled:
+		b.event(ctx, corev1.EventTypeNormal, marklogicv1alpha1.EventSecurityInstalled, "installed the Security database on %s", b.cfg.BootstrapHost)
+	case PhaseGroupConfigured:
+		b.event(ctx, corev1.EventTypeNormal, marklogicv1alpha1.EventGroupConfigured, "configured group %s", b.cfg.Group)
+	case PhaseJoined:
+		b.event(ctx, corev1.EventTypeNormal, marklogicv1alpha1.EventHostJoined, "joined the cluster of %s in group %s", b.cfg.BootstrapHost, b.cfg.Group)
+	case PhaseTLSConfigured:
+		b.event(ctx, corev1.EventTypeNormal, marklogicv1alpha1.EventTLSEnabled, "enabled https on the default App Servers")
+	case PhaseCompleted:
+		b.event(ctx, corev1.EventTypeNormal, marklogicv1alpha1.EventBootstrapCompleted, "completed the bootstrap")
+	}
+}
+
+// failed records that the step after the current phase failed.
+func (b *Bootstrapper) failed(ctx context.Context, failure error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), reportTimeout)
+	defer cancel()
+	b.report(ctx, failure)
+	b.event(ctx, corev1.EventTypeWarning, marklogicv1alpha1.EventBootstrapFailed, "bootstrap failed after phase %s: %v", b.phase, failure)
+}
+
+// event records a transition of the host. Like the reports, errors are only
+// logged.
+func (b *Bootstrapper) event(ctx context.Context, eventType, reason, format string, args ...interface{}) {
+	if err := events.Eventf(ctx, b.cfg.Events, eventType, reason, format, args...); err != nil {
+		b.log.Error(err, "failed to record event", "reason", reason)
+	}
+}
+
+// report records the current phase and failure, if any. The bootstrap does
+// not depend on it, errors are only logged.
+func (b *Bootstrapper) report(ctx context.Context, failure error) {
+	if b.cfg.Reporter == nil {
+		return
+	}
+	if err := b.cfg.Reporter.ReportPhase(ctx, b.phase, failure); err != nil {
+		b.log.Error(err, "failed to report bootstrap phase", "phase", b.phase)
+	}
+}
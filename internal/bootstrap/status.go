@@ -0,0 +1,81 @@
+package bootstrap
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// statusFile records the configuration applied by the last successful run
+// in the data volume, so that restarted pods skip the configuration.
+const statusFile = "status.txt"
+
+// Completed reports whether the host completed its bootstrap, which left a
+// status file in statusDir.
+func Completed(statusDir string) (bool, error) {
+	_, err := os.Stat(filepath.Join(statusDir, statusFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *Bootstrapper) statusPath() string {
+	return filepath.Join(b.cfg.StatusDir, statusFile)
+}
+
+// status returns the content of the status file for the configuration.
+func (b *Bootstrapper) status() map[string]string {
+	return map[string]string{
+		"fqdn":                   b.cfg.FQDN(),
+		"group_name":             b.cfg.Group,
+		"group_xdqp_ssl_enabled": fmt.Sprint(b.cfg.XdqpSSLEnabled),
+		"https_enabled":          fmt.Sprint(b.cfg.TLSEnabled),
+	}
+}
+
+func (b *Bootstrapper) statusExists() (bool, error) {
+	return Completed(b.cfg.StatusDir)
+}
+
+// statusChanged reports whether the group or TLS settings differ from the
+// ones recorded in the status file, or no status file exists.
+func (b *Bootstrapper) statusChanged() (bool, error) {
+	data, err := os.ReadFile(b.statusPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	recorded := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if key, value, ok := strings.Cut(scanner.Text(), "="); ok {
+			recorded[key] = value
+		}
+	}
+	for _, key := range []string{"group_name", "group_xdqp_ssl_enabled", "https_enabled"} {
+		if recorded[key] != b.status()[key] {
+			b.log.Info("configuration changed, continue configuration", "setting", key, "old", recorded[key], "new", b.status()[key])
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (b *Bootstrapper) writeStatus() error {
+	if err := os.MkdirAll(b.cfg.StatusDir, 0o755); err != nil {
+		return err
+	}
+	status := b.status()
+	var buf bytes.Buffer
+	for _, key := range []string{"fqdn", "group_name", "group_xdqp_ssl_enabled", "https_enabled"} {
+		fmt.Fprintf(&buf, "%s=%s\n", key, status[key])
+	}
+	return os.WriteFile(b.statusPath(), buf.Bytes(), 0o644)
+}
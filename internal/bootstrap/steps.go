@@ -0,0 +1,331 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+	"github.com/marklogic/marklogic-kubernetes/pkg/restart"
+)
+
+// joinRetries is how many unexpected answers of the bootstrap host are
+// tolerated before joining the cluster is given up.
+const joinRetries = 5
+
+// groupRetries is how many times the group of the host is looked up on the
+// bootstrap host before joining the cluster is given up.
+const groupRetries = 10
+
+// initMarkLogic waits until the local server responds and initializes it.
+func (b *Bootstrapper) initMarkLogic(ctx context.Context) error {
+	local, err := b.local(false)
+	if err != nil {
+		return err
+	}
+	host := b.cfg.FQDN()
+	b.log.Info("wait until host is ready", "host", host)
+	for {
+		ts, err := local.Timestamp(ctx)
+		if err == nil || (mlclient.StatusCode(err) != 0 && mlclient.StatusCode(err) != http.StatusServiceUnavailable) {
+			b.log.Info("host is responding", "host", host, "timestamp", ts)
+			break
+		}
+		b.log.Info("host not responding yet", "host", host, "reason", describe("localhost", err))
+		if err := wait(ctx, b.cfg.RetryInterval); err != nil {
+			return fmt.Errorf("waiting for %s to respond: %w", host, err)
+		}
+	}
+
+	restarted, err := b.restartingCall(ctx, local, func(ctx context.Context) (*mlclient.Restart, error) {
+		return local.Init(ctx, mlclient.InitRequest{LicenseKey: b.cfg.LicenseKey, Licensee: b.cfg.Licensee})
+	})
+	switch {
+	case mlclient.IsUnauthorized(err) && !restarted:
+		// Once security is installed the host refuses to be initialized again.
+		b.log.Info("host already initialized", "host", host)
+		return nil
+	case err != nil && !restarted:
+		return fmt.Errorf("initializing %s: %s", host, describe("localhost", err))
+	case err != nil:
+		return err
+	case restarted:
+		b.log.Info("init called, restart triggered", "host", host)
+	default:
+		b.log.Info("init called, no restart triggered", "host", host)
+	}
+	b.log.Info("init complete", "host", host)
+	return nil
+}
+
+// restartingCall runs an Admin API call of c that may restart the host and
+// waits for the restart.
+func (b *Bootstrapper) restartingCall(ctx context.Context, c *mlclient.Client, call func(context.Context) (*mlclient.Restart, error)) (bool, error) {
+	restarted, err := restart.Call(ctx, c, b.restartBackoff(), func(ctx context.Context) (*mlclient.Restart, error) {
+		r, err := call(ctx)
+		if r != nil {
+			b.log.Info("waiting for MarkLogic to restart", "host", c.Host())
+		}
+		return r, err
+	})
+	if restarted {
+		if err != nil {
+			return true, fmt.Errorf("%s did not restart: %w", c.Host(), err)
+		}
+		b.log.Info("MarkLogic has restarted", "host", c.Host())
+		b.event(ctx, corev1.EventTypeNormal, marklogicv1alpha1.EventHostRestarted, "MarkLogic restarted on %s after a configuration change", c.Host())
+	}
+	return restarted, err
+}
+
+// restartBackoff polls the timestamp service every RetryInterval, at most
+// RestartRetries times.
+func (b *Bootstrapper) restartBackoff() restart.Backoff {
+	return restart.Backoff{Interval: b.cfg.RetryInterval, Steps: b.cfg.RestartRetries}
+}
+
+// initSecurityDB installs the security database and the admin user on the
+// bootstrap host unless it is already initialized.
+func (b *Bootstrapper) initSecurityDB(ctx context.Context) error {
+	b.log.Info("initializing as bootstrap cluster")
+	bootstrap, err := b.bootstrapHost()
+	if err != nil {
+		return err
+	}
+	if _, err := bootstrap.HostProperties(ctx, b.cfg.BootstrapHost); err == nil {
+		b.log.Info("bootstrap security already initialized", "host", b.cfg.BootstrapHost)
+		return nil
+	}
+
+	b.log.Info("initializing bootstrap security")
+	admin, err := b.client(b.cfg.BootstrapHost, false, true)
+	if err != nil {
+		return err
+	}
+	if _, err := b.restartingCall(ctx, admin, func(ctx context.Context) (*mlclient.Restart, error) {
+		return admin.InstanceAdmin(ctx, mlclient.InstanceAdminRequest{
+			AdminUsername:  b.cfg.Username,
+			AdminPassword:  b.cfg.Password,
+			Realm:          b.cfg.Realm,
+			WalletPassword: b.cfg.WalletPassword,
+		})
+	}); err != nil {
+		return fmt.Errorf("initializing security on %s: %w", b.cfg.BootstrapHost, err)
+	}
+	b.log.Info("bootstrap security initialized")
+	return nil
+}
+
+// waitBootstrapReady waits until the security database is installed on the
+// bootstrap host and, when TLS is enabled, until its app servers use https.
+// An unauthenticated http request tells both: 401 once security is installed,
+// 403 once TLS is enabled.
+func (b *Bootstrapper) waitBootstrapReady(ctx context.Context) error {
+	probe, err := b.client(b.cfg.BootstrapHost, false, false)
+	if err != nil {
+		return err
+	}
+	want := http.StatusUnauthorized
+	if b.cfg.TLSEnabled {
+		want = http.StatusForbidden
+	}
+	var reason string
+	for {
+		_, err := probe.Timestamp(ctx)
+		if ctx.Err() != nil && reason != "" {
+			return fmt.Errorf("bootstrap host %s did not become ready: %s", b.cfg.BootstrapHost, reason)
+		}
+		code := mlclient.StatusCode(err)
+		switch {
+		case code == want:
+			b.log.Info("bootstrap host is ready", "host", b.cfg.BootstrapHost, "tls", b.cfg.TLSEnabled)
+			return nil
+		case err == nil:
+			reason = "security is not initialized on the bootstrap host yet"
+		case code == http.StatusUnauthorized:
+			reason = "bootstrap host accepts http, waiting for TLS to be enabled on it; check that TLS is enabled on the bootstrap cluster"
+		case code == http.StatusForbidden:
+			reason = "bootstrap host requires https but TLS is disabled for this cluster; enable TLS to join it"
+		default:
+			reason = describe(b.cfg.BootstrapHost, err)
+		}
+		b.log.Info("bootstrap host is not ready, retrying", "host", b.cfg.BootstrapHost, "statusCode", code, "reason", reason)
+		if err := wait(ctx, b.cfg.RetryInterval); err != nil {
+			return fmt.Errorf("bootstrap host %s did not become ready: %s", b.cfg.BootstrapHost, reason)
+		}
+	}
+}
+
+// joinCluster adds the host to the cluster of the bootstrap host unless it
+// has already joined.
+func (b *Bootstrapper) joinCluster(ctx context.Context) error {
+	host := b.cfg.FQDN()
+	bootstrap, err := b.bootstrapHost()
+	if err != nil {
+		return err
+	}
+
+	// 200: already joined, 404: not joined yet, 401: security database not
+	// set or wrong credentials, 403: bootstrap host not initialized for the
+	// protocol used, 000: bootstrap host unreachable.
+	for retries := joinRetries; ; {
+		_, err := bootstrap.HostProperties(ctx, host)
+		if err == nil {
+			b.log.Info("host has already joined the cluster", "host", host)
+			return nil
+		}
+		if mlclient.IsNotFound(err) {
+			b.log.Info("proceed to joining bootstrap host", "host", host, "bootstrapHost", b.cfg.BootstrapHost)
+			break
+		}
+		reason := describe(b.cfg.BootstrapHost, err)
+		if mlclient.IsUnauthorized(err) {
+			return fmt.Errorf("failed to join the cluster: %s", reason)
+		}
+		if retries--; retries <= 0 {
+			return fmt.Errorf("failed to join the cluster after %d attempts: %s", joinRetries, reason)
+		}
+		b.log.Info("unexpected response from bootstrap host, retrying", "statusCode", mlclient.StatusCode(err), "reason", reason)
+		if err := wait(ctx, b.cfg.RetryInterval); err != nil {
+			return fmt.Errorf("failed to join the cluster: %s", reason)
+		}
+	}
+
+	if err := b.waitGroup(ctx, bootstrap); err != nil {
+		return err
+	}
+
+	local, err := b.local(false)
+	if err != nil {
+		return err
+	}
+	serverConfig, err := local.ServerConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("getting server-config of %s: %w", host, err)
+	}
+	b.log.Info("getting cluster-config from bootstrap host", "group", b.cfg.Group)
+	clusterConfig, err := bootstrap.ClusterConfig(ctx, b.cfg.Group, serverConfig)
+	if err != nil {
+		return fmt.Errorf("getting cluster-config from %s: %s", b.cfg.BootstrapHost, describe(b.cfg.BootstrapHost, err))
+	}
+
+	b.log.Info("joining cluster", "group", b.cfg.Group)
+	if _, err := b.restartingCall(ctx, local, func(ctx context.Context) (*mlclient.Restart, error) {
+		return local.JoinCluster(ctx, clusterConfig)
+	}); err != nil {
+		return fmt.Errorf("applying cluster-config on %s: %w", host, err)
+	}
+	b.log.Info("joined group", "group", b.cfg.Group)
+	return nil
+}
+
+// waitGroup waits until the group of the host exists on the bootstrap host.
+func (b *Bootstrapper) waitGroup(ctx context.Context, bootstrap *mlclient.Client) error {
+	for i := 1; ; i++ {
+		_, err := bootstrap.GroupProperties(ctx, b.cfg.Group)
+		if err == nil {
+			b.log.Info("found the group, proceed to join the group", "group", b.cfg.Group)
+			return nil
+		}
+		if i >= groupRetries {
+			return fmt.Errorf("group %q is not available on bootstrap host %s: %s", b.cfg.Group, b.cfg.BootstrapHost, describe(b.cfg.BootstrapHost, err))
+		}
+		b.log.Info("group not found, retrying", "group", b.cfg.Group, "statusCode", mlclient.StatusCode(err), "attempt", i)
+		if err := wait(ctx, b.cfg.RetryInterval); err != nil {
+			return fmt.Errorf("waiting for group %q: %w", b.cfg.Group, err)
+		}
+	}
+}
+
+// configureGroup applies the group settings on the bootstrap host and creates
+// the group of a non-bootstrap cluster. It reports whether the group was
+// configured; hosts joining a cluster skip it, and the bootstrap host of a
+// non-bootstrap cluster only updates its group once it has joined. Failures do not stop the
+// bootstrap, like in the chart scripts, as they do not prevent the host from
+// serving.
+func (b *Bootstrapper) configureGroup(ctx context.Context) (bool, error) {
+	if !b.cfg.IsBootstrapHost() {
+		b.log.Info("not bootstrap host, skip group configuration")
+		return false, nil
+	}
+	b.log.Info("configuring group", "group", b.cfg.Group)
+	xdqpSSL := b.cfg.XdqpSSLEnabled
+	props := mlclient.GroupProperties{GroupName: b.cfg.Group, XdqpSSLEnabled: &xdqpSSL}
+
+	current, err := b.client(b.cfg.BootstrapHost, b.usesHTTPS(ctx, b.cfg.BootstrapHost), true)
+	if err != nil {
+		return false, err
+	}
+	var updateErr error
+	updated := false
+	host, err := current.HostProperties(ctx, b.cfg.FQDN())
+	switch {
+	case mlclient.IsNotFound(err):
+		b.log.Info("host has not joined the cluster yet, skip group update", "host", b.cfg.FQDN())
+	case err != nil:
+		updateErr = fmt.Errorf("getting the group of %s: %s", b.cfg.FQDN(), describe(b.cfg.BootstrapHost, err))
+	default:
+		restart, err := current.UpdateGroupProperties(ctx, host.Group, props)
+		switch {
+		case err != nil:
+			updateErr = fmt.Errorf("updating group %s: %s", host.Group, describe(b.cfg.BootstrapHost, err))
+		case restart != nil:
+			b.log.Info("group updated and a restart of all hosts in the group was triggered", "group", host.Group)
+			b.event(ctx, corev1.EventTypeNormal, marklogicv1alpha1.EventGroupRestarting, "updating group %s restarts all its hosts", host.Group)
+			updated = true
+		default:
+			b.log.Info("group updated", "group", host.Group)
+			updated = true
+		}
+	}
+
+	if b.cfg.ClusterType != ClusterTypeNonBootstrap {
+		return updated, updateErr
+	}
+	b.log.Info("creating group for the non-bootstrap cluster", "group", b.cfg.Group)
+	bootstrap, err := b.bootstrapHost()
+	if err != nil {
+		return false, errors.Join(updateErr, err)
+	}
+	if _, err := bootstrap.GroupProperties(ctx, b.cfg.Group); err == nil {
+		b.log.Info("skipping creation of group as it already exists on the MarkLogic cluster", "group", b.cfg.Group)
+		return true, updateErr
+	}
+	if err := bootstrap.CreateGroup(ctx, props); err != nil {
+		return false, errors.Join(updateErr, fmt.Errorf("creating group %s: %s", b.cfg.Group, describe(b.cfg.BootstrapHost, err)))
+	}
+	b.log.Info("successfully configured group on the MarkLogic cluster", "group", b.cfg.Group)
+	return true, updateErr
+}
+
+// configurePathBasedRouting switches the default app servers to basic
+// authentication, which HAProxy path based routing requires.
+func (b *Bootstrapper) configurePathBasedRouting(ctx context.Context) {
+	if !b.cfg.PathBasedRouting {
+		b.log.Info("path based routing is not set, skipping authentication configuration")
+		return
+	}
+	b.log.Info("path based routing is set, adapting authentication method")
+	local, err := b.local(false)
+	if err != nil {
+		b.log.Error(err, "failed to configure authentication")
+		return
+	}
+	for _, server := range []string{"Admin", "App-Services", "Manage"} {
+		_, err := local.UpdateServerProperties(ctx, server, b.cfg.Group, mlclient.ServerProperties{Authentication: "basic"})
+		b.log.Info("set authentication of app server", "server", server, "authentication", "basic", "statusCode", statusOf(err))
+	}
+}
+
+// statusOf returns the status code of a call for logging, 0 when MarkLogic
+// could not be reached.
+func statusOf(err error) int {
+	if err == nil {
+		return http.StatusNoContent
+	}
+	return mlclient.StatusCode(err)
+}
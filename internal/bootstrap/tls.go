@@ -0,0 +1,185 @@
+package bootstrap
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/marklogic/marklogic-kubernetes/internal/certs"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// certificateTemplate is the template used for the default app servers.
+const certificateTemplate = "defaultTemplate"
+
+// defaultAppServers are configured for https in order. Manage must be last
+// so that the calls configuring the others still work over http.
+var defaultAppServers = []string{"App-Services", "Admin", "Manage"}
+
+const generateCAQuery = `xquery version "1.0-ml";
+import module namespace pki = "http://marklogic.com/xdmp/pki"
+    at "/MarkLogic/pki.xqy";
+let $tid := pki:template-get-id(pki:get-template-by-name("defaultTemplate"))
+return
+    pki:generate-template-certificate-authority($tid, 365)`
+
+const templateCAQuery = `xquery version "1.0-ml";
+import module namespace pki = "http://marklogic.com/xdmp/pki"
+    at "/MarkLogic/pki.xqy";
+let $tid := pki:template-get-id(pki:get-template-by-name("defaultTemplate"))
+return
+    pki:get-template-certificate-authority($tid)`
+
+const createTempCertQuery = `xquery version "1.0-ml";
+import module namespace pki = "http://marklogic.com/xdmp/pki"
+    at "/MarkLogic/pki.xqy";
+import module namespace admin = "http://marklogic.com/xdmp/admin"
+    at "/MarkLogic/admin.xqy";
+let $tid := pki:template-get-id(pki:get-template-by-name("defaultTemplate"))
+let $config := admin:get-configuration()
+let $hostname := admin:host-get-name($config, admin:host-get-id($config, xdmp:host-name()))
+return
+    pki:generate-temporary-certificate-if-necessary($tid, 365, $hostname, (), ())`
+
+// namedCertificate returns the certificate of the host copied by the
+// copy-certs init container, or nil when self-signed certificates are used.
+func (b *Bootstrapper) namedCertificate() (*mlclient.HostCertificate, error) {
+	cert, err := os.ReadFile(filepath.Join(b.cfg.CertsDir, "tls.crt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := os.ReadFile(filepath.Join(b.cfg.CertsDir, "tls.key"))
+	if err != nil {
+		return nil, err
+	}
+	return &mlclient.HostCertificate{Cert: string(cert), PKey: string(key)}, nil
+}
+
+// configureTLS enables https on the default app servers with either the
+// named certificate of the host or a temporary certificate signed by a CA
+// generated on the bootstrap host.
+func (b *Bootstrapper) configureTLS(ctx context.Context) error {
+	ownsCluster := b.cfg.IsBootstrapHost() && b.cfg.ClusterType == ClusterTypeBootstrap
+	if ownsCluster && b.usesHTTPS(ctx, "localhost") {
+		b.log.Info("MarkLogic server has already configured HTTPS for bootstrap host")
+		return nil
+	}
+
+	b.log.Info("configuring TLS for app servers")
+	named, err := b.namedCertificate()
+	if err != nil {
+		return err
+	}
+	certType := "self-signed"
+	if named != nil {
+		certType = "named"
+	}
+	b.log.Info("configuring certificates on host", "certType", certType)
+
+	local, err := b.local(false)
+	if err != nil {
+		return err
+	}
+	if ownsCluster {
+		err := local.CreateCertificateTemplate(ctx, mlclient.CertificateTemplate{
+			TemplateName:        certificateTemplate,
+			TemplateDescription: certificateTemplate,
+			KeyType:             "rsa",
+			KeyOptions:          &mlclient.KeyOptions{KeyLength: "2048"},
+			Req: &mlclient.CertificateRequest{
+				Version: "0",
+				Subject: mlclient.CertificateSubject{OrganizationName: "MarkLogic"},
+			},
+		})
+		b.log.Info("created default certificate template", "statusCode", statusOf(err))
+		if err := wait(ctx, b.cfg.SettleDelay); err != nil {
+			return err
+		}
+	}
+
+	if named != nil {
+		// Hosts other than the bootstrap host joined a cluster that already uses https.
+		target, err := b.local(!b.cfg.IsBootstrapHost())
+		if err != nil {
+			return err
+		}
+		err = target.InsertHostCertificates(ctx, certificateTemplate, *named)
+		b.log.Info("inserted named certificate", "template", certificateTemplate, "statusCode", statusOf(err))
+		if err := wait(ctx, b.cfg.SettleDelay); err != nil {
+			return err
+		}
+	}
+
+	if b.cfg.IsBootstrapHost() {
+		if b.cfg.ClusterType == ClusterTypeBootstrap {
+			_, err := local.Eval(ctx, generateCAQuery)
+			b.log.Info("generated temporary CA certificate", "statusCode", statusOf(err))
+			if err := wait(ctx, b.cfg.SettleDelay); err != nil {
+				return err
+			}
+		}
+		for _, server := range defaultAppServers {
+			_, err := local.UpdateServerProperties(ctx, server, b.cfg.Group, mlclient.ServerProperties{SSLCertificateTemplate: certificateTemplate})
+			b.log.Info("configured SSL for app server", "server", server, "statusCode", statusOf(err))
+			if err := wait(ctx, b.cfg.SettleDelay); err != nil {
+				return err
+			}
+		}
+		if named == nil {
+			secure, err := b.local(true)
+			if err != nil {
+				return err
+			}
+			_, err = secure.Eval(ctx, createTempCertQuery)
+			b.log.Info("generated temporary certificate if necessary", "statusCode", statusOf(err))
+		}
+	}
+
+	b.log.Info("removing certificate keys")
+	keys, _ := filepath.Glob(filepath.Join(b.cfg.CertsDir, "*.key"))
+	for _, key := range keys {
+		if err := os.Remove(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishCA reads the CA certificate generated for the temporary certificates
+// of the hosts and publishes it, so clients can verify them. The CA of named
+// certificates is published by the operator.
+func (b *Bootstrapper) publishCA(ctx context.Context) error {
+	if b.cfg.CAPublisher == nil {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(b.cfg.CertsDir, "tls.crt")); err == nil {
+		return nil
+	}
+	secure, err := b.local(true)
+	if err != nil {
+		return err
+	}
+	resp, err := secure.Eval(ctx, templateCAQuery)
+	if err != nil {
+		return fmt.Errorf("reading CA certificate of %s: %w", certificateTemplate, err)
+	}
+	cas, err := certs.ParseCertificates(resp)
+	if err != nil {
+		return fmt.Errorf("reading CA certificate of %s: %w", certificateTemplate, err)
+	}
+	var data []byte
+	for _, ca := range cas {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})...)
+	}
+	if err := b.cfg.CAPublisher.PublishCA(ctx, data); err != nil {
+		return err
+	}
+	b.log.Info("published CA certificate", "subject", cas[0].Subject.String(), "notAfter", cas[0].NotAfter)
+	return nil
+}
@@ -0,0 +1,227 @@
+// Package certs selects the named certificate of a MarkLogic host among the
+// certificates mounted into its pod, and prepares the certificate directory
+// read by the bootstrap of the host.
+//
+// It is the Go implementation of the copy-certs.sh init container of the
+// chart. Unlike the script it matches the host on the DNS names of the
+// certificate as well as its common name, accepts RSA, ECDSA and Ed25519
+// keys in PKCS#1, SEC1 and PKCS#8 encodings and verifies certificates that
+// carry their intermediate CAs.
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Rejection records why a certificate file was not selected for the host.
+type Rejection struct {
+	// File is the path of the certificate.
+	File string
+	// ForHost reports whether the certificate is issued for the host, in
+	// which case it was rejected for being unusable rather than for naming
+	// another host.
+	ForHost bool
+	Reason  string
+}
+
+func (r Rejection) String() string {
+	return filepath.Base(r.File) + ": " + r.Reason
+}
+
+// Match is the certificate selected for the host.
+type Match struct {
+	CertFile string
+	KeyFile  string
+	// Leaf is the certificate of the host, the first one of CertFile.
+	Leaf *x509.Certificate
+}
+
+// Select returns the certificate among certFiles that is issued for host,
+// has its private key next to it and verifies against roots at now. The key
+// of tls_0.crt is tls_0.key. Every other certificate is returned as a
+// Rejection with its reason, in the order of certFiles.
+func Select(certFiles []string, host string, roots *x509.CertPool, now time.Time) (*Match, []Rejection) {
+	var match *Match
+	var rejections []Rejection
+	for _, file := range certFiles {
+		m, err := check(file, host, roots, now)
+		var r *rejection
+		switch {
+		case errors.As(err, &r):
+			rejections = append(rejections, Rejection{File: file, ForHost: r.forHost, Reason: r.reason})
+		case err != nil:
+			rejections = append(rejections, Rejection{File: file, Reason: err.Error()})
+		case match != nil:
+			rejections = append(rejections, Rejection{File: file, ForHost: true,
+				Reason: "another certificate for the host was already selected: " + filepath.Base(match.CertFile)})
+		default:
+			match = m
+		}
+	}
+	return match, rejections
+}
+
+// CertFiles returns the tls_*.crt files of dir, sorted.
+func CertFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "tls_*.crt"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+type rejection struct {
+	forHost bool
+	reason  string
+}
+
+func (r *rejection) Error() string {
+	return r.reason
+}
+
+func reject(forHost bool, format string, args ...interface{}) error {
+	return &rejection{forHost: forHost, reason: fmt.Sprintf(format, args...)}
+}
+
+func check(file, host string, roots *x509.CertPool, now time.Time) (*Match, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := ParseCertificates(data)
+	if err != nil {
+		return nil, reject(false, "%v", err)
+	}
+	leaf := chain[0]
+	if !IssuedFor(leaf, host) {
+		return nil, reject(false, "issued for %s, not %s", describeNames(leaf), host)
+	}
+
+	keyFile := strings.TrimSuffix(file, ".crt") + ".key"
+	keyData, err := os.ReadFile(keyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, reject(true, "private key %s not found", filepath.Base(keyFile))
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePrivateKey(keyData)
+	if err != nil {
+		return nil, reject(true, "private key %s: %v", filepath.Base(keyFile), err)
+	}
+	if !publicKeyEqual(leaf.PublicKey, key.Public()) {
+		return nil, reject(true, "private key %s does not belong to the certificate", filepath.Base(keyFile))
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, reject(true, "verification with the CA certificate failed: %v", err)
+	}
+	return &Match{CertFile: file, KeyFile: keyFile, Leaf: leaf}, nil
+}
+
+// IssuedFor reports whether cert is issued for host, through one of its DNS
+// names or, like the chart, its common name.
+func IssuedFor(cert *x509.Certificate, host string) bool {
+	if strings.EqualFold(cert.Subject.CommonName, host) {
+		return true
+	}
+	return cert.VerifyHostname(host) == nil
+}
+
+func describeNames(cert *x509.Certificate) string {
+	var names []string
+	if cert.Subject.CommonName != "" {
+		names = append(names, "CN "+cert.Subject.CommonName)
+	}
+	if len(cert.DNSNames) > 0 {
+		names = append(names, "DNS names "+strings.Join(cert.DNSNames, ", "))
+	}
+	if len(names) == 0 {
+		return "no host name"
+	}
+	return strings.Join(names, " and ")
+}
+
+// ParseCertificates returns the PEM encoded certificates of data, the leaf
+// first. Blocks of other types are ignored.
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+	return certs, nil
+}
+
+// ParsePrivateKey returns the first PEM encoded private key of data, in the
+// PKCS#1, SEC1 or PKCS#8 format.
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no PEM encoded private key found")
+		}
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			return x509.ParseECPrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			switch k := key.(type) {
+			case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+				return k.(crypto.Signer), nil
+			default:
+				return nil, fmt.Errorf("unsupported private key type %T", key)
+			}
+		case "ENCRYPTED PRIVATE KEY":
+			return nil, errors.New("encrypted private keys are not supported")
+		}
+	}
+}
+
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
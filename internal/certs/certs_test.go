@@ -0,0 +1,289 @@
+package certs
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const host = "dnode-1.dnode.marklogic.svc.cluster.local"
+
+var now = time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+type issuer struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+func rsaKey(t *testing.T) crypto.Signer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+func ecdsaKey(t *testing.T) crypto.Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return key
+}
+
+func ed25519Key(t *testing.T) crypto.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return key
+}
+
+// newCA returns a CA signed by parent, self-signed when parent is nil.
+func newCA(t *testing.T, name string, parent *issuer) *issuer {
+	key := ecdsaKey(t)
+	tmpl := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	if parent == nil {
+		parent = &issuer{cert: tmpl, key: key}
+	}
+	return &issuer{cert: parent.issue(t, key, tmpl), key: key}
+}
+
+func (ca *issuer) issue(t *testing.T, key crypto.Signer, tmpl *x509.Certificate) *x509.Certificate {
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	tmpl.SerialNumber = serial
+	if tmpl.NotBefore.IsZero() {
+		tmpl.NotBefore, tmpl.NotAfter = now.Add(-time.Hour), now.Add(365*24*time.Hour)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, key.Public(), ca.key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func certPEM(certs ...*x509.Certificate) []byte {
+	var out []byte
+	for _, c := range certs {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	return out
+}
+
+func pkcs8PEM(t *testing.T, key crypto.Signer) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func write(t *testing.T, path string, data []byte) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+}
+
+// serverCerts writes the certificates of the tests into dir as the projected
+// volume of the StatefulSet does, and returns the root CA and the CA unknown
+// to the cluster.
+func serverCerts(t *testing.T, dir string) (*issuer, *issuer) {
+	root := newCA(t, "root", nil)
+	intermediate := newCA(t, "intermediate", root)
+	other := newCA(t, "other", nil)
+
+	// tls_0 is the certificate of another host.
+	key := rsaKey(t)
+	write(t, filepath.Join(dir, "tls_0.crt"), certPEM(root.issue(t, key, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "dnode-0.dnode.marklogic.svc.cluster.local"},
+	})))
+	write(t, filepath.Join(dir, "tls_0.key"), pem.EncodeToMemory(&pem.Block{
+		Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key.(*rsa.PrivateKey)),
+	}))
+	// tls_1 names the host in its SANs only and ships the intermediate CA.
+	key = ecdsaKey(t)
+	write(t, filepath.Join(dir, "tls_1.crt"), certPEM(intermediate.issue(t, key, &x509.Certificate{
+		DNSNames: []string{"*.dnode.marklogic.svc.cluster.local"},
+	}), intermediate.cert))
+	write(t, filepath.Join(dir, "tls_1.key"), pkcs8PEM(t, key))
+	// tls_2 has the key of another certificate.
+	write(t, filepath.Join(dir, "tls_2.crt"), certPEM(root.issue(t, rsaKey(t), &x509.Certificate{
+		Subject: pkix.Name{CommonName: host},
+	})))
+	write(t, filepath.Join(dir, "tls_2.key"), pkcs8PEM(t, key))
+	// tls_3 has no key.
+	write(t, filepath.Join(dir, "tls_3.crt"), certPEM(root.issue(t, rsaKey(t), &x509.Certificate{DNSNames: []string{host}})))
+	// tls_4 is signed by an unknown CA.
+	key = ed25519Key(t)
+	write(t, filepath.Join(dir, "tls_4.crt"), certPEM(other.issue(t, key, &x509.Certificate{DNSNames: []string{host}})))
+	write(t, filepath.Join(dir, "tls_4.key"), pkcs8PEM(t, key))
+	// tls_5 expired.
+	key = ed25519Key(t)
+	write(t, filepath.Join(dir, "tls_5.crt"), certPEM(root.issue(t, key, &x509.Certificate{
+		DNSNames:  []string{host},
+		NotBefore: now.Add(-48 * time.Hour),
+		NotAfter:  now.Add(-24 * time.Hour),
+	})))
+	write(t, filepath.Join(dir, "tls_5.key"), pkcs8PEM(t, key))
+	return root, other
+}
+
+func TestSelect(t *testing.T) {
+	dir := t.TempDir()
+	root, other := serverCerts(t, dir)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	files, err := CertFiles(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 6)
+
+	match, rejections := Select(files, host, roots, now)
+	require.NotNil(t, match)
+	assert.Equal(t, filepath.Join(dir, "tls_1.crt"), match.CertFile)
+	assert.Equal(t, filepath.Join(dir, "tls_1.key"), match.KeyFile)
+	assert.Empty(t, match.Leaf.Subject.CommonName)
+
+	require.Len(t, rejections, 5)
+	assert.Equal(t, "tls_0.crt: issued for CN dnode-0.dnode.marklogic.svc.cluster.local, not "+host, rejections[0].String())
+	assert.False(t, rejections[0].ForHost)
+	assert.Equal(t, "tls_2.crt: private key tls_2.key does not belong to the certificate", rejections[1].String())
+	assert.Equal(t, "tls_3.crt: private key tls_3.key not found", rejections[2].String())
+	assert.Contains(t, rejections[3].String(), "tls_4.crt: verification with the CA certificate failed: x509: certificate signed by unknown authority")
+	assert.Contains(t, rejections[4].String(), "tls_5.crt: verification with the CA certificate failed: x509: certificate has expired")
+	for _, r := range rejections[1:] {
+		assert.True(t, r.ForHost, r.String())
+	}
+
+	// Ed25519 keys are accepted as well.
+	roots = x509.NewCertPool()
+	roots.AddCert(other.cert)
+	match, _ = Select(files, host, roots, now)
+	require.NotNil(t, match)
+	assert.Equal(t, filepath.Join(dir, "tls_4.crt"), match.CertFile)
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	key := ecdsaKey(t)
+	der, err := x509.MarshalECPrivateKey(key.(*ecdsa.PrivateKey))
+	require.NoError(t, err)
+	parsed, err := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	require.NoError(t, err)
+	assert.True(t, key.(*ecdsa.PrivateKey).Equal(parsed))
+
+	_, err = ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}))
+	assert.EqualError(t, err, "encrypted private keys are not supported")
+	_, err = ParsePrivateKey([]byte("not a key"))
+	assert.EqualError(t, err, "no PEM encoded private key found")
+}
+
+func namedOptions(t *testing.T, hostName string) Options {
+	opts := Options{
+		HostName:       hostName,
+		FQDNSuffix:     "dnode.marklogic.svc.cluster.local",
+		ServerCertsDir: t.TempDir(),
+		CACertDir:      t.TempDir(),
+		CertsDir:       t.TempDir(),
+		Now:            func() time.Time { return now },
+	}
+	root, _ := serverCerts(t, opts.ServerCertsDir)
+	write(t, filepath.Join(opts.CACertDir, caCertFile), certPEM(root.cert))
+	return opts
+}
+
+func TestCopyNamedCertificate(t *testing.T) {
+	opts := namedOptions(t, "dnode-1")
+	require.NoError(t, Copy(context.Background(), opts, logr.Discard()))
+
+	for file, src := range map[string]string{
+		"tls.crt":  filepath.Join(opts.ServerCertsDir, "tls_1.crt"),
+		"tls.key":  filepath.Join(opts.ServerCertsDir, "tls_1.key"),
+		caCertFile: filepath.Join(opts.CACertDir, caCertFile),
+	} {
+		want, err := os.ReadFile(src)
+		require.NoError(t, err)
+		got, err := os.ReadFile(filepath.Join(opts.CertsDir, file))
+		require.NoError(t, err)
+		assert.Equal(t, want, got, file)
+	}
+}
+
+func TestCopyWithoutNamedCertificate(t *testing.T) {
+	// Hosts other than the bootstrap host continue with a temporary certificate.
+	opts := namedOptions(t, "dnode-3")
+	for _, f := range []string{"tls_1.crt", "tls_2.crt", "tls_3.crt", "tls_4.crt", "tls_5.crt"} {
+		require.NoError(t, os.Remove(filepath.Join(opts.ServerCertsDir, f)))
+	}
+	require.NoError(t, Copy(context.Background(), opts, logr.Discard()))
+	assert.NoFileExists(t, filepath.Join(opts.CertsDir, "tls.crt"))
+	assert.FileExists(t, filepath.Join(opts.CertsDir, caCertFile))
+
+	opts.HostName = "dnode-2"
+	opts.FQDNSuffix = "other.marklogic.svc.cluster.local"
+	require.NoError(t, Copy(context.Background(), opts, logr.Discard()))
+
+	opts.HostName = "dnode-0"
+	err := Copy(context.Background(), opts, logr.Discard())
+	assert.EqualError(t, err, "no valid certificate for dnode-0.other.marklogic.svc.cluster.local among 1 certificates, the bootstrap host requires one")
+
+	// A certificate for the host that cannot be used fails every host.
+	opts = namedOptions(t, "dnode-1")
+	require.NoError(t, os.Remove(filepath.Join(opts.ServerCertsDir, "tls_1.crt")))
+	err = Copy(context.Background(), opts, logr.Discard())
+	assert.EqualError(t, err, "no valid certificate for "+host+" among 5 certificates")
+}
+
+func TestCopyFetchesSelfSignedCA(t *testing.T) {
+	srv := httptest.NewTLSServer(nil)
+	t.Cleanup(srv.Close)
+	addr := srv.Listener.Addr().(*net.TCPAddr)
+
+	opts := Options{
+		HostName:        "dnode-1",
+		FQDNSuffix:      "dnode.marklogic.svc.cluster.local",
+		BootstrapHost:   addr.IP.String(),
+		AppServicesPort: addr.Port,
+		ServerCertsDir:  filepath.Join(t.TempDir(), "missing"),
+		CertsDir:        t.TempDir(),
+	}
+	require.NoError(t, Copy(context.Background(), opts, logr.Discard()))
+	data, err := os.ReadFile(filepath.Join(opts.CertsDir, caCertFile))
+	require.NoError(t, err)
+	certs, err := ParseCertificates(data)
+	require.NoError(t, err)
+	assert.Equal(t, srv.Certificate().Raw, certs[0].Raw)
+
+	// The bootstrap host generates the CA itself.
+	opts.HostName = "dnode-0"
+	opts.CertsDir = t.TempDir()
+	require.NoError(t, Copy(context.Background(), opts, logr.Discard()))
+	assert.NoFileExists(t, filepath.Join(opts.CertsDir, caCertFile))
+
+	// Hosts join without the CA when the bootstrap host is not reachable.
+	opts.HostName = "dnode-2"
+	opts.AppServicesPort = freePort(t)
+	require.NoError(t, Copy(context.Background(), opts, logr.Discard()))
+	assert.NoFileExists(t, filepath.Join(opts.CertsDir, caCertFile))
+}
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	require.NoError(t, l.Close())
+	return port
+}
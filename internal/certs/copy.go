@@ -0,0 +1,186 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// Default locations of the copy-certs init container.
+const (
+	DefaultServerCertsDir = "/tmp/server-cert-secrets"
+	DefaultCACertDir      = "/tmp/ca-cert-secret"
+	DefaultCertsDir       = "/run/secrets/marklogic-certs"
+)
+
+// caCertFile is the CA certificate in the CA secret and the certificate
+// directory.
+const caCertFile = "cacert.pem"
+
+// Options configures Copy.
+type Options struct {
+	// HostName is the short host name of the pod, e.g. dnode-0.
+	HostName string
+	// FQDNSuffix is appended to HostName to build the host FQDN.
+	FQDNSuffix string
+	// BootstrapHost is the FQDN of the first host of the cluster.
+	BootstrapHost string
+	// NonBootstrap is set when the StatefulSet joins the cluster of another one.
+	NonBootstrap bool
+	// AppServicesPort is the port the CA of the bootstrap host is read from.
+	// Defaults to 8000.
+	AppServicesPort int
+
+	// ServerCertsDir holds the named certificates, tls_<n>.crt and
+	// tls_<n>.key. Self-signed certificates are used when it does not exist.
+	ServerCertsDir string
+	// CACertDir holds cacert.pem, the CA of the named certificates.
+	CACertDir string
+	// CertsDir receives tls.crt, tls.key and cacert.pem.
+	CertsDir string
+
+	// Now returns the time certificates are verified at. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// FQDN returns the fully qualified name of the host.
+func (o *Options) FQDN() string {
+	return o.HostName + "." + o.FQDNSuffix
+}
+
+func (o *Options) isBootstrapHost() bool {
+	return strings.HasSuffix(o.HostName, "-0")
+}
+
+// Copy prepares the certificate directory of the host. With named
+// certificates it copies the CA and the certificate issued for the host with
+// its key. The bootstrap host fails without a usable certificate, other
+// hosts continue with a temporary certificate generated by MarkLogic. With
+// self-signed certificates, hosts other than the bootstrap host fetch the CA
+// from the bootstrap host.
+func Copy(ctx context.Context, opts Options, log logr.Logger) error {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.AppServicesPort == 0 {
+		opts.AppServicesPort = 8000
+	}
+	certType := "self-signed"
+	if info, err := os.Stat(opts.ServerCertsDir); err == nil && info.IsDir() {
+		certType = "named"
+	}
+	log.Info("proceeding with certificate flow", "certType", certType, "host", opts.FQDN())
+	if certType == "named" {
+		return copyNamed(opts, log)
+	}
+	if opts.isBootstrapHost() && !opts.NonBootstrap {
+		return nil
+	}
+	log.Info("getting CA of the bootstrap host", "bootstrapHost", opts.BootstrapHost)
+	pemData, err := fetchCA(ctx, opts.BootstrapHost, opts.AppServicesPort)
+	if err != nil {
+		// Like the chart, the host then joins without the CA.
+		log.Error(err, "failed to get CA of the bootstrap host", "bootstrapHost", opts.BootstrapHost)
+		return nil
+	}
+	return os.WriteFile(filepath.Join(opts.CertsDir, caCertFile), pemData, 0o644)
+}
+
+func copyNamed(opts Options, log logr.Logger) error {
+	caFiles, err := os.ReadDir(opts.CACertDir)
+	if err != nil {
+		return fmt.Errorf("reading CA certificate: %w", err)
+	}
+	for _, f := range caFiles {
+		// Secret volumes hold their keys as symbolic links.
+		if f.IsDir() || strings.HasPrefix(f.Name(), "..") {
+			continue
+		}
+		if err := copyFile(filepath.Join(opts.CACertDir, f.Name()), filepath.Join(opts.CertsDir, f.Name())); err != nil {
+			return err
+		}
+	}
+	caData, err := os.ReadFile(filepath.Join(opts.CACertDir, caCertFile))
+	if err != nil {
+		return fmt.Errorf("reading CA certificate: %w", err)
+	}
+	caCerts, err := ParseCertificates(caData)
+	if err != nil {
+		return fmt.Errorf("CA certificate %s: %w", caCertFile, err)
+	}
+	roots := x509.NewCertPool()
+	for _, c := range caCerts {
+		roots.AddCert(c)
+	}
+
+	files, err := CertFiles(opts.ServerCertsDir)
+	if err != nil {
+		return err
+	}
+	host := opts.FQDN()
+	match, rejections := Select(files, host, roots, opts.Now())
+	forHost := false
+	for _, r := range rejections {
+		log.Info("rejected certificate", "certificate", filepath.Base(r.File), "reason", r.Reason)
+		forHost = forHost || r.ForHost
+	}
+	if match == nil {
+		err := fmt.Errorf("no valid certificate for %s among %d certificates", host, len(files))
+		if forHost {
+			// A certificate for the host exists but cannot be used, which
+			// a temporary certificate would only hide.
+			return err
+		}
+		if opts.isBootstrapHost() {
+			return fmt.Errorf("%w, the bootstrap host requires one", err)
+		}
+		log.Error(err, "continuing with a temporary certificate for this host, please update the certificate of this host later")
+		return nil
+	}
+	log.Info("found certificate for the host", "certificate", filepath.Base(match.CertFile),
+		"subject", match.Leaf.Subject.String(), "notAfter", match.Leaf.NotAfter)
+	if err := copyFile(match.CertFile, filepath.Join(opts.CertsDir, "tls.crt")); err != nil {
+		return err
+	}
+	// The key stays readable by the MarkLogic container, which runs as
+	// another user and removes it once it is inserted.
+	return copyFile(match.KeyFile, filepath.Join(opts.CertsDir, "tls.key"))
+}
+
+// fetchCA returns the certificates presented by the app server on port of
+// host, PEM encoded.
+func fetchCA(ctx context.Context, host string, port int) ([]byte, error) {
+	dialer := &tls.Dialer{Config: &tls.Config{ServerName: host, InsecureSkipVerify: true}} //nolint:gosec // the CA is read to be trusted later on
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var out []byte
+	for _, cert := range conn.(*tls.Conn).ConnectionState().PeerCertificates {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no certificate presented")
+	}
+	return out, nil
+}
+
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0o644)
+}
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+// appServersFor returns the defaulted MarkLogicAppServers of the cluster,
+// sorted by name so the rendered services and HAProxy configuration are stable.
+// App Servers blocked by a running MarkLogicRestore are left out.
+func appServersFor(ctx context.Context, c client.Reader, cluster *marklogicv1alpha1.MarkLogicCluster) ([]marklogicv1alpha1.MarkLogicAppServer, error) {
+	list := &marklogicv1alpha1.MarkLogicAppServerList{}
+	if err := c.List(ctx, list, client.InNamespace(cluster.Namespace)); err != nil {
+		return nil, fmt.Errorf("listing MarkLogicAppServers: %w", err)
+	}
+	var servers []marklogicv1alpha1.MarkLogicAppServer
+	for i := range list.Items {
+		s := list.Items[i].DeepCopy()
+		if s.Spec.ClusterRef.Name != cluster.Name || !s.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if _, blocked := s.Annotations[marklogicv1alpha1.AnnotationBlockedByRestore]; blocked {
+			continue
+		}
+		s.Default()
+		servers = append(servers, *s)
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Name < servers[j].Name })
+	return servers, nil
+}
+
+// appServerCluster maps a MarkLogicAppServer to the cluster exposing it.
+func appServerCluster(_ context.Context, obj client.Object) []ctrl.Request {
+	s, ok := obj.(*marklogicv1alpha1.MarkLogicAppServer)
+	if !ok {
+		return nil
+	}
+	return []ctrl.Request{{NamespacedName: types.NamespacedName{Namespace: s.Namespace, Name: s.Spec.ClusterRef.Name}}}
+}
+
+// appServerPortName names the service and HAProxy ports of an App Server. It
+// stays within the 15 characters allowed for container port names.
+func appServerPortName(s *marklogicv1alpha1.MarkLogicAppServer) string {
+	return fmt.Sprintf("%s-%d", s.Spec.ServerType, s.Spec.Port)
+}
+
+// routedByHAProxy reports whether HAProxy of cluster load balances the App Server.
+// HTTP App Servers need a path when path based routing is enabled.
+func routedByHAProxy(cluster *marklogicv1alpha1.MarkLogicCluster, s *marklogicv1alpha1.MarkLogicAppServer) bool {
+	if !cluster.Spec.HAProxy.Enabled || !*s.Spec.HAProxy.Enabled {
+		return false
+	}
+	if s.Spec.ServerType != marklogicv1alpha1.ServerTypeODBC && cluster.Spec.HAProxy.PathBased.Enabled {
+		return s.Spec.HAProxy.Path != ""
+	}
+	return true
+}
+
+// addAppServers exposes the App Servers on the services and HAProxy of the
+// cluster, the way service.additionalPorts, haproxy.additionalAppServers and
+// haproxy.tcpports of the chart values do. Ports already listed in the spec
+// are kept as they are.
+func addAppServers(c *marklogicv1alpha1.MarkLogicCluster, servers []marklogicv1alpha1.MarkLogicAppServer) {
+	exposed := map[int32]bool{}
+	for _, p := range servicePorts(c) {
+		exposed[p.Port] = true
+	}
+	proxied := map[int32]bool{}
+	for _, p := range haproxyPorts(c) {
+		proxied[p.Port] = true
+	}
+
+	for i := range servers {
+		s := &servers[i]
+		name := appServerPortName(s)
+		if !exposed[s.Spec.Port] {
+			exposed[s.Spec.Port] = true
+			c.Spec.Service.AdditionalPorts = append(c.Spec.Service.AdditionalPorts, corev1.ServicePort{
+				Name:       name,
+				Port:       s.Spec.Port,
+				TargetPort: intstr.FromInt32(s.Spec.Port),
+				Protocol:   corev1.ProtocolTCP,
+			})
+		}
+
+		if !routedByHAProxy(c, s) {
+			continue
+		}
+		entry := marklogicv1alpha1.HAProxyAppServer{
+			Name:       name,
+			Port:       s.Spec.HAProxy.Port,
+			TargetPort: s.Spec.Port,
+			Path:       s.Spec.HAProxy.Path,
+		}
+		if s.Spec.ServerType == marklogicv1alpha1.ServerTypeODBC {
+			if proxied[entry.Port] {
+				continue
+			}
+			proxied[entry.Port] = true
+			entry.Type = "TCP"
+			c.Spec.HAProxy.TCPPorts.Enabled = true
+			c.Spec.HAProxy.TCPPorts.Ports = append(c.Spec.HAProxy.TCPPorts.Ports, entry)
+			continue
+		}
+		if !c.Spec.HAProxy.PathBased.Enabled {
+			if proxied[entry.Port] {
+				continue
+			}
+			proxied[entry.Port] = true
+		}
+		entry.Type = "HTTP"
+		c.Spec.HAProxy.AdditionalAppServers = append(c.Spec.HAProxy.AdditionalAppServers, entry)
+	}
+}
@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+// bootstrapPhases maps the phases the agent annotates the pods with to the
+// phases of the cluster status.
+var bootstrapPhases = map[string]marklogicv1alpha1.BootstrapPhase{
+	"pending":            marklogicv1alpha1.BootstrapPending,
+	"initialized":        marklogicv1alpha1.BootstrapInitialized,
+	"security-installed": marklogicv1alpha1.BootstrapSecurityInstalled,
+	"group-configured":   marklogicv1alpha1.BootstrapGroupConfigured,
+	"joined":             marklogicv1alpha1.BootstrapJoined,
+	"tls-configured":     marklogicv1alpha1.BootstrapTLSConfigured,
+	"completed":          marklogicv1alpha1.BootstrapCompleted,
+}
+
+// statusReporterName returns the Role and RoleBinding allowing the pods to
+// report their bootstrap phases and lifecycle Events.
+func statusReporterName(c *marklogicv1alpha1.MarkLogicCluster) string {
+	return c.Name + "-status-reporter"
+}
+
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// reconcileStatusReporter allows the agent to annotate the pods of the
+// StatefulSet with the progress of their bootstrap, and to record Events on
+// them and on the cluster. The Role names the pods and the cluster so that a
+// host cannot read or change other objects of the namespace.
+func (r *MarkLogicClusterReconciler) reconcileStatusReporter(ctx context.Context, c *marklogicv1alpha1.MarkLogicCluster) error {
+	role := &rbacv1.Role{ObjectMeta: r.objectMeta(c, statusReporterName(c))}
+	binding := &rbacv1.RoleBinding{ObjectMeta: r.objectMeta(c, statusReporterName(c))}
+
+	if c.Spec.Agent.Image == "" {
+		for _, obj := range []client.Object{binding, role} {
+			if err := r.deleteOwned(ctx, c, obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	pods := make([]string, 0, *c.Spec.ReplicaCount)
+	for i := 0; i < int(*c.Spec.ReplicaCount); i++ {
+		pods = append(pods, podName(c, i))
+	}
+	if err := r.createOrUpdate(ctx, c, role, func() error {
+		role.Labels = mergeMaps(role.Labels, labels(c))
+		role.Rules = []rbacv1.PolicyRule{{
+			APIGroups:     []string{""},
+			Resources:     []string{"pods"},
+			ResourceNames: pods,
+			Verbs:         []string{"get", "patch"},
+		}, {
+			APIGroups:     []string{marklogicv1alpha1.GroupVersion.Group},
+			Resources:     []string{"marklogicclusters"},
+			ResourceNames: []string{c.Name},
+			Verbs:         []string{"get"},
+		}, {
+			APIGroups: []string{""},
+			Resources: []string{"events"},
+			Verbs:     []string{"create"},
+		}}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return r.createOrUpdate(ctx, c, binding, func() error {
+		binding.Labels = mergeMaps(binding.Labels, labels(c))
+		binding.RoleRef = rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: role.Name}
+		binding.Subjects = []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: serviceAccountName(c), Namespace: c.Namespace}}
+		return nil
+	})
+}
+
+// setBootstrapStatus collects the bootstrap phases the agent reported on the
+// pods into the status of the cluster. Without the agent nothing reports them.
+func (r *MarkLogicClusterReconciler) setBootstrapStatus(ctx context.Context, cluster, desired *marklogicv1alpha1.MarkLogicCluster) error {
+	if desired.Spec.Agent.Image == "" {
+		cluster.Status.Bootstrap = nil
+		meta.RemoveStatusCondition(&cluster.Status.Conditions, marklogicv1alpha1.ConditionBootstrapped)
+		return nil
+	}
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(desired.Namespace), client.MatchingLabels(selectorLabels(desired))); err != nil {
+		return err
+	}
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return podOrdinal(pods.Items[i].Name) < podOrdinal(pods.Items[j].Name)
+	})
+
+	hosts := make([]marklogicv1alpha1.HostBootstrapStatus, 0, len(pods.Items))
+	for i := range pods.Items {
+		hosts = append(hosts, hostBootstrapStatus(&pods.Items[i]))
+	}
+	cluster.Status.Bootstrap = hosts
+	meta.SetStatusCondition(&cluster.Status.Conditions, bootstrappedCondition(hosts, *desired.Spec.ReplicaCount, cluster.Generation))
+	return nil
+}
+
+// hostBootstrapStatus reads the bootstrap annotations of pod. Pods the agent
+// did not annotate yet are pending.
+func hostBootstrapStatus(pod *corev1.Pod) marklogicv1alpha1.HostBootstrapStatus {
+	a := pod.Annotations
+	host := marklogicv1alpha1.HostBootstrapStatus{
+		Pod:     pod.Name,
+		Phase:   marklogicv1alpha1.BootstrapPending,
+		Message: a[annotationBootstrapError],
+	}
+	if phase, ok := bootstrapPhases[a[annotationBootstrapPhase]]; ok {
+		host.Phase = phase
+	}
+	if t, err := time.Parse(time.RFC3339, a[annotationBootstrapTime]); err == nil {
+		host.LastTransitionTime = &metav1.Time{Time: t}
+	}
+	return host
+}
+
+// bootstrappedCondition tells whether all replicas completed their bootstrap,
+// naming the hosts in progress with their phase and the ones that failed
+// with the reason.
+func bootstrappedCondition(hosts []marklogicv1alpha1.HostBootstrapStatus, replicas int32, generation int64) metav1.Condition {
+	completed := 0
+	failed := false
+	var pending []string
+	for _, h := range hosts {
+		switch {
+		case h.Message != "":
+			failed = true
+			pending = append(pending, fmt.Sprintf("%s failed after %s: %s", h.Pod, h.Phase, h.Message))
+		case h.Phase == marklogicv1alpha1.BootstrapCompleted:
+			completed++
+		default:
+			pending = append(pending, fmt.Sprintf("%s at %s", h.Pod, h.Phase))
+		}
+	}
+	condition := metav1.Condition{
+		Type:               marklogicv1alpha1.ConditionBootstrapped,
+		Status:             metav1.ConditionFalse,
+		Reason:             "BootstrapInProgress",
+		Message:            fmt.Sprintf("%d of %d hosts bootstrapped", completed, replicas),
+		ObservedGeneration: generation,
+	}
+	if len(pending) > 0 {
+		condition.Message += ", " + strings.Join(pending, ", ")
+	}
+	switch {
+	case failed:
+		condition.Reason = "BootstrapFailed"
+	case completed >= int(replicas):
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "HostsBootstrapped"
+	}
+	return condition
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+// bootstrapPod returns a pod of c annotated with the bootstrap phase the
+// agent reported, and the failure of the next step if any.
+func bootstrapPod(c *marklogicv1alpha1.MarkLogicCluster, ordinal int, phase, failure string) *corev1.Pod {
+	pod := upgradePod(c, ordinal, "dnode-1", oldImage)
+	pod.Annotations = map[string]string{
+		annotationBootstrapPhase: phase,
+		annotationBootstrapTime:  "2024-05-01T10:00:00Z",
+	}
+	if failure != "" {
+		pod.Annotations[annotationBootstrapError] = failure
+	}
+	return pod
+}
+
+func TestReconcileReportsBootstrapPhases(t *testing.T) {
+	cluster := newCluster("dnode")
+	cluster.Spec.ReplicaCount = ptr.To[int32](3)
+	cluster.Spec.Agent.Image = "marklogic-operator:latest"
+	r := newReconciler(cluster,
+		bootstrapPod(cluster, 0, "completed", ""),
+		bootstrapPod(cluster, 1, "joined", ""),
+		bootstrapPod(cluster, 2, "initialized", "dnode-0 rejected the admin credentials"))
+	reconcile(t, r, "dnode")
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "marklogic", Name: "dnode"}
+
+	current := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, r.Get(ctx, key, current))
+	require.Len(t, current.Status.Bootstrap, 3)
+	assert.Equal(t, marklogicv1alpha1.BootstrapCompleted, current.Status.Bootstrap[0].Phase)
+	assert.Equal(t, marklogicv1alpha1.BootstrapJoined, current.Status.Bootstrap[1].Phase)
+	host := current.Status.Bootstrap[2]
+	assert.Equal(t, "dnode-2", host.Pod)
+	assert.Equal(t, marklogicv1alpha1.BootstrapInitialized, host.Phase)
+	assert.Equal(t, "dnode-0 rejected the admin credentials", host.Message)
+	assert.True(t, time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC).Equal(host.LastTransitionTime.Time))
+	requireCondition(t, current.Status.Conditions, marklogicv1alpha1.ConditionBootstrapped, metav1.ConditionFalse, "BootstrapFailed")
+	cond := meta.FindStatusCondition(current.Status.Conditions, marklogicv1alpha1.ConditionBootstrapped)
+	assert.Equal(t, "1 of 3 hosts bootstrapped, dnode-1 at Joined, dnode-2 failed after Initialized: dnode-0 rejected the admin credentials", cond.Message)
+
+	// The Role only lets the hosts annotate the pods of the StatefulSet and
+	// record Events on them and on the cluster.
+	role := &rbacv1.Role{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode-status-reporter"}, role))
+	assert.Equal(t, []string{"dnode-0", "dnode-1", "dnode-2"}, role.Rules[0].ResourceNames)
+	assert.Equal(t, []string{"get", "patch"}, role.Rules[0].Verbs)
+	assert.Equal(t, []string{"dnode"}, role.Rules[1].ResourceNames)
+	assert.Equal(t, []string{"get"}, role.Rules[1].Verbs)
+	assert.Equal(t, []string{"events"}, role.Rules[2].Resources)
+	binding := &rbacv1.RoleBinding{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode-status-reporter"}, binding))
+	assert.Equal(t, "dnode", binding.Subjects[0].Name)
+
+	for i := 1; i < 3; i++ {
+		pod := &corev1.Pod{}
+		require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: podName(cluster, i)}, pod))
+		pod.Annotations = map[string]string{annotationBootstrapPhase: "completed"}
+		require.NoError(t, r.Update(ctx, pod))
+	}
+	reconcile(t, r, "dnode")
+	require.NoError(t, r.Get(ctx, key, current))
+	requireCondition(t, current.Status.Conditions, marklogicv1alpha1.ConditionBootstrapped, metav1.ConditionTrue, "HostsBootstrapped")
+	assert.Nil(t, current.Status.Bootstrap[2].LastTransitionTime)
+	assert.Empty(t, current.Status.Bootstrap[2].Message)
+
+	// Without the agent no host reports its phases.
+	current.Spec.Agent.Image = ""
+	require.NoError(t, r.Update(ctx, current))
+	reconcile(t, r, "dnode")
+	require.NoError(t, r.Get(ctx, key, current))
+	assert.Nil(t, current.Status.Bootstrap)
+	assert.Nil(t, meta.FindStatusCondition(current.Status.Conditions, marklogicv1alpha1.ConditionBootstrapped))
+	assert.True(t, apierrors.IsNotFound(r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode-status-reporter"}, role)))
+}
+
+func TestBootstrappedCondition(t *testing.T) {
+	hosts := []marklogicv1alpha1.HostBootstrapStatus{
+		{Pod: "dnode-0", Phase: marklogicv1alpha1.BootstrapCompleted},
+		{Pod: "dnode-1", Phase: marklogicv1alpha1.BootstrapPending},
+	}
+	cond := bootstrappedCondition(hosts, 3, 1)
+	assert.Equal(t, metav1.ConditionFalse, cond.Status)
+	assert.Equal(t, "BootstrapInProgress", cond.Reason)
+	assert.Equal(t, "1 of 3 hosts bootstrapped, dnode-1 at Pending", cond.Message)
+
+	// Pods the StatefulSet did not create yet are not bootstrapped.
+	cond = bootstrappedCondition(hosts[:1], 3, 1)
+	assert.Equal(t, metav1.ConditionFalse, cond.Status)
+	assert.Equal(t, "1 of 3 hosts bootstrapped", cond.Message)
+
+	cond = bootstrappedCondition(hosts[:1], 1, 1)
+	assert.Equal(t, metav1.ConditionTrue, cond.Status)
+	assert.Equal(t, "1 of 1 hosts bootstrapped", cond.Message)
+}
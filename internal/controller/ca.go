@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/internal/certs"
+)
+
+const (
+	// caKey is the key of the CA certificate in the config map, the one
+	// bootstrap.ConfigMapPublisher sets.
+	caKey = "ca.crt"
+	// haproxyCAPath is where HAProxy finds the CA certificate of the cluster.
+	haproxyCAPath = "/usr/local/etc/haproxy/ca/"
+)
+
+// caConfigMapName returns the config map publishing the CA certificate of
+// the cluster as ca.crt.
+func caConfigMapName(c *marklogicv1alpha1.MarkLogicCluster) string {
+	return c.Name + "-ca"
+}
+
+// caPublisherName returns the Role and RoleBinding allowing the pods to
+// publish the generated CA certificate.
+func caPublisherName(c *marklogicv1alpha1.MarkLogicCluster) string {
+	return c.Name + "-ca-publisher"
+}
+
+// publishesCA reports whether the bootstrap host publishes the CA it
+// generates for the temporary certificates of the hosts.
+func publishesCA(c *marklogicv1alpha1.MarkLogicCluster) bool {
+	return c.Spec.TLS.EnableOnDefaultAppServers && !namedCertificates(c) && c.Spec.BootstrapHostName == ""
+}
+
+// reconcileCAExport maintains the config map publishing the CA certificate of
+// the cluster. The CA of named certificates is copied from its secret; the
+// CA generated for temporary certificates is published by the bootstrap
+// host, which a Role allows to patch this config map only.
+func (r *MarkLogicClusterReconciler) reconcileCAExport(ctx context.Context, c *marklogicv1alpha1.MarkLogicCluster) error {
+	cm := &corev1.ConfigMap{ObjectMeta: r.objectMeta(c, caConfigMapName(c))}
+	role := &rbacv1.Role{ObjectMeta: r.objectMeta(c, caPublisherName(c))}
+	binding := &rbacv1.RoleBinding{ObjectMeta: r.objectMeta(c, caPublisherName(c))}
+
+	if !c.Spec.TLS.EnableOnDefaultAppServers {
+		for _, obj := range []client.Object{binding, role, cm} {
+			if err := r.deleteOwned(ctx, c, obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var ca string
+	if name := caSecretName(c); name != "" {
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: c.Namespace, Name: name}, secret); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		ca = string(secret.Data["cacert.pem"])
+	}
+	if err := r.createOrUpdate(ctx, c, cm, func() error {
+		cm.Labels = mergeMaps(cm.Labels, labels(c))
+		if ca != "" {
+			cm.Data = map[string]string{caKey: ca}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if !publishesCA(c) {
+		for _, obj := range []client.Object{binding, role} {
+			if err := r.deleteOwned(ctx, c, obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := r.createOrUpdate(ctx, c, role, func() error {
+		role.Labels = mergeMaps(role.Labels, labels(c))
+		role.Rules = []rbacv1.PolicyRule{{
+			APIGroups:     []string{""},
+			Resources:     []string{"configmaps"},
+			ResourceNames: []string{caConfigMapName(c)},
+			Verbs:         []string{"get", "patch"},
+		}}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return r.createOrUpdate(ctx, c, binding, func() error {
+		binding.Labels = mergeMaps(binding.Labels, labels(c))
+		binding.RoleRef = rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: role.Name}
+		binding.Subjects = []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: serviceAccountName(c), Namespace: c.Namespace}}
+		return nil
+	})
+}
+
+// publishedCA returns the CA certificate published for the cluster, or an
+// empty string while there is none.
+func publishedCA(ctx context.Context, c client.Reader, cluster *marklogicv1alpha1.MarkLogicCluster) (string, error) {
+	cm := &corev1.ConfigMap{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: cluster.Namespace, Name: caConfigMapName(cluster)}, cm); err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+	return cm.Data[caKey], nil
+}
+
+// publishedCATLSConfig verifies the temporary certificates of the hosts with
+// the CA published by the bootstrap host. They carry the host name in their
+// common name only, which crypto/tls does not accept, so the chain and the
+// host name are verified separately.
+func publishedCATLSConfig(ca string) (*tls.Config, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(ca)) {
+		return nil, errors.New("the published CA certificate is not PEM encoded")
+	}
+	return &tls.Config{
+		InsecureSkipVerify: true, //nolint:gosec // verified by VerifyConnection
+		MinVersion:         tls.VersionTLS12,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("no certificate presented")
+			}
+			opts := x509.VerifyOptions{Roots: pool, Intermediates: x509.NewCertPool()}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			leaf := cs.PeerCertificates[0]
+			if _, err := leaf.Verify(opts); err != nil {
+				return err
+			}
+			if !certs.IssuedFor(leaf, cs.ServerName) {
+				return fmt.Errorf("certificate of %s is issued for %s", cs.ServerName, leaf.Subject.CommonName)
+			}
+			return nil
+		},
+	}, nil
+}
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+func TestReconcileExportsCA(t *testing.T) {
+	cluster := newCluster("dnode")
+	cluster.Spec.TLS.EnableOnDefaultAppServers = true
+	cluster.Spec.HAProxy.Enabled = true
+	r := newReconciler(cluster)
+	ctx := context.Background()
+	key := func(name string) types.NamespacedName {
+		return types.NamespacedName{Namespace: "marklogic", Name: name}
+	}
+
+	// The bootstrap host may publish the generated CA into the config map.
+	reconcile(t, r, "dnode")
+	cm := &corev1.ConfigMap{}
+	require.NoError(t, r.Get(ctx, key("dnode-ca"), cm))
+	require.Empty(t, cm.Data)
+	role := &rbacv1.Role{}
+	require.NoError(t, r.Get(ctx, key("dnode-ca-publisher"), role))
+	require.Equal(t, []rbacv1.PolicyRule{{
+		APIGroups: []string{""}, Resources: []string{"configmaps"}, ResourceNames: []string{"dnode-ca"}, Verbs: []string{"get", "patch"},
+	}}, role.Rules)
+	binding := &rbacv1.RoleBinding{}
+	require.NoError(t, r.Get(ctx, key("dnode-ca-publisher"), binding))
+	require.Equal(t, []rbacv1.Subject{{Kind: "ServiceAccount", Name: "dnode", Namespace: "marklogic"}}, binding.Subjects)
+	env := &corev1.ConfigMap{}
+	require.NoError(t, r.Get(ctx, key("dnode"), env))
+	require.Equal(t, "dnode-ca", env.Data["MARKLOGIC_CA_CONFIGMAP"])
+	haproxy := &corev1.ConfigMap{}
+	require.NoError(t, r.Get(ctx, key("dnode-haproxy"), haproxy))
+	require.Contains(t, haproxy.Data[haproxyConfigKey], "cookie dnode-admin-0 ssl verify none")
+
+	// Once it is published, HAProxy and the operator verify the hosts with it.
+	ca := newTestCA(t)
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}))
+	cm.Data = map[string]string{"ca.crt": caPEM}
+	require.NoError(t, r.Update(ctx, cm))
+	reconcile(t, r, "dnode")
+	require.NoError(t, r.Get(ctx, key("dnode-ca"), cm))
+	require.Equal(t, caPEM, cm.Data["ca.crt"], "the published CA is kept")
+	require.NoError(t, r.Get(ctx, key("dnode-haproxy"), haproxy))
+	require.Contains(t, haproxy.Data[haproxyConfigKey],
+		"cookie dnode-admin-0 ssl verify required ca-file /usr/local/etc/haproxy/ca/ca.crt verifyhost dnode-0.dnode.marklogic.svc.cluster.local\n")
+	dep := &appsv1.Deployment{}
+	require.NoError(t, r.Get(ctx, key("dnode-haproxy"), dep))
+	require.Contains(t, dep.Spec.Template.Spec.Volumes, corev1.Volume{
+		Name: "ca-certificate",
+		VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "dnode-ca"},
+		}},
+	})
+
+	updated := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cluster), updated))
+	cfg, err := clusterTLSConfig(ctx, r, updated)
+	require.NoError(t, err)
+	// Temporary certificates name the host in their common name only.
+	crt, pkey := ca.issue(t, "dnode-0.dnode.marklogic.svc.cluster.local", nil, scheduleCreated.AddDate(100, 0, 0))
+	require.NoError(t, handshake(t, cfg, crt, pkey, "dnode-0.dnode.marklogic.svc.cluster.local"))
+	require.ErrorContains(t, handshake(t, cfg, crt, pkey, "dnode-1.dnode.marklogic.svc.cluster.local"), "issued for dnode-0")
+	crt, pkey = newTestCA(t).issue(t, "dnode-0.dnode.marklogic.svc.cluster.local", nil, scheduleCreated.AddDate(100, 0, 0))
+	require.ErrorContains(t, handshake(t, cfg, crt, pkey, "dnode-0.dnode.marklogic.svc.cluster.local"), "unknown authority")
+
+	// Turning TLS off removes the config map and the permission to publish.
+	updated.Spec.TLS.EnableOnDefaultAppServers = false
+	require.NoError(t, r.Update(ctx, updated))
+	reconcile(t, r, "dnode")
+	require.True(t, apierrors.IsNotFound(r.Get(ctx, key("dnode-ca"), cm)))
+	require.True(t, apierrors.IsNotFound(r.Get(ctx, key("dnode-ca-publisher"), role)))
+	require.True(t, apierrors.IsNotFound(r.Get(ctx, key("dnode-ca-publisher"), binding)))
+}
+
+func TestReconcileExportsCAOfNamedCertificates(t *testing.T) {
+	cluster := newCluster("dnode")
+	cluster.Spec.TLS.EnableOnDefaultAppServers = true
+	cluster.Spec.TLS.CertSecretNames = []string{"cert-0"}
+	cluster.Spec.TLS.CASecretName = "ca-cert"
+	ca := newTestCA(t).secret("ca-cert")
+	r := newReconciler(cluster, ca)
+	ctx := context.Background()
+
+	reconcile(t, r, "dnode")
+	cm := &corev1.ConfigMap{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode-ca"}, cm))
+	require.Equal(t, string(ca.Data["cacert.pem"]), cm.Data["ca.crt"])
+	err := r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode-ca-publisher"}, &rbacv1.Role{})
+	require.True(t, apierrors.IsNotFound(err), "the pods do not publish named CAs")
+	env := &corev1.ConfigMap{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode"}, env))
+	require.NotContains(t, env.Data, "MARKLOGIC_CA_CONFIGMAP")
+}
+
+// handshake connects with cfg to a TLS server presenting crt for host.
+func handshake(t *testing.T, cfg *tls.Config, crt, key []byte, host string) error {
+	t.Helper()
+	pair, err := tls.X509KeyPair(crt, key)
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{pair}, MinVersion: tls.VersionTLS12}).Handshake()
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	cfg = cfg.Clone()
+	cfg.ServerName = host
+	return tls.Client(conn, cfg).Handshake()
+}
@@ -0,0 +1,202 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+// certificateGVK is the cert-manager Certificate. cert-manager is not a
+// dependency of the operator, so Certificates are unstructured objects.
+var certificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+// certificateName returns the name of the cert-manager Certificate of the pod
+// with the given ordinal.
+func certificateName(c *marklogicv1alpha1.MarkLogicCluster, ordinal int) string {
+	return podName(c, ordinal)
+}
+
+// certificateSecretName returns the secret cert-manager stores the certificate
+// of the pod with the given ordinal in.
+func certificateSecretName(c *marklogicv1alpha1.MarkLogicCluster, ordinal int) string {
+	return podName(c, ordinal) + "-tls"
+}
+
+// serverCertsSecretName returns the secret collecting the certificates issued
+// by cert-manager as tls_<ordinal>.crt and tls_<ordinal>.key.
+func serverCertsSecretName(c *marklogicv1alpha1.MarkLogicCluster) string {
+	return c.Name + "-server-certs"
+}
+
+// caSecretName returns the secret holding cacert.pem, the CA of the named
+// certificates. With cert-manager it defaults to a secret collecting the CAs
+// of the issued certificates.
+func caSecretName(c *marklogicv1alpha1.MarkLogicCluster) string {
+	if c.Spec.TLS.CASecretName == "" && c.Spec.TLS.CertManager != nil {
+		return c.Name + "-ca-cert"
+	}
+	return c.Spec.TLS.CASecretName
+}
+
+// namedCertificates reports whether the hosts use named certificates instead
+// of the temporary ones generated by MarkLogic.
+func namedCertificates(c *marklogicv1alpha1.MarkLogicCluster) bool {
+	return len(c.Spec.TLS.CertSecretNames) > 0 || c.Spec.TLS.CertManager != nil
+}
+
+// certSecretNames returns the secrets holding the named certificates of the
+// hosts, each with the keys tls.crt and tls.key.
+func certSecretNames(c *marklogicv1alpha1.MarkLogicCluster) []string {
+	if c.Spec.TLS.CertManager == nil {
+		return c.Spec.TLS.CertSecretNames
+	}
+	names := make([]string, *c.Spec.ReplicaCount)
+	for i := range names {
+		names[i] = certificateSecretName(c, i)
+	}
+	return names
+}
+
+func newCertificate(c *marklogicv1alpha1.MarkLogicCluster, name string) *unstructured.Unstructured {
+	cert := &unstructured.Unstructured{}
+	cert.SetGroupVersionKind(certificateGVK)
+	cert.SetNamespace(c.Namespace)
+	cert.SetName(name)
+	return cert
+}
+
+// mutateCertificate sets the spec of the Certificate of the pod with the given
+// ordinal. The certificate is issued for the FQDN of the host, which MarkLogic
+// expects as common name.
+func mutateCertificate(c *marklogicv1alpha1.MarkLogicCluster, cert *unstructured.Unstructured, ordinal int) {
+	cm := c.Spec.TLS.CertManager
+	secretLabels := map[string]interface{}{}
+	for k, v := range labels(c) {
+		secretLabels[k] = v
+	}
+	spec := map[string]interface{}{
+		"secretName": certificateSecretName(c, ordinal),
+		"commonName": hostFQDN(c, ordinal),
+		"dnsNames":   []interface{}{hostFQDN(c, ordinal)},
+		"usages":     []interface{}{"server auth", "client auth"},
+		"issuerRef": map[string]interface{}{
+			"name":  cm.IssuerRef.Name,
+			"kind":  cm.IssuerRef.Kind,
+			"group": cm.IssuerRef.Group,
+		},
+		"privateKey": map[string]interface{}{
+			"algorithm":      cm.PrivateKeyAlgorithm,
+			"rotationPolicy": "Always",
+		},
+		// The labels let the operator notice issued and renewed certificates.
+		"secretTemplate": map[string]interface{}{"labels": secretLabels},
+	}
+	if cm.Duration != nil {
+		spec["duration"] = cm.Duration.Duration.String()
+	}
+	cert.SetLabels(mergeMaps(cert.GetLabels(), labels(c)))
+	cert.Object["spec"] = spec
+}
+
+// reconcileCertificates requests a cert-manager Certificate for every pod of
+// the cluster, deletes those of removed pods, and collects the issued
+// certificates into the secrets the copy-certs init container reads. It
+// returns how many pods, counted from the first ordinal, have a certificate.
+func (r *MarkLogicClusterReconciler) reconcileCertificates(ctx context.Context, c *marklogicv1alpha1.MarkLogicCluster) (int32, error) {
+	replicas := *c.Spec.ReplicaCount
+	for i := 0; i < int(replicas); i++ {
+		cert := newCertificate(c, certificateName(c, i))
+		if err := r.createOrUpdate(ctx, c, cert, func() error {
+			mutateCertificate(c, cert, i)
+			return nil
+		}); err != nil {
+			return 0, err
+		}
+	}
+
+	certs := &unstructured.UnstructuredList{}
+	certs.SetGroupVersionKind(certificateGVK.GroupVersion().WithKind(certificateGVK.Kind + "List"))
+	if err := r.List(ctx, certs, client.InNamespace(c.Namespace), client.MatchingLabels(selectorLabels(c))); err != nil {
+		return 0, fmt.Errorf("listing certificates: %w", err)
+	}
+	for i := range certs.Items {
+		cert := &certs.Items[i]
+		ordinal := podOrdinal(cert.GetName())
+		if ordinal < int(replicas) || !metav1.IsControlledBy(cert, c) {
+			continue
+		}
+		if err := r.Delete(ctx, cert); err != nil && !apierrors.IsNotFound(err) {
+			return 0, fmt.Errorf("deleting certificate %s: %w", cert.GetName(), err)
+		}
+		// cert-manager leaves the secret, which holds the private key, behind.
+		secret := &corev1.Secret{ObjectMeta: r.objectMeta(c, certificateSecretName(c, ordinal))}
+		if err := r.Delete(ctx, secret); err != nil && !apierrors.IsNotFound(err) {
+			return 0, fmt.Errorf("deleting secret %s: %w", secret.Name, err)
+		}
+	}
+
+	data := map[string][]byte{}
+	var cas [][]byte
+	var issued int32
+	for ; issued < replicas; issued++ {
+		secret := &corev1.Secret{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: c.Namespace, Name: certificateSecretName(c, int(issued))}, secret)
+		if apierrors.IsNotFound(err) || (err == nil && (len(secret.Data["tls.crt"]) == 0 || len(secret.Data["tls.key"]) == 0)) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		data[fmt.Sprintf("tls_%d.crt", issued)] = secret.Data["tls.crt"]
+		data[fmt.Sprintf("tls_%d.key", issued)] = secret.Data["tls.key"]
+		// While a renewed CA is rolled out, certificates of both CAs are in use.
+		if ca := secret.Data["ca.crt"]; len(ca) > 0 && !containsBytes(cas, ca) {
+			cas = append(cas, ca)
+		}
+	}
+
+	server := &corev1.Secret{ObjectMeta: r.objectMeta(c, serverCertsSecretName(c))}
+	if err := r.createOrUpdate(ctx, c, server, func() error {
+		server.Labels = mergeMaps(server.Labels, labels(c))
+		server.Type = corev1.SecretTypeOpaque
+		server.Data = data
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	if c.Spec.TLS.CASecretName != "" || issued == 0 {
+		return issued, nil
+	}
+	if len(cas) == 0 {
+		return 0, fmt.Errorf("the issuer of the host certificates provides no CA certificate in ca.crt, please set tls.caSecretName")
+	}
+	ca := &corev1.Secret{ObjectMeta: r.objectMeta(c, caSecretName(c))}
+	if err := r.createOrUpdate(ctx, c, ca, func() error {
+		ca.Labels = mergeMaps(ca.Labels, labels(c))
+		ca.Type = corev1.SecretTypeOpaque
+		ca.Data = map[string][]byte{"cacert.pem": bytes.Join(cas, nil)}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return issued, nil
+}
+
+func containsBytes(list [][]byte, b []byte) bool {
+	for _, e := range list {
+		if bytes.Equal(e, b) {
+			return true
+		}
+	}
+	return false
+}
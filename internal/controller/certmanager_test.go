@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+// fakeIssuer issues the cert-manager Certificates of a namespace the way a
+// cert-manager CA Issuer does: it writes the secret of every Certificate
+// with the certificate, its key and the CA.
+type fakeIssuer struct {
+	t  *testing.T
+	c  client.Client
+	ca *testCA
+}
+
+func (i *fakeIssuer) issue() {
+	i.t.Helper()
+	ctx := context.Background()
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(certificateGVK.GroupVersion().WithKind("CertificateList"))
+	require.NoError(i.t, i.c.List(ctx, list, client.InNamespace("marklogic")))
+	for _, cert := range list.Items {
+		secretName, _, _ := unstructured.NestedString(cert.Object, "spec", "secretName")
+		commonName, _, _ := unstructured.NestedString(cert.Object, "spec", "commonName")
+		dnsNames, _, _ := unstructured.NestedStringSlice(cert.Object, "spec", "dnsNames")
+		labels, _, _ := unstructured.NestedStringMap(cert.Object, "spec", "secretTemplate", "labels")
+		if err := i.c.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: secretName}, &corev1.Secret{}); err == nil {
+			continue
+		}
+		crt, key := i.ca.issue(i.t, commonName, dnsNames, scheduleCreated.AddDate(1, 0, 0))
+		require.NoError(i.t, i.c.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: "marklogic", Labels: labels},
+			Type:       corev1.SecretTypeTLS,
+			Data: map[string][]byte{
+				"tls.crt": crt,
+				"tls.key": key,
+				"ca.crt":  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: i.ca.cert.Raw}),
+			},
+		}))
+	}
+}
+
+func TestReconcileRequestsCertificatesFromCertManager(t *testing.T) {
+	cluster := newCluster("dnode")
+	cluster.Spec.ReplicaCount = ptr.To[int32](2)
+	cluster.Spec.ScaleDown.Managed = ptr.To(false)
+	cluster.Spec.TLS.EnableOnDefaultAppServers = true
+	cluster.Spec.TLS.CertManager = &marklogicv1alpha1.CertManager{
+		IssuerRef: marklogicv1alpha1.IssuerRef{Name: "marklogic-ca", Kind: "ClusterIssuer"},
+		Duration:  &metav1.Duration{Duration: 90 * 24 * time.Hour},
+	}
+	r := newReconciler(cluster)
+	issuer := &fakeIssuer{t: t, c: r.Client, ca: newTestCA(t)}
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "marklogic", Name: "dnode"}
+
+	// Pods wait for their certificates.
+	reconcile(t, r, "dnode")
+	cert := newCertificate(cluster, "dnode-1")
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cert), cert))
+	require.True(t, metav1.IsControlledBy(cert, cluster))
+	spec := cert.Object["spec"].(map[string]interface{})
+	require.Equal(t, "dnode-1-tls", spec["secretName"])
+	require.Equal(t, "dnode-1.dnode.marklogic.svc.cluster.local", spec["commonName"])
+	require.Equal(t, []interface{}{"dnode-1.dnode.marklogic.svc.cluster.local"}, spec["dnsNames"])
+	require.Equal(t, "2160h0m0s", spec["duration"])
+	require.Equal(t, map[string]interface{}{"name": "marklogic-ca", "kind": "ClusterIssuer", "group": "cert-manager.io"}, spec["issuerRef"])
+	require.Equal(t, "RSA", spec["privateKey"].(map[string]interface{})["algorithm"])
+	sts := &appsv1.StatefulSet{}
+	require.NoError(t, r.Get(ctx, key, sts))
+	require.Equal(t, int32(0), *sts.Spec.Replicas)
+	updated := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, r.Get(ctx, key, updated))
+	require.Equal(t, "0 of 2 MarkLogic pods are ready", meta.FindStatusCondition(updated.Status.Conditions, marklogicv1alpha1.ConditionAvailable).Message)
+
+	// Issued certificates are collected into the secrets mounted by copy-certs.
+	issuer.issue()
+	reconcile(t, r, "dnode")
+	require.NoError(t, r.Get(ctx, key, sts))
+	require.Equal(t, int32(2), *sts.Spec.Replicas)
+	volumes := map[string]string{}
+	for _, v := range sts.Spec.Template.Spec.Volumes {
+		if v.Secret != nil {
+			volumes[v.Name] = v.Secret.SecretName
+		}
+	}
+	require.Equal(t, "dnode-server-certs", volumes[volumeServerCerts])
+	require.Equal(t, "dnode-ca-cert", volumes[volumeCACert])
+	server := &corev1.Secret{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode-server-certs"}, server))
+	require.Len(t, server.Data, 4)
+	block, _ := pem.Decode(server.Data["tls_1.crt"])
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	require.Equal(t, "dnode-1.dnode.marklogic.svc.cluster.local", leaf.Subject.CommonName)
+	ca := &corev1.Secret{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode-ca-cert"}, ca))
+	require.Equal(t, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issuer.ca.cert.Raw}), ca.Data["cacert.pem"])
+
+	// Scaling up requests the certificate of the new pod before creating it.
+	require.NoError(t, r.Get(ctx, key, updated))
+	updated.Spec.ReplicaCount = ptr.To[int32](3)
+	require.NoError(t, r.Update(ctx, updated))
+	reconcile(t, r, "dnode")
+	require.NoError(t, r.Get(ctx, key, sts))
+	require.Equal(t, int32(2), *sts.Spec.Replicas)
+	issuer.issue()
+	reconcile(t, r, "dnode")
+	require.NoError(t, r.Get(ctx, key, sts))
+	require.Equal(t, int32(3), *sts.Spec.Replicas)
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(server), server))
+	require.Contains(t, server.Data, "tls_2.key")
+
+	// Certificates of removed pods are deleted with their keys.
+	require.NoError(t, r.Get(ctx, key, updated))
+	updated.Spec.ReplicaCount = ptr.To[int32](1)
+	require.NoError(t, r.Update(ctx, updated))
+	reconcile(t, r, "dnode")
+	for _, name := range []string{"dnode-1", "dnode-2"} {
+		err := r.Get(ctx, client.ObjectKeyFromObject(newCertificate(cluster, name)), newCertificate(cluster, name))
+		require.True(t, apierrors.IsNotFound(err), name)
+		err = r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: name + "-tls"}, &corev1.Secret{})
+		require.True(t, apierrors.IsNotFound(err), name)
+	}
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(newCertificate(cluster, "dnode-0")), newCertificate(cluster, "dnode-0")))
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(server), server))
+	require.Len(t, server.Data, 2)
+}
+
+func TestReconcileRejectsCertManagerWithCertSecrets(t *testing.T) {
+	cluster := newCluster("dnode")
+	cluster.Spec.TLS.EnableOnDefaultAppServers = true
+	cluster.Spec.TLS.CertSecretNames = []string{"cert-0"}
+	cluster.Spec.TLS.CertManager = &marklogicv1alpha1.CertManager{IssuerRef: marklogicv1alpha1.IssuerRef{Name: "marklogic-ca"}}
+	r := newReconciler(cluster)
+	reconcile(t, r, "dnode")
+
+	updated := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, r.Get(context.Background(), client.ObjectKeyFromObject(cluster), updated))
+	cond := meta.FindStatusCondition(updated.Status.Conditions, marklogicv1alpha1.ConditionReconciled)
+	require.NotNil(t, cond)
+	require.Equal(t, "InvalidSpec", cond.Reason)
+	require.Contains(t, cond.Message, "mutually exclusive")
+}
@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"strconv"
+	"strings"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+// envConfigData returns the environment passed to the MarkLogic containers, as rendered by
+// charts/templates/configmap.yaml.
+func envConfigData(c *marklogicv1alpha1.MarkLogicCluster) map[string]string {
+	data := map[string]string{
+		"MARKLOGIC_FQDN_SUFFIX":  headlessURL(c),
+		"MARKLOGIC_INIT":         "false",
+		"MARKLOGIC_JOIN_CLUSTER": "false",
+		"XDQP_SSL_ENABLED":       strconv.FormatBool(*c.Spec.Group.EnableXdqpSsl),
+		"MARKLOGIC_IMAGE_TYPE":   imageType(c),
+	}
+	if c.Spec.BootstrapHostName != "" {
+		data["MARKLOGIC_CLUSTER_TYPE"] = "non-bootstrap"
+		data["MARKLOGIC_BOOTSTRAP_HOST"] = c.Spec.BootstrapHostName
+	} else {
+		data["MARKLOGIC_CLUSTER_TYPE"] = "bootstrap"
+		data["MARKLOGIC_BOOTSTRAP_HOST"] = fqdn(c)
+	}
+	if c.Spec.HAProxy.PathBased.Enabled {
+		data["PATH_BASED_ROUTING"] = "true"
+	}
+	if c.Spec.TLS.EnableOnDefaultAppServers {
+		data["MARKLOGIC_JOIN_TLS_ENABLED"] = "true"
+		data["MARKLOGIC_JOIN_CACERT_FILE"] = "marklogic-certs/cacert.pem"
+		if publishesCA(c) {
+			data["MARKLOGIC_CA_CONFIGMAP"] = caConfigMapName(c)
+		}
+	} else {
+		data["MARKLOGIC_JOIN_TLS_ENABLED"] = "false"
+	}
+	return data
+}
+
+func imageType(c *marklogicv1alpha1.MarkLogicCluster) string {
+	if strings.Contains(c.Spec.Image.Tag, "rootless") {
+		return "rootless"
+	}
+	return "root"
+}
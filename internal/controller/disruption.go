@@ -0,0 +1,211 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// EvictionWebhookPath is where the eviction guard is served.
+const EvictionWebhookPath = "/validate-marklogic-eviction"
+
+const (
+	// evictionTimeout bounds the check of an eviction, under the
+	// timeoutSeconds of the webhook past which the API server ignores it.
+	evictionTimeout = 8 * time.Second
+	// evictionCallTimeout bounds a single Management API call of the check.
+	evictionCallTimeout = 3 * time.Second
+)
+
+// reconcilePodDisruptionBudget maintains the PodDisruptionBudget of the
+// MarkLogic pods, named after the StatefulSet.
+func (r *MarkLogicClusterReconciler) reconcilePodDisruptionBudget(ctx context.Context, c *marklogicv1alpha1.MarkLogicCluster) error {
+	pdb := &policyv1.PodDisruptionBudget{ObjectMeta: r.objectMeta(c, c.Name)}
+	if !*c.Spec.PodDisruptionBudget.Enabled {
+		return r.deleteOwned(ctx, c, pdb)
+	}
+	return r.createOrUpdate(ctx, c, pdb, func() error {
+		pdb.Labels = mergeMaps(pdb.Labels, labels(c))
+		pdb.Spec.MaxUnavailable = c.Spec.PodDisruptionBudget.MaxUnavailable
+		pdb.Spec.MinAvailable = nil
+		pdb.Spec.Selector = &metav1.LabelSelector{MatchLabels: selectorLabels(c)}
+		return nil
+	})
+}
+
+// +kubebuilder:webhook:path=/validate-marklogic-eviction,mutating=false,failurePolicy=ignore,sideEffects=None,groups="",resources=pods/eviction,verbs=create,versions=v1,name=eviction.marklogic.com,admissionReviewVersions=v1,timeoutSeconds=10
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+
+// EvictionGuard refuses evictions of MarkLogic pods that would take the
+// cluster below a quorum of online hosts or leave a forest of the evicted
+// host without a synchronized replica to take over from it. It reads the
+// live state of the cluster from the Management API and lets evictions
+// through when the state cannot be read, the PodDisruptionBudget still
+// limits them, unless the check ran out of time after finding hosts offline.
+type EvictionGuard struct {
+	Client client.Reader
+	// NewClient creates the MarkLogic clients. Defaults to mlclient.New.
+	NewClient mlclient.Factory
+	// Timeout bounds the check of an eviction. Defaults to 8s.
+	Timeout time.Duration
+}
+
+// Handle implements admission.Handler for the pods/eviction subresource.
+func (g *EvictionGuard) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.SubResource != "eviction" {
+		return admission.Allowed("")
+	}
+	logger := log.FromContext(ctx).WithValues("pod", req.Name, "namespace", req.Namespace)
+
+	pod := &corev1.Pod{}
+	if err := g.Client.Get(ctx, client.ObjectKey{Namespace: req.Namespace, Name: req.Name}, pod); err != nil {
+		if apierrors.IsNotFound(err) {
+			return admission.Allowed("")
+		}
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	ref := podCluster(ctx, pod)
+	if len(ref) == 0 {
+		return admission.Allowed("")
+	}
+	cluster, err := clusterFor(ctx, g.Client, pod.Namespace, marklogicv1alpha1.ClusterReference{Name: ref[0].Name})
+	if apierrors.IsNotFound(err) {
+		return admission.Allowed("")
+	}
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	// An unready host does not serve its forests, evicting it changes nothing.
+	if !*cluster.Spec.PodDisruptionBudget.GuardEvictions || !podReady(pod) {
+		return admission.Allowed("")
+	}
+
+	// The cluster is not Available precisely when a host is at risk, ask the
+	// host being evicted, which is ready, instead of the cluster Service.
+	host := hostFQDN(cluster, podOrdinal(pod.Name))
+	timeout := g.Timeout
+	if timeout == 0 {
+		timeout = evictionTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var offline []string
+	mc, err := hostClient(ctx, g.Client, g.newClient, cluster, host)
+	if err == nil {
+		var reason string
+		reason, offline, err = g.check(ctx, mc, host)
+		if err == nil && reason != "" {
+			logger.Info("refusing eviction", "reason", reason)
+			return admission.Denied(reason)
+		}
+	}
+	if err != nil && ctx.Err() != nil && len(offline) > 0 {
+		// The cluster is already degraded, an eviction the guard had no time
+		// to verify may cost it its quorum.
+		reason := fmt.Sprintf("cannot verify MarkLogic cluster %s in time while hosts %s are offline", cluster.Name, strings.Join(offline, ", "))
+		logger.Info("refusing eviction", "reason", reason, "error", err.Error())
+		return admission.Denied(reason)
+	}
+	if err != nil {
+		logger.Error(err, "cannot verify the MarkLogic cluster, allowing eviction")
+		return admission.Allowed("").WithWarnings(fmt.Sprintf("cannot verify MarkLogic cluster %s: %v", cluster.Name, err))
+	}
+	return admission.Allowed("")
+}
+
+// newClient creates the MarkLogic clients of the guard. The API server does
+// not wait for the webhook past its timeoutSeconds, calls are not retried.
+func (g *EvictionGuard) newClient(cfg mlclient.Config) (*mlclient.Client, error) {
+	cfg.MaxRetries, cfg.Timeout = -1, evictionCallTimeout
+	if g.NewClient == nil {
+		return mlclient.New(cfg)
+	}
+	return g.NewClient(cfg)
+}
+
+// check returns why host must not go down, or an empty string when the
+// cluster keeps its quorum and every open forest of host has a replica
+// ready to take over. It also returns the hosts found offline so far, even
+// along with an error.
+func (g *EvictionGuard) check(ctx context.Context, mc *mlclient.Client, host string) (string, []string, error) {
+	hosts, err := mc.Hosts(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("listing hosts: %w", err)
+	}
+	var offline []string
+	online, member := 0, false
+	for _, h := range hosts {
+		up, err := mc.HostOnline(ctx, h)
+		if err != nil && ctx.Err() != nil {
+			return "", offline, fmt.Errorf("reading status of host %s: %w", h, err)
+		}
+		if err != nil {
+			// Hosts that are down may not report a status.
+			continue
+		}
+		if h == host {
+			member = up
+		}
+		if up {
+			online++
+		} else {
+			offline = append(offline, h)
+		}
+	}
+	if !member {
+		return "", offline, nil
+	}
+	if len(hosts) > 1 && (online-1)*2 <= len(hosts) {
+		return fmt.Sprintf("evicting %s would leave %d of %d MarkLogic hosts online, the cluster needs a quorum of more than half", host, online-1, len(hosts)), offline, nil
+	}
+
+	forests, err := mc.HostForests(ctx, host)
+	if err != nil {
+		return "", offline, fmt.Errorf("listing forests of %s: %w", host, err)
+	}
+	var unprotected []string
+	for _, name := range forests {
+		state, err := mc.ForestState(ctx, name)
+		if err != nil {
+			return "", offline, fmt.Errorf("reading state of forest %s: %w", name, err)
+		}
+		if state != mlclient.ForestOpen {
+			continue
+		}
+		props, err := mc.ForestProperties(ctx, name)
+		if err != nil {
+			return "", offline, fmt.Errorf("reading forest %s: %w", name, err)
+		}
+		protected := false
+		for _, replica := range props.Replicas {
+			state, err := mc.ForestState(ctx, replica.ReplicaName)
+			if err != nil {
+				return "", offline, fmt.Errorf("reading state of replica %s: %w", replica.ReplicaName, err)
+			}
+			if state == mlclient.ForestSyncReplicating {
+				protected = true
+				break
+			}
+		}
+		if !protected {
+			unprotected = append(unprotected, name)
+		}
+	}
+	if len(unprotected) > 0 {
+		return fmt.Sprintf("evicting %s would leave forests %s without an open copy, they have no synchronized replica", host, strings.Join(unprotected, ", ")), offline, nil
+	}
+	return "", offline, nil
+}
@@ -0,0 +1,184 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+func TestReconcileManagesPodDisruptionBudget(t *testing.T) {
+	cluster := newCluster("dnode")
+	cluster.Spec.ReplicaCount = ptr.To[int32](3)
+	r := newReconciler(cluster)
+	reconcile(t, r, "dnode")
+
+	pdb := &policyv1.PodDisruptionBudget{}
+	key := types.NamespacedName{Namespace: "marklogic", Name: "dnode"}
+	require.NoError(t, r.Get(context.Background(), key, pdb))
+	assert.Equal(t, ptr.To(intstr.FromInt32(1)), pdb.Spec.MaxUnavailable)
+	assert.Nil(t, pdb.Spec.MinAvailable)
+	assert.Equal(t, selectorLabels(cluster), pdb.Spec.Selector.MatchLabels)
+	assert.True(t, metav1.IsControlledBy(pdb, cluster))
+
+	current := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, r.Get(context.Background(), key, current))
+	current.Spec.PodDisruptionBudget.Enabled = ptr.To(false)
+	require.NoError(t, r.Update(context.Background(), current))
+	reconcile(t, r, "dnode")
+	assert.True(t, apierrors.IsNotFound(r.Get(context.Background(), key, pdb)))
+}
+
+// newEvictionGuard returns a guard of a cluster of three online hosts with
+// ready pods, with the client holding them.
+func newEvictionGuard(t *testing.T, f *fakeMarkLogic) (*EvictionGuard, client.Client) {
+	cluster, secret := availableCluster(f, "dnode", 3, 3)
+	objs := []client.Object{cluster, secret}
+	for i := 0; i < 3; i++ {
+		objs = append(objs, upgradePod(cluster, i, "dnode-old", oldImage))
+	}
+	c := fake.NewClientBuilder().WithScheme(testScheme()).WithObjects(objs...).Build()
+	return &EvictionGuard{Client: c, NewClient: f.newClient}, c
+}
+
+func evict(g *EvictionGuard, pod string) admission.Response {
+	return g.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Name:        pod,
+		Namespace:   "marklogic",
+		Operation:   admissionv1.Create,
+		Resource:    metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		SubResource: "eviction",
+	}})
+}
+
+func TestEvictionGuardChecksForestReplicas(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	host := func(i int) string { return fmt.Sprintf("dnode-%d.dnode.marklogic.svc.cluster.local", i) }
+	f.put("forests", map[string]interface{}{"forest-name": "Documents-1", "host": host(1),
+		"forest-replica": []map[string]string{{"replica-name": "Documents-1-r", "host": host(2)}}})
+	f.put("forests", map[string]interface{}{"forest-name": "Documents-1-r", "host": host(2), "state": "sync replicating"})
+	f.put("forests", map[string]interface{}{"forest-name": "Scratch", "host": host(2)})
+	g, _ := newEvictionGuard(t, f)
+
+	// The replica of Documents-1 takes over, the other forests are elsewhere.
+	assert.True(t, evict(g, "dnode-1").Allowed)
+
+	// Scratch has no replica.
+	resp := evict(g, "dnode-2")
+	assert.False(t, resp.Allowed)
+	assert.Contains(t, resp.Result.Message, "forests Scratch without an open copy")
+
+	// A replica that fell behind cannot take over.
+	f.get("forests", "Documents-1-r")["state"] = "async replicating"
+	assert.False(t, evict(g, "dnode-1").Allowed)
+
+	// Forests that already failed over need nothing.
+	f.get("forests", "Documents-1")["state"] = "sync replicating"
+	assert.True(t, evict(g, "dnode-1").Allowed)
+}
+
+func TestEvictionGuardKeepsQuorum(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	g, c := newEvictionGuard(t, f)
+	ctx := context.Background()
+	assert.True(t, evict(g, "dnode-1").Allowed)
+
+	// With a host down its pod is unready and the cluster no longer Available,
+	// the other hosts are still asked.
+	f.get("hosts", "dnode-2.dnode.marklogic.svc.cluster.local")["online"] = false
+	pod := &corev1.Pod{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode-2"}, pod))
+	pod.Status.Conditions = nil
+	require.NoError(t, c.Status().Update(ctx, pod))
+	cluster := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode"}, cluster))
+	cluster.Status.ReadyReplicas = 2
+	meta.SetStatusCondition(&cluster.Status.Conditions, metav1.Condition{
+		Type:   marklogicv1alpha1.ConditionAvailable,
+		Status: metav1.ConditionFalse,
+		Reason: "PodsNotReady",
+	})
+	require.NoError(t, c.Update(ctx, cluster))
+
+	resp := evict(g, "dnode-1")
+	assert.False(t, resp.Allowed)
+	assert.Contains(t, resp.Result.Message, "would leave 1 of 3 MarkLogic hosts online")
+
+	// Evicting the host that is down does not change the quorum.
+	assert.True(t, evict(g, "dnode-2").Allowed)
+}
+
+func TestEvictionGuardTimesOut(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	g, _ := newEvictionGuard(t, f)
+	g.Timeout = 200 * time.Millisecond
+	f.stall("/manage/v2/hosts/dnode-2.dnode.marklogic.svc.cluster.local")
+
+	// Without a host known to be offline, the PodDisruptionBudget decides.
+	resp := evict(g, "dnode-1")
+	assert.True(t, resp.Allowed)
+	assert.NotEmpty(t, resp.Warnings)
+
+	// With one, an eviction that cannot be verified in time may cost the
+	// cluster its quorum.
+	f.get("hosts", "dnode-0.dnode.marklogic.svc.cluster.local")["online"] = false
+	resp = evict(g, "dnode-1")
+	assert.False(t, resp.Allowed)
+	assert.Equal(t, "cannot verify MarkLogic cluster dnode in time while hosts dnode-0.dnode.marklogic.svc.cluster.local are offline", resp.Result.Message)
+}
+
+func TestEvictionGuardAllowsUnguardedPods(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	f.put("forests", map[string]interface{}{"forest-name": "Scratch", "host": "dnode-1.dnode.marklogic.svc.cluster.local"})
+	g, c := newEvictionGuard(t, f)
+	ctx := context.Background()
+	require.False(t, evict(g, "dnode-1").Allowed)
+
+	// Pods of other applications.
+	require.NoError(t, c.Create(ctx, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "marklogic",
+		Labels: map[string]string{"app.kubernetes.io/name": "web", "app.kubernetes.io/instance": "dnode"}}}))
+	assert.True(t, evict(g, "web-0").Allowed)
+
+	// Unready pods do not serve forests.
+	pod := &corev1.Pod{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode-1"}, pod))
+	ready := pod.Status.Conditions
+	pod.Status.Conditions = nil
+	require.NoError(t, c.Status().Update(ctx, pod))
+	assert.True(t, evict(g, "dnode-1").Allowed)
+	pod.Status.Conditions = ready
+	require.NoError(t, c.Status().Update(ctx, pod))
+
+	// Clusters that opt out.
+	cluster := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode"}, cluster))
+	cluster.Spec.PodDisruptionBudget.GuardEvictions = ptr.To(false)
+	require.NoError(t, c.Update(ctx, cluster))
+	assert.True(t, evict(g, "dnode-1").Allowed)
+
+	// The eviction goes through when MarkLogic cannot be asked, the
+	// PodDisruptionBudget still applies.
+	cluster.Spec.PodDisruptionBudget.GuardEvictions = nil
+	require.NoError(t, c.Update(ctx, cluster))
+	f.srv.Close()
+	resp := evict(g, "dnode-1")
+	assert.True(t, resp.Allowed)
+	assert.NotEmpty(t, resp.Warnings)
+}
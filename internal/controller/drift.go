@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+// toProperties converts v, a struct with Management API JSON tags, into the
+// generic form properties are read back in, so both can be compared.
+func toProperties(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	props := map[string]interface{}{}
+	if err := json.Unmarshal(data, &props); err != nil {
+		return nil, err
+	}
+	return props, nil
+}
+
+// mergeRawProperties adds the properties of raw, a JSON object, to props
+// unless props already sets them.
+func mergeRawProperties(props map[string]interface{}, raw []byte) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	extra := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &extra); err != nil {
+		return fmt.Errorf("properties must be a JSON object: %w", err)
+	}
+	for k, v := range extra {
+		if _, ok := props[k]; !ok {
+			props[k] = v
+		}
+	}
+	return nil
+}
+
+// drift returns the names of the desired properties that current does not
+// match, sorted.
+func drift(desired, current map[string]interface{}) []string {
+	var names []string
+	for k, v := range desired {
+		if !matches(v, current[k]) {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// matches reports whether got satisfies want. Objects match when got has all
+// fields of want, so properties MarkLogic adds on its own do not count as
+// drift. Lists match in any order, since MarkLogic does not keep the order of
+// indexes and similar lists.
+func matches(want, got interface{}) bool {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, v := range w {
+			if !matches(v, g[k]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok {
+			// MarkLogic omits empty lists.
+			return len(w) == 0 && got == nil
+		}
+		if len(w) != len(g) {
+			return false
+		}
+		used := make([]bool, len(g))
+	next:
+		for _, wv := range w {
+			for i, gv := range g {
+				if !used[i] && matches(wv, gv) {
+					used[i] = true
+					continue next
+				}
+			}
+			return false
+		}
+		return true
+	default:
+		return want == got
+	}
+}
+
+// syncResult is the outcome of syncProperties, recorded in the status and the
+// InSync condition of a resource.
+type syncResult struct {
+	// Drift lists the properties changed outside of the operator.
+	Drift   []string
+	Status  metav1.ConditionStatus
+	Reason  string
+	Message string
+}
+
+// syncProperties updates the properties of a MarkLogic resource of kind that
+// differ from desired. Differences found while the spec is unchanged are
+// drift, which is only reported with the Report drift policy.
+func syncProperties(ctx context.Context, kind string, desired, current map[string]interface{}, specChanged bool,
+	policy marklogicv1alpha1.DriftPolicy, update func(props map[string]interface{}) error) (syncResult, error) {
+	changed := drift(desired, current)
+	if len(changed) == 0 {
+		return syncResult{Status: metav1.ConditionTrue, Reason: "InSync", Message: kind + " properties match the spec"}, nil
+	}
+	result := syncResult{Status: metav1.ConditionTrue, Reason: "Updated", Message: kind + " properties updated: " + strings.Join(changed, ", ")}
+	if !specChanged {
+		result.Drift = changed
+		if policy == marklogicv1alpha1.DriftPolicyReport {
+			result.Status = metav1.ConditionFalse
+			result.Reason = "Drifted"
+			result.Message = kind + " properties changed outside of the operator: " + strings.Join(changed, ", ")
+			return result, nil
+		}
+		result.Reason = "DriftCorrected"
+		result.Message = "reverted " + kind + " properties changed outside of the operator: " + strings.Join(changed, ", ")
+	}
+
+	props := map[string]interface{}{}
+	for _, k := range changed {
+		props[k] = desired[k]
+	}
+	log.FromContext(ctx).Info("updating "+kind+" properties", "properties", changed)
+	return result, update(props)
+}
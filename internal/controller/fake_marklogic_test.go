@@ -0,0 +1,433 @@
+package controller
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// nameKeys maps the Management API collections the fake supports to the
+// property naming their resources.
+var nameKeys = map[string]string{
+	"databases":         "database-name",
+	"external-security": "external-security-name",
+	"forests":           "forest-name",
+	"hosts":             "host-name",
+	"roles":             "role-name",
+	"servers":           "server-name",
+	"users":             "user-name",
+}
+
+// fakeMarkLogic emulates the Management API of a MarkLogic cluster. Resources
+// are kept as their JSON properties per collection.
+type fakeMarkLogic struct {
+	mu        sync.Mutex
+	srv       *httptest.Server
+	resources map[string]map[string]map[string]interface{}
+	calls     []string
+	// jobs holds the status of the backup and restore jobs by job id.
+	jobs map[string]string
+	// evals holds the queries sent to /v1/eval.
+	evals []string
+	// invalid holds the backup-validate failures by forest name.
+	invalid map[string]string
+	// operationErrors holds the status codes answered to database
+	// operations, by operation.
+	operationErrors map[string]int
+	// startup is the timestamp of the hosts, empty while they are down.
+	startup string
+	// certificates holds the host certificates inserted into certificate templates.
+	certificates []string
+	// authorities holds the CA certificates inserted.
+	authorities []string
+	// authenticate makes requests log in with basic authentication as one of
+	// the users.
+	authenticate bool
+	// stalled holds the paths whose requests hang until the client gives up.
+	stalled map[string]bool
+}
+
+func newFakeMarkLogic(t *testing.T) *fakeMarkLogic {
+	f := &fakeMarkLogic{resources: map[string]map[string]map[string]interface{}{}, jobs: map[string]string{},
+		invalid: map[string]string{}, operationErrors: map[string]int{}, stalled: map[string]bool{}}
+	for collection := range nameKeys {
+		f.resources[collection] = map[string]map[string]interface{}{}
+	}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+// newClient is an mlclient.Factory that points every client at the fake.
+func (f *fakeMarkLogic) newClient(cfg mlclient.Config) (*mlclient.Client, error) {
+	u, _ := url.Parse(f.srv.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+	p, _ := strconv.Atoi(port)
+	cfg.Host = "127.0.0.1"
+	cfg.HTTPS = false
+	cfg.TLSConfig = nil
+	cfg.AdminPort, cfg.ManagePort, cfg.AppServicesPort = p, p, p
+	cfg.MaxRetries = -1
+	return mlclient.New(cfg)
+}
+
+// put stores a resource as if it had been created in MarkLogic.
+func (f *fakeMarkLogic) put(collection string, props map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.resources[collection][props[nameKeys[collection]].(string)] = props
+}
+
+// get returns the properties of a resource, nil when it does not exist.
+func (f *fakeMarkLogic) get(collection, name string) map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.resources[collection][name]
+}
+
+// setStartup sets the timestamp of the hosts, as if they restarted.
+func (f *fakeMarkLogic) setStartup(ts string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.startup = ts
+}
+
+// setJob sets the status of a backup or restore job.
+func (f *fakeMarkLogic) setJob(id, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.jobs[id] = status
+}
+
+// stall makes the requests to path hang until the client gives up.
+func (f *fakeMarkLogic) stall(path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stalled[path] = true
+}
+
+func (f *fakeMarkLogic) called(call string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.calls {
+		if c == call {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeMarkLogic) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	stalled := f.stalled[r.URL.Path]
+	f.mu.Unlock()
+	if stalled {
+		<-r.Context().Done()
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	f.calls = append(f.calls, r.Method+" "+r.URL.Path)
+	if f.authenticate {
+		username, password, ok := r.BasicAuth()
+		if user := f.resources["users"][username]; !ok || user == nil || user["password"] != password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="public"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	switch r.URL.Path {
+	case "/admin/v1/timestamp":
+		if f.startup == "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.WriteString(w, f.startup)
+		return
+	case "/admin/v1/security-upgrade":
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"restart": map[string]interface{}{"last-startup": []map[string]string{{"value": f.startup}}},
+		})
+		return
+	case "/manage/v2/certificate-authorities":
+		f.authorities = append(f.authorities, string(body))
+		w.WriteHeader(http.StatusCreated)
+		return
+	case "/manage/v2/certificate-templates/defaultTemplate":
+		op := struct {
+			Certificates []struct {
+				Certificate mlclient.HostCertificate `json:"certificate"`
+			} `json:"certificates"`
+		}{}
+		if err := json.Unmarshal(body, &op); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, c := range op.Certificates {
+			f.certificates = append(f.certificates, c.Certificate.Cert)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case "/admin/v1/host-config":
+		delete(f.resources["hosts"], r.URL.Query().Get("remote-host"))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case "/v1/eval":
+		form, _ := url.ParseQuery(string(body))
+		f.evals = append(f.evals, form.Get("xquery"))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// /manage/v2/{collection}[/{name}[/properties]]
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 3 || parts[0] != "manage" || nameKeys[parts[2]] == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	collection := f.resources[parts[2]]
+	if len(parts) == 3 && r.Method == http.MethodPost {
+		props := map[string]interface{}{}
+		if err := json.Unmarshal(body, &props); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		collection[props[nameKeys[parts[2]]].(string)] = props
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	if len(parts) == 3 && r.Method == http.MethodGet && parts[2] == "forests" {
+		f.hostForests(w, r.URL.Query().Get("host-id"))
+		return
+	}
+	if len(parts) == 3 && r.Method == http.MethodGet && parts[2] == "hosts" {
+		f.hosts(w)
+		return
+	}
+	if len(parts) < 4 {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	props, ok := collection[parts[3]]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Query().Get("view") == "counts":
+		documents, _ := props["documents"].(int)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"forest-counts": map[string]interface{}{
+			"count-properties": map[string]interface{}{"document-count": map[string]interface{}{"value": documents}},
+		}})
+	case r.Method == http.MethodGet && r.URL.Query().Get("view") == "status":
+		f.status(w, parts[2], props)
+	case r.Method == http.MethodGet:
+		_ = json.NewEncoder(w).Encode(props)
+	case r.Method == http.MethodPut:
+		update := map[string]interface{}{}
+		if err := json.Unmarshal(body, &update); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for k, v := range update {
+			props[k] = v
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		delete(collection, parts[3])
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && parts[2] == "forests":
+		form, _ := url.ParseQuery(string(body))
+		switch form.Get("state") {
+		case "attach":
+			props["database"] = form.Get("database")
+		case "detach":
+			delete(props, "database")
+		case "retire":
+			props["retired"] = true
+		case "employ":
+			delete(props, "retired")
+		}
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPost && parts[2] == "databases":
+		f.databaseOperation(w, parts[3], body)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// databaseOperation starts backup and restore jobs, which stay in progress
+// until setJob changes them, reports their status and validates backups of
+// the forests attached to database.
+func (f *fakeMarkLogic) databaseOperation(w http.ResponseWriter, database string, body []byte) {
+	op := map[string]string{}
+	if err := json.Unmarshal(body, &op); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if code, ok := f.operationErrors[op["operation"]]; ok {
+		w.WriteHeader(code)
+		return
+	}
+	switch op["operation"] {
+	case "backup-database", "restore-database":
+		id := strconv.Itoa(len(f.jobs) + 1)
+		f.jobs[id] = mlclient.JobInProgress
+		_ = json.NewEncoder(w).Encode(map[string]string{"job-id": id, "host-name": "dnode-0"})
+	case "backup-status", "restore-status":
+		status, ok := f.jobs[op["job-id"]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		forests := []map[string]string{}
+		if op["operation"] == "restore-status" {
+			for _, name := range f.databaseForests(database) {
+				forests = append(forests, map[string]string{"forest-name": name, "host-name": f.forestHost(name), "status": status})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"job-id": op["job-id"], "host-name": op["host-name"], "status": status, "forest": forests,
+		})
+	case "backup-validate":
+		forests := []map[string]string{}
+		for _, name := range f.databaseForests(database) {
+			v := map[string]string{"forest-name": name, "host-name": f.forestHost(name), "status": mlclient.ValidationOkay}
+			if message, ok := f.invalid[name]; ok {
+				v["status"], v["message"] = "failed", message
+			}
+			forests = append(forests, v)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"forest": forests})
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// databaseForests returns the names of the forests attached to database, sorted.
+func (f *fakeMarkLogic) databaseForests(database string) []string {
+	var names []string
+	for name, props := range f.resources["forests"] {
+		if props["database"] == database {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hostForests lists the forests on host, or all forests when host is
+// empty, sorted.
+func (f *fakeMarkLogic) hostForests(w http.ResponseWriter, host string) {
+	var names []string
+	for name := range f.resources["forests"] {
+		if host == "" || f.forestHost(name) == host {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	items := []map[string]string{}
+	for _, name := range names {
+		items = append(items, map[string]string{"nameref": name})
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"forest-default-list": map[string]interface{}{
+		"list-items": map[string]interface{}{"list-item": items},
+	}})
+}
+
+// hosts lists the hosts of the cluster, sorted.
+func (f *fakeMarkLogic) hosts(w http.ResponseWriter) {
+	var names []string
+	for name := range f.resources["hosts"] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	items := []map[string]string{}
+	for _, name := range names {
+		items = append(items, map[string]string{"nameref": name})
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"host-default-list": map[string]interface{}{
+		"list-items": map[string]interface{}{"list-item": items},
+	}})
+}
+
+// status reports hosts as online unless their "online" property is false,
+// and forests in their "state" property, open by default.
+func (f *fakeMarkLogic) status(w http.ResponseWriter, collection string, props map[string]interface{}) {
+	switch collection {
+	case "hosts":
+		online, ok := props["online"].(bool)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"host-status": map[string]interface{}{
+			"status-properties": map[string]interface{}{"online": map[string]interface{}{"value": online || !ok}},
+		}})
+	case "forests":
+		state, ok := props["state"].(string)
+		if !ok {
+			state = mlclient.ForestOpen
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"forest-status": map[string]interface{}{
+			"status-properties": map[string]interface{}{"state": map[string]interface{}{"value": state}},
+		}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// forestHost returns the host of a forest, dnode-0 when it has none.
+func (f *fakeMarkLogic) forestHost(name string) string {
+	if host, ok := f.resources["forests"][name]["host"].(string); ok {
+		return host
+	}
+	return "dnode-0"
+}
+
+// availableCluster returns a cluster whose pods are ready, with its admin
+// secret, and registers its hosts with the fake.
+func availableCluster(f *fakeMarkLogic, name string, replicas int32, joined int) (*marklogicv1alpha1.MarkLogicCluster, *corev1.Secret) {
+	cluster := newCluster(name)
+	cluster.Spec.ReplicaCount = &replicas
+	cluster.Status.ReadyReplicas = replicas
+	meta.SetStatusCondition(&cluster.Status.Conditions, metav1.Condition{
+		Type:   marklogicv1alpha1.ConditionAvailable,
+		Status: metav1.ConditionTrue,
+		Reason: "PodsReady",
+	})
+	defaulted := cluster.DeepCopy()
+	defaulted.Default()
+	for i := 0; i < joined; i++ {
+		f.put("hosts", map[string]interface{}{"host-name": hostFQDN(defaulted, i), "group": "Default"})
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: authSecretName(defaulted), Namespace: cluster.Namespace},
+		Data:       map[string][]byte{"username": []byte("admin"), "password": []byte("admin")},
+	}
+	return cluster, secret
+}
+
+// requireCondition fails unless conditions has the type with the status and reason.
+func requireCondition(t *testing.T, conditions []metav1.Condition, condType string, status metav1.ConditionStatus, reason string) {
+	t.Helper()
+	cond := meta.FindStatusCondition(conditions, condType)
+	require.NotNil(t, cond, "condition %s", condType)
+	require.Equal(t, status, cond.Status, cond.Message)
+	require.Equal(t, reason, cond.Reason, cond.Message)
+}
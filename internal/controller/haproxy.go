@@ -0,0 +1,325 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+const (
+	haproxyConfigKey     = "haproxy.cfg"
+	haproxyConfigPath    = "/usr/local/etc/haproxy/haproxy.cfg"
+	haproxySSLPath       = "/usr/local/etc/ssl/"
+	annotationHAProxyCfg = "marklogic.com/haproxy-config-checksum"
+)
+
+func haproxyName(c *marklogicv1alpha1.MarkLogicCluster) string {
+	return c.Name + "-haproxy"
+}
+
+func haproxySelectorLabels(c *marklogicv1alpha1.MarkLogicCluster) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":      "haproxy",
+		"app.kubernetes.io/instance":  c.Name,
+		"app.kubernetes.io/component": "haproxy",
+	}
+}
+
+func haproxyLabels(c *marklogicv1alpha1.MarkLogicCluster) map[string]string {
+	l := haproxySelectorLabels(c)
+	l["app.kubernetes.io/managed-by"] = managedBy
+	return l
+}
+
+// haproxyBackend is a MarkLogic App Server load balanced by HAProxy.
+type haproxyBackend struct {
+	// Name is the suffix of the frontend and backend sections, e.g. "admin" or "8010".
+	Name string
+	// Server is the prefix of the server entries of the backend.
+	Server string
+	Port   int32
+	Path   string
+}
+
+type haproxyConfigData struct {
+	Spec        marklogicv1alpha1.HAProxy
+	Hosts       []string
+	TLS         bool
+	VerifyCA    bool
+	Backends    []haproxyBackend
+	TCPBackends []haproxyBackend
+	Name        string
+}
+
+// haproxyConfig renders haproxy.cfg the same way charts/templates/configmap-haproxy.yaml does.
+// With verifyCA, HAProxy verifies the certificates of the hosts with the
+// published CA of the cluster instead of accepting any.
+func haproxyConfig(c *marklogicv1alpha1.MarkLogicCluster, verifyCA bool) (string, error) {
+	h := c.Spec.HAProxy
+	data := haproxyConfigData{
+		Spec:     h,
+		TLS:      c.Spec.TLS.EnableOnDefaultAppServers,
+		VerifyCA: verifyCA,
+		Name:     c.Name,
+	}
+	for i := 0; i < int(*c.Spec.ReplicaCount); i++ {
+		data.Hosts = append(data.Hosts, hostFQDN(c, i))
+	}
+
+	defaults := h.DefaultAppServers
+	if h.PathBased.Enabled {
+		data.Backends = []haproxyBackend{
+			{Name: "app-services", Server: c.Name + "-appservices", Port: 8000, Path: defaults.AppServices.Path},
+			{Name: "admin", Server: c.Name + "-admin", Port: 8001, Path: defaults.Admin.Path},
+			{Name: "manage", Server: c.Name + "-manage", Port: 8002, Path: defaults.Manage.Path},
+		}
+	} else {
+		data.Backends = []haproxyBackend{
+			{Name: "appservices", Server: c.Name + "-appservices", Port: defaults.AppServices.Port},
+			{Name: "admin", Server: c.Name + "-admin", Port: defaults.Admin.Port},
+			{Name: "manage", Server: c.Name + "-manage", Port: defaults.Manage.Port},
+		}
+	}
+	for _, s := range h.AdditionalAppServers {
+		port := backendPort(s)
+		data.Backends = append(data.Backends, haproxyBackend{
+			Name:   fmt.Sprint(port),
+			Server: fmt.Sprintf("ml-%s-%d", c.Name, port),
+			Port:   port,
+			Path:   s.Path,
+		})
+	}
+	if h.TCPPorts.Enabled {
+		for _, s := range h.TCPPorts.Ports {
+			port := backendPort(s)
+			data.TCPBackends = append(data.TCPBackends, haproxyBackend{
+				Name:   fmt.Sprint(port),
+				Server: fmt.Sprintf("ml-%s-%d", c.Name, port),
+				Port:   port,
+			})
+		}
+	}
+
+	var b bytes.Buffer
+	if err := haproxyTemplate.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// backendPort returns the MarkLogic port an HAProxy entry forwards to.
+func backendPort(s marklogicv1alpha1.HAProxyAppServer) int32 {
+	if s.TargetPort != 0 {
+		return s.TargetPort
+	}
+	return s.Port
+}
+
+var haproxyTemplate = template.Must(template.New(haproxyConfigKey).Funcs(template.FuncMap{
+	"trimSlash": func(s string) string { return strings.TrimSuffix(s, "/") },
+	"caFile":    func() string { return haproxyCAPath + caKey },
+}).Parse(`global
+  log stdout format raw local0
+  maxconn 1024
+
+defaults
+  log global
+  option forwardfor
+  timeout client {{ .Spec.Timeout.Client }}
+  timeout connect {{ .Spec.Timeout.Connect }}
+  timeout server {{ .Spec.Timeout.Server }}
+
+resolvers dns
+  # add nameserver from /etc/resolv.conf
+  parse-resolv-conf
+  # Maximum size of a DNS answer allowed, in bytes
+  accepted_payload_size 8192
+  # How long to "hold" a backend server's up/down status depending on the name resolution status.
+  hold valid    10s
+  hold other    30s
+  hold refused  30s
+  hold nx       30s
+  hold timeout  30s
+  hold obsolete 30s
+  resolve_retries 3
+  timeout retry 5s
+  timeout resolve 5s
+{{- if .Spec.Stats.Enabled }}
+
+frontend stats
+  mode http
+  bind *:{{ .Spec.Stats.Port }}
+  stats enable
+  http-request use-service prometheus-exporter if { path /metrics }
+  stats uri /
+  stats refresh 10s
+  stats admin if LOCALHOST
+{{- end }}
+{{- range $b := .TCPBackends }}
+
+listen marklogic-TCP-{{ $b.Name }}
+  bind :{{ $b.Port }}
+  mode tcp
+  balance leastconn
+{{- range $i, $host := $.Hosts }}
+  server {{ $b.Server }}-{{ $i }} {{ $host }}:{{ $b.Port }} check resolvers dns init-addr none
+{{- end }}
+{{- end }}
+{{- if .Spec.PathBased.Enabled }}
+
+frontend marklogic
+  mode http
+  option httplog
+  bind :{{ .Spec.FrontendPort }}
+  http-request set-header Host {{ .Name }}:80
+  http-request set-header REFERER http://{{ .Name }}:80
+  http-request set-header X-ML-QC-Path "{{ .Spec.DefaultAppServers.AppServices.Path }}"
+  http-request set-header X-ML-ADM-Path "{{ .Spec.DefaultAppServers.Admin.Path }}"
+  http-request set-header X-ML-MNG-Path "{{ .Spec.DefaultAppServers.Manage.Path }}"
+{{- range $b := .Backends }}
+  use_backend marklogic-{{ $b.Name }} if { path {{ trimSlash $b.Path }} } || { path_beg {{ trimSlash $b.Path }}/ }
+{{- end }}
+{{- else }}
+{{- range $b := .Backends }}
+
+frontend marklogic-{{ $b.Name }}
+  mode http
+{{- if $.Spec.TLS.Enabled }}
+  bind :{{ $b.Port }} ssl crt /usr/local/etc/ssl/{{ $.Spec.TLS.CertFileName }}
+{{- else }}
+  bind :{{ $b.Port }}
+{{- end }}
+  log-format "%ci:%cp [%tr] %ft %b/%s %TR/%Tw/%Tc/%Tr/%Ta %ST %B %CC %CS %tsc %ac/%fc/%bc/%sc/%rc %sq/%bq %hr %hs %{+Q}r"
+  default_backend marklogic-{{ $b.Name }}
+{{- end }}
+{{- end }}
+{{- range $b := .Backends }}
+
+backend marklogic-{{ $b.Name }}
+  mode http
+  balance leastconn
+  option forwardfor
+{{- if $.Spec.PathBased.Enabled }}
+  http-request replace-path {{ trimSlash $b.Path }}(/)?(.*) /\2
+{{- end }}
+  cookie haproxy insert indirect nocache maxidle 30m maxlife 4h
+  stick-table type string len 32 size 10k expire 4h
+  stick store-response res.cook(HostId)
+  stick store-response res.cook(SessionId)
+  stick match req.cook(HostId)
+  stick match req.cook(SessionId)
+  default-server check
+{{- range $i, $host := $.Hosts }}
+  server {{ $b.Server }}-{{ $i }} {{ $host }}:{{ $b.Port }} resolvers dns init-addr none cookie {{ $b.Server }}-{{ $i }}
+{{- if $.VerifyCA }} ssl verify required ca-file {{ caFile }} verifyhost {{ $host }}
+{{- else if $.TLS }} ssl verify none{{ end }}
+{{- end }}
+{{- end }}
+`))
+
+// haproxyPorts returns the ports HAProxy listens on.
+func haproxyPorts(c *marklogicv1alpha1.MarkLogicCluster) []corev1.ServicePort {
+	h := c.Spec.HAProxy
+	port := func(name string, p int32) corev1.ServicePort {
+		return corev1.ServicePort{Name: name, Port: p, TargetPort: intstr.FromInt32(p), Protocol: corev1.ProtocolTCP}
+	}
+	ports := []corev1.ServicePort{
+		port("qconsole", h.DefaultAppServers.AppServices.Port),
+		port("admin", h.DefaultAppServers.Admin.Port),
+		port("manage", h.DefaultAppServers.Manage.Port),
+	}
+	if h.PathBased.Enabled {
+		ports = append(ports, port("frontendport", h.FrontendPort))
+	} else {
+		for _, s := range h.AdditionalAppServers {
+			ports = append(ports, port(s.Name, s.Port))
+		}
+	}
+	if h.Stats.Enabled {
+		ports = append(ports, port("stats", h.Stats.Port))
+	}
+	if h.TCPPorts.Enabled {
+		for _, s := range h.TCPPorts.Ports {
+			ports = append(ports, port(s.Name, s.Port))
+		}
+	}
+	return ports
+}
+
+func mutateHAProxyConfigMap(c *marklogicv1alpha1.MarkLogicCluster, cm *corev1.ConfigMap, cfg string) {
+	cm.Labels = mergeMaps(cm.Labels, haproxyLabels(c))
+	cm.Data = map[string]string{haproxyConfigKey: cfg}
+}
+
+func mutateHAProxyService(c *marklogicv1alpha1.MarkLogicCluster, svc *corev1.Service) {
+	svc.Labels = mergeMaps(svc.Labels, haproxyLabels(c))
+	svc.Spec.Type = c.Spec.HAProxy.Service.Type
+	svc.Spec.Selector = haproxySelectorLabels(c)
+	svc.Spec.Ports = haproxyPorts(c)
+}
+
+func mutateHAProxyDeployment(c *marklogicv1alpha1.MarkLogicCluster, dep *appsv1.Deployment, cfg string, verifyCA bool) {
+	h := c.Spec.HAProxy
+	dep.Labels = mergeMaps(dep.Labels, haproxyLabels(c))
+	if dep.CreationTimestamp.IsZero() {
+		dep.Spec.Selector = &metav1.LabelSelector{MatchLabels: haproxySelectorLabels(c)}
+	}
+	dep.Spec.Replicas = ptr.To(*h.ReplicaCount)
+
+	containerPorts := make([]corev1.ContainerPort, 0)
+	for _, p := range haproxyPorts(c) {
+		containerPorts = append(containerPorts, corev1.ContainerPort{Name: p.Name, ContainerPort: p.Port, Protocol: corev1.ProtocolTCP})
+	}
+	mounts := []corev1.VolumeMount{{Name: "haproxy-config", MountPath: haproxyConfigPath, SubPath: haproxyConfigKey}}
+	vols := []corev1.Volume{{
+		Name: "haproxy-config",
+		VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: haproxyName(c)},
+		}},
+	}}
+	if h.TLS.Enabled {
+		mounts = append(mounts, corev1.VolumeMount{Name: "ssl-certificate", MountPath: haproxySSLPath})
+		vols = append(vols, corev1.Volume{
+			Name:         "ssl-certificate",
+			VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: h.TLS.SecretName}},
+		})
+	}
+
+	if verifyCA {
+		mounts = append(mounts, corev1.VolumeMount{Name: "ca-certificate", MountPath: haproxyCAPath})
+		vols = append(vols, corev1.Volume{
+			Name: "ca-certificate",
+			VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: caConfigMapName(c)},
+			}},
+		})
+	}
+
+	dep.Spec.Template = corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      haproxySelectorLabels(c),
+			Annotations: map[string]string{annotationHAProxyCfg: checksum(map[string]string{haproxyConfigKey: cfg})},
+		},
+		Spec: corev1.PodSpec{
+			ImagePullSecrets: c.Spec.ImagePullSecrets,
+			Containers: []corev1.Container{{
+				Name:            "haproxy",
+				Image:           h.Image.Repository + ":" + h.Image.Tag,
+				ImagePullPolicy: h.Image.PullPolicy,
+				Ports:           containerPorts,
+				VolumeMounts:    mounts,
+				Resources:       h.Resources,
+			}},
+			Volumes: vols,
+		},
+	}
+}
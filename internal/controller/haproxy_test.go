@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/ptr"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+func TestHAProxyConfigDefaultAppServers(t *testing.T) {
+	c := newCluster("dnode")
+	c.Spec.ReplicaCount = ptr.To[int32](2)
+	c.Spec.TLS.EnableOnDefaultAppServers = true
+	c.Spec.HAProxy.AdditionalAppServers = []marklogicv1alpha1.HAProxyAppServer{{Name: "app1", Port: 8010}}
+	c.Default()
+
+	cfg, err := haproxyConfig(c, false)
+	require.NoError(t, err)
+	require.Contains(t, cfg, "frontend marklogic-appservices\n  mode http\n  bind :8000\n")
+	require.Contains(t, cfg, "default_backend marklogic-8010")
+	require.Contains(t, cfg, "server dnode-manage-1 dnode-1.dnode.marklogic.svc.cluster.local:8002 resolvers dns init-addr none cookie dnode-manage-1 ssl verify none")
+	require.Contains(t, cfg, "server ml-dnode-8010-0 dnode-0.dnode.marklogic.svc.cluster.local:8010")
+	require.NotContains(t, cfg, "frontend stats")
+	require.NotContains(t, cfg, "replace-path")
+}
+
+func TestHAProxyConfigPathBased(t *testing.T) {
+	c := newCluster("dnode")
+	c.Spec.HAProxy.PathBased.Enabled = true
+	c.Spec.HAProxy.Stats.Enabled = true
+	c.Spec.HAProxy.AdditionalAppServers = []marklogicv1alpha1.HAProxyAppServer{{Name: "dhf", Port: 8010, Path: "/DHF-jobs"}}
+	c.Spec.HAProxy.TCPPorts = marklogicv1alpha1.HAProxyTCPPorts{
+		Enabled: true,
+		Ports:   []marklogicv1alpha1.HAProxyAppServer{{Name: "odbc", Type: "TCP", Port: 5432}},
+	}
+	c.Default()
+
+	cfg, err := haproxyConfig(c, false)
+	require.NoError(t, err)
+	require.Contains(t, cfg, "frontend marklogic\n  mode http\n  option httplog\n  bind :443\n")
+	require.Contains(t, cfg, "use_backend marklogic-admin if { path /adminUI } || { path_beg /adminUI/ }")
+	require.Contains(t, cfg, "use_backend marklogic-8010 if { path /DHF-jobs } || { path_beg /DHF-jobs/ }")
+	require.Contains(t, cfg, `http-request replace-path /console(/)?(.*) /\2`)
+	require.Contains(t, cfg, "listen marklogic-TCP-5432\n  bind :5432\n  mode tcp\n")
+	require.Contains(t, cfg, "bind *:1024")
+	require.Equal(t, 0, strings.Count(cfg, "frontend marklogic-"))
+
+	ports := haproxyPorts(c)
+	names := make([]string, 0, len(ports))
+	for _, p := range ports {
+		names = append(names, p.Name)
+	}
+	require.Equal(t, []string{"qconsole", "admin", "manage", "frontendport", "stats", "odbc"}, names)
+}
@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+const logShipperContainerName = "log-shipper"
+
+// logShipperEnabled reports whether the pods run the log shipper, which is
+// part of the agent.
+func logShipperEnabled(c *marklogicv1alpha1.MarkLogicCluster) bool {
+	return c.Spec.LogCollection.Enabled && c.Spec.Agent.Image != ""
+}
+
+// logShipperContainer exports the log files of the MarkLogic host of the pod,
+// read from the data volume of the MarkLogic container.
+func logShipperContainer(c *marklogicv1alpha1.MarkLogicCluster) corev1.Container {
+	lc := c.Spec.LogCollection
+	command := []string{agentBinary, "ship-logs",
+		"--logs-dir", dataMountPath + "/Logs",
+		"--files", strings.Join(logFiles(lc.Files), ","),
+		"--endpoint", lc.Endpoint,
+	}
+	if lc.InsecureSkipVerify {
+		command = append(command, "--insecure-skip-verify")
+	}
+	names := make([]string, 0, len(lc.Headers))
+	for name := range lc.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		command = append(command, "--header", name+"="+lc.Headers[name])
+	}
+	return corev1.Container{
+		Name:            logShipperContainerName,
+		Image:           c.Spec.Agent.Image,
+		ImagePullPolicy: c.Spec.Agent.PullPolicy,
+		Command:         command,
+		Env: []corev1.EnvVar{podNameEnv(), {
+			Name:      "POD_NAMESPACE",
+			ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"}},
+		}},
+		EnvFrom:      []corev1.EnvFromSource{envConfigMapRef(c)},
+		VolumeMounts: []corev1.VolumeMount{{Name: volumeData, MountPath: dataMountPath, ReadOnly: true}},
+		Resources:    lc.Resources,
+	}
+}
+
+// logFiles returns the kinds of log files shipped, as named by the agent.
+func logFiles(f marklogicv1alpha1.LogFiles) []string {
+	var kinds []string
+	for _, k := range []struct {
+		name    string
+		enabled *bool
+	}{
+		{"error", f.ErrorLogs},
+		{"access", f.AccessLogs},
+		{"request", f.RequestLogs},
+		{"crash", f.CrashLogs},
+		{"audit", f.AuditLogs},
+	} {
+		if k.enabled == nil || *k.enabled {
+			kinds = append(kinds, k.name)
+		}
+	}
+	return kinds
+}
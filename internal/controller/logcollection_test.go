@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+func TestReconcileShipsLogs(t *testing.T) {
+	cluster := newCluster("dnode")
+	cluster.Spec.Agent.Image = "marklogic-operator:latest"
+	cluster.Spec.LogCollection = marklogicv1alpha1.LogCollection{
+		Enabled:  true,
+		Endpoint: "http://otel-collector.monitoring:4318",
+		Headers:  map[string]string{"X-Scope-OrgID": "marklogic", "Authorization": "Basic YWRtaW46YWRtaW4="},
+		Files:    marklogicv1alpha1.LogFiles{AccessLogs: ptr.To(false), RequestLogs: ptr.To(false)},
+	}
+	r := newReconciler(cluster)
+	reconcile(t, r, "dnode")
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "marklogic", Name: "dnode"}
+
+	sts := &appsv1.StatefulSet{}
+	require.NoError(t, r.Get(ctx, key, sts))
+	containers := sts.Spec.Template.Spec.Containers
+	require.Len(t, containers, 2)
+	shipper := containers[1]
+	assert.Equal(t, logShipperContainerName, shipper.Name)
+	assert.Equal(t, "marklogic-operator:latest", shipper.Image)
+	assert.Equal(t, []string{"/agent", "ship-logs",
+		"--logs-dir", "/var/opt/MarkLogic/Logs",
+		"--files", "error,crash,audit",
+		"--endpoint", "http://otel-collector.monitoring:4318",
+		"--header", "Authorization=Basic YWRtaW46YWRtaW4=",
+		"--header", "X-Scope-OrgID=marklogic",
+	}, shipper.Command)
+	assert.Equal(t, []corev1.VolumeMount{{Name: volumeData, MountPath: dataMountPath, ReadOnly: true}}, shipper.VolumeMounts)
+	assert.Equal(t, containers[0].EnvFrom, shipper.EnvFrom)
+	assert.Equal(t, "POD_NAMESPACE", shipper.Env[1].Name)
+
+	current := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, r.Get(ctx, key, current))
+	current.Spec.LogCollection.Enabled = false
+	require.NoError(t, r.Update(ctx, current))
+	reconcile(t, r, "dnode")
+	require.NoError(t, r.Get(ctx, key, sts))
+	assert.Len(t, sts.Spec.Template.Spec.Containers, 1)
+}
+
+func TestReconcileRejectsLogCollectionWithoutEndpoint(t *testing.T) {
+	cluster := newCluster("dnode")
+	cluster.Spec.Agent.Image = "marklogic-operator:latest"
+	cluster.Spec.LogCollection.Enabled = true
+	r := newReconciler(cluster)
+	reconcile(t, r, "dnode")
+	current := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, r.Get(context.Background(), types.NamespacedName{Namespace: "marklogic", Name: "dnode"}, current))
+	requireCondition(t, current.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "InvalidSpec")
+}
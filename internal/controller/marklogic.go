@@ -0,0 +1,240 @@
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/internal/credentials"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+const (
+	// clusterNotReadyRequeue is how long reconcilers wait for a MarkLogicCluster
+	// to become available before they configure it.
+	clusterNotReadyRequeue = 30 * time.Second
+	// driftCheckInterval is how often the configuration inside MarkLogic is
+	// compared against the resources describing it.
+	driftCheckInterval = 5 * time.Minute
+)
+
+// CredentialsDir holds the admin credentials of the clusters using the File
+// provider, in a directory <namespace>/<name> per cluster. The operator
+// mounts them there, e.g. from the same CSI driver as the pods.
+var CredentialsDir = "/etc/marklogic/credentials"
+
+// vaultTokens keeps the Vault logins of the operator across reconciles, so
+// the clients of a cluster do not log in again on every call.
+var vaultTokens = &credentials.TokenCache{}
+
+// errClusterNotReady is returned by clusterClient while the pods of the
+// referenced cluster are not ready.
+var errClusterNotReady = errors.New("MarkLogic cluster is not ready")
+
+// errCredentialsUnavailable is returned for a cluster using the File provider
+// while its credentials are not mounted in the operator. The pods run, but the
+// operator cannot configure MarkLogic.
+var errCredentialsUnavailable = errors.New("admin credentials are not available to the operator")
+
+// clusterFor returns the referenced MarkLogicCluster with its defaults applied.
+func clusterFor(ctx context.Context, c client.Reader, namespace string, ref marklogicv1alpha1.ClusterReference) (*marklogicv1alpha1.MarkLogicCluster, error) {
+	cluster := &marklogicv1alpha1.MarkLogicCluster{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, cluster); err != nil {
+		return nil, err
+	}
+	cluster.Default()
+	return cluster, nil
+}
+
+// clusterClient returns a client for the Admin and Management APIs of the first
+// host of cluster, authenticated with the admin credentials of the cluster.
+func clusterClient(ctx context.Context, c client.Reader, newClient mlclient.Factory, cluster *marklogicv1alpha1.MarkLogicCluster) (*mlclient.Client, error) {
+	if !meta.IsStatusConditionTrue(cluster.Status.Conditions, marklogicv1alpha1.ConditionAvailable) {
+		return nil, errClusterNotReady
+	}
+	return hostClient(ctx, c, newClient, cluster, fqdn(cluster))
+}
+
+// hostClient returns a client for the Admin and Management APIs of one host of
+// cluster, authenticated with the admin credentials of the cluster.
+func hostClient(ctx context.Context, c client.Reader, newClient mlclient.Factory, cluster *marklogicv1alpha1.MarkLogicCluster, host string) (*mlclient.Client, error) {
+	provider, err := credentialsProvider(ctx, c, cluster)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := provider.Credentials(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading admin credentials: %w", err)
+	}
+	return newHostClient(ctx, c, newClient, cluster, host, creds.Username, creds.Password)
+}
+
+// credentialsProvider returns the source of the admin credentials MarkLogic
+// accepts. With secrets, they are the ones of the auth secret until the
+// cluster controller has created the active secret.
+func credentialsProvider(ctx context.Context, c client.Reader, cluster *marklogicv1alpha1.MarkLogicCluster) (credentials.Provider, error) {
+	switch cluster.Spec.Auth.Provider {
+	case marklogicv1alpha1.CredentialsProviderFile:
+		if !isDir(credentialsDir(cluster)) {
+			return nil, fmt.Errorf("%w: the File credentials provider needs them mounted in the operator at %s, "+
+				"please add a volume of the CSI driver to the operator deployment", errCredentialsUnavailable, credentialsDir(cluster))
+		}
+		return &credentials.FileProvider{Dir: credentialsDir(cluster)}, nil
+	case marklogicv1alpha1.CredentialsProviderVault:
+		return vaultProvider(ctx, c, cluster)
+	}
+	name := activeAuthSecretName(cluster)
+	if err := c.Get(ctx, types.NamespacedName{Namespace: cluster.Namespace, Name: name}, &corev1.Secret{}); apierrors.IsNotFound(err) {
+		name = authSecretName(cluster)
+	} else if err != nil {
+		return nil, fmt.Errorf("reading admin credentials: %w", err)
+	}
+	return &credentials.SecretProvider{Client: c, Namespace: cluster.Namespace, Name: name}, nil
+}
+
+// credentialsDir is where the operator reads the credentials of a cluster
+// using the File provider.
+func credentialsDir(cluster *marklogicv1alpha1.MarkLogicCluster) string {
+	return filepath.Join(CredentialsDir, cluster.Namespace, cluster.Name)
+}
+
+// refused reports whether MarkLogic refused a request with a 4xx status.
+// Retrying it does not help, unlike a 5xx status such as the 503 of a host
+// that restarts, which the client does not retry for POST requests.
+func refused(err error) bool {
+	code := mlclient.StatusCode(err)
+	return code >= http.StatusBadRequest && code < http.StatusInternalServerError
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// vaultProvider reads the admin credentials from Vault, logging in with the
+// service account of the operator.
+func vaultProvider(ctx context.Context, c client.Reader, cluster *marklogicv1alpha1.MarkLogicCluster) (*credentials.VaultProvider, error) {
+	v := cluster.Spec.Auth.Vault
+	if v == nil {
+		return nil, errors.New("auth.vault must be set for the Vault provider")
+	}
+	p := &credentials.VaultProvider{
+		Address:   v.Address,
+		Path:      v.Path,
+		Role:      v.OperatorRole,
+		AuthMount: v.AuthMount,
+		Namespace: v.Namespace,
+		Tokens:    vaultTokens,
+	}
+	if p.Role == "" {
+		p.Role = v.Role
+	}
+	if v.CASecretName != "" {
+		secret := &corev1.Secret{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: cluster.Namespace, Name: v.CASecretName}, secret); err != nil {
+			return nil, fmt.Errorf("reading the CA of vault: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(secret.Data["ca.crt"]) {
+			return nil, fmt.Errorf("secret %s has no PEM certificate in ca.crt", secret.Name)
+		}
+		p.HTTPClient = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}}}
+	}
+	return p, nil
+}
+
+// newHostClient returns a client for the Admin and Management APIs of one host
+// of cluster, authenticated with the given credentials.
+func newHostClient(ctx context.Context, c client.Reader, newClient mlclient.Factory, cluster *marklogicv1alpha1.MarkLogicCluster,
+	host, username, password string) (*mlclient.Client, error) {
+	cfg := mlclient.Config{
+		Host:     host,
+		Username: username,
+		Password: password,
+	}
+	if cluster.Spec.TLS.EnableOnDefaultAppServers {
+		tlsConfig, err := clusterTLSConfig(ctx, c, cluster)
+		if err != nil {
+			return nil, err
+		}
+		cfg.HTTPS = true
+		cfg.TLSConfig = tlsConfig
+	}
+	if newClient == nil {
+		newClient = mlclient.New
+	}
+	return newClient(cfg)
+}
+
+// clusterTLSConfig trusts the CA of the named certificates of the cluster, or
+// the CA generated for the temporary certificates once the bootstrap host has
+// published it. Until then temporary certificates cannot be verified.
+func clusterTLSConfig(ctx context.Context, c client.Reader, cluster *marklogicv1alpha1.MarkLogicCluster) (*tls.Config, error) {
+	name := caSecretName(cluster)
+	if name == "" {
+		ca, err := publishedCA(ctx, c, cluster)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA certificate: %w", err)
+		}
+		if ca == "" {
+			return &tls.Config{InsecureSkipVerify: true}, nil //nolint:gosec // temporary certificates are self-signed
+		}
+		return publishedCATLSConfig(ca)
+	}
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: cluster.Namespace, Name: name}, secret); err != nil {
+		return nil, fmt.Errorf("reading CA certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(secret.Data["cacert.pem"]) {
+		return nil, fmt.Errorf("secret %s has no PEM certificate in cacert.pem", secret.Name)
+	}
+	return &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}, nil
+}
+
+// clusterChanged passes the events of a MarkLogicCluster that matter to the
+// configuration inside it: the cluster becoming available or hosts becoming
+// ready.
+var clusterChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldCluster, ok := e.ObjectOld.(*marklogicv1alpha1.MarkLogicCluster)
+		if !ok {
+			return false
+		}
+		newCluster, ok := e.ObjectNew.(*marklogicv1alpha1.MarkLogicCluster)
+		if !ok {
+			return false
+		}
+		return oldCluster.Status.ReadyReplicas != newCluster.Status.ReadyReplicas ||
+			meta.IsStatusConditionTrue(oldCluster.Status.Conditions, marklogicv1alpha1.ConditionAvailable) !=
+				meta.IsStatusConditionTrue(newCluster.Status.Conditions, marklogicv1alpha1.ConditionAvailable)
+	},
+	DeleteFunc: func(event.DeleteEvent) bool { return false },
+}
+
+// setCondition sets a condition of a resource observed at generation.
+func setCondition(conditions *[]metav1.Condition, generation int64, condType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(conditions, metav1.Condition{
+		Type:               condType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: generation,
+	})
+}
@@ -0,0 +1,343 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// appServerFinalizer lets the operator delete the App Server from MarkLogic
+// before the resource goes away.
+const appServerFinalizer = "marklogic.com/appserver"
+
+// MarkLogicAppServerReconciler reconciles a MarkLogicAppServer object. The
+// service ports and HAProxy routes of the App Server are rendered by the
+// MarkLogicCluster controller.
+type MarkLogicAppServerReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// NewClient creates the MarkLogic clients. Defaults to mlclient.New.
+	NewClient mlclient.Factory
+}
+
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicappservers,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicappservers/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicappservers/finalizers,verbs=update
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile creates the App Server in the MarkLogic cluster, keeps its
+// properties in line with the spec and manages its Ingress.
+func (r *MarkLogicAppServerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	server := &marklogicv1alpha1.MarkLogicAppServer{}
+	if err := r.Get(ctx, req.NamespacedName, server); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	desired := server.DeepCopy()
+	desired.Default()
+
+	if !server.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, server, desired)
+	}
+	if controllerutil.AddFinalizer(server, appServerFinalizer) {
+		if err := r.Update(ctx, server); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if desired.Spec.Ingress.Enabled && desired.Spec.ServerType == marklogicv1alpha1.ServerTypeODBC {
+		r.setReconciled(server, metav1.ConditionFalse, "InvalidSpec", "an Ingress can not route to an ODBC App Server")
+		return ctrl.Result{}, r.Status().Update(ctx, server)
+	}
+
+	cluster, err := clusterFor(ctx, r.Client, server.Namespace, desired.Spec.ClusterRef)
+	if apierrors.IsNotFound(err) {
+		r.setReconciled(server, metav1.ConditionFalse, "ClusterNotFound", fmt.Sprintf("MarkLogicCluster %s not found", desired.Spec.ClusterRef.Name))
+		return ctrl.Result{}, r.Status().Update(ctx, server)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if desired.Spec.GroupName == "" {
+		desired.Spec.GroupName = cluster.Spec.Group.Name
+	}
+	props, err := appServerProperties(desired)
+	if err != nil {
+		logger.Info("invalid MarkLogicAppServer spec", "reason", err.Error())
+		r.setReconciled(server, metav1.ConditionFalse, "InvalidSpec", err.Error())
+		return ctrl.Result{}, r.Status().Update(ctx, server)
+	}
+
+	if err := r.reconcileIngress(ctx, cluster, server, desired); err != nil {
+		return ctrl.Result{}, err
+	}
+	server.Status.Endpoints = appServerEndpoints(cluster, desired)
+
+	mc, err := clusterClient(ctx, r.Client, r.NewClient, cluster)
+	if errors.Is(err, errClusterNotReady) {
+		r.setReconciled(server, metav1.ConditionFalse, "ClusterNotReady", fmt.Sprintf("waiting for MarkLogicCluster %s to become available", cluster.Name))
+		return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, server)
+	}
+	if errors.Is(err, errCredentialsUnavailable) {
+		r.setReconciled(server, metav1.ConditionFalse, "CredentialsUnavailable", err.Error())
+		return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, server)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileAppServer(ctx, mc, server, desired, props); err != nil {
+		logger.Error(err, "failed to reconcile App Server", "server", desired.Spec.ServerName)
+		r.setReconciled(server, metav1.ConditionFalse, "ReconcileError", err.Error())
+		if statusErr := r.Status().Update(ctx, server); statusErr != nil {
+			logger.Error(statusErr, "failed to update MarkLogicAppServer status")
+		}
+		return ctrl.Result{}, err
+	}
+	server.Status.ObservedGeneration = server.Generation
+	r.setReconciled(server, metav1.ConditionTrue, "Reconciled", "App Server is up to date")
+	return ctrl.Result{RequeueAfter: driftCheckInterval}, r.Status().Update(ctx, server)
+}
+
+// reconcileAppServer applies the desired App Server properties and records
+// the outcome in the status of server.
+func (r *MarkLogicAppServerReconciler) reconcileAppServer(ctx context.Context, mc *mlclient.Client,
+	server, desired *marklogicv1alpha1.MarkLogicAppServer, props map[string]interface{}) error {
+	name, group := desired.Spec.ServerName, desired.Spec.GroupName
+
+	current, err := mc.AppServerProperties(ctx, name, group)
+	switch {
+	case mlclient.IsNotFound(err):
+		log.FromContext(ctx).Info("creating App Server", "server", name, "group", group)
+		if err := mc.CreateAppServer(ctx, group, mlclient.AppServerProperties(props)); err != nil {
+			return fmt.Errorf("creating App Server %s: %w", name, err)
+		}
+		server.Status.Drift = nil
+		r.setInSync(server, metav1.ConditionTrue, "Created", "App Server created")
+	case err != nil:
+		return fmt.Errorf("reading App Server %s: %w", name, err)
+	default:
+		result, err := syncProperties(ctx, "App Server", props, current, server.Generation != server.Status.ObservedGeneration, desired.Spec.DriftPolicy,
+			func(update map[string]interface{}) error {
+				if _, err := mc.UpdateAppServerProperties(ctx, name, group, update); err != nil {
+					return fmt.Errorf("updating App Server %s: %w", name, err)
+				}
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+		server.Status.Drift = result.Drift
+		r.setInSync(server, result.Status, result.Reason, result.Message)
+	}
+	now := metav1.Now()
+	server.Status.LastDriftCheck = &now
+	server.Status.ServerName = name
+	return nil
+}
+
+// reconcileIngress creates the Ingress of the App Server, or deletes it once
+// it is disabled.
+func (r *MarkLogicAppServerReconciler) reconcileIngress(ctx context.Context, cluster *marklogicv1alpha1.MarkLogicCluster,
+	server, desired *marklogicv1alpha1.MarkLogicAppServer) error {
+	ing := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: server.Name, Namespace: server.Namespace}}
+	if !desired.Spec.Ingress.Enabled {
+		err := r.Get(ctx, client.ObjectKeyFromObject(ing), ing)
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !metav1.IsControlledBy(ing, server) {
+			return nil
+		}
+		return client.IgnoreNotFound(r.Delete(ctx, ing))
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, ing, func() error {
+		mutateAppServerIngress(cluster, desired, ing)
+		return controllerutil.SetControllerReference(server, ing, r.Scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("reconciling Ingress %s: %w", ing.Name, err)
+	}
+	return nil
+}
+
+// mutateAppServerIngress sets the desired state of the Ingress of an App
+// Server. It routes to HAProxy when HAProxy load balances the App Server,
+// otherwise to the service of the cluster.
+func mutateAppServerIngress(cluster *marklogicv1alpha1.MarkLogicCluster, s *marklogicv1alpha1.MarkLogicAppServer, ing *networkingv1.Ingress) {
+	spec := s.Spec.Ingress
+	ing.Labels = mergeMaps(ing.Labels, spec.Labels)
+	ing.Annotations = mergeMaps(ing.Annotations, spec.Annotations)
+
+	service, port, path := clusterServiceName(cluster), s.Spec.Port, "/"
+	if routedByHAProxy(cluster, s) {
+		service, port = haproxyName(cluster), s.Spec.HAProxy.Port
+		if cluster.Spec.HAProxy.PathBased.Enabled {
+			port, path = cluster.Spec.HAProxy.FrontendPort, s.Spec.HAProxy.Path
+		}
+	}
+	pathType := networkingv1.PathTypePrefix
+	ing.Spec = networkingv1.IngressSpec{
+		IngressClassName: spec.ClassName,
+		TLS:              spec.TLS,
+		Rules: []networkingv1.IngressRule{{
+			Host: spec.Host,
+			IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+				Paths: []networkingv1.HTTPIngressPath{{
+					Path:     path,
+					PathType: &pathType,
+					Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+						Name: service,
+						Port: networkingv1.ServiceBackendPort{Number: port},
+					}},
+				}},
+			}},
+		}},
+	}
+}
+
+// appServerEndpoints returns the addresses of the App Server on the services
+// of the cluster.
+func appServerEndpoints(cluster *marklogicv1alpha1.MarkLogicCluster, s *marklogicv1alpha1.MarkLogicAppServer) []string {
+	address := func(service string, port int32) string {
+		return fmt.Sprintf("%s.%s.svc.%s:%d", service, cluster.Namespace, cluster.Spec.ClusterDomain, port)
+	}
+	endpoints := []string{address(clusterServiceName(cluster), s.Spec.Port)}
+	if routedByHAProxy(cluster, s) {
+		if cluster.Spec.HAProxy.PathBased.Enabled && s.Spec.ServerType != marklogicv1alpha1.ServerTypeODBC {
+			endpoints = append(endpoints, address(haproxyName(cluster), cluster.Spec.HAProxy.FrontendPort)+s.Spec.HAProxy.Path)
+		} else {
+			endpoints = append(endpoints, address(haproxyName(cluster), s.Spec.HAProxy.Port))
+		}
+	}
+	return endpoints
+}
+
+// finalize deletes the App Server from MarkLogic when the deletion policy
+// asks for it, then releases the resource.
+func (r *MarkLogicAppServerReconciler) finalize(ctx context.Context, server, desired *marklogicv1alpha1.MarkLogicAppServer) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(server, appServerFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	if desired.Spec.DeletionPolicy == marklogicv1alpha1.DeletionPolicyDelete {
+		cluster, err := clusterFor(ctx, r.Client, server.Namespace, desired.Spec.ClusterRef)
+		switch {
+		case apierrors.IsNotFound(err):
+			// Nothing is left to clean up with the cluster gone.
+		case err != nil:
+			return ctrl.Result{}, err
+		case cluster.DeletionTimestamp.IsZero():
+			mc, err := clusterClient(ctx, r.Client, r.NewClient, cluster)
+			if errors.Is(err, errClusterNotReady) {
+				r.setReconciled(server, metav1.ConditionFalse, "ClusterNotReady", fmt.Sprintf("waiting for MarkLogicCluster %s to become available", cluster.Name))
+				return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, server)
+			}
+			if errors.Is(err, errCredentialsUnavailable) {
+				r.setReconciled(server, metav1.ConditionFalse, "CredentialsUnavailable", err.Error())
+				return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, server)
+			}
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			group := desired.Spec.GroupName
+			if group == "" {
+				group = cluster.Spec.Group.Name
+			}
+			log.FromContext(ctx).Info("deleting App Server", "server", desired.Spec.ServerName, "group", group)
+			if err := mc.DeleteAppServer(ctx, desired.Spec.ServerName, group); err != nil {
+				return ctrl.Result{}, fmt.Errorf("deleting App Server %s: %w", desired.Spec.ServerName, err)
+			}
+		}
+	}
+	controllerutil.RemoveFinalizer(server, appServerFinalizer)
+	return ctrl.Result{}, r.Update(ctx, server)
+}
+
+func (r *MarkLogicAppServerReconciler) setReconciled(s *marklogicv1alpha1.MarkLogicAppServer, status metav1.ConditionStatus, reason, message string) {
+	setCondition(&s.Status.Conditions, s.Generation, marklogicv1alpha1.ConditionReconciled, status, reason, message)
+}
+
+func (r *MarkLogicAppServerReconciler) setInSync(s *marklogicv1alpha1.MarkLogicAppServer, status metav1.ConditionStatus, reason, message string) {
+	setCondition(&s.Status.Conditions, s.Generation, marklogicv1alpha1.ConditionInSync, status, reason, message)
+}
+
+// appServerSpec is the Management API form of the typed fields of the spec.
+type appServerSpec struct {
+	ServerName      string `json:"server-name"`
+	ServerType      string `json:"server-type"`
+	GroupName       string `json:"group-name"`
+	Root            string `json:"root"`
+	Port            int32  `json:"port"`
+	ContentDatabase string `json:"content-database"`
+	ModulesDatabase string `json:"modules-database,omitempty"`
+	Authentication  string `json:"authentication"`
+}
+
+// appServerProperties returns the desired properties of a defaulted App Server.
+func appServerProperties(server *marklogicv1alpha1.MarkLogicAppServer) (map[string]interface{}, error) {
+	s := server.Spec
+	props, err := toProperties(appServerSpec{
+		ServerName:      s.ServerName,
+		ServerType:      s.ServerType,
+		GroupName:       s.GroupName,
+		Root:            s.Root,
+		Port:            s.Port,
+		ContentDatabase: s.ContentDatabase,
+		ModulesDatabase: s.ModulesDatabase,
+		Authentication:  s.Authentication,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s.Properties != nil {
+		if err := mergeRawProperties(props, s.Properties.Raw); err != nil {
+			return nil, fmt.Errorf("spec.properties: %w", err)
+		}
+	}
+	return props, nil
+}
+
+// appServersForCluster maps a MarkLogicCluster to the App Servers it hosts.
+func (r *MarkLogicAppServerReconciler) appServersForCluster(ctx context.Context, obj client.Object) []ctrl.Request {
+	list := &marklogicv1alpha1.MarkLogicAppServerList{}
+	if err := r.List(ctx, list, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list MarkLogicAppServers")
+		return nil
+	}
+	var requests []ctrl.Request
+	for _, s := range list.Items {
+		if s.Spec.ClusterRef.Name == obj.GetName() {
+			requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&s)})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MarkLogicAppServerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&marklogicv1alpha1.MarkLogicAppServer{}).
+		Owns(&networkingv1.Ingress{}).
+		Watches(&marklogicv1alpha1.MarkLogicCluster{},
+			handler.EnqueueRequestsFromMapFunc(r.appServersForCluster),
+			builder.WithPredicates(clusterChanged)).
+		Complete(r)
+}
@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+func newAppServer(name, cluster string, port int32) *marklogicv1alpha1.MarkLogicAppServer {
+	return &marklogicv1alpha1.MarkLogicAppServer{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "marklogic", Generation: 1, UID: types.UID(name + "-uid")},
+		Spec: marklogicv1alpha1.MarkLogicAppServerSpec{
+			ClusterRef: marklogicv1alpha1.ClusterReference{Name: cluster},
+			Port:       port,
+		},
+	}
+}
+
+func newAppServerReconciler(f *fakeMarkLogic, objs ...client.Object) *MarkLogicAppServerReconciler {
+	s := testScheme()
+	c := fake.NewClientBuilder().
+		WithScheme(s).
+		WithObjects(objs...).
+		WithStatusSubresource(&marklogicv1alpha1.MarkLogicAppServer{}, &marklogicv1alpha1.MarkLogicCluster{}).
+		Build()
+	return &MarkLogicAppServerReconciler{Client: c, Scheme: s, NewClient: f.newClient}
+}
+
+func reconcileAppServer(t *testing.T, r *MarkLogicAppServerReconciler, name string) (ctrl.Result, *marklogicv1alpha1.MarkLogicAppServer) {
+	t.Helper()
+	key := types.NamespacedName{Namespace: "marklogic", Name: name}
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	server := &marklogicv1alpha1.MarkLogicAppServer{}
+	require.NoError(t, r.Get(context.Background(), key, server))
+	return result, server
+}
+
+func TestReconcileAppServerCreatesServer(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	cluster, secret := availableCluster(f, "dnode", 1, 1)
+	server := newAppServer("app", "dnode", 8010)
+	server.Spec.ModulesDatabase = "Modules"
+	server.Spec.Properties = &runtime.RawExtension{Raw: []byte(`{"concurrent-request-limit": 10, "port": 9999}`)}
+	r := newAppServerReconciler(f, cluster, secret, server)
+
+	result, server := reconcileAppServer(t, r, "app")
+	props := f.get("servers", "app")
+	require.NotNil(t, props)
+	assert.Equal(t, "http", props["server-type"])
+	assert.Equal(t, "Default", props["group-name"])
+	assert.Equal(t, float64(8010), props["port"], "typed fields take precedence over properties")
+	assert.Equal(t, "Modules", props["modules-database"])
+	assert.Equal(t, float64(10), props["concurrent-request-limit"])
+
+	assert.Equal(t, driftCheckInterval, result.RequeueAfter)
+	assert.Equal(t, []string{appServerFinalizer}, server.Finalizers)
+	assert.Equal(t, "app", server.Status.ServerName)
+	assert.Equal(t, []string{"dnode-cluster.marklogic.svc.cluster.local:8010"}, server.Status.Endpoints)
+	requireCondition(t, server.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionTrue, "Reconciled")
+	requireCondition(t, server.Status.Conditions, marklogicv1alpha1.ConditionInSync, metav1.ConditionTrue, "Created")
+
+	// Changes made outside of the operator are reverted.
+	props["authentication"] = "basic"
+	props["threads"] = float64(64)
+	_, server = reconcileAppServer(t, r, "app")
+	assert.Equal(t, []string{"authentication"}, server.Status.Drift)
+	assert.Equal(t, "digest", f.get("servers", "app")["authentication"])
+	requireCondition(t, server.Status.Conditions, marklogicv1alpha1.ConditionInSync, metav1.ConditionTrue, "DriftCorrected")
+}
+
+func TestReconcileAppServerIngress(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	cluster, secret := availableCluster(f, "dnode", 1, 1)
+	cluster.Spec.HAProxy.Enabled = true
+	cluster.Spec.HAProxy.PathBased.Enabled = true
+	server := newAppServer("app", "dnode", 8010)
+	server.Spec.HAProxy.Path = "/app"
+	server.Spec.Ingress = marklogicv1alpha1.AppServerIngress{
+		Enabled:     true,
+		ClassName:   ptr.To("nginx"),
+		Host:        "app.example.com",
+		Annotations: map[string]string{"nginx.ingress.kubernetes.io/affinity": "cookie"},
+	}
+	r := newAppServerReconciler(f, cluster, secret, server)
+	ctx := context.Background()
+
+	_, server = reconcileAppServer(t, r, "app")
+	ing := &networkingv1.Ingress{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "app"}, ing))
+	assert.True(t, metav1.IsControlledBy(ing, server))
+	assert.Equal(t, "nginx", *ing.Spec.IngressClassName)
+	assert.Equal(t, "cookie", ing.Annotations["nginx.ingress.kubernetes.io/affinity"])
+	require.Len(t, ing.Spec.Rules, 1)
+	assert.Equal(t, "app.example.com", ing.Spec.Rules[0].Host)
+	path := ing.Spec.Rules[0].HTTP.Paths[0]
+	assert.Equal(t, "/app", path.Path)
+	assert.Equal(t, "dnode-haproxy", path.Backend.Service.Name)
+	assert.Equal(t, int32(443), path.Backend.Service.Port.Number)
+	assert.Equal(t, []string{
+		"dnode-cluster.marklogic.svc.cluster.local:8010",
+		"dnode-haproxy.marklogic.svc.cluster.local:443/app",
+	}, server.Status.Endpoints)
+
+	// Without HAProxy the Ingress routes to the service of the cluster.
+	server.Spec.HAProxy.Enabled = ptr.To(false)
+	require.NoError(t, r.Update(ctx, server))
+	_, server = reconcileAppServer(t, r, "app")
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "app"}, ing))
+	path = ing.Spec.Rules[0].HTTP.Paths[0]
+	assert.Equal(t, "/", path.Path)
+	assert.Equal(t, "dnode-cluster", path.Backend.Service.Name)
+	assert.Equal(t, int32(8010), path.Backend.Service.Port.Number)
+
+	server.Spec.Ingress.Enabled = false
+	require.NoError(t, r.Update(ctx, server))
+	reconcileAppServer(t, r, "app")
+	err := r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "app"}, ing)
+	assert.True(t, apierrors.IsNotFound(err), "Ingress should be deleted")
+}
+
+func TestReconcileAppServerRejectsODBCIngress(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	cluster, secret := availableCluster(f, "dnode", 1, 1)
+	server := newAppServer("odbc", "dnode", 5432)
+	server.Spec.ServerType = marklogicv1alpha1.ServerTypeODBC
+	server.Spec.Ingress.Enabled = true
+	r := newAppServerReconciler(f, cluster, secret, server)
+
+	_, server = reconcileAppServer(t, r, "odbc")
+	requireCondition(t, server.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "InvalidSpec")
+	assert.Nil(t, f.get("servers", "odbc"))
+}
+
+func TestReconcileAppServerDeletion(t *testing.T) {
+	for _, policy := range []marklogicv1alpha1.DeletionPolicy{marklogicv1alpha1.DeletionPolicyRetain, marklogicv1alpha1.DeletionPolicyDelete} {
+		t.Run(string(policy), func(t *testing.T) {
+			f := newFakeMarkLogic(t)
+			cluster, secret := availableCluster(f, "dnode", 1, 1)
+			server := newAppServer("app", "dnode", 8010)
+			server.Spec.DeletionPolicy = policy
+			r := newAppServerReconciler(f, cluster, secret, server)
+			_, server = reconcileAppServer(t, r, "app")
+
+			require.NoError(t, r.Delete(context.Background(), server))
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(server)})
+			require.NoError(t, err)
+			require.Error(t, r.Get(context.Background(), client.ObjectKeyFromObject(server), server), "finalizer is removed")
+			assert.Equal(t, policy == marklogicv1alpha1.DeletionPolicyDelete, f.called("DELETE /manage/v2/servers/app"))
+		})
+	}
+}
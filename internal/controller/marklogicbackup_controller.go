@@ -0,0 +1,213 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// backupPollInterval is how often the status of a running backup job is checked.
+const backupPollInterval = 15 * time.Second
+
+// MarkLogicBackupReconciler reconciles a MarkLogicBackup object
+type MarkLogicBackupReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// NewClient creates the MarkLogic clients. Defaults to mlclient.New.
+	NewClient mlclient.Factory
+}
+
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicbackups,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicbackups/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile starts the backup job of a MarkLogicBackup and follows it until
+// it completes or fails.
+func (r *MarkLogicBackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	backup := &marklogicv1alpha1.MarkLogicBackup{}
+	if err := r.Get(ctx, req.NamespacedName, backup); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if backupFinished(backup) || !backup.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+	desired := backup.DeepCopy()
+	desired.Default()
+	if backup.Status.Phase == "" {
+		backup.Status.Phase = marklogicv1alpha1.BackupPending
+	}
+
+	cluster, err := clusterFor(ctx, r.Client, backup.Namespace, desired.Spec.ClusterRef)
+	if apierrors.IsNotFound(err) {
+		r.setReconciled(backup, metav1.ConditionFalse, "ClusterNotFound", fmt.Sprintf("MarkLogicCluster %s not found", desired.Spec.ClusterRef.Name))
+		return ctrl.Result{}, r.Status().Update(ctx, backup)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	mc, err := clusterClient(ctx, r.Client, r.NewClient, cluster)
+	if errors.Is(err, errClusterNotReady) {
+		r.setReconciled(backup, metav1.ConditionFalse, "ClusterNotReady", fmt.Sprintf("waiting for MarkLogicCluster %s to become available", cluster.Name))
+		return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, backup)
+	}
+	if errors.Is(err, errCredentialsUnavailable) {
+		r.setReconciled(backup, metav1.ConditionFalse, "CredentialsUnavailable", err.Error())
+		return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, backup)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	result, err := r.reconcileJob(ctx, mc, backup, desired)
+	if err != nil {
+		logger.Error(err, "failed to reconcile backup", "database", desired.Spec.Database)
+		r.setReconciled(backup, metav1.ConditionFalse, "ReconcileError", err.Error())
+		if statusErr := r.Status().Update(ctx, backup); statusErr != nil {
+			logger.Error(statusErr, "failed to update MarkLogicBackup status")
+		}
+		return ctrl.Result{}, err
+	}
+	r.setReconciled(backup, metav1.ConditionTrue, "Reconciled", "backup job is tracked")
+	return result, r.Status().Update(ctx, backup)
+}
+
+// reconcileJob starts the backup job, or checks on the one already started.
+// Requests MarkLogic refuses fail the backup; connection errors and 5xx
+// answers are retried. The job is recorded in the status as soon as it
+// starts, so that a failed status update does not start a second one.
+func (r *MarkLogicBackupReconciler) reconcileJob(ctx context.Context, mc *mlclient.Client,
+	backup, desired *marklogicv1alpha1.MarkLogicBackup) (ctrl.Result, error) {
+	spec := desired.Spec
+	if backup.Status.JobID == "" {
+		log.FromContext(ctx).Info("starting backup", "database", spec.Database, "incremental", spec.Incremental)
+		job, err := mc.Backup(ctx, spec.Database, mlclient.BackupRequest{
+			BackupDir:       spec.BackupDir,
+			IncludeReplicas: *spec.IncludeReplicas,
+			Incremental:     spec.Incremental,
+			IncrementalDir:  incrementalDir(desired),
+		})
+		if refused(err) {
+			r.fail(backup, fmt.Sprintf("MarkLogic refused the backup of database %s: %v", spec.Database, err))
+			return ctrl.Result{}, nil
+		}
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("starting backup of database %s: %w", spec.Database, err)
+		}
+		now := metav1.Now()
+		base := backup.DeepCopy()
+		backup.Status.Phase = marklogicv1alpha1.BackupRunning
+		backup.Status.JobID = job.JobID
+		backup.Status.HostName = job.HostName
+		backup.Status.StartTime = &now
+		// A merge patch does not conflict with changes made since the backup
+		// was read.
+		if err := r.Status().Patch(ctx, backup, client.MergeFrom(base)); err != nil {
+			return ctrl.Result{}, fmt.Errorf("recording backup job %s of database %s: %w", job.JobID, spec.Database, err)
+		}
+		r.event(backup, corev1.EventTypeNormal, "BackupStarted", "started backup job %s of database %s", job.JobID, spec.Database)
+		return ctrl.Result{RequeueAfter: backupPollInterval}, nil
+	}
+
+	status, err := mc.BackupStatus(ctx, spec.Database, mlclient.Job{JobID: backup.Status.JobID, HostName: backup.Status.HostName})
+	if mlclient.IsNotFound(err) {
+		r.fail(backup, fmt.Sprintf("backup job %s is unknown to MarkLogic", backup.Status.JobID))
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("reading status of backup job %s: %w", backup.Status.JobID, err)
+	}
+	switch status.Status {
+	case mlclient.JobCompleted:
+		now := metav1.Now()
+		backup.Status.Phase = marklogicv1alpha1.BackupCompleted
+		backup.Status.CompletionTime = &now
+		r.event(backup, corev1.EventTypeNormal, "BackupCompleted", "backup job %s of database %s completed", backup.Status.JobID, spec.Database)
+		return ctrl.Result{}, nil
+	case mlclient.JobFailed, mlclient.JobCancelled:
+		r.fail(backup, fmt.Sprintf("backup job %s of database %s %s", backup.Status.JobID, spec.Database, status.Status))
+		return ctrl.Result{}, nil
+	default:
+		return ctrl.Result{RequeueAfter: backupPollInterval}, nil
+	}
+}
+
+// incrementalDir returns the incremental directory of incremental backups;
+// full backups do not take one.
+func incrementalDir(b *marklogicv1alpha1.MarkLogicBackup) string {
+	if !b.Spec.Incremental {
+		return ""
+	}
+	return b.Spec.IncrementalDir
+}
+
+func (r *MarkLogicBackupReconciler) fail(backup *marklogicv1alpha1.MarkLogicBackup, message string) {
+	now := metav1.Now()
+	backup.Status.Phase = marklogicv1alpha1.BackupFailed
+	backup.Status.CompletionTime = &now
+	backup.Status.Message = message
+	r.event(backup, corev1.EventTypeWarning, "BackupFailed", "%s", message)
+}
+
+// event records an event on the backup and on the schedule that started it.
+func (r *MarkLogicBackupReconciler) event(backup *marklogicv1alpha1.MarkLogicBackup, eventType, reason, messageFmt string, args ...interface{}) {
+	r.Recorder.Eventf(backup, eventType, reason, messageFmt, args...)
+	if owner := metav1.GetControllerOf(backup); owner != nil && owner.Kind == "MarkLogicBackupSchedule" {
+		schedule := &marklogicv1alpha1.MarkLogicBackupSchedule{ObjectMeta: metav1.ObjectMeta{
+			Name: owner.Name, Namespace: backup.Namespace, UID: owner.UID,
+		}}
+		r.Recorder.Eventf(schedule, eventType, reason, backup.Name+": "+messageFmt, args...)
+	}
+}
+
+func (r *MarkLogicBackupReconciler) setReconciled(b *marklogicv1alpha1.MarkLogicBackup, status metav1.ConditionStatus, reason, message string) {
+	setCondition(&b.Status.Conditions, b.Generation, marklogicv1alpha1.ConditionReconciled, status, reason, message)
+}
+
+// backupFinished reports whether the backup completed or failed.
+func backupFinished(b *marklogicv1alpha1.MarkLogicBackup) bool {
+	return b.Status.Phase == marklogicv1alpha1.BackupCompleted || b.Status.Phase == marklogicv1alpha1.BackupFailed
+}
+
+// backupsForCluster maps a MarkLogicCluster to its unfinished backups.
+func (r *MarkLogicBackupReconciler) backupsForCluster(ctx context.Context, obj client.Object) []ctrl.Request {
+	list := &marklogicv1alpha1.MarkLogicBackupList{}
+	if err := r.List(ctx, list, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list MarkLogicBackups")
+		return nil
+	}
+	var requests []ctrl.Request
+	for _, b := range list.Items {
+		if b.Spec.ClusterRef.Name == obj.GetName() && !backupFinished(&b) {
+			requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&b)})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MarkLogicBackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&marklogicv1alpha1.MarkLogicBackup{}).
+		Watches(&marklogicv1alpha1.MarkLogicCluster{},
+			handler.EnqueueRequestsFromMapFunc(r.backupsForCluster),
+			builder.WithPredicates(clusterChanged)).
+		Complete(r)
+}
@@ -0,0 +1,186 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+func newBackup(name, cluster, database string) *marklogicv1alpha1.MarkLogicBackup {
+	return &marklogicv1alpha1.MarkLogicBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "marklogic", Generation: 1},
+		Spec: marklogicv1alpha1.MarkLogicBackupSpec{
+			ClusterRef:     marklogicv1alpha1.ClusterReference{Name: cluster},
+			BackupTemplate: marklogicv1alpha1.BackupTemplate{Database: database, BackupDir: "/backups"},
+		},
+	}
+}
+
+func newBackupReconciler(f *fakeMarkLogic, objs ...client.Object) (*MarkLogicBackupReconciler, *record.FakeRecorder) {
+	s := testScheme()
+	c := fake.NewClientBuilder().
+		WithScheme(s).
+		WithObjects(objs...).
+		WithStatusSubresource(&marklogicv1alpha1.MarkLogicBackup{}, &marklogicv1alpha1.MarkLogicCluster{}).
+		Build()
+	recorder := record.NewFakeRecorder(10)
+	return &MarkLogicBackupReconciler{Client: c, Scheme: s, Recorder: recorder, NewClient: f.newClient}, recorder
+}
+
+func reconcileBackup(t *testing.T, r *MarkLogicBackupReconciler, name string) (ctrl.Result, *marklogicv1alpha1.MarkLogicBackup) {
+	t.Helper()
+	key := types.NamespacedName{Namespace: "marklogic", Name: name}
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	backup := &marklogicv1alpha1.MarkLogicBackup{}
+	require.NoError(t, r.Get(context.Background(), key, backup))
+	return result, backup
+}
+
+// events drains the events recorded so far.
+func events(recorder *record.FakeRecorder) []string {
+	var recorded []string
+	for {
+		select {
+		case e := <-recorder.Events:
+			recorded = append(recorded, e)
+		default:
+			return recorded
+		}
+	}
+}
+
+func TestReconcileBackupRunsJob(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	f.put("databases", map[string]interface{}{"database-name": "Documents"})
+	cluster, secret := availableCluster(f, "dnode", 1, 1)
+	r, recorder := newBackupReconciler(f, cluster, secret, newBackup("nightly", "dnode", "Documents"))
+
+	result, backup := reconcileBackup(t, r, "nightly")
+	assert.Equal(t, backupPollInterval, result.RequeueAfter)
+	assert.Equal(t, marklogicv1alpha1.BackupRunning, backup.Status.Phase)
+	assert.Equal(t, "1", backup.Status.JobID)
+	assert.Equal(t, "dnode-0", backup.Status.HostName)
+	assert.NotNil(t, backup.Status.StartTime)
+	assert.Equal(t, []string{"Normal BackupStarted started backup job 1 of database Documents"}, events(recorder))
+
+	result, backup = reconcileBackup(t, r, "nightly")
+	assert.Equal(t, backupPollInterval, result.RequeueAfter)
+	assert.Equal(t, marklogicv1alpha1.BackupRunning, backup.Status.Phase)
+
+	f.setJob("1", mlclient.JobCompleted)
+	result, backup = reconcileBackup(t, r, "nightly")
+	assert.Zero(t, result.RequeueAfter)
+	assert.Equal(t, marklogicv1alpha1.BackupCompleted, backup.Status.Phase)
+	assert.NotNil(t, backup.Status.CompletionTime)
+	assert.Equal(t, []string{"Normal BackupCompleted backup job 1 of database Documents completed"}, events(recorder))
+
+	// A finished backup is left alone.
+	f.calls = nil
+	reconcileBackup(t, r, "nightly")
+	assert.Empty(t, f.calls)
+}
+
+func TestReconcileBackupFailures(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	f.put("databases", map[string]interface{}{"database-name": "Documents"})
+	cluster, secret := availableCluster(f, "dnode", 1, 1)
+	missing := newBackup("missing", "dnode", "Missing")
+	cancelled := newBackup("cancelled", "dnode", "Documents")
+	cancelled.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: marklogicv1alpha1.GroupVersion.String(), Kind: "MarkLogicBackupSchedule",
+		Name: "daily", UID: "daily-uid", Controller: ptr.To(true),
+	}}
+	r, recorder := newBackupReconciler(f, cluster, secret, missing, cancelled)
+
+	_, missing = reconcileBackup(t, r, "missing")
+	assert.Equal(t, marklogicv1alpha1.BackupFailed, missing.Status.Phase)
+	assert.Contains(t, missing.Status.Message, "MarkLogic refused the backup of database Missing")
+	require.Len(t, events(recorder), 1)
+
+	reconcileBackup(t, r, "cancelled")
+	events(recorder)
+	f.setJob("1", mlclient.JobCancelled)
+	_, cancelled = reconcileBackup(t, r, "cancelled")
+	assert.Equal(t, marklogicv1alpha1.BackupFailed, cancelled.Status.Phase)
+	assert.Equal(t, []string{
+		"Warning BackupFailed backup job 1 of database Documents cancelled",
+		"Warning BackupFailed cancelled: backup job 1 of database Documents cancelled",
+	}, events(recorder), "the failure is reported on the schedule too")
+}
+
+func TestReconcileBackupRetriesUnavailableMarkLogic(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	f.put("databases", map[string]interface{}{"database-name": "Documents"})
+	cluster, secret := availableCluster(f, "dnode", 1, 1)
+	r, _ := newBackupReconciler(f, cluster, secret, newBackup("nightly", "dnode", "Documents"))
+	key := types.NamespacedName{Namespace: "marklogic", Name: "nightly"}
+
+	f.operationErrors["backup-database"] = http.StatusServiceUnavailable
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.Error(t, err)
+	backup := &marklogicv1alpha1.MarkLogicBackup{}
+	require.NoError(t, r.Get(context.Background(), key, backup))
+	assert.Equal(t, marklogicv1alpha1.BackupPending, backup.Status.Phase)
+	requireCondition(t, backup.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "ReconcileError")
+
+	delete(f.operationErrors, "backup-database")
+	_, backup = reconcileBackup(t, r, "nightly")
+	assert.Equal(t, marklogicv1alpha1.BackupRunning, backup.Status.Phase)
+	assert.Equal(t, "1", backup.Status.JobID)
+}
+
+func TestReconcileBackupRecordsJobBeforeStatusUpdate(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	f.put("databases", map[string]interface{}{"database-name": "Documents"})
+	cluster, secret := availableCluster(f, "dnode", 1, 1)
+	s := testScheme()
+	c := fake.NewClientBuilder().
+		WithScheme(s).
+		WithObjects(cluster, secret, newBackup("nightly", "dnode", "Documents")).
+		WithStatusSubresource(&marklogicv1alpha1.MarkLogicBackup{}, &marklogicv1alpha1.MarkLogicCluster{}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceUpdate: func(context.Context, client.Client, string, client.Object, ...client.SubResourceUpdateOption) error {
+				return errors.New("conflict")
+			},
+		}).
+		Build()
+	r := &MarkLogicBackupReconciler{Client: c, Scheme: s, Recorder: record.NewFakeRecorder(10), NewClient: f.newClient}
+	key := types.NamespacedName{Namespace: "marklogic", Name: "nightly"}
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.Error(t, err)
+	backup := &marklogicv1alpha1.MarkLogicBackup{}
+	require.NoError(t, r.Get(context.Background(), key, backup))
+	assert.Equal(t, "1", backup.Status.JobID)
+
+	// The next reconcile follows the recorded job instead of starting another.
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.Error(t, err)
+	assert.Len(t, f.jobs, 1)
+}
+
+func TestReconcileBackupWaitsForCluster(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	r, _ := newBackupReconciler(f, newCluster("starting"), newBackup("nightly", "starting", "Documents"))
+	result, backup := reconcileBackup(t, r, "nightly")
+	assert.Equal(t, clusterNotReadyRequeue, result.RequeueAfter)
+	assert.Equal(t, marklogicv1alpha1.BackupPending, backup.Status.Phase)
+	requireCondition(t, backup.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "ClusterNotReady")
+	assert.Empty(t, f.calls)
+}
@@ -0,0 +1,337 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/internal/cron"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// MarkLogicBackupScheduleReconciler reconciles a MarkLogicBackupSchedule object
+type MarkLogicBackupScheduleReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// NewClient creates the MarkLogic clients. Defaults to mlclient.New.
+	NewClient mlclient.Factory
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicbackupschedules,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicbackupschedules/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicbackupschedules/finalizers,verbs=update
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicbackups,verbs=get;list;watch;create;update;patch;delete
+
+// scheduledBackups are the backups of a schedule, oldest first.
+type scheduledBackups struct {
+	active, full, incremental, failed []*marklogicv1alpha1.MarkLogicBackup
+}
+
+// Reconcile creates a MarkLogicBackup whenever one of the schedules is due,
+// and deletes and purges backups beyond the retention of the schedule.
+func (r *MarkLogicBackupScheduleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	schedule := &marklogicv1alpha1.MarkLogicBackupSchedule{}
+	if err := r.Get(ctx, req.NamespacedName, schedule); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !schedule.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+	desired := schedule.DeepCopy()
+	desired.Default()
+
+	full, incremental, loc, err := parseSchedules(desired)
+	if err != nil {
+		logger.Info("invalid MarkLogicBackupSchedule spec", "reason", err.Error())
+		r.setReconciled(schedule, metav1.ConditionFalse, "InvalidSpec", err.Error())
+		return ctrl.Result{}, r.Status().Update(ctx, schedule)
+	}
+
+	backups, err := r.backups(ctx, schedule)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	schedule.Status.Active = nil
+	for _, b := range backups.active {
+		schedule.Status.Active = append(schedule.Status.Active, b.Name)
+	}
+	for _, b := range append(backups.full, backups.incremental...) {
+		if t := b.Status.CompletionTime; t != nil && (schedule.Status.LastSuccessfulTime == nil || schedule.Status.LastSuccessfulTime.Before(t)) {
+			schedule.Status.LastSuccessfulTime = t
+		}
+	}
+
+	if err := r.enforceRetention(ctx, schedule, desired, backups); err != nil {
+		logger.Error(err, "failed to enforce backup retention")
+		r.setReconciled(schedule, metav1.ConditionFalse, "ReconcileError", err.Error())
+		if statusErr := r.Status().Update(ctx, schedule); statusErr != nil {
+			logger.Error(statusErr, "failed to update MarkLogicBackupSchedule status")
+		}
+		return ctrl.Result{}, err
+	}
+
+	now := r.now().In(loc)
+	if err := r.startDueBackups(ctx, schedule, desired, backups, full, incremental, now); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	next := full.Next(now)
+	if incremental != nil {
+		if t := incremental.Next(now); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	schedule.Status.ObservedGeneration = schedule.Generation
+	result := ctrl.Result{}
+	switch {
+	case desired.Spec.Suspend:
+		r.setReconciled(schedule, metav1.ConditionTrue, "Suspended", "no backups are started while the schedule is suspended")
+	case next.IsZero():
+		r.setReconciled(schedule, metav1.ConditionTrue, "Reconciled", "the schedules never match")
+	default:
+		result.RequeueAfter = next.Sub(now)
+		r.setReconciled(schedule, metav1.ConditionTrue, "Reconciled", "next backup at "+next.Format(time.RFC3339))
+	}
+	return result, r.Status().Update(ctx, schedule)
+}
+
+// parseSchedules parses the cron schedules and time zone of the schedule.
+// The incremental schedule is nil when none is set.
+func parseSchedules(s *marklogicv1alpha1.MarkLogicBackupSchedule) (full, incremental *cron.Schedule, loc *time.Location, err error) {
+	loc = time.UTC
+	if s.Spec.TimeZone != "" {
+		if loc, err = time.LoadLocation(s.Spec.TimeZone); err != nil {
+			return nil, nil, nil, fmt.Errorf("spec.timeZone: %w", err)
+		}
+	}
+	if full, err = cron.Parse(s.Spec.Schedule); err != nil {
+		return nil, nil, nil, fmt.Errorf("spec.schedule: %w", err)
+	}
+	if s.Spec.IncrementalSchedule != "" {
+		if incremental, err = cron.Parse(s.Spec.IncrementalSchedule); err != nil {
+			return nil, nil, nil, fmt.Errorf("spec.incrementalSchedule: %w", err)
+		}
+	}
+	return full, incremental, loc, nil
+}
+
+// backups lists the backups started by the schedule.
+func (r *MarkLogicBackupScheduleReconciler) backups(ctx context.Context, schedule *marklogicv1alpha1.MarkLogicBackupSchedule) (*scheduledBackups, error) {
+	list := &marklogicv1alpha1.MarkLogicBackupList{}
+	if err := r.List(ctx, list, client.InNamespace(schedule.Namespace),
+		client.MatchingLabels{marklogicv1alpha1.LabelBackupSchedule: schedule.Name}); err != nil {
+		return nil, fmt.Errorf("listing MarkLogicBackups: %w", err)
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		return scheduledTime(&list.Items[i]).Before(scheduledTime(&list.Items[j]))
+	})
+	backups := &scheduledBackups{}
+	for i := range list.Items {
+		b := &list.Items[i]
+		if !metav1.IsControlledBy(b, schedule) || !b.DeletionTimestamp.IsZero() {
+			continue
+		}
+		switch {
+		case b.Status.Phase == marklogicv1alpha1.BackupFailed:
+			backups.failed = append(backups.failed, b)
+		case b.Status.Phase != marklogicv1alpha1.BackupCompleted:
+			backups.active = append(backups.active, b)
+		case b.Spec.Incremental:
+			backups.incremental = append(backups.incremental, b)
+		default:
+			backups.full = append(backups.full, b)
+		}
+	}
+	return backups, nil
+}
+
+// scheduledTime returns the time a backup was scheduled for.
+func scheduledTime(b *marklogicv1alpha1.MarkLogicBackup) time.Time {
+	t, err := time.Parse(time.RFC3339, b.Annotations[annotationScheduledTime])
+	if err != nil {
+		return b.CreationTimestamp.Time
+	}
+	return t
+}
+
+// enforceRetention deletes the MarkLogicBackups beyond the retention of the
+// schedule and purges the backups they wrote from MarkLogic. Incremental
+// backups go with the full backup they were taken on top of.
+func (r *MarkLogicBackupScheduleReconciler) enforceRetention(ctx context.Context, schedule, desired *marklogicv1alpha1.MarkLogicBackupSchedule,
+	backups *scheduledBackups) error {
+	var expired []*marklogicv1alpha1.MarkLogicBackup
+	if excess := len(backups.failed) - int(*desired.Spec.Retention.Failed); excess > 0 {
+		expired = append(expired, backups.failed[:excess]...)
+	}
+	keep := int(*desired.Spec.Retention.Full)
+	if excess := len(backups.full) - keep; excess > 0 {
+		expired = append(expired, backups.full[:excess]...)
+		oldest := scheduledTime(backups.full[excess])
+		for _, b := range backups.incremental {
+			if scheduledTime(b).Before(oldest) {
+				expired = append(expired, b)
+			}
+		}
+	}
+	for _, b := range expired {
+		log.FromContext(ctx).Info("deleting expired backup", "backup", b.Name)
+		if err := r.Delete(ctx, b); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("deleting MarkLogicBackup %s: %w", b.Name, err)
+		}
+	}
+
+	if len(backups.full) == 0 {
+		return nil
+	}
+	latest := backups.full[len(backups.full)-1]
+	if schedule.Status.LastPurgedBackup == latest.Name {
+		return nil
+	}
+	cluster, err := clusterFor(ctx, r.Client, schedule.Namespace, desired.Spec.ClusterRef)
+	if err != nil {
+		return err
+	}
+	mc, err := clusterClient(ctx, r.Client, r.NewClient, cluster)
+	if errors.Is(err, errClusterNotReady) || errors.Is(err, errCredentialsUnavailable) {
+		// Purged once the cluster is back.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("purging old backups", "database", desired.Spec.Database, "directory", desired.Spec.BackupDir, "keep", keep)
+	if err := mc.PurgeBackups(ctx, desired.Spec.Database, desired.Spec.BackupDir, keep); err != nil {
+		r.Recorder.Eventf(schedule, corev1.EventTypeWarning, "PurgeFailed", "purging backups of database %s in %s failed: %v",
+			desired.Spec.Database, desired.Spec.BackupDir, err)
+		return fmt.Errorf("purging backups of database %s: %w", desired.Spec.Database, err)
+	}
+	schedule.Status.LastPurgedBackup = latest.Name
+	return nil
+}
+
+// startDueBackups starts the last missed full or incremental backup. Only one
+// backup of the schedule runs at a time; a backup that is due while another
+// runs starts once it is done.
+func (r *MarkLogicBackupScheduleReconciler) startDueBackups(ctx context.Context, schedule, desired *marklogicv1alpha1.MarkLogicBackupSchedule,
+	backups *scheduledBackups, full, incremental *cron.Schedule, now time.Time) error {
+	if desired.Spec.Suspend || len(backups.active) > 0 {
+		return nil
+	}
+	created := schedule.CreationTimestamp.Time
+	if created.IsZero() {
+		created = now
+	}
+
+	dueFull := lastMissed(full, since(schedule.Status.LastFullScheduleTime, created), now)
+	var dueIncremental time.Time
+	if incremental != nil {
+		dueIncremental = lastMissed(incremental, since(schedule.Status.LastIncrementalScheduleTime, created), now)
+	}
+	switch {
+	case !dueFull.IsZero():
+		if err := r.startBackup(ctx, schedule, desired, dueFull, false); err != nil {
+			return err
+		}
+		schedule.Status.LastFullScheduleTime = &metav1.Time{Time: dueFull}
+		if !dueIncremental.IsZero() {
+			// The full backup covers the incremental backup due as well.
+			schedule.Status.LastIncrementalScheduleTime = &metav1.Time{Time: dueIncremental}
+		}
+	case !dueIncremental.IsZero():
+		schedule.Status.LastIncrementalScheduleTime = &metav1.Time{Time: dueIncremental}
+		if len(backups.full) == 0 {
+			log.FromContext(ctx).Info("skipping incremental backup until a full backup has completed")
+			return nil
+		}
+		if err := r.startBackup(ctx, schedule, desired, dueIncremental, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startBackup creates the MarkLogicBackup of a scheduled time.
+func (r *MarkLogicBackupScheduleReconciler) startBackup(ctx context.Context, schedule, desired *marklogicv1alpha1.MarkLogicBackupSchedule,
+	scheduled time.Time, incremental bool) error {
+	kind, suffix := "full", "full"
+	if incremental {
+		kind, suffix = "incremental", "incr"
+	}
+	backup := &marklogicv1alpha1.MarkLogicBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%s-%s-%d", schedule.Name, suffix, scheduled.Unix()/60),
+			Namespace:   schedule.Namespace,
+			Labels:      map[string]string{marklogicv1alpha1.LabelBackupSchedule: schedule.Name},
+			Annotations: map[string]string{annotationScheduledTime: scheduled.UTC().Format(time.RFC3339)},
+		},
+		Spec: marklogicv1alpha1.MarkLogicBackupSpec{
+			ClusterRef:     desired.Spec.ClusterRef,
+			BackupTemplate: desired.Spec.BackupTemplate,
+			Incremental:    incremental,
+		},
+	}
+	if err := controllerutil.SetControllerReference(schedule, backup, r.Scheme); err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("starting scheduled backup", "backup", backup.Name, "incremental", incremental)
+	if err := r.Create(ctx, backup); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("creating MarkLogicBackup %s: %w", backup.Name, err)
+	}
+	schedule.Status.Active = append(schedule.Status.Active, backup.Name)
+	r.Recorder.Eventf(schedule, corev1.EventTypeNormal, "BackupScheduled", "created %s backup %s", kind, backup.Name)
+	return nil
+}
+
+// lastMissed returns the last time the schedule matched after earliest and up
+// to now, or the zero time when it did not.
+func lastMissed(s *cron.Schedule, earliest, now time.Time) time.Time {
+	var last time.Time
+	for t := s.Next(earliest.In(now.Location())); !t.IsZero() && !t.After(now); t = s.Next(t) {
+		last = t
+	}
+	return last
+}
+
+func since(last *metav1.Time, created time.Time) time.Time {
+	if last != nil {
+		return last.Time
+	}
+	return created
+}
+
+func (r *MarkLogicBackupScheduleReconciler) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
+
+func (r *MarkLogicBackupScheduleReconciler) setReconciled(s *marklogicv1alpha1.MarkLogicBackupSchedule, status metav1.ConditionStatus, reason, message string) {
+	setCondition(&s.Status.Conditions, s.Generation, marklogicv1alpha1.ConditionReconciled, status, reason, message)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MarkLogicBackupScheduleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&marklogicv1alpha1.MarkLogicBackupSchedule{}).
+		Owns(&marklogicv1alpha1.MarkLogicBackup{}).
+		Complete(r)
+}
@@ -0,0 +1,208 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+var scheduleCreated = time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+func newBackupSchedule(name, cluster string) *marklogicv1alpha1.MarkLogicBackupSchedule {
+	return &marklogicv1alpha1.MarkLogicBackupSchedule{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name, Namespace: "marklogic", Generation: 1, UID: types.UID(name + "-uid"),
+			CreationTimestamp: metav1.NewTime(scheduleCreated),
+		},
+		Spec: marklogicv1alpha1.MarkLogicBackupScheduleSpec{
+			ClusterRef:     marklogicv1alpha1.ClusterReference{Name: cluster},
+			BackupTemplate: marklogicv1alpha1.BackupTemplate{Database: "Documents", BackupDir: "/backups"},
+			Schedule:       "0 2 * * *",
+		},
+	}
+}
+
+// newScheduledBackup returns a backup the schedule started at the given time.
+func newScheduledBackup(schedule *marklogicv1alpha1.MarkLogicBackupSchedule, at time.Time, incremental bool,
+	phase marklogicv1alpha1.BackupPhase) *marklogicv1alpha1.MarkLogicBackup {
+	b := newBackup(fmt.Sprintf("%s-%d", schedule.Name, at.Unix()/60), schedule.Spec.ClusterRef.Name, schedule.Spec.Database)
+	b.Spec.Incremental = incremental
+	b.Labels = map[string]string{marklogicv1alpha1.LabelBackupSchedule: schedule.Name}
+	b.Annotations = map[string]string{annotationScheduledTime: at.Format(time.RFC3339)}
+	b.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: marklogicv1alpha1.GroupVersion.String(), Kind: "MarkLogicBackupSchedule",
+		Name: schedule.Name, UID: schedule.UID, Controller: ptr.To(true),
+	}}
+	b.Status.Phase = phase
+	if phase == marklogicv1alpha1.BackupCompleted {
+		b.Status.CompletionTime = &metav1.Time{Time: at.Add(time.Minute)}
+	}
+	return b
+}
+
+type backupScheduleTest struct {
+	r        *MarkLogicBackupScheduleReconciler
+	recorder *record.FakeRecorder
+	now      time.Time
+}
+
+func newBackupScheduleTest(f *fakeMarkLogic, objs ...client.Object) *backupScheduleTest {
+	s := testScheme()
+	c := fake.NewClientBuilder().
+		WithScheme(s).
+		WithObjects(objs...).
+		WithStatusSubresource(&marklogicv1alpha1.MarkLogicBackupSchedule{}, &marklogicv1alpha1.MarkLogicBackup{},
+			&marklogicv1alpha1.MarkLogicCluster{}).
+		Build()
+	st := &backupScheduleTest{recorder: record.NewFakeRecorder(10), now: scheduleCreated}
+	st.r = &MarkLogicBackupScheduleReconciler{Client: c, Scheme: s, Recorder: st.recorder, NewClient: f.newClient,
+		Now: func() time.Time { return st.now }}
+	return st
+}
+
+func (st *backupScheduleTest) reconcile(t *testing.T, name string) (ctrl.Result, *marklogicv1alpha1.MarkLogicBackupSchedule) {
+	t.Helper()
+	key := types.NamespacedName{Namespace: "marklogic", Name: name}
+	result, err := st.r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	schedule := &marklogicv1alpha1.MarkLogicBackupSchedule{}
+	require.NoError(t, st.r.Get(context.Background(), key, schedule))
+	return result, schedule
+}
+
+// backups returns the names of the backups, sorted.
+func (st *backupScheduleTest) backups(t *testing.T) []string {
+	t.Helper()
+	list := &marklogicv1alpha1.MarkLogicBackupList{}
+	require.NoError(t, st.r.List(context.Background(), list))
+	var names []string
+	for _, b := range list.Items {
+		names = append(names, b.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (st *backupScheduleTest) complete(t *testing.T, name string) {
+	t.Helper()
+	b := &marklogicv1alpha1.MarkLogicBackup{}
+	require.NoError(t, st.r.Get(context.Background(), types.NamespacedName{Namespace: "marklogic", Name: name}, b))
+	b.Status.Phase = marklogicv1alpha1.BackupCompleted
+	b.Status.CompletionTime = &metav1.Time{Time: st.now}
+	require.NoError(t, st.r.Status().Update(context.Background(), b))
+}
+
+func TestReconcileBackupScheduleStartsBackups(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	cluster, secret := availableCluster(f, "dnode", 1, 1)
+	schedule := newBackupSchedule("daily", "dnode")
+	schedule.Spec.IncrementalSchedule = "0 * * * *"
+	st := newBackupScheduleTest(f, cluster, secret, schedule)
+	fullName := fmt.Sprintf("daily-full-%d", scheduleCreated.Add(2*time.Hour).Unix()/60)
+	incrName := fmt.Sprintf("daily-incr-%d", scheduleCreated.Add(3*time.Hour).Unix()/60)
+
+	// Incremental backups wait for a full backup.
+	st.now = scheduleCreated.Add(time.Hour + 59*time.Minute)
+	result, schedule := st.reconcile(t, "daily")
+	assert.Equal(t, time.Minute, result.RequeueAfter)
+	assert.Empty(t, st.backups(t))
+	assert.Equal(t, scheduleCreated.Add(time.Hour), schedule.Status.LastIncrementalScheduleTime.Time.UTC())
+	requireCondition(t, schedule.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionTrue, "Reconciled")
+
+	// The full backup wins when both are due.
+	st.now = scheduleCreated.Add(2 * time.Hour)
+	_, schedule = st.reconcile(t, "daily")
+	assert.Equal(t, []string{fullName}, st.backups(t))
+	assert.Equal(t, []string{fullName}, schedule.Status.Active)
+	assert.Equal(t, []string{"Normal BackupScheduled created full backup " + fullName}, events(st.recorder))
+	backup := &marklogicv1alpha1.MarkLogicBackup{}
+	require.NoError(t, st.r.Get(context.Background(), types.NamespacedName{Namespace: "marklogic", Name: fullName}, backup))
+	assert.True(t, metav1.IsControlledBy(backup, schedule))
+	assert.False(t, backup.Spec.Incremental)
+	assert.Equal(t, "/backups", backup.Spec.BackupDir)
+
+	// Nothing starts while a backup runs.
+	st.now = scheduleCreated.Add(3 * time.Hour)
+	st.reconcile(t, "daily")
+	assert.Len(t, st.backups(t), 1)
+
+	st.complete(t, fullName)
+	_, schedule = st.reconcile(t, "daily")
+	assert.Equal(t, []string{fullName, incrName}, st.backups(t))
+	require.NoError(t, st.r.Get(context.Background(), types.NamespacedName{Namespace: "marklogic", Name: incrName}, backup))
+	assert.True(t, backup.Spec.Incremental)
+	assert.Equal(t, st.now, schedule.Status.LastSuccessfulTime.Time.UTC())
+	assert.Equal(t, fullName, schedule.Status.LastPurgedBackup)
+	assert.Equal(t, []string{`xdmp:database-backup-purge("/backups", 7, xdmp:database-forests(xdmp:database("Documents")))`}, f.evals)
+
+	// Suspended schedules start nothing.
+	schedule.Spec.Suspend = true
+	require.NoError(t, st.r.Update(context.Background(), schedule))
+	st.complete(t, incrName)
+	st.now = scheduleCreated.Add(26 * time.Hour)
+	_, schedule = st.reconcile(t, "daily")
+	assert.Len(t, st.backups(t), 2)
+	requireCondition(t, schedule.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionTrue, "Suspended")
+}
+
+func TestReconcileBackupScheduleRetention(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	cluster, secret := availableCluster(f, "dnode", 1, 1)
+	schedule := newBackupSchedule("daily", "dnode")
+	schedule.Spec.Retention = marklogicv1alpha1.BackupRetention{Full: ptr.To[int32](2), Failed: ptr.To[int32](1)}
+	day := func(n int) time.Time { return scheduleCreated.Add(time.Duration(n)*24*time.Hour + 2*time.Hour) }
+	objs := []client.Object{cluster, secret, schedule}
+	for i, b := range []*marklogicv1alpha1.MarkLogicBackup{
+		newScheduledBackup(schedule, day(0), false, marklogicv1alpha1.BackupCompleted),
+		newScheduledBackup(schedule, day(0).Add(time.Hour), true, marklogicv1alpha1.BackupCompleted),
+		newScheduledBackup(schedule, day(1), false, marklogicv1alpha1.BackupFailed),
+		newScheduledBackup(schedule, day(2), false, marklogicv1alpha1.BackupCompleted),
+		newScheduledBackup(schedule, day(2).Add(time.Hour), true, marklogicv1alpha1.BackupCompleted),
+		newScheduledBackup(schedule, day(3), false, marklogicv1alpha1.BackupFailed),
+		newScheduledBackup(schedule, day(4), false, marklogicv1alpha1.BackupCompleted),
+	} {
+		b.Name = fmt.Sprintf("backup-%d", i)
+		objs = append(objs, b)
+	}
+	schedule.Status.LastFullScheduleTime = &metav1.Time{Time: day(4)}
+	st := newBackupScheduleTest(f, objs...)
+	st.now = day(4).Add(time.Hour)
+
+	_, schedule = st.reconcile(t, "daily")
+	assert.Equal(t, []string{"backup-3", "backup-4", "backup-5", "backup-6"}, st.backups(t))
+	assert.Equal(t, "backup-6", schedule.Status.LastPurgedBackup)
+	assert.Equal(t, []string{`xdmp:database-backup-purge("/backups", 2, xdmp:database-forests(xdmp:database("Documents")))`}, f.evals)
+
+	// Backups are purged once per full backup.
+	st.reconcile(t, "daily")
+	assert.Len(t, f.evals, 1)
+}
+
+func TestReconcileBackupScheduleRejectsInvalidSchedule(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	schedule := newBackupSchedule("daily", "dnode")
+	schedule.Spec.Schedule = "every night"
+	st := newBackupScheduleTest(f, schedule)
+	_, schedule = st.reconcile(t, "daily")
+	requireCondition(t, schedule.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "InvalidSpec")
+
+	schedule.Spec.Schedule = "@daily"
+	schedule.Spec.TimeZone = "Mars/Olympus_Mons"
+	require.NoError(t, st.r.Update(context.Background(), schedule))
+	_, schedule = st.reconcile(t, "daily")
+	requireCondition(t, schedule.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "InvalidSpec")
+}
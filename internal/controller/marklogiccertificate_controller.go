@@ -0,0 +1,334 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/internal/certs"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+const (
+	// certificateTemplate is the certificate template of the default App
+	// Servers, created by the bootstrap of the cluster.
+	certificateTemplate = "defaultTemplate"
+	// certRotationInterval is the pause between the certificate insertions of
+	// two hosts.
+	certRotationInterval = 10 * time.Second
+	// certCheckInterval bounds how long the expiry of certificates goes unchecked.
+	certCheckInterval = 12 * time.Hour
+)
+
+// MarkLogicCertificateReconciler keeps the named certificates of a
+// MarkLogicCluster current. It reports their expiry in the cluster status
+// and inserts renewed certificates of spec.tls.certSecretNames into the
+// certificate template of the default App Servers, one host at a time,
+// without restarting the pods.
+type MarkLogicCertificateReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// NewClient creates the MarkLogic clients. Defaults to mlclient.New.
+	NewClient mlclient.Factory
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicclusters,verbs=get;list;watch
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicclusters/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile checks the named certificates of a MarkLogicCluster and inserts
+// the renewed ones into MarkLogic.
+func (r *MarkLogicCertificateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	cluster := &marklogicv1alpha1.MarkLogicCluster{}
+	if err := r.Get(ctx, req.NamespacedName, cluster); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !cluster.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+	desired := cluster.DeepCopy()
+	desired.Default()
+	if !desired.Spec.TLS.EnableOnDefaultAppServers || !namedCertificates(desired) {
+		if cluster.Status.Certificates == nil && meta.FindStatusCondition(cluster.Status.Conditions, marklogicv1alpha1.ConditionCertificatesValid) == nil {
+			return ctrl.Result{}, nil
+		}
+		cluster.Status.Certificates = nil
+		meta.RemoveStatusCondition(&cluster.Status.Conditions, marklogicv1alpha1.ConditionCertificatesValid)
+		return ctrl.Result{}, r.Status().Update(ctx, cluster)
+	}
+
+	st, err := r.observe(ctx, desired, cluster.Status.Certificates)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	cluster.Status.Certificates = st
+	next := r.checkExpiry(cluster, desired)
+
+	result := ctrl.Result{RequeueAfter: next}
+	if *desired.Spec.TLS.Rotate {
+		rotated, err := r.rotate(ctx, cluster, desired)
+		switch {
+		case errors.Is(err, errClusterNotReady), errors.Is(err, errCredentialsUnavailable):
+			result.RequeueAfter = clusterNotReadyRequeue
+		case err != nil:
+			logger.Error(err, "failed to rotate certificates")
+			if statusErr := r.Status().Update(ctx, cluster); statusErr != nil {
+				logger.Error(statusErr, "failed to update MarkLogicCluster status")
+			}
+			return ctrl.Result{}, err
+		case rotated:
+			result.RequeueAfter = certRotationInterval
+		}
+	}
+	return result, r.Status().Update(ctx, cluster)
+}
+
+// observe reads the CA and host certificates of the secrets of the cluster.
+// Hosts keep the insertion state of prev; the first certificate seen for a
+// host is the one its bootstrap inserted.
+func (r *MarkLogicCertificateReconciler) observe(ctx context.Context, c *marklogicv1alpha1.MarkLogicCluster,
+	prev *marklogicv1alpha1.CertificatesStatus) (*marklogicv1alpha1.CertificatesStatus, error) {
+	if prev == nil {
+		prev = &marklogicv1alpha1.CertificatesStatus{}
+	}
+	st := &marklogicv1alpha1.CertificatesStatus{InsertedCAFingerprint: prev.InsertedCAFingerprint}
+	if name := caSecretName(c); name != "" {
+		secret, err := r.secret(ctx, c.Namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		if ca, err := certs.ParseCertificates(secret.Data["cacert.pem"]); err == nil {
+			st.CAFingerprint = fingerprint(ca[0])
+			st.CANotAfter = &metav1.Time{Time: ca[0].NotAfter}
+			if st.InsertedCAFingerprint == "" {
+				st.InsertedCAFingerprint = st.CAFingerprint
+			}
+		}
+	}
+
+	inserted := map[string]marklogicv1alpha1.HostCertificateStatus{}
+	for _, h := range prev.Hosts {
+		inserted[h.Pod] = h
+	}
+	byOrdinal := make([]*marklogicv1alpha1.HostCertificateStatus, *c.Spec.ReplicaCount)
+	for _, name := range certSecretNames(c) {
+		secret, err := r.secret(ctx, c.Namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		chain, err := certs.ParseCertificates(secret.Data["tls.crt"])
+		if err != nil {
+			continue
+		}
+		for i := range byOrdinal {
+			if byOrdinal[i] != nil || !certs.IssuedFor(chain[0], hostFQDN(c, i)) {
+				continue
+			}
+			h := marklogicv1alpha1.HostCertificateStatus{
+				Pod:         podName(c, i),
+				Secret:      name,
+				Fingerprint: fingerprint(chain[0]),
+				NotAfter:    metav1.Time{Time: chain[0].NotAfter},
+			}
+			if p, ok := inserted[h.Pod]; ok {
+				h.InsertedFingerprint, h.InsertTime = p.InsertedFingerprint, p.InsertTime
+			} else {
+				h.InsertedFingerprint = h.Fingerprint
+			}
+			byOrdinal[i] = &h
+			break
+		}
+	}
+	for _, h := range byOrdinal {
+		if h != nil {
+			st.Hosts = append(st.Hosts, *h)
+		}
+	}
+	return st, nil
+}
+
+// secret returns the named secret, an empty one when it does not exist yet.
+func (r *MarkLogicCertificateReconciler) secret(ctx context.Context, namespace, name string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, secret)
+	if apierrors.IsNotFound(err) {
+		return &corev1.Secret{}, nil
+	}
+	return secret, err
+}
+
+// checkExpiry sets the CertificatesValid condition, with a warning event when
+// a certificate starts expiring, and returns when to check again.
+func (r *MarkLogicCertificateReconciler) checkExpiry(cluster, desired *marklogicv1alpha1.MarkLogicCluster) time.Duration {
+	st := cluster.Status.Certificates
+	now := r.now()
+	renewBefore := desired.Spec.TLS.RenewBefore.Duration
+	next := certCheckInterval
+	var expired, expiring []string
+	check := func(name string, notAfter time.Time) {
+		switch {
+		case !now.Before(notAfter):
+			expired = append(expired, fmt.Sprintf("%s on %s", name, notAfter.UTC().Format(time.RFC3339)))
+		case !now.Before(notAfter.Add(-renewBefore)):
+			expiring = append(expiring, fmt.Sprintf("%s on %s", name, notAfter.UTC().Format(time.RFC3339)))
+			next = min(next, notAfter.Sub(now))
+		default:
+			next = min(next, notAfter.Add(-renewBefore).Sub(now))
+		}
+	}
+	if st.CANotAfter != nil {
+		check("CA certificate", st.CANotAfter.Time)
+	}
+	for _, h := range st.Hosts {
+		check("certificate of "+h.Pod, h.NotAfter.Time)
+	}
+
+	status, reason, message := metav1.ConditionTrue, "Valid", fmt.Sprintf("%d host certificates are valid", len(st.Hosts))
+	switch {
+	case len(expired) > 0:
+		status, reason, message = metav1.ConditionFalse, "Expired", "expired: "+strings.Join(append(expired, expiring...), ", ")
+	case len(expiring) > 0:
+		status, reason, message = metav1.ConditionFalse, "Expiring", "expiring: "+strings.Join(expiring, ", ")
+	}
+	prev := meta.FindStatusCondition(cluster.Status.Conditions, marklogicv1alpha1.ConditionCertificatesValid)
+	if status == metav1.ConditionFalse && (prev == nil || prev.Message != message) {
+		r.Recorder.Eventf(cluster, corev1.EventTypeWarning, "Certificate"+reason, "certificates %s", message)
+	}
+	setCondition(&cluster.Status.Conditions, cluster.Generation, marklogicv1alpha1.ConditionCertificatesValid, status, reason, message)
+	return next
+}
+
+// rotate trusts a renewed CA, then inserts the renewed certificate of the
+// first host that runs an older one. It reports whether it inserted one.
+func (r *MarkLogicCertificateReconciler) rotate(ctx context.Context, cluster, desired *marklogicv1alpha1.MarkLogicCluster) (bool, error) {
+	st := cluster.Status.Certificates
+	pending := st.CAFingerprint != st.InsertedCAFingerprint
+	for _, h := range st.Hosts {
+		pending = pending || h.Fingerprint != h.InsertedFingerprint
+	}
+	if !pending {
+		return false, nil
+	}
+	mc, err := clusterClient(ctx, r.Client, r.NewClient, desired)
+	if err != nil {
+		return false, err
+	}
+
+	if st.CAFingerprint != st.InsertedCAFingerprint {
+		secret, err := r.secret(ctx, desired.Namespace, caSecretName(desired))
+		if err != nil {
+			return false, err
+		}
+		if err := mc.InsertCertificateAuthority(ctx, string(secret.Data["cacert.pem"])); err != nil {
+			return false, fmt.Errorf("inserting CA certificate: %w", err)
+		}
+		st.InsertedCAFingerprint = st.CAFingerprint
+		r.Recorder.Eventf(cluster, corev1.EventTypeNormal, "CARotated", "trusted the renewed CA certificate of secret %s", secret.Name)
+	}
+
+	for i := range st.Hosts {
+		h := &st.Hosts[i]
+		if h.Fingerprint == h.InsertedFingerprint {
+			continue
+		}
+		pod := &corev1.Pod{}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: desired.Namespace, Name: h.Pod}, pod); err != nil || !podReady(pod) {
+			// The pod picks the certificate up when it starts.
+			continue
+		}
+		secret, err := r.secret(ctx, desired.Namespace, h.Secret)
+		if err != nil {
+			return false, err
+		}
+		if _, err := tls.X509KeyPair(secret.Data["tls.crt"], secret.Data["tls.key"]); err != nil {
+			r.Recorder.Eventf(cluster, corev1.EventTypeWarning, "InvalidCertificate", "secret %s: %v", h.Secret, err)
+			continue
+		}
+		host := hostFQDN(desired, podOrdinal(h.Pod))
+		hc, err := hostClient(ctx, r.Client, r.NewClient, desired, host)
+		if err != nil {
+			return false, err
+		}
+		log.FromContext(ctx).Info("inserting renewed certificate", "host", host, "secret", h.Secret)
+		cert := mlclient.HostCertificate{Cert: string(secret.Data["tls.crt"]), PKey: string(secret.Data["tls.key"])}
+		if err := hc.InsertHostCertificates(ctx, certificateTemplate, cert); err != nil {
+			return false, fmt.Errorf("inserting certificate of host %s: %w", host, err)
+		}
+		h.InsertedFingerprint = h.Fingerprint
+		h.InsertTime = &metav1.Time{Time: r.now()}
+		r.Recorder.Eventf(cluster, corev1.EventTypeNormal, "CertificateRotated", "inserted the renewed certificate of host %s, valid until %s",
+			host, h.NotAfter.UTC().Format(time.RFC3339))
+		return true, nil
+	}
+	return false, nil
+}
+
+func (r *MarkLogicCertificateReconciler) now() time.Time {
+	if r.Now == nil {
+		return time.Now()
+	}
+	return r.Now()
+}
+
+// fingerprint returns the hex encoded SHA-256 digest of cert.
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// certificateClusters maps a secret to the clusters using it as named
+// certificate or CA.
+func (r *MarkLogicCertificateReconciler) certificateClusters(ctx context.Context, obj client.Object) []ctrl.Request {
+	clusters := &marklogicv1alpha1.MarkLogicClusterList{}
+	if err := r.List(ctx, clusters, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	var requests []ctrl.Request
+	for i := range clusters.Items {
+		c := clusters.Items[i].DeepCopy()
+		c.Default()
+		uses := caSecretName(c) == obj.GetName()
+		for _, name := range certSecretNames(c) {
+			uses = uses || name == obj.GetName()
+		}
+		if uses {
+			requests = append(requests, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: c.Namespace, Name: c.Name}})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MarkLogicCertificateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("marklogiccertificate").
+		For(&marklogicv1alpha1.MarkLogicCluster{}).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.certificateClusters)).
+		Complete(r)
+}
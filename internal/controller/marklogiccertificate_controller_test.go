@@ -0,0 +1,232 @@
+package controller
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+// testCA signs the named certificates of the tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "MarkLogic CA"},
+		NotBefore:             scheduleCreated.Add(-time.Hour),
+		NotAfter:              scheduleCreated.Add(5 * 365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) secret(name string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "marklogic"},
+		Data:       map[string][]byte{"cacert.pem": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})},
+	}
+}
+
+// hostSecret returns a secret with a certificate for host, valid until notAfter.
+func (ca *testCA) hostSecret(t *testing.T, name, host string, notAfter time.Time) *corev1.Secret {
+	crt, key := ca.issue(t, "", []string{host}, notAfter)
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "marklogic"},
+		Data:       map[string][]byte{"tls.crt": crt, "tls.key": key},
+	}
+}
+
+// issue returns a PEM encoded certificate and PKCS#8 key signed by the CA.
+func (ca *testCA) issue(t *testing.T, commonName string, dnsNames []string, notAfter time.Time) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    scheduleCreated.Add(-time.Hour),
+		NotAfter:     notAfter,
+	}, ca.cert, key.Public(), ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+type certificateTest struct {
+	r        *MarkLogicCertificateReconciler
+	recorder *record.FakeRecorder
+	ca       *testCA
+	now      time.Time
+}
+
+// newCertificateTest returns a cluster of two ready hosts using named
+// certificates valid for a year.
+func newCertificateTest(t *testing.T, f *fakeMarkLogic) *certificateTest {
+	cluster, secret := availableCluster(f, "dnode", 2, 2)
+	cluster.Spec.TLS = marklogicv1alpha1.TLS{
+		EnableOnDefaultAppServers: true,
+		CertSecretNames:           []string{"cert-a", "cert-b"},
+		CASecretName:              "ca-cert",
+	}
+	ca := newTestCA(t)
+	objs := []client.Object{cluster, secret, ca.secret("ca-cert"),
+		// The secrets need not be in ordinal order.
+		ca.hostSecret(t, "cert-a", "dnode-1.dnode.marklogic.svc.cluster.local", scheduleCreated.AddDate(1, 0, 0)),
+		ca.hostSecret(t, "cert-b", "dnode-0.dnode.marklogic.svc.cluster.local", scheduleCreated.AddDate(1, 0, 0)),
+	}
+	for i := 0; i < 2; i++ {
+		objs = append(objs, upgradePod(cluster, i, "dnode-1", oldImage))
+	}
+	s := testScheme()
+	c := fake.NewClientBuilder().
+		WithScheme(s).
+		WithObjects(objs...).
+		WithStatusSubresource(&marklogicv1alpha1.MarkLogicCluster{}).
+		Build()
+	ct := &certificateTest{recorder: record.NewFakeRecorder(10), ca: ca, now: scheduleCreated}
+	ct.r = &MarkLogicCertificateReconciler{Client: c, Scheme: s, Recorder: ct.recorder, NewClient: f.newClient,
+		Now: func() time.Time { return ct.now }}
+	return ct
+}
+
+func (ct *certificateTest) reconcile(t *testing.T) (ctrl.Result, *marklogicv1alpha1.MarkLogicCluster) {
+	t.Helper()
+	key := types.NamespacedName{Namespace: "marklogic", Name: "dnode"}
+	result, err := ct.r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	cluster := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, ct.r.Get(context.Background(), key, cluster))
+	return result, cluster
+}
+
+// renew replaces the secret, as cert-manager does when it renews a certificate.
+func (ct *certificateTest) renew(t *testing.T, secret *corev1.Secret) {
+	t.Helper()
+	current := &corev1.Secret{}
+	require.NoError(t, ct.r.Get(context.Background(), client.ObjectKeyFromObject(secret), current))
+	current.Data = secret.Data
+	require.NoError(t, ct.r.Update(context.Background(), current))
+}
+
+func TestReconcileCertificatesRotatesRenewedCertificates(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	ct := newCertificateTest(t, f)
+
+	result, cluster := ct.reconcile(t)
+	assert.Equal(t, certCheckInterval, result.RequeueAfter)
+	st := cluster.Status.Certificates
+	require.NotNil(t, st)
+	assert.Equal(t, fingerprint(ct.ca.cert), st.CAFingerprint)
+	assert.Equal(t, st.CAFingerprint, st.InsertedCAFingerprint)
+	require.Len(t, st.Hosts, 2)
+	assert.Equal(t, "dnode-0", st.Hosts[0].Pod)
+	assert.Equal(t, "cert-b", st.Hosts[0].Secret)
+	assert.Equal(t, "dnode-1", st.Hosts[1].Pod)
+	assert.Equal(t, st.Hosts[1].Fingerprint, st.Hosts[1].InsertedFingerprint, "the bootstrap inserted the first certificates")
+	assert.True(t, st.Hosts[1].NotAfter.Time.Equal(scheduleCreated.AddDate(1, 0, 0)))
+	requireCondition(t, cluster.Status.Conditions, marklogicv1alpha1.ConditionCertificatesValid, metav1.ConditionTrue, "Valid")
+	assert.Empty(t, f.certificates)
+
+	// Renewed certificates are inserted one host at a time.
+	renewedA := ct.ca.hostSecret(t, "cert-a", "dnode-1.dnode.marklogic.svc.cluster.local", scheduleCreated.AddDate(2, 0, 0))
+	renewedB := ct.ca.hostSecret(t, "cert-b", "dnode-0.dnode.marklogic.svc.cluster.local", scheduleCreated.AddDate(2, 0, 0))
+	ct.renew(t, renewedA)
+	ct.renew(t, renewedB)
+	result, cluster = ct.reconcile(t)
+	assert.Equal(t, certRotationInterval, result.RequeueAfter)
+	assert.Equal(t, []string{string(renewedB.Data["tls.crt"])}, f.certificates)
+	st = cluster.Status.Certificates
+	assert.Equal(t, st.Hosts[0].Fingerprint, st.Hosts[0].InsertedFingerprint)
+	assert.NotNil(t, st.Hosts[0].InsertTime)
+	assert.NotEqual(t, st.Hosts[1].Fingerprint, st.Hosts[1].InsertedFingerprint)
+	assert.Equal(t, []string{
+		"Normal CertificateRotated inserted the renewed certificate of host dnode-0.dnode.marklogic.svc.cluster.local, valid until 2026-03-01T00:00:00Z",
+	}, events(ct.recorder))
+
+	result, _ = ct.reconcile(t)
+	assert.Equal(t, certRotationInterval, result.RequeueAfter)
+	assert.Len(t, f.certificates, 2)
+	result, _ = ct.reconcile(t)
+	assert.Equal(t, certCheckInterval, result.RequeueAfter)
+	assert.Len(t, f.certificates, 2)
+	assert.Len(t, events(ct.recorder), 1)
+
+	// A renewed CA is trusted before the certificates it signed are inserted.
+	ca := newTestCA(t)
+	ct.renew(t, ca.secret("ca-cert"))
+	_, cluster = ct.reconcile(t)
+	assert.Equal(t, []string{string(ca.secret("ca-cert").Data["cacert.pem"])}, f.authorities)
+	assert.Equal(t, fingerprint(ca.cert), cluster.Status.Certificates.InsertedCAFingerprint)
+	assert.Equal(t, []string{"Normal CARotated trusted the renewed CA certificate of secret ca-cert"}, events(ct.recorder))
+}
+
+func TestReconcileCertificatesReportsExpiry(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	ct := newCertificateTest(t, f)
+
+	ct.now = scheduleCreated.AddDate(1, 0, -10)
+	result, cluster := ct.reconcile(t)
+	assert.Equal(t, certCheckInterval, result.RequeueAfter)
+	requireCondition(t, cluster.Status.Conditions, marklogicv1alpha1.ConditionCertificatesValid, metav1.ConditionFalse, "Expiring")
+	msg := "expiring: certificate of dnode-0 on 2025-03-01T00:00:00Z, certificate of dnode-1 on 2025-03-01T00:00:00Z"
+	assert.Equal(t, []string{"Warning CertificateExpiring certificates " + msg}, events(ct.recorder))
+	ct.reconcile(t)
+	assert.Empty(t, events(ct.recorder), "the warning is not repeated")
+
+	ct.now = scheduleCreated.AddDate(1, 0, 0)
+	_, cluster = ct.reconcile(t)
+	requireCondition(t, cluster.Status.Conditions, marklogicv1alpha1.ConditionCertificatesValid, metav1.ConditionFalse, "Expired")
+	assert.Len(t, events(ct.recorder), 1)
+
+	// The certificate of a pod that is not ready is inserted once it is.
+	ct.renew(t, ct.ca.hostSecret(t, "cert-b", "dnode-0.dnode.marklogic.svc.cluster.local", scheduleCreated.AddDate(2, 0, 0)))
+	pod := &corev1.Pod{}
+	require.NoError(t, ct.r.Get(context.Background(), types.NamespacedName{Namespace: "marklogic", Name: "dnode-0"}, pod))
+	pod.Status.Conditions[0].Status = corev1.ConditionFalse
+	require.NoError(t, ct.r.Status().Update(context.Background(), pod))
+	_, cluster = ct.reconcile(t)
+	assert.Empty(t, f.certificates)
+	requireCondition(t, cluster.Status.Conditions, marklogicv1alpha1.ConditionCertificatesValid, metav1.ConditionFalse, "Expired")
+
+	// Turning TLS off clears the status.
+	cluster.Spec.TLS.EnableOnDefaultAppServers = false
+	require.NoError(t, ct.r.Update(context.Background(), cluster))
+	_, cluster = ct.reconcile(t)
+	assert.Nil(t, cluster.Status.Certificates)
+	assert.Nil(t, meta.FindStatusCondition(cluster.Status.Conditions, marklogicv1alpha1.ConditionCertificatesValid))
+}
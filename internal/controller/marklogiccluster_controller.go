@@ -0,0 +1,470 @@
+// Package controller contains the reconcilers of the MarkLogic operator.
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+// maxHostnameLength is the longest CN MarkLogic accepts in a host certificate.
+const maxHostnameLength = 64
+
+// MarkLogicClusterReconciler reconciles a MarkLogicCluster object
+type MarkLogicClusterReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicclusters,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicclusters/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicclusters/finalizers,verbs=update
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicappservers,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=statefulsets;deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=services;configmaps;secrets;serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile drives the StatefulSet, services, config maps, disruption budget and optional HAProxy of a
+// MarkLogicCluster towards the state described by its spec.
+func (r *MarkLogicClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	cluster := &marklogicv1alpha1.MarkLogicCluster{}
+	if err := r.Get(ctx, req.NamespacedName, cluster); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !cluster.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	desired := cluster.DeepCopy()
+	desired.Default()
+
+	if err := r.validate(ctx, desired); err != nil {
+		logger.Info("invalid MarkLogicCluster spec", "reason", err.Error())
+		meta.SetStatusCondition(&cluster.Status.Conditions, metav1.Condition{
+			Type:               marklogicv1alpha1.ConditionReconciled,
+			Status:             metav1.ConditionFalse,
+			Reason:             "InvalidSpec",
+			Message:            err.Error(),
+			ObservedGeneration: cluster.Generation,
+		})
+		return ctrl.Result{}, r.Status().Update(ctx, cluster)
+	}
+
+	servers, err := appServersFor(ctx, r, desired)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	addAppServers(desired, servers)
+
+	current := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: desired.Namespace, Name: desired.Name}, current); err == nil && current.Spec.Replicas != nil {
+		desired.Spec.ReplicaCount = ptr.To(replicasDuringScaleDown(desired, *current.Spec.Replicas))
+	} else if err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+
+	sts, err := r.reconcileResources(ctx, desired)
+	if err != nil {
+		meta.SetStatusCondition(&cluster.Status.Conditions, metav1.Condition{
+			Type:               marklogicv1alpha1.ConditionReconciled,
+			Status:             metav1.ConditionFalse,
+			Reason:             "ReconcileError",
+			Message:            err.Error(),
+			ObservedGeneration: cluster.Generation,
+		})
+		if statusErr := r.Status().Update(ctx, cluster); statusErr != nil {
+			logger.Error(statusErr, "failed to update MarkLogicCluster status")
+		}
+		return ctrl.Result{}, err
+	}
+
+	r.setStatus(cluster, desired, sts)
+	setCredentialsAvailable(cluster, desired)
+	if err := r.setBootstrapStatus(ctx, cluster, desired); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, r.Status().Update(ctx, cluster)
+}
+
+// validate rejects specs the chart refuses to render.
+func (r *MarkLogicClusterReconciler) validate(ctx context.Context, c *marklogicv1alpha1.MarkLogicCluster) error {
+	if c.Spec.TLS.CertManager != nil && len(c.Spec.TLS.CertSecretNames) > 0 {
+		return fmt.Errorf("tls.certManager and tls.certSecretNames are mutually exclusive")
+	}
+	switch {
+	case c.Spec.Auth.Provider == marklogicv1alpha1.CredentialsProviderFile && c.Spec.Auth.CSI == nil:
+		return fmt.Errorf("auth.csi must be set for the File credentials provider")
+	case c.Spec.Auth.Provider == marklogicv1alpha1.CredentialsProviderVault && c.Spec.Auth.Vault == nil:
+		return fmt.Errorf("auth.vault must be set for the Vault credentials provider")
+	case c.Spec.Auth.Provider == marklogicv1alpha1.CredentialsProviderVault && c.Spec.Agent.Image == "":
+		return fmt.Errorf("the Vault credentials provider needs the agent, please set agent.image")
+	case c.Spec.Metrics.Enabled && c.Spec.Agent.Image == "":
+		return fmt.Errorf("the metrics exporter needs the agent, please set agent.image")
+	case c.Spec.LogCollection.Enabled && c.Spec.Agent.Image == "":
+		return fmt.Errorf("the log shipper needs the agent, please set agent.image")
+	case c.Spec.LogCollection.Enabled && c.Spec.LogCollection.Endpoint == "":
+		return fmt.Errorf("logCollection.endpoint must be set to ship the logs")
+	case c.Spec.LogCollection.Enabled && len(logFiles(c.Spec.LogCollection.Files)) == 0:
+		return fmt.Errorf("logCollection.files selects no log file")
+	}
+	if name := fqdn(c); len(name) > maxHostnameLength && !c.Spec.AllowLongHostnames {
+		return fmt.Errorf("the FQDN %s is longer than %d characters, MarkLogic App Server does not support turning on SSL with it; "+
+			"use a shorter name or set allowLongHostnames to true", name, maxHostnameLength)
+	}
+
+	clusters := &marklogicv1alpha1.MarkLogicClusterList{}
+	if err := r.List(ctx, clusters); err != nil {
+		return err
+	}
+	for i := range clusters.Items {
+		other := clusters.Items[i].DeepCopy()
+		if other.UID == c.UID {
+			continue
+		}
+		other.Default()
+		if clusterName(other) == clusterName(c) && other.Spec.Group.Name == c.Spec.Group.Name {
+			return fmt.Errorf("group %q is already used by MarkLogicCluster %s/%s in the same MarkLogic cluster, please choose another group name",
+				c.Spec.Group.Name, other.Namespace, other.Name)
+		}
+	}
+	return nil
+}
+
+func (r *MarkLogicClusterReconciler) reconcileResources(ctx context.Context, c *marklogicv1alpha1.MarkLogicCluster) (*appsv1.StatefulSet, error) {
+	if c.Spec.Auth.Provider == marklogicv1alpha1.CredentialsProviderSecret {
+		if c.Spec.Auth.SecretName == "" {
+			secret := &corev1.Secret{ObjectMeta: r.objectMeta(c, authSecretName(c))}
+			if err := r.createOrUpdate(ctx, c, secret, func() error {
+				secret.Labels = mergeMaps(secret.Labels, labels(c))
+				return fillAdminSecret(secret)
+			}); err != nil {
+				return nil, err
+			}
+		}
+		if err := r.reconcileActiveAuthSecret(ctx, c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Spec.ServiceAccountName == "" {
+		sa := &corev1.ServiceAccount{ObjectMeta: r.objectMeta(c, serviceAccountName(c))}
+		if err := r.createOrUpdate(ctx, c, sa, func() error {
+			sa.Labels = mergeMaps(sa.Labels, labels(c))
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	envConfig := &corev1.ConfigMap{ObjectMeta: r.objectMeta(c, c.Name)}
+	if err := r.createOrUpdate(ctx, c, envConfig, func() error {
+		envConfig.Labels = mergeMaps(envConfig.Labels, labels(c))
+		envConfig.Data = envConfigData(c)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	scripts := scriptsData()
+	scriptsConfig := &corev1.ConfigMap{ObjectMeta: r.objectMeta(c, scriptsConfigMapName(c))}
+	if err := r.createOrUpdate(ctx, c, scriptsConfig, func() error {
+		scriptsConfig.Labels = mergeMaps(scriptsConfig.Labels, labels(c))
+		scriptsConfig.Data = scripts
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	headless := &corev1.Service{ObjectMeta: r.objectMeta(c, headlessServiceName(c))}
+	if err := r.createOrUpdate(ctx, c, headless, func() error {
+		mutateHeadlessService(c, headless)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	clusterSvc := &corev1.Service{ObjectMeta: r.objectMeta(c, clusterServiceName(c))}
+	if err := r.createOrUpdate(ctx, c, clusterSvc, func() error {
+		mutateClusterService(c, clusterSvc)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	stsSpec := c
+	if c.Spec.TLS.EnableOnDefaultAppServers && c.Spec.TLS.CertManager != nil {
+		issued, err := r.reconcileCertificates(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		// Without its certificate a pod would start with a temporary one, so
+		// new pods wait until cert-manager has issued it.
+		if issued < *c.Spec.ReplicaCount {
+			log.FromContext(ctx).Info("waiting for host certificates", "issued", issued, "replicas", *c.Spec.ReplicaCount)
+			stsSpec = c.DeepCopy()
+			stsSpec.Spec.ReplicaCount = ptr.To(issued)
+		}
+	}
+
+	if err := r.reconcileCAExport(ctx, c); err != nil {
+		return nil, err
+	}
+	if err := r.reconcileStatusReporter(ctx, c); err != nil {
+		return nil, err
+	}
+
+	sts := &appsv1.StatefulSet{ObjectMeta: r.objectMeta(c, c.Name)}
+	if err := r.createOrUpdate(ctx, c, sts, func() error {
+		mutateStatefulSet(stsSpec, sts, checksum(scripts))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := r.reconcilePodDisruptionBudget(ctx, c); err != nil {
+		return nil, err
+	}
+	if err := r.reconcileMetrics(ctx, c); err != nil {
+		return nil, err
+	}
+	if err := r.reconcileHAProxy(ctx, c); err != nil {
+		return nil, err
+	}
+	return sts, nil
+}
+
+func (r *MarkLogicClusterReconciler) reconcileHAProxy(ctx context.Context, c *marklogicv1alpha1.MarkLogicCluster) error {
+	cm := &corev1.ConfigMap{ObjectMeta: r.objectMeta(c, haproxyName(c))}
+	svc := &corev1.Service{ObjectMeta: r.objectMeta(c, haproxyName(c))}
+	dep := &appsv1.Deployment{ObjectMeta: r.objectMeta(c, haproxyName(c))}
+
+	if !c.Spec.HAProxy.Enabled {
+		for _, obj := range []client.Object{dep, svc, cm} {
+			if err := r.deleteOwned(ctx, c, obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ca, err := publishedCA(ctx, r, c)
+	if err != nil {
+		return err
+	}
+	verify := c.Spec.TLS.EnableOnDefaultAppServers && ca != ""
+	cfg, err := haproxyConfig(c, verify)
+	if err != nil {
+		return fmt.Errorf("rendering haproxy.cfg: %w", err)
+	}
+	if err := r.createOrUpdate(ctx, c, cm, func() error {
+		mutateHAProxyConfigMap(c, cm, cfg)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := r.createOrUpdate(ctx, c, svc, func() error {
+		mutateHAProxyService(c, svc)
+		return nil
+	}); err != nil {
+		return err
+	}
+	return r.createOrUpdate(ctx, c, dep, func() error {
+		mutateHAProxyDeployment(c, dep, cfg, verify)
+		return nil
+	})
+}
+
+// createOrUpdate applies mutate to obj and makes the cluster its controller before writing it.
+func (r *MarkLogicClusterReconciler) createOrUpdate(ctx context.Context, c *marklogicv1alpha1.MarkLogicCluster, obj client.Object, mutate func() error) error {
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, obj, func() error {
+		if err := mutate(); err != nil {
+			return err
+		}
+		return controllerutil.SetControllerReference(c, obj, r.Scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("reconciling %T %s: %w", obj, obj.GetName(), err)
+	}
+	if op != controllerutil.OperationResultNone {
+		log.FromContext(ctx).Info("reconciled resource", "kind", fmt.Sprintf("%T", obj), "name", obj.GetName(), "operation", op)
+	}
+	return nil
+}
+
+// deleteOwned deletes obj if it exists and is controlled by the cluster.
+func (r *MarkLogicClusterReconciler) deleteOwned(ctx context.Context, c *marklogicv1alpha1.MarkLogicCluster, obj client.Object) error {
+	if err := r.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(obj, c) {
+		return nil
+	}
+	if err := r.Delete(ctx, obj); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("deleting %T %s: %w", obj, obj.GetName(), err)
+	}
+	return nil
+}
+
+func (r *MarkLogicClusterReconciler) objectMeta(c *marklogicv1alpha1.MarkLogicCluster, name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Name: name, Namespace: c.Namespace}
+}
+
+func (r *MarkLogicClusterReconciler) setStatus(cluster, desired *marklogicv1alpha1.MarkLogicCluster, sts *appsv1.StatefulSet) {
+	cluster.Status.ObservedGeneration = cluster.Generation
+	cluster.Status.Replicas = sts.Status.Replicas
+	cluster.Status.ReadyReplicas = sts.Status.ReadyReplicas
+	cluster.Status.Selector = k8slabels.SelectorFromSet(selectorLabels(desired)).String()
+	cluster.Status.BootstrapHost = clusterName(desired)
+
+	meta.SetStatusCondition(&cluster.Status.Conditions, metav1.Condition{
+		Type:               marklogicv1alpha1.ConditionReconciled,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Reconciled",
+		Message:            "All resources are up to date",
+		ObservedGeneration: cluster.Generation,
+	})
+	available := metav1.Condition{
+		Type:               marklogicv1alpha1.ConditionAvailable,
+		Status:             metav1.ConditionFalse,
+		Reason:             "PodsNotReady",
+		Message:            fmt.Sprintf("%d of %d MarkLogic pods are ready", sts.Status.ReadyReplicas, *desired.Spec.ReplicaCount),
+		ObservedGeneration: cluster.Generation,
+	}
+	if sts.Status.ReadyReplicas == *desired.Spec.ReplicaCount {
+		available.Status = metav1.ConditionTrue
+		available.Reason = "PodsReady"
+	}
+	meta.SetStatusCondition(&cluster.Status.Conditions, available)
+}
+
+// setCredentialsAvailable reports whether the operator can read the admin
+// credentials of a cluster using the File provider. Without them the pods
+// run, but databases, users, backups and the other resources configured
+// through the Management API wait.
+func setCredentialsAvailable(cluster, desired *marklogicv1alpha1.MarkLogicCluster) {
+	if desired.Spec.Auth.Provider != marklogicv1alpha1.CredentialsProviderFile {
+		meta.RemoveStatusCondition(&cluster.Status.Conditions, marklogicv1alpha1.ConditionCredentialsAvailable)
+		return
+	}
+	dir := credentialsDir(desired)
+	if !isDir(dir) {
+		setCondition(&cluster.Status.Conditions, cluster.Generation, marklogicv1alpha1.ConditionCredentialsAvailable, metav1.ConditionFalse,
+			"NotMounted", fmt.Sprintf("the File credentials provider needs the credentials mounted in the operator at %s, "+
+				"please add a volume of the CSI driver to the operator deployment", dir))
+		return
+	}
+	setCondition(&cluster.Status.Conditions, cluster.Generation, marklogicv1alpha1.ConditionCredentialsAvailable, metav1.ConditionTrue,
+		"Mounted", "the credentials are mounted in the operator at "+dir)
+}
+
+// fillAdminSecret generates the admin credentials the chart would generate in
+// charts/templates/secret.yaml. Existing values are kept.
+func fillAdminSecret(secret *corev1.Secret) error {
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Type = corev1.SecretTypeOpaque
+	generate := map[string]func() (string, error){
+		"username": func() (string, error) {
+			s, err := randAlphaNum(5)
+			return "admin-" + s, err
+		},
+		"password":        func() (string, error) { return randAlphaNum(10) },
+		"wallet-password": func() (string, error) { return randAlphaNum(10) },
+	}
+	for key, gen := range generate {
+		if len(secret.Data[key]) > 0 {
+			continue
+		}
+		v, err := gen()
+		if err != nil {
+			return err
+		}
+		secret.Data[key] = []byte(v)
+	}
+	return nil
+}
+
+// reconcileActiveAuthSecret creates the secret the pods read the admin
+// credentials from as a copy of the auth secret. Once it exists, only the
+// password controller changes its data, after MarkLogic accepted the new
+// password.
+func (r *MarkLogicClusterReconciler) reconcileActiveAuthSecret(ctx context.Context, c *marklogicv1alpha1.MarkLogicCluster) error {
+	active := &corev1.Secret{ObjectMeta: r.objectMeta(c, activeAuthSecretName(c))}
+	return r.createOrUpdate(ctx, c, active, func() error {
+		active.Labels = mergeMaps(active.Labels, labels(c))
+		if len(active.Data) > 0 {
+			return nil
+		}
+		auth := &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: c.Namespace, Name: authSecretName(c)}, auth); err != nil {
+			return fmt.Errorf("reading admin credentials: %w", err)
+		}
+		active.Type = corev1.SecretTypeOpaque
+		active.Data = auth.Data
+		return nil
+	})
+}
+
+const alphaNum = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+func randAlphaNum(n int) (string, error) {
+	b := make([]byte, n)
+	for i := range b {
+		idx, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphaNum))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = alphaNum[idx.Int64()]
+	}
+	return string(b), nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MarkLogicClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&marklogicv1alpha1.MarkLogicCluster{}).
+		Owns(&appsv1.StatefulSet{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.Service{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.Secret{}).
+		Owns(&corev1.ServiceAccount{}).
+		Owns(&policyv1.PodDisruptionBudget{}).
+		Owns(&rbacv1.Role{}).
+		Owns(&rbacv1.RoleBinding{}).
+		Watches(&marklogicv1alpha1.MarkLogicAppServer{},
+			handler.EnqueueRequestsFromMapFunc(appServerCluster)).
+		// Host certificates issued by cert-manager
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(podCluster)).
+		// Bootstrap phases reported by the agent
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(podCluster),
+			builder.WithPredicates(predicate.AnnotationChangedPredicate{})).
+		Complete(r)
+}
@@ -0,0 +1,420 @@
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/internal/credentials"
+)
+
+func testScheme() *runtime.Scheme {
+	s := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(s))
+	utilruntime.Must(marklogicv1alpha1.AddToScheme(s))
+	return s
+}
+
+func newCluster(name string) *marklogicv1alpha1.MarkLogicCluster {
+	return &marklogicv1alpha1.MarkLogicCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "marklogic", UID: types.UID(name + "-uid")},
+	}
+}
+
+func newReconciler(objs ...client.Object) *MarkLogicClusterReconciler {
+	s := testScheme()
+	c := fake.NewClientBuilder().
+		WithScheme(s).
+		WithObjects(objs...).
+		WithStatusSubresource(&marklogicv1alpha1.MarkLogicCluster{}).
+		Build()
+	return &MarkLogicClusterReconciler{Client: c, Scheme: s}
+}
+
+func reconcile(t *testing.T, r *MarkLogicClusterReconciler, name string) {
+	t.Helper()
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "marklogic", Name: name}})
+	require.NoError(t, err)
+}
+
+func TestReconcileCreatesChartResources(t *testing.T) {
+	cluster := newCluster("dnode")
+	cluster.Spec.ReplicaCount = ptr.To[int32](3)
+	cluster.Spec.TLS.EnableOnDefaultAppServers = true
+	cluster.Spec.TLS.CertSecretNames = []string{"cert-0", "cert-1", "cert-2"}
+	cluster.Spec.TLS.CASecretName = "ca-cert"
+	r := newReconciler(cluster)
+	ctx := context.Background()
+
+	reconcile(t, r, "dnode")
+
+	sts := &appsv1.StatefulSet{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode"}, sts))
+	require.Equal(t, int32(3), *sts.Spec.Replicas)
+	require.Equal(t, "dnode", sts.Spec.ServiceName)
+	require.Equal(t, appsv1.OnDeleteStatefulSetStrategyType, sts.Spec.UpdateStrategy.Type)
+	require.Equal(t, appsv1.ParallelPodManagement, sts.Spec.PodManagementPolicy)
+	require.Equal(t, "dnode-0.dnode.marklogic.svc.cluster.local", sts.Annotations[annotationFQDN])
+	require.Len(t, sts.Spec.VolumeClaimTemplates, 1)
+	require.Equal(t, "10Gi", sts.Spec.VolumeClaimTemplates[0].Spec.Resources.Requests.Storage().String())
+	require.True(t, metav1.IsControlledBy(sts, cluster))
+
+	pod := sts.Spec.Template.Spec
+	require.Equal(t, "progressofficial/marklogic-db:11.3.0-ubi-rootless", pod.Containers[0].Image)
+	require.Equal(t, []string{"dnode.marklogic.svc.cluster.local"}, pod.DNSConfig.Searches)
+	require.Len(t, pod.InitContainers, 1)
+	require.Equal(t, "copy-certs", pod.InitContainers[0].Name)
+	var projected *corev1.ProjectedVolumeSource
+	for _, v := range pod.Volumes {
+		if v.Name == volumeServerCerts {
+			projected = v.Projected
+		}
+	}
+	require.NotNil(t, projected)
+	require.Len(t, projected.Sources, 3)
+	require.Equal(t, "tls_2.crt", projected.Sources[2].Secret.Items[0].Path)
+
+	headless := &corev1.Service{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode"}, headless))
+	require.Equal(t, corev1.ClusterIPNone, headless.Spec.ClusterIP)
+	require.True(t, headless.Spec.PublishNotReadyAddresses)
+
+	clusterSvc := &corev1.Service{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode-cluster"}, clusterSvc))
+	require.Len(t, clusterSvc.Spec.Ports, 6)
+
+	env := &corev1.ConfigMap{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode"}, env))
+	require.Equal(t, "bootstrap", env.Data["MARKLOGIC_CLUSTER_TYPE"])
+	require.Equal(t, "true", env.Data["MARKLOGIC_JOIN_TLS_ENABLED"])
+	require.Equal(t, "rootless", env.Data["MARKLOGIC_IMAGE_TYPE"])
+
+	scripts := &corev1.ConfigMap{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode-scripts"}, scripts))
+	require.Contains(t, scripts.Data, "poststart-hook.sh")
+
+	secret := &corev1.Secret{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode-admin"}, secret))
+	require.True(t, strings.HasPrefix(string(secret.Data["username"]), "admin-"))
+	require.Len(t, secret.Data["password"], 10)
+
+	updated := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cluster), updated))
+	require.True(t, meta.IsStatusConditionTrue(updated.Status.Conditions, marklogicv1alpha1.ConditionReconciled))
+	require.False(t, meta.IsStatusConditionTrue(updated.Status.Conditions, marklogicv1alpha1.ConditionAvailable))
+	require.Equal(t, "dnode-0.dnode.marklogic.svc.cluster.local", updated.Status.BootstrapHost)
+}
+
+func TestReconcileKeepsGeneratedCredentials(t *testing.T) {
+	r := newReconciler(newCluster("creds"))
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "marklogic", Name: "creds-admin"}
+
+	reconcile(t, r, "creds")
+	first := &corev1.Secret{}
+	require.NoError(t, r.Get(ctx, key, first))
+
+	reconcile(t, r, "creds")
+	second := &corev1.Secret{}
+	require.NoError(t, r.Get(ctx, key, second))
+	require.Equal(t, first.Data, second.Data)
+
+	// The pods mount a copy, which only the password controller updates.
+	activeKey := types.NamespacedName{Namespace: "marklogic", Name: "creds-admin-active"}
+	active := &corev1.Secret{}
+	require.NoError(t, r.Get(ctx, activeKey, active))
+	require.Equal(t, first.Data, active.Data)
+	second.Data["password"] = []byte("rotated")
+	require.NoError(t, r.Update(ctx, second))
+	reconcile(t, r, "creds")
+	require.NoError(t, r.Get(ctx, activeKey, active))
+	require.Equal(t, first.Data["password"], active.Data["password"])
+	sts := &appsv1.StatefulSet{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "creds"}, sts))
+	require.Contains(t, sts.Spec.Template.Spec.Volumes, corev1.Volume{
+		Name:         volumeAdminSecrets,
+		VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "creds-admin-active"}},
+	})
+}
+
+func TestReconcileScaleAndHAProxyToggle(t *testing.T) {
+	cluster := newCluster("scale")
+	cluster.Spec.HAProxy.Enabled = true
+	r := newReconciler(cluster)
+	ctx := context.Background()
+
+	reconcile(t, r, "scale")
+	cfg := &corev1.ConfigMap{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "scale-haproxy"}, cfg))
+	require.Equal(t, 1, strings.Count(cfg.Data[haproxyConfigKey], "server scale-admin-"))
+
+	current := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cluster), current))
+	current.Spec.ReplicaCount = ptr.To[int32](3)
+	require.NoError(t, r.Update(ctx, current))
+	reconcile(t, r, "scale")
+
+	sts := &appsv1.StatefulSet{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "scale"}, sts))
+	require.Equal(t, int32(3), *sts.Spec.Replicas)
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "scale-haproxy"}, cfg))
+	require.Equal(t, 3, strings.Count(cfg.Data[haproxyConfigKey], "server scale-admin-"))
+
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cluster), current))
+	current.Spec.HAProxy.Enabled = false
+	require.NoError(t, r.Update(ctx, current))
+	reconcile(t, r, "scale")
+
+	err := r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "scale-haproxy"}, &appsv1.Deployment{})
+	require.True(t, apierrors.IsNotFound(err), "HAProxy deployment should be deleted")
+}
+
+func TestReconcileHoldsPodsDuringScaleDown(t *testing.T) {
+	cluster := newCluster("dnode")
+	cluster.Spec.ReplicaCount = ptr.To[int32](3)
+	r := newReconciler(cluster)
+	ctx := context.Background()
+	reconcile(t, r, "dnode")
+
+	current := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cluster), current))
+	current.Spec.ReplicaCount = ptr.To[int32](1)
+	require.NoError(t, r.Update(ctx, current))
+	reconcile(t, r, "dnode")
+	sts := &appsv1.StatefulSet{}
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cluster), sts))
+	require.Equal(t, int32(3), *sts.Spec.Replicas, "pods stay until their hosts left the cluster")
+
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cluster), current))
+	current.Status.ScaleDown = &marklogicv1alpha1.ScaleDownStatus{Hosts: []marklogicv1alpha1.LeavingHostStatus{
+		{Pod: "dnode-2", Phase: marklogicv1alpha1.HostRemoved},
+		{Pod: "dnode-1", Phase: marklogicv1alpha1.HostEvacuating},
+	}}
+	require.NoError(t, r.Status().Update(ctx, current))
+	reconcile(t, r, "dnode")
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cluster), sts))
+	require.Equal(t, int32(2), *sts.Spec.Replicas)
+
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cluster), current))
+	current.Spec.ScaleDown.Managed = ptr.To(false)
+	require.NoError(t, r.Update(ctx, current))
+	reconcile(t, r, "dnode")
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cluster), sts))
+	require.Equal(t, int32(1), *sts.Spec.Replicas)
+}
+
+func TestReconcileExposesAppServers(t *testing.T) {
+	cluster := newCluster("apps")
+	cluster.Spec.HAProxy.Enabled = true
+	http := newAppServer("app", "apps", 8010)
+	odbc := newAppServer("sql", "apps", 5432)
+	odbc.Spec.ServerType = marklogicv1alpha1.ServerTypeODBC
+	other := newAppServer("other", "elsewhere", 8020)
+	blocked := newAppServer("blocked", "apps", 8030)
+	blocked.Annotations = map[string]string{marklogicv1alpha1.AnnotationBlockedByRestore: "rollback"}
+	r := newReconciler(cluster, http, odbc, other, blocked)
+	ctx := context.Background()
+
+	reconcile(t, r, "apps")
+	svc := &corev1.Service{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "apps-cluster"}, svc))
+	var names []string
+	for _, p := range svc.Spec.Ports {
+		names = append(names, p.Name)
+	}
+	require.Subset(t, names, []string{"http-8010", "odbc-5432"})
+	require.NotContains(t, names, "http-8020")
+	require.NotContains(t, names, "http-8030", "App Servers blocked by a restore are not exposed")
+
+	cfg := &corev1.ConfigMap{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "apps-haproxy"}, cfg))
+	require.Contains(t, cfg.Data[haproxyConfigKey], "server ml-apps-8010-0")
+	require.Contains(t, cfg.Data[haproxyConfigKey], "server ml-apps-5432-0")
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "apps-haproxy"}, svc))
+	names = nil
+	for _, p := range svc.Spec.Ports {
+		names = append(names, p.Name)
+	}
+	require.Subset(t, names, []string{"http-8010", "odbc-5432"})
+
+	// The App Servers do not change the stored spec of the cluster.
+	current := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cluster), current))
+	require.Empty(t, current.Spec.Service.AdditionalPorts)
+	require.Empty(t, current.Spec.HAProxy.AdditionalAppServers)
+}
+
+func TestReconcileRejectsInvalidSpec(t *testing.T) {
+	long := newCluster(strings.Repeat("a", 40))
+	first := newCluster("first")
+	second := newCluster("second")
+	second.Spec.BootstrapHostName = "first-0.first.marklogic.svc.cluster.local"
+	r := newReconciler(long, first, second)
+	ctx := context.Background()
+
+	reconcile(t, r, long.Name)
+	updated := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(long), updated))
+	cond := meta.FindStatusCondition(updated.Status.Conditions, marklogicv1alpha1.ConditionReconciled)
+	require.NotNil(t, cond)
+	require.Equal(t, "InvalidSpec", cond.Reason)
+	require.Error(t, r.Get(ctx, client.ObjectKeyFromObject(long), &appsv1.StatefulSet{}))
+
+	reconcile(t, r, "second")
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(second), updated))
+	cond = meta.FindStatusCondition(updated.Status.Conditions, marklogicv1alpha1.ConditionReconciled)
+	require.NotNil(t, cond)
+	require.Contains(t, cond.Message, `group "Default" is already used`)
+}
+
+func TestReconcileUsesAgent(t *testing.T) {
+	cluster := newCluster("agent")
+	cluster.Spec.Agent.Image = "marklogic-operator:latest"
+	r := newReconciler(cluster)
+	reconcile(t, r, "agent")
+
+	sts := &appsv1.StatefulSet{}
+	require.NoError(t, r.Get(context.Background(), types.NamespacedName{Namespace: "marklogic", Name: "agent"}, sts))
+	pod := sts.Spec.Template.Spec
+	require.Len(t, pod.InitContainers, 1)
+	require.Equal(t, []string{"/agent", "install", agentMountPath}, pod.InitContainers[0].Command)
+	require.Equal(t, corev1.PullIfNotPresent, pod.InitContainers[0].ImagePullPolicy)
+	require.Equal(t, []string{agentMountPath + "/agent", "bootstrap"}, pod.Containers[0].Lifecycle.PostStart.Exec.Command)
+	require.Equal(t, []string{agentMountPath + "/agent", "drain", "--grace-period", "120s"}, pod.Containers[0].Lifecycle.PreStop.Exec.Command)
+	require.Equal(t, []string{agentMountPath + "/agent", "probe", "liveness", "--timeout", "5s"}, pod.Containers[0].LivenessProbe.Exec.Command)
+	require.Equal(t, []string{agentMountPath + "/agent", "probe", "readiness", "--timeout", "5s"}, pod.Containers[0].ReadinessProbe.Exec.Command)
+	require.Equal(t, []string{agentMountPath + "/agent", "probe", "startup", "--timeout", "5s"}, pod.Containers[0].StartupProbe.Exec.Command)
+	require.Equal(t, int32(180), pod.Containers[0].StartupProbe.FailureThreshold)
+	require.Contains(t, pod.Containers[0].VolumeMounts, corev1.VolumeMount{Name: volumeAgent, MountPath: agentMountPath, ReadOnly: true})
+
+	// The agent selects the named certificates too.
+	current := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, r.Get(context.Background(), client.ObjectKeyFromObject(cluster), current))
+	current.Spec.TLS.EnableOnDefaultAppServers = true
+	current.Spec.TLS.CertSecretNames = []string{"cert-0"}
+	require.NoError(t, r.Update(context.Background(), current))
+	reconcile(t, r, "agent")
+	require.NoError(t, r.Get(context.Background(), types.NamespacedName{Namespace: "marklogic", Name: "agent"}, sts))
+	pod = sts.Spec.Template.Spec
+	require.Len(t, pod.InitContainers, 2)
+	require.Equal(t, "copy-certs", pod.InitContainers[1].Name)
+	require.Equal(t, "marklogic-operator:latest", pod.InitContainers[1].Image)
+	require.Equal(t, []string{"/agent", "copy-certs"}, pod.InitContainers[1].Command)
+}
+
+func TestReconcileReadsCredentialsFromVault(t *testing.T) {
+	cluster := newCluster("vault")
+	cluster.Spec.Agent.Image = "marklogic-operator:latest"
+	cluster.Spec.Auth.Provider = marklogicv1alpha1.CredentialsProviderVault
+	cluster.Spec.Auth.Vault = &marklogicv1alpha1.VaultAuth{
+		Address:      "https://vault.vault.svc:8200",
+		Path:         "secret/data/marklogic",
+		Role:         "marklogic",
+		CASecretName: "vault-ca",
+	}
+	r := newReconciler(cluster)
+	ctx := context.Background()
+	reconcile(t, r, "vault")
+
+	// The credentials are kept out of the secrets of the cluster.
+	for _, name := range []string{"vault-admin", "vault-admin-active"} {
+		require.True(t, apierrors.IsNotFound(r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: name}, &corev1.Secret{})), name)
+	}
+	sts := &appsv1.StatefulSet{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "vault"}, sts))
+	pod := sts.Spec.Template.Spec
+	fetch := pod.InitContainers[0]
+	require.Equal(t, "fetch-credentials", fetch.Name)
+	require.Equal(t, []string{"/agent", "credentials", "--dir", secretsMountPath}, fetch.Command)
+	require.Equal(t, []corev1.EnvVar{
+		{Name: "VAULT_ADDR", Value: "https://vault.vault.svc:8200"},
+		{Name: "VAULT_SECRET_PATH", Value: "secret/data/marklogic"},
+		{Name: "VAULT_ROLE", Value: "marklogic"},
+		{Name: "VAULT_AUTH_MOUNT", Value: "kubernetes"},
+		{Name: "VAULT_CACERT", Value: "/run/secrets/vault-ca/ca.crt"},
+	}, fetch.Env)
+	require.Contains(t, pod.Volumes, corev1.Volume{
+		Name:         volumeAdminSecrets,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory}},
+	})
+	require.Contains(t, pod.Volumes, corev1.Volume{
+		Name:         volumeVaultCA,
+		VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "vault-ca"}},
+	})
+
+	// Without the agent, nothing fetches the credentials.
+	current := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cluster), current))
+	current.Spec.Agent.Image = ""
+	require.NoError(t, r.Update(ctx, current))
+	reconcile(t, r, "vault")
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cluster), current))
+	requireCondition(t, current.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "InvalidSpec")
+}
+
+func TestReconcileReadsCredentialsFromFiles(t *testing.T) {
+	dir := t.TempDir()
+	defer func(prev string) { CredentialsDir = prev }(CredentialsDir)
+	CredentialsDir = dir
+
+	f := newFakeMarkLogic(t)
+	f.authenticate = true
+	f.put("users", map[string]interface{}{"user-name": "admin", "password": "csi"})
+	cluster, _ := availableCluster(f, "files", 1, 1)
+	csi := &corev1.CSIVolumeSource{
+		Driver:           "secrets-store.csi.k8s.io",
+		ReadOnly:         ptr.To(true),
+		VolumeAttributes: map[string]string{"secretProviderClass": "marklogic-admin"},
+	}
+	cluster.Spec.Auth.Provider = marklogicv1alpha1.CredentialsProviderFile
+	cluster.Spec.Auth.CSI = csi
+	r := newReconciler(cluster)
+	ctx := context.Background()
+
+	// Without a mount of the driver, the pods still run but the operator
+	// cannot read the credentials.
+	reconcile(t, r, "files")
+	current := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cluster), current))
+	requireCondition(t, current.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionTrue, "Reconciled")
+	requireCondition(t, current.Status.Conditions, marklogicv1alpha1.ConditionCredentialsAvailable, metav1.ConditionFalse, "NotMounted")
+	cond := meta.FindStatusCondition(current.Status.Conditions, marklogicv1alpha1.ConditionCredentialsAvailable)
+	require.Contains(t, cond.Message, filepath.Join(dir, "marklogic", "files"))
+	sts := &appsv1.StatefulSet{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "files"}, sts))
+	require.Contains(t, sts.Spec.Template.Spec.Volumes, corev1.Volume{Name: volumeAdminSecrets, VolumeSource: corev1.VolumeSource{CSI: csi}})
+	defaulted := cluster.DeepCopy()
+	defaulted.Default()
+	_, err := hostClient(ctx, r, f.newClient, defaulted, fqdn(defaulted))
+	require.ErrorIs(t, err, errCredentialsUnavailable)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "marklogic", "files"), 0o755))
+	require.NoError(t, credentials.WriteFiles(filepath.Join(dir, "marklogic", "files"), &credentials.Credentials{Username: "admin", Password: "csi"}))
+	reconcile(t, r, "files")
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cluster), current))
+	requireCondition(t, current.Status.Conditions, marklogicv1alpha1.ConditionCredentialsAvailable, metav1.ConditionTrue, "Mounted")
+
+	// The operator reads the credentials from its own mount of the driver.
+	mc, err := hostClient(ctx, r, f.newClient, defaulted, fqdn(defaulted))
+	require.NoError(t, err)
+	_, err = mc.HostProperties(ctx, fqdn(defaulted))
+	require.NoError(t, err)
+}
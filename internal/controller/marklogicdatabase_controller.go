@@ -0,0 +1,346 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// databaseFinalizer lets the operator delete the database from MarkLogic
+// before the resource goes away.
+const databaseFinalizer = "marklogic.com/database"
+
+// MarkLogicDatabaseReconciler reconciles a MarkLogicDatabase object
+type MarkLogicDatabaseReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// NewClient creates the MarkLogic clients. Defaults to mlclient.New.
+	NewClient mlclient.Factory
+}
+
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicdatabases,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicdatabases/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicdatabases/finalizers,verbs=update
+
+// Reconcile creates the database and its forests in the MarkLogic cluster and
+// keeps the database properties in line with the spec.
+func (r *MarkLogicDatabaseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	db := &marklogicv1alpha1.MarkLogicDatabase{}
+	if err := r.Get(ctx, req.NamespacedName, db); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	desired := db.DeepCopy()
+	desired.Default()
+
+	if !db.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, db, desired)
+	}
+	if controllerutil.AddFinalizer(db, databaseFinalizer) {
+		if err := r.Update(ctx, db); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	props, err := databaseProperties(desired)
+	if err != nil {
+		logger.Info("invalid MarkLogicDatabase spec", "reason", err.Error())
+		r.setReconciled(db, metav1.ConditionFalse, "InvalidSpec", err.Error())
+		return ctrl.Result{}, r.Status().Update(ctx, db)
+	}
+
+	cluster, err := clusterFor(ctx, r.Client, db.Namespace, desired.Spec.ClusterRef)
+	if apierrors.IsNotFound(err) {
+		r.setReconciled(db, metav1.ConditionFalse, "ClusterNotFound", fmt.Sprintf("MarkLogicCluster %s not found", desired.Spec.ClusterRef.Name))
+		return ctrl.Result{}, r.Status().Update(ctx, db)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	mc, err := clusterClient(ctx, r.Client, r.NewClient, cluster)
+	if errors.Is(err, errClusterNotReady) {
+		r.setReconciled(db, metav1.ConditionFalse, "ClusterNotReady", fmt.Sprintf("waiting for MarkLogicCluster %s to become available", cluster.Name))
+		return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, db)
+	}
+	if errors.Is(err, errCredentialsUnavailable) {
+		r.setReconciled(db, metav1.ConditionFalse, "CredentialsUnavailable", err.Error())
+		return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, db)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	result, err := r.reconcileDatabase(ctx, mc, cluster, db, desired, props)
+	if err != nil {
+		logger.Error(err, "failed to reconcile database", "database", desired.Spec.DatabaseName)
+		r.setReconciled(db, metav1.ConditionFalse, "ReconcileError", err.Error())
+		if statusErr := r.Status().Update(ctx, db); statusErr != nil {
+			logger.Error(statusErr, "failed to update MarkLogicDatabase status")
+		}
+		return ctrl.Result{}, err
+	}
+	return result, r.Status().Update(ctx, db)
+}
+
+// reconcileDatabase applies the desired database and forests and records the
+// outcome in the status of db.
+func (r *MarkLogicDatabaseReconciler) reconcileDatabase(ctx context.Context, mc *mlclient.Client, cluster *marklogicv1alpha1.MarkLogicCluster,
+	db, desired *marklogicv1alpha1.MarkLogicDatabase, props map[string]interface{}) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	name := desired.Spec.DatabaseName
+
+	current, err := mc.DatabaseProperties(ctx, name)
+	switch {
+	case mlclient.IsNotFound(err):
+		logger.Info("creating database", "database", name)
+		if err := mc.CreateDatabase(ctx, mlclient.DatabaseProperties(props)); err != nil {
+			return ctrl.Result{}, fmt.Errorf("creating database %s: %w", name, err)
+		}
+		db.Status.Drift = nil
+		r.setInSync(db, metav1.ConditionTrue, "Created", "database created")
+	case err != nil:
+		return ctrl.Result{}, fmt.Errorf("reading database %s: %w", name, err)
+	default:
+		result, err := syncProperties(ctx, "database", props, current, db.Generation != db.Status.ObservedGeneration, desired.Spec.DriftPolicy,
+			func(update map[string]interface{}) error {
+				if _, err := mc.UpdateDatabaseProperties(ctx, name, update); err != nil {
+					return fmt.Errorf("updating database %s: %w", name, err)
+				}
+				return nil
+			})
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		db.Status.Drift = result.Drift
+		r.setInSync(db, result.Status, result.Reason, result.Message)
+	}
+	now := metav1.Now()
+	db.Status.LastDriftCheck = &now
+	db.Status.DatabaseName = name
+
+	forests, pending, err := reconcileForests(ctx, mc, cluster, desired)
+	db.Status.Forests = forests
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	db.Status.ObservedGeneration = db.Generation
+	if pending {
+		r.setReconciled(db, metav1.ConditionFalse, "HostsPending", "some hosts have not joined the cluster yet, their forests will be created later")
+		return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, nil
+	}
+	r.setReconciled(db, metav1.ConditionTrue, "Reconciled", "database and forests are up to date")
+	return ctrl.Result{RequeueAfter: driftCheckInterval}, nil
+}
+
+// reconcileForests creates the forests of the database on every host of the
+// cluster and attaches them. It reports hosts that have not joined the cluster
+// yet as pending.
+func reconcileForests(ctx context.Context, mc *mlclient.Client, cluster *marklogicv1alpha1.MarkLogicCluster,
+	db *marklogicv1alpha1.MarkLogicDatabase) ([]marklogicv1alpha1.ForestStatus, bool, error) {
+	logger := log.FromContext(ctx)
+	name := db.Spec.DatabaseName
+	var forests []marklogicv1alpha1.ForestStatus
+	pending := false
+
+	for ordinal := 0; ordinal < int(*cluster.Spec.ReplicaCount); ordinal++ {
+		host := hostFQDN(cluster, ordinal)
+		for n := 1; n <= int(*db.Spec.Forests.PerHost); n++ {
+			forest := marklogicv1alpha1.ForestStatus{Name: forestName(name, ordinal, n), Host: host}
+			props, err := mc.ForestProperties(ctx, forest.Name)
+			switch {
+			case mlclient.IsNotFound(err):
+				if _, err := mc.HostProperties(ctx, host); mlclient.IsNotFound(err) {
+					pending = true
+					forests = append(forests, forest)
+					continue
+				} else if err != nil {
+					return forests, false, fmt.Errorf("reading host %s: %w", host, err)
+				}
+				logger.Info("creating forest", "forest", forest.Name, "host", host)
+				if err := mc.CreateForest(ctx, mlclient.ForestProperties{
+					ForestName:    forest.Name,
+					Host:          host,
+					Database:      name,
+					DataDirectory: db.Spec.Forests.DataDirectory,
+				}); err != nil {
+					return forests, false, fmt.Errorf("creating forest %s: %w", forest.Name, err)
+				}
+			case err != nil:
+				return forests, false, fmt.Errorf("reading forest %s: %w", forest.Name, err)
+			case props.Database == "":
+				logger.Info("attaching forest", "forest", forest.Name)
+				if err := mc.AttachForest(ctx, forest.Name, name); err != nil {
+					return forests, false, fmt.Errorf("attaching forest %s: %w", forest.Name, err)
+				}
+			case props.Database != name:
+				forests = append(forests, forest)
+				return forests, false, fmt.Errorf("forest %s is attached to database %s", forest.Name, props.Database)
+			}
+			forest.Attached = true
+			forests = append(forests, forest)
+		}
+	}
+	return forests, pending, nil
+}
+
+func forestName(database string, ordinal, n int) string {
+	return fmt.Sprintf("%s-%d-%d", database, ordinal, n)
+}
+
+// finalize deletes the database and its forests from MarkLogic when the
+// deletion policy asks for it, then releases the resource.
+func (r *MarkLogicDatabaseReconciler) finalize(ctx context.Context, db, desired *marklogicv1alpha1.MarkLogicDatabase) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(db, databaseFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	if desired.Spec.DeletionPolicy == marklogicv1alpha1.DeletionPolicyDelete {
+		cluster, err := clusterFor(ctx, r.Client, db.Namespace, desired.Spec.ClusterRef)
+		switch {
+		case apierrors.IsNotFound(err):
+			// Nothing is left to clean up with the cluster gone.
+		case err != nil:
+			return ctrl.Result{}, err
+		case cluster.DeletionTimestamp.IsZero():
+			mc, err := clusterClient(ctx, r.Client, r.NewClient, cluster)
+			if errors.Is(err, errClusterNotReady) {
+				r.setReconciled(db, metav1.ConditionFalse, "ClusterNotReady", fmt.Sprintf("waiting for MarkLogicCluster %s to become available", cluster.Name))
+				return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, db)
+			}
+			if errors.Is(err, errCredentialsUnavailable) {
+				r.setReconciled(db, metav1.ConditionFalse, "CredentialsUnavailable", err.Error())
+				return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, db)
+			}
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			log.FromContext(ctx).Info("deleting database", "database", desired.Spec.DatabaseName)
+			if err := mc.DeleteDatabase(ctx, desired.Spec.DatabaseName, mlclient.ForestDeleteData); err != nil {
+				return ctrl.Result{}, fmt.Errorf("deleting database %s: %w", desired.Spec.DatabaseName, err)
+			}
+		}
+	}
+	controllerutil.RemoveFinalizer(db, databaseFinalizer)
+	return ctrl.Result{}, r.Update(ctx, db)
+}
+
+func (r *MarkLogicDatabaseReconciler) setReconciled(db *marklogicv1alpha1.MarkLogicDatabase, status metav1.ConditionStatus, reason, message string) {
+	setCondition(&db.Status.Conditions, db.Generation, marklogicv1alpha1.ConditionReconciled, status, reason, message)
+}
+
+func (r *MarkLogicDatabaseReconciler) setInSync(db *marklogicv1alpha1.MarkLogicDatabase, status metav1.ConditionStatus, reason, message string) {
+	setCondition(&db.Status.Conditions, db.Generation, marklogicv1alpha1.ConditionInSync, status, reason, message)
+}
+
+// rangeElementIndex is the Management API form of a range element index.
+type rangeElementIndex struct {
+	ScalarType          string `json:"scalar-type"`
+	NamespaceURI        string `json:"namespace-uri"`
+	Localname           string `json:"localname"`
+	Collation           string `json:"collation"`
+	RangeValuePositions bool   `json:"range-value-positions"`
+	InvalidValues       string `json:"invalid-values"`
+}
+
+// rangePathIndex is the Management API form of a range path index.
+type rangePathIndex struct {
+	ScalarType          string `json:"scalar-type"`
+	PathExpression      string `json:"path-expression"`
+	Collation           string `json:"collation"`
+	RangeValuePositions bool   `json:"range-value-positions"`
+	InvalidValues       string `json:"invalid-values"`
+}
+
+// databaseSpec is the Management API form of the typed fields of the spec.
+type databaseSpec struct {
+	DatabaseName        string              `json:"database-name"`
+	Enabled             bool                `json:"enabled"`
+	SecurityDatabase    string              `json:"security-database"`
+	SchemaDatabase      string              `json:"schema-database"`
+	TriggersDatabase    string              `json:"triggers-database"`
+	Language            string              `json:"language,omitempty"`
+	RangeElementIndexes []rangeElementIndex `json:"range-element-index,omitempty"`
+	RangePathIndexes    []rangePathIndex    `json:"range-path-index,omitempty"`
+}
+
+// databaseProperties returns the desired properties of a defaulted database.
+func databaseProperties(db *marklogicv1alpha1.MarkLogicDatabase) (map[string]interface{}, error) {
+	s := db.Spec
+	spec := databaseSpec{
+		DatabaseName:     s.DatabaseName,
+		Enabled:          *s.Enabled,
+		SecurityDatabase: s.SecurityDatabase,
+		SchemaDatabase:   s.SchemaDatabase,
+		TriggersDatabase: s.TriggersDatabase,
+		Language:         s.Language,
+	}
+	for _, idx := range s.RangeElementIndexes {
+		spec.RangeElementIndexes = append(spec.RangeElementIndexes, rangeElementIndex{
+			ScalarType:          idx.ScalarType,
+			NamespaceURI:        idx.NamespaceURI,
+			Localname:           idx.Localname,
+			Collation:           idx.Collation,
+			RangeValuePositions: idx.RangeValuePositions,
+			InvalidValues:       idx.InvalidValues,
+		})
+	}
+	for _, idx := range s.RangePathIndexes {
+		spec.RangePathIndexes = append(spec.RangePathIndexes, rangePathIndex{
+			ScalarType:          idx.ScalarType,
+			PathExpression:      idx.PathExpression,
+			Collation:           idx.Collation,
+			RangeValuePositions: idx.RangeValuePositions,
+			InvalidValues:       idx.InvalidValues,
+		})
+	}
+	props, err := toProperties(spec)
+	if err != nil {
+		return nil, err
+	}
+	if s.Properties != nil {
+		if err := mergeRawProperties(props, s.Properties.Raw); err != nil {
+			return nil, fmt.Errorf("spec.properties: %w", err)
+		}
+	}
+	return props, nil
+}
+
+// databasesForCluster maps a MarkLogicCluster to the databases it hosts.
+func (r *MarkLogicDatabaseReconciler) databasesForCluster(ctx context.Context, obj client.Object) []ctrl.Request {
+	list := &marklogicv1alpha1.MarkLogicDatabaseList{}
+	if err := r.List(ctx, list, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list MarkLogicDatabases")
+		return nil
+	}
+	var requests []ctrl.Request
+	for _, db := range list.Items {
+		if db.Spec.ClusterRef.Name == obj.GetName() {
+			requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&db)})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MarkLogicDatabaseReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&marklogicv1alpha1.MarkLogicDatabase{}).
+		Watches(&marklogicv1alpha1.MarkLogicCluster{},
+			handler.EnqueueRequestsFromMapFunc(r.databasesForCluster),
+			builder.WithPredicates(clusterChanged)).
+		Complete(r)
+}
@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+func newDatabase(name, cluster string) *marklogicv1alpha1.MarkLogicDatabase {
+	return &marklogicv1alpha1.MarkLogicDatabase{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "marklogic", Generation: 1},
+		Spec:       marklogicv1alpha1.MarkLogicDatabaseSpec{ClusterRef: marklogicv1alpha1.ClusterReference{Name: cluster}},
+	}
+}
+
+func newDatabaseReconciler(f *fakeMarkLogic, objs ...client.Object) *MarkLogicDatabaseReconciler {
+	s := testScheme()
+	c := fake.NewClientBuilder().
+		WithScheme(s).
+		WithObjects(objs...).
+		WithStatusSubresource(&marklogicv1alpha1.MarkLogicDatabase{}, &marklogicv1alpha1.MarkLogicCluster{}).
+		Build()
+	return &MarkLogicDatabaseReconciler{Client: c, Scheme: s, NewClient: f.newClient}
+}
+
+func reconcileDatabase(t *testing.T, r *MarkLogicDatabaseReconciler, name string) (ctrl.Result, *marklogicv1alpha1.MarkLogicDatabase) {
+	t.Helper()
+	key := types.NamespacedName{Namespace: "marklogic", Name: name}
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	db := &marklogicv1alpha1.MarkLogicDatabase{}
+	require.NoError(t, r.Get(context.Background(), key, db))
+	return result, db
+}
+
+func TestReconcileDatabaseCreatesDatabaseAndForests(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	cluster, secret := availableCluster(f, "dnode", 3, 2)
+	db := newDatabase("app", "dnode")
+	db.Spec.Forests.PerHost = ptr.To[int32](2)
+	db.Spec.RangeElementIndexes = []marklogicv1alpha1.RangeElementIndex{{ScalarType: "string", Localname: "title"}}
+	db.Spec.Properties = &runtime.RawExtension{Raw: []byte(`{"triple-index": true, "enabled": false}`)}
+	r := newDatabaseReconciler(f, cluster, secret, db)
+
+	result, db := reconcileDatabase(t, r, "app")
+	props := f.get("databases", "app")
+	require.NotNil(t, props)
+	assert.Equal(t, true, props["triple-index"])
+	assert.Equal(t, true, props["enabled"], "typed fields take precedence over properties")
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"scalar-type": "string", "namespace-uri": "", "localname": "title", "collation": "http://marklogic.com/collation/codepoint",
+		"range-value-positions": false, "invalid-values": "reject",
+	}}, props["range-element-index"])
+
+	for _, name := range []string{"app-0-1", "app-0-2", "app-1-1", "app-1-2"} {
+		forest := f.get("forests", name)
+		require.NotNil(t, forest, name)
+		assert.Equal(t, "app", forest["database"])
+	}
+	assert.Equal(t, "dnode-1.dnode.marklogic.svc.cluster.local", f.get("forests", "app-1-2")["host"])
+	assert.Nil(t, f.get("forests", "app-2-1"), "host 2 has not joined yet")
+
+	assert.Equal(t, clusterNotReadyRequeue, result.RequeueAfter)
+	assert.Equal(t, []string{databaseFinalizer}, db.Finalizers)
+	assert.Equal(t, "app", db.Status.DatabaseName)
+	require.Len(t, db.Status.Forests, 6)
+	assert.False(t, db.Status.Forests[4].Attached)
+	requireCondition(t, db.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "HostsPending")
+	requireCondition(t, db.Status.Conditions, marklogicv1alpha1.ConditionInSync, metav1.ConditionTrue, "Created")
+
+	// The forests of the last host are created once it joins.
+	f.put("hosts", map[string]interface{}{"host-name": "dnode-2.dnode.marklogic.svc.cluster.local"})
+	result, db = reconcileDatabase(t, r, "app")
+	assert.Equal(t, driftCheckInterval, result.RequeueAfter)
+	assert.NotNil(t, f.get("forests", "app-2-2"))
+	requireCondition(t, db.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionTrue, "Reconciled")
+	requireCondition(t, db.Status.Conditions, marklogicv1alpha1.ConditionInSync, metav1.ConditionTrue, "InSync")
+}
+
+func TestReconcileDatabaseDrift(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	cluster, secret := availableCluster(f, "dnode", 1, 1)
+	db := newDatabase("app", "dnode")
+	db.Spec.RangeElementIndexes = []marklogicv1alpha1.RangeElementIndex{
+		{ScalarType: "int", Localname: "count"},
+		{ScalarType: "string", Localname: "title"},
+	}
+	r := newDatabaseReconciler(f, cluster, secret, db)
+	reconcileDatabase(t, r, "app")
+
+	// MarkLogic reorders indexes and adds properties of its own; neither is drift.
+	props := f.get("databases", "app")
+	indexes := props["range-element-index"].([]interface{})
+	props["range-element-index"] = []interface{}{indexes[1], indexes[0]}
+	props["in-memory-limit"] = float64(262144)
+	_, db = reconcileDatabase(t, r, "app")
+	assert.Empty(t, db.Status.Drift)
+	requireCondition(t, db.Status.Conditions, marklogicv1alpha1.ConditionInSync, metav1.ConditionTrue, "InSync")
+
+	// Changes made outside of the operator are reverted by default.
+	props["enabled"] = false
+	props["range-element-index"] = []interface{}{indexes[0]}
+	_, db = reconcileDatabase(t, r, "app")
+	assert.Equal(t, []string{"enabled", "range-element-index"}, db.Status.Drift)
+	assert.Equal(t, true, f.get("databases", "app")["enabled"])
+	assert.Len(t, f.get("databases", "app")["range-element-index"], 2)
+	requireCondition(t, db.Status.Conditions, marklogicv1alpha1.ConditionInSync, metav1.ConditionTrue, "DriftCorrected")
+
+	// With the Report policy they are only reported.
+	db.Spec.DriftPolicy = marklogicv1alpha1.DriftPolicyReport
+	require.NoError(t, r.Update(context.Background(), db))
+	props["schema-database"] = "Other"
+	_, db = reconcileDatabase(t, r, "app")
+	assert.Equal(t, []string{"schema-database"}, db.Status.Drift)
+	assert.Equal(t, "Other", f.get("databases", "app")["schema-database"])
+	requireCondition(t, db.Status.Conditions, marklogicv1alpha1.ConditionInSync, metav1.ConditionFalse, "Drifted")
+
+	// A changed spec is applied regardless of the policy.
+	db.Spec.SchemaDatabase = "Schemas2"
+	db.Generation = 2
+	require.NoError(t, r.Update(context.Background(), db))
+	_, db = reconcileDatabase(t, r, "app")
+	assert.Empty(t, db.Status.Drift)
+	assert.Equal(t, "Schemas2", f.get("databases", "app")["schema-database"])
+	requireCondition(t, db.Status.Conditions, marklogicv1alpha1.ConditionInSync, metav1.ConditionTrue, "Updated")
+}
+
+func TestReconcileDatabaseWaitsForCluster(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	r := newDatabaseReconciler(f, newDatabase("app", "missing"))
+	_, db := reconcileDatabase(t, r, "app")
+	requireCondition(t, db.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "ClusterNotFound")
+
+	cluster := newCluster("starting")
+	r = newDatabaseReconciler(f, cluster, newDatabase("app", "starting"))
+	result, db := reconcileDatabase(t, r, "app")
+	assert.Equal(t, clusterNotReadyRequeue, result.RequeueAfter)
+	requireCondition(t, db.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "ClusterNotReady")
+	assert.Empty(t, f.calls)
+
+	// The operator waits for the credentials of the File provider to be
+	// mounted in its pod.
+	defer func(prev string) { CredentialsDir = prev }(CredentialsDir)
+	CredentialsDir = t.TempDir()
+	files, secret := availableCluster(f, "files", 1, 1)
+	files.Spec.Auth.Provider = marklogicv1alpha1.CredentialsProviderFile
+	r = newDatabaseReconciler(f, files, secret, newDatabase("app", "files"))
+	result, db = reconcileDatabase(t, r, "app")
+	assert.Equal(t, clusterNotReadyRequeue, result.RequeueAfter)
+	requireCondition(t, db.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "CredentialsUnavailable")
+	assert.Empty(t, f.calls)
+
+	invalid := newDatabase("invalid", "starting")
+	invalid.Spec.Properties = &runtime.RawExtension{Raw: []byte(`[1, 2]`)}
+	r = newDatabaseReconciler(f, cluster, invalid)
+	_, invalid = reconcileDatabase(t, r, "invalid")
+	requireCondition(t, invalid.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "InvalidSpec")
+}
+
+func TestReconcileDatabaseDeletion(t *testing.T) {
+	for _, policy := range []marklogicv1alpha1.DeletionPolicy{marklogicv1alpha1.DeletionPolicyRetain, marklogicv1alpha1.DeletionPolicyDelete} {
+		t.Run(string(policy), func(t *testing.T) {
+			f := newFakeMarkLogic(t)
+			cluster, secret := availableCluster(f, "dnode", 1, 1)
+			db := newDatabase("app", "dnode")
+			db.Spec.DeletionPolicy = policy
+			r := newDatabaseReconciler(f, cluster, secret, db)
+			_, db = reconcileDatabase(t, r, "app")
+
+			require.NoError(t, r.Delete(context.Background(), db))
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(db)})
+			require.NoError(t, err)
+			require.Error(t, r.Get(context.Background(), client.ObjectKeyFromObject(db), db), "finalizer is removed")
+			assert.Equal(t, policy == marklogicv1alpha1.DeletionPolicyDelete, f.called("DELETE /manage/v2/databases/app"))
+		})
+	}
+}
+
+func TestReconcileDatabaseDeletionWaitsForCluster(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	cluster, secret := availableCluster(f, "dnode", 1, 1)
+	db := newDatabase("app", "dnode")
+	db.Spec.DeletionPolicy = marklogicv1alpha1.DeletionPolicyDelete
+	r := newDatabaseReconciler(f, cluster, secret, db)
+	ctx := context.Background()
+	_, db = reconcileDatabase(t, r, "app")
+
+	meta.SetStatusCondition(&cluster.Status.Conditions, metav1.Condition{Type: marklogicv1alpha1.ConditionAvailable,
+		Status: metav1.ConditionFalse, Reason: "PodsNotReady"})
+	require.NoError(t, r.Status().Update(ctx, cluster))
+	require.NoError(t, r.Delete(ctx, db))
+	result, db := reconcileDatabase(t, r, "app")
+	assert.Equal(t, clusterNotReadyRequeue, result.RequeueAfter)
+	assert.Contains(t, db.Finalizers, databaseFinalizer)
+	requireCondition(t, db.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "ClusterNotReady")
+	assert.False(t, f.called("DELETE /manage/v2/databases/app"))
+
+	meta.SetStatusCondition(&cluster.Status.Conditions, metav1.Condition{Type: marklogicv1alpha1.ConditionAvailable,
+		Status: metav1.ConditionTrue, Reason: "PodsReady"})
+	require.NoError(t, r.Status().Update(ctx, cluster))
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(db)})
+	require.NoError(t, err)
+	require.Error(t, r.Get(ctx, client.ObjectKeyFromObject(db), db), "finalizer is removed")
+	assert.True(t, f.called("DELETE /manage/v2/databases/app"))
+}
@@ -0,0 +1,451 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// externalSecurityFinalizer lets the operator delete the external security
+// configuration from MarkLogic before the resource goes away.
+const externalSecurityFinalizer = "marklogic.com/externalsecurity"
+
+// MarkLogicExternalSecurityReconciler reconciles a MarkLogicExternalSecurity
+// object: the external security configuration in MarkLogic and its use by App
+// Servers.
+type MarkLogicExternalSecurityReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// NewClient creates the MarkLogic clients. Defaults to mlclient.New.
+	NewClient mlclient.Factory
+}
+
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicexternalsecurities,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicexternalsecurities/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicexternalsecurities/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+// Reconcile creates the external security configuration in the MarkLogic
+// cluster, keeps its properties and bind password in line with the spec and
+// assigns it to the App Servers of the spec.
+func (r *MarkLogicExternalSecurityReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	es := &marklogicv1alpha1.MarkLogicExternalSecurity{}
+	if err := r.Get(ctx, req.NamespacedName, es); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	desired := es.DeepCopy()
+	desired.Default()
+
+	if !es.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, r.finalize(ctx, es, desired)
+	}
+	if controllerutil.AddFinalizer(es, externalSecurityFinalizer) {
+		if err := r.Update(ctx, es); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	cluster, err := clusterFor(ctx, r.Client, es.Namespace, desired.Spec.ClusterRef)
+	if apierrors.IsNotFound(err) {
+		r.setReconciled(es, metav1.ConditionFalse, "ClusterNotFound", fmt.Sprintf("MarkLogicCluster %s not found", desired.Spec.ClusterRef.Name))
+		return ctrl.Result{}, r.Status().Update(ctx, es)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	for i := range desired.Spec.AppServers {
+		if desired.Spec.AppServers[i].GroupName == "" {
+			desired.Spec.AppServers[i].GroupName = cluster.Spec.Group.Name
+		}
+	}
+
+	bind, err := r.bindSecret(ctx, desired)
+	if apierrors.IsNotFound(err) {
+		r.setReconciled(es, metav1.ConditionFalse, "SecretNotFound", fmt.Sprintf("secret %s not found", desired.Spec.LDAP.BindSecretName))
+		return ctrl.Result{}, r.Status().Update(ctx, es)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	props, err := externalSecurityProperties(desired, bind)
+	if err != nil {
+		logger.Info("invalid MarkLogicExternalSecurity spec", "reason", err.Error())
+		r.setReconciled(es, metav1.ConditionFalse, "InvalidSpec", err.Error())
+		return ctrl.Result{}, r.Status().Update(ctx, es)
+	}
+
+	mc, err := clusterClient(ctx, r.Client, r.NewClient, cluster)
+	if errors.Is(err, errClusterNotReady) {
+		r.setReconciled(es, metav1.ConditionFalse, "ClusterNotReady", fmt.Sprintf("waiting for MarkLogicCluster %s to become available", cluster.Name))
+		return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, es)
+	}
+	if errors.Is(err, errCredentialsUnavailable) {
+		r.setReconciled(es, metav1.ConditionFalse, "CredentialsUnavailable", err.Error())
+		return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, es)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	err = r.reconcileExternalSecurity(ctx, mc, es, desired, props, bind)
+	if err == nil {
+		err = r.reconcileAppServers(ctx, mc, es, desired)
+	}
+	if err != nil {
+		logger.Error(err, "failed to reconcile external security", "externalSecurity", desired.Spec.ExternalSecurityName)
+		r.setReconciled(es, metav1.ConditionFalse, "ReconcileError", err.Error())
+		if statusErr := r.Status().Update(ctx, es); statusErr != nil {
+			logger.Error(statusErr, "failed to update MarkLogicExternalSecurity status")
+		}
+		return ctrl.Result{}, err
+	}
+	es.Status.ObservedGeneration = es.Generation
+	r.setReconciled(es, metav1.ConditionTrue, "Reconciled", "external security is up to date")
+	return ctrl.Result{RequeueAfter: driftCheckInterval}, r.Status().Update(ctx, es)
+}
+
+// bindSecret returns the secret with the bind credentials of the LDAP server,
+// nil when the server is searched anonymously.
+func (r *MarkLogicExternalSecurityReconciler) bindSecret(ctx context.Context, es *marklogicv1alpha1.MarkLogicExternalSecurity) (*corev1.Secret, error) {
+	if es.Spec.LDAP == nil || es.Spec.LDAP.BindSecretName == "" {
+		return nil, nil
+	}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: es.Namespace, Name: es.Spec.LDAP.BindSecretName}, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// reconcileExternalSecurity applies the desired properties and records the
+// outcome in the status of es. The bind password cannot be read back, so it
+// is sent whenever the LDAP server is updated or the bind secret changes.
+func (r *MarkLogicExternalSecurityReconciler) reconcileExternalSecurity(ctx context.Context, mc *mlclient.Client,
+	es, desired *marklogicv1alpha1.MarkLogicExternalSecurity, props map[string]interface{}, bind *corev1.Secret) error {
+	name := desired.Spec.ExternalSecurityName
+	var password, bindVersion string
+	if bind != nil {
+		password, bindVersion = string(bind.Data["password"]), bind.ResourceVersion
+	}
+
+	current, err := mc.ExternalSecurityProperties(ctx, name)
+	switch {
+	case mlclient.IsNotFound(err):
+		log.FromContext(ctx).Info("creating external security", "externalSecurity", name)
+		if err := mc.CreateExternalSecurity(ctx, withBindPassword(props, password)); err != nil {
+			return fmt.Errorf("creating external security %s: %w", name, err)
+		}
+		es.Status.Drift = nil
+		r.setInSync(es, metav1.ConditionTrue, "Created", "external security created")
+	case err != nil:
+		return fmt.Errorf("reading external security %s: %w", name, err)
+	default:
+		passwordSent := false
+		update := func(update map[string]interface{}) error {
+			if _, ok := update["ldap-server"]; ok {
+				update = withBindPassword(update, password)
+				passwordSent = true
+			}
+			if err := mc.UpdateExternalSecurityProperties(ctx, name, update); err != nil {
+				return fmt.Errorf("updating external security %s: %w", name, err)
+			}
+			return nil
+		}
+		result, err := syncProperties(ctx, "external security", props, current, es.Generation != es.Status.ObservedGeneration,
+			desired.Spec.DriftPolicy, update)
+		if err != nil {
+			return err
+		}
+		if bind != nil && bindVersion != es.Status.BindSecretVersion && !passwordSent {
+			log.FromContext(ctx).Info("updating LDAP bind password", "externalSecurity", name, "secret", bind.Name)
+			if err := update(map[string]interface{}{"ldap-server": props["ldap-server"]}); err != nil {
+				return err
+			}
+		}
+		es.Status.Drift = result.Drift
+		r.setInSync(es, result.Status, result.Reason, result.Message)
+	}
+	now := metav1.Now()
+	es.Status.LastDriftCheck = &now
+	es.Status.ExternalSecurityName = name
+	es.Status.BindSecretVersion = bindVersion
+	return nil
+}
+
+// reconcileAppServers adds the configuration to the external security of the
+// App Servers of the spec and removes it from those no longer listed.
+func (r *MarkLogicExternalSecurityReconciler) reconcileAppServers(ctx context.Context, mc *mlclient.Client,
+	es, desired *marklogicv1alpha1.MarkLogicExternalSecurity) error {
+	name := desired.Spec.ExternalSecurityName
+	for _, s := range es.Status.AppServers {
+		if !containsAppServer(desired.Spec.AppServers, s) {
+			if err := setExternalSecurity(ctx, mc, s, name, false); err != nil {
+				return err
+			}
+		}
+	}
+	es.Status.AppServers = nil
+	for _, s := range desired.Spec.AppServers {
+		if err := setExternalSecurity(ctx, mc, s, name, true); err != nil {
+			return err
+		}
+		es.Status.AppServers = append(es.Status.AppServers, s)
+	}
+	return nil
+}
+
+// setExternalSecurity adds name to the external-security list of an App
+// Server, or removes it. An App Server left without external security falls
+// back to internal security.
+func setExternalSecurity(ctx context.Context, mc *mlclient.Client, s marklogicv1alpha1.ExternalSecurityAppServer, name string, assign bool) error {
+	props, err := mc.AppServerProperties(ctx, s.ServerName, s.GroupName)
+	if mlclient.IsNotFound(err) && !assign {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading App Server %s: %w", s.ServerName, err)
+	}
+	names := []string{}
+	assigned := false
+	for _, n := range stringList(props["external-security"]) {
+		if n == name {
+			assigned = true
+			if !assign {
+				continue
+			}
+		}
+		names = append(names, n)
+	}
+	if assigned == assign {
+		return nil
+	}
+	update := mlclient.AppServerProperties{}
+	if assign {
+		names = append(names, name)
+	} else if len(names) == 0 {
+		update["internal-security"] = true
+	}
+	update["external-security"] = names
+	log.FromContext(ctx).Info("updating external security of App Server", "server", s.ServerName, "group", s.GroupName, "externalSecurity", names)
+	if _, err := mc.UpdateAppServerProperties(ctx, s.ServerName, s.GroupName, update); err != nil {
+		return fmt.Errorf("updating App Server %s: %w", s.ServerName, err)
+	}
+	return nil
+}
+
+// stringList returns the strings of a property holding a list of strings or
+// a single one.
+func stringList(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+func containsAppServer(servers []marklogicv1alpha1.ExternalSecurityAppServer, s marklogicv1alpha1.ExternalSecurityAppServer) bool {
+	for _, server := range servers {
+		if server == s {
+			return true
+		}
+	}
+	return false
+}
+
+// finalize removes the configuration from its App Servers and deletes it
+// from MarkLogic when the deletion policy asks for it, then releases the
+// resource.
+func (r *MarkLogicExternalSecurityReconciler) finalize(ctx context.Context, es, desired *marklogicv1alpha1.MarkLogicExternalSecurity) error {
+	if !controllerutil.ContainsFinalizer(es, externalSecurityFinalizer) {
+		return nil
+	}
+	if desired.Spec.DeletionPolicy == marklogicv1alpha1.DeletionPolicyDelete {
+		cluster, err := clusterFor(ctx, r.Client, es.Namespace, desired.Spec.ClusterRef)
+		switch {
+		case apierrors.IsNotFound(err):
+			// Nothing is left to clean up with the cluster gone.
+		case err != nil:
+			return err
+		case cluster.DeletionTimestamp.IsZero():
+			mc, err := clusterClient(ctx, r.Client, r.NewClient, cluster)
+			if err != nil {
+				return err
+			}
+			name := desired.Spec.ExternalSecurityName
+			// MarkLogic refuses to delete a configuration App Servers use.
+			for _, s := range es.Status.AppServers {
+				if err := setExternalSecurity(ctx, mc, s, name, false); err != nil {
+					return err
+				}
+			}
+			log.FromContext(ctx).Info("deleting external security", "externalSecurity", name)
+			if err := mc.DeleteExternalSecurity(ctx, name); err != nil {
+				return fmt.Errorf("deleting external security %s: %w", name, err)
+			}
+		}
+	}
+	controllerutil.RemoveFinalizer(es, externalSecurityFinalizer)
+	return r.Update(ctx, es)
+}
+
+func (r *MarkLogicExternalSecurityReconciler) setReconciled(es *marklogicv1alpha1.MarkLogicExternalSecurity, status metav1.ConditionStatus, reason, message string) {
+	setCondition(&es.Status.Conditions, es.Generation, marklogicv1alpha1.ConditionReconciled, status, reason, message)
+}
+
+func (r *MarkLogicExternalSecurityReconciler) setInSync(es *marklogicv1alpha1.MarkLogicExternalSecurity, status metav1.ConditionStatus, reason, message string) {
+	setCondition(&es.Status.Conditions, es.Generation, marklogicv1alpha1.ConditionInSync, status, reason, message)
+}
+
+// externalSecuritySpec is the Management API form of the typed fields of the
+// spec.
+type externalSecuritySpec struct {
+	ExternalSecurityName string          `json:"external-security-name"`
+	Description          string          `json:"description,omitempty"`
+	Authentication       string          `json:"authentication"`
+	CacheTimeout         int32           `json:"cache-timeout"`
+	Authorization        string          `json:"authorization"`
+	LDAPServer           *ldapServerSpec `json:"ldap-server,omitempty"`
+}
+
+type ldapServerSpec struct {
+	ServerURI         string `json:"ldap-server-uri"`
+	Base              string `json:"ldap-base,omitempty"`
+	Attribute         string `json:"ldap-attribute"`
+	DefaultUser       string `json:"ldap-default-user,omitempty"`
+	BindMethod        string `json:"ldap-bind-method"`
+	MemberOfAttribute string `json:"ldap-memberof-attribute,omitempty"`
+	MemberAttribute   string `json:"ldap-member-attribute,omitempty"`
+	StartTLS          bool   `json:"ldap-start-tls"`
+}
+
+// externalSecurityProperties returns the desired properties of a defaulted
+// external security configuration, without the bind password.
+func externalSecurityProperties(es *marklogicv1alpha1.MarkLogicExternalSecurity, bind *corev1.Secret) (map[string]interface{}, error) {
+	s := es.Spec
+	spec := externalSecuritySpec{
+		ExternalSecurityName: s.ExternalSecurityName,
+		Description:          s.Description,
+		Authentication:       s.Authentication,
+		CacheTimeout:         *s.CacheTimeout,
+		Authorization:        s.Authorization,
+	}
+	if s.LDAP == nil && (s.Authentication == "ldap" || s.Authorization == "ldap") {
+		return nil, errors.New("spec.ldap must be set for ldap authentication or authorization")
+	}
+	if ldap := s.LDAP; ldap != nil {
+		spec.LDAPServer = &ldapServerSpec{
+			ServerURI:         ldap.ServerURI,
+			Base:              ldap.Base,
+			Attribute:         ldap.Attribute,
+			BindMethod:        ldap.BindMethod,
+			MemberOfAttribute: ldap.MemberOfAttribute,
+			MemberAttribute:   ldap.MemberAttribute,
+			StartTLS:          ldap.StartTLS,
+		}
+		if bind != nil {
+			if len(bind.Data["username"]) == 0 || len(bind.Data["password"]) == 0 {
+				return nil, fmt.Errorf("secret %s has no username and password", bind.Name)
+			}
+			spec.LDAPServer.DefaultUser = string(bind.Data["username"])
+		}
+	}
+	props, err := toProperties(spec)
+	if err != nil {
+		return nil, err
+	}
+	if s.Properties != nil {
+		if err := mergeRawProperties(props, s.Properties.Raw); err != nil {
+			return nil, fmt.Errorf("spec.properties: %w", err)
+		}
+	}
+	return props, nil
+}
+
+// withBindPassword returns a copy of props with password added to its LDAP
+// server, props itself when there is none.
+func withBindPassword(props map[string]interface{}, password string) map[string]interface{} {
+	server, ok := props["ldap-server"].(map[string]interface{})
+	if !ok || password == "" {
+		return props
+	}
+	out := make(map[string]interface{}, len(props))
+	for k, v := range props {
+		out[k] = v
+	}
+	withPassword := make(map[string]interface{}, len(server)+1)
+	for k, v := range server {
+		withPassword[k] = v
+	}
+	withPassword["ldap-password"] = password
+	out["ldap-server"] = withPassword
+	return out
+}
+
+// externalSecuritiesForCluster maps a MarkLogicCluster to the external
+// security configurations it holds.
+func (r *MarkLogicExternalSecurityReconciler) externalSecuritiesForCluster(ctx context.Context, obj client.Object) []ctrl.Request {
+	return r.externalSecuritiesFor(ctx, obj.GetNamespace(), func(es *marklogicv1alpha1.MarkLogicExternalSecurity) bool {
+		return es.Spec.ClusterRef.Name == obj.GetName()
+	})
+}
+
+// externalSecuritiesForSecret maps a secret to the external security
+// configurations binding with it.
+func (r *MarkLogicExternalSecurityReconciler) externalSecuritiesForSecret(ctx context.Context, obj client.Object) []ctrl.Request {
+	return r.externalSecuritiesFor(ctx, obj.GetNamespace(), func(es *marklogicv1alpha1.MarkLogicExternalSecurity) bool {
+		return es.Spec.LDAP != nil && es.Spec.LDAP.BindSecretName == obj.GetName()
+	})
+}
+
+func (r *MarkLogicExternalSecurityReconciler) externalSecuritiesFor(ctx context.Context, namespace string,
+	match func(*marklogicv1alpha1.MarkLogicExternalSecurity) bool) []ctrl.Request {
+	list := &marklogicv1alpha1.MarkLogicExternalSecurityList{}
+	if err := r.List(ctx, list, client.InNamespace(namespace)); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list MarkLogicExternalSecurities")
+		return nil
+	}
+	var requests []ctrl.Request
+	for i := range list.Items {
+		if match(&list.Items[i]) {
+			requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&list.Items[i])})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MarkLogicExternalSecurityReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&marklogicv1alpha1.MarkLogicExternalSecurity{}).
+		Watches(&marklogicv1alpha1.MarkLogicCluster{},
+			handler.EnqueueRequestsFromMapFunc(r.externalSecuritiesForCluster),
+			builder.WithPredicates(clusterChanged)).
+		Watches(&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.externalSecuritiesForSecret)).
+		Complete(r)
+}
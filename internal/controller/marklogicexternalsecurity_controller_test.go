@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+func newExternalSecurity(name, cluster string) *marklogicv1alpha1.MarkLogicExternalSecurity {
+	return &marklogicv1alpha1.MarkLogicExternalSecurity{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "marklogic", Generation: 1},
+		Spec: marklogicv1alpha1.MarkLogicExternalSecuritySpec{
+			ClusterRef: marklogicv1alpha1.ClusterReference{Name: cluster},
+			LDAP: &marklogicv1alpha1.ExternalSecurityLDAP{
+				ServerURI:      "ldaps://ldap.example.com:636",
+				Base:           "dc=example,dc=com",
+				BindSecretName: "ldap-bind",
+			},
+			AppServers: []marklogicv1alpha1.ExternalSecurityAppServer{{ServerName: "App-Services"}},
+		},
+	}
+}
+
+func newExternalSecurityReconciler(f *fakeMarkLogic, objs ...client.Object) *MarkLogicExternalSecurityReconciler {
+	s := testScheme()
+	c := fake.NewClientBuilder().
+		WithScheme(s).
+		WithObjects(objs...).
+		WithStatusSubresource(&marklogicv1alpha1.MarkLogicExternalSecurity{}, &marklogicv1alpha1.MarkLogicCluster{}).
+		Build()
+	return &MarkLogicExternalSecurityReconciler{Client: c, Scheme: s, NewClient: f.newClient}
+}
+
+func reconcileExternalSecurity(t *testing.T, r *MarkLogicExternalSecurityReconciler, name string) (ctrl.Result, *marklogicv1alpha1.MarkLogicExternalSecurity) {
+	t.Helper()
+	key := types.NamespacedName{Namespace: "marklogic", Name: name}
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	es := &marklogicv1alpha1.MarkLogicExternalSecurity{}
+	require.NoError(t, r.Get(context.Background(), key, es))
+	return result, es
+}
+
+func bindSecret(password string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ldap-bind", Namespace: "marklogic"},
+		Data:       map[string][]byte{"username": []byte("cn=marklogic,dc=example,dc=com"), "password": []byte(password)},
+	}
+}
+
+func TestReconcileExternalSecurityCreatesAndAssigns(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	f.put("servers", map[string]interface{}{"server-name": "App-Services", "group-name": "Default", "external-security": []interface{}{"saml"}})
+	cluster, secret := availableCluster(f, "dnode", 1, 1)
+	r := newExternalSecurityReconciler(f, cluster, secret, bindSecret("bind"), newExternalSecurity("ldap", "dnode"))
+	ctx := context.Background()
+
+	result, es := reconcileExternalSecurity(t, r, "ldap")
+	props := f.get("external-security", "ldap")
+	require.NotNil(t, props)
+	assert.Equal(t, "ldap", props["authentication"])
+	assert.Equal(t, "internal", props["authorization"])
+	assert.Equal(t, float64(300), props["cache-timeout"])
+	assert.Equal(t, map[string]interface{}{
+		"ldap-server-uri":   "ldaps://ldap.example.com:636",
+		"ldap-base":         "dc=example,dc=com",
+		"ldap-attribute":    "uid",
+		"ldap-default-user": "cn=marklogic,dc=example,dc=com",
+		"ldap-password":     "bind",
+		"ldap-bind-method":  "simple",
+		"ldap-start-tls":    false,
+	}, props["ldap-server"])
+	assert.Equal(t, []interface{}{"saml", "ldap"}, f.get("servers", "App-Services")["external-security"])
+
+	assert.Equal(t, driftCheckInterval, result.RequeueAfter)
+	assert.Equal(t, []string{externalSecurityFinalizer}, es.Finalizers)
+	assert.Equal(t, []marklogicv1alpha1.ExternalSecurityAppServer{{ServerName: "App-Services", GroupName: "Default"}}, es.Status.AppServers)
+	requireCondition(t, es.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionTrue, "Reconciled")
+	requireCondition(t, es.Status.Conditions, marklogicv1alpha1.ConditionInSync, metav1.ConditionTrue, "Created")
+
+	// Changes made outside of the operator are reverted, keeping the password.
+	props["cache-timeout"] = float64(60)
+	_, es = reconcileExternalSecurity(t, r, "ldap")
+	assert.Equal(t, []string{"cache-timeout"}, es.Status.Drift)
+	assert.Equal(t, float64(300), props["cache-timeout"])
+	requireCondition(t, es.Status.Conditions, marklogicv1alpha1.ConditionInSync, metav1.ConditionTrue, "DriftCorrected")
+
+	// A new bind password is sent although it cannot be compared.
+	bind := &corev1.Secret{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "ldap-bind"}, bind))
+	bind.Data["password"] = []byte("rotated")
+	require.NoError(t, r.Update(ctx, bind))
+	_, es = reconcileExternalSecurity(t, r, "ldap")
+	assert.Equal(t, "rotated", f.get("external-security", "ldap")["ldap-server"].(map[string]interface{})["ldap-password"])
+	assert.Equal(t, bind.ResourceVersion, es.Status.BindSecretVersion)
+
+	// App Servers removed from the spec stop using the configuration.
+	es.Spec.AppServers = nil
+	es.Generation++
+	require.NoError(t, r.Update(ctx, es))
+	_, es = reconcileExternalSecurity(t, r, "ldap")
+	assert.Equal(t, []interface{}{"saml"}, f.get("servers", "App-Services")["external-security"])
+	assert.Empty(t, es.Status.AppServers)
+}
+
+func TestReconcileExternalSecurityFallsBackToInternalSecurity(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	f.put("servers", map[string]interface{}{"server-name": "App-Services", "group-name": "Default", "internal-security": false,
+		"external-security": []interface{}{"ldap"}})
+	cluster, secret := availableCluster(f, "dnode", 1, 1)
+	es := newExternalSecurity("ldap", "dnode")
+	es.Spec.DeletionPolicy = marklogicv1alpha1.DeletionPolicyDelete
+	r := newExternalSecurityReconciler(f, cluster, secret, bindSecret("bind"), es)
+	_, es = reconcileExternalSecurity(t, r, "ldap")
+	assert.False(t, f.called("PUT /manage/v2/servers/App-Services/properties"), "the App Server already uses the configuration")
+
+	require.NoError(t, r.Delete(context.Background(), es))
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(es)})
+	require.NoError(t, err)
+	require.Error(t, r.Get(context.Background(), client.ObjectKeyFromObject(es), es), "finalizer is removed")
+	server := f.get("servers", "App-Services")
+	assert.Equal(t, []interface{}{}, server["external-security"])
+	assert.Equal(t, true, server["internal-security"])
+	assert.Nil(t, f.get("external-security", "ldap"))
+}
+
+func TestReconcileExternalSecurityRejectsInvalidSpec(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	cluster, secret := availableCluster(f, "dnode", 1, 1)
+	es := newExternalSecurity("kerberos", "dnode")
+	es.Spec.Authentication = "kerberos"
+	es.Spec.Authorization = "ldap"
+	es.Spec.LDAP = nil
+	missing := newExternalSecurity("missing", "dnode")
+	r := newExternalSecurityReconciler(f, cluster, secret, es, missing)
+
+	_, es = reconcileExternalSecurity(t, r, "kerberos")
+	requireCondition(t, es.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "InvalidSpec")
+	_, missing = reconcileExternalSecurity(t, r, "missing")
+	requireCondition(t, missing.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "SecretNotFound")
+	assert.False(t, f.called("POST /manage/v2/external-security"))
+}
@@ -0,0 +1,258 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/internal/credentials"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// MarkLogicPasswordReconciler rotates the admin password of a
+// MarkLogicCluster when the password in its auth secret changes. The new
+// password is set through the Management API and verified on every ready
+// host before the active secret, which the hook scripts of the pods read, is
+// updated. When a host rejects it, the previous password is restored.
+type MarkLogicPasswordReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// NewClient creates the MarkLogic clients. Defaults to mlclient.New.
+	NewClient mlclient.Factory
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicclusters,verbs=get;list;watch
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicclusters/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;update
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile changes the admin password of a MarkLogicCluster to the one of its
+// auth secret.
+func (r *MarkLogicPasswordReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	cluster := &marklogicv1alpha1.MarkLogicCluster{}
+	if err := r.Get(ctx, req.NamespacedName, cluster); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !cluster.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+	desired := cluster.DeepCopy()
+	desired.Default()
+	// Only the auth secret is rotated, other providers keep the credentials
+	// outside of Kubernetes.
+	if desired.Spec.Auth.Provider != marklogicv1alpha1.CredentialsProviderSecret {
+		return ctrl.Result{}, nil
+	}
+
+	auth := &corev1.Secret{}
+	active := &corev1.Secret{}
+	for name, secret := range map[string]*corev1.Secret{authSecretName(desired): auth, activeAuthSecretName(desired): active} {
+		if err := r.Get(ctx, types.NamespacedName{Namespace: desired.Namespace, Name: name}, secret); err != nil {
+			// The cluster controller reports a missing auth secret and
+			// creates the active one.
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+	}
+
+	// The credentials are read like every other client reads them, without
+	// surrounding whitespace, so the password set is the one they send.
+	authCreds, err := (&credentials.SecretProvider{Client: r.Client, Namespace: desired.Namespace, Name: auth.Name}).Credentials(ctx)
+	if err != nil {
+		r.setSynced(cluster, metav1.ConditionFalse, "InvalidSecret", err.Error())
+		return ctrl.Result{}, r.Status().Update(ctx, cluster)
+	}
+	activeCreds, err := (&credentials.SecretProvider{Client: r.Client, Namespace: desired.Namespace, Name: active.Name}).Credentials(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if authCreds.Username != activeCreds.Username {
+		r.setSynced(cluster, metav1.ConditionFalse, "UsernameChanged", fmt.Sprintf(
+			"the admin username cannot be changed, restore username %s in secret %s", activeCreds.Username, auth.Name))
+		return ctrl.Result{}, r.Status().Update(ctx, cluster)
+	}
+	if authCreds.Password == activeCreds.Password {
+		// Keys only read at the first boot, like wallet-password, are
+		// copied as they are.
+		if !reflect.DeepEqual(auth.Data, active.Data) {
+			active.Data = auth.Data
+			if err := r.Update(ctx, active); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		r.setSynced(cluster, metav1.ConditionTrue, "Synced", "the admin password matches secret "+auth.Name)
+		return ctrl.Result{}, r.Status().Update(ctx, cluster)
+	}
+	if st := cluster.Status.AdminPassword; st != nil && st.RolledBackVersion == auth.ResourceVersion {
+		return ctrl.Result{}, nil
+	}
+
+	err = r.rotate(ctx, cluster, desired, auth, active, activeCreds.Username, activeCreds.Password, authCreds.Password)
+	switch {
+	case errors.Is(err, errClusterNotReady):
+		return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, nil
+	case err != nil:
+		log.FromContext(ctx).Error(err, "failed to rotate the admin password")
+		r.setSynced(cluster, metav1.ConditionFalse, "RotationFailed", err.Error())
+		if statusErr := r.Status().Update(ctx, cluster); statusErr != nil {
+			log.FromContext(ctx).Error(statusErr, "failed to update MarkLogicCluster status")
+		}
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, r.Status().Update(ctx, cluster)
+}
+
+// rotate changes the password of username in MarkLogic from oldPassword, the
+// one of active, to newPassword, the one of auth, verifies it on every ready
+// host and then copies auth into active. If a host rejects the new password,
+// the old one is restored.
+func (r *MarkLogicPasswordReconciler) rotate(ctx context.Context, cluster, desired *marklogicv1alpha1.MarkLogicCluster, auth, active *corev1.Secret,
+	username, oldPassword, newPassword string) error {
+	logger := log.FromContext(ctx)
+	mc, err := clusterClient(ctx, r.Client, r.NewClient, desired)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("changing the admin password", "user", username)
+	err = mc.UpdateUserProperties(ctx, username, mlclient.UserProperties{"password": newPassword})
+	if err != nil && !mlclient.IsUnauthorized(err) {
+		return fmt.Errorf("changing the password of %s: %w", username, err)
+	}
+	// A rejected old password means the new one was set before the active
+	// secret could be updated; it is verified like a fresh change.
+	verified, verifyErr := r.verify(ctx, desired, username, newPassword)
+	switch {
+	case verifyErr == nil:
+	case !mlclient.IsUnauthorized(verifyErr):
+		// Only a host rejecting the password is a reason to roll back, the
+		// others are retried.
+		return fmt.Errorf("verifying the admin password: %w", verifyErr)
+	case err != nil:
+		return fmt.Errorf("neither the active nor the new admin password is accepted: %w", verifyErr)
+	default:
+		logger.Info("rolling back the admin password", "reason", verifyErr.Error())
+		if err := r.setPassword(ctx, desired, username, []string{newPassword, oldPassword}, oldPassword); err != nil {
+			return fmt.Errorf("rolling back the admin password after %v: %w", verifyErr, err)
+		}
+		st := &marklogicv1alpha1.AdminPasswordStatus{RolledBackVersion: auth.ResourceVersion}
+		if prev := cluster.Status.AdminPassword; prev != nil {
+			st.RotationTime = prev.RotationTime
+		}
+		cluster.Status.AdminPassword = st
+		message := fmt.Sprintf("the password of secret %s was rolled back: %v", auth.Name, verifyErr)
+		r.Recorder.Event(cluster, corev1.EventTypeWarning, "AdminPasswordRolledBack", message)
+		r.setSynced(cluster, metav1.ConditionFalse, "RolledBack", message)
+		return nil
+	}
+
+	// The pods read the credentials from the active secret, which the
+	// kubelet refreshes in their volumes.
+	active.Data = auth.Data
+	if err := r.Update(ctx, active); err != nil {
+		return fmt.Errorf("updating secret %s: %w", active.Name, err)
+	}
+	cluster.Status.AdminPassword = &marklogicv1alpha1.AdminPasswordStatus{RotationTime: &metav1.Time{Time: r.now()}}
+	r.Recorder.Eventf(cluster, corev1.EventTypeNormal, "AdminPasswordRotated",
+		"changed the password of %s and verified it on %d hosts", username, verified)
+	r.setSynced(cluster, metav1.ConditionTrue, "Synced", "the admin password matches secret "+auth.Name)
+	return nil
+}
+
+// verify logs in to the hosts of the ready pods of the cluster with the given
+// credentials and returns how many accepted them. Unready hosts cannot answer,
+// they read the active secret when they start.
+func (r *MarkLogicPasswordReconciler) verify(ctx context.Context, c *marklogicv1alpha1.MarkLogicCluster, username, password string) (int, error) {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(c.Namespace), client.MatchingLabels(selectorLabels(c))); err != nil {
+		return 0, fmt.Errorf("listing pods: %w", err)
+	}
+	verified := 0
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if podOrdinal(pod.Name) < 0 || !podReady(pod) {
+			continue
+		}
+		host := hostFQDN(c, podOrdinal(pod.Name))
+		hc, err := newHostClient(ctx, r.Client, r.NewClient, c, host, username, password)
+		if err != nil {
+			return verified, err
+		}
+		if _, err := hc.HostProperties(ctx, host); err != nil {
+			return verified, fmt.Errorf("host %s: %w", host, err)
+		}
+		verified++
+	}
+	return verified, nil
+}
+
+// setPassword sets the password of username on the bootstrap host, logging
+// in with the first of logins that is accepted.
+func (r *MarkLogicPasswordReconciler) setPassword(ctx context.Context, c *marklogicv1alpha1.MarkLogicCluster, username string, logins []string, password string) error {
+	var err error
+	for _, login := range logins {
+		var hc *mlclient.Client
+		hc, err = newHostClient(ctx, r.Client, r.NewClient, c, fqdn(c), username, login)
+		if err != nil {
+			return err
+		}
+		err = hc.UpdateUserProperties(ctx, username, mlclient.UserProperties{"password": password})
+		if !mlclient.IsUnauthorized(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func (r *MarkLogicPasswordReconciler) setSynced(cluster *marklogicv1alpha1.MarkLogicCluster, status metav1.ConditionStatus, reason, message string) {
+	setCondition(&cluster.Status.Conditions, cluster.Generation, marklogicv1alpha1.ConditionAdminPasswordSynced, status, reason, message)
+}
+
+func (r *MarkLogicPasswordReconciler) now() time.Time {
+	if r.Now == nil {
+		return time.Now()
+	}
+	return r.Now()
+}
+
+// passwordClusters maps a secret to the clusters using it as auth or active
+// secret.
+func (r *MarkLogicPasswordReconciler) passwordClusters(ctx context.Context, obj client.Object) []ctrl.Request {
+	clusters := &marklogicv1alpha1.MarkLogicClusterList{}
+	if err := r.List(ctx, clusters, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	var requests []ctrl.Request
+	for i := range clusters.Items {
+		c := &clusters.Items[i]
+		if authSecretName(c) == obj.GetName() || activeAuthSecretName(c) == obj.GetName() {
+			requests = append(requests, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: c.Namespace, Name: c.Name}})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MarkLogicPasswordReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("marklogicpassword").
+		For(&marklogicv1alpha1.MarkLogicCluster{}).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.passwordClusters)).
+		Complete(r)
+}
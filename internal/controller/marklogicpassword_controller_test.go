@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// passwordTest is a cluster of two ready hosts and an unready one whose admin
+// user logs in with the password "admin".
+type passwordTest struct {
+	r        *MarkLogicPasswordReconciler
+	recorder *record.FakeRecorder
+	f        *fakeMarkLogic
+}
+
+func newPasswordTest(t *testing.T) *passwordTest {
+	f := newFakeMarkLogic(t)
+	f.authenticate = true
+	f.put("users", map[string]interface{}{"user-name": "admin", "password": "admin"})
+	cluster, secret := availableCluster(f, "dnode", 3, 3)
+	active := secret.DeepCopy()
+	active.Name = "dnode-admin-active"
+	unready := upgradePod(cluster, 2, "dnode-old", oldImage)
+	unready.Status.Conditions = nil
+	s := testScheme()
+	c := fake.NewClientBuilder().
+		WithScheme(s).
+		WithObjects(cluster, secret, active, upgradePod(cluster, 0, "dnode-old", oldImage), upgradePod(cluster, 1, "dnode-old", oldImage), unready).
+		WithStatusSubresource(&marklogicv1alpha1.MarkLogicCluster{}).
+		Build()
+	pt := &passwordTest{recorder: record.NewFakeRecorder(10), f: f}
+	pt.r = &MarkLogicPasswordReconciler{Client: c, Scheme: s, Recorder: pt.recorder, NewClient: f.newClient,
+		Now: func() time.Time { return scheduleCreated }}
+	return pt
+}
+
+func (pt *passwordTest) reconcile(t *testing.T) *marklogicv1alpha1.MarkLogicCluster {
+	t.Helper()
+	key := types.NamespacedName{Namespace: "marklogic", Name: "dnode"}
+	_, err := pt.r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	cluster := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, pt.r.Get(context.Background(), key, cluster))
+	return cluster
+}
+
+// setPassword changes the password in the auth secret.
+func (pt *passwordTest) setPassword(t *testing.T, password string) {
+	t.Helper()
+	secret := pt.secret(t, "dnode-admin")
+	secret.Data["password"] = []byte(password)
+	require.NoError(t, pt.r.Update(context.Background(), secret))
+}
+
+func (pt *passwordTest) secret(t *testing.T, name string) *corev1.Secret {
+	t.Helper()
+	secret := &corev1.Secret{}
+	require.NoError(t, pt.r.Get(context.Background(), types.NamespacedName{Namespace: "marklogic", Name: name}, secret))
+	return secret
+}
+
+func TestReconcilePasswordRotatesAdminPassword(t *testing.T) {
+	pt := newPasswordTest(t)
+	// The host of the unready pod cannot be asked.
+	pt.r.NewClient = func(cfg mlclient.Config) (*mlclient.Client, error) {
+		if cfg.Host == "dnode-2.dnode.marklogic.svc.cluster.local" {
+			return mlclient.New(mlclient.Config{Host: "127.0.0.1", AdminPort: 1, ManagePort: 1, MaxRetries: -1})
+		}
+		return pt.f.newClient(cfg)
+	}
+	cluster := pt.reconcile(t)
+	requireCondition(t, cluster.Status.Conditions, marklogicv1alpha1.ConditionAdminPasswordSynced, metav1.ConditionTrue, "Synced")
+	require.False(t, pt.f.called("PUT /manage/v2/users/admin/properties"))
+
+	pt.setPassword(t, "rotated")
+	cluster = pt.reconcile(t)
+	require.Equal(t, "rotated", pt.f.get("users", "admin")["password"])
+	require.Equal(t, "rotated", string(pt.secret(t, "dnode-admin-active").Data["password"]))
+	requireCondition(t, cluster.Status.Conditions, marklogicv1alpha1.ConditionAdminPasswordSynced, metav1.ConditionTrue, "Synced")
+	require.True(t, scheduleCreated.Equal(cluster.Status.AdminPassword.RotationTime.Time))
+	require.Equal(t, []string{"Normal AdminPasswordRotated changed the password of admin and verified it on 2 hosts"}, events(pt.recorder))
+}
+
+func TestReconcilePasswordTrimsSecret(t *testing.T) {
+	pt := newPasswordTest(t)
+	// kubectl create secret --from-file keeps the trailing newline.
+	pt.setPassword(t, "rotated\n")
+	cluster := pt.reconcile(t)
+	require.Equal(t, "rotated", pt.f.get("users", "admin")["password"])
+	require.Equal(t, "rotated\n", string(pt.secret(t, "dnode-admin-active").Data["password"]))
+	requireCondition(t, cluster.Status.Conditions, marklogicv1alpha1.ConditionAdminPasswordSynced, metav1.ConditionTrue, "Synced")
+
+	// The same password without the newline needs no rotation.
+	pt.f.calls = nil
+	pt.setPassword(t, "rotated")
+	pt.reconcile(t)
+	require.False(t, pt.f.called("PUT /manage/v2/users/admin/properties"))
+	require.Equal(t, "rotated", string(pt.secret(t, "dnode-admin-active").Data["password"]))
+}
+
+func TestReconcilePasswordRollsBackRejectedPassword(t *testing.T) {
+	pt := newPasswordTest(t)
+	// The second host does not accept the new password.
+	pt.r.NewClient = func(cfg mlclient.Config) (*mlclient.Client, error) {
+		if cfg.Host == "dnode-1.dnode.marklogic.svc.cluster.local" && cfg.Password == "rotated" {
+			cfg.Password = "stale"
+		}
+		return pt.f.newClient(cfg)
+	}
+
+	pt.setPassword(t, "rotated")
+	cluster := pt.reconcile(t)
+	require.Equal(t, "admin", pt.f.get("users", "admin")["password"])
+	require.Equal(t, "admin", string(pt.secret(t, "dnode-admin-active").Data["password"]))
+	requireCondition(t, cluster.Status.Conditions, marklogicv1alpha1.ConditionAdminPasswordSynced, metav1.ConditionFalse, "RolledBack")
+	require.Equal(t, pt.secret(t, "dnode-admin").ResourceVersion, cluster.Status.AdminPassword.RolledBackVersion)
+	recorded := events(pt.recorder)
+	require.Len(t, recorded, 1)
+	require.Contains(t, recorded[0], "Warning AdminPasswordRolledBack the password of secret dnode-admin was rolled back: host dnode-1")
+
+	// The rejected password is not retried until the secret changes.
+	pt.f.calls = nil
+	pt.reconcile(t)
+	require.False(t, pt.f.called("PUT /manage/v2/users/admin/properties"))
+	pt.r.NewClient = pt.f.newClient
+	pt.setPassword(t, "rotated")
+	secret := pt.secret(t, "dnode-admin")
+	secret.Annotations = map[string]string{"rotate": "again"}
+	require.NoError(t, pt.r.Update(context.Background(), secret))
+	cluster = pt.reconcile(t)
+	require.Equal(t, "rotated", pt.f.get("users", "admin")["password"])
+	requireCondition(t, cluster.Status.Conditions, marklogicv1alpha1.ConditionAdminPasswordSynced, metav1.ConditionTrue, "Synced")
+}
+
+func TestReconcilePasswordRetriesUnreachableHost(t *testing.T) {
+	pt := newPasswordTest(t)
+	unreachable := true
+	pt.r.NewClient = func(cfg mlclient.Config) (*mlclient.Client, error) {
+		if unreachable && cfg.Host == "dnode-1.dnode.marklogic.svc.cluster.local" {
+			return mlclient.New(mlclient.Config{Host: "127.0.0.1", AdminPort: 1, ManagePort: 1, MaxRetries: -1})
+		}
+		return pt.f.newClient(cfg)
+	}
+
+	pt.setPassword(t, "rotated")
+	key := types.NamespacedName{Namespace: "marklogic", Name: "dnode"}
+	_, err := pt.r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.Error(t, err)
+	cluster := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, pt.r.Get(context.Background(), key, cluster))
+	requireCondition(t, cluster.Status.Conditions, marklogicv1alpha1.ConditionAdminPasswordSynced, metav1.ConditionFalse, "RotationFailed")
+	require.Nil(t, cluster.Status.AdminPassword, "the password is not rolled back")
+	require.Equal(t, "rotated", pt.f.get("users", "admin")["password"])
+	require.Equal(t, "admin", string(pt.secret(t, "dnode-admin-active").Data["password"]))
+
+	// The retry verifies the password that is already set.
+	unreachable = false
+	cluster = pt.reconcile(t)
+	require.Equal(t, "rotated", string(pt.secret(t, "dnode-admin-active").Data["password"]))
+	requireCondition(t, cluster.Status.Conditions, marklogicv1alpha1.ConditionAdminPasswordSynced, metav1.ConditionTrue, "Synced")
+}
+
+func TestReconcilePasswordAdoptsChangedPassword(t *testing.T) {
+	pt := newPasswordTest(t)
+	// The password was changed, but the active secret was not updated.
+	pt.f.put("users", map[string]interface{}{"user-name": "admin", "password": "rotated"})
+	pt.setPassword(t, "rotated")
+
+	cluster := pt.reconcile(t)
+	require.Equal(t, "rotated", string(pt.secret(t, "dnode-admin-active").Data["password"]))
+	requireCondition(t, cluster.Status.Conditions, marklogicv1alpha1.ConditionAdminPasswordSynced, metav1.ConditionTrue, "Synced")
+}
+
+func TestReconcilePasswordRejectsUsernameChange(t *testing.T) {
+	pt := newPasswordTest(t)
+	secret := pt.secret(t, "dnode-admin")
+	secret.Data["username"] = []byte("root")
+	require.NoError(t, pt.r.Update(context.Background(), secret))
+
+	cluster := pt.reconcile(t)
+	requireCondition(t, cluster.Status.Conditions, marklogicv1alpha1.ConditionAdminPasswordSynced, metav1.ConditionFalse, "UsernameChanged")
+	require.Equal(t, "admin", string(pt.secret(t, "dnode-admin-active").Data["username"]))
+}
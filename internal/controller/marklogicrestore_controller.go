@@ -0,0 +1,490 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// restoreFinalizer lets the operator unblock the App Servers of a restore
+// deleted while it runs.
+const restoreFinalizer = "marklogic.com/restore"
+
+// errTrafficNotBlocked is returned while the services or HAProxy of the
+// cluster still route traffic to the blocked App Servers.
+var errTrafficNotBlocked = errors.New("traffic is not blocked yet")
+
+// MarkLogicRestoreReconciler reconciles a MarkLogicRestore object
+type MarkLogicRestoreReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// NewClient creates the MarkLogic clients. Defaults to mlclient.New.
+	NewClient mlclient.Factory
+}
+
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicrestores,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicrestores/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicrestores/finalizers,verbs=update
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicbackups,verbs=get;list;watch
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicappservers,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups="",resources=services;configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile validates the backup of a MarkLogicRestore, blocks the App
+// Servers of the database, waits until the services and HAProxy of the
+// cluster no longer expose them, runs the restore job and follows it until it
+// completes or fails, then unblocks the App Servers again.
+func (r *MarkLogicRestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	restore := &marklogicv1alpha1.MarkLogicRestore{}
+	if err := r.Get(ctx, req.NamespacedName, restore); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if restoreFinished(restore) || !restore.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, r.release(ctx, restore)
+	}
+	if controllerutil.AddFinalizer(restore, restoreFinalizer) {
+		if err := r.Update(ctx, restore); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	desired := restore.DeepCopy()
+	desired.Default()
+	if restore.Status.Phase == "" {
+		restore.Status.Phase = marklogicv1alpha1.RestorePending
+	}
+
+	if ref := desired.Spec.BackupRef; ref != nil {
+		backup := &marklogicv1alpha1.MarkLogicBackup{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: restore.Namespace, Name: ref.Name}, backup)
+		switch {
+		case apierrors.IsNotFound(err):
+			r.setReconciled(restore, metav1.ConditionFalse, "BackupNotFound", fmt.Sprintf("MarkLogicBackup %s not found", ref.Name))
+			return ctrl.Result{}, r.Status().Update(ctx, restore)
+		case err != nil:
+			return ctrl.Result{}, err
+		case backup.Status.Phase == marklogicv1alpha1.BackupFailed:
+			r.fail(restore, "RestoreFailed", fmt.Sprintf("MarkLogicBackup %s failed", ref.Name))
+			return r.finish(ctx, restore)
+		case backup.Status.Phase != marklogicv1alpha1.BackupCompleted:
+			r.setReconciled(restore, metav1.ConditionFalse, "BackupNotCompleted", fmt.Sprintf("waiting for MarkLogicBackup %s to complete", ref.Name))
+			return ctrl.Result{}, r.Status().Update(ctx, restore)
+		}
+		backupSource(desired, backup)
+	}
+	restore.Status.Database = desired.Spec.Database
+	restore.Status.BackupDir = desired.Spec.BackupDir
+
+	cluster, err := clusterFor(ctx, r.Client, restore.Namespace, desired.Spec.ClusterRef)
+	if apierrors.IsNotFound(err) {
+		r.setReconciled(restore, metav1.ConditionFalse, "ClusterNotFound", fmt.Sprintf("MarkLogicCluster %s not found", desired.Spec.ClusterRef.Name))
+		return ctrl.Result{}, r.Status().Update(ctx, restore)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	mc, err := clusterClient(ctx, r.Client, r.NewClient, cluster)
+	if errors.Is(err, errClusterNotReady) {
+		r.setReconciled(restore, metav1.ConditionFalse, "ClusterNotReady", fmt.Sprintf("waiting for MarkLogicCluster %s to become available", cluster.Name))
+		return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, restore)
+	}
+	if errors.Is(err, errCredentialsUnavailable) {
+		r.setReconciled(restore, metav1.ConditionFalse, "CredentialsUnavailable", err.Error())
+		return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, restore)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	result, err := r.reconcileJob(ctx, mc, cluster, restore, desired)
+	if errors.Is(err, errTrafficNotBlocked) {
+		r.setReconciled(restore, metav1.ConditionFalse, "TrafficNotBlocked", err.Error())
+		return ctrl.Result{RequeueAfter: backupPollInterval}, r.Status().Update(ctx, restore)
+	}
+	if err != nil {
+		logger.Error(err, "failed to reconcile restore", "database", desired.Spec.Database)
+		r.setReconciled(restore, metav1.ConditionFalse, "ReconcileError", err.Error())
+		if statusErr := r.Status().Update(ctx, restore); statusErr != nil {
+			logger.Error(statusErr, "failed to update MarkLogicRestore status")
+		}
+		return ctrl.Result{}, err
+	}
+	if restoreFinished(restore) {
+		return r.finish(ctx, restore)
+	}
+	message := "restore job is tracked"
+	if *desired.Spec.BlockTraffic {
+		message += fmt.Sprintf("; App Servers not managed by a MarkLogicAppServer, such as the default ones, are not blocked and still serve database %s",
+			desired.Spec.Database)
+	}
+	r.setReconciled(restore, metav1.ConditionTrue, "Reconciled", message)
+	return result, r.Status().Update(ctx, restore)
+}
+
+// backupSource points the restore at the directories of backup. An
+// incremental backup is restored on top of the full backups in its backup
+// directory.
+func backupSource(desired *marklogicv1alpha1.MarkLogicRestore, backup *marklogicv1alpha1.MarkLogicBackup) {
+	b := backup.DeepCopy()
+	b.Default()
+	if desired.Spec.Database == "" {
+		desired.Spec.Database = b.Spec.Database
+	}
+	desired.Spec.BackupDir = b.Spec.BackupDir
+	desired.Spec.Incremental = b.Spec.Incremental
+	desired.Spec.IncrementalDir = b.Spec.IncrementalDir
+}
+
+// reconcileJob validates the backup once and starts the restore job, or
+// checks on the one already started. Requests MarkLogic refuses fail the
+// restore; connection errors and 5xx answers are retried.
+func (r *MarkLogicRestoreReconciler) reconcileJob(ctx context.Context, mc *mlclient.Client, cluster *marklogicv1alpha1.MarkLogicCluster,
+	restore, desired *marklogicv1alpha1.MarkLogicRestore) (ctrl.Result, error) {
+	spec := desired.Spec
+	if restore.Status.JobID != "" {
+		return r.pollJob(ctx, mc, restore, spec.Database)
+	}
+
+	in := mlclient.RestoreRequest{
+		BackupDir:       spec.BackupDir,
+		IncludeReplicas: *spec.IncludeReplicas,
+		Incremental:     spec.Incremental,
+	}
+	if spec.Incremental {
+		in.IncrementalDir = spec.IncrementalDir
+	}
+	if restore.Status.ValidationTime == nil {
+		restore.Status.Phase = marklogicv1alpha1.RestoreValidating
+		validation, err := mc.ValidateBackup(ctx, spec.Database, in)
+		if refused(err) {
+			r.fail(restore, "ValidationFailed", fmt.Sprintf("MarkLogic refused to validate the backup of database %s in %s: %v", spec.Database, spec.BackupDir, err))
+			return ctrl.Result{}, nil
+		}
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("validating backup of database %s: %w", spec.Database, err)
+		}
+		if failed := validation.Failed(); len(failed) > 0 {
+			var reasons []string
+			for _, f := range failed {
+				reasons = append(reasons, fmt.Sprintf("forest %s: %s %s", f.ForestName, f.Status, f.Message))
+			}
+			r.fail(restore, "ValidationFailed", fmt.Sprintf("backup of database %s in %s failed validation: %s",
+				spec.Database, spec.BackupDir, strings.Join(reasons, "; ")))
+			return ctrl.Result{}, nil
+		}
+		now := metav1.Now()
+		restore.Status.ValidationTime = &now
+	}
+
+	if *spec.BlockTraffic {
+		if err := r.block(ctx, restore, spec.Database); err != nil {
+			return ctrl.Result{}, err
+		}
+		exposed, err := r.exposed(ctx, cluster, restore.Status.BlockedAppServers)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if len(exposed) > 0 {
+			return ctrl.Result{}, fmt.Errorf("%w: the services or HAProxy of MarkLogicCluster %s still expose App Servers %s",
+				errTrafficNotBlocked, cluster.Name, strings.Join(exposed, ", "))
+		}
+	}
+
+	if spec.RestoreToTime != nil {
+		in.RestoreToTime = spec.RestoreToTime.UTC().Format(time.RFC3339)
+	}
+	log.FromContext(ctx).Info("starting restore", "database", spec.Database, "backupDir", spec.BackupDir, "restoreToTime", in.RestoreToTime)
+	job, err := mc.Restore(ctx, spec.Database, in)
+	if refused(err) {
+		r.fail(restore, "RestoreFailed", fmt.Sprintf("MarkLogic refused the restore of database %s: %v", spec.Database, err))
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("starting restore of database %s: %w", spec.Database, err)
+	}
+	now := metav1.Now()
+	restore.Status.Phase = marklogicv1alpha1.RestoreRestoring
+	restore.Status.JobID = job.JobID
+	restore.Status.HostName = job.HostName
+	restore.Status.StartTime = &now
+	r.Recorder.Eventf(restore, corev1.EventTypeNormal, "RestoreStarted", "started restore job %s of database %s from %s",
+		job.JobID, spec.Database, spec.BackupDir)
+	return ctrl.Result{RequeueAfter: backupPollInterval}, nil
+}
+
+// pollJob records the progress of the restore job per host.
+func (r *MarkLogicRestoreReconciler) pollJob(ctx context.Context, mc *mlclient.Client,
+	restore *marklogicv1alpha1.MarkLogicRestore, database string) (ctrl.Result, error) {
+	jobID := restore.Status.JobID
+	status, err := mc.RestoreStatus(ctx, database, mlclient.Job{JobID: jobID, HostName: restore.Status.HostName})
+	if mlclient.IsNotFound(err) {
+		r.fail(restore, "RestoreFailed", fmt.Sprintf("restore job %s is unknown to MarkLogic", jobID))
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("reading status of restore job %s: %w", jobID, err)
+	}
+	restore.Status.Hosts = restoreHosts(status.Forests)
+	switch status.Status {
+	case mlclient.JobCompleted:
+		now := metav1.Now()
+		restore.Status.Phase = marklogicv1alpha1.RestoreCompleted
+		restore.Status.CompletionTime = &now
+		r.Recorder.Eventf(restore, corev1.EventTypeNormal, "RestoreCompleted", "restore job %s of database %s completed", jobID, database)
+		return ctrl.Result{}, nil
+	case mlclient.JobFailed, mlclient.JobCancelled:
+		r.fail(restore, "RestoreFailed", fmt.Sprintf("restore job %s of database %s %s", jobID, database, status.Status))
+		return ctrl.Result{}, nil
+	default:
+		return ctrl.Result{RequeueAfter: backupPollInterval}, nil
+	}
+}
+
+// restoreHosts sums up the status of the restored forests per host, sorted by host.
+func restoreHosts(forests []mlclient.ForestJobStatus) []marklogicv1alpha1.HostRestoreStatus {
+	byHost := map[string]*marklogicv1alpha1.HostRestoreStatus{}
+	var hosts []string
+	for _, f := range forests {
+		h, ok := byHost[f.HostName]
+		if !ok {
+			h = &marklogicv1alpha1.HostRestoreStatus{Host: f.HostName, Status: mlclient.JobCompleted}
+			byHost[f.HostName] = h
+			hosts = append(hosts, f.HostName)
+		}
+		h.Forests++
+		switch f.Status {
+		case mlclient.JobCompleted:
+			h.CompletedForests++
+		case mlclient.JobFailed, mlclient.JobCancelled:
+			h.Status = mlclient.JobFailed
+		default:
+			if h.Status != mlclient.JobFailed {
+				h.Status = mlclient.JobInProgress
+			}
+		}
+	}
+	sort.Strings(hosts)
+	var status []marklogicv1alpha1.HostRestoreStatus
+	for _, host := range hosts {
+		status = append(status, *byHost[host])
+	}
+	return status
+}
+
+// block takes the MarkLogicAppServers of the cluster using database as their
+// content or modules database off the services and HAProxy. Other App
+// Servers, such as App-Services on port 8000 that the operator itself
+// relies on, are not blocked.
+func (r *MarkLogicRestoreReconciler) block(ctx context.Context, restore *marklogicv1alpha1.MarkLogicRestore, database string) error {
+	list := &marklogicv1alpha1.MarkLogicAppServerList{}
+	if err := r.List(ctx, list, client.InNamespace(restore.Namespace)); err != nil {
+		return fmt.Errorf("listing MarkLogicAppServers: %w", err)
+	}
+	var blocked []string
+	changed := false
+	for i := range list.Items {
+		s := &list.Items[i]
+		if s.Spec.ClusterRef.Name != restore.Spec.ClusterRef.Name ||
+			(s.Spec.ContentDatabase != database && s.Spec.ModulesDatabase != database) {
+			continue
+		}
+		if by, ok := s.Annotations[marklogicv1alpha1.AnnotationBlockedByRestore]; ok && by != restore.Name {
+			continue
+		}
+		if s.Annotations[marklogicv1alpha1.AnnotationBlockedByRestore] != restore.Name {
+			if s.Annotations == nil {
+				s.Annotations = map[string]string{}
+			}
+			s.Annotations[marklogicv1alpha1.AnnotationBlockedByRestore] = restore.Name
+			if err := r.Update(ctx, s); err != nil {
+				return fmt.Errorf("blocking MarkLogicAppServer %s: %w", s.Name, err)
+			}
+			changed = true
+		}
+		blocked = append(blocked, s.Name)
+	}
+	sort.Strings(blocked)
+	restore.Status.BlockedAppServers = blocked
+	if changed {
+		r.Recorder.Eventf(restore, corev1.EventTypeNormal, "TrafficBlocked", "blocked App Servers %s during the restore of database %s",
+			strings.Join(blocked, ", "), database)
+	}
+	return nil
+}
+
+// exposed returns the blocked App Servers that the services or the HAProxy
+// configuration of the cluster still route traffic to. The cluster controller
+// takes them off once it sees the block. Ports the cluster spec lists itself
+// stay exposed and are not waited for.
+func (r *MarkLogicRestoreReconciler) exposed(ctx context.Context, cluster *marklogicv1alpha1.MarkLogicCluster, blocked []string) ([]string, error) {
+	ports := map[string]bool{}
+	for _, name := range []string{headlessServiceName(cluster), clusterServiceName(cluster)} {
+		svc := &corev1.Service{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: cluster.Namespace, Name: name}, svc)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading service %s: %w", name, err)
+		}
+		for _, p := range svc.Spec.Ports {
+			ports[p.Name] = true
+		}
+	}
+	for _, p := range cluster.Spec.Service.AdditionalPorts {
+		delete(ports, p.Name)
+	}
+	cm := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: cluster.Namespace, Name: haproxyName(cluster)}, cm)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("reading HAProxy configuration: %w", err)
+	}
+	cfg := cm.Data[haproxyConfigKey]
+	proxied := map[int32]bool{}
+	for _, p := range cluster.Spec.HAProxy.AdditionalAppServers {
+		proxied[backendPort(p)] = true
+	}
+	for _, p := range cluster.Spec.HAProxy.TCPPorts.Ports {
+		proxied[backendPort(p)] = true
+	}
+
+	var exposed []string
+	for _, name := range blocked {
+		s := &marklogicv1alpha1.MarkLogicAppServer{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: cluster.Namespace, Name: name}, s)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.Default()
+		routed := !proxied[s.Spec.Port] && strings.Contains(cfg, fmt.Sprintf("server ml-%s-%d-", cluster.Name, s.Spec.Port))
+		if ports[appServerPortName(s)] || routed {
+			exposed = append(exposed, name)
+		}
+	}
+	return exposed, nil
+}
+
+// release unblocks the App Servers of a finished or deleted restore and
+// removes its finalizer.
+func (r *MarkLogicRestoreReconciler) release(ctx context.Context, restore *marklogicv1alpha1.MarkLogicRestore) error {
+	if len(restore.Status.BlockedAppServers) > 0 {
+		for _, name := range restore.Status.BlockedAppServers {
+			s := &marklogicv1alpha1.MarkLogicAppServer{}
+			err := r.Get(ctx, types.NamespacedName{Namespace: restore.Namespace, Name: name}, s)
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if s.Annotations[marklogicv1alpha1.AnnotationBlockedByRestore] != restore.Name {
+				continue
+			}
+			delete(s.Annotations, marklogicv1alpha1.AnnotationBlockedByRestore)
+			if err := r.Update(ctx, s); err != nil {
+				return fmt.Errorf("unblocking MarkLogicAppServer %s: %w", name, err)
+			}
+		}
+		r.Recorder.Eventf(restore, corev1.EventTypeNormal, "TrafficUnblocked", "unblocked App Servers %s",
+			strings.Join(restore.Status.BlockedAppServers, ", "))
+		restore.Status.BlockedAppServers = nil
+		if err := r.Status().Update(ctx, restore); err != nil {
+			return err
+		}
+	}
+	if controllerutil.RemoveFinalizer(restore, restoreFinalizer) {
+		return r.Update(ctx, restore)
+	}
+	return nil
+}
+
+// finish records the outcome of the restore, then releases its App Servers.
+func (r *MarkLogicRestoreReconciler) finish(ctx context.Context, restore *marklogicv1alpha1.MarkLogicRestore) (ctrl.Result, error) {
+	if restore.Status.Phase == marklogicv1alpha1.RestoreCompleted {
+		r.setReconciled(restore, metav1.ConditionTrue, "Reconciled", "restore completed")
+	} else {
+		r.setReconciled(restore, metav1.ConditionFalse, "RestoreFailed", restore.Status.Message)
+	}
+	if err := r.Status().Update(ctx, restore); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, r.release(ctx, restore)
+}
+
+func (r *MarkLogicRestoreReconciler) fail(restore *marklogicv1alpha1.MarkLogicRestore, reason, message string) {
+	now := metav1.Now()
+	restore.Status.Phase = marklogicv1alpha1.RestoreFailed
+	restore.Status.CompletionTime = &now
+	restore.Status.Message = message
+	r.Recorder.Eventf(restore, corev1.EventTypeWarning, reason, "%s", message)
+}
+
+func (r *MarkLogicRestoreReconciler) setReconciled(rs *marklogicv1alpha1.MarkLogicRestore, status metav1.ConditionStatus, reason, message string) {
+	setCondition(&rs.Status.Conditions, rs.Generation, marklogicv1alpha1.ConditionReconciled, status, reason, message)
+}
+
+// restoreFinished reports whether the restore completed or failed.
+func restoreFinished(rs *marklogicv1alpha1.MarkLogicRestore) bool {
+	return rs.Status.Phase == marklogicv1alpha1.RestoreCompleted || rs.Status.Phase == marklogicv1alpha1.RestoreFailed
+}
+
+// restoresFor maps a MarkLogicCluster or MarkLogicBackup to the unfinished
+// restores referencing it.
+func (r *MarkLogicRestoreReconciler) restoresFor(ctx context.Context, obj client.Object) []ctrl.Request {
+	list := &marklogicv1alpha1.MarkLogicRestoreList{}
+	if err := r.List(ctx, list, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list MarkLogicRestores")
+		return nil
+	}
+	_, isBackup := obj.(*marklogicv1alpha1.MarkLogicBackup)
+	var requests []ctrl.Request
+	for _, rs := range list.Items {
+		if restoreFinished(&rs) {
+			continue
+		}
+		if isBackup && (rs.Spec.BackupRef == nil || rs.Spec.BackupRef.Name != obj.GetName()) {
+			continue
+		}
+		if !isBackup && rs.Spec.ClusterRef.Name != obj.GetName() {
+			continue
+		}
+		requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&rs)})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MarkLogicRestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&marklogicv1alpha1.MarkLogicRestore{}).
+		Watches(&marklogicv1alpha1.MarkLogicCluster{},
+			handler.EnqueueRequestsFromMapFunc(r.restoresFor),
+			builder.WithPredicates(clusterChanged)).
+		Watches(&marklogicv1alpha1.MarkLogicBackup{},
+			handler.EnqueueRequestsFromMapFunc(r.restoresFor)).
+		Complete(r)
+}
@@ -0,0 +1,266 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+func newRestore(name, cluster, backup string) *marklogicv1alpha1.MarkLogicRestore {
+	return &marklogicv1alpha1.MarkLogicRestore{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "marklogic", Generation: 1},
+		Spec: marklogicv1alpha1.MarkLogicRestoreSpec{
+			ClusterRef: marklogicv1alpha1.ClusterReference{Name: cluster},
+			BackupRef:  &marklogicv1alpha1.BackupReference{Name: backup},
+		},
+	}
+}
+
+func newRestoreReconciler(f *fakeMarkLogic, objs ...client.Object) (*MarkLogicRestoreReconciler, *record.FakeRecorder) {
+	s := testScheme()
+	c := fake.NewClientBuilder().
+		WithScheme(s).
+		WithObjects(objs...).
+		WithStatusSubresource(&marklogicv1alpha1.MarkLogicRestore{}, &marklogicv1alpha1.MarkLogicBackup{},
+			&marklogicv1alpha1.MarkLogicCluster{}).
+		Build()
+	recorder := record.NewFakeRecorder(10)
+	return &MarkLogicRestoreReconciler{Client: c, Scheme: s, Recorder: recorder, NewClient: f.newClient}, recorder
+}
+
+func reconcileRestore(t *testing.T, r *MarkLogicRestoreReconciler, name string) (ctrl.Result, *marklogicv1alpha1.MarkLogicRestore) {
+	t.Helper()
+	key := types.NamespacedName{Namespace: "marklogic", Name: name}
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	restore := &marklogicv1alpha1.MarkLogicRestore{}
+	require.NoError(t, r.Get(context.Background(), key, restore))
+	return result, restore
+}
+
+// restoreFixture returns a fake with the Documents database on two hosts,
+// its available cluster and a completed backup of the database.
+func restoreFixture(t *testing.T) (*fakeMarkLogic, []client.Object) {
+	f := newFakeMarkLogic(t)
+	f.put("databases", map[string]interface{}{"database-name": "Documents"})
+	f.put("forests", map[string]interface{}{"forest-name": "Documents-1", "database": "Documents", "host": "dnode-0"})
+	f.put("forests", map[string]interface{}{"forest-name": "Documents-2", "database": "Documents", "host": "dnode-1"})
+	cluster, secret := availableCluster(f, "dnode", 2, 2)
+	backup := newBackup("nightly", "dnode", "Documents")
+	backup.Status.Phase = marklogicv1alpha1.BackupCompleted
+	return f, []client.Object{cluster, secret, backup}
+}
+
+func blockedBy(t *testing.T, r *MarkLogicRestoreReconciler, server string) string {
+	t.Helper()
+	s := &marklogicv1alpha1.MarkLogicAppServer{}
+	require.NoError(t, r.Get(context.Background(), types.NamespacedName{Namespace: "marklogic", Name: server}, s))
+	return s.Annotations[marklogicv1alpha1.AnnotationBlockedByRestore]
+}
+
+func TestReconcileRestoreRunsJob(t *testing.T) {
+	f, objs := restoreFixture(t)
+	app := newAppServer("app", "dnode", 8010)
+	app.Spec.ContentDatabase = "Documents"
+	modules := newAppServer("modules", "dnode", 8011)
+	modules.Spec.ModulesDatabase = "Documents"
+	other := newAppServer("other", "dnode", 8012)
+	other.Spec.ContentDatabase = "Other"
+	restore := newRestore("rollback", "dnode", "nightly")
+	restore.Spec.RestoreToTime = &metav1.Time{Time: time.Date(2024, time.March, 1, 12, 30, 0, 0, time.UTC)}
+	r, recorder := newRestoreReconciler(f, append(objs, app, modules, other, restore)...)
+
+	result, restore := reconcileRestore(t, r, "rollback")
+	assert.Equal(t, backupPollInterval, result.RequeueAfter)
+	assert.Equal(t, marklogicv1alpha1.RestoreRestoring, restore.Status.Phase)
+	assert.Equal(t, "Documents", restore.Status.Database)
+	assert.Equal(t, "/backups", restore.Status.BackupDir)
+	assert.Equal(t, "1", restore.Status.JobID)
+	assert.Equal(t, []string{"app", "modules"}, restore.Status.BlockedAppServers)
+	assert.Contains(t, restore.Finalizers, restoreFinalizer)
+	assert.Equal(t, "rollback", blockedBy(t, r, "app"))
+	assert.Equal(t, "rollback", blockedBy(t, r, "modules"))
+	assert.Empty(t, blockedBy(t, r, "other"))
+	assert.Equal(t, []string{
+		"Normal TrafficBlocked blocked App Servers app, modules during the restore of database Documents",
+		"Normal RestoreStarted started restore job 1 of database Documents from /backups",
+	}, events(recorder))
+
+	_, restore = reconcileRestore(t, r, "rollback")
+	assert.Equal(t, []marklogicv1alpha1.HostRestoreStatus{
+		{Host: "dnode-0", Status: mlclient.JobInProgress, Forests: 1},
+		{Host: "dnode-1", Status: mlclient.JobInProgress, Forests: 1},
+	}, restore.Status.Hosts)
+
+	f.setJob("1", mlclient.JobCompleted)
+	result, restore = reconcileRestore(t, r, "rollback")
+	assert.Zero(t, result.RequeueAfter)
+	assert.Equal(t, marklogicv1alpha1.RestoreCompleted, restore.Status.Phase)
+	assert.NotNil(t, restore.Status.CompletionTime)
+	assert.Equal(t, int32(1), restore.Status.Hosts[1].CompletedForests)
+	assert.Empty(t, restore.Status.BlockedAppServers)
+	assert.NotContains(t, restore.Finalizers, restoreFinalizer)
+	assert.Empty(t, blockedBy(t, r, "app"))
+	assert.Empty(t, blockedBy(t, r, "modules"))
+	assert.Equal(t, []string{
+		"Normal RestoreCompleted restore job 1 of database Documents completed",
+		"Normal TrafficUnblocked unblocked App Servers app, modules",
+	}, events(recorder))
+}
+
+func TestReconcileRestoreWaitsForTrafficBlock(t *testing.T) {
+	f, objs := restoreFixture(t)
+	app := newAppServer("app", "dnode", 8010)
+	app.Spec.ContentDatabase = "Documents"
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "dnode-cluster", Namespace: "marklogic"},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http-8010", Port: 8010}}},
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "dnode-haproxy", Namespace: "marklogic"},
+		Data:       map[string]string{haproxyConfigKey: "  server ml-dnode-8010-0 dnode-0.dnode.marklogic.svc.cluster.local:8010\n"},
+	}
+	r, recorder := newRestoreReconciler(f, append(objs, app, svc, cm, newRestore("rollback", "dnode", "nightly"))...)
+	ctx := context.Background()
+
+	result, restore := reconcileRestore(t, r, "rollback")
+	assert.Equal(t, backupPollInterval, result.RequeueAfter)
+	assert.Equal(t, "rollback", blockedBy(t, r, "app"))
+	requireCondition(t, restore.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "TrafficNotBlocked")
+	assert.Empty(t, restore.Status.JobID)
+	assert.Empty(t, f.jobs)
+	assert.NotNil(t, restore.Status.ValidationTime)
+
+	// The backup is validated once, not on every requeue.
+	f.invalid["Documents-2"] = "missing label file"
+
+	// The cluster controller takes the port off the service first.
+	svc.Spec.Ports = nil
+	require.NoError(t, r.Update(ctx, svc))
+	_, restore = reconcileRestore(t, r, "rollback")
+	assert.Empty(t, restore.Status.JobID)
+
+	cm.Data[haproxyConfigKey] = ""
+	require.NoError(t, r.Update(ctx, cm))
+	_, restore = reconcileRestore(t, r, "rollback")
+	assert.Equal(t, marklogicv1alpha1.RestoreRestoring, restore.Status.Phase)
+	assert.Equal(t, []string{
+		"Normal TrafficBlocked blocked App Servers app during the restore of database Documents",
+		"Normal RestoreStarted started restore job 1 of database Documents from /backups",
+	}, events(recorder))
+}
+
+func TestReconcileRestoreRetriesUnavailableMarkLogic(t *testing.T) {
+	f, objs := restoreFixture(t)
+	r, _ := newRestoreReconciler(f, append(objs, newRestore("rollback", "dnode", "nightly"))...)
+	key := types.NamespacedName{Namespace: "marklogic", Name: "rollback"}
+	reconcile := func() *marklogicv1alpha1.MarkLogicRestore {
+		t.Helper()
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+		require.Error(t, err)
+		restore := &marklogicv1alpha1.MarkLogicRestore{}
+		require.NoError(t, r.Get(context.Background(), key, restore))
+		return restore
+	}
+
+	f.operationErrors["backup-validate"] = http.StatusServiceUnavailable
+	restore := reconcile()
+	assert.Equal(t, marklogicv1alpha1.RestoreValidating, restore.Status.Phase)
+	assert.Nil(t, restore.Status.ValidationTime)
+
+	delete(f.operationErrors, "backup-validate")
+	f.operationErrors["restore-database"] = http.StatusServiceUnavailable
+	restore = reconcile()
+	assert.Equal(t, marklogicv1alpha1.RestoreValidating, restore.Status.Phase)
+	assert.NotNil(t, restore.Status.ValidationTime)
+	requireCondition(t, restore.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "ReconcileError")
+
+	delete(f.operationErrors, "restore-database")
+	_, restore = reconcileRestore(t, r, "rollback")
+	assert.Equal(t, marklogicv1alpha1.RestoreRestoring, restore.Status.Phase)
+	assert.Contains(t, meta.FindStatusCondition(restore.Status.Conditions, marklogicv1alpha1.ConditionReconciled).Message,
+		"App Servers not managed by a MarkLogicAppServer, such as the default ones, are not blocked")
+
+	// A refused request fails the restore.
+	restore = newRestore("refused", "dnode", "nightly")
+	require.NoError(t, r.Create(context.Background(), restore))
+	f.operationErrors["restore-database"] = http.StatusBadRequest
+	_, restore = reconcileRestore(t, r, "refused")
+	assert.Equal(t, marklogicv1alpha1.RestoreFailed, restore.Status.Phase)
+}
+
+func TestReconcileRestoreRejectsInvalidBackup(t *testing.T) {
+	f, objs := restoreFixture(t)
+	f.invalid["Documents-2"] = "missing label file"
+	app := newAppServer("app", "dnode", 8010)
+	app.Spec.ContentDatabase = "Documents"
+	r, recorder := newRestoreReconciler(f, append(objs, app, newRestore("rollback", "dnode", "nightly"))...)
+
+	_, restore := reconcileRestore(t, r, "rollback")
+	assert.Equal(t, marklogicv1alpha1.RestoreFailed, restore.Status.Phase)
+	assert.Equal(t, "backup of database Documents in /backups failed validation: forest Documents-2: failed missing label file",
+		restore.Status.Message)
+	requireCondition(t, restore.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "RestoreFailed")
+	assert.Empty(t, blockedBy(t, r, "app"), "traffic is not blocked for a restore that never starts")
+	assert.Empty(t, f.jobs)
+	require.Len(t, events(recorder), 1)
+}
+
+func TestReconcileRestoreWaitsForBackup(t *testing.T) {
+	f, objs := restoreFixture(t)
+	running := newBackup("running", "dnode", "Documents")
+	running.Status.Phase = marklogicv1alpha1.BackupRunning
+	r, _ := newRestoreReconciler(f, append(objs, running, newRestore("early", "dnode", "running"),
+		newRestore("orphan", "dnode", "missing"))...)
+
+	_, restore := reconcileRestore(t, r, "early")
+	assert.Equal(t, marklogicv1alpha1.RestorePending, restore.Status.Phase)
+	requireCondition(t, restore.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "BackupNotCompleted")
+
+	_, restore = reconcileRestore(t, r, "orphan")
+	requireCondition(t, restore.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "BackupNotFound")
+	assert.Empty(t, f.jobs)
+
+	running.Status.Phase = marklogicv1alpha1.BackupFailed
+	require.NoError(t, r.Status().Update(context.Background(), running))
+	_, restore = reconcileRestore(t, r, "early")
+	assert.Equal(t, marklogicv1alpha1.RestoreFailed, restore.Status.Phase)
+	assert.Equal(t, "MarkLogicBackup running failed", restore.Status.Message)
+}
+
+func TestReconcileRestoreDeletedWhileRunning(t *testing.T) {
+	f, objs := restoreFixture(t)
+	app := newAppServer("app", "dnode", 8010)
+	app.Spec.ContentDatabase = "Documents"
+	restore := newRestore("rollback", "dnode", "")
+	restore.Spec.BackupRef = nil
+	restore.Spec.Database = "Documents"
+	restore.Spec.BackupDir = "/imported"
+	r, _ := newRestoreReconciler(f, append(objs, app, restore)...)
+	ctx := context.Background()
+
+	_, restore = reconcileRestore(t, r, "rollback")
+	assert.Equal(t, "/imported", restore.Status.BackupDir)
+	assert.Equal(t, "rollback", blockedBy(t, r, "app"))
+
+	require.NoError(t, r.Delete(ctx, restore))
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(restore)})
+	require.NoError(t, err)
+	assert.Empty(t, blockedBy(t, r, "app"))
+	assert.Error(t, r.Get(ctx, client.ObjectKeyFromObject(restore), &marklogicv1alpha1.MarkLogicRestore{}))
+}
@@ -0,0 +1,275 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// roleFinalizer lets the operator delete the role from MarkLogic before the
+// resource goes away.
+const roleFinalizer = "marklogic.com/role"
+
+// MarkLogicRoleReconciler reconciles a MarkLogicRole object.
+type MarkLogicRoleReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// NewClient creates the MarkLogic clients. Defaults to mlclient.New.
+	NewClient mlclient.Factory
+}
+
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicroles,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicroles/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicroles/finalizers,verbs=update
+
+// Reconcile creates the role in the MarkLogic cluster and keeps its
+// properties in line with the spec.
+func (r *MarkLogicRoleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	role := &marklogicv1alpha1.MarkLogicRole{}
+	if err := r.Get(ctx, req.NamespacedName, role); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	desired := role.DeepCopy()
+	desired.Default()
+
+	if !role.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, role, desired)
+	}
+	if controllerutil.AddFinalizer(role, roleFinalizer) {
+		if err := r.Update(ctx, role); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	props, err := roleProperties(desired)
+	if err != nil {
+		logger.Info("invalid MarkLogicRole spec", "reason", err.Error())
+		r.setReconciled(role, metav1.ConditionFalse, "InvalidSpec", err.Error())
+		return ctrl.Result{}, r.Status().Update(ctx, role)
+	}
+
+	cluster, err := clusterFor(ctx, r.Client, role.Namespace, desired.Spec.ClusterRef)
+	if apierrors.IsNotFound(err) {
+		r.setReconciled(role, metav1.ConditionFalse, "ClusterNotFound", fmt.Sprintf("MarkLogicCluster %s not found", desired.Spec.ClusterRef.Name))
+		return ctrl.Result{}, r.Status().Update(ctx, role)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	mc, err := clusterClient(ctx, r.Client, r.NewClient, cluster)
+	if errors.Is(err, errClusterNotReady) {
+		r.setReconciled(role, metav1.ConditionFalse, "ClusterNotReady", fmt.Sprintf("waiting for MarkLogicCluster %s to become available", cluster.Name))
+		return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, role)
+	}
+	if errors.Is(err, errCredentialsUnavailable) {
+		r.setReconciled(role, metav1.ConditionFalse, "CredentialsUnavailable", err.Error())
+		return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, role)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileRole(ctx, mc, role, desired, props); err != nil {
+		logger.Error(err, "failed to reconcile role", "role", desired.Spec.RoleName)
+		r.setReconciled(role, metav1.ConditionFalse, "ReconcileError", err.Error())
+		if statusErr := r.Status().Update(ctx, role); statusErr != nil {
+			logger.Error(statusErr, "failed to update MarkLogicRole status")
+		}
+		return ctrl.Result{}, err
+	}
+	role.Status.ObservedGeneration = role.Generation
+	r.setReconciled(role, metav1.ConditionTrue, "Reconciled", "role is up to date")
+	return ctrl.Result{RequeueAfter: driftCheckInterval}, r.Status().Update(ctx, role)
+}
+
+// reconcileRole applies the desired role properties and records the outcome
+// in the status of role.
+func (r *MarkLogicRoleReconciler) reconcileRole(ctx context.Context, mc *mlclient.Client,
+	role, desired *marklogicv1alpha1.MarkLogicRole, props map[string]interface{}) error {
+	name := desired.Spec.RoleName
+
+	current, err := mc.RoleProperties(ctx, name)
+	switch {
+	case mlclient.IsNotFound(err):
+		log.FromContext(ctx).Info("creating role", "role", name)
+		if err := mc.CreateRole(ctx, props); err != nil {
+			return fmt.Errorf("creating role %s: %w", name, err)
+		}
+		role.Status.Drift = nil
+		r.setInSync(role, metav1.ConditionTrue, "Created", "role created")
+	case err != nil:
+		return fmt.Errorf("reading role %s: %w", name, err)
+	default:
+		result, err := syncProperties(ctx, "role", props, current, role.Generation != role.Status.ObservedGeneration, desired.Spec.DriftPolicy,
+			func(update map[string]interface{}) error {
+				if err := mc.UpdateRoleProperties(ctx, name, update); err != nil {
+					return fmt.Errorf("updating role %s: %w", name, err)
+				}
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+		role.Status.Drift = result.Drift
+		r.setInSync(role, result.Status, result.Reason, result.Message)
+	}
+	now := metav1.Now()
+	role.Status.LastDriftCheck = &now
+	role.Status.RoleName = name
+	return nil
+}
+
+// finalize deletes the role from MarkLogic when the deletion policy asks for
+// it, then releases the resource.
+func (r *MarkLogicRoleReconciler) finalize(ctx context.Context, role, desired *marklogicv1alpha1.MarkLogicRole) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(role, roleFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	if desired.Spec.DeletionPolicy == marklogicv1alpha1.DeletionPolicyDelete {
+		cluster, err := clusterFor(ctx, r.Client, role.Namespace, desired.Spec.ClusterRef)
+		switch {
+		case apierrors.IsNotFound(err):
+			// Nothing is left to clean up with the cluster gone.
+		case err != nil:
+			return ctrl.Result{}, err
+		case cluster.DeletionTimestamp.IsZero():
+			mc, err := clusterClient(ctx, r.Client, r.NewClient, cluster)
+			if errors.Is(err, errClusterNotReady) {
+				r.setReconciled(role, metav1.ConditionFalse, "ClusterNotReady", fmt.Sprintf("waiting for MarkLogicCluster %s to become available", cluster.Name))
+				return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, role)
+			}
+			if errors.Is(err, errCredentialsUnavailable) {
+				r.setReconciled(role, metav1.ConditionFalse, "CredentialsUnavailable", err.Error())
+				return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, role)
+			}
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			log.FromContext(ctx).Info("deleting role", "role", desired.Spec.RoleName)
+			if err := mc.DeleteRole(ctx, desired.Spec.RoleName); err != nil {
+				return ctrl.Result{}, fmt.Errorf("deleting role %s: %w", desired.Spec.RoleName, err)
+			}
+		}
+	}
+	controllerutil.RemoveFinalizer(role, roleFinalizer)
+	return ctrl.Result{}, r.Update(ctx, role)
+}
+
+func (r *MarkLogicRoleReconciler) setReconciled(role *marklogicv1alpha1.MarkLogicRole, status metav1.ConditionStatus, reason, message string) {
+	setCondition(&role.Status.Conditions, role.Generation, marklogicv1alpha1.ConditionReconciled, status, reason, message)
+}
+
+func (r *MarkLogicRoleReconciler) setInSync(role *marklogicv1alpha1.MarkLogicRole, status metav1.ConditionStatus, reason, message string) {
+	setCondition(&role.Status.Conditions, role.Generation, marklogicv1alpha1.ConditionInSync, status, reason, message)
+}
+
+// permissionSpec is the Management API form of a Permission.
+type permissionSpec struct {
+	RoleName   string `json:"role-name"`
+	Capability string `json:"capability"`
+}
+
+// permissionSpecs converts permissions, returning an empty list rather than
+// nil so that removing the last permission clears them in MarkLogic.
+func permissionSpecs(permissions []marklogicv1alpha1.Permission) []permissionSpec {
+	specs := []permissionSpec{}
+	for _, p := range permissions {
+		specs = append(specs, permissionSpec{RoleName: p.RoleName, Capability: p.Capability})
+	}
+	return specs
+}
+
+// nonNil returns list, or an empty list when it is nil.
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+// roleSpec is the Management API form of the typed fields of the spec.
+type roleSpec struct {
+	RoleName      string           `json:"role-name"`
+	Description   string           `json:"description,omitempty"`
+	Compartment   string           `json:"compartment,omitempty"`
+	ExternalNames []string         `json:"external-name"`
+	Roles         []string         `json:"role"`
+	Privileges    []privilegeSpec  `json:"privilege"`
+	Permissions   []permissionSpec `json:"permission"`
+	Collections   []string         `json:"collection"`
+}
+
+type privilegeSpec struct {
+	PrivilegeName string `json:"privilege-name"`
+	Action        string `json:"action"`
+	Kind          string `json:"kind"`
+}
+
+// roleProperties returns the desired properties of a defaulted role.
+func roleProperties(role *marklogicv1alpha1.MarkLogicRole) (map[string]interface{}, error) {
+	s := role.Spec
+	spec := roleSpec{
+		RoleName:      s.RoleName,
+		Description:   s.Description,
+		Compartment:   s.Compartment,
+		ExternalNames: nonNil(s.ExternalNames),
+		Roles:         nonNil(s.Roles),
+		Privileges:    []privilegeSpec{},
+		Permissions:   permissionSpecs(s.Permissions),
+		Collections:   nonNil(s.Collections),
+	}
+	for _, p := range s.Privileges {
+		spec.Privileges = append(spec.Privileges, privilegeSpec{PrivilegeName: p.PrivilegeName, Action: p.Action, Kind: p.Kind})
+	}
+	props, err := toProperties(spec)
+	if err != nil {
+		return nil, err
+	}
+	if s.Properties != nil {
+		if err := mergeRawProperties(props, s.Properties.Raw); err != nil {
+			return nil, fmt.Errorf("spec.properties: %w", err)
+		}
+	}
+	return props, nil
+}
+
+// rolesForCluster maps a MarkLogicCluster to the roles it holds.
+func (r *MarkLogicRoleReconciler) rolesForCluster(ctx context.Context, obj client.Object) []ctrl.Request {
+	list := &marklogicv1alpha1.MarkLogicRoleList{}
+	if err := r.List(ctx, list, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list MarkLogicRoles")
+		return nil
+	}
+	var requests []ctrl.Request
+	for _, role := range list.Items {
+		if role.Spec.ClusterRef.Name == obj.GetName() {
+			requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&role)})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MarkLogicRoleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&marklogicv1alpha1.MarkLogicRole{}).
+		Watches(&marklogicv1alpha1.MarkLogicCluster{},
+			handler.EnqueueRequestsFromMapFunc(r.rolesForCluster),
+			builder.WithPredicates(clusterChanged)).
+		Complete(r)
+}
@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+func newRole(name, cluster string) *marklogicv1alpha1.MarkLogicRole {
+	return &marklogicv1alpha1.MarkLogicRole{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "marklogic", Generation: 1},
+		Spec: marklogicv1alpha1.MarkLogicRoleSpec{
+			ClusterRef: marklogicv1alpha1.ClusterReference{Name: cluster},
+		},
+	}
+}
+
+func newRoleReconciler(f *fakeMarkLogic, objs ...client.Object) *MarkLogicRoleReconciler {
+	s := testScheme()
+	c := fake.NewClientBuilder().
+		WithScheme(s).
+		WithObjects(objs...).
+		WithStatusSubresource(&marklogicv1alpha1.MarkLogicRole{}, &marklogicv1alpha1.MarkLogicCluster{}).
+		Build()
+	return &MarkLogicRoleReconciler{Client: c, Scheme: s, NewClient: f.newClient}
+}
+
+func reconcileRole(t *testing.T, r *MarkLogicRoleReconciler, name string) (ctrl.Result, *marklogicv1alpha1.MarkLogicRole) {
+	t.Helper()
+	key := types.NamespacedName{Namespace: "marklogic", Name: name}
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	role := &marklogicv1alpha1.MarkLogicRole{}
+	require.NoError(t, r.Get(context.Background(), key, role))
+	return result, role
+}
+
+func TestReconcileRoleCreatesRole(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	cluster, secret := availableCluster(f, "dnode", 1, 1)
+	role := newRole("app-writer", "dnode")
+	role.Spec.Roles = []string{"rest-writer"}
+	role.Spec.Privileges = []marklogicv1alpha1.RolePrivilege{{PrivilegeName: "any-uri", Action: "http://marklogic.com/xdmp/privileges/any-uri"}}
+	role.Spec.Permissions = []marklogicv1alpha1.Permission{{RoleName: "app-writer", Capability: "update"}}
+	role.Spec.Properties = &runtime.RawExtension{Raw: []byte(`{"queries": {"query": []}, "role-name": "ignored"}`)}
+	r := newRoleReconciler(f, cluster, secret, role)
+
+	result, role := reconcileRole(t, r, "app-writer")
+	props := f.get("roles", "app-writer")
+	require.NotNil(t, props)
+	assert.Equal(t, []interface{}{"rest-writer"}, props["role"])
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"privilege-name": "any-uri", "action": "http://marklogic.com/xdmp/privileges/any-uri", "kind": "execute",
+	}}, props["privilege"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"role-name": "app-writer", "capability": "update"}}, props["permission"])
+	assert.NotNil(t, props["queries"])
+
+	assert.Equal(t, driftCheckInterval, result.RequeueAfter)
+	assert.Equal(t, []string{roleFinalizer}, role.Finalizers)
+	assert.Equal(t, "app-writer", role.Status.RoleName)
+	requireCondition(t, role.Status.Conditions, marklogicv1alpha1.ConditionInSync, metav1.ConditionTrue, "Created")
+
+	// Changes made outside of the operator are only reported with the Report policy.
+	role.Spec.DriftPolicy = marklogicv1alpha1.DriftPolicyReport
+	role.Generation++
+	require.NoError(t, r.Update(context.Background(), role))
+	_, role = reconcileRole(t, r, "app-writer")
+	props["role"] = []interface{}{"rest-writer", "admin"}
+	_, role = reconcileRole(t, r, "app-writer")
+	assert.Equal(t, []string{"role"}, role.Status.Drift)
+	assert.Equal(t, []interface{}{"rest-writer", "admin"}, props["role"])
+	requireCondition(t, role.Status.Conditions, marklogicv1alpha1.ConditionInSync, metav1.ConditionFalse, "Drifted")
+
+	// Removing the last inherited role clears them.
+	role.Spec.Roles = nil
+	role.Generation++
+	require.NoError(t, r.Update(context.Background(), role))
+	_, role = reconcileRole(t, r, "app-writer")
+	assert.Equal(t, []interface{}{}, props["role"])
+	requireCondition(t, role.Status.Conditions, marklogicv1alpha1.ConditionInSync, metav1.ConditionTrue, "Updated")
+}
+
+func TestReconcileRoleDeletion(t *testing.T) {
+	for _, policy := range []marklogicv1alpha1.DeletionPolicy{marklogicv1alpha1.DeletionPolicyRetain, marklogicv1alpha1.DeletionPolicyDelete} {
+		t.Run(string(policy), func(t *testing.T) {
+			f := newFakeMarkLogic(t)
+			cluster, secret := availableCluster(f, "dnode", 1, 1)
+			role := newRole("app-reader", "dnode")
+			role.Spec.DeletionPolicy = policy
+			r := newRoleReconciler(f, cluster, secret, role)
+			_, role = reconcileRole(t, r, "app-reader")
+
+			require.NoError(t, r.Delete(context.Background(), role))
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(role)})
+			require.NoError(t, err)
+			require.Error(t, r.Get(context.Background(), client.ObjectKeyFromObject(role), role), "finalizer is removed")
+			assert.Equal(t, policy == marklogicv1alpha1.DeletionPolicyDelete, f.get("roles", "app-reader") == nil)
+		})
+	}
+}
@@ -0,0 +1,428 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// scaleDownPollInterval is how often a managed scale down checks on the
+// forests being evacuated.
+const scaleDownPollInterval = 15 * time.Second
+
+// MarkLogicScaleDownReconciler lets hosts leave a MarkLogicCluster whose
+// replicaCount was lowered. It retires the forests of the hosts above the new
+// count so the rebalancer moves their documents to the remaining hosts,
+// deletes the emptied forests and removes the hosts from the MarkLogic
+// cluster, highest ordinal first. The MarkLogicCluster controller keeps each
+// pod until its host is removed, see replicasDuringScaleDown.
+type MarkLogicScaleDownReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// NewClient creates the MarkLogic clients. Defaults to mlclient.New.
+	NewClient mlclient.Factory
+}
+
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicclusters,verbs=get;list;watch
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicclusters/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile moves the managed scale down of a MarkLogicCluster one step forward.
+func (r *MarkLogicScaleDownReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	cluster := &marklogicv1alpha1.MarkLogicCluster{}
+	if err := r.Get(ctx, req.NamespacedName, cluster); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !cluster.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+	desired := cluster.DeepCopy()
+	desired.Default()
+	if !*desired.Spec.ScaleDown.Managed {
+		return ctrl.Result{}, nil
+	}
+	sts := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: cluster.Namespace, Name: cluster.Name}, sts); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	current, target := *sts.Spec.Replicas, *desired.Spec.ReplicaCount
+	sd := cluster.Status.ScaleDown
+	active := sd != nil && sd.Phase != marklogicv1alpha1.ScaleDownCompleted
+	if target == 0 || (!active && current <= target) {
+		return ctrl.Result{}, nil
+	}
+	if !active {
+		sd = &marklogicv1alpha1.ScaleDownStatus{
+			Phase:        marklogicv1alpha1.ScaleDownEvacuating,
+			FromReplicas: current,
+			StartTime:    &metav1.Time{Time: time.Now()},
+		}
+		cluster.Status.ScaleDown = sd
+		r.Recorder.Eventf(cluster, corev1.EventTypeNormal, "ScaleDownStarted", "evacuating hosts before scaling from %d to %d pods", current, target)
+	}
+	sd.ToReplicas = target
+
+	result, err := r.scaleDown(ctx, cluster, desired, sts)
+	r.reportBlocked(cluster)
+	switch {
+	case errors.Is(err, errClusterNotReady), errors.Is(err, errCredentialsUnavailable):
+		return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, cluster)
+	case mlclient.StatusCode(err) != 0:
+		// MarkLogic refused a step, e.g. deleting a forest that is still a
+		// replica. Report it and try again later.
+		if sd.Message != err.Error() {
+			r.Recorder.Eventf(cluster, corev1.EventTypeWarning, "ScaleDownFailed", "%v", err)
+		}
+		sd.Phase, sd.Message = marklogicv1alpha1.ScaleDownFailed, err.Error()
+		return ctrl.Result{RequeueAfter: scaleDownPollInterval}, r.Status().Update(ctx, cluster)
+	case err != nil:
+		logger.Error(err, "failed to scale down MarkLogic cluster")
+		if statusErr := r.Status().Update(ctx, cluster); statusErr != nil {
+			logger.Error(statusErr, "failed to update MarkLogicCluster status")
+		}
+		return ctrl.Result{}, err
+	}
+	return result, r.Status().Update(ctx, cluster)
+}
+
+// scaleDown evacuates and removes the hosts above the target count, then
+// waits for their pods to go and deletes their volumes.
+func (r *MarkLogicScaleDownReconciler) scaleDown(ctx context.Context, cluster, desired *marklogicv1alpha1.MarkLogicCluster,
+	sts *appsv1.StatefulSet) (ctrl.Result, error) {
+	sd := cluster.Status.ScaleDown
+	current, target := *sts.Spec.Replicas, sd.ToReplicas
+
+	leaving := map[string]bool{}
+	for i := target; i < current; i++ {
+		leaving[podName(desired, int(i))] = true
+	}
+	pending := false
+	for _, h := range sd.Hosts {
+		pending = pending || (!leaving[h.Pod] && h.Phase != marklogicv1alpha1.HostRemoved)
+		delete(leaving, h.Pod)
+	}
+	for pod := range leaving {
+		sd.Hosts = append(sd.Hosts, marklogicv1alpha1.LeavingHostStatus{Pod: pod, Phase: marklogicv1alpha1.HostEvacuating})
+	}
+	sort.Slice(sd.Hosts, func(i, j int) bool { return podOrdinal(sd.Hosts[i].Pod) > podOrdinal(sd.Hosts[j].Pod) })
+
+	if len(leaving) > 0 || pending || hostsLeft(sd) {
+		mc, err := clusterClient(ctx, r.Client, r.NewClient, cluster)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if pending {
+			if err := r.cancel(ctx, mc, cluster, target); err != nil {
+				return ctrl.Result{}, err
+			}
+			if len(sd.Hosts) == 0 {
+				sd.Phase = marklogicv1alpha1.ScaleDownCompleted
+				sd.CompletionTime = &metav1.Time{Time: time.Now()}
+				return ctrl.Result{}, nil
+			}
+		}
+		for i := range sd.Hosts {
+			if sd.Hosts[i].Phase == marklogicv1alpha1.HostEvacuating {
+				if err := r.evacuate(ctx, mc, desired, &sd.Hosts[i]); err != nil {
+					return ctrl.Result{}, err
+				}
+			}
+		}
+		// Hosts leave highest ordinal first, so the StatefulSet can shrink
+		// past every removed host.
+		for i := range sd.Hosts {
+			h := &sd.Hosts[i]
+			if h.Phase == marklogicv1alpha1.HostRemoved {
+				continue
+			}
+			if h.Phase != marklogicv1alpha1.HostRemoving {
+				break
+			}
+			host := hostFQDN(desired, podOrdinal(h.Pod))
+			log.FromContext(ctx).Info("removing host from MarkLogic cluster", "host", host)
+			if _, err := mc.RemoveHost(ctx, host); err != nil {
+				return ctrl.Result{}, fmt.Errorf("removing host %s: %w", host, err)
+			}
+			h.Phase = marklogicv1alpha1.HostRemoved
+			r.Recorder.Eventf(cluster, corev1.EventTypeNormal, "HostRemoved", "removed host %s from the MarkLogic cluster", host)
+			// The cluster restarts to apply the change; carry on with the
+			// next host once it is back.
+			return ctrl.Result{RequeueAfter: scaleDownPollInterval}, nil
+		}
+		if hostsLeft(sd) {
+			sd.Phase, sd.Message = marklogicv1alpha1.ScaleDownEvacuating, ""
+			return ctrl.Result{RequeueAfter: scaleDownPollInterval}, nil
+		}
+	}
+
+	sd.Phase, sd.Message = marklogicv1alpha1.ScaleDownShrinking, ""
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(cluster.Namespace), client.MatchingLabels(selectorLabels(desired))); err != nil {
+		return ctrl.Result{}, fmt.Errorf("listing pods: %w", err)
+	}
+	for _, pod := range pods.Items {
+		if int32(podOrdinal(pod.Name)) >= target {
+			return ctrl.Result{RequeueAfter: scaleDownPollInterval}, nil
+		}
+	}
+	if current > target {
+		return ctrl.Result{RequeueAfter: scaleDownPollInterval}, nil
+	}
+	if *desired.Spec.ScaleDown.DeleteVolumes {
+		if err := r.deleteVolumes(ctx, cluster, sts, sd); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	sd.Phase = marklogicv1alpha1.ScaleDownCompleted
+	sd.CompletionTime = &metav1.Time{Time: time.Now()}
+	r.Recorder.Eventf(cluster, corev1.EventTypeNormal, "ScaleDownCompleted", "scaled down from %d to %d pods", sd.FromReplicas, target)
+	return ctrl.Result{}, nil
+}
+
+// evacuate retires the forests of the host, and deletes the ones emptied by
+// the rebalancer. Local-disk replicas in sync with their master forest are
+// detached and deleted right away. Other forests attached to no database may
+// hold the only copy of their data and are kept as blocking forests. The host
+// moves on to removal once it holds no forests.
+func (r *MarkLogicScaleDownReconciler) evacuate(ctx context.Context, mc *mlclient.Client, c *marklogicv1alpha1.MarkLogicCluster,
+	h *marklogicv1alpha1.LeavingHostStatus) error {
+	host := hostFQDN(c, podOrdinal(h.Pod))
+	forests, err := mc.HostForests(ctx, host)
+	if err != nil {
+		return fmt.Errorf("listing forests of host %s: %w", host, err)
+	}
+	seen := map[string]bool{}
+	for _, f := range h.Forests {
+		seen[f] = true
+	}
+	var left, blocking []string
+	var documents int64
+	for _, name := range forests {
+		props, err := mc.ForestProperties(ctx, name)
+		if err != nil {
+			return fmt.Errorf("reading forest %s: %w", name, err)
+		}
+		if props.Database != "" {
+			if !seen[name] {
+				log.FromContext(ctx).Info("retiring forest", "forest", name, "database", props.Database)
+				if err := mc.RetireForest(ctx, name); err != nil {
+					return fmt.Errorf("retiring forest %s: %w", name, err)
+				}
+			}
+			count, err := mc.ForestDocumentCount(ctx, name)
+			if err != nil {
+				return fmt.Errorf("counting documents of forest %s: %w", name, err)
+			}
+			if count > 0 {
+				left = append(left, name)
+				documents += count
+				continue
+			}
+			if err := mc.DetachForest(ctx, name); err != nil {
+				return fmt.Errorf("detaching forest %s: %w", name, err)
+			}
+		} else {
+			replica, err := r.detachReplica(ctx, mc, c, h, name)
+			if err != nil {
+				return err
+			}
+			if !replica {
+				blocking = append(blocking, name)
+				continue
+			}
+		}
+		log.FromContext(ctx).Info("deleting forest", "forest", name, "host", host)
+		if err := mc.DeleteForest(ctx, name); err != nil {
+			return fmt.Errorf("deleting forest %s: %w", name, err)
+		}
+	}
+	h.Forests, h.Documents, h.BlockingForests = left, documents, blocking
+	if len(left) == 0 && len(blocking) == 0 {
+		h.Phase = marklogicv1alpha1.HostRemoving
+	}
+	return nil
+}
+
+// detachReplica removes the named forest, attached to no database, from the
+// replicas of its master forest if it is a local-disk replica in sync with
+// it, records that the master lost that failover copy and reports whether the
+// forest can be deleted. A replica acting as master after a failover is not
+// in sync replicating state and is kept.
+func (r *MarkLogicScaleDownReconciler) detachReplica(ctx context.Context, mc *mlclient.Client, c *marklogicv1alpha1.MarkLogicCluster,
+	h *marklogicv1alpha1.LeavingHostStatus, name string) (bool, error) {
+	for _, detached := range h.DetachedReplicas {
+		if detached == name {
+			// Detached by an earlier reconcile that failed to delete it.
+			return true, nil
+		}
+	}
+	state, err := mc.ForestState(ctx, name)
+	if err != nil {
+		return false, fmt.Errorf("reading state of forest %s: %w", name, err)
+	}
+	if state != mlclient.ForestSyncReplicating {
+		return false, nil
+	}
+	forests, err := mc.Forests(ctx)
+	if err != nil {
+		return false, fmt.Errorf("listing forests: %w", err)
+	}
+	for _, master := range forests {
+		props, err := mc.ForestProperties(ctx, master)
+		if err != nil {
+			return false, fmt.Errorf("reading forest %s: %w", master, err)
+		}
+		var replicas []mlclient.ForestReplica
+		for _, replica := range props.Replicas {
+			if replica.ReplicaName != name {
+				replicas = append(replicas, replica)
+			}
+		}
+		if len(replicas) == len(props.Replicas) {
+			continue
+		}
+		log.FromContext(ctx).Info("detaching replica forest", "forest", name, "master", master)
+		if err := mc.SetForestReplicas(ctx, master, replicas); err != nil {
+			return false, fmt.Errorf("detaching replica %s from forest %s: %w", name, master, err)
+		}
+		h.DetachedReplicas = append(h.DetachedReplicas, name)
+		r.Recorder.Eventf(c, corev1.EventTypeWarning, "ReplicaDetached", "detached replica forest %s from forest %s before deleting it", name, master)
+		return true, nil
+	}
+	return false, nil
+}
+
+// reportBlocked sets the ScaleDownBlocked condition of the cluster from the
+// blocking forests of the hosts still evacuating.
+func (r *MarkLogicScaleDownReconciler) reportBlocked(cluster *marklogicv1alpha1.MarkLogicCluster) {
+	var blocked []string
+	for _, h := range cluster.Status.ScaleDown.Hosts {
+		if h.Phase == marklogicv1alpha1.HostEvacuating && len(h.BlockingForests) > 0 {
+			blocked = append(blocked, fmt.Sprintf("%s (%s)", strings.Join(h.BlockingForests, ", "), h.Pod))
+		}
+	}
+	if len(blocked) == 0 {
+		if meta.FindStatusCondition(cluster.Status.Conditions, marklogicv1alpha1.ConditionScaleDownBlocked) != nil {
+			setCondition(&cluster.Status.Conditions, cluster.Generation, marklogicv1alpha1.ConditionScaleDownBlocked,
+				metav1.ConditionFalse, "NoBlockingForests", "")
+		}
+		return
+	}
+	message := fmt.Sprintf("forests %s are attached to no database and are not synchronized replicas; "+
+		"move or delete them so their hosts can leave", strings.Join(blocked, "; "))
+	if c := meta.FindStatusCondition(cluster.Status.Conditions, marklogicv1alpha1.ConditionScaleDownBlocked); c == nil || c.Message != message {
+		r.Recorder.Eventf(cluster, corev1.EventTypeWarning, "ScaleDownBlocked", "%s", message)
+	}
+	setCondition(&cluster.Status.Conditions, cluster.Generation, marklogicv1alpha1.ConditionScaleDownBlocked,
+		metav1.ConditionTrue, "UnattachedForests", message)
+}
+
+// cancel takes the forests of hosts that no longer leave, because
+// replicaCount was raised again, back into service.
+func (r *MarkLogicScaleDownReconciler) cancel(ctx context.Context, mc *mlclient.Client, cluster *marklogicv1alpha1.MarkLogicCluster, target int32) error {
+	sd := cluster.Status.ScaleDown
+	var kept []marklogicv1alpha1.LeavingHostStatus
+	var staying []string
+	for _, h := range sd.Hosts {
+		if int32(podOrdinal(h.Pod)) >= target || h.Phase == marklogicv1alpha1.HostRemoved {
+			kept = append(kept, h)
+			continue
+		}
+		for _, name := range h.Forests {
+			if err := mc.EmployForest(ctx, name); err != nil && !mlclient.IsNotFound(err) {
+				return fmt.Errorf("employing forest %s: %w", name, err)
+			}
+		}
+		staying = append(staying, h.Pod)
+	}
+	sd.Hosts = kept
+	if len(staying) == 0 {
+		return nil
+	}
+	r.Recorder.Eventf(cluster, corev1.EventTypeNormal, "ScaleDownCancelled", "hosts %s stay in the cluster", strings.Join(staying, ", "))
+	return nil
+}
+
+// deleteVolumes deletes the claims of the volume claim templates of the
+// removed pods, unless the StatefulSet grew back over them.
+func (r *MarkLogicScaleDownReconciler) deleteVolumes(ctx context.Context, cluster *marklogicv1alpha1.MarkLogicCluster,
+	sts *appsv1.StatefulSet, sd *marklogicv1alpha1.ScaleDownStatus) error {
+	for _, h := range sd.Hosts {
+		if h.Phase != marklogicv1alpha1.HostRemoved || int32(podOrdinal(h.Pod)) < sd.ToReplicas {
+			continue
+		}
+		for _, tmpl := range sts.Spec.VolumeClaimTemplates {
+			pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: tmpl.Name + "-" + h.Pod, Namespace: cluster.Namespace}}
+			if err := r.Delete(ctx, pvc); err != nil && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("deleting volume claim %s: %w", pvc.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// hostsLeft reports whether hosts of the scale down are still in the MarkLogic cluster.
+func hostsLeft(sd *marklogicv1alpha1.ScaleDownStatus) bool {
+	for _, h := range sd.Hosts {
+		if h.Phase != marklogicv1alpha1.HostRemoved {
+			return true
+		}
+	}
+	return false
+}
+
+// replicasDuringScaleDown returns the size of the StatefulSet of the defaulted
+// cluster, currently at current pods. During a managed scale down every pod
+// stays until its host, and the hosts above it, left the MarkLogic cluster.
+func replicasDuringScaleDown(c *marklogicv1alpha1.MarkLogicCluster, current int32) int32 {
+	target := *c.Spec.ReplicaCount
+	if !*c.Spec.ScaleDown.Managed || target == 0 || current <= target {
+		return target
+	}
+	removed := map[string]bool{}
+	if sd := c.Status.ScaleDown; sd != nil {
+		for _, h := range sd.Hosts {
+			removed[h.Pod] = h.Phase == marklogicv1alpha1.HostRemoved
+		}
+	}
+	n := current
+	for n > target && removed[podName(c, int(n-1))] {
+		n--
+	}
+	return n
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MarkLogicScaleDownReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("marklogicscaledown").
+		For(&marklogicv1alpha1.MarkLogicCluster{}).
+		Owns(&appsv1.StatefulSet{}).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(podCluster)).
+		Complete(r)
+}
@@ -0,0 +1,245 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+type scaleDownTest struct {
+	r        *MarkLogicScaleDownReconciler
+	recorder *record.FakeRecorder
+}
+
+// newScaleDownTest returns a cluster of three pods, with their volumes, whose
+// replicaCount was lowered to replicas.
+func newScaleDownTest(t *testing.T, f *fakeMarkLogic, replicas int32) *scaleDownTest {
+	cluster, secret := availableCluster(f, "dnode", replicas, 3)
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "dnode", Namespace: "marklogic"},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:             ptr.To(int32(3)),
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{{ObjectMeta: metav1.ObjectMeta{Name: "datadir"}}},
+		},
+	}
+	objs := []client.Object{cluster, secret, sts}
+	for i := 0; i < 3; i++ {
+		objs = append(objs,
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: podName(cluster, i), Namespace: "marklogic", Labels: selectorLabels(cluster)}},
+			&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "datadir-" + podName(cluster, i), Namespace: "marklogic"}})
+	}
+	s := testScheme()
+	c := fake.NewClientBuilder().
+		WithScheme(s).
+		WithObjects(objs...).
+		WithStatusSubresource(&marklogicv1alpha1.MarkLogicCluster{}).
+		Build()
+	st := &scaleDownTest{recorder: record.NewFakeRecorder(10)}
+	st.r = &MarkLogicScaleDownReconciler{Client: c, Scheme: s, Recorder: st.recorder, NewClient: f.newClient}
+	return st
+}
+
+func (st *scaleDownTest) reconcile(t *testing.T) (ctrl.Result, *marklogicv1alpha1.MarkLogicCluster) {
+	t.Helper()
+	key := types.NamespacedName{Namespace: "marklogic", Name: "dnode"}
+	result, err := st.r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	cluster := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, st.r.Get(context.Background(), key, cluster))
+	return result, cluster
+}
+
+// shrink scales the StatefulSet to replicas and deletes the pods above it, as
+// the MarkLogicCluster and StatefulSet controllers do.
+func (st *scaleDownTest) shrink(t *testing.T, replicas int32) {
+	t.Helper()
+	ctx := context.Background()
+	sts := &appsv1.StatefulSet{}
+	require.NoError(t, st.r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode"}, sts))
+	for i := replicas; i < *sts.Spec.Replicas; i++ {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: podName(newCluster("dnode"), int(i)), Namespace: "marklogic"}}
+		require.NoError(t, st.r.Delete(ctx, pod))
+	}
+	sts.Spec.Replicas = ptr.To(replicas)
+	require.NoError(t, st.r.Update(ctx, sts))
+}
+
+func (st *scaleDownTest) volume(t *testing.T, pod string) bool {
+	t.Helper()
+	err := st.r.Get(context.Background(), types.NamespacedName{Namespace: "marklogic", Name: "datadir-" + pod}, &corev1.PersistentVolumeClaim{})
+	return err == nil
+}
+
+func TestReconcileScaleDownEvacuatesHosts(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	host := func(i int) string { return fmt.Sprintf("dnode-%d.dnode.marklogic.svc.cluster.local", i) }
+	f.put("forests", map[string]interface{}{"forest-name": "Documents-1", "database": "Documents", "host": host(0), "documents": 3,
+		"forest-replica": []interface{}{map[string]interface{}{"replica-name": "Documents-1-r", "host": host(2)}}})
+	f.put("forests", map[string]interface{}{"forest-name": "Documents-2", "database": "Documents", "host": host(1), "documents": 5})
+	f.put("forests", map[string]interface{}{"forest-name": "Documents-3", "database": "Documents", "host": host(2)})
+	f.put("forests", map[string]interface{}{"forest-name": "Documents-1-r", "host": host(2), "state": "sync replicating"})
+	st := newScaleDownTest(t, f, 1)
+
+	// The emptied host leaves right away, the other one waits for the
+	// rebalancer.
+	result, cluster := st.reconcile(t)
+	assert.Equal(t, scaleDownPollInterval, result.RequeueAfter)
+	sd := cluster.Status.ScaleDown
+	require.NotNil(t, sd)
+	assert.Equal(t, marklogicv1alpha1.ScaleDownEvacuating, sd.Phase)
+	assert.Equal(t, int32(3), sd.FromReplicas)
+	assert.Equal(t, int32(1), sd.ToReplicas)
+	assert.Equal(t, []marklogicv1alpha1.LeavingHostStatus{
+		{Pod: "dnode-2", Phase: marklogicv1alpha1.HostRemoved, DetachedReplicas: []string{"Documents-1-r"}},
+		{Pod: "dnode-1", Phase: marklogicv1alpha1.HostEvacuating, Forests: []string{"Documents-2"}, Documents: 5},
+	}, sd.Hosts)
+	assert.Nil(t, f.get("forests", "Documents-3"))
+	assert.Nil(t, f.get("forests", "Documents-1-r"))
+	assert.Empty(t, f.get("forests", "Documents-1")["forest-replica"])
+	assert.Equal(t, true, f.get("forests", "Documents-2")["retired"])
+	assert.Nil(t, f.get("forests", "Documents-1")["retired"])
+	assert.Nil(t, f.get("hosts", host(2)))
+	assert.NotNil(t, f.get("hosts", host(1)))
+	assert.Equal(t, []string{
+		"Normal ScaleDownStarted evacuating hosts before scaling from 3 to 1 pods",
+		"Warning ReplicaDetached detached replica forest Documents-1-r from forest Documents-1 before deleting it",
+		"Normal HostRemoved removed host " + host(2) + " from the MarkLogic cluster",
+	}, events(st.recorder))
+
+	st.shrink(t, 2)
+	_, cluster = st.reconcile(t)
+	assert.Equal(t, marklogicv1alpha1.HostEvacuating, cluster.Status.ScaleDown.Hosts[1].Phase)
+	assert.NotNil(t, f.get("hosts", host(1)))
+
+	f.get("forests", "Documents-2")["documents"] = 0
+	_, cluster = st.reconcile(t)
+	assert.Equal(t, marklogicv1alpha1.HostRemoved, cluster.Status.ScaleDown.Hosts[1].Phase)
+	assert.Nil(t, f.get("forests", "Documents-2"))
+	assert.Nil(t, f.get("hosts", host(1)))
+	assert.Equal(t, []string{"Normal HostRemoved removed host " + host(1) + " from the MarkLogic cluster"}, events(st.recorder))
+
+	// The volumes go once the pods are gone.
+	result, cluster = st.reconcile(t)
+	assert.Equal(t, scaleDownPollInterval, result.RequeueAfter)
+	assert.Equal(t, marklogicv1alpha1.ScaleDownShrinking, cluster.Status.ScaleDown.Phase)
+	assert.True(t, st.volume(t, "dnode-2"))
+
+	st.shrink(t, 1)
+	result, cluster = st.reconcile(t)
+	assert.Zero(t, result.RequeueAfter)
+	assert.Equal(t, marklogicv1alpha1.ScaleDownCompleted, cluster.Status.ScaleDown.Phase)
+	assert.NotNil(t, cluster.Status.ScaleDown.CompletionTime)
+	assert.True(t, st.volume(t, "dnode-0"))
+	assert.False(t, st.volume(t, "dnode-1"))
+	assert.False(t, st.volume(t, "dnode-2"))
+	assert.Equal(t, []string{"Normal ScaleDownCompleted scaled down from 3 to 1 pods"}, events(st.recorder))
+
+	_, cluster = st.reconcile(t)
+	assert.Equal(t, marklogicv1alpha1.ScaleDownCompleted, cluster.Status.ScaleDown.Phase)
+	assert.Empty(t, events(st.recorder))
+}
+
+func TestReconcileScaleDownKeepsUnattachedForests(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	host := func(i int) string { return fmt.Sprintf("dnode-%d.dnode.marklogic.svc.cluster.local", i) }
+	// Documents-1 failed over to its replica on the leaving host, and Scratch
+	// was detached from its database on purpose.
+	f.put("forests", map[string]interface{}{"forest-name": "Documents-1", "database": "Documents", "host": host(0), "state": "sync replicating",
+		"forest-replica": []interface{}{map[string]interface{}{"replica-name": "Documents-1-r", "host": host(2)}}})
+	f.put("forests", map[string]interface{}{"forest-name": "Documents-1-r", "host": host(2), "state": "open"})
+	f.put("forests", map[string]interface{}{"forest-name": "Scratch", "host": host(2), "documents": 7})
+	st := newScaleDownTest(t, f, 2)
+
+	result, cluster := st.reconcile(t)
+	assert.Equal(t, scaleDownPollInterval, result.RequeueAfter)
+	assert.Equal(t, []marklogicv1alpha1.LeavingHostStatus{
+		{Pod: "dnode-2", Phase: marklogicv1alpha1.HostEvacuating, BlockingForests: []string{"Documents-1-r", "Scratch"}},
+	}, cluster.Status.ScaleDown.Hosts)
+	assert.NotNil(t, f.get("forests", "Documents-1-r"))
+	assert.NotNil(t, f.get("forests", "Scratch"))
+	assert.Len(t, f.get("forests", "Documents-1")["forest-replica"], 1)
+	assert.NotNil(t, f.get("hosts", host(2)))
+	requireCondition(t, cluster.Status.Conditions, marklogicv1alpha1.ConditionScaleDownBlocked, metav1.ConditionTrue, "UnattachedForests")
+	message := "forests Documents-1-r, Scratch (dnode-2) are attached to no database and are not synchronized replicas; " +
+		"move or delete them so their hosts can leave"
+	assert.Equal(t, message, meta.FindStatusCondition(cluster.Status.Conditions, marklogicv1alpha1.ConditionScaleDownBlocked).Message)
+	assert.Equal(t, []string{
+		"Normal ScaleDownStarted evacuating hosts before scaling from 3 to 2 pods",
+		"Warning ScaleDownBlocked " + message,
+	}, events(st.recorder))
+
+	// The operator does not repeat the event while nothing changes.
+	st.reconcile(t)
+	assert.Empty(t, events(st.recorder))
+
+	// Once the data moved, the host leaves.
+	f.get("forests", "Documents-1-r")["state"] = "sync replicating"
+	f.get("forests", "Documents-1")["state"] = "open"
+	delete(f.resources["forests"], "Scratch")
+	_, cluster = st.reconcile(t)
+	assert.Equal(t, marklogicv1alpha1.HostRemoved, cluster.Status.ScaleDown.Hosts[0].Phase)
+	assert.Nil(t, f.get("forests", "Documents-1-r"))
+	assert.Nil(t, f.get("hosts", host(2)))
+	requireCondition(t, cluster.Status.Conditions, marklogicv1alpha1.ConditionScaleDownBlocked, metav1.ConditionFalse, "NoBlockingForests")
+}
+
+func TestReconcileScaleDownCancelled(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	f.put("forests", map[string]interface{}{"forest-name": "Documents-3", "database": "Documents",
+		"host": "dnode-2.dnode.marklogic.svc.cluster.local", "documents": 5})
+	st := newScaleDownTest(t, f, 2)
+
+	_, cluster := st.reconcile(t)
+	assert.Equal(t, true, f.get("forests", "Documents-3")["retired"])
+	events(st.recorder)
+
+	cluster.Spec.ReplicaCount = ptr.To(int32(3))
+	require.NoError(t, st.r.Update(context.Background(), cluster))
+	result, cluster := st.reconcile(t)
+	assert.Zero(t, result.RequeueAfter)
+	assert.Equal(t, marklogicv1alpha1.ScaleDownCompleted, cluster.Status.ScaleDown.Phase)
+	assert.Empty(t, cluster.Status.ScaleDown.Hosts)
+	assert.Nil(t, f.get("forests", "Documents-3")["retired"])
+	assert.True(t, st.volume(t, "dnode-2"))
+	assert.Equal(t, []string{"Normal ScaleDownCancelled hosts dnode-2 stay in the cluster"}, events(st.recorder))
+}
+
+func TestScaleDownCancelNoStayingHosts(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	st := newScaleDownTest(t, f, 2)
+	cluster := newCluster("dnode")
+	cluster.Status.ScaleDown = &marklogicv1alpha1.ScaleDownStatus{
+		Hosts: []marklogicv1alpha1.LeavingHostStatus{{Pod: "dnode-2", Phase: marklogicv1alpha1.HostRemoved}},
+	}
+	require.NoError(t, st.r.cancel(context.Background(), nil, cluster, 2))
+	assert.Len(t, cluster.Status.ScaleDown.Hosts, 1)
+	assert.Empty(t, events(st.recorder))
+}
+
+func TestReconcileScaleDownUnmanaged(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	st := newScaleDownTest(t, f, 1)
+	cluster := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, st.r.Get(context.Background(), types.NamespacedName{Namespace: "marklogic", Name: "dnode"}, cluster))
+	cluster.Spec.ScaleDown.Managed = ptr.To(false)
+	require.NoError(t, st.r.Update(context.Background(), cluster))
+
+	_, cluster = st.reconcile(t)
+	assert.Nil(t, cluster.Status.ScaleDown)
+	assert.False(t, f.called("DELETE /admin/v1/host-config"))
+}
@@ -0,0 +1,361 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// upgradePollInterval is how often a managed upgrade checks on the host it replaces.
+const upgradePollInterval = 10 * time.Second
+
+// MarkLogicUpgradeReconciler replaces the pods of a MarkLogicCluster whose
+// StatefulSet uses the OnDelete strategy when the pod template changes, the
+// way a manual MarkLogic upgrade is done: bootstrap host first, then the
+// Security database, then the remaining hosts one at a time. The next pod is
+// only deleted once the replaced host is ready and restarted, and the upgrade
+// pauses when a host does not get there in time.
+type MarkLogicUpgradeReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// NewClient creates the MarkLogic clients. Defaults to mlclient.New.
+	NewClient mlclient.Factory
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicclusters,verbs=get;list;watch
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicclusters/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile moves the managed upgrade of a MarkLogicCluster one step forward.
+func (r *MarkLogicUpgradeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	cluster := &marklogicv1alpha1.MarkLogicCluster{}
+	if err := r.Get(ctx, req.NamespacedName, cluster); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !cluster.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+	desired := cluster.DeepCopy()
+	desired.Default()
+	strategy := desired.Spec.UpdateStrategy
+	if !*strategy.Managed || strategy.Type != appsv1.OnDeleteStatefulSetStrategyType {
+		return ctrl.Result{}, nil
+	}
+
+	sts := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: cluster.Namespace, Name: cluster.Name}, sts); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	revision := sts.Status.UpdateRevision
+	if revision == "" || sts.Status.ObservedGeneration < sts.Generation {
+		return ctrl.Result{RequeueAfter: upgradePollInterval}, nil
+	}
+	pods, err := r.pods(ctx, desired)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	var outdated []*corev1.Pod
+	for _, pod := range pods {
+		if pod.Labels[appsv1.ControllerRevisionHashLabelKey] != revision {
+			outdated = append(outdated, pod)
+		}
+	}
+
+	up := cluster.Status.Upgrade
+	if len(outdated) == 0 && (up == nil || up.CurrentPod == "") {
+		if up == nil || up.Phase == marklogicv1alpha1.UpgradeCompleted {
+			return ctrl.Result{}, nil
+		}
+		r.complete(cluster)
+		return ctrl.Result{}, r.Status().Update(ctx, cluster)
+	}
+	if up == nil || up.Revision != revision {
+		r.start(cluster, desired, revision, outdated)
+	}
+
+	result, err := r.step(ctx, cluster, desired, pods, outdated)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to upgrade MarkLogic cluster")
+		if statusErr := r.Status().Update(ctx, cluster); statusErr != nil {
+			log.FromContext(ctx).Error(statusErr, "failed to update MarkLogicCluster status")
+		}
+		return ctrl.Result{}, err
+	}
+	return result, r.Status().Update(ctx, cluster)
+}
+
+// start records a new upgrade to revision. An upgrade retargeted to another
+// revision keeps following the pod it is replacing.
+func (r *MarkLogicUpgradeReconciler) start(cluster, desired *marklogicv1alpha1.MarkLogicCluster, revision string, outdated []*corev1.Pod) {
+	now := metav1.NewTime(r.now())
+	next := &marklogicv1alpha1.UpgradeStatus{
+		Phase:     marklogicv1alpha1.UpgradeProgressing,
+		Revision:  revision,
+		ToImage:   desired.Spec.Image.Repository + ":" + desired.Spec.Image.Tag,
+		StartTime: &now,
+	}
+	if len(outdated) > 0 {
+		next.FromImage = podImage(outdated[0])
+	}
+	if prev := cluster.Status.Upgrade; prev != nil && prev.Phase != marklogicv1alpha1.UpgradeCompleted {
+		next.FromImage = prev.FromImage
+		next.CurrentPod = prev.CurrentPod
+		next.CurrentPodSince = prev.CurrentPodSince
+		next.LastStartup = prev.LastStartup
+		next.SecurityUpgraded = prev.SecurityUpgraded && prev.ToImage == next.ToImage
+	}
+	cluster.Status.Upgrade = next
+	r.Recorder.Eventf(cluster, corev1.EventTypeNormal, "UpgradeStarted", "upgrading %d pods to revision %s, image %s",
+		len(outdated), revision, next.ToImage)
+	setCondition(&cluster.Status.Conditions, cluster.Generation, marklogicv1alpha1.ConditionUpgrading, metav1.ConditionTrue,
+		"Progressing", fmt.Sprintf("upgrading to revision %s", revision))
+}
+
+// step checks on the pod being replaced and replaces the next outdated pod
+// once it is done.
+func (r *MarkLogicUpgradeReconciler) step(ctx context.Context, cluster, desired *marklogicv1alpha1.MarkLogicCluster,
+	pods []*corev1.Pod, outdated []*corev1.Pod) (ctrl.Result, error) {
+	up := cluster.Status.Upgrade
+	if up.Phase == marklogicv1alpha1.UpgradePaused && up.PausedGeneration != 0 {
+		if up.PausedGeneration == cluster.Generation {
+			return ctrl.Result{}, nil
+		}
+		// The spec changed since the upgrade paused; give the current host
+		// another host timeout.
+		now := metav1.NewTime(r.now())
+		up.Phase, up.PausedGeneration, up.Message = marklogicv1alpha1.UpgradeProgressing, 0, ""
+		if up.CurrentPod != "" {
+			up.CurrentPodSince = &now
+		}
+		r.Recorder.Eventf(cluster, corev1.EventTypeNormal, "UpgradeResumed", "resuming upgrade to revision %s", up.Revision)
+	}
+
+	if up.CurrentPod != "" {
+		done, err := r.checkCurrent(ctx, cluster, desired, pods)
+		if err != nil || !done {
+			return ctrl.Result{RequeueAfter: upgradePollInterval}, err
+		}
+		if up.Phase == marklogicv1alpha1.UpgradePaused {
+			return ctrl.Result{}, nil
+		}
+	}
+	if len(outdated) == 0 {
+		r.complete(cluster)
+		return ctrl.Result{}, nil
+	}
+
+	if desired.Spec.UpdateStrategy.Paused {
+		up.Phase = marklogicv1alpha1.UpgradePaused
+		up.Message = "paused by spec.updateStrategy.paused"
+		setCondition(&cluster.Status.Conditions, cluster.Generation, marklogicv1alpha1.ConditionUpgrading, metav1.ConditionFalse,
+			"Paused", up.Message)
+		return ctrl.Result{}, nil
+	}
+	up.Phase, up.Message = marklogicv1alpha1.UpgradeProgressing, ""
+	setCondition(&cluster.Status.Conditions, cluster.Generation, marklogicv1alpha1.ConditionUpgrading, metav1.ConditionTrue,
+		"Progressing", fmt.Sprintf("upgrading to revision %s, %d pods left", up.Revision, len(outdated)))
+
+	// Only replace a host while every other host serves its forests.
+	if int32(len(pods)) < *desired.Spec.ReplicaCount {
+		return ctrl.Result{RequeueAfter: upgradePollInterval}, nil
+	}
+	for _, pod := range pods {
+		if !podReady(pod) {
+			return ctrl.Result{RequeueAfter: upgradePollInterval}, nil
+		}
+	}
+
+	// outdated is sorted by ordinal, so the bootstrap host goes first.
+	next := outdated[0]
+	baseline := ""
+	if mc, err := r.hostClient(ctx, desired, next); err == nil {
+		baseline, _ = mc.Timestamp(ctx)
+	}
+	log.FromContext(ctx).Info("replacing pod for upgrade", "pod", next.Name, "revision", up.Revision)
+	if err := r.Delete(ctx, next, client.Preconditions{UID: &next.UID}); err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, fmt.Errorf("deleting pod %s: %w", next.Name, err)
+	}
+	now := metav1.NewTime(r.now())
+	up.CurrentPod = next.Name
+	up.CurrentPodSince = &now
+	up.LastStartup = baseline
+	r.Recorder.Eventf(cluster, corev1.EventTypeNormal, "UpgradingHost", "replacing pod %s with revision %s", next.Name, up.Revision)
+	return ctrl.Result{RequeueAfter: upgradePollInterval}, nil
+}
+
+// checkCurrent reports whether the host of the pod being replaced runs the
+// new revision, is ready and restarted. After the bootstrap host it upgrades
+// the Security database when the MarkLogic image changed. The upgrade pauses
+// when the host does not get there within the host timeout.
+func (r *MarkLogicUpgradeReconciler) checkCurrent(ctx context.Context, cluster, desired *marklogicv1alpha1.MarkLogicCluster,
+	pods []*corev1.Pod) (bool, error) {
+	up := cluster.Status.Upgrade
+	var pod *corev1.Pod
+	for _, p := range pods {
+		if p.Name == up.CurrentPod {
+			pod = p
+		}
+	}
+	if pod == nil || pod.Labels[appsv1.ControllerRevisionHashLabelKey] != up.Revision || !podReady(pod) {
+		r.checkTimeout(cluster, desired, "did not become ready with revision "+up.Revision)
+		return false, nil
+	}
+	mc, err := r.hostClient(ctx, desired, pod)
+	if err != nil {
+		return false, err
+	}
+	startup, err := mc.Timestamp(ctx)
+	if err != nil || startup == "" || startup == up.LastStartup {
+		r.checkTimeout(cluster, desired, "did not restart MarkLogic")
+		return false, nil
+	}
+
+	if podOrdinal(pod.Name) == 0 && desired.Spec.BootstrapHostName == "" && !up.SecurityUpgraded && up.FromImage != up.ToImage {
+		restart, err := mc.SecurityUpgrade(ctx)
+		if mlclient.StatusCode(err) != 0 {
+			r.pause(cluster, fmt.Sprintf("MarkLogic refused the upgrade of the Security database: %v", err))
+			return true, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("upgrading Security database: %w", err)
+		}
+		up.SecurityUpgraded = true
+		r.Recorder.Eventf(cluster, corev1.EventTypeNormal, "SecurityUpgraded", "upgraded the Security database on %s", pod.Name)
+		if restart != nil {
+			// Wait for the restart that completes the upgrade like for the
+			// replacement itself.
+			now := metav1.NewTime(r.now())
+			up.LastStartup = startup
+			if restart.LastStartup != "" {
+				up.LastStartup = restart.LastStartup
+			}
+			up.CurrentPodSince = &now
+			return false, nil
+		}
+	}
+
+	up.UpgradedPods = append(up.UpgradedPods, pod.Name)
+	up.CurrentPod, up.CurrentPodSince, up.LastStartup = "", nil, ""
+	r.Recorder.Eventf(cluster, corev1.EventTypeNormal, "HostUpgraded", "pod %s runs revision %s", pod.Name, up.Revision)
+	return true, nil
+}
+
+// checkTimeout pauses the upgrade when the current pod exceeded the host timeout.
+func (r *MarkLogicUpgradeReconciler) checkTimeout(cluster, desired *marklogicv1alpha1.MarkLogicCluster, problem string) {
+	up := cluster.Status.Upgrade
+	timeout := desired.Spec.UpdateStrategy.HostTimeout.Duration
+	if up.Phase == marklogicv1alpha1.UpgradePaused || up.CurrentPodSince == nil || r.now().Sub(up.CurrentPodSince.Time) < timeout {
+		return
+	}
+	r.pause(cluster, fmt.Sprintf("pod %s %s within %s", up.CurrentPod, problem, timeout))
+}
+
+// pause stops the upgrade until the spec of the cluster changes.
+func (r *MarkLogicUpgradeReconciler) pause(cluster *marklogicv1alpha1.MarkLogicCluster, message string) {
+	up := cluster.Status.Upgrade
+	up.Phase = marklogicv1alpha1.UpgradePaused
+	up.PausedGeneration = cluster.Generation
+	up.Message = message
+	r.Recorder.Eventf(cluster, corev1.EventTypeWarning, "UpgradePaused", "%s", message)
+	setCondition(&cluster.Status.Conditions, cluster.Generation, marklogicv1alpha1.ConditionUpgrading, metav1.ConditionFalse,
+		"HostFailed", message)
+}
+
+func (r *MarkLogicUpgradeReconciler) complete(cluster *marklogicv1alpha1.MarkLogicCluster) {
+	up := cluster.Status.Upgrade
+	now := metav1.NewTime(r.now())
+	up.Phase = marklogicv1alpha1.UpgradeCompleted
+	up.CompletionTime = &now
+	up.Message = ""
+	r.Recorder.Eventf(cluster, corev1.EventTypeNormal, "UpgradeCompleted", "all pods run revision %s", up.Revision)
+	setCondition(&cluster.Status.Conditions, cluster.Generation, marklogicv1alpha1.ConditionUpgrading, metav1.ConditionFalse,
+		"Completed", fmt.Sprintf("all pods run revision %s", up.Revision))
+}
+
+// pods returns the MarkLogic pods of the cluster sorted by ordinal.
+func (r *MarkLogicUpgradeReconciler) pods(ctx context.Context, c *marklogicv1alpha1.MarkLogicCluster) ([]*corev1.Pod, error) {
+	list := &corev1.PodList{}
+	if err := r.List(ctx, list, client.InNamespace(c.Namespace), client.MatchingLabels(selectorLabels(c))); err != nil {
+		return nil, fmt.Errorf("listing pods: %w", err)
+	}
+	var pods []*corev1.Pod
+	for i := range list.Items {
+		if podOrdinal(list.Items[i].Name) >= 0 && list.Items[i].DeletionTimestamp.IsZero() {
+			pods = append(pods, &list.Items[i])
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool { return podOrdinal(pods[i].Name) < podOrdinal(pods[j].Name) })
+	return pods, nil
+}
+
+func (r *MarkLogicUpgradeReconciler) hostClient(ctx context.Context, c *marklogicv1alpha1.MarkLogicCluster, pod *corev1.Pod) (*mlclient.Client, error) {
+	return hostClient(ctx, r.Client, r.NewClient, c, hostFQDN(c, podOrdinal(pod.Name)))
+}
+
+func (r *MarkLogicUpgradeReconciler) now() time.Time {
+	if r.Now == nil {
+		return time.Now()
+	}
+	return r.Now()
+}
+
+// podReady reports whether the Ready condition of pod is true.
+func podReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// podImage returns the image of the MarkLogic container of pod.
+func podImage(pod *corev1.Pod) string {
+	for _, c := range pod.Spec.Containers {
+		if c.Name == containerName {
+			return c.Image
+		}
+	}
+	return ""
+}
+
+// podCluster maps a MarkLogic pod, or another object with the selector
+// labels of a cluster, to its cluster.
+func podCluster(_ context.Context, obj client.Object) []ctrl.Request {
+	l := obj.GetLabels()
+	if l["app.kubernetes.io/name"] != appName || l["app.kubernetes.io/instance"] == "" {
+		return nil
+	}
+	return []ctrl.Request{{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: l["app.kubernetes.io/instance"]}}}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MarkLogicUpgradeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("marklogicupgrade").
+		For(&marklogicv1alpha1.MarkLogicCluster{}).
+		Owns(&appsv1.StatefulSet{}).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(podCluster)).
+		Complete(r)
+}
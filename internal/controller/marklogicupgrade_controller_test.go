@@ -0,0 +1,218 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+const (
+	oldImage = "progressofficial/marklogic-db:11.2.0-ubi-rootless"
+	newImage = "progressofficial/marklogic-db:11.3.0-ubi-rootless"
+)
+
+type upgradeTest struct {
+	r        *MarkLogicUpgradeReconciler
+	recorder *record.FakeRecorder
+	now      time.Time
+}
+
+// newUpgradeTest returns a cluster of three hosts running oldImage whose
+// StatefulSet was updated to newImage.
+func newUpgradeTest(t *testing.T, f *fakeMarkLogic, mutate func(*marklogicv1alpha1.MarkLogicCluster)) *upgradeTest {
+	cluster, secret := availableCluster(f, "dnode", 3, 3)
+	cluster.Generation = 1
+	if mutate != nil {
+		mutate(cluster)
+	}
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "dnode", Namespace: "marklogic", Generation: 1},
+		Status:     appsv1.StatefulSetStatus{ObservedGeneration: 1, CurrentRevision: "dnode-old", UpdateRevision: "dnode-new"},
+	}
+	objs := []client.Object{cluster, secret, sts}
+	for i := 0; i < 3; i++ {
+		objs = append(objs, upgradePod(cluster, i, "dnode-old", oldImage))
+	}
+	s := testScheme()
+	c := fake.NewClientBuilder().
+		WithScheme(s).
+		WithObjects(objs...).
+		WithStatusSubresource(&marklogicv1alpha1.MarkLogicCluster{}, &appsv1.StatefulSet{}).
+		Build()
+	ut := &upgradeTest{recorder: record.NewFakeRecorder(10), now: scheduleCreated}
+	ut.r = &MarkLogicUpgradeReconciler{Client: c, Scheme: s, Recorder: ut.recorder, NewClient: f.newClient,
+		Now: func() time.Time { return ut.now }}
+	return ut
+}
+
+func upgradePod(c *marklogicv1alpha1.MarkLogicCluster, ordinal int, revision, image string) *corev1.Pod {
+	l := selectorLabels(c)
+	l[appsv1.ControllerRevisionHashLabelKey] = revision
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: podName(c, ordinal), Namespace: c.Namespace, Labels: l},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: containerName, Image: image}}},
+		Status: corev1.PodStatus{Conditions: []corev1.PodCondition{
+			{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+		}},
+	}
+}
+
+func (ut *upgradeTest) reconcile(t *testing.T) (ctrl.Result, *marklogicv1alpha1.MarkLogicCluster) {
+	t.Helper()
+	key := types.NamespacedName{Namespace: "marklogic", Name: "dnode"}
+	result, err := ut.r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	cluster := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, ut.r.Get(context.Background(), key, cluster))
+	return result, cluster
+}
+
+// exists reports whether the pod with ordinal exists.
+func (ut *upgradeTest) exists(t *testing.T, ordinal int) bool {
+	t.Helper()
+	err := ut.r.Get(context.Background(), types.NamespacedName{Namespace: "marklogic", Name: fmt.Sprintf("dnode-%d", ordinal)}, &corev1.Pod{})
+	return err == nil
+}
+
+// recreate creates the pod with ordinal from the new revision, as the
+// StatefulSet controller does after the pod was deleted.
+func (ut *upgradeTest) recreate(t *testing.T, ordinal int) {
+	t.Helper()
+	c := newCluster("dnode")
+	require.NoError(t, ut.r.Create(context.Background(), upgradePod(c, ordinal, "dnode-new", newImage)))
+}
+
+func TestReconcileUpgradeReplacesHostsInOrder(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	f.setStartup("startup-0")
+	ut := newUpgradeTest(t, f, nil)
+
+	result, cluster := ut.reconcile(t)
+	assert.Equal(t, upgradePollInterval, result.RequeueAfter)
+	assert.False(t, ut.exists(t, 0), "the bootstrap host goes first")
+	assert.True(t, ut.exists(t, 1))
+	up := cluster.Status.Upgrade
+	require.NotNil(t, up)
+	assert.Equal(t, marklogicv1alpha1.UpgradeProgressing, up.Phase)
+	assert.Equal(t, oldImage, up.FromImage)
+	assert.Equal(t, newImage, up.ToImage)
+	assert.Equal(t, "dnode-0", up.CurrentPod)
+	assert.Equal(t, "startup-0", up.LastStartup)
+	requireCondition(t, cluster.Status.Conditions, marklogicv1alpha1.ConditionUpgrading, metav1.ConditionTrue, "Progressing")
+	assert.Equal(t, []string{
+		"Normal UpgradeStarted upgrading 3 pods to revision dnode-new, image " + newImage,
+		"Normal UpgradingHost replacing pod dnode-0 with revision dnode-new",
+	}, events(ut.recorder))
+
+	// The next host waits for the replaced one to be ready and restarted.
+	ut.reconcile(t)
+	ut.recreate(t, 0)
+	ut.reconcile(t)
+	assert.True(t, ut.exists(t, 1))
+	assert.False(t, f.called("POST /admin/v1/security-upgrade"))
+
+	// The Security database is upgraded after the bootstrap host restarted,
+	// and the host restarts once more.
+	f.setStartup("startup-1")
+	_, cluster = ut.reconcile(t)
+	assert.True(t, f.called("POST /admin/v1/security-upgrade"))
+	assert.True(t, cluster.Status.Upgrade.SecurityUpgraded)
+	assert.True(t, ut.exists(t, 1))
+	assert.Equal(t, []string{"Normal SecurityUpgraded upgraded the Security database on dnode-0"}, events(ut.recorder))
+
+	f.setStartup("startup-2")
+	_, cluster = ut.reconcile(t)
+	assert.False(t, ut.exists(t, 1))
+	assert.Equal(t, []string{"dnode-0"}, cluster.Status.Upgrade.UpgradedPods)
+	assert.Equal(t, "dnode-1", cluster.Status.Upgrade.CurrentPod)
+	assert.Equal(t, []string{
+		"Normal HostUpgraded pod dnode-0 runs revision dnode-new",
+		"Normal UpgradingHost replacing pod dnode-1 with revision dnode-new",
+	}, events(ut.recorder))
+
+	// A host that does not come back pauses the upgrade.
+	ut.now = ut.now.Add(16 * time.Minute)
+	result, cluster = ut.reconcile(t)
+	assert.Equal(t, marklogicv1alpha1.UpgradePaused, cluster.Status.Upgrade.Phase)
+	assert.Equal(t, "pod dnode-1 did not become ready with revision dnode-new within 15m0s", cluster.Status.Upgrade.Message)
+	requireCondition(t, cluster.Status.Conditions, marklogicv1alpha1.ConditionUpgrading, metav1.ConditionFalse, "HostFailed")
+	assert.Equal(t, []string{"Warning UpgradePaused " + cluster.Status.Upgrade.Message}, events(ut.recorder))
+	ut.recreate(t, 1)
+	f.setStartup("startup-3")
+	result, cluster = ut.reconcile(t)
+	assert.Zero(t, result.RequeueAfter)
+	assert.Equal(t, marklogicv1alpha1.UpgradePaused, cluster.Status.Upgrade.Phase)
+	assert.True(t, ut.exists(t, 2))
+
+	// Changing the spec resumes it.
+	cluster.Generation = 2
+	require.NoError(t, ut.r.Update(context.Background(), cluster))
+	_, cluster = ut.reconcile(t)
+	assert.False(t, ut.exists(t, 2))
+	assert.Equal(t, []string{"dnode-0", "dnode-1"}, cluster.Status.Upgrade.UpgradedPods)
+	assert.Len(t, events(ut.recorder), 3)
+
+	ut.recreate(t, 2)
+	f.setStartup("startup-4")
+	result, cluster = ut.reconcile(t)
+	assert.Zero(t, result.RequeueAfter)
+	assert.Equal(t, marklogicv1alpha1.UpgradeCompleted, cluster.Status.Upgrade.Phase)
+	assert.NotNil(t, cluster.Status.Upgrade.CompletionTime)
+	assert.Equal(t, []string{"dnode-0", "dnode-1", "dnode-2"}, cluster.Status.Upgrade.UpgradedPods)
+	requireCondition(t, cluster.Status.Conditions, marklogicv1alpha1.ConditionUpgrading, metav1.ConditionFalse, "Completed")
+	assert.Equal(t, []string{
+		"Normal HostUpgraded pod dnode-2 runs revision dnode-new",
+		"Normal UpgradeCompleted all pods run revision dnode-new",
+	}, events(ut.recorder))
+}
+
+func TestReconcileUpgradeWaitsForHealthyCluster(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	ut := newUpgradeTest(t, f, nil)
+	pod := &corev1.Pod{}
+	require.NoError(t, ut.r.Get(context.Background(), types.NamespacedName{Namespace: "marklogic", Name: "dnode-2"}, pod))
+	pod.Status.Conditions[0].Status = corev1.ConditionFalse
+	require.NoError(t, ut.r.Status().Update(context.Background(), pod))
+
+	result, _ := ut.reconcile(t)
+	assert.Equal(t, upgradePollInterval, result.RequeueAfter)
+	assert.True(t, ut.exists(t, 0), "no host is replaced while another one is not ready")
+}
+
+func TestReconcileUpgradeHonoursStrategy(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	ut := newUpgradeTest(t, f, func(c *marklogicv1alpha1.MarkLogicCluster) {
+		c.Spec.UpdateStrategy.Paused = true
+	})
+	_, cluster := ut.reconcile(t)
+	assert.True(t, ut.exists(t, 0))
+	assert.Equal(t, marklogicv1alpha1.UpgradePaused, cluster.Status.Upgrade.Phase)
+	requireCondition(t, cluster.Status.Conditions, marklogicv1alpha1.ConditionUpgrading, metav1.ConditionFalse, "Paused")
+
+	for _, mutate := range []func(*marklogicv1alpha1.MarkLogicCluster){
+		func(c *marklogicv1alpha1.MarkLogicCluster) { c.Spec.UpdateStrategy.Managed = ptr.To(false) },
+		func(c *marklogicv1alpha1.MarkLogicCluster) {
+			c.Spec.UpdateStrategy.Type = appsv1.RollingUpdateStatefulSetStrategyType
+		},
+	} {
+		ut := newUpgradeTest(t, f, mutate)
+		_, cluster := ut.reconcile(t)
+		assert.True(t, ut.exists(t, 0))
+		assert.Nil(t, cluster.Status.Upgrade)
+	}
+}
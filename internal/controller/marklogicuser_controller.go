@@ -0,0 +1,284 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// userFinalizer lets the operator delete the user from MarkLogic before the
+// resource goes away.
+const userFinalizer = "marklogic.com/user"
+
+// MarkLogicUserReconciler reconciles a MarkLogicUser object.
+type MarkLogicUserReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// NewClient creates the MarkLogic clients. Defaults to mlclient.New.
+	NewClient mlclient.Factory
+}
+
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicusers,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicusers/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=marklogic.com,resources=marklogicusers/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+// Reconcile creates the user in the MarkLogic cluster, keeps its properties in
+// line with the spec and its password in line with its password secret.
+func (r *MarkLogicUserReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	user := &marklogicv1alpha1.MarkLogicUser{}
+	if err := r.Get(ctx, req.NamespacedName, user); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	desired := user.DeepCopy()
+	desired.Default()
+
+	if !user.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, user, desired)
+	}
+	if controllerutil.AddFinalizer(user, userFinalizer) {
+		if err := r.Update(ctx, user); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: user.Namespace, Name: desired.Spec.PasswordSecretName}, secret)
+	if apierrors.IsNotFound(err) {
+		r.setReconciled(user, metav1.ConditionFalse, "SecretNotFound", fmt.Sprintf("secret %s not found", desired.Spec.PasswordSecretName))
+		return ctrl.Result{}, r.Status().Update(ctx, user)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	props, err := userProperties(desired)
+	if err == nil && len(secret.Data["password"]) == 0 {
+		err = fmt.Errorf("secret %s has no password", secret.Name)
+	}
+	if err != nil {
+		logger.Info("invalid MarkLogicUser spec", "reason", err.Error())
+		r.setReconciled(user, metav1.ConditionFalse, "InvalidSpec", err.Error())
+		return ctrl.Result{}, r.Status().Update(ctx, user)
+	}
+
+	cluster, err := clusterFor(ctx, r.Client, user.Namespace, desired.Spec.ClusterRef)
+	if apierrors.IsNotFound(err) {
+		r.setReconciled(user, metav1.ConditionFalse, "ClusterNotFound", fmt.Sprintf("MarkLogicCluster %s not found", desired.Spec.ClusterRef.Name))
+		return ctrl.Result{}, r.Status().Update(ctx, user)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	mc, err := clusterClient(ctx, r.Client, r.NewClient, cluster)
+	if errors.Is(err, errClusterNotReady) {
+		r.setReconciled(user, metav1.ConditionFalse, "ClusterNotReady", fmt.Sprintf("waiting for MarkLogicCluster %s to become available", cluster.Name))
+		return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, user)
+	}
+	if errors.Is(err, errCredentialsUnavailable) {
+		r.setReconciled(user, metav1.ConditionFalse, "CredentialsUnavailable", err.Error())
+		return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, user)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileUser(ctx, mc, user, desired, props, secret); err != nil {
+		logger.Error(err, "failed to reconcile user", "user", desired.Spec.UserName)
+		r.setReconciled(user, metav1.ConditionFalse, "ReconcileError", err.Error())
+		if statusErr := r.Status().Update(ctx, user); statusErr != nil {
+			logger.Error(statusErr, "failed to update MarkLogicUser status")
+		}
+		return ctrl.Result{}, err
+	}
+	user.Status.ObservedGeneration = user.Generation
+	r.setReconciled(user, metav1.ConditionTrue, "Reconciled", "user is up to date")
+	return ctrl.Result{RequeueAfter: driftCheckInterval}, r.Status().Update(ctx, user)
+}
+
+// reconcileUser applies the desired user properties and records the outcome
+// in the status of user. The password cannot be read back, so it is only set
+// when the user is created or its secret changes.
+func (r *MarkLogicUserReconciler) reconcileUser(ctx context.Context, mc *mlclient.Client,
+	user, desired *marklogicv1alpha1.MarkLogicUser, props map[string]interface{}, secret *corev1.Secret) error {
+	name := desired.Spec.UserName
+	password := string(secret.Data["password"])
+
+	current, err := mc.UserProperties(ctx, name)
+	switch {
+	case mlclient.IsNotFound(err):
+		log.FromContext(ctx).Info("creating user", "user", name)
+		create := mlclient.UserProperties{"password": password}
+		for k, v := range props {
+			create[k] = v
+		}
+		if err := mc.CreateUser(ctx, create); err != nil {
+			return fmt.Errorf("creating user %s: %w", name, err)
+		}
+		user.Status.Drift = nil
+		r.setInSync(user, metav1.ConditionTrue, "Created", "user created")
+	case err != nil:
+		return fmt.Errorf("reading user %s: %w", name, err)
+	default:
+		result, err := syncProperties(ctx, "user", props, current, user.Generation != user.Status.ObservedGeneration, desired.Spec.DriftPolicy,
+			func(update map[string]interface{}) error {
+				if err := mc.UpdateUserProperties(ctx, name, update); err != nil {
+					return fmt.Errorf("updating user %s: %w", name, err)
+				}
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+		if secret.ResourceVersion != user.Status.PasswordSecretVersion {
+			log.FromContext(ctx).Info("changing the password of user", "user", name, "secret", secret.Name)
+			if err := mc.UpdateUserProperties(ctx, name, mlclient.UserProperties{"password": password}); err != nil {
+				return fmt.Errorf("changing the password of user %s: %w", name, err)
+			}
+		}
+		user.Status.Drift = result.Drift
+		r.setInSync(user, result.Status, result.Reason, result.Message)
+	}
+	now := metav1.Now()
+	user.Status.LastDriftCheck = &now
+	user.Status.UserName = name
+	user.Status.PasswordSecretVersion = secret.ResourceVersion
+	return nil
+}
+
+// finalize deletes the user from MarkLogic when the deletion policy asks for
+// it, then releases the resource.
+func (r *MarkLogicUserReconciler) finalize(ctx context.Context, user, desired *marklogicv1alpha1.MarkLogicUser) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(user, userFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	if desired.Spec.DeletionPolicy == marklogicv1alpha1.DeletionPolicyDelete {
+		cluster, err := clusterFor(ctx, r.Client, user.Namespace, desired.Spec.ClusterRef)
+		switch {
+		case apierrors.IsNotFound(err):
+			// Nothing is left to clean up with the cluster gone.
+		case err != nil:
+			return ctrl.Result{}, err
+		case cluster.DeletionTimestamp.IsZero():
+			mc, err := clusterClient(ctx, r.Client, r.NewClient, cluster)
+			if errors.Is(err, errClusterNotReady) {
+				r.setReconciled(user, metav1.ConditionFalse, "ClusterNotReady", fmt.Sprintf("waiting for MarkLogicCluster %s to become available", cluster.Name))
+				return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, user)
+			}
+			if errors.Is(err, errCredentialsUnavailable) {
+				r.setReconciled(user, metav1.ConditionFalse, "CredentialsUnavailable", err.Error())
+				return ctrl.Result{RequeueAfter: clusterNotReadyRequeue}, r.Status().Update(ctx, user)
+			}
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			log.FromContext(ctx).Info("deleting user", "user", desired.Spec.UserName)
+			if err := mc.DeleteUser(ctx, desired.Spec.UserName); err != nil {
+				return ctrl.Result{}, fmt.Errorf("deleting user %s: %w", desired.Spec.UserName, err)
+			}
+		}
+	}
+	controllerutil.RemoveFinalizer(user, userFinalizer)
+	return ctrl.Result{}, r.Update(ctx, user)
+}
+
+func (r *MarkLogicUserReconciler) setReconciled(user *marklogicv1alpha1.MarkLogicUser, status metav1.ConditionStatus, reason, message string) {
+	setCondition(&user.Status.Conditions, user.Generation, marklogicv1alpha1.ConditionReconciled, status, reason, message)
+}
+
+func (r *MarkLogicUserReconciler) setInSync(user *marklogicv1alpha1.MarkLogicUser, status metav1.ConditionStatus, reason, message string) {
+	setCondition(&user.Status.Conditions, user.Generation, marklogicv1alpha1.ConditionInSync, status, reason, message)
+}
+
+// userSpec is the Management API form of the typed fields of the spec.
+type userSpec struct {
+	UserName      string           `json:"user-name"`
+	Description   string           `json:"description,omitempty"`
+	ExternalNames []string         `json:"external-name"`
+	Roles         []string         `json:"role"`
+	Permissions   []permissionSpec `json:"permission"`
+	Collections   []string         `json:"collection"`
+}
+
+// userProperties returns the desired properties of a defaulted user, without
+// its password.
+func userProperties(user *marklogicv1alpha1.MarkLogicUser) (map[string]interface{}, error) {
+	s := user.Spec
+	props, err := toProperties(userSpec{
+		UserName:      s.UserName,
+		Description:   s.Description,
+		ExternalNames: nonNil(s.ExternalNames),
+		Roles:         nonNil(s.Roles),
+		Permissions:   permissionSpecs(s.Permissions),
+		Collections:   nonNil(s.Collections),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s.Properties != nil {
+		if err := mergeRawProperties(props, s.Properties.Raw); err != nil {
+			return nil, fmt.Errorf("spec.properties: %w", err)
+		}
+	}
+	// The password is set from the secret only.
+	delete(props, "password")
+	return props, nil
+}
+
+// usersFor lists the requests of the users in namespace that match.
+func (r *MarkLogicUserReconciler) usersFor(ctx context.Context, namespace string, match func(*marklogicv1alpha1.MarkLogicUser) bool) []ctrl.Request {
+	list := &marklogicv1alpha1.MarkLogicUserList{}
+	if err := r.List(ctx, list, client.InNamespace(namespace)); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list MarkLogicUsers")
+		return nil
+	}
+	var requests []ctrl.Request
+	for i := range list.Items {
+		if match(&list.Items[i]) {
+			requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&list.Items[i])})
+		}
+	}
+	return requests
+}
+
+// usersForCluster maps a MarkLogicCluster to the users it holds.
+func (r *MarkLogicUserReconciler) usersForCluster(ctx context.Context, obj client.Object) []ctrl.Request {
+	return r.usersFor(ctx, obj.GetNamespace(), func(u *marklogicv1alpha1.MarkLogicUser) bool {
+		return u.Spec.ClusterRef.Name == obj.GetName()
+	})
+}
+
+// usersForSecret maps a secret to the users whose password it holds.
+func (r *MarkLogicUserReconciler) usersForSecret(ctx context.Context, obj client.Object) []ctrl.Request {
+	return r.usersFor(ctx, obj.GetNamespace(), func(u *marklogicv1alpha1.MarkLogicUser) bool {
+		return u.Spec.PasswordSecretName == obj.GetName()
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MarkLogicUserReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&marklogicv1alpha1.MarkLogicUser{}).
+		Watches(&marklogicv1alpha1.MarkLogicCluster{},
+			handler.EnqueueRequestsFromMapFunc(r.usersForCluster),
+			builder.WithPredicates(clusterChanged)).
+		Watches(&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.usersForSecret)).
+		Complete(r)
+}
@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+func newUser(name, cluster string) *marklogicv1alpha1.MarkLogicUser {
+	return &marklogicv1alpha1.MarkLogicUser{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "marklogic", Generation: 1},
+		Spec: marklogicv1alpha1.MarkLogicUserSpec{
+			ClusterRef:         marklogicv1alpha1.ClusterReference{Name: cluster},
+			PasswordSecretName: name + "-password",
+			Roles:              []string{"rest-reader"},
+		},
+	}
+}
+
+func newUserReconciler(f *fakeMarkLogic, objs ...client.Object) *MarkLogicUserReconciler {
+	s := testScheme()
+	c := fake.NewClientBuilder().
+		WithScheme(s).
+		WithObjects(objs...).
+		WithStatusSubresource(&marklogicv1alpha1.MarkLogicUser{}, &marklogicv1alpha1.MarkLogicCluster{}).
+		Build()
+	return &MarkLogicUserReconciler{Client: c, Scheme: s, NewClient: f.newClient}
+}
+
+func reconcileUser(t *testing.T, r *MarkLogicUserReconciler, name string) (ctrl.Result, *marklogicv1alpha1.MarkLogicUser) {
+	t.Helper()
+	key := types.NamespacedName{Namespace: "marklogic", Name: name}
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	user := &marklogicv1alpha1.MarkLogicUser{}
+	require.NoError(t, r.Get(context.Background(), key, user))
+	return result, user
+}
+
+func passwordSecret(name, password string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "marklogic"},
+		Data:       map[string][]byte{"password": []byte(password)},
+	}
+}
+
+func TestReconcileUserCreatesUser(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	cluster, secret := availableCluster(f, "dnode", 1, 1)
+	user := newUser("app", "dnode")
+	user.Spec.Properties = &runtime.RawExtension{Raw: []byte(`{"password": "ignored", "external-name": ["ignored"]}`)}
+	r := newUserReconciler(f, cluster, secret, passwordSecret("app-password", "secret"), user)
+	ctx := context.Background()
+
+	result, user := reconcileUser(t, r, "app")
+	props := f.get("users", "app")
+	require.NotNil(t, props)
+	assert.Equal(t, "secret", props["password"], "the password only comes from the secret")
+	assert.Equal(t, []interface{}{"rest-reader"}, props["role"])
+	assert.Equal(t, []interface{}{}, props["external-name"], "typed fields take precedence over properties")
+
+	assert.Equal(t, driftCheckInterval, result.RequeueAfter)
+	assert.Equal(t, []string{userFinalizer}, user.Finalizers)
+	assert.Equal(t, "app", user.Status.UserName)
+	requireCondition(t, user.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionTrue, "Reconciled")
+	requireCondition(t, user.Status.Conditions, marklogicv1alpha1.ConditionInSync, metav1.ConditionTrue, "Created")
+
+	// Roles granted outside of the operator are revoked.
+	props["role"] = []interface{}{"rest-reader", "admin"}
+	_, user = reconcileUser(t, r, "app")
+	assert.Equal(t, []string{"role"}, user.Status.Drift)
+	assert.Equal(t, []interface{}{"rest-reader"}, props["role"])
+	requireCondition(t, user.Status.Conditions, marklogicv1alpha1.ConditionInSync, metav1.ConditionTrue, "DriftCorrected")
+	props["password"] = "changed"
+	_, _ = reconcileUser(t, r, "app")
+	assert.Equal(t, "changed", props["password"], "the password is only set when its secret changes")
+
+	// A new password in the secret is set.
+	pw := &corev1.Secret{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "app-password"}, pw))
+	pw.Data["password"] = []byte("rotated")
+	require.NoError(t, r.Update(ctx, pw))
+	_, user = reconcileUser(t, r, "app")
+	assert.Equal(t, "rotated", props["password"])
+	assert.Equal(t, pw.ResourceVersion, user.Status.PasswordSecretVersion)
+}
+
+func TestReconcileUserWaitsForSecret(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	cluster, secret := availableCluster(f, "dnode", 1, 1)
+	r := newUserReconciler(f, cluster, secret, newUser("app", "dnode"), newUser("empty", "dnode"), passwordSecret("empty-password", ""))
+
+	_, user := reconcileUser(t, r, "app")
+	requireCondition(t, user.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "SecretNotFound")
+	_, user = reconcileUser(t, r, "empty")
+	requireCondition(t, user.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "InvalidSpec")
+	assert.False(t, f.called("POST /manage/v2/users"))
+
+	require.NoError(t, r.Create(context.Background(), passwordSecret("app-password", "secret")))
+	_, user = reconcileUser(t, r, "app")
+	requireCondition(t, user.Status.Conditions, marklogicv1alpha1.ConditionInSync, metav1.ConditionTrue, "Created")
+}
+
+func TestReconcileUserDeletion(t *testing.T) {
+	f := newFakeMarkLogic(t)
+	cluster, secret := availableCluster(f, "dnode", 1, 1)
+	user := newUser("app", "dnode")
+	user.Spec.DeletionPolicy = marklogicv1alpha1.DeletionPolicyDelete
+	r := newUserReconciler(f, cluster, secret, passwordSecret("app-password", "secret"), user)
+	_, user = reconcileUser(t, r, "app")
+
+	require.NoError(t, r.Delete(context.Background(), user))
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(user)})
+	require.NoError(t, err)
+	require.Error(t, r.Get(context.Background(), client.ObjectKeyFromObject(user), user), "finalizer is removed")
+	assert.Nil(t, f.get("users", "app"))
+}
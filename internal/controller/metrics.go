@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+const (
+	exporterContainerName = "exporter"
+	metricsPortName       = "metrics"
+)
+
+// serviceMonitorGVK is the ServiceMonitor of the Prometheus Operator. The
+// operator does not depend on its Go types, the CRD may not be installed.
+var serviceMonitorGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "ServiceMonitor"}
+
+// metricsName returns the Service and ServiceMonitor of the exporters.
+func metricsName(c *marklogicv1alpha1.MarkLogicCluster) string {
+	return c.Name + "-metrics"
+}
+
+func metricsLabels(c *marklogicv1alpha1.MarkLogicCluster) map[string]string {
+	l := labels(c)
+	l["app.kubernetes.io/component"] = metricsPortName
+	return l
+}
+
+// exporterEnabled reports whether the pods run the exporter, which is part of
+// the agent.
+func exporterEnabled(c *marklogicv1alpha1.MarkLogicCluster) bool {
+	return c.Spec.Metrics.Enabled && c.Spec.Agent.Image != ""
+}
+
+// exporterContainer serves the metrics of the MarkLogic host of the pod. It
+// reads the admin credentials and the CA of the cluster from the volumes of
+// the MarkLogic container and the host settings from the same config map.
+func exporterContainer(c *marklogicv1alpha1.MarkLogicCluster) corev1.Container {
+	m := c.Spec.Metrics
+	mounts := []corev1.VolumeMount{{Name: volumeAdminSecrets, MountPath: secretsMountPath, ReadOnly: true}}
+	if c.Spec.TLS.EnableOnDefaultAppServers {
+		mounts = append(mounts, corev1.VolumeMount{Name: volumeCerts, MountPath: certsMountPath, ReadOnly: true})
+	}
+	return corev1.Container{
+		Name:            exporterContainerName,
+		Image:           c.Spec.Agent.Image,
+		ImagePullPolicy: c.Spec.Agent.PullPolicy,
+		Command:         []string{agentBinary, "exporter", "--secrets-dir", secretsMountPath, "--listen-address", fmt.Sprintf(":%d", m.Port)},
+		EnvFrom:         []corev1.EnvFromSource{envConfigMapRef(c)},
+		Ports:           []corev1.ContainerPort{{Name: metricsPortName, ContainerPort: m.Port, Protocol: corev1.ProtocolTCP}},
+		VolumeMounts:    mounts,
+		Resources:       m.Resources,
+	}
+}
+
+// +kubebuilder:rbac:groups=monitoring.coreos.com,resources=servicemonitors,verbs=get;list;watch;create;update;patch;delete
+
+// reconcileMetrics maintains the headless Service exposing the exporters of
+// the pods and the ServiceMonitor scraping it, like the HAProxy subchart of
+// the chart. The ServiceMonitor is skipped when the Prometheus Operator is
+// not installed.
+func (r *MarkLogicClusterReconciler) reconcileMetrics(ctx context.Context, c *marklogicv1alpha1.MarkLogicCluster) error {
+	svc := &corev1.Service{ObjectMeta: r.objectMeta(c, metricsName(c))}
+	sm := &unstructured.Unstructured{}
+	sm.SetGroupVersionKind(serviceMonitorGVK)
+	sm.SetNamespace(c.Namespace)
+	sm.SetName(metricsName(c))
+
+	if !exporterEnabled(c) || !c.Spec.Metrics.ServiceMonitor.Enabled {
+		if err := r.deleteOwned(ctx, c, sm); err != nil && !meta.IsNoMatchError(err) {
+			return err
+		}
+	}
+	if !exporterEnabled(c) {
+		return r.deleteOwned(ctx, c, svc)
+	}
+	if err := r.createOrUpdate(ctx, c, svc, func() error {
+		svc.Labels = mergeMaps(svc.Labels, metricsLabels(c))
+		svc.Spec.ClusterIP = corev1.ClusterIPNone
+		svc.Spec.Selector = selectorLabels(c)
+		svc.Spec.Ports = []corev1.ServicePort{{
+			Name:       metricsPortName,
+			Port:       c.Spec.Metrics.Port,
+			TargetPort: intstr.FromString(metricsPortName),
+			Protocol:   corev1.ProtocolTCP,
+		}}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if !c.Spec.Metrics.ServiceMonitor.Enabled {
+		return nil
+	}
+
+	err := r.createOrUpdate(ctx, c, sm, func() error {
+		sm.SetLabels(mergeMaps(mergeMaps(sm.GetLabels(), metricsLabels(c)), c.Spec.Metrics.ServiceMonitor.ExtraLabels))
+		return unstructured.SetNestedField(sm.Object, serviceMonitorSpec(c), "spec")
+	})
+	if meta.IsNoMatchError(err) {
+		log.FromContext(ctx).Info("the ServiceMonitor CRD is not installed, skipping the ServiceMonitor", "name", sm.GetName())
+		return nil
+	}
+	return err
+}
+
+// serviceMonitorSpec scrapes the metrics port of the pods behind the metrics
+// Service of the cluster.
+func serviceMonitorSpec(c *marklogicv1alpha1.MarkLogicCluster) map[string]interface{} {
+	selector := map[string]interface{}{}
+	for k, v := range metricsLabels(c) {
+		selector[k] = v
+	}
+	return map[string]interface{}{
+		"selector":          map[string]interface{}{"matchLabels": selector},
+		"namespaceSelector": map[string]interface{}{"matchNames": []interface{}{c.Namespace}},
+		"endpoints": []interface{}{map[string]interface{}{
+			"port":          metricsPortName,
+			"path":          "/metrics",
+			"interval":      c.Spec.Metrics.ServiceMonitor.Interval,
+			"scrapeTimeout": c.Spec.Metrics.ServiceMonitor.ScrapeTimeout,
+		}},
+	}
+}
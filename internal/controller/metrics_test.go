@@ -0,0 +1,132 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+func metricsCluster() *marklogicv1alpha1.MarkLogicCluster {
+	cluster := newCluster("dnode")
+	cluster.Spec.Agent.Image = "marklogic-operator:latest"
+	cluster.Spec.Metrics.Enabled = true
+	cluster.Spec.Metrics.ServiceMonitor.Enabled = true
+	cluster.Spec.Metrics.ServiceMonitor.ExtraLabels = map[string]string{"release": "prometheus"}
+	return cluster
+}
+
+// newReconcilerWithoutServiceMonitors returns a reconciler of a cluster
+// where the CRDs of the Prometheus Operator are not installed.
+func newReconcilerWithoutServiceMonitors(cluster *marklogicv1alpha1.MarkLogicCluster) *MarkLogicClusterReconciler {
+	noMatch := func(obj client.Object) error {
+		if obj.GetObjectKind().GroupVersionKind() == serviceMonitorGVK {
+			return &meta.NoKindMatchError{GroupKind: serviceMonitorGVK.GroupKind(), SearchedVersions: []string{serviceMonitorGVK.Version}}
+		}
+		return nil
+	}
+	s := testScheme()
+	c := fake.NewClientBuilder().
+		WithScheme(s).
+		WithObjects(cluster).
+		WithStatusSubresource(&marklogicv1alpha1.MarkLogicCluster{}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				if err := noMatch(obj); err != nil {
+					return err
+				}
+				return c.Get(ctx, key, obj, opts...)
+			},
+			Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+				if err := noMatch(obj); err != nil {
+					return err
+				}
+				return c.Create(ctx, obj, opts...)
+			},
+		}).
+		Build()
+	return &MarkLogicClusterReconciler{Client: c, Scheme: s}
+}
+
+func TestReconcileExportsMetrics(t *testing.T) {
+	r := newReconciler(metricsCluster())
+	reconcile(t, r, "dnode")
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "marklogic", Name: "dnode-metrics"}
+
+	sts := &appsv1.StatefulSet{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode"}, sts))
+	containers := sts.Spec.Template.Spec.Containers
+	require.Len(t, containers, 2)
+	exporter := containers[1]
+	assert.Equal(t, "marklogic-operator:latest", exporter.Image)
+	assert.Equal(t, []string{"/agent", "exporter", "--secrets-dir", secretsMountPath, "--listen-address", ":9106"}, exporter.Command)
+	assert.Equal(t, "metrics", exporter.Ports[0].Name)
+	assert.Equal(t, containers[0].EnvFrom, exporter.EnvFrom)
+	assert.Equal(t, []corev1.VolumeMount{{Name: volumeAdminSecrets, MountPath: secretsMountPath, ReadOnly: true}}, exporter.VolumeMounts)
+
+	svc := &corev1.Service{}
+	require.NoError(t, r.Get(ctx, key, svc))
+	assert.Equal(t, corev1.ClusterIPNone, svc.Spec.ClusterIP)
+	assert.Equal(t, selectorLabels(metricsCluster()), svc.Spec.Selector)
+	assert.Equal(t, int32(9106), svc.Spec.Ports[0].Port)
+
+	sm := &unstructured.Unstructured{}
+	sm.SetGroupVersionKind(serviceMonitorGVK)
+	require.NoError(t, r.Get(ctx, key, sm))
+	assert.Equal(t, "prometheus", sm.GetLabels()["release"])
+	matchLabels, _, _ := unstructured.NestedStringMap(sm.Object, "spec", "selector", "matchLabels")
+	assert.Equal(t, svc.Labels, matchLabels, "the ServiceMonitor selects the metrics Service only")
+	endpoints, _, _ := unstructured.NestedSlice(sm.Object, "spec", "endpoints")
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"port": "metrics", "path": "/metrics", "interval": "30s", "scrapeTimeout": "10s",
+	}}, endpoints)
+
+	current := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode"}, current))
+	current.Spec.Metrics.Enabled = false
+	require.NoError(t, r.Update(ctx, current))
+	reconcile(t, r, "dnode")
+	assert.True(t, apierrors.IsNotFound(r.Get(ctx, key, sm)))
+	assert.True(t, apierrors.IsNotFound(r.Get(ctx, key, svc)))
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "marklogic", Name: "dnode"}, sts))
+	assert.Len(t, sts.Spec.Template.Spec.Containers, 1)
+}
+
+func TestReconcileSkipsServiceMonitorWithoutPrometheusOperator(t *testing.T) {
+	r := newReconcilerWithoutServiceMonitors(metricsCluster())
+	reconcile(t, r, "dnode")
+	key := types.NamespacedName{Namespace: "marklogic", Name: "dnode-metrics"}
+	require.NoError(t, r.Get(context.Background(), key, &corev1.Service{}))
+	sm := &unstructured.Unstructured{}
+	sm.SetGroupVersionKind(serviceMonitorGVK)
+	assert.True(t, meta.IsNoMatchError(r.Get(context.Background(), key, sm)))
+
+	// Clusters without metrics do not look for the ServiceMonitor either.
+	current := &marklogicv1alpha1.MarkLogicCluster{}
+	require.NoError(t, r.Get(context.Background(), types.NamespacedName{Namespace: "marklogic", Name: "dnode"}, current))
+	current.Spec.Metrics.Enabled = false
+	require.NoError(t, r.Update(context.Background(), current))
+	reconcile(t, r, "dnode")
+
+	// The exporter is part of the agent.
+	cluster := metricsCluster()
+	cluster.Spec.Agent.Image = ""
+	r = newReconciler(cluster)
+	reconcile(t, r, "dnode")
+	require.NoError(t, r.Get(context.Background(), types.NamespacedName{Namespace: "marklogic", Name: "dnode"}, current))
+	requireCondition(t, current.Status.Conditions, marklogicv1alpha1.ConditionReconciled, metav1.ConditionFalse, "InvalidSpec")
+}
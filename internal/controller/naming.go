@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+// The names below follow the helpers in charts/templates/_helpers.tpl for new installations,
+// so a cluster created by the operator gets the same hostnames as one installed with the chart.
+
+const (
+	appName   = "marklogic"
+	managedBy = "marklogic-operator"
+
+	annotationGroupName       = "marklogic.com/group-name"
+	annotationGroupXdqpSsl    = "marklogic.com/group-xdqp-enabled"
+	annotationClusterName     = "marklogic.com/cluster-name"
+	annotationFQDN            = "marklogic.com/fqdn"
+	annotationAppName         = "app.kubernetes.io/name"
+	annotationScriptsChecksum = "marklogic.com/scripts-checksum"
+	annotationScheduledTime   = "marklogic.com/scheduled-time"
+
+	// Bootstrap progress reported by the agent on the pods.
+	annotationBootstrapPhase = "marklogic.com/bootstrap-phase"
+	annotationBootstrapError = "marklogic.com/bootstrap-error"
+	annotationBootstrapTime  = "marklogic.com/bootstrap-time"
+)
+
+func headlessServiceName(c *marklogicv1alpha1.MarkLogicCluster) string {
+	return c.Name
+}
+
+func clusterServiceName(c *marklogicv1alpha1.MarkLogicCluster) string {
+	return c.Name + "-cluster"
+}
+
+func scriptsConfigMapName(c *marklogicv1alpha1.MarkLogicCluster) string {
+	return c.Name + "-scripts"
+}
+
+func authSecretName(c *marklogicv1alpha1.MarkLogicCluster) string {
+	if c.Spec.Auth.SecretName != "" {
+		return c.Spec.Auth.SecretName
+	}
+	return c.Name + "-admin"
+}
+
+// activeAuthSecretName returns the secret holding the admin credentials
+// MarkLogic currently accepts. The pods mount it instead of the auth secret,
+// which may hold a new password that is not rotated yet.
+func activeAuthSecretName(c *marklogicv1alpha1.MarkLogicCluster) string {
+	return c.Name + "-admin-active"
+}
+
+func serviceAccountName(c *marklogicv1alpha1.MarkLogicCluster) string {
+	if c.Spec.ServiceAccountName != "" {
+		return c.Spec.ServiceAccountName
+	}
+	return c.Name
+}
+
+func headlessURL(c *marklogicv1alpha1.MarkLogicCluster) string {
+	return fmt.Sprintf("%s.%s.svc.%s", headlessServiceName(c), c.Namespace, c.Spec.ClusterDomain)
+}
+
+// hostFQDN returns the fully qualified hostname of the pod with the given ordinal.
+func hostFQDN(c *marklogicv1alpha1.MarkLogicCluster, ordinal int) string {
+	return podName(c, ordinal) + "." + headlessURL(c)
+}
+
+func podName(c *marklogicv1alpha1.MarkLogicCluster, ordinal int) string {
+	return c.Name + "-" + strconv.Itoa(ordinal)
+}
+
+// podOrdinal returns the StatefulSet ordinal of the named pod, -1 for pods of
+// other workloads.
+func podOrdinal(name string) int {
+	i := strings.LastIndexByte(name, '-')
+	if i < 0 {
+		return -1
+	}
+	n, err := strconv.Atoi(name[i+1:])
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func fqdn(c *marklogicv1alpha1.MarkLogicCluster) string {
+	return hostFQDN(c, 0)
+}
+
+func clusterName(c *marklogicv1alpha1.MarkLogicCluster) string {
+	if c.Spec.BootstrapHostName != "" {
+		return c.Spec.BootstrapHostName
+	}
+	return fqdn(c)
+}
+
+func selectorLabels(c *marklogicv1alpha1.MarkLogicCluster) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":     appName,
+		"app.kubernetes.io/instance": c.Name,
+	}
+}
+
+func labels(c *marklogicv1alpha1.MarkLogicCluster) map[string]string {
+	l := selectorLabels(c)
+	l["app.kubernetes.io/managed-by"] = managedBy
+	return l
+}
+
+func annotations(c *marklogicv1alpha1.MarkLogicCluster) map[string]string {
+	return map[string]string{
+		annotationGroupName:    c.Spec.Group.Name,
+		annotationGroupXdqpSsl: strconv.FormatBool(*c.Spec.Group.EnableXdqpSsl),
+		annotationClusterName:  clusterName(c),
+		annotationAppName:      appName,
+		annotationFQDN:         fqdn(c),
+	}
+}
@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"sort"
+)
+
+// The hook scripts mounted into the MarkLogic pods. They are copies of the scripts in
+// charts/templates/configmap-scripts.yaml and must be kept in sync with the chart.
+//
+//go:embed scripts/*.sh
+var scriptsFS embed.FS
+
+// scriptsData returns the content of the scripts config map keyed by file name.
+func scriptsData() map[string]string {
+	entries, err := fs.ReadDir(scriptsFS, "scripts")
+	if err != nil {
+		panic(err)
+	}
+	data := make(map[string]string, len(entries))
+	for _, e := range entries {
+		b, err := scriptsFS.ReadFile("scripts/" + e.Name())
+		if err != nil {
+			panic(err)
+		}
+		data[e.Name()] = string(b)
+	}
+	return data
+}
+
+// checksum returns a stable digest of the given config map data.
+func checksum(data map[string]string) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(data[k]))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
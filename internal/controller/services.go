@@ -0,0 +1,40 @@
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+// mutateHeadlessService sets the desired state of the headless service that gives every
+// MarkLogic pod a stable DNS name, including pods that are not ready yet.
+func mutateHeadlessService(c *marklogicv1alpha1.MarkLogicCluster, svc *corev1.Service) {
+	svc.Labels = mergeMaps(svc.Labels, labels(c))
+	svc.Spec.ClusterIP = corev1.ClusterIPNone
+	svc.Spec.PublishNotReadyAddresses = true
+	svc.Spec.Selector = selectorLabels(c)
+	svc.Spec.Ports = servicePorts(c)
+}
+
+// mutateClusterService sets the desired state of the service used to access the cluster.
+func mutateClusterService(c *marklogicv1alpha1.MarkLogicCluster, svc *corev1.Service) {
+	svc.Labels = mergeMaps(svc.Labels, labels(c))
+	svc.Annotations = mergeMaps(svc.Annotations, c.Spec.Service.Annotations)
+	svc.Spec.Selector = selectorLabels(c)
+	svc.Spec.Type = c.Spec.Service.Type
+	svc.Spec.Ports = servicePorts(c)
+}
+
+func servicePorts(c *marklogicv1alpha1.MarkLogicCluster) []corev1.ServicePort {
+	ports := make([]corev1.ServicePort, 0, len(markLogicPorts)+len(c.Spec.Service.AdditionalPorts))
+	for _, p := range markLogicPorts {
+		ports = append(ports, corev1.ServicePort{
+			Name:       p.name,
+			Port:       p.port,
+			TargetPort: intstr.FromInt32(p.port),
+			Protocol:   corev1.ProtocolTCP,
+		})
+	}
+	return append(ports, c.Spec.Service.AdditionalPorts...)
+}
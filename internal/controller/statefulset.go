@@ -0,0 +1,460 @@
+package controller
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+const (
+	containerName = "marklogic-server"
+
+	volumeData          = "datadir"
+	volumeAdminSecrets  = "mladmin-secrets"
+	volumeCerts         = "certs"
+	volumeCACert        = "ca-cert-secret"
+	volumeServerCerts   = "server-cert-secrets"
+	volumeHugePages     = "huge-pages"
+	volumeHelmScripts   = "helm-scripts"
+	volumeAgent         = "marklogic-agent"
+	volumeVaultCA       = "vault-ca"
+	scriptsMountPath    = "/tmp/helm-scripts"
+	agentMountPath      = "/opt/marklogic-agent"
+	agentBinary         = "/agent"
+	secretsMountPath    = "/run/secrets/ml-secrets"
+	certsMountPath      = "/run/secrets/marklogic-certs/"
+	vaultCAMountPath    = "/run/secrets/vault-ca"
+	dataMountPath       = "/var/opt/MarkLogic"
+	usernameFileEnvPath = "ml-secrets/username"
+	passwordFileEnvPath = "ml-secrets/password"
+)
+
+// markLogicPorts are the ports every MarkLogic host listens on.
+var markLogicPorts = []struct {
+	name string
+	port int32
+}{
+	{"health-check", 7997},
+	{"xdqp-port1", 7998},
+	{"xdqp-port2", 7999},
+	{"app-services", 8000},
+	{"admin", 8001},
+	{"manage", 8002},
+}
+
+// defaultTopologySpreadConstraints are the constraints of charts/values.yaml, evenly distributing
+// MarkLogic pods across worker nodes and zones.
+func defaultTopologySpreadConstraints() []corev1.TopologySpreadConstraint {
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app.kubernetes.io/name": appName}}
+	return []corev1.TopologySpreadConstraint{
+		{
+			MaxSkew:           1,
+			TopologyKey:       corev1.LabelHostname,
+			WhenUnsatisfiable: corev1.DoNotSchedule,
+			LabelSelector:     selector,
+		},
+		{
+			MaxSkew:           1,
+			TopologyKey:       corev1.LabelTopologyZone,
+			WhenUnsatisfiable: corev1.ScheduleAnyway,
+			LabelSelector:     selector.DeepCopy(),
+		},
+	}
+}
+
+// mutateStatefulSet sets the desired state of the MarkLogic StatefulSet on sts. Immutable fields
+// are only set when the StatefulSet is created.
+func mutateStatefulSet(c *marklogicv1alpha1.MarkLogicCluster, sts *appsv1.StatefulSet, scriptsChecksum string) {
+	sts.Labels = mergeMaps(sts.Labels, labels(c))
+	sts.Annotations = mergeMaps(sts.Annotations, annotations(c))
+
+	if sts.CreationTimestamp.IsZero() {
+		sts.Spec.ServiceName = headlessServiceName(c)
+		sts.Spec.PodManagementPolicy = appsv1.ParallelPodManagement
+		sts.Spec.Selector = &metav1.LabelSelector{MatchLabels: selectorLabels(c)}
+		sts.Spec.VolumeClaimTemplates = volumeClaimTemplates(c)
+	}
+	sts.Spec.Replicas = ptr.To(*c.Spec.ReplicaCount)
+	sts.Spec.UpdateStrategy = appsv1.StatefulSetUpdateStrategy{Type: c.Spec.UpdateStrategy.Type}
+	sts.Spec.Template = podTemplate(c, scriptsChecksum)
+}
+
+func podTemplate(c *marklogicv1alpha1.MarkLogicCluster, scriptsChecksum string) corev1.PodTemplateSpec {
+	podAnnotations := mergeMaps(map[string]string{annotationScriptsChecksum: scriptsChecksum}, c.Spec.PodAnnotations)
+
+	topology := c.Spec.TopologySpreadConstraints
+	if len(topology) == 0 {
+		topology = defaultTopologySpreadConstraints()
+	}
+
+	podSecurityContext := c.Spec.PodSecurityContext
+	if podSecurityContext == nil {
+		policy := corev1.FSGroupChangeOnRootMismatch
+		podSecurityContext = &corev1.PodSecurityContext{
+			FSGroup:             ptr.To[int64](2),
+			FSGroupChangePolicy: &policy,
+		}
+	}
+
+	var initContainers []corev1.Container
+	if c.Spec.Auth.Provider == marklogicv1alpha1.CredentialsProviderVault {
+		initContainers = append(initContainers, fetchCredentialsContainer(c))
+	}
+	if c.Spec.Agent.Image != "" {
+		initContainers = append(initContainers, installAgentContainer(c))
+	}
+	if c.Spec.TLS.EnableOnDefaultAppServers {
+		initContainers = append(initContainers, copyCertsContainer(c))
+	}
+
+	containers := []corev1.Container{markLogicContainer(c)}
+	if exporterEnabled(c) {
+		containers = append(containers, exporterContainer(c))
+	}
+	if logShipperEnabled(c) {
+		containers = append(containers, logShipperContainer(c))
+	}
+
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      selectorLabels(c),
+			Annotations: podAnnotations,
+		},
+		Spec: corev1.PodSpec{
+			SecurityContext:               podSecurityContext,
+			ServiceAccountName:            serviceAccountName(c),
+			Affinity:                      c.Spec.Affinity,
+			TopologySpreadConstraints:     topology,
+			TerminationGracePeriodSeconds: c.Spec.TerminationGracePeriod,
+			InitContainers:                initContainers,
+			Containers:                    containers,
+			PriorityClassName:             c.Spec.PriorityClassName,
+			NodeSelector:                  c.Spec.NodeSelector,
+			ImagePullSecrets:              c.Spec.ImagePullSecrets,
+			DNSConfig: &corev1.PodDNSConfig{
+				Searches: []string{headlessURL(c)},
+			},
+			Volumes: volumes(c),
+		},
+	}
+}
+
+func markLogicContainer(c *marklogicv1alpha1.MarkLogicCluster) corev1.Container {
+	mounts := []corev1.VolumeMount{
+		{Name: volumeData, MountPath: dataMountPath},
+		{Name: volumeAdminSecrets, MountPath: secretsMountPath, ReadOnly: true},
+	}
+	if c.Spec.TLS.EnableOnDefaultAppServers {
+		mounts = append(mounts, corev1.VolumeMount{Name: volumeCerts, MountPath: certsMountPath})
+	}
+	if c.Spec.HugePages.Enabled {
+		mounts = append(mounts, corev1.VolumeMount{Name: volumeHugePages, MountPath: c.Spec.HugePages.MountPath})
+	}
+	mounts = append(mounts, corev1.VolumeMount{Name: volumeHelmScripts, MountPath: scriptsMountPath})
+	postStart := []string{"/bin/bash", scriptsMountPath + "/poststart-hook.sh"}
+	preStop := []string{"/bin/bash", scriptsMountPath + "/prestop-hook.sh"}
+	if c.Spec.Agent.Image != "" {
+		mounts = append(mounts, corev1.VolumeMount{Name: volumeAgent, MountPath: agentMountPath, ReadOnly: true})
+		postStart = []string{agentMountPath + agentBinary, "bootstrap"}
+		// The drain budgets its waits within the grace period of the pod.
+		preStop = []string{agentMountPath + agentBinary, "drain", "--grace-period", fmt.Sprintf("%ds", *c.Spec.TerminationGracePeriod)}
+	}
+
+	env := []corev1.EnvVar{
+		{Name: "MARKLOGIC_ADMIN_USERNAME_FILE", Value: usernameFileEnvPath},
+		{Name: "MARKLOGIC_ADMIN_PASSWORD_FILE", Value: passwordFileEnvPath},
+		podNameEnv(),
+		{Name: "INSTALL_CONVERTERS", Value: fmt.Sprint(c.Spec.EnableConverters)},
+		{Name: "LICENSE_KEY", Value: c.Spec.License.Key},
+		{Name: "LICENSEE", Value: c.Spec.License.Licensee},
+		{Name: "REALM", Value: c.Spec.Realm},
+		{Name: "MARKLOGIC_GROUP", Value: c.Spec.Group.Name},
+	}
+
+	ports := make([]corev1.ContainerPort, 0, len(markLogicPorts))
+	for _, p := range markLogicPorts {
+		ports = append(ports, corev1.ContainerPort{Name: p.name, ContainerPort: p.port, Protocol: corev1.ProtocolTCP})
+	}
+
+	securityContext := c.Spec.ContainerSecurityContext
+	if securityContext == nil {
+		securityContext = &corev1.SecurityContext{
+			RunAsUser:                ptr.To[int64](1000),
+			RunAsNonRoot:             ptr.To(true),
+			AllowPrivilegeEscalation: ptr.To(false),
+		}
+	}
+
+	container := corev1.Container{
+		Name:            containerName,
+		Image:           c.Spec.Image.Repository + ":" + c.Spec.Image.Tag,
+		ImagePullPolicy: c.Spec.Image.PullPolicy,
+		VolumeMounts:    mounts,
+		Env:             env,
+		EnvFrom:         []corev1.EnvFromSource{envConfigMapRef(c)},
+		Ports:           ports,
+		Lifecycle: &corev1.Lifecycle{
+			PostStart: &corev1.LifecycleHandler{
+				Exec: &corev1.ExecAction{Command: postStart},
+			},
+			PreStop: &corev1.LifecycleHandler{
+				Exec: &corev1.ExecAction{Command: preStop},
+			},
+		},
+		SecurityContext: securityContext,
+		Resources:       c.Spec.Resources,
+	}
+	if c.Spec.Agent.Image != "" {
+		// The agent tells hosts that are up from hosts that serve the cluster.
+		if *c.Spec.LivenessProbe.Enabled {
+			container.LivenessProbe = probe(c.Spec.LivenessProbe, agentProbe("liveness", c.Spec.LivenessProbe))
+		}
+		if *c.Spec.ReadinessProbe.Enabled {
+			container.ReadinessProbe = probe(c.Spec.ReadinessProbe, agentProbe("readiness", c.Spec.ReadinessProbe))
+		}
+		if *c.Spec.StartupProbe.Enabled {
+			container.StartupProbe = probe(c.Spec.StartupProbe, agentProbe("startup", c.Spec.StartupProbe))
+		}
+		return container
+	}
+	if *c.Spec.LivenessProbe.Enabled {
+		container.LivenessProbe = probe(c.Spec.LivenessProbe, corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{Command: []string{"/bin/bash", scriptsMountPath + "/liveness-probe.sh"}},
+		})
+	}
+	if *c.Spec.ReadinessProbe.Enabled {
+		container.ReadinessProbe = probe(c.Spec.ReadinessProbe, corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{Path: "/", Port: intstr.FromString("health-check")},
+		})
+	}
+	return container
+}
+
+// installAgentContainer copies the agent binary into the volume shared with the MarkLogic container.
+func installAgentContainer(c *marklogicv1alpha1.MarkLogicCluster) corev1.Container {
+	return corev1.Container{
+		Name:            "install-agent",
+		Image:           c.Spec.Agent.Image,
+		ImagePullPolicy: c.Spec.Agent.PullPolicy,
+		Command:         []string{agentBinary, "install", agentMountPath},
+		VolumeMounts:    []corev1.VolumeMount{{Name: volumeAgent, MountPath: agentMountPath}},
+	}
+}
+
+// fetchCredentialsContainer writes the admin credentials read from Vault into
+// the in-memory volume the other containers read them from.
+func fetchCredentialsContainer(c *marklogicv1alpha1.MarkLogicCluster) corev1.Container {
+	v := c.Spec.Auth.Vault
+	env := []corev1.EnvVar{
+		{Name: "VAULT_ADDR", Value: v.Address},
+		{Name: "VAULT_SECRET_PATH", Value: v.Path},
+		{Name: "VAULT_ROLE", Value: v.Role},
+		{Name: "VAULT_AUTH_MOUNT", Value: v.AuthMount},
+	}
+	if v.Namespace != "" {
+		env = append(env, corev1.EnvVar{Name: "VAULT_NAMESPACE", Value: v.Namespace})
+	}
+	mounts := []corev1.VolumeMount{{Name: volumeAdminSecrets, MountPath: secretsMountPath}}
+	if v.CASecretName != "" {
+		env = append(env, corev1.EnvVar{Name: "VAULT_CACERT", Value: vaultCAMountPath + "/ca.crt"})
+		mounts = append(mounts, corev1.VolumeMount{Name: volumeVaultCA, MountPath: vaultCAMountPath, ReadOnly: true})
+	}
+	return corev1.Container{
+		Name:            "fetch-credentials",
+		Image:           c.Spec.Agent.Image,
+		ImagePullPolicy: c.Spec.Agent.PullPolicy,
+		Command:         []string{agentBinary, "credentials", "--dir", secretsMountPath},
+		Env:             env,
+		VolumeMounts:    mounts,
+	}
+}
+
+func copyCertsContainer(c *marklogicv1alpha1.MarkLogicCluster) corev1.Container {
+	var mounts []corev1.VolumeMount
+	if namedCertificates(c) {
+		mounts = append(mounts,
+			corev1.VolumeMount{Name: volumeCACert, MountPath: "/tmp/ca-cert-secret/"},
+			corev1.VolumeMount{Name: volumeServerCerts, MountPath: "/tmp/server-cert-secrets/"},
+		)
+	}
+	mounts = append(mounts,
+		corev1.VolumeMount{Name: volumeCerts, MountPath: certsMountPath},
+		corev1.VolumeMount{Name: volumeAdminSecrets, MountPath: secretsMountPath + "/", ReadOnly: true},
+		corev1.VolumeMount{Name: volumeHelmScripts, MountPath: scriptsMountPath},
+	)
+	container := corev1.Container{
+		Name:            "copy-certs",
+		Image:           c.Spec.InitContainers.UtilContainer.Image,
+		ImagePullPolicy: c.Spec.InitContainers.UtilContainer.PullPolicy,
+		Command:         []string{"/bin/sh", scriptsMountPath + "/copy-certs.sh"},
+		VolumeMounts:    mounts,
+		Env: []corev1.EnvVar{
+			podNameEnv(),
+			{Name: "MARKLOGIC_ADMIN_USERNAME_FILE", Value: usernameFileEnvPath},
+			{Name: "MARKLOGIC_ADMIN_PASSWORD_FILE", Value: passwordFileEnvPath},
+		},
+		EnvFrom: []corev1.EnvFromSource{envConfigMapRef(c)},
+	}
+	// The agent matches certificates on their DNS names too, and accepts
+	// any key type and chained certificates.
+	if c.Spec.Agent.Image != "" {
+		container.Image = c.Spec.Agent.Image
+		container.ImagePullPolicy = c.Spec.Agent.PullPolicy
+		container.Command = []string{agentBinary, "copy-certs"}
+	}
+	return container
+}
+
+func volumes(c *marklogicv1alpha1.MarkLogicCluster) []corev1.Volume {
+	var vols []corev1.Volume
+	if c.Spec.TLS.EnableOnDefaultAppServers {
+		vols = append(vols, corev1.Volume{Name: volumeCerts, VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}})
+		if c.Spec.TLS.CertManager != nil {
+			// The certificates are collected into one secret, so the pod
+			// template does not change with the number of replicas.
+			vols = append(vols,
+				corev1.Volume{
+					Name:         volumeCACert,
+					VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: caSecretName(c)}},
+				},
+				corev1.Volume{
+					Name:         volumeServerCerts,
+					VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: serverCertsSecretName(c)}},
+				},
+			)
+		} else if len(c.Spec.TLS.CertSecretNames) > 0 {
+			vols = append(vols, corev1.Volume{
+				Name:         volumeCACert,
+				VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: c.Spec.TLS.CASecretName}},
+			})
+			sources := make([]corev1.VolumeProjection, 0, len(c.Spec.TLS.CertSecretNames))
+			for i, name := range c.Spec.TLS.CertSecretNames {
+				sources = append(sources, corev1.VolumeProjection{Secret: &corev1.SecretProjection{
+					LocalObjectReference: corev1.LocalObjectReference{Name: name},
+					Items: []corev1.KeyToPath{
+						{Key: "tls.crt", Path: fmt.Sprintf("tls_%d.crt", i)},
+						{Key: "tls.key", Path: fmt.Sprintf("tls_%d.key", i)},
+					},
+				}})
+			}
+			vols = append(vols, corev1.Volume{
+				Name:         volumeServerCerts,
+				VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{Sources: sources}},
+			})
+		}
+	}
+	vols = append(vols, adminSecretsVolume(c))
+	if v := c.Spec.Auth.Vault; c.Spec.Auth.Provider == marklogicv1alpha1.CredentialsProviderVault && v.CASecretName != "" {
+		vols = append(vols, corev1.Volume{
+			Name:         volumeVaultCA,
+			VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: v.CASecretName}},
+		})
+	}
+	if c.Spec.HugePages.Enabled {
+		vols = append(vols, corev1.Volume{
+			Name:         volumeHugePages,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumHugePages}},
+		})
+	}
+	if !*c.Spec.Persistence.Enabled {
+		vols = append(vols, corev1.Volume{Name: volumeData, VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}})
+	}
+	vols = append(vols, corev1.Volume{
+		Name: volumeHelmScripts,
+		VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: scriptsConfigMapName(c)},
+			DefaultMode:          ptr.To[int32](0755),
+		}},
+	})
+	if c.Spec.Agent.Image != "" {
+		vols = append(vols, corev1.Volume{Name: volumeAgent, VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}})
+	}
+	return vols
+}
+
+// adminSecretsVolume returns the volume holding the admin credentials of the
+// credentials provider of the cluster. Credentials read from Vault are kept
+// in memory only.
+func adminSecretsVolume(c *marklogicv1alpha1.MarkLogicCluster) corev1.Volume {
+	v := corev1.Volume{Name: volumeAdminSecrets}
+	switch c.Spec.Auth.Provider {
+	case marklogicv1alpha1.CredentialsProviderFile:
+		v.CSI = c.Spec.Auth.CSI
+	case marklogicv1alpha1.CredentialsProviderVault:
+		v.EmptyDir = &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory}
+	default:
+		v.Secret = &corev1.SecretVolumeSource{SecretName: activeAuthSecretName(c)}
+	}
+	return v
+}
+
+func volumeClaimTemplates(c *marklogicv1alpha1.MarkLogicCluster) []corev1.PersistentVolumeClaim {
+	if !*c.Spec.Persistence.Enabled {
+		return nil
+	}
+	pvc := corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        volumeData,
+			Labels:      selectorLabels(c),
+			Annotations: c.Spec.Persistence.Annotations,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: c.Spec.Persistence.AccessModes,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: *c.Spec.Persistence.Size},
+			},
+		},
+	}
+	if c.Spec.Persistence.StorageClass != "" {
+		pvc.Spec.StorageClassName = ptr.To(c.Spec.Persistence.StorageClass)
+	}
+	return []corev1.PersistentVolumeClaim{pvc}
+}
+
+func probe(p marklogicv1alpha1.Probe, handler corev1.ProbeHandler) *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler:        handler,
+		InitialDelaySeconds: *p.InitialDelaySeconds,
+		PeriodSeconds:       *p.PeriodSeconds,
+		TimeoutSeconds:      *p.TimeoutSeconds,
+		FailureThreshold:    *p.FailureThreshold,
+		SuccessThreshold:    *p.SuccessThreshold,
+	}
+}
+
+// agentProbe runs the probe of mode with the agent, bounded by the timeout of p.
+func agentProbe(mode string, p marklogicv1alpha1.Probe) corev1.ProbeHandler {
+	return corev1.ProbeHandler{Exec: &corev1.ExecAction{
+		Command: []string{agentMountPath + agentBinary, "probe", mode, "--timeout", fmt.Sprintf("%ds", *p.TimeoutSeconds)},
+	}}
+}
+
+func podNameEnv() corev1.EnvVar {
+	return corev1.EnvVar{
+		Name:      "POD_NAME",
+		ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}},
+	}
+}
+
+func envConfigMapRef(c *marklogicv1alpha1.MarkLogicCluster) corev1.EnvFromSource {
+	return corev1.EnvFromSource{
+		ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: c.Name}},
+	}
+}
+
+// mergeMaps returns dst with all entries of src added, allocating dst if needed.
+func mergeMaps(dst, src map[string]string) map[string]string {
+	if dst == nil {
+		dst = make(map[string]string, len(src))
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
@@ -0,0 +1,109 @@
+// Package credentials reads the admin credentials of a MarkLogic cluster from
+// where they are kept: a Kubernetes secret, files mounted by a CSI driver, or
+// the key/value API of a Vault server. Every source holds the keys username,
+// password and, optionally, wallet-password.
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Keys of the credentials in every source.
+const (
+	KeyUsername       = "username"
+	KeyPassword       = "password"
+	KeyWalletPassword = "wallet-password"
+)
+
+// Credentials are the admin credentials of a cluster.
+type Credentials struct {
+	Username string
+	Password string
+	// WalletPassword protects the keystore of the cluster, if set.
+	WalletPassword string
+}
+
+// Provider returns the admin credentials of a cluster.
+type Provider interface {
+	Credentials(ctx context.Context) (*Credentials, error)
+}
+
+// fromKeys builds credentials from the values of the keys, which must include
+// a username and a password.
+func fromKeys(source string, value func(key string) string) (*Credentials, error) {
+	creds := &Credentials{
+		Username:       strings.TrimSpace(value(KeyUsername)),
+		Password:       strings.TrimSpace(value(KeyPassword)),
+		WalletPassword: strings.TrimSpace(value(KeyWalletPassword)),
+	}
+	if creds.Username == "" || creds.Password == "" {
+		return nil, fmt.Errorf("%s has no %s and %s", source, KeyUsername, KeyPassword)
+	}
+	return creds, nil
+}
+
+// SecretProvider reads the credentials from a Kubernetes secret.
+type SecretProvider struct {
+	Client    client.Reader
+	Namespace string
+	Name      string
+}
+
+// Credentials implements Provider.
+func (p *SecretProvider) Credentials(ctx context.Context) (*Credentials, error) {
+	secret := &corev1.Secret{}
+	if err := p.Client.Get(ctx, types.NamespacedName{Namespace: p.Namespace, Name: p.Name}, secret); err != nil {
+		return nil, fmt.Errorf("reading secret %s: %w", p.Name, err)
+	}
+	return fromKeys("secret "+p.Name, func(key string) string { return string(secret.Data[key]) })
+}
+
+// FileProvider reads the credentials from one file per key in a directory,
+// such as a mounted secret or a volume of the Secrets Store CSI Driver.
+type FileProvider struct {
+	Dir string
+}
+
+// Credentials implements Provider.
+func (p *FileProvider) Credentials(_ context.Context) (*Credentials, error) {
+	var readErr error
+	creds, err := fromKeys("directory "+p.Dir, func(key string) string {
+		data, err := os.ReadFile(filepath.Join(p.Dir, key))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			readErr = err
+		}
+		return string(data)
+	})
+	if readErr != nil {
+		return nil, readErr
+	}
+	return creds, err
+}
+
+// WriteFiles writes creds into dir the way FileProvider reads them. The files
+// are readable by the group, which is the fsGroup of the pod on volumes.
+func WriteFiles(dir string, creds *Credentials) error {
+	files := map[string]string{
+		KeyUsername:       creds.Username,
+		KeyPassword:       creds.Password,
+		KeyWalletPassword: creds.WalletPassword,
+	}
+	for key, value := range files {
+		if value == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, key), []byte(value), 0o440); err != nil {
+			return err
+		}
+	}
+	return nil
+}
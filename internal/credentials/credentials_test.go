@@ -0,0 +1,156 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakeVault is a stand-in for the Vault HTTP API. It serves the secrets by
+// API path to requests carrying its token, which the Kubernetes auth method
+// hands out for the expected role and service account token.
+type fakeVault struct {
+	secrets map[string]interface{}
+	// logins counts the logins to the Kubernetes auth method.
+	logins int
+	// token is the client token accepted, vaultToken unless revoked.
+	token string
+}
+
+const (
+	vaultToken = "s.token"
+	vaultJWT   = "service-account-jwt"
+)
+
+func newFakeVault(t *testing.T, secrets map[string]interface{}) (*fakeVault, *httptest.Server) {
+	v := &fakeVault{secrets: secrets, token: vaultToken}
+	srv := httptest.NewServer(http.HandlerFunc(v.serve))
+	t.Cleanup(srv.Close)
+	return v, srv
+}
+
+func (v *fakeVault) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost && r.URL.Path == "/v1/auth/k8s/login" {
+		login := map[string]string{}
+		_ = json.NewDecoder(r.Body).Decode(&login)
+		if login["role"] != "marklogic" || login["jwt"] != vaultJWT {
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(map[string][]string{"errors": {"permission denied"}})
+			return
+		}
+		v.logins++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]interface{}{"client_token": v.token, "lease_duration": 3600}})
+		return
+	}
+	if r.Header.Get("X-Vault-Token") != v.token {
+		w.WriteHeader(http.StatusForbidden)
+		_ = json.NewEncoder(w).Encode(map[string][]string{"errors": {"permission denied"}})
+		return
+	}
+	data, ok := v.secrets[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(map[string][]string{"errors": {}})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func TestSecretProvider(t *testing.T) {
+	c := fake.NewClientBuilder().WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "admin", Namespace: "marklogic"},
+		Data:       map[string][]byte{"username": []byte("admin"), "password": []byte("secret\n")},
+	}).Build()
+	creds, err := (&SecretProvider{Client: c, Namespace: "marklogic", Name: "admin"}).Credentials(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &Credentials{Username: "admin", Password: "secret"}, creds)
+
+	_, err = (&SecretProvider{Client: c, Namespace: "marklogic", Name: "missing"}).Credentials(context.Background())
+	assert.ErrorContains(t, err, "reading secret missing")
+}
+
+func TestFileProvider(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, WriteFiles(dir, &Credentials{Username: "admin", Password: "secret", WalletPassword: "wallet"}))
+	creds, err := (&FileProvider{Dir: dir}).Credentials(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &Credentials{Username: "admin", Password: "secret", WalletPassword: "wallet"}, creds)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, KeyPassword)))
+	_, err = (&FileProvider{Dir: dir}).Credentials(context.Background())
+	assert.ErrorContains(t, err, "has no username and password")
+}
+
+func TestVaultProviderKV2(t *testing.T) {
+	v, srv := newFakeVault(t, map[string]interface{}{
+		"/v1/secret/data/marklogic": map[string]interface{}{
+			"data":     map[string]string{"username": "admin", "password": "secret", "wallet-password": "wallet"},
+			"metadata": map[string]interface{}{"version": 3},
+		},
+	})
+	jwt := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(jwt, []byte(vaultJWT+"\n"), 0o600))
+
+	p := &VaultProvider{Address: srv.URL, Path: "secret/data/marklogic", Role: "marklogic", AuthMount: "k8s", JWTFile: jwt}
+	creds, err := p.Credentials(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &Credentials{Username: "admin", Password: "secret", WalletPassword: "wallet"}, creds)
+	assert.Equal(t, 1, v.logins)
+
+	p.Role = "other"
+	_, err = p.Credentials(context.Background())
+	assert.ErrorContains(t, err, "logging in to vault as other")
+	assert.ErrorContains(t, err, "permission denied")
+}
+
+func TestVaultProviderCachesToken(t *testing.T) {
+	v, srv := newFakeVault(t, map[string]interface{}{
+		"/v1/kv/marklogic": map[string]string{"username": "admin", "password": "secret"},
+	})
+	jwt := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(jwt, []byte(vaultJWT), 0o600))
+	tokens := &TokenCache{}
+	provider := func() *VaultProvider {
+		return &VaultProvider{Address: srv.URL, Path: "kv/marklogic", Role: "marklogic", AuthMount: "k8s", JWTFile: jwt, Tokens: tokens}
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := provider().Credentials(context.Background())
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1, v.logins)
+
+	// A revoked token is replaced by a new login.
+	v.token = "s.other"
+	creds, err := provider().Credentials(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "secret", creds.Password)
+	assert.Equal(t, 2, v.logins)
+}
+
+func TestVaultProviderKV1(t *testing.T) {
+	v, srv := newFakeVault(t, map[string]interface{}{
+		"/v1/kv/marklogic": map[string]string{"username": "admin", "password": "secret"},
+	})
+	p := &VaultProvider{Address: srv.URL + "/", Path: "/kv/marklogic", Token: vaultToken}
+	creds, err := p.Credentials(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &Credentials{Username: "admin", Password: "secret"}, creds)
+	assert.Zero(t, v.logins)
+
+	p.Path = "kv/missing"
+	_, err = p.Credentials(context.Background())
+	assert.ErrorContains(t, err, "404")
+	_, err = (&VaultProvider{Address: srv.URL, Path: "kv/marklogic"}).Credentials(context.Background())
+	assert.ErrorContains(t, err, "needs a token or a role")
+}
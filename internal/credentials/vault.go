@@ -0,0 +1,239 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultJWTFile is the service account token a pod logs in to Vault with.
+const DefaultJWTFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// VaultProvider reads the credentials from the key/value secrets engine of a
+// Vault server, or any server implementing its HTTP API. Both versions of the
+// engine are supported: Path is the API path of the secret after /v1/, e.g.
+// secret/data/marklogic for version 2 and kv/marklogic for version 1.
+type VaultProvider struct {
+	// Address is the base URL of the server, e.g. https://vault.vault.svc:8200.
+	Address string
+	Path    string
+	// Token authenticates the requests. Without it the provider logs in with
+	// the Kubernetes auth method as Role.
+	Token string
+	Role  string
+	// AuthMount is the mount path of the Kubernetes auth method, kubernetes
+	// if empty.
+	AuthMount string
+	// JWTFile holds the service account token sent to the Kubernetes auth
+	// method, DefaultJWTFile if empty.
+	JWTFile string
+	// Namespace is the Vault Enterprise namespace of the secret, if any.
+	Namespace string
+	// HTTPClient sends the requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+	// Tokens keeps the client tokens of the logins for the providers sharing
+	// it. Every call logs in again if nil.
+	Tokens *TokenCache
+}
+
+// TokenCache holds client tokens of the Kubernetes auth method until their
+// lease runs out. It is safe for concurrent use; the zero value is empty.
+type TokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+}
+
+type cachedToken struct {
+	token   string
+	expires time.Time
+}
+
+func (c *TokenCache) get(key string) string {
+	if c == nil {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.tokens[key]
+	if !ok || !time.Now().Before(t.expires) {
+		return ""
+	}
+	return t.token
+}
+
+// put keeps token for most of its lease, so it is not used just as it expires.
+// Tokens without a lease are not kept.
+func (c *TokenCache) put(key, token string, lease time.Duration) {
+	if c == nil || lease <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tokens == nil {
+		c.tokens = map[string]cachedToken{}
+	}
+	c.tokens[key] = cachedToken{token: token, expires: time.Now().Add(lease - lease/10)}
+}
+
+func (c *TokenCache) drop(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tokens, key)
+}
+
+// Credentials implements Provider.
+func (p *VaultProvider) Credentials(ctx context.Context) (*Credentials, error) {
+	var resp struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := p.read(ctx, &resp); err != nil {
+		return nil, err
+	}
+	data := resp.Data
+	// Version 2 nests the values next to the metadata of the secret.
+	if _, ok := data["metadata"]; ok && data["data"] != nil {
+		var nested map[string]json.RawMessage
+		if err := json.Unmarshal(data["data"], &nested); err != nil {
+			return nil, fmt.Errorf("decoding vault secret %s: %w", p.Path, err)
+		}
+		data = nested
+	}
+	return fromKeys("vault secret "+p.Path, func(key string) string {
+		var s string
+		_ = json.Unmarshal(data[key], &s)
+		return s
+	})
+}
+
+// read reads the secret at Path into out. A cached client token Vault no
+// longer accepts, e.g. because it was revoked, is replaced by a new login.
+func (p *VaultProvider) read(ctx context.Context, out interface{}) error {
+	if p.Token != "" {
+		return p.do(ctx, http.MethodGet, p.Path, p.Token, nil, out)
+	}
+	key := p.cacheKey()
+	if token := p.Tokens.get(key); token != "" {
+		err := p.do(ctx, http.MethodGet, p.Path, token, nil, out)
+		var vaultErr *vaultError
+		if !errors.As(err, &vaultErr) || vaultErr.status != http.StatusForbidden {
+			return err
+		}
+		p.Tokens.drop(key)
+	}
+	token, lease, err := p.login(ctx)
+	if err != nil {
+		return err
+	}
+	p.Tokens.put(key, token, lease)
+	return p.do(ctx, http.MethodGet, p.Path, token, nil, out)
+}
+
+// cacheKey identifies the logins of the provider in Tokens.
+func (p *VaultProvider) cacheKey() string {
+	return strings.Join([]string{p.Address, p.Namespace, p.AuthMount, p.Role, p.jwtFile()}, "\x00")
+}
+
+func (p *VaultProvider) jwtFile() string {
+	if p.JWTFile == "" {
+		return DefaultJWTFile
+	}
+	return p.JWTFile
+}
+
+// login returns a client token of the Kubernetes auth method and its lease.
+func (p *VaultProvider) login(ctx context.Context) (string, time.Duration, error) {
+	if p.Role == "" {
+		return "", 0, errors.New("vault needs a token or a role to log in with")
+	}
+	jwt, err := os.ReadFile(p.jwtFile())
+	if err != nil {
+		return "", 0, fmt.Errorf("reading service account token: %w", err)
+	}
+	mount := p.AuthMount
+	if mount == "" {
+		mount = "kubernetes"
+	}
+	body, err := json.Marshal(map[string]string{"role": p.Role, "jwt": strings.TrimSpace(string(jwt))})
+	if err != nil {
+		return "", 0, err
+	}
+	var resp struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int64  `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	if err := p.do(ctx, http.MethodPost, "auth/"+strings.Trim(mount, "/")+"/login", "", body, &resp); err != nil {
+		return "", 0, fmt.Errorf("logging in to vault as %s: %w", p.Role, err)
+	}
+	if resp.Auth.ClientToken == "" {
+		return "", 0, fmt.Errorf("logging in to vault as %s: no client token", p.Role)
+	}
+	return resp.Auth.ClientToken, time.Duration(resp.Auth.LeaseDuration) * time.Second, nil
+}
+
+// do sends a request to /v1/path and decodes the JSON response into out.
+func (p *VaultProvider) do(ctx context.Context, method, path, token string, body []byte, out interface{}) error {
+	url := strings.TrimRight(p.Address, "/") + "/v1/" + strings.TrimLeft(path, "/")
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if p.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	httpClient := p.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.Unmarshal(data, &vaultErr)
+		return &vaultError{
+			status:  resp.StatusCode,
+			message: fmt.Sprintf("%s %s: %s %s", method, url, resp.Status, strings.Join(vaultErr.Errors, "; ")),
+		}
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("decoding response of %s: %w", url, err)
+	}
+	return nil
+}
+
+// vaultError is an error response of the Vault API.
+type vaultError struct {
+	status  int
+	message string
+}
+
+func (e *vaultError) Error() string {
+	return e.message
+}
@@ -0,0 +1,50 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNext(t *testing.T) {
+	from := time.Date(2024, time.February, 27, 10, 30, 15, 0, time.UTC) // a Tuesday
+	cases := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 2, 27, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 2, 27, 10, 45, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2024, 2, 28, 2, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 2, 28, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 2, 27, 11, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"30 1 * * sun", time.Date(2024, 3, 3, 1, 30, 0, 0, time.UTC)},
+		{"30 1 * * 7", time.Date(2024, 3, 3, 1, 30, 0, 0, time.UTC)},
+		{"0 12 * * mon-fri", time.Date(2024, 2, 27, 12, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: either matches.
+		{"0 0 10 * 3", time.Date(2024, 2, 28, 0, 0, 0, 0, time.UTC)},
+		{"10-20/5 8 * * *", time.Date(2024, 2, 28, 8, 10, 0, 0, time.UTC)},
+	}
+	for _, tc := range cases {
+		s, err := Parse(tc.spec)
+		require.NoError(t, err, tc.spec)
+		assert.Equal(t, tc.want, s.Next(from), tc.spec)
+	}
+}
+
+func TestNextNeverMatches(t *testing.T) {
+	s, err := Parse("0 0 30 2 *")
+	require.NoError(t, err)
+	assert.True(t, s.Next(time.Now()).IsZero())
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "*/0 * * * *", "5-1 * * * *", "@every 5m", "* * * foo *"} {
+		_, err := Parse(spec)
+		assert.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,313 @@
+// Package drain takes a MarkLogic host out of service before its pod stops.
+//
+// It is the Go implementation of the preStop hook of the chart. Unlike the
+// script, which shuts the host down and waits for MarkLogic to stop without
+// bound, the drain first moves every forest of the host to its synchronized
+// replica and spends at most the termination grace period of the pod doing
+// so, leaving the rest to the kubelet.
+package drain
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+	"github.com/marklogic/marklogic-kubernetes/internal/events"
+	"github.com/marklogic/marklogic-kubernetes/internal/hostclient"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// Options configures a Drainer.
+type Options struct {
+	// Host is the FQDN of the host to drain, the name MarkLogic knows it by.
+	Host string
+	// Username and Password are the MarkLogic admin credentials.
+	Username string
+	Password string
+	// TLSEnabled makes the drain use https for the Management API.
+	TLSEnabled bool
+	// GracePeriod is the termination grace period of the pod, which bounds
+	// the whole drain. Defaults to 120s.
+	GracePeriod time.Duration
+	// PollInterval is the delay between two checks of the forests or the
+	// host. Defaults to 2s.
+	PollInterval time.Duration
+	// Events records the outcome of the drain as Kubernetes Events, if set.
+	Events events.Recorder
+}
+
+// eventTimeout bounds the recording of the Events, which happens after the
+// drain may have used up its budget.
+const eventTimeout = 5 * time.Second
+
+// stopMargin is the part of the grace period left to the kubelet to stop the
+// container after the hook returns.
+const stopMargin = 5 * time.Second
+
+// Budget splits the grace period of the pod between waiting for the replicas
+// to take over and waiting for MarkLogic to shut down, keeping a margin for
+// the kubelet. Failover gets two thirds of the time as a shutdown with no
+// forests left is quick.
+func Budget(grace time.Duration) (failover, shutdown time.Duration) {
+	usable := grace - stopMargin
+	if usable <= 0 {
+		return 0, 0
+	}
+	failover = usable * 2 / 3
+	return failover, usable - failover
+}
+
+// Summary is the outcome of a drain.
+type Summary struct {
+	Host string
+	// Forests is the number of forests the host served.
+	Forests int
+	// FailedOver are the forests whose replica took over.
+	FailedOver []string
+	// Unprotected are the forests without a synchronized replica. Their
+	// databases are unavailable until the host is back.
+	Unprotected []string
+	// TimedOut are the forests whose replica did not open in time.
+	TimedOut []string
+	// Shutdown tells whether MarkLogic stopped within the grace period.
+	Shutdown bool
+	Duration time.Duration
+}
+
+// Drainer drains one host.
+type Drainer struct {
+	opts    Options
+	log     logr.Logger
+	clients hostclient.Factory
+}
+
+// New returns a Drainer for opts. newClient may be nil to use mlclient.New.
+func New(opts Options, log logr.Logger, newClient mlclient.Factory) *Drainer {
+	if opts.GracePeriod == 0 {
+		opts.GracePeriod = 120 * time.Second
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = 2 * time.Second
+	}
+	return &Drainer{opts: opts, log: log, clients: hostclient.Factory{New: newClient}}
+}
+
+// protectedForest is a forest served by the host with the replicas that can
+// take over from it.
+type protectedForest struct {
+	name     string
+	replicas []string
+}
+
+// Run fails the forests of the host over to their replicas, waits for the
+// replicas to open and shuts MarkLogic down. Forests without a synchronized
+// replica and replicas that do not open in time do not stop the drain, the
+// pod is going away regardless; they are reported in the summary, which is
+// also logged. Run only fails when the host cannot be shut down.
+func (d *Drainer) Run(ctx context.Context) (*Summary, error) {
+	start := time.Now()
+	summary := &Summary{Host: d.opts.Host}
+	failoverBudget, shutdownBudget := Budget(d.opts.GracePeriod)
+	d.log.Info("draining host", "host", d.opts.Host, "failoverBudget", failoverBudget, "shutdownBudget", shutdownBudget)
+
+	c, err := d.client()
+	if err != nil {
+		return nil, err
+	}
+
+	protected, err := d.inventory(ctx, c, summary)
+	if err != nil {
+		// Shutting down with failover still lets MarkLogic move the forests.
+		d.log.Error(err, "cannot verify the replicas of the forests, shutting down anyway")
+	}
+	if len(protected) > 0 && failoverBudget > 0 {
+		failoverCtx, cancel := context.WithTimeout(ctx, failoverBudget)
+		d.failover(failoverCtx, c, protected, summary)
+		cancel()
+	} else {
+		for _, f := range protected {
+			summary.TimedOut = append(summary.TimedOut, f.name)
+		}
+	}
+
+	err = d.shutdown(ctx, c, shutdownBudget, summary)
+	summary.Duration = time.Since(start).Round(time.Millisecond)
+	d.log.Info("drain summary",
+		"host", summary.Host,
+		"forests", summary.Forests,
+		"failedOver", summary.FailedOver,
+		"unprotected", summary.Unprotected,
+		"timedOut", summary.TimedOut,
+		"shutdown", summary.Shutdown,
+		"duration", summary.Duration.String())
+	d.recordEvents(ctx, summary, err)
+	return summary, err
+}
+
+// recordEvents records the failover and the shutdown of the host. The drain
+// does not depend on them, errors are only logged.
+func (d *Drainer) recordEvents(ctx context.Context, summary *Summary, drainErr error) {
+	if d.opts.Events == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), eventTimeout)
+	defer cancel()
+	event := func(eventType, reason, format string, args ...interface{}) {
+		if err := events.Eventf(ctx, d.opts.Events, eventType, reason, format, args...); err != nil {
+			d.log.Error(err, "failed to record event", "reason", reason)
+		}
+	}
+	if len(summary.FailedOver) > 0 {
+		event(corev1.EventTypeNormal, marklogicv1alpha1.EventForestsFailedOver, "replicas took over forests %s", strings.Join(summary.FailedOver, ", "))
+	}
+	var unavailable []string
+	if len(summary.Unprotected) > 0 {
+		unavailable = append(unavailable, "without a synchronized replica: "+strings.Join(summary.Unprotected, ", "))
+	}
+	if len(summary.TimedOut) > 0 {
+		unavailable = append(unavailable, "whose replica did not open in time: "+strings.Join(summary.TimedOut, ", "))
+	}
+	if len(unavailable) > 0 {
+		event(corev1.EventTypeWarning, marklogicv1alpha1.EventForestsUnavailable, "forests are unavailable until the host is back, %s", strings.Join(unavailable, "; "))
+	}
+	switch {
+	case drainErr != nil:
+		event(corev1.EventTypeWarning, marklogicv1alpha1.EventDrainFailed, "%v", drainErr)
+	case summary.Shutdown:
+		event(corev1.EventTypeNormal, marklogicv1alpha1.EventHostShutDown, "MarkLogic shut down in %s", summary.Duration)
+	}
+}
+
+// client returns a client of the local host, using https when TLS is enabled.
+// Polls must not wait for the retries of the client.
+func (d *Drainer) client() (*mlclient.Client, error) {
+	cfg := mlclient.Config{
+		Host:       hostclient.Localhost,
+		Username:   d.opts.Username,
+		Password:   d.opts.Password,
+		HTTPS:      d.opts.TLSEnabled,
+		Timeout:    10 * time.Second,
+		MaxRetries: -1,
+	}
+	return d.clients.Client(cfg, d.opts.Host)
+}
+
+// inventory returns the open forests of the host that have a synchronized
+// replica and records the others as unprotected. Replicas hosted here and
+// forests that already failed over are not open and need nothing.
+func (d *Drainer) inventory(ctx context.Context, c *mlclient.Client, summary *Summary) ([]protectedForest, error) {
+	names, err := c.HostForests(ctx, d.opts.Host)
+	if err != nil {
+		return nil, fmt.Errorf("listing forests of %s: %w", d.opts.Host, err)
+	}
+	var protected []protectedForest
+	for _, name := range names {
+		state, err := c.ForestState(ctx, name)
+		if err != nil {
+			return protected, fmt.Errorf("reading state of forest %s: %w", name, err)
+		}
+		if state != mlclient.ForestOpen {
+			continue
+		}
+		summary.Forests++
+		props, err := c.ForestProperties(ctx, name)
+		if err != nil {
+			return protected, fmt.Errorf("reading forest %s: %w", name, err)
+		}
+		f := protectedForest{name: name}
+		for _, r := range props.Replicas {
+			replicaState, err := c.ForestState(ctx, r.ReplicaName)
+			if err != nil {
+				return protected, fmt.Errorf("reading state of replica %s: %w", r.ReplicaName, err)
+			}
+			if replicaState == mlclient.ForestSyncReplicating {
+				f.replicas = append(f.replicas, r.ReplicaName)
+			}
+		}
+		if len(f.replicas) == 0 {
+			d.log.Info("forest has no synchronized replica, its database is unavailable until the host is back", "forest", name)
+			summary.Unprotected = append(summary.Unprotected, name)
+			continue
+		}
+		protected = append(protected, f)
+	}
+	return protected, nil
+}
+
+// failover restarts the protected forests so that their replicas take over,
+// then waits until a replica of each is open or ctx is done.
+func (d *Drainer) failover(ctx context.Context, c *mlclient.Client, forests []protectedForest, summary *Summary) {
+	pending := map[string]protectedForest{}
+	for _, f := range forests {
+		d.log.Info("failing over forest", "forest", f.name, "replicas", f.replicas)
+		if err := c.RestartForest(ctx, f.name); err != nil {
+			d.log.Error(err, "failed to restart forest", "forest", f.name)
+		}
+		pending[f.name] = f
+	}
+	for {
+		for _, f := range forests {
+			if _, ok := pending[f.name]; !ok {
+				continue
+			}
+			for _, replica := range f.replicas {
+				if state, err := c.ForestState(ctx, replica); err == nil && state == mlclient.ForestOpen {
+					d.log.Info("replica took over", "forest", f.name, "replica", replica)
+					summary.FailedOver = append(summary.FailedOver, f.name)
+					delete(pending, f.name)
+					break
+				}
+			}
+		}
+		if len(pending) == 0 {
+			return
+		}
+		if err := wait(ctx, d.opts.PollInterval); err != nil {
+			for _, f := range forests {
+				if _, ok := pending[f.name]; ok {
+					d.log.Info("replica did not open in time", "forest", f.name)
+					summary.TimedOut = append(summary.TimedOut, f.name)
+				}
+			}
+			return
+		}
+	}
+}
+
+// shutdown asks MarkLogic to shut the host down with failover and waits up
+// to budget for it to stop answering.
+func (d *Drainer) shutdown(ctx context.Context, c *mlclient.Client, budget time.Duration, summary *Summary) error {
+	if err := c.ShutdownHost(ctx, d.opts.Host, true); err != nil {
+		return fmt.Errorf("shutting down %s: %w", d.opts.Host, err)
+	}
+	d.log.Info("host shutting down", "host", d.opts.Host)
+	ctx, cancel := context.WithTimeout(ctx, budget)
+	defer cancel()
+	for {
+		_, err := c.Timestamp(ctx)
+		if ctx.Err() == nil && (mlclient.IsUnreachable(err) || mlclient.StatusCode(err) == http.StatusServiceUnavailable) {
+			summary.Shutdown = true
+			return nil
+		}
+		if err := wait(ctx, d.opts.PollInterval); err != nil {
+			d.log.Info("MarkLogic did not stop in time, leaving it to the kubelet", "host", d.opts.Host)
+			return nil
+		}
+	}
+}
+
+// wait pauses for d or until ctx is done.
+func wait(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
@@ -0,0 +1,204 @@
+package drain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+const testHost = "dnode-1.dnode.marklogic.svc.cluster.local"
+
+// fakeHost emulates the Management API of a host whose forests have
+// replicas on other hosts.
+type fakeHost struct {
+	mu  sync.Mutex
+	srv *httptest.Server
+	// forests are the forests of the host with their replicas.
+	forests  map[string][]string
+	states   map[string]string
+	restarts int
+	// takeOver makes the replicas of a restarted forest open.
+	takeOver bool
+	shutdown string
+	stopped  bool
+}
+
+func newFakeHost(t *testing.T) *fakeHost {
+	f := &fakeHost{
+		forests: map[string][]string{
+			"app-1":   {"app-1-r"},
+			"app-2":   nil,
+			"other-r": nil,
+		},
+		states: map[string]string{
+			"app-1":   mlclient.ForestOpen,
+			"app-1-r": mlclient.ForestSyncReplicating,
+			"app-2":   mlclient.ForestOpen,
+			"other-r": mlclient.ForestSyncReplicating,
+		},
+		takeOver: true,
+	}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeHost) newClient(cfg mlclient.Config) (*mlclient.Client, error) {
+	u, _ := url.Parse(f.srv.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+	p, _ := strconv.Atoi(port)
+	cfg.Host = "127.0.0.1"
+	cfg.HTTPS = false
+	cfg.TLSConfig = nil
+	cfg.AdminPort, cfg.ManagePort = p, p
+	return mlclient.New(cfg)
+}
+
+func (f *fakeHost) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/admin/v1/timestamp":
+		if f.stopped {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "2024-01-01T00:00:00")
+	case r.Method == http.MethodGet && r.URL.Path == "/manage/v2/forests":
+		if r.URL.Query().Get("host-id") != testHost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var items []map[string]string
+		for _, name := range []string{"app-1", "app-2", "other-r"} {
+			items = append(items, map[string]string{"nameref": name})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"forest-default-list": map[string]interface{}{"list-items": map[string]interface{}{"list-item": items}},
+		})
+	case r.Method == http.MethodGet && len(parts) == 5 && parts[4] == "properties":
+		var replicas []mlclient.ForestReplica
+		for _, name := range f.forests[parts[3]] {
+			replicas = append(replicas, mlclient.ForestReplica{ReplicaName: name, Host: "dnode-2.dnode.marklogic.svc.cluster.local"})
+		}
+		_ = json.NewEncoder(w).Encode(mlclient.ForestProperties{ForestName: parts[3], Host: testHost, Replicas: replicas})
+	case r.Method == http.MethodGet && len(parts) == 4 && parts[2] == "forests":
+		state, ok := f.states[parts[3]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"forest-status":{"status-properties":{"state":{"value":%q}}}}`, state)
+	case r.Method == http.MethodPost && len(parts) == 4 && parts[2] == "forests":
+		form, _ := url.ParseQuery(string(body))
+		if form.Get("state") == "restart" {
+			f.restarts++
+			if f.takeOver {
+				for _, replica := range f.forests[parts[3]] {
+					f.states[replica] = mlclient.ForestOpen
+				}
+				f.states[parts[3]] = mlclient.ForestSyncReplicating
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPost && len(parts) == 4 && parts[2] == "hosts" && parts[3] == testHost:
+		f.shutdown = parts[3] + "?" + string(body)
+		f.stopped = true
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func testOptions() Options {
+	return Options{
+		Host:         testHost,
+		Username:     "admin",
+		Password:     "admin",
+		GracePeriod:  time.Minute,
+		PollInterval: time.Millisecond,
+	}
+}
+
+// eventRecorder records the Events as their reason and message.
+type eventRecorder []string
+
+func (r *eventRecorder) Event(_ context.Context, _, reason, message string) error {
+	*r = append(*r, reason+": "+message)
+	return nil
+}
+
+func TestRunFailsOverProtectedForests(t *testing.T) {
+	f := newFakeHost(t)
+	opts := testOptions()
+	events := &eventRecorder{}
+	opts.Events = events
+
+	summary, err := New(opts, testr.New(t), f.newClient).Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, testHost, summary.Host)
+	require.Equal(t, 2, summary.Forests)
+	require.Equal(t, []string{"app-1"}, summary.FailedOver)
+	require.Equal(t, []string{"app-2"}, summary.Unprotected)
+	require.Empty(t, summary.TimedOut)
+	require.True(t, summary.Shutdown)
+	require.Equal(t, 1, f.restarts)
+	require.Equal(t, testHost+"?failover=true&state=shutdown", f.shutdown)
+	require.Len(t, *events, 3)
+	require.Equal(t, "ForestsFailedOver: replicas took over forests app-1", (*events)[0])
+	require.Equal(t, "ForestsUnavailable: forests are unavailable until the host is back, without a synchronized replica: app-2", (*events)[1])
+	require.Contains(t, (*events)[2], "HostShutDown: MarkLogic shut down in ")
+}
+
+func TestRunReportsReplicasNotOpeningInTime(t *testing.T) {
+	f := newFakeHost(t)
+	f.takeOver = false
+	opts := testOptions()
+	opts.GracePeriod = stopMargin + 30*time.Millisecond
+
+	summary, err := New(opts, testr.New(t), f.newClient).Run(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, summary.FailedOver)
+	require.Equal(t, []string{"app-1"}, summary.TimedOut)
+	require.True(t, summary.Shutdown)
+}
+
+func TestRunFailsWhenShutdownIsRejected(t *testing.T) {
+	f := newFakeHost(t)
+	opts := testOptions()
+	opts.Host = "unknown.dnode.marklogic.svc.cluster.local"
+	events := &eventRecorder{}
+	opts.Events = events
+
+	_, err := New(opts, testr.New(t), f.newClient).Run(context.Background())
+	require.Error(t, err)
+	require.Zero(t, f.restarts)
+	require.Contains(t, (*events)[len(*events)-1], "DrainFailed: shutting down unknown.dnode.marklogic.svc.cluster.local")
+}
+
+func TestBudget(t *testing.T) {
+	failover, shutdown := Budget(120 * time.Second)
+	require.Equal(t, 115*time.Second, failover+shutdown)
+	require.Greater(t, failover, shutdown)
+
+	failover, shutdown = Budget(stopMargin)
+	require.Zero(t, failover)
+	require.Zero(t, shutdown)
+}
@@ -0,0 +1,125 @@
+// Package events records the lifecycle transitions of a MarkLogic host as
+// Kubernetes Events.
+//
+// The agent joins, configures, restarts and drains the host inside the hooks
+// of its pod, whose output is not part of the container logs. Every
+// transition is recorded on the pod and on the MarkLogicCluster owning it,
+// with one of the reasons of the API, so it can be followed with kubectl
+// describe or alerted on.
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+// clusterLabel is the label of the pods naming their MarkLogicCluster.
+const clusterLabel = "app.kubernetes.io/instance"
+
+// Recorder records the Events of a host.
+type Recorder interface {
+	// Event records an Event of type corev1.EventTypeNormal or
+	// corev1.EventTypeWarning with one of the reasons of the API.
+	Event(ctx context.Context, eventType, reason, message string) error
+}
+
+// Eventf records an Event with a formatted message on r, if set. The hooks do
+// not depend on the Events, callers only log the error.
+func Eventf(ctx context.Context, r Recorder, eventType, reason, format string, args ...interface{}) error {
+	if r == nil {
+		return nil
+	}
+	return r.Event(ctx, eventType, reason, fmt.Sprintf(format, args...))
+}
+
+// PodRecorder records the Events on the named pod and on its cluster, using
+// the credentials of the pod. A Role created by the operator lets the pods
+// read their pod and cluster and create Events.
+type PodRecorder struct {
+	Client    client.Client
+	Namespace string
+	Name      string
+	// Component is the source of the Events, e.g. the hook.
+	Component string
+	// Now returns the time of an Event. Defaults to time.Now.
+	Now func() time.Time
+
+	pod, cluster *corev1.ObjectReference
+}
+
+// Event creates the Event on the pod and, prefixed with the pod name, on the
+// cluster. The pod and the cluster are read on the first Event.
+func (r *PodRecorder) Event(ctx context.Context, eventType, reason, message string) error {
+	resolveErr := r.resolve(ctx)
+	if r.pod == nil {
+		return resolveErr
+	}
+	errs := []error{resolveErr}
+	errs = append(errs, r.create(ctx, r.pod, eventType, reason, message))
+	if r.cluster != nil {
+		errs = append(errs, r.create(ctx, r.cluster, eventType, reason, r.Name+": "+message))
+	}
+	return errors.Join(errs...)
+}
+
+// resolve reads the references of the pod and of its cluster. The Events of
+// the pod are still recorded when the cluster cannot be read.
+func (r *PodRecorder) resolve(ctx context.Context) error {
+	if r.pod != nil {
+		return nil
+	}
+	pod := &corev1.Pod{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: r.Namespace, Name: r.Name}, pod); err != nil {
+		return fmt.Errorf("reading pod %s: %w", r.Name, err)
+	}
+	r.pod = &corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+
+	name := pod.Labels[clusterLabel]
+	if name == "" {
+		return nil
+	}
+	cluster := &metav1.PartialObjectMetadata{}
+	cluster.SetGroupVersionKind(marklogicv1alpha1.GroupVersion.WithKind("MarkLogicCluster"))
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: r.Namespace, Name: name}, cluster); err != nil {
+		return fmt.Errorf("reading MarkLogicCluster %s: %w", name, err)
+	}
+	r.cluster = &corev1.ObjectReference{
+		APIVersion: marklogicv1alpha1.GroupVersion.String(),
+		Kind:       "MarkLogicCluster",
+		Namespace:  cluster.Namespace,
+		Name:       cluster.Name,
+		UID:        cluster.UID,
+	}
+	return nil
+}
+
+func (r *PodRecorder) create(ctx context.Context, ref *corev1.ObjectReference, eventType, reason, message string) error {
+	now := time.Now
+	if r.Now != nil {
+		now = r.Now
+	}
+	t := metav1.NewTime(now())
+	event := &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Namespace: r.Namespace, GenerateName: ref.Name + "."},
+		InvolvedObject: *ref,
+		Type:           eventType,
+		Reason:         reason,
+		Message:        message,
+		Source:         corev1.EventSource{Component: r.Component, Host: r.Name},
+		FirstTimestamp: t,
+		LastTimestamp:  t,
+		Count:          1,
+	}
+	if err := r.Client.Create(ctx, event); err != nil {
+		return fmt.Errorf("recording event %s on %s %s: %w", reason, ref.Kind, ref.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	marklogicv1alpha1 "github.com/marklogic/marklogic-kubernetes/api/v1alpha1"
+)
+
+func TestPodRecorder(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(s))
+	require.NoError(t, marklogicv1alpha1.AddToScheme(s))
+	k8s := fake.NewClientBuilder().WithScheme(s).WithObjects(
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Namespace: "marklogic", Name: "dnode-1", UID: "pod-uid",
+			Labels: map[string]string{"app.kubernetes.io/instance": "dnode"},
+		}},
+		&marklogicv1alpha1.MarkLogicCluster{ObjectMeta: metav1.ObjectMeta{Namespace: "marklogic", Name: "dnode", UID: "cluster-uid"}},
+	).Build()
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	r := &PodRecorder{Client: k8s, Namespace: "marklogic", Name: "dnode-1", Component: "marklogic-agent/postStart", Now: func() time.Time { return now }}
+	ctx := context.Background()
+
+	require.NoError(t, Eventf(ctx, r, corev1.EventTypeNormal, marklogicv1alpha1.EventHostJoined, "joined the cluster of %s", "dnode-0"))
+	events := &corev1.EventList{}
+	require.NoError(t, k8s.List(ctx, events, client.InNamespace("marklogic")))
+	require.Len(t, events.Items, 2)
+	byKind := map[string]corev1.Event{}
+	for _, e := range events.Items {
+		byKind[e.InvolvedObject.Kind] = e
+		assert.Equal(t, marklogicv1alpha1.EventHostJoined, e.Reason)
+		assert.Equal(t, corev1.EventTypeNormal, e.Type)
+		assert.Equal(t, corev1.EventSource{Component: "marklogic-agent/postStart", Host: "dnode-1"}, e.Source)
+		assert.True(t, now.Equal(e.LastTimestamp.Time))
+	}
+	pod := byKind["Pod"]
+	assert.Equal(t, "joined the cluster of dnode-0", pod.Message)
+	assert.Equal(t, corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: "marklogic", Name: "dnode-1", UID: "pod-uid"}, pod.InvolvedObject)
+	cluster := byKind["MarkLogicCluster"]
+	assert.Equal(t, "dnode-1: joined the cluster of dnode-0", cluster.Message)
+	assert.Equal(t, corev1.ObjectReference{APIVersion: "marklogic.com/v1alpha1", Kind: "MarkLogicCluster", Namespace: "marklogic", Name: "dnode", UID: "cluster-uid"}, cluster.InvolvedObject)
+
+	// Without a recorder nothing is recorded.
+	require.NoError(t, Eventf(ctx, nil, corev1.EventTypeNormal, marklogicv1alpha1.EventHostJoined, "joined"))
+
+	// Pods that cannot be read record nothing.
+	r = &PodRecorder{Client: k8s, Namespace: "marklogic", Name: "dnode-2"}
+	require.Error(t, r.Event(ctx, corev1.EventTypeNormal, marklogicv1alpha1.EventHostJoined, "joined"))
+}
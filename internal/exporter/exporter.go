@@ -0,0 +1,158 @@
+// Package exporter exposes the metrics of a MarkLogic host to Prometheus.
+//
+// It runs next to MarkLogic in every pod and reads the metrics views of the
+// Management API on each scrape: the metrics of the host and of the forests
+// it serves, and on one host of the cluster the metrics of the databases,
+// which every host would report alike.
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/marklogic/marklogic-kubernetes/internal/hostclient"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// namespace prefixes the names of the exported metrics.
+const namespace = "marklogic"
+
+// DefaultPort is the port the exporter listens on.
+const DefaultPort = 9106
+
+var (
+	upDesc = prometheus.NewDesc(namespace+"_up",
+		"Whether the last scrape of the Management API succeeded.", []string{"host"}, nil)
+	durationDesc = prometheus.NewDesc(namespace+"_scrape_duration_seconds",
+		"Duration of the last scrape of the Management API.", []string{"host"}, nil)
+
+	invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// Options configures an Exporter.
+type Options struct {
+	// Host is the FQDN of the host, the name MarkLogic knows it by.
+	Host string
+	// Credentials returns the MarkLogic admin credentials. They are read on
+	// every scrape, so a rotated password is picked up.
+	Credentials func() (username, password string, err error)
+	// TLSEnabled makes the exporter use https.
+	TLSEnabled bool
+	// Databases exports the metrics of the databases of the cluster.
+	Databases bool
+	// Timeout bounds a scrape. Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// Exporter is a prometheus.Collector reading the metrics of a host. The
+// metrics depend on the MarkLogic version, so it describes none upfront.
+type Exporter struct {
+	opts    Options
+	log     logr.Logger
+	clients hostclient.Factory
+}
+
+// New returns an Exporter for opts. newClient may be nil to use mlclient.New.
+func New(opts Options, log logr.Logger, newClient mlclient.Factory) *Exporter {
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	return &Exporter{opts: opts, log: log, clients: hostclient.Factory{New: newClient}}
+}
+
+// Describe implements prometheus.Collector. It sends no descriptor, which
+// makes the Exporter an unchecked collector.
+func (e *Exporter) Describe(chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector. A failed scrape reports
+// marklogic_up 0 along with the metrics read until then.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), e.opts.Timeout)
+	defer cancel()
+
+	up := 1.0
+	if err := e.collect(ctx, ch); err != nil {
+		e.log.Error(err, "scraping MarkLogic failed", "host", e.opts.Host)
+		up = 0
+	}
+	ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, up, e.opts.Host)
+	ch <- prometheus.MustNewConstMetric(durationDesc, prometheus.GaugeValue, time.Since(start).Seconds(), e.opts.Host)
+}
+
+func (e *Exporter) collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	c, err := e.client()
+	if err != nil {
+		return err
+	}
+	metrics, err := c.HostMetrics(ctx, e.opts.Host)
+	if err != nil {
+		return fmt.Errorf("reading metrics of host %s: %w", e.opts.Host, err)
+	}
+	send(ch, "host", metrics, []string{"host"}, e.opts.Host)
+
+	forests, err := c.HostForests(ctx, e.opts.Host)
+	if err != nil {
+		return fmt.Errorf("listing forests of %s: %w", e.opts.Host, err)
+	}
+	for _, name := range forests {
+		metrics, err := c.ForestMetrics(ctx, name)
+		if err != nil {
+			return fmt.Errorf("reading metrics of forest %s: %w", name, err)
+		}
+		send(ch, "forest", metrics, []string{"forest", "host"}, name, e.opts.Host)
+	}
+
+	if !e.opts.Databases {
+		return nil
+	}
+	databases, err := c.Databases(ctx)
+	if err != nil {
+		return fmt.Errorf("listing databases: %w", err)
+	}
+	for _, name := range databases {
+		metrics, err := c.DatabaseMetrics(ctx, name)
+		if err != nil {
+			return fmt.Errorf("reading metrics of database %s: %w", name, err)
+		}
+		send(ch, "database", metrics, []string{"database"}, name)
+	}
+	return nil
+}
+
+// send exports metrics as gauges named after the Management API metric,
+// e.g. total-cpu-stat-user of a host becomes marklogic_host_total_cpu_stat_user.
+func send(ch chan<- prometheus.Metric, subsystem string, metrics []mlclient.Metric, labels []string, values ...string) {
+	for _, m := range metrics {
+		help := fmt.Sprintf("MarkLogic %s metric %s", subsystem, m.Name)
+		if m.Units != "" {
+			help += " in " + m.Units
+		}
+		name := prometheus.BuildFQName(namespace, subsystem, invalidNameChars.ReplaceAllString(m.Name, "_"))
+		desc := prometheus.NewDesc(name, help+".", labels, nil)
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, m.Value, values...)
+	}
+}
+
+// client returns a client of the local host, using https when TLS is enabled.
+// A failed scrape is retried by Prometheus, not by the client.
+func (e *Exporter) client() (*mlclient.Client, error) {
+	username, password, err := e.opts.Credentials()
+	if err != nil {
+		return nil, fmt.Errorf("reading admin credentials: %w", err)
+	}
+	cfg := mlclient.Config{
+		Host:       hostclient.Localhost,
+		HTTPS:      e.opts.TLSEnabled,
+		Username:   username,
+		Password:   password,
+		MaxRetries: -1,
+		Timeout:    e.opts.Timeout,
+	}
+	return e.clients.Client(cfg, e.opts.Host)
+}
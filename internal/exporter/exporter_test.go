@@ -0,0 +1,126 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr/testr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+const testHost = "dnode-0.dnode.marklogic.svc.cluster.local"
+
+// fakeHost emulates the metrics views of the Management API of a host
+// serving the forests of the Documents database.
+type fakeHost struct {
+	srv *httptest.Server
+	// failing answers the named path with an error.
+	failing string
+}
+
+func newFakeHost(t *testing.T) *fakeHost {
+	f := &fakeHost{}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeHost) newClient(cfg mlclient.Config) (*mlclient.Client, error) {
+	u, _ := url.Parse(f.srv.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+	p, _ := strconv.Atoi(port)
+	cfg.Host = "127.0.0.1"
+	cfg.HTTPS = false
+	cfg.TLSConfig = nil
+	cfg.AdminPort, cfg.ManagePort = p, p
+	return mlclient.New(cfg)
+}
+
+// metricsView renders a metrics view with one sample per metric.
+func metricsView(kind string, metrics map[string]float64) string {
+	var entries []string
+	for name, value := range metrics {
+		entries = append(entries, fmt.Sprintf(`{%q:{"units":"MB","summary":{"data":{"entry":[{"value":%v}]}}}}`, name, value))
+	}
+	return fmt.Sprintf(`{"%[1]s-metrics-list":{"metrics-relations":{"%[1]s-metrics-list":{"metrics":[%[2]s]}}}}`, kind, strings.Join(entries, ","))
+}
+
+func (f *fakeHost) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == f.failing {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	q := r.URL.Query()
+	switch {
+	case r.URL.Path == "/manage/v2/hosts" && q.Get("host-id") == testHost:
+		fmt.Fprint(w, metricsView("host", map[string]float64{"memory-process-size": 2048}))
+	case r.URL.Path == "/manage/v2/forests" && q.Get("view") != "metrics" && q.Get("host-id") == testHost:
+		fmt.Fprint(w, `{"forest-default-list":{"list-items":{"list-item":[{"nameref":"Documents-1"}]}}}`)
+	case r.URL.Path == "/manage/v2/forests" && q.Get("forest-id") == "Documents-1":
+		fmt.Fprint(w, metricsView("forest", map[string]float64{"active-fragment-count": 42}))
+	case r.URL.Path == "/manage/v2/databases" && q.Get("view") != "metrics":
+		fmt.Fprint(w, `{"database-default-list":{"list-items":{"list-item":[{"nameref":"Documents"}]}}}`)
+	case r.URL.Path == "/manage/v2/databases" && q.Get("database-id") == "Documents":
+		fmt.Fprint(w, metricsView("database", map[string]float64{"data-size": 512}))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestExporter(t *testing.T, f *fakeHost, databases bool) *Exporter {
+	return New(Options{
+		Host:        testHost,
+		Credentials: func() (string, string, error) { return "admin", "admin", nil },
+		Databases:   databases,
+	}, testr.New(t), f.newClient)
+}
+
+func TestCollect(t *testing.T) {
+	f := newFakeHost(t)
+	require.NoError(t, testutil.CollectAndCompare(newTestExporter(t, f, true), strings.NewReader(`
+# HELP marklogic_database_data_size MarkLogic database metric data-size in MB.
+# TYPE marklogic_database_data_size gauge
+marklogic_database_data_size{database="Documents"} 512
+# HELP marklogic_forest_active_fragment_count MarkLogic forest metric active-fragment-count in MB.
+# TYPE marklogic_forest_active_fragment_count gauge
+marklogic_forest_active_fragment_count{forest="Documents-1",host="dnode-0.dnode.marklogic.svc.cluster.local"} 42
+# HELP marklogic_host_memory_process_size MarkLogic host metric memory-process-size in MB.
+# TYPE marklogic_host_memory_process_size gauge
+marklogic_host_memory_process_size{host="dnode-0.dnode.marklogic.svc.cluster.local"} 2048
+# HELP marklogic_up Whether the last scrape of the Management API succeeded.
+# TYPE marklogic_up gauge
+marklogic_up{host="dnode-0.dnode.marklogic.svc.cluster.local"} 1
+`), "marklogic_database_data_size", "marklogic_forest_active_fragment_count", "marklogic_host_memory_process_size", "marklogic_up"))
+
+	// Other hosts leave the databases to the bootstrap host.
+	require.Equal(t, 0, testutil.CollectAndCount(newTestExporter(t, f, false), "marklogic_database_data_size"))
+}
+
+const down = `
+# HELP marklogic_up Whether the last scrape of the Management API succeeded.
+# TYPE marklogic_up gauge
+marklogic_up{host="dnode-0.dnode.marklogic.svc.cluster.local"} 0
+`
+
+func TestCollectReportsFailedScrape(t *testing.T) {
+	f := newFakeHost(t)
+	f.failing = "/manage/v2/databases"
+	e := newTestExporter(t, f, true)
+	require.NoError(t, testutil.CollectAndCompare(e, strings.NewReader(down), "marklogic_up"))
+	// The metrics read before the failure are still exported.
+	require.Equal(t, 1, testutil.CollectAndCount(e, "marklogic_host_memory_process_size"))
+
+	e.opts.Credentials = func() (string, string, error) { return "", "", errors.New("no such file") }
+	require.Equal(t, 0, testutil.CollectAndCount(e, "marklogic_host_memory_process_size"))
+	require.NoError(t, testutil.CollectAndCompare(e, strings.NewReader(down), "marklogic_up"))
+}
@@ -0,0 +1,71 @@
+// Package hostclient creates the MarkLogic clients the agent uses inside the
+// pods of a cluster, for the host of the pod and for the bootstrap host.
+//
+// With TLS enabled the hosts serve certificates signed by the CA of the
+// cluster, which the copy-certs init container puts next to the certificate
+// of the host. The clients trust that CA. Until it is there, e.g. on the
+// bootstrap host using temporary certificates, the certificate is not
+// verified, like with curl -k in the chart scripts.
+package hostclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// DefaultCAFile is the CA of the cluster in the MarkLogic pods.
+const DefaultCAFile = "/run/secrets/marklogic-certs/cacert.pem"
+
+// Localhost is the host name the clients of the host of the pod connect to.
+const Localhost = "localhost"
+
+// Factory creates the clients of the hosts of a cluster.
+type Factory struct {
+	// New creates the clients. Defaults to mlclient.New.
+	New mlclient.Factory
+	// CAFile holds the CA of the cluster. Defaults to DefaultCAFile.
+	CAFile string
+}
+
+// Client returns a client for cfg. When cfg uses https without a TLS
+// configuration, the certificate is verified against the CA of the cluster
+// for name, the FQDN of the host, as clients of the host of the pod connect
+// to localhost.
+func (f Factory) Client(cfg mlclient.Config, name string) (*mlclient.Client, error) {
+	if cfg.HTTPS && cfg.TLSConfig == nil {
+		tlsConfig, err := f.tlsConfig(name)
+		if err != nil {
+			return nil, err
+		}
+		cfg.TLSConfig = tlsConfig
+	}
+	if f.New == nil {
+		return mlclient.New(cfg)
+	}
+	return f.New(cfg)
+}
+
+func (f Factory) tlsConfig(name string) (*tls.Config, error) {
+	caFile := f.CAFile
+	if caFile == "" {
+		caFile = DefaultCAFile
+	}
+	ca, err := os.ReadFile(caFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &tls.Config{InsecureSkipVerify: true}, nil //nolint:gosec // temporary certificates cannot be verified yet
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading CA certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("%s has no PEM certificate", caFile)
+	}
+	return &tls.Config{RootCAs: pool, ServerName: name, MinVersion: tls.VersionTLS12}, nil
+}
@@ -0,0 +1,56 @@
+package hostclient
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+func TestFactoryTrustsClusterCA(t *testing.T) {
+	// The certificate of the test server is issued for example.com.
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "2024-01-01T00:00:00")
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	host, port, _ := net.SplitHostPort(u.Host)
+	p, _ := strconv.Atoi(port)
+	cfg := mlclient.Config{Host: host, HTTPS: true, AdminPort: p, MaxRetries: -1}
+	caFile := filepath.Join(t.TempDir(), "cacert.pem")
+	f := Factory{CAFile: caFile}
+	ctx := context.Background()
+
+	// Temporary certificates are not verified until the CA is there.
+	c, err := f.Client(cfg, "dnode-0.dnode.marklogic.svc.cluster.local")
+	require.NoError(t, err)
+	_, err = c.Timestamp(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600))
+	c, err = f.Client(cfg, "example.com")
+	require.NoError(t, err)
+	_, err = c.Timestamp(ctx)
+	require.NoError(t, err)
+
+	c, err = f.Client(cfg, "dnode-0.dnode.marklogic.svc.cluster.local")
+	require.NoError(t, err)
+	_, err = c.Timestamp(ctx)
+	assert.True(t, mlclient.IsUnreachable(err), "the certificate does not name the host")
+
+	require.NoError(t, os.WriteFile(caFile, []byte("not a certificate"), 0o600))
+	_, err = f.Client(cfg, "example.com")
+	require.Error(t, err)
+}
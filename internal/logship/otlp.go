@@ -0,0 +1,163 @@
+package logship
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// logsPath is the path of the logs service of OTLP/HTTP.
+const logsPath = "/v1/logs"
+
+// scopeName is the instrumentation scope of the exported records.
+const scopeName = "github.com/marklogic/marklogic-kubernetes/internal/logship"
+
+// The types below are the JSON encoding of the ExportLogsServiceRequest of
+// OTLP. 64 bit integers are encoded as strings, like protobuf JSON does.
+type exportRequest struct {
+	ResourceLogs []resourceLogs `json:"resourceLogs"`
+}
+
+type resourceLogs struct {
+	Resource  resource    `json:"resource"`
+	ScopeLogs []scopeLogs `json:"scopeLogs"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeLogs struct {
+	Scope      scope       `json:"scope"`
+	LogRecords []logRecord `json:"logRecords"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type logRecord struct {
+	TimeUnixNano         string     `json:"timeUnixNano,omitempty"`
+	ObservedTimeUnixNano string     `json:"observedTimeUnixNano"`
+	SeverityNumber       Severity   `json:"severityNumber,omitempty"`
+	SeverityText         string     `json:"severityText,omitempty"`
+	Body                 anyValue   `json:"body"`
+	Attributes           []keyValue `json:"attributes,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+func newAnyValue(v interface{}) anyValue {
+	switch v := v.(type) {
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return anyValue{IntValue: &s}
+	case float64:
+		return anyValue{DoubleValue: &v}
+	case bool:
+		return anyValue{BoolValue: &v}
+	case string:
+		return anyValue{StringValue: &v}
+	}
+	s := fmt.Sprint(v)
+	return anyValue{StringValue: &s}
+}
+
+func keyValues(attrs []Attribute) []keyValue {
+	kvs := make([]keyValue, 0, len(attrs))
+	for _, a := range attrs {
+		kvs = append(kvs, keyValue{Key: a.Key, Value: newAnyValue(a.Value)})
+	}
+	return kvs
+}
+
+func unixNano(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// encode returns the OTLP request exporting records of the resource
+// described by res.
+func encode(res []Attribute, records []Record) ([]byte, error) {
+	logs := make([]logRecord, 0, len(records))
+	for _, r := range records {
+		logs = append(logs, logRecord{
+			TimeUnixNano:         unixNano(r.Time),
+			ObservedTimeUnixNano: unixNano(r.ObservedTime),
+			SeverityNumber:       r.Severity,
+			SeverityText:         r.SeverityText,
+			Body:                 newAnyValue(r.Body),
+			Attributes:           keyValues(r.Attributes),
+		})
+	}
+	req := exportRequest{ResourceLogs: []resourceLogs{{
+		Resource:  resource{Attributes: keyValues(res)},
+		ScopeLogs: []scopeLogs{{Scope: scope{Name: scopeName}, LogRecords: logs}},
+	}}}
+	var b bytes.Buffer
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(req); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// LogsURL returns the URL of the logs service of the collector at endpoint.
+// Like the OTEL_EXPORTER_OTLP_ENDPOINT variable of the SDKs, endpoint is the
+// base URL of the collector, e.g. http://otel-collector:4318.
+func LogsURL(endpoint string) string {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if strings.HasSuffix(endpoint, logsPath) {
+		return endpoint
+	}
+	return endpoint + logsPath
+}
+
+// export posts records to the collector. Rejected requests are errors,
+// records rejected within an accepted request are not.
+func (s *Shipper) export(ctx context.Context, records []Record) error {
+	body, err := encode(s.opts.Resource, records)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, LogsURL(s.opts.Endpoint), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.opts.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("the collector answered %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
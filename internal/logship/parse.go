@@ -0,0 +1,276 @@
+// Package logship ships the log files of a MarkLogic host to an
+// OpenTelemetry collector.
+//
+// It runs next to MarkLogic in every pod in place of the fluent-bit sidecar
+// of the chart: it follows the error, access, request, crash and audit logs
+// of the host, parses their lines into structured records and exports them
+// with the OTLP/HTTP protocol.
+package logship
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind is a kind of MarkLogic log file.
+type Kind string
+
+const (
+	// KindError is ErrorLog.txt and the <port>_ErrorLog.txt of the App Servers.
+	KindError Kind = "error"
+	// KindAccess is the <port>_AccessLog.txt of the App Servers.
+	KindAccess Kind = "access"
+	// KindRequest is the <port>_RequestLog.txt of the App Servers, one JSON
+	// object per line.
+	KindRequest Kind = "request"
+	// KindCrash is CrashLog.txt.
+	KindCrash Kind = "crash"
+	// KindAudit is AuditLog.txt.
+	KindAudit Kind = "audit"
+)
+
+// Kinds lists all kinds of log files.
+var Kinds = []Kind{KindError, KindAccess, KindRequest, KindCrash, KindAudit}
+
+// pattern returns the glob matching the files of k in the logs directory,
+// like the Path of the fluent-bit inputs of the chart. Rotated files such as
+// ErrorLog_1.txt do not match.
+func (k Kind) pattern() string {
+	switch k {
+	case KindError:
+		return "*ErrorLog.txt"
+	case KindAccess:
+		return "*AccessLog.txt"
+	case KindRequest:
+		return "*RequestLog.txt"
+	case KindCrash:
+		return "CrashLog.txt"
+	case KindAudit:
+		return "AuditLog.txt"
+	}
+	return ""
+}
+
+// ParseKind returns the kind named s.
+func ParseKind(s string) (Kind, bool) {
+	for _, k := range Kinds {
+		if string(k) == s {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// KindOf returns the kind of the log file at path and the port of the App
+// Server it belongs to, 0 for the logs of the host.
+func KindOf(path string) (kind Kind, port int, ok bool) {
+	name := filepath.Base(path)
+	for _, k := range Kinds {
+		if matched, _ := filepath.Match(k.pattern(), name); !matched {
+			continue
+		}
+		if prefix, _, found := strings.Cut(name, "_"); found {
+			port, _ = strconv.Atoi(prefix)
+		}
+		return k, port, true
+	}
+	return "", 0, false
+}
+
+// Severity is the severity number of a log record, as defined by the
+// OpenTelemetry log data model.
+type Severity int
+
+// severities maps the MarkLogic log levels to the OpenTelemetry severities.
+var severities = map[string]Severity{
+	"Finest":    1,  // TRACE
+	"Finer":     2,  // TRACE2
+	"Fine":      3,  // TRACE3
+	"Debug":     5,  // DEBUG
+	"Config":    8,  // DEBUG4
+	"Info":      9,  // INFO
+	"Notice":    10, // INFO2
+	"Warning":   13, // WARN
+	"Error":     17, // ERROR
+	"Critical":  18, // ERROR2
+	"Alert":     21, // FATAL
+	"Emergency": 22, // FATAL2
+}
+
+// Attribute is an attribute of a log record. Value is a string, an int64, a
+// float64 or a bool.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Record is a parsed log line.
+type Record struct {
+	// Time is when the event occurred, zero when the line carries no time.
+	Time time.Time
+	// ObservedTime is when the line was read.
+	ObservedTime time.Time
+	Severity     Severity
+	// SeverityText is the log level as written by MarkLogic.
+	SeverityText string
+	Body         string
+	Attributes   []Attribute
+}
+
+// errorLogTime is the layout of the times of the error and audit logs,
+// written in the time zone of the host.
+const errorLogTime = "2006-01-02 15:04:05.000"
+
+var (
+	// errorLine is e.g. "2024-05-01 10:00:00.123 Info: Merged 1 MB". Lines
+	// continuing the previous message have a + after the level.
+	errorLine = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3}) ([A-Za-z]+):(\+?) ?(.*)$`)
+	// auditLine is e.g. "2024-05-01 10:00:00.123 event=login-success; ...".
+	auditLine = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3}) (.*)$`)
+	// accessLine is the combined log format,
+	// e.g. `10.0.0.1 - admin [01/May/2024:10:00:00 +0000] "GET / HTTP/1.1" 200 12 - "curl/8.0"`.
+	accessLine = regexp.MustCompile(`^(\S+) \S+ (\S+) \[([^\]]+)\] "([^"]*)" (\d{3}|-) (\d+|-)(?: "?([^"]*?)"? "([^"]*)")?`)
+)
+
+// Parse parses a line of a log file of kind, read from the App Server on
+// port, 0 for the logs of the host. Times without a zone are in loc. Lines
+// not in the format of their kind are kept whole as the body of the record.
+func Parse(kind Kind, port int, line string, loc *time.Location) Record {
+	r := Record{Body: line}
+	switch kind {
+	case KindError:
+		parseError(&r, line, loc)
+	case KindAccess:
+		parseAccess(&r, line)
+	case KindRequest:
+		parseRequest(&r, line)
+	case KindAudit:
+		if m := auditLine.FindStringSubmatch(line); m != nil {
+			if t, err := time.ParseInLocation(errorLogTime, m[1], loc); err == nil {
+				r.Time, r.Body = t, m[2]
+			}
+		}
+	}
+	attrs := []Attribute{{Key: "marklogic.log.type", Value: string(kind)}}
+	if port != 0 {
+		attrs = append(attrs, Attribute{Key: "marklogic.port", Value: int64(port)})
+	}
+	r.Attributes = append(attrs, r.Attributes...)
+	return r
+}
+
+func parseError(r *Record, line string, loc *time.Location) {
+	m := errorLine.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+	t, err := time.ParseInLocation(errorLogTime, m[1], loc)
+	if err != nil {
+		return
+	}
+	r.Time, r.SeverityText, r.Body = t, m[2], m[4]
+	r.Severity = severities[m[2]]
+	if m[3] != "" {
+		r.Attributes = append(r.Attributes, Attribute{Key: "marklogic.log.continuation", Value: true})
+	}
+}
+
+func parseAccess(r *Record, line string) {
+	m := accessLine.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+	if t, err := time.Parse("02/Jan/2006:15:04:05 -0700", m[3]); err == nil {
+		r.Time = t
+	}
+	r.Attributes = append(r.Attributes, Attribute{Key: "client.address", Value: m[1]})
+	if m[2] != "-" {
+		r.Attributes = append(r.Attributes, Attribute{Key: "user.name", Value: m[2]})
+	}
+	if method, rest, ok := strings.Cut(m[4], " "); ok {
+		target, protocol, _ := strings.Cut(rest, " ")
+		path, query, _ := strings.Cut(target, "?")
+		r.Attributes = append(r.Attributes,
+			Attribute{Key: "http.request.method", Value: method},
+			Attribute{Key: "url.path", Value: path})
+		if query != "" {
+			r.Attributes = append(r.Attributes, Attribute{Key: "url.query", Value: query})
+		}
+		if version, ok := strings.CutPrefix(protocol, "HTTP/"); ok {
+			r.Attributes = append(r.Attributes, Attribute{Key: "network.protocol.version", Value: version})
+		}
+	}
+	if status, err := strconv.ParseInt(m[5], 10, 64); err == nil {
+		r.Attributes = append(r.Attributes, Attribute{Key: "http.response.status_code", Value: status})
+	}
+	if size, err := strconv.ParseInt(m[6], 10, 64); err == nil {
+		r.Attributes = append(r.Attributes, Attribute{Key: "http.response.body.size", Value: size})
+	}
+	if m[7] != "" && m[7] != "-" {
+		r.Attributes = append(r.Attributes, Attribute{Key: "http.request.header.referer", Value: m[7]})
+	}
+	if m[8] != "" && m[8] != "-" {
+		r.Attributes = append(r.Attributes, Attribute{Key: "user_agent.original", Value: m[8]})
+	}
+}
+
+// requestLogTimes are the layouts MarkLogic writes the time of the request
+// logs with.
+var requestLogTimes = []string{"2006-01-02T15:04:05Z0700", time.RFC3339Nano}
+
+// parseRequest turns the fields of a request log line into attributes
+// prefixed with marklogic.request, in the order of their names. The time
+// field is the time of the record.
+func parseRequest(r *Record, line string) {
+	d := json.NewDecoder(strings.NewReader(line))
+	d.UseNumber()
+	var fields map[string]interface{}
+	if err := d.Decode(&fields); err != nil {
+		return
+	}
+	if s, ok := fields["time"].(string); ok {
+		for _, layout := range requestLogTimes {
+			if t, err := time.Parse(layout, s); err == nil {
+				r.Time = t
+				delete(fields, "time")
+				break
+			}
+		}
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		r.Attributes = append(r.Attributes, Attribute{Key: "marklogic.request." + k, Value: attributeValue(fields[k])})
+	}
+}
+
+// attributeValue converts a decoded JSON value. Objects and arrays are kept
+// as their JSON text.
+func attributeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string, bool:
+		return v
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case nil:
+		return ""
+	}
+	var b bytes.Buffer
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	_ = e.Encode(v)
+	return strings.TrimSpace(b.String())
+}
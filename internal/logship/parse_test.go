@@ -0,0 +1,82 @@
+package logship
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the parsers")
+
+var (
+	testResource = []Attribute{{Key: "service.name", Value: "marklogic"}, {Key: "k8s.pod.name", Value: "dnode-0"}}
+	observed     = time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+)
+
+// TestParseGolden parses the sample logs of testdata and compares the OTLP
+// requests exporting them with the .golden files. Run with -update after
+// changing a parser.
+func TestParseGolden(t *testing.T) {
+	samples, err := filepath.Glob("testdata/*.txt")
+	require.NoError(t, err)
+	require.NotEmpty(t, samples)
+	for _, sample := range samples {
+		t.Run(filepath.Base(sample), func(t *testing.T) {
+			kind, port, ok := KindOf(sample)
+			require.True(t, ok)
+			f, err := os.Open(sample)
+			require.NoError(t, err)
+			defer f.Close()
+			var records []Record
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				r := Parse(kind, port, scanner.Text(), time.UTC)
+				r.ObservedTime = observed
+				records = append(records, r)
+			}
+			require.NoError(t, scanner.Err())
+
+			body, err := encode(testResource, records)
+			require.NoError(t, err)
+			var got bytes.Buffer
+			require.NoError(t, json.Indent(&got, body, "", "  "))
+			golden := strings.TrimSuffix(sample, ".txt") + ".golden"
+			if *update {
+				require.NoError(t, os.WriteFile(golden, got.Bytes(), 0o644))
+			}
+			want, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			assert.Equal(t, string(want), got.String())
+		})
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	for path, want := range map[string]struct {
+		kind Kind
+		port int
+	}{
+		"/var/opt/MarkLogic/Logs/ErrorLog.txt":        {KindError, 0},
+		"/var/opt/MarkLogic/Logs/8002_ErrorLog.txt":   {KindError, 8002},
+		"/var/opt/MarkLogic/Logs/8000_AccessLog.txt":  {KindAccess, 8000},
+		"/var/opt/MarkLogic/Logs/8000_RequestLog.txt": {KindRequest, 8000},
+		"/var/opt/MarkLogic/Logs/CrashLog.txt":        {KindCrash, 0},
+		"/var/opt/MarkLogic/Logs/AuditLog.txt":        {KindAudit, 0},
+	} {
+		kind, port, ok := KindOf(path)
+		assert.True(t, ok, path)
+		assert.Equal(t, want.kind, kind, path)
+		assert.Equal(t, want.port, port, path)
+	}
+	_, _, ok := KindOf("/var/opt/MarkLogic/Logs/ErrorLog_1.txt")
+	assert.False(t, ok, "rotated files are not shipped")
+}
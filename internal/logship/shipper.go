@@ -0,0 +1,146 @@
+package logship
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// DefaultLogsDir is where MarkLogic writes its log files.
+const DefaultLogsDir = "/var/opt/MarkLogic/Logs"
+
+// Options configures a Shipper.
+type Options struct {
+	// LogsDir is the directory of the log files. Defaults to DefaultLogsDir.
+	LogsDir string
+	// Kinds are the kinds of log files shipped. Defaults to all.
+	Kinds []Kind
+	// Endpoint is the base URL of the OTLP/HTTP receiver of the collector.
+	Endpoint string
+	// Headers are sent with every export, e.g. to authenticate.
+	Headers map[string]string
+	// InsecureSkipVerify does not verify the certificate of the collector.
+	InsecureSkipVerify bool
+	// Resource describes the host the logs are read from.
+	Resource []Attribute
+	// Location is the time zone of the times MarkLogic writes without one.
+	// Defaults to the local time zone.
+	Location *time.Location
+	// FlushInterval is how often the files are read and the new records
+	// exported. Defaults to 5 seconds, the Flush of the fluent-bit sidecar.
+	FlushInterval time.Duration
+	// BatchSize bounds the records of an export. Defaults to 1000.
+	BatchSize int
+	// MaxBuffered bounds the records kept while the collector is unreachable.
+	// The oldest records are dropped beyond it. Defaults to 10000.
+	MaxBuffered int
+	// Timeout bounds an export. Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// Shipper follows the log files of a host and exports their records.
+type Shipper struct {
+	opts    Options
+	log     logr.Logger
+	client  *http.Client
+	tailer  *tailer
+	pending []Record
+	now     func() time.Time
+}
+
+// New returns a Shipper for opts.
+func New(opts Options, log logr.Logger) *Shipper {
+	if opts.LogsDir == "" {
+		opts.LogsDir = DefaultLogsDir
+	}
+	if len(opts.Kinds) == 0 {
+		opts.Kinds = Kinds
+	}
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+	if opts.FlushInterval == 0 {
+		opts.FlushInterval = 5 * time.Second
+	}
+	if opts.BatchSize == 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.MaxBuffered == 0 {
+		opts.MaxBuffered = 10000
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &Shipper{
+		opts:   opts,
+		log:    log,
+		client: &http.Client{Transport: transport},
+		tailer: newTailer(opts.LogsDir, opts.Kinds),
+		now:    time.Now,
+	}
+}
+
+// Run ships the records until ctx is done, then makes a last attempt to
+// export the pending records. Failed exports are retried on the next flush.
+func (s *Shipper) Run(ctx context.Context) error {
+	defer s.tailer.close()
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		s.read()
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), s.opts.Timeout)
+			defer cancel()
+			return s.flush(flushCtx)
+		default:
+		}
+		if err := s.flush(ctx); err != nil && ctx.Err() == nil {
+			s.log.Error(err, "exporting logs failed, retrying on the next flush", "pending", len(s.pending))
+		}
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+}
+
+// read parses the lines appended to the log files.
+func (s *Shipper) read() {
+	lines, err := s.tailer.poll()
+	if err != nil {
+		s.log.Error(err, "reading log files failed")
+	}
+	observed := s.now()
+	for _, l := range lines {
+		kind, port, _ := KindOf(l.path)
+		r := Parse(kind, port, l.text, s.opts.Location)
+		r.ObservedTime = observed
+		r.Attributes = append(r.Attributes, Attribute{Key: "log.file.path", Value: l.path})
+		s.pending = append(s.pending, r)
+	}
+	if dropped := len(s.pending) - s.opts.MaxBuffered; dropped > 0 {
+		s.log.Info("dropping log records the collector did not accept in time", "dropped", dropped)
+		s.pending = append([]Record(nil), s.pending[dropped:]...)
+	}
+}
+
+// flush exports the pending records in batches.
+func (s *Shipper) flush(ctx context.Context) error {
+	for len(s.pending) > 0 {
+		n := min(len(s.pending), s.opts.BatchSize)
+		if err := s.export(ctx, s.pending[:n]); err != nil {
+			return err
+		}
+		s.pending = s.pending[n:]
+	}
+	s.pending = nil
+	return nil
+}
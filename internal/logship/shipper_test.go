@@ -0,0 +1,141 @@
+package logship
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCollector records the bodies of the log records exported to it.
+type fakeCollector struct {
+	srv *httptest.Server
+
+	mu     sync.Mutex
+	bodies []string
+	header http.Header
+	// failing rejects the exports.
+	failing bool
+}
+
+func newFakeCollector(t *testing.T) *fakeCollector {
+	c := &fakeCollector{}
+	c.srv = httptest.NewServer(http.HandlerFunc(c.serve))
+	t.Cleanup(c.srv.Close)
+	return c
+}
+
+func (c *fakeCollector) serve(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r.URL.Path != logsPath || r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if c.failing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var req exportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.header = r.Header
+	for _, l := range req.ResourceLogs[0].ScopeLogs[0].LogRecords {
+		c.bodies = append(c.bodies, *l.Body.StringValue)
+	}
+	w.Write([]byte(`{}`))
+}
+
+func (c *fakeCollector) received() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.bodies...)
+}
+
+func appendFile(t *testing.T, path, data string) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func newTestShipper(t *testing.T, c *fakeCollector, dir string) *Shipper {
+	return New(Options{
+		LogsDir:  dir,
+		Kinds:    []Kind{KindError, KindAccess},
+		Endpoint: c.srv.URL,
+		Headers:  map[string]string{"Authorization": "Bearer token"},
+		Resource: testResource,
+		Location: time.UTC,
+	}, testr.New(t))
+}
+
+func TestShipperFollowsFiles(t *testing.T) {
+	c := newFakeCollector(t)
+	dir := t.TempDir()
+	errorLog := filepath.Join(dir, "ErrorLog.txt")
+	appendFile(t, errorLog, "2024-05-01 10:00:00.000 Info: one\n2024-05-01 10:00:01.000 Info: tw")
+	appendFile(t, filepath.Join(dir, "CrashLog.txt"), "not shipped\n")
+	s := newTestShipper(t, c, dir)
+	ctx := context.Background()
+
+	s.read()
+	require.NoError(t, s.flush(ctx))
+	assert.Equal(t, []string{"one"}, c.received(), "incomplete lines wait for their end")
+	assert.Equal(t, "Bearer token", c.header.Get("Authorization"))
+
+	// MarkLogic rotates the log by renaming it and starting a new one.
+	appendFile(t, errorLog, "o\n2024-05-01 10:00:02.000 Info: three")
+	require.NoError(t, os.Rename(errorLog, filepath.Join(dir, "ErrorLog_1.txt")))
+	appendFile(t, errorLog, "2024-05-01 10:00:03.000 Info: four\n")
+	appendFile(t, filepath.Join(dir, "8000_AccessLog.txt"), `10.0.0.9 - admin [01/May/2024:10:06:00 +0000] "GET / HTTP/1.1" 200 12 - "curl/8.0.1"`+"\n")
+	s.read()
+	require.NoError(t, s.flush(ctx))
+	assert.Equal(t, []string{"one", `10.0.0.9 - admin [01/May/2024:10:06:00 +0000] "GET / HTTP/1.1" 200 12 - "curl/8.0.1"`, "two", "three", "four"}, c.received())
+
+	// A truncated file is read again from its head.
+	require.NoError(t, os.Truncate(errorLog, 0))
+	appendFile(t, errorLog, "2024-05-01 10:00:04.000 Info: 5\n")
+	s.read()
+	require.NoError(t, s.flush(ctx))
+	assert.Equal(t, []string{"5"}, c.received()[5:])
+}
+
+func TestShipperRetriesFailedExports(t *testing.T) {
+	c := newFakeCollector(t)
+	c.failing = true
+	dir := t.TempDir()
+	appendFile(t, filepath.Join(dir, "ErrorLog.txt"), "2024-05-01 10:00:00.000 Info: one\n2024-05-01 10:00:01.000 Info: two\n")
+	s := newTestShipper(t, c, dir)
+	s.opts.MaxBuffered = 1
+
+	s.read()
+	require.Error(t, s.flush(context.Background()))
+	assert.Len(t, s.pending, 1, "the oldest records are dropped beyond MaxBuffered")
+
+	// The pending records are exported when the pod stops.
+	c.failing = false
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, s.Run(ctx))
+	assert.Equal(t, []string{"two"}, c.received())
+	assert.Empty(t, s.pending)
+}
+
+func TestLogsURL(t *testing.T) {
+	assert.Equal(t, "http://otel-collector:4318/v1/logs", LogsURL("http://otel-collector:4318"))
+	assert.Equal(t, "http://otel-collector:4318/v1/logs", LogsURL("http://otel-collector:4318/"))
+	assert.Equal(t, "https://otlp.example.com/v1/logs", LogsURL("https://otlp.example.com/v1/logs"))
+}
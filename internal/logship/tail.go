@@ -0,0 +1,152 @@
+package logship
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	// maxReadPerPoll bounds what is read from a file on each poll, so a
+	// large file is shipped over several flushes.
+	maxReadPerPoll = 1 << 20
+	// maxLineLength bounds a line. Longer lines are split.
+	maxLineLength = 64 << 10
+)
+
+// line is a line read from a log file.
+type line struct {
+	path string
+	text string
+}
+
+// tailedFile is a log file being followed. The file stays open, so the
+// lines written before MarkLogic rotates it are still read.
+type tailedFile struct {
+	f       *os.File
+	info    os.FileInfo
+	offset  int64
+	partial []byte
+}
+
+// tailer follows the log files of the given kinds in a directory from their
+// head, like the tail inputs of the fluent-bit sidecar.
+type tailer struct {
+	dir   string
+	kinds []Kind
+	files map[string]*tailedFile
+	buf   []byte
+}
+
+func newTailer(dir string, kinds []Kind) *tailer {
+	return &tailer{dir: dir, kinds: kinds, files: map[string]*tailedFile{}, buf: make([]byte, maxReadPerPoll)}
+}
+
+// poll returns the complete lines appended to the files since the last poll.
+// A file replaced by a new one, after a rotation, is read to its end before
+// the new one is read from its head. A truncated file is read again from its
+// head.
+func (t *tailer) poll() ([]line, error) {
+	var paths []string
+	for _, k := range t.kinds {
+		matches, err := filepath.Glob(filepath.Join(t.dir, k.pattern()))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+
+	var lines []line
+	var errs []error
+	seen := map[string]bool{}
+	for _, path := range paths {
+		seen[path] = true
+		tf := t.files[path]
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if tf != nil && !os.SameFile(tf.info, info) {
+			lines = append(lines, tf.read(t.buf, path, true)...)
+			tf.f.Close()
+			tf = nil
+		}
+		if tf == nil {
+			f, err := os.Open(path)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			tf = &tailedFile{f: f, info: info}
+			t.files[path] = tf
+		}
+		lines = append(lines, tf.read(t.buf, path, false)...)
+	}
+	for path, tf := range t.files {
+		if !seen[path] {
+			lines = append(lines, tf.read(t.buf, path, true)...)
+			tf.f.Close()
+			delete(t.files, path)
+		}
+	}
+	return lines, errors.Join(errs...)
+}
+
+// read returns the lines appended to the file. A file done with is read to
+// its end and its last line returned even without its newline.
+func (tf *tailedFile) read(buf []byte, path string, last bool) []line {
+	if info, err := tf.f.Stat(); err == nil && info.Size() < tf.offset {
+		tf.offset, tf.partial = 0, nil
+	}
+	var lines []line
+	for {
+		n, err := tf.f.ReadAt(buf, tf.offset)
+		if err != nil && err != io.EOF {
+			return lines
+		}
+		tf.offset += int64(n)
+		lines = append(lines, tf.split(path, buf[:n], last && n < len(buf))...)
+		if !last || n < len(buf) {
+			return lines
+		}
+	}
+}
+
+// split returns the complete lines of data following the partial line of the
+// previous read, keeping the rest for the next one unless flush is set.
+func (tf *tailedFile) split(path string, data []byte, flush bool) []line {
+	data = append(tf.partial, data...)
+	tf.partial = nil
+	var lines []line
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		lines = append(lines, newLine(path, data[:i]))
+		data = data[i+1:]
+	}
+	switch {
+	case len(data) == 0:
+	case flush || len(data) >= maxLineLength:
+		lines = append(lines, newLine(path, data))
+	default:
+		tf.partial = append([]byte(nil), data...)
+	}
+	return lines
+}
+
+func newLine(path string, b []byte) line {
+	return line{path: path, text: string(bytes.TrimSuffix(b, []byte("\r")))}
+}
+
+// close closes the followed files.
+func (t *tailer) close() {
+	for _, tf := range t.files {
+		tf.f.Close()
+	}
+}
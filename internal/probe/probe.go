@@ -0,0 +1,161 @@
+// Package probe checks the health of the MarkLogic host of a pod for the
+// kubelet.
+//
+// It is the Go implementation of the probe scripts of the chart, which only
+// look at the MarkLogic service and the health check port. Liveness fails
+// when MarkLogic stops answering, readiness only passes once the host serves
+// as a member of the cluster, and startup passes once the host completed its
+// bootstrap.
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/marklogic/marklogic-kubernetes/internal/bootstrap"
+	"github.com/marklogic/marklogic-kubernetes/internal/hostclient"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// Modes of a probe.
+const (
+	Liveness  = "liveness"
+	Readiness = "readiness"
+	Startup   = "startup"
+)
+
+// securityDatabase must be available for the host to authenticate requests.
+const securityDatabase = "Security"
+
+// unmountedForestStates are the states of forests the host does not serve.
+var unmountedForestStates = map[string]bool{
+	"unmounted": true,
+	"mounting":  true,
+	"error":     true,
+}
+
+// Options configures a Prober.
+type Options struct {
+	// Host is the FQDN of the host, the name MarkLogic knows it by.
+	Host string
+	// Username and Password are the MarkLogic admin credentials.
+	Username string
+	Password string
+	// TLSEnabled makes the probes use https.
+	TLSEnabled bool
+	// StatusDir holds the status file of the bootstrap.
+	StatusDir string
+	// Running reports whether the MarkLogic process runs.
+	Running func() bool
+}
+
+// Prober runs the probes of one host.
+type Prober struct {
+	opts    Options
+	clients hostclient.Factory
+}
+
+// New returns a Prober for opts. newClient may be nil to use mlclient.New.
+func New(opts Options, newClient mlclient.Factory) *Prober {
+	if opts.StatusDir == "" {
+		opts.StatusDir = bootstrap.DefaultStatusDir
+	}
+	return &Prober{opts: opts, clients: hostclient.Factory{New: newClient}}
+}
+
+// Run runs the probe of mode and returns why it failed.
+func (p *Prober) Run(ctx context.Context, mode string) error {
+	switch mode {
+	case Liveness:
+		return p.Liveness(ctx)
+	case Readiness:
+		return p.Readiness(ctx)
+	case Startup:
+		return p.Startup()
+	}
+	return fmt.Errorf("unknown probe %q, expected %s, %s or %s", mode, Liveness, Readiness, Startup)
+}
+
+// Liveness passes while the MarkLogic process runs and answers on the
+// timestamp service. A 401 answer tells that MarkLogic is alive as well.
+func (p *Prober) Liveness(ctx context.Context) error {
+	if p.opts.Running != nil && !p.opts.Running() {
+		return errors.New("MarkLogic is not running")
+	}
+	c, err := p.client(false)
+	if err != nil {
+		return err
+	}
+	if _, err := c.Timestamp(ctx); err != nil && !mlclient.IsUnauthorized(err) {
+		return fmt.Errorf("MarkLogic does not answer: %w", err)
+	}
+	return nil
+}
+
+// Readiness passes once the cluster sees the host online, the Security
+// database is available and every forest of the host is mounted, so no
+// traffic reaches a host that is up but has not joined the cluster yet.
+func (p *Prober) Readiness(ctx context.Context) error {
+	c, err := p.client(true)
+	if err != nil {
+		return err
+	}
+	online, err := c.HostOnline(ctx, p.opts.Host)
+	if mlclient.IsNotFound(err) {
+		return fmt.Errorf("host %s has not joined the cluster", p.opts.Host)
+	}
+	if err != nil {
+		return fmt.Errorf("reading status of host %s: %w", p.opts.Host, err)
+	}
+	if !online {
+		return fmt.Errorf("host %s is not online in the cluster", p.opts.Host)
+	}
+
+	state, err := c.DatabaseState(ctx, securityDatabase)
+	if err != nil {
+		return fmt.Errorf("reading status of the %s database: %w", securityDatabase, err)
+	}
+	if state != mlclient.DatabaseAvailable {
+		return fmt.Errorf("the %s database is %s", securityDatabase, state)
+	}
+
+	forests, err := c.HostForests(ctx, p.opts.Host)
+	if err != nil {
+		return fmt.Errorf("listing forests of %s: %w", p.opts.Host, err)
+	}
+	for _, name := range forests {
+		state, err := c.ForestState(ctx, name)
+		if err != nil {
+			return fmt.Errorf("reading state of forest %s: %w", name, err)
+		}
+		if unmountedForestStates[state] {
+			return fmt.Errorf("forest %s is %s", name, state)
+		}
+	}
+	return nil
+}
+
+// Startup passes once the host completed its bootstrap.
+func (p *Prober) Startup() error {
+	done, err := bootstrap.Completed(p.opts.StatusDir)
+	if err != nil {
+		return err
+	}
+	if !done {
+		return errors.New("the host has not completed its bootstrap")
+	}
+	return nil
+}
+
+// client returns a client of the local host, using https when TLS is enabled.
+// A probe is retried by the kubelet, not by the client.
+func (p *Prober) client(auth bool) (*mlclient.Client, error) {
+	cfg := mlclient.Config{Host: hostclient.Localhost, HTTPS: p.opts.TLSEnabled, MaxRetries: -1, Timeout: 10 * time.Second}
+	if auth {
+		cfg.Username = p.opts.Username
+		cfg.Password = p.opts.Password
+	}
+	return p.clients.Client(cfg, p.opts.Host)
+}
@@ -0,0 +1,137 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+const testHost = "dnode-1.dnode.marklogic.svc.cluster.local"
+
+// fakeHost emulates the timestamp service and the status views of the
+// Management API of a host.
+type fakeHost struct {
+	mu        sync.Mutex
+	srv       *httptest.Server
+	timestamp int
+	joined    bool
+	online    bool
+	security  string
+	forests   map[string]string
+}
+
+func newFakeHost(t *testing.T) *fakeHost {
+	f := &fakeHost{
+		timestamp: http.StatusOK,
+		joined:    true,
+		online:    true,
+		security:  mlclient.DatabaseAvailable,
+		forests:   map[string]string{"App-1": mlclient.ForestOpen, "App-1-r": mlclient.ForestSyncReplicating},
+	}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeHost) newClient(cfg mlclient.Config) (*mlclient.Client, error) {
+	u, _ := url.Parse(f.srv.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+	p, _ := strconv.Atoi(port)
+	cfg.Host = "127.0.0.1"
+	cfg.HTTPS = false
+	cfg.TLSConfig = nil
+	cfg.AdminPort, cfg.ManagePort = p, p
+	return mlclient.New(cfg)
+}
+
+func (f *fakeHost) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case "/admin/v1/timestamp":
+		w.WriteHeader(f.timestamp)
+		fmt.Fprint(w, "2024-01-01T00:00:00")
+	case "/manage/v2/hosts/" + testHost:
+		if !f.joined {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"host-status":{"status-properties":{"online":{"value":%t}}}}`, f.online)
+	case "/manage/v2/databases/Security":
+		fmt.Fprintf(w, `{"database-status":{"status-properties":{"state":{"value":%q}}}}`, f.security)
+	case "/manage/v2/forests":
+		fmt.Fprint(w, `{"forest-default-list":{"list-items":{"list-item":[{"nameref":"App-1"},{"nameref":"App-1-r"}]}}}`)
+	default:
+		var name string
+		if _, err := fmt.Sscanf(r.URL.Path, "/manage/v2/forests/%s", &name); err != nil || f.forests[name] == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"forest-status":{"status-properties":{"state":{"value":%q}}}}`, f.forests[name])
+	}
+}
+
+func newTestProber(f *fakeHost, running bool, statusDir string) *Prober {
+	return New(Options{
+		Host:      testHost,
+		Username:  "admin",
+		Password:  "admin",
+		StatusDir: statusDir,
+		Running:   func() bool { return running },
+	}, f.newClient)
+}
+
+func TestLiveness(t *testing.T) {
+	f := newFakeHost(t)
+	ctx := context.Background()
+	require.NoError(t, newTestProber(f, true, "").Run(ctx, Liveness))
+	require.ErrorContains(t, newTestProber(f, false, "").Run(ctx, Liveness), "not running")
+
+	f.timestamp = http.StatusUnauthorized
+	require.NoError(t, newTestProber(f, true, "").Run(ctx, Liveness))
+
+	f.timestamp = http.StatusInternalServerError
+	require.ErrorContains(t, newTestProber(f, true, "").Run(ctx, Liveness), "does not answer")
+}
+
+func TestReadiness(t *testing.T) {
+	f := newFakeHost(t)
+	ctx := context.Background()
+	p := newTestProber(f, true, "")
+	require.NoError(t, p.Run(ctx, Readiness))
+
+	f.forests["App-1"] = "mounting"
+	require.ErrorContains(t, p.Run(ctx, Readiness), "forest App-1 is mounting")
+
+	f.security = "unavailable"
+	require.ErrorContains(t, p.Run(ctx, Readiness), "the Security database is unavailable")
+
+	f.online = false
+	require.ErrorContains(t, p.Run(ctx, Readiness), "is not online")
+
+	f.joined = false
+	require.ErrorContains(t, p.Run(ctx, Readiness), "has not joined the cluster")
+}
+
+func TestStartup(t *testing.T) {
+	dir := t.TempDir()
+	p := newTestProber(newFakeHost(t), true, dir)
+	require.ErrorContains(t, p.Run(context.Background(), Startup), "not completed its bootstrap")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "status.txt"), []byte("fqdn="+testHost+"\n"), 0o644))
+	require.NoError(t, p.Run(context.Background(), Startup))
+
+	require.ErrorContains(t, p.Run(context.Background(), "ready"), "unknown probe")
+}
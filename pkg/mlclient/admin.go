@@ -0,0 +1,209 @@
+package mlclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Restart describes a restart triggered by an Admin API call that answered
+// 202 Accepted. LastStartup is the timestamp of the startup the restart will
+// replace and can be compared against Timestamp to detect the restart.
+type Restart struct {
+	LastStartup string
+}
+
+type restartResponse struct {
+	Restart struct {
+		LastStartup []struct {
+			Value  string `json:"value"`
+			HostID string `json:"host-id"`
+		} `json:"last-startup"`
+	} `json:"restart"`
+}
+
+// restart decodes the body of a 202 response. It returns nil for any other
+// status since no restart was triggered.
+func restart(resp *response) *Restart {
+	if resp.statusCode != http.StatusAccepted {
+		return nil
+	}
+	r := &Restart{}
+	var body restartResponse
+	if json.Unmarshal(resp.body, &body) == nil && len(body.Restart.LastStartup) > 0 {
+		r.LastStartup = body.Restart.LastStartup[0].Value
+	}
+	return r
+}
+
+// Timestamp returns the last startup time of the host from
+// GET /admin/v1/timestamp. This call needs no authentication before security
+// is initialized.
+func (c *Client) Timestamp(ctx context.Context) (string, error) {
+	resp, err := c.do(ctx, request{
+		method: http.MethodGet,
+		port:   c.cfg.AdminPort,
+		path:   "/admin/v1/timestamp",
+		expect: []int{http.StatusOK},
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(resp.body)), nil
+}
+
+// InitRequest is the payload of POST /admin/v1/init.
+type InitRequest struct {
+	LicenseKey string `json:"license-key,omitempty"`
+	Licensee   string `json:"licensee,omitempty"`
+}
+
+// Init initializes a freshly installed host. It returns a non-nil Restart
+// when the host restarts to complete the initialization.
+func (c *Client) Init(ctx context.Context, in InitRequest) (*Restart, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, request{
+		method:      http.MethodPost,
+		port:        c.cfg.AdminPort,
+		path:        "/admin/v1/init",
+		body:        body,
+		contentType: "application/json",
+		accept:      "application/json",
+		expect:      []int{http.StatusAccepted, http.StatusNoContent},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restart(resp), nil
+}
+
+// InstanceAdminRequest is the payload of POST /admin/v1/instance-admin.
+type InstanceAdminRequest struct {
+	AdminUsername  string
+	AdminPassword  string
+	Realm          string
+	WalletPassword string
+}
+
+// InstanceAdmin installs the security database and creates the admin user.
+// The host restarts when the call succeeds.
+func (c *Client) InstanceAdmin(ctx context.Context, in InstanceAdminRequest) (*Restart, error) {
+	form := url.Values{}
+	form.Set("admin-username", in.AdminUsername)
+	form.Set("admin-password", in.AdminPassword)
+	if in.Realm != "" {
+		form.Set("realm", in.Realm)
+	}
+	if in.WalletPassword != "" {
+		form.Set("wallet-password", in.WalletPassword)
+	}
+	resp, err := c.do(ctx, request{
+		method:      http.MethodPost,
+		port:        c.cfg.AdminPort,
+		path:        "/admin/v1/instance-admin",
+		body:        []byte(form.Encode()),
+		contentType: "application/x-www-form-urlencoded; charset=utf-8",
+		accept:      "application/json",
+		expect:      []int{http.StatusAccepted},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restart(resp), nil
+}
+
+// ServerConfig returns the XML server configuration of the host, which a
+// joining host sends to the cluster it joins.
+func (c *Client) ServerConfig(ctx context.Context) ([]byte, error) {
+	resp, err := c.do(ctx, request{
+		method: http.MethodGet,
+		port:   c.cfg.AdminPort,
+		path:   "/admin/v1/server-config",
+		accept: "application/xml",
+		expect: []int{http.StatusOK},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.body, nil
+}
+
+// ClusterConfig asks a host that is already part of the cluster to accept
+// the host described by serverConfig into group. It returns the cluster
+// configuration zip to pass to JoinCluster on the joining host.
+func (c *Client) ClusterConfig(ctx context.Context, group string, serverConfig []byte) ([]byte, error) {
+	form := url.Values{}
+	form.Set("group", group)
+	form.Set("server-config", string(serverConfig))
+	resp, err := c.do(ctx, request{
+		method:      http.MethodPost,
+		port:        c.cfg.AdminPort,
+		path:        "/admin/v1/cluster-config",
+		body:        []byte(form.Encode()),
+		contentType: "application/x-www-form-urlencoded",
+		accept:      "application/zip",
+		expect:      []int{http.StatusOK},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.body, nil
+}
+
+// JoinCluster applies the cluster configuration zip returned by ClusterConfig
+// to the host. The host restarts when the call succeeds.
+func (c *Client) JoinCluster(ctx context.Context, clusterConfig []byte) (*Restart, error) {
+	resp, err := c.do(ctx, request{
+		method:      http.MethodPost,
+		port:        c.cfg.AdminPort,
+		path:        "/admin/v1/cluster-config",
+		body:        clusterConfig,
+		contentType: "application/zip",
+		accept:      "application/json",
+		expect:      []int{http.StatusAccepted},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restart(resp), nil
+}
+
+// SecurityUpgrade upgrades the Security database to the MarkLogic version the
+// host runs, after the host holding it was upgraded. It returns a non-nil
+// Restart when the host restarts to complete the upgrade.
+func (c *Client) SecurityUpgrade(ctx context.Context) (*Restart, error) {
+	resp, err := c.do(ctx, request{
+		method: http.MethodPost,
+		port:   c.cfg.AdminPort,
+		path:   "/admin/v1/security-upgrade",
+		accept: "application/json",
+		expect: []int{http.StatusOK, http.StatusAccepted, http.StatusNoContent},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restart(resp), nil
+}
+
+// RemoveHost removes host from the cluster with DELETE /admin/v1/host-config.
+// The host must not hold any forests. It returns a non-nil Restart when the
+// cluster restarts to apply the change.
+func (c *Client) RemoveHost(ctx context.Context, host string) (*Restart, error) {
+	resp, err := c.do(ctx, request{
+		method: http.MethodDelete,
+		port:   c.cfg.AdminPort,
+		path:   "/admin/v1/host-config",
+		query:  url.Values{"remote-host": []string{host}},
+		accept: "application/json",
+		expect: []int{http.StatusAccepted, http.StatusNoContent},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restart(resp), nil
+}
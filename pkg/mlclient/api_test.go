@@ -0,0 +1,404 @@
+package mlclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorded is a request seen by the fake MarkLogic server.
+type recorded struct {
+	method      string
+	uri         string
+	contentType string
+	body        string
+}
+
+// fakeServer answers every request with status and body and records it.
+func fakeServer(t *testing.T, status int, body string) (*Client, *[]recorded) {
+	t.Helper()
+	var seen []recorded
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		seen = append(seen, recorded{r.Method, r.URL.RequestURI(), r.Header.Get("Content-Type"), string(data)})
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return testClient(t, srv, Config{}), &seen
+}
+
+func TestInit(t *testing.T) {
+	c, seen := fakeServer(t, http.StatusAccepted,
+		`{"restart":{"last-startup":[{"value":"2024-05-01T10:00:00Z","host-id":"123"}],"link":[]}}`)
+
+	restart, err := c.Init(context.Background(), InitRequest{LicenseKey: "key", Licensee: "me"})
+	require.NoError(t, err)
+	require.NotNil(t, restart)
+	assert.Equal(t, "2024-05-01T10:00:00Z", restart.LastStartup)
+	assert.Equal(t, "/admin/v1/init", (*seen)[0].uri)
+	assert.JSONEq(t, `{"license-key":"key","licensee":"me"}`, (*seen)[0].body)
+
+	c, seen = fakeServer(t, http.StatusNoContent, "")
+	restart, err = c.Init(context.Background(), InitRequest{})
+	require.NoError(t, err)
+	assert.Nil(t, restart)
+	assert.JSONEq(t, `{}`, (*seen)[0].body)
+}
+
+func TestInstanceAdmin(t *testing.T) {
+	c, seen := fakeServer(t, http.StatusAccepted, `{"restart":{"last-startup":[{"value":"ts"}]}}`)
+
+	restart, err := c.InstanceAdmin(context.Background(), InstanceAdminRequest{AdminUsername: "admin", AdminPassword: "p&ss", Realm: "public"})
+	require.NoError(t, err)
+	assert.Equal(t, "ts", restart.LastStartup)
+	assert.Equal(t, "admin-password=p%26ss&admin-username=admin&realm=public", (*seen)[0].body)
+	assert.Contains(t, (*seen)[0].contentType, "application/x-www-form-urlencoded")
+
+	c, _ = fakeServer(t, http.StatusUnauthorized, "")
+	_, err = c.InstanceAdmin(context.Background(), InstanceAdminRequest{})
+	assert.True(t, IsUnauthorized(err))
+}
+
+func TestJoinCluster(t *testing.T) {
+	c, seen := fakeServer(t, http.StatusOK, "zip-bytes")
+	zip, err := c.ClusterConfig(context.Background(), "Default", []byte("<host/>"))
+	require.NoError(t, err)
+	assert.Equal(t, "zip-bytes", string(zip))
+	assert.Equal(t, "group=Default&server-config=%3Chost%2F%3E", (*seen)[0].body)
+
+	c, seen = fakeServer(t, http.StatusAccepted, "")
+	restart, err := c.JoinCluster(context.Background(), zip)
+	require.NoError(t, err)
+	assert.NotNil(t, restart)
+	assert.Equal(t, "application/zip", (*seen)[0].contentType)
+	assert.Equal(t, "zip-bytes", (*seen)[0].body)
+}
+
+func TestSecurityUpgrade(t *testing.T) {
+	c, seen := fakeServer(t, http.StatusAccepted, `{"restart":{"last-startup":[{"value":"ts"}]}}`)
+	restart, err := c.SecurityUpgrade(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "ts", restart.LastStartup)
+	assert.Equal(t, "POST", (*seen)[0].method)
+	assert.Equal(t, "/admin/v1/security-upgrade", (*seen)[0].uri)
+
+	c, _ = fakeServer(t, http.StatusNoContent, "")
+	restart, err = c.SecurityUpgrade(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, restart, "nothing to upgrade")
+}
+
+func TestRemoveHost(t *testing.T) {
+	c, seen := fakeServer(t, http.StatusAccepted, `{"restart":{"last-startup":[{"value":"ts"}]}}`)
+	restart, err := c.RemoveHost(context.Background(), "dnode-2.dnode.marklogic.svc.cluster.local")
+	require.NoError(t, err)
+	assert.Equal(t, "ts", restart.LastStartup)
+	assert.Equal(t, "DELETE", (*seen)[0].method)
+	assert.Equal(t, "/admin/v1/host-config?remote-host=dnode-2.dnode.marklogic.svc.cluster.local", (*seen)[0].uri)
+}
+
+func TestCertificates(t *testing.T) {
+	c, seen := fakeServer(t, http.StatusNoContent, "")
+	require.NoError(t, c.InsertHostCertificates(context.Background(), "defaultTemplate", HostCertificate{Cert: "cert", PKey: "key"}))
+	assert.Equal(t, "/manage/v2/certificate-templates/defaultTemplate", (*seen)[0].uri)
+	assert.JSONEq(t, `{"operation":"insert-host-certificates","certificates":[{"certificate":{"cert":"cert","pkey":"key"}}]}`, (*seen)[0].body)
+
+	c, seen = fakeServer(t, http.StatusCreated, "")
+	require.NoError(t, c.InsertCertificateAuthority(context.Background(), "-----BEGIN CERTIFICATE-----"))
+	assert.Equal(t, "POST", (*seen)[0].method)
+	assert.Equal(t, "/manage/v2/certificate-authorities", (*seen)[0].uri)
+	assert.Equal(t, "text/plain", (*seen)[0].contentType)
+	assert.Equal(t, "-----BEGIN CERTIFICATE-----", (*seen)[0].body)
+}
+
+func TestGroups(t *testing.T) {
+	c, seen := fakeServer(t, http.StatusOK, `{"group-name":"Default","xdqp-ssl-enabled":true,"list-cache-size":64}`)
+	props, err := c.GroupProperties(context.Background(), "Default")
+	require.NoError(t, err)
+	assert.Equal(t, "Default", props.GroupName)
+	assert.True(t, *props.XdqpSSLEnabled)
+	assert.Equal(t, "/manage/v2/groups/Default/properties?format=json", (*seen)[0].uri)
+
+	c, seen = fakeServer(t, http.StatusCreated, "")
+	disabled := false
+	require.NoError(t, c.CreateGroup(context.Background(), GroupProperties{GroupName: "enode", XdqpSSLEnabled: &disabled}))
+	assert.JSONEq(t, `{"group-name":"enode","xdqp-ssl-enabled":false}`, (*seen)[0].body)
+
+	c, _ = fakeServer(t, http.StatusNoContent, "")
+	restart, err := c.UpdateGroupProperties(context.Background(), "enode", GroupProperties{XdqpSSLEnabled: &disabled})
+	require.NoError(t, err)
+	assert.Nil(t, restart)
+}
+
+func TestServerProperties(t *testing.T) {
+	c, seen := fakeServer(t, http.StatusNoContent, "")
+	_, err := c.UpdateServerProperties(context.Background(), "App-Services", "dnode", ServerProperties{Authentication: "basic"})
+	require.NoError(t, err)
+	assert.Equal(t, http.MethodPut, (*seen)[0].method)
+	assert.Equal(t, "/manage/v2/servers/App-Services/properties?group-id=dnode", (*seen)[0].uri)
+	assert.JSONEq(t, `{"authentication":"basic"}`, (*seen)[0].body)
+}
+
+func TestBackupRestore(t *testing.T) {
+	c, seen := fakeServer(t, http.StatusOK, `{"job-id":"42","host-name":"ml-0"}`)
+	job, err := c.Backup(context.Background(), "Documents", BackupRequest{
+		BackupDir:       "/tmp/backup",
+		IncludeReplicas: true,
+		Incremental:     true,
+		IncrementalDir:  "/tmp/backup/incr",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, Job{JobID: "42", HostName: "ml-0"}, *job)
+	assert.Equal(t, "/manage/v2/databases/Documents", (*seen)[0].uri)
+	assert.JSONEq(t, `{"operation":"backup-database","backup-dir":"/tmp/backup","include-replicas":"true","incremental":"true","incremental-dir":"/tmp/backup/incr"}`, (*seen)[0].body)
+
+	c, seen = fakeServer(t, http.StatusOK, `{"job-id":"42","host-name":"ml-0","status":"completed"}`)
+	status, err := c.BackupStatus(context.Background(), "Documents", *job)
+	require.NoError(t, err)
+	assert.Equal(t, JobCompleted, status.Status)
+	var op map[string]string
+	require.NoError(t, json.Unmarshal([]byte((*seen)[0].body), &op))
+	assert.Equal(t, map[string]string{"operation": "backup-status", "job-id": "42", "host-name": "ml-0"}, op)
+
+	c, seen = fakeServer(t, http.StatusOK, `{"job-id":"43"}`)
+	job, err = c.Restore(context.Background(), "Documents", RestoreRequest{BackupDir: "/tmp/backup"})
+	require.NoError(t, err)
+	assert.Equal(t, "43", job.JobID)
+	assert.JSONEq(t, `{"operation":"restore-database","backup-dir":"/tmp/backup","include-replicas":"false"}`, (*seen)[0].body)
+
+	c, seen = fakeServer(t, http.StatusOK, `{"job-id":"43","host-name":"ml-0","status":"in-progress",`+
+		`"forest":[{"forest-name":"Documents","host-name":"ml-0","status":"completed"}]}`)
+	restore, err := c.Restore(context.Background(), "Documents", RestoreRequest{BackupDir: "/tmp/backup", RestoreToTime: "2024-05-01T10:00:00Z"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"operation":"restore-database","backup-dir":"/tmp/backup","include-replicas":"false","restore-to-time":"2024-05-01T10:00:00Z"}`,
+		(*seen)[0].body)
+	restoreStatus, err := c.RestoreStatus(context.Background(), "Documents", *restore)
+	require.NoError(t, err)
+	assert.Equal(t, JobInProgress, restoreStatus.Status)
+	assert.Equal(t, []ForestJobStatus{{ForestName: "Documents", HostName: "ml-0", Status: "completed"}}, restoreStatus.Forests)
+	assert.JSONEq(t, `{"operation":"restore-status","job-id":"43","host-name":"ml-0"}`, (*seen)[1].body)
+
+	c, seen = fakeServer(t, http.StatusOK, `{"forest":[{"forest-name":"Documents","status":"okay"},{"forest-name":"Other","status":"missing"}]}`)
+	validation, err := c.ValidateBackup(context.Background(), "Documents", RestoreRequest{BackupDir: "/tmp/backup", IncludeReplicas: true})
+	require.NoError(t, err)
+	assert.Equal(t, []ForestValidation{{ForestName: "Other", Status: "missing"}}, validation.Failed())
+	assert.JSONEq(t, `{"operation":"backup-validate","backup-dir":"/tmp/backup","include-replicas":"true"}`, (*seen)[0].body)
+
+	c, seen = fakeServer(t, http.StatusOK, "")
+	require.NoError(t, c.PurgeBackups(context.Background(), `Docs "A&B"`, "/backups", 3))
+	assert.Equal(t, "/v1/eval", (*seen)[0].uri)
+	form, err := url.ParseQuery((*seen)[0].body)
+	require.NoError(t, err)
+	assert.Equal(t, `xdmp:database-backup-purge("/backups", 3, xdmp:database-forests(xdmp:database("Docs ""A&amp;B""")))`, form.Get("xquery"))
+}
+
+func TestDatabasesAndForests(t *testing.T) {
+	c, seen := fakeServer(t, http.StatusOK, `{"database-name":"app","enabled":true,"range-element-index":[]}`)
+	props, err := c.DatabaseProperties(context.Background(), "app")
+	require.NoError(t, err)
+	assert.Equal(t, true, props["enabled"])
+	assert.Equal(t, "/manage/v2/databases/app/properties?format=json", (*seen)[0].uri)
+
+	c, seen = fakeServer(t, http.StatusOK, `{"database-status":{"status-properties":{"state":{"units":"enum","value":"available"}}}}`)
+	state, err := c.DatabaseState(context.Background(), "Security")
+	require.NoError(t, err)
+	assert.Equal(t, DatabaseAvailable, state)
+	assert.Equal(t, "/manage/v2/databases/Security?format=json&view=status", (*seen)[0].uri)
+
+	c, seen = fakeServer(t, http.StatusCreated, "")
+	require.NoError(t, c.CreateDatabase(context.Background(), DatabaseProperties{"database-name": "app"}))
+	require.NoError(t, c.CreateForest(context.Background(), ForestProperties{ForestName: "app-0-1", Host: "dnode-0", Database: "app"}))
+	assert.JSONEq(t, `{"forest-name":"app-0-1","host":"dnode-0","database":"app"}`, (*seen)[1].body)
+
+	c, seen = fakeServer(t, http.StatusOK, "")
+	require.NoError(t, c.AttachForest(context.Background(), "app-0-1", "app"))
+	assert.Equal(t, "/manage/v2/forests/app-0-1", (*seen)[0].uri)
+	assert.Equal(t, "database=app&state=attach", (*seen)[0].body)
+
+	require.NoError(t, c.RetireForest(context.Background(), "app-0-1"))
+	assert.Equal(t, "state=retire", (*seen)[1].body)
+	require.NoError(t, c.DetachForest(context.Background(), "app-0-1"))
+	assert.Equal(t, "state=detach", (*seen)[2].body)
+
+	c, seen = fakeServer(t, http.StatusOK, `{"forest-default-list":{"list-items":{"list-count":{"value":2},`+
+		`"list-item":[{"idref":"1","nameref":"app-2-1"},{"idref":"2","nameref":"app-2-2"}]}}}`)
+	forests, err := c.HostForests(context.Background(), "dnode-2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app-2-1", "app-2-2"}, forests)
+	assert.Equal(t, "/manage/v2/forests?format=json&host-id=dnode-2", (*seen)[0].uri)
+	forests, err = c.Forests(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app-2-1", "app-2-2"}, forests)
+	assert.Equal(t, "/manage/v2/forests?format=json", (*seen)[1].uri)
+
+	c, seen = fakeServer(t, http.StatusNoContent, "")
+	require.NoError(t, c.SetForestReplicas(context.Background(), "app-0-1", nil))
+	assert.Equal(t, "/manage/v2/forests/app-0-1/properties", (*seen)[0].uri)
+	assert.JSONEq(t, `{"forest-replica":[]}`, (*seen)[0].body)
+
+	c, seen = fakeServer(t, http.StatusOK, `{"forest-counts":{"count-properties":{"document-count":{"units":"quantity","value":42}}}}`)
+	count, err := c.ForestDocumentCount(context.Background(), "app-2-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), count)
+	assert.Equal(t, "/manage/v2/forests/app-2-1?format=json&view=counts", (*seen)[0].uri)
+
+	c, seen = fakeServer(t, http.StatusOK, `{"forest-status":{"status-properties":{"state":{"units":"enum","value":"sync replicating"}}}}`)
+	state, err = c.ForestState(context.Background(), "app-2-1-r")
+	require.NoError(t, err)
+	assert.Equal(t, ForestSyncReplicating, state)
+	assert.Equal(t, "/manage/v2/forests/app-2-1-r?format=json&view=status", (*seen)[0].uri)
+	require.NoError(t, c.RestartForest(context.Background(), "app-2-1"))
+	assert.Equal(t, "state=restart", (*seen)[1].body)
+
+	c, seen = fakeServer(t, http.StatusNotFound, "")
+	require.NoError(t, c.DeleteForest(context.Background(), "app-2-1"))
+	assert.Equal(t, "/manage/v2/forests/app-2-1?level=full", (*seen)[0].uri)
+	require.NoError(t, c.DeleteDatabase(context.Background(), "app", ForestDeleteData))
+	assert.Equal(t, "/manage/v2/databases/app?forest-delete=data", (*seen)[1].uri)
+	_, err = c.ForestProperties(context.Background(), "missing")
+	assert.True(t, IsNotFound(err))
+}
+
+func TestAppServers(t *testing.T) {
+	c, seen := fakeServer(t, http.StatusCreated, "")
+	require.NoError(t, c.CreateAppServer(context.Background(), "Default", AppServerProperties{"server-name": "app", "port": 8010}))
+	assert.Equal(t, "/manage/v2/servers?group-id=Default", (*seen)[0].uri)
+	assert.JSONEq(t, `{"server-name":"app","port":8010}`, (*seen)[0].body)
+
+	c, seen = fakeServer(t, http.StatusOK, `{"server-name":"app","port":8010,"url-rewriter":"/rewriter.xqy"}`)
+	props, err := c.AppServerProperties(context.Background(), "app", "Default")
+	require.NoError(t, err)
+	assert.Equal(t, "/rewriter.xqy", props["url-rewriter"])
+	assert.Equal(t, "/manage/v2/servers/app/properties?format=json&group-id=Default", (*seen)[0].uri)
+	server, err := c.ServerProperties(context.Background(), "app", "Default")
+	require.NoError(t, err)
+	assert.Equal(t, ServerProperties{ServerName: "app", Port: 8010}, *server)
+
+	c, _ = fakeServer(t, http.StatusAccepted, `{"restart":{"last-startup":[{"value":"ts"}]}}`)
+	restart, err := c.UpdateAppServerProperties(context.Background(), "app", "Default", AppServerProperties{"port": 8011})
+	require.NoError(t, err)
+	assert.Equal(t, "ts", restart.LastStartup)
+
+	c, seen = fakeServer(t, http.StatusNoContent, "")
+	require.NoError(t, c.DeleteAppServer(context.Background(), "app", "Default"))
+	assert.Equal(t, "DELETE /manage/v2/servers/app?group-id=Default", (*seen)[0].method+" "+(*seen)[0].uri)
+}
+
+func TestShutdownHost(t *testing.T) {
+	c, seen := fakeServer(t, http.StatusAccepted, "")
+	require.NoError(t, c.ShutdownHost(context.Background(), "dnode-1.dnode-headless", true))
+	assert.Equal(t, "POST /manage/v2/hosts/dnode-1.dnode-headless", (*seen)[0].method+" "+(*seen)[0].uri)
+	assert.Equal(t, "failover=true&state=shutdown", (*seen)[0].body)
+}
+
+func TestHosts(t *testing.T) {
+	c, seen := fakeServer(t, http.StatusOK,
+		`{"host-default-list":{"list-items":{"list-item":[{"nameref":"dnode-0.dnode-headless"},{"nameref":"dnode-1.dnode-headless"}]}}}`)
+	hosts, err := c.Hosts(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dnode-0.dnode-headless", "dnode-1.dnode-headless"}, hosts)
+	assert.Equal(t, "/manage/v2/hosts?format=json", (*seen)[0].uri)
+
+	c, seen = fakeServer(t, http.StatusOK, `{"host-status":{"status-properties":{"online":{"value":true}}}}`)
+	online, err := c.HostOnline(context.Background(), "dnode-1.dnode-headless")
+	require.NoError(t, err)
+	assert.True(t, online)
+	assert.Equal(t, "/manage/v2/hosts/dnode-1.dnode-headless?format=json&view=status", (*seen)[0].uri)
+}
+
+func TestMetrics(t *testing.T) {
+	c, seen := fakeServer(t, http.StatusOK, `{"host-metrics-list":{"metrics-relations":{"host-metrics-list":{"metrics":[
+		{"total-cpu-stat-user":{"units":"percentage","summary":{"data":{"entry":[{"dt":"2024-05-01T10:00:00Z","value":1.5},{"dt":"2024-05-01T10:00:10Z","value":2.5}]}}}},
+		{"memory-process-size":{"units":"MB","summary":{"data":{"entry":[{"dt":"2024-05-01T10:00:10Z","value":1024}]}}}},
+		{"host-detail":{"units":"","summary":{"data":{"entry":[]}}}},
+		{"host-name":"dnode-0"}
+	]}}}}`)
+	metrics, err := c.HostMetrics(context.Background(), "dnode-0.dnode-headless")
+	require.NoError(t, err)
+	assert.Equal(t, []Metric{
+		{Name: "memory-process-size", Units: "MB", Value: 1024},
+		{Name: "total-cpu-stat-user", Units: "percentage", Value: 2.5},
+	}, metrics)
+	assert.Equal(t, "/manage/v2/hosts?detail=false&format=json&host-id=dnode-0.dnode-headless&period=raw&view=metrics", (*seen)[0].uri)
+
+	c, seen = fakeServer(t, http.StatusOK, `{"forest-metrics-list":{"metrics-relations":{"forest-metrics-list":{"metrics":[]}}}}`)
+	metrics, err = c.ForestMetrics(context.Background(), "Documents")
+	require.NoError(t, err)
+	assert.Empty(t, metrics)
+	assert.Equal(t, "/manage/v2/forests?detail=false&forest-id=Documents&format=json&period=raw&view=metrics", (*seen)[0].uri)
+
+	c, seen = fakeServer(t, http.StatusOK, `{"database-default-list":{"list-items":{"list-item":[{"nameref":"Documents"},{"nameref":"Security"}]}}}`)
+	databases, err := c.Databases(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Documents", "Security"}, databases)
+	assert.Equal(t, "/manage/v2/databases?format=json", (*seen)[0].uri)
+}
+
+func TestUsers(t *testing.T) {
+	c, seen := fakeServer(t, http.StatusNoContent, "")
+	require.NoError(t, c.UpdateUserProperties(context.Background(), "admin", UserProperties{"password": "rotated"}))
+	assert.Equal(t, "PUT /manage/v2/users/admin/properties", (*seen)[0].method+" "+(*seen)[0].uri)
+	assert.JSONEq(t, `{"password":"rotated"}`, (*seen)[0].body)
+
+	c, seen = fakeServer(t, http.StatusCreated, "")
+	require.NoError(t, c.CreateUser(context.Background(), UserProperties{"user-name": "app", "password": "secret", "role": []string{"rest-reader"}}))
+	assert.Equal(t, "POST /manage/v2/users", (*seen)[0].method+" "+(*seen)[0].uri)
+	assert.JSONEq(t, `{"user-name":"app","password":"secret","role":["rest-reader"]}`, (*seen)[0].body)
+
+	c, seen = fakeServer(t, http.StatusOK, `{"user-name":"app","role":["rest-reader"]}`)
+	props, err := c.UserProperties(context.Background(), "app")
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"rest-reader"}, props["role"])
+	assert.Equal(t, "/manage/v2/users/app/properties?format=json", (*seen)[0].uri)
+
+	c, seen = fakeServer(t, http.StatusNotFound, "")
+	require.NoError(t, c.DeleteUser(context.Background(), "app"))
+	assert.Equal(t, "DELETE /manage/v2/users/app", (*seen)[0].method+" "+(*seen)[0].uri)
+}
+
+func TestRoles(t *testing.T) {
+	c, seen := fakeServer(t, http.StatusCreated, "")
+	require.NoError(t, c.CreateRole(context.Background(), RoleProperties{"role-name": "app-reader", "role": []string{"rest-reader"}}))
+	assert.Equal(t, "POST /manage/v2/roles", (*seen)[0].method+" "+(*seen)[0].uri)
+	assert.JSONEq(t, `{"role-name":"app-reader","role":["rest-reader"]}`, (*seen)[0].body)
+
+	c, seen = fakeServer(t, http.StatusOK, `{"role-name":"app-reader","privilege":[{"privilege-name":"any-uri","action":"http://marklogic.com/xdmp/privileges/any-uri","kind":"execute"}]}`)
+	props, err := c.RoleProperties(context.Background(), "app-reader")
+	require.NoError(t, err)
+	assert.Len(t, props["privilege"], 1)
+	assert.Equal(t, "/manage/v2/roles/app-reader/properties?format=json", (*seen)[0].uri)
+
+	c, seen = fakeServer(t, http.StatusNoContent, "")
+	require.NoError(t, c.UpdateRoleProperties(context.Background(), "app-reader", RoleProperties{"description": "reads app"}))
+	assert.Equal(t, "PUT /manage/v2/roles/app-reader/properties", (*seen)[0].method+" "+(*seen)[0].uri)
+	require.NoError(t, c.DeleteRole(context.Background(), "app-reader"))
+	assert.Equal(t, "DELETE /manage/v2/roles/app-reader", (*seen)[1].method+" "+(*seen)[1].uri)
+}
+
+func TestExternalSecurity(t *testing.T) {
+	c, seen := fakeServer(t, http.StatusCreated, "")
+	require.NoError(t, c.CreateExternalSecurity(context.Background(), ExternalSecurityProperties{"external-security-name": "ldap", "authentication": "ldap"}))
+	assert.Equal(t, "POST /manage/v2/external-security", (*seen)[0].method+" "+(*seen)[0].uri)
+	assert.JSONEq(t, `{"external-security-name":"ldap","authentication":"ldap"}`, (*seen)[0].body)
+
+	c, seen = fakeServer(t, http.StatusOK, `{"external-security-name":"ldap","cache-timeout":300}`)
+	props, err := c.ExternalSecurityProperties(context.Background(), "ldap")
+	require.NoError(t, err)
+	assert.Equal(t, float64(300), props["cache-timeout"])
+	assert.Equal(t, "/manage/v2/external-security/ldap/properties?format=json", (*seen)[0].uri)
+
+	c, seen = fakeServer(t, http.StatusNoContent, "")
+	require.NoError(t, c.UpdateExternalSecurityProperties(context.Background(), "ldap", ExternalSecurityProperties{"cache-timeout": 60}))
+	assert.Equal(t, "PUT /manage/v2/external-security/ldap/properties", (*seen)[0].method+" "+(*seen)[0].uri)
+	require.NoError(t, c.DeleteExternalSecurity(context.Background(), "ldap"))
+	assert.Equal(t, "DELETE /manage/v2/external-security/ldap", (*seen)[1].method+" "+(*seen)[1].uri)
+}
@@ -0,0 +1,76 @@
+package mlclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// CertificateTemplate is a certificate template in /manage/v2/certificate-templates.
+type CertificateTemplate struct {
+	TemplateName        string              `json:"template-name"`
+	TemplateDescription string              `json:"template-description,omitempty"`
+	KeyType             string              `json:"key-type,omitempty"`
+	KeyOptions          *KeyOptions         `json:"key-options,omitempty"`
+	Req                 *CertificateRequest `json:"req,omitempty"`
+}
+
+// KeyOptions are the key options of a certificate template.
+type KeyOptions struct {
+	KeyLength string `json:"key-length,omitempty"`
+}
+
+// CertificateRequest is the certificate request of a certificate template.
+type CertificateRequest struct {
+	Version string             `json:"version,omitempty"`
+	Subject CertificateSubject `json:"subject"`
+}
+
+// CertificateSubject is the subject of a certificate request.
+type CertificateSubject struct {
+	CommonName       string `json:"commonName,omitempty"`
+	OrganizationName string `json:"organizationName,omitempty"`
+}
+
+// HostCertificate is a PEM encoded certificate and private key signed for a host.
+type HostCertificate struct {
+	Cert string `json:"cert"`
+	PKey string `json:"pkey"`
+}
+
+// CreateCertificateTemplate creates a certificate template.
+func (c *Client) CreateCertificateTemplate(ctx context.Context, tmpl CertificateTemplate) error {
+	_, err := c.sendJSON(ctx, http.MethodPost, "/manage/v2/certificate-templates", nil, tmpl, http.StatusCreated)
+	return err
+}
+
+// InsertHostCertificates inserts named host certificates into a template.
+func (c *Client) InsertHostCertificates(ctx context.Context, template string, certs ...HostCertificate) error {
+	type certificate struct {
+		Certificate HostCertificate `json:"certificate"`
+	}
+	op := struct {
+		Operation    string        `json:"operation"`
+		Certificates []certificate `json:"certificates"`
+	}{Operation: "insert-host-certificates"}
+	for _, cert := range certs {
+		op.Certificates = append(op.Certificates, certificate{cert})
+	}
+	_, err := c.sendJSON(ctx, http.MethodPost, "/manage/v2/certificate-templates/"+url.PathEscape(template), nil, op,
+		http.StatusOK, http.StatusCreated, http.StatusNoContent)
+	return err
+}
+
+// InsertCertificateAuthority adds a PEM encoded CA certificate to the
+// certificate authorities trusted by MarkLogic.
+func (c *Client) InsertCertificateAuthority(ctx context.Context, cert string) error {
+	_, err := c.do(ctx, request{
+		method:      http.MethodPost,
+		port:        c.cfg.ManagePort,
+		path:        "/manage/v2/certificate-authorities",
+		body:        []byte(cert),
+		contentType: "text/plain",
+		expect:      []int{http.StatusCreated, http.StatusOK, http.StatusNoContent},
+	})
+	return err
+}
@@ -0,0 +1,309 @@
+// Package mlclient is a typed client for the MarkLogic Admin and Management REST APIs.
+//
+// A Client talks to a single MarkLogic host. It handles basic and digest
+// authentication, TLS, retries of transient failures and decodes MarkLogic
+// error responses into *Error values so callers can branch on the status
+// code the same way the chart scripts do with curl.
+package mlclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Default ports of the MarkLogic app servers used by this package.
+const (
+	DefaultAppServicesPort = 8000
+	DefaultAdminPort       = 8001
+	DefaultManagePort      = 8002
+	DefaultHealthCheckPort = 7997
+)
+
+const (
+	defaultTimeout       = 30 * time.Second
+	defaultRetryInterval = 5 * time.Second
+	defaultMaxRetries    = 3
+)
+
+// AuthMethod selects how the client authenticates against MarkLogic.
+type AuthMethod int
+
+const (
+	// AuthAny uses whatever scheme the server challenges with, like curl --anyauth.
+	AuthAny AuthMethod = iota
+	// AuthDigest only answers digest challenges.
+	AuthDigest
+	// AuthBasic sends basic credentials preemptively.
+	AuthBasic
+)
+
+// Config configures a Client.
+type Config struct {
+	// Host is the host name or address of the MarkLogic host.
+	Host string
+	// Username and Password are the MarkLogic admin credentials. They may be
+	// empty for calls that do not need authentication, e.g. before security
+	// is initialized.
+	Username string
+	Password string
+	// AuthMethod defaults to AuthAny.
+	AuthMethod AuthMethod
+	// HTTPS makes the client use https for all requests.
+	HTTPS bool
+	// TLSConfig is used for https requests. It is ignored when HTTPClient is set.
+	TLSConfig *tls.Config
+	// AdminPort, ManagePort and AppServicesPort default to 8001, 8002 and 8000.
+	AdminPort       int
+	ManagePort      int
+	AppServicesPort int
+	// Timeout bounds a single attempt. Defaults to 30s.
+	Timeout time.Duration
+	// MaxRetries is the number of additional attempts made after a transient
+	// failure. Defaults to 3, a negative value disables retries. POST
+	// requests are not idempotent and only retried when they could not be
+	// sent.
+	MaxRetries int
+	// RetryInterval is the delay between attempts. Defaults to 5s.
+	RetryInterval time.Duration
+	// HTTPClient overrides the underlying HTTP client.
+	HTTPClient *http.Client
+}
+
+// Factory creates clients, New or a replacement pointing them elsewhere, e.g.
+// at a fake in tests.
+type Factory func(cfg Config) (*Client, error)
+
+// Client is a MarkLogic REST API client bound to a single host.
+type Client struct {
+	cfg  Config
+	http *http.Client
+
+	mu     sync.Mutex
+	digest *digestChallenge
+	basic  bool
+}
+
+// New returns a client for the host described by cfg.
+func New(cfg Config) (*Client, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("mlclient: host is required")
+	}
+	if cfg.AdminPort == 0 {
+		cfg.AdminPort = DefaultAdminPort
+	}
+	if cfg.ManagePort == 0 {
+		cfg.ManagePort = DefaultManagePort
+	}
+	if cfg.AppServicesPort == 0 {
+		cfg.AppServicesPort = DefaultAppServicesPort
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = defaultMaxRetries
+	}
+	if cfg.RetryInterval == 0 {
+		cfg.RetryInterval = defaultRetryInterval
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = cfg.TLSConfig
+		httpClient = &http.Client{Transport: transport}
+	}
+	return &Client{cfg: cfg, http: httpClient}, nil
+}
+
+// Host returns the host the client talks to.
+func (c *Client) Host() string {
+	return c.cfg.Host
+}
+
+// request describes a single API call. The body is kept as bytes so that it
+// can be replayed on retries and authentication challenges.
+type request struct {
+	method      string
+	port        int
+	path        string
+	query       url.Values
+	body        []byte
+	contentType string
+	accept      string
+	expect      []int
+}
+
+type response struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+func (c *Client) url(port int, path string, query url.Values) string {
+	scheme := "http"
+	if c.cfg.HTTPS {
+		scheme = "https"
+	}
+	u := url.URL{
+		Scheme: scheme,
+		Host:   net.JoinHostPort(c.cfg.Host, strconv.Itoa(port)),
+		Path:   path,
+	}
+	if len(query) > 0 {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// do sends r, retrying transient failures when retryable allows it, and
+// returns an *Error when the response status is not one of r.expect.
+func (c *Client) do(ctx context.Context, r request) (*response, error) {
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		resp, err := c.attempt(ctx, r)
+		if err == nil {
+			for _, code := range r.expect {
+				if resp.statusCode == code {
+					return resp, nil
+				}
+			}
+			err = newError(r.method, c.url(r.port, r.path, r.query), resp)
+		}
+		lastErr = err
+		if !retryable(r, err) || attempt >= c.cfg.MaxRetries || ctx.Err() != nil {
+			return resp, lastErr
+		}
+		select {
+		case <-ctx.Done():
+			return resp, lastErr
+		case <-time.After(c.cfg.RetryInterval):
+		}
+	}
+}
+
+// attempt sends r once, answering an authentication challenge if needed.
+func (c *Client) attempt(ctx context.Context, r request) (*response, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	resp, err := c.send(ctx, r, c.authorization(r))
+	if err != nil || resp.statusCode != http.StatusUnauthorized || c.cfg.Username == "" {
+		return resp, err
+	}
+	auth, ok := c.answer(r, resp.header.Values("WWW-Authenticate"))
+	if !ok {
+		return resp, nil
+	}
+	return c.send(ctx, r, auth)
+}
+
+func (c *Client) send(ctx context.Context, r request, authorization string) (*response, error) {
+	var body io.Reader
+	if r.body != nil {
+		body = bytes.NewReader(r.body)
+	}
+	target := c.url(r.port, r.path, r.query)
+	req, err := http.NewRequestWithContext(ctx, r.method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if r.contentType != "" {
+		req.Header.Set("Content-Type", r.contentType)
+	}
+	if r.accept != "" {
+		req.Header.Set("Accept", r.accept)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	httpResp, err := c.http.Do(req)
+	if err != nil {
+		return nil, &ConnectionError{Method: r.method, URL: target, Err: err}
+	}
+	defer httpResp.Body.Close()
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, &ConnectionError{Method: r.method, URL: target, Err: err}
+	}
+	return &response{statusCode: httpResp.StatusCode, header: httpResp.Header, body: data}, nil
+}
+
+// authorization returns a preemptive Authorization header for r, if one can
+// be computed without a fresh challenge.
+func (c *Client) authorization(r request) string {
+	if c.cfg.Username == "" {
+		return ""
+	}
+	if c.cfg.AuthMethod == AuthBasic {
+		return basicAuth(c.cfg.Username, c.cfg.Password)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.basic {
+		return basicAuth(c.cfg.Username, c.cfg.Password)
+	}
+	if c.digest == nil {
+		return ""
+	}
+	return c.digest.authorize(c.cfg.Username, c.cfg.Password, r.method, requestURI(r))
+}
+
+// answer computes the Authorization header for the given challenges and
+// remembers the chosen scheme for later requests.
+func (c *Client) answer(r request, challenges []string) (string, bool) {
+	if c.cfg.AuthMethod == AuthBasic {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, challenge := range challenges {
+		if dc, ok := parseDigestChallenge(challenge); ok {
+			c.digest, c.basic = dc, false
+			return dc.authorize(c.cfg.Username, c.cfg.Password, r.method, requestURI(r)), true
+		}
+	}
+	if c.cfg.AuthMethod == AuthAny {
+		for _, challenge := range challenges {
+			if isBasicChallenge(challenge) {
+				c.digest, c.basic = nil, true
+				return basicAuth(c.cfg.Username, c.cfg.Password), true
+			}
+		}
+	}
+	return "", false
+}
+
+func requestURI(r request) string {
+	u := url.URL{Path: r.path}
+	if len(r.query) > 0 {
+		u.RawQuery = r.query.Encode()
+	}
+	return u.RequestURI()
+}
+
+// retryable reports whether r may be sent again after err. Transient
+// failures are retried for idempotent methods only: a POST, like the one
+// starting a backup, may have been executed before the connection was lost or
+// a proxy answered, so it is only retried when it was never sent.
+func retryable(r request, err error) bool {
+	idempotent := r.method != http.MethodPost && r.method != http.MethodPatch
+	var connErr *ConnectionError
+	if errors.As(err, &connErr) {
+		var opErr *net.OpError
+		return idempotent || (errors.As(connErr.Err, &opErr) && opErr.Op == "dial")
+	}
+	switch StatusCode(err) {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package mlclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testUser     = "admin"
+	testPassword = "secret"
+	testRealm    = "public"
+	testNonce    = "b5c0a9d1e2f3"
+)
+
+// digestOK verifies the digest Authorization header of r the way MarkLogic does.
+func digestOK(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Digest ") {
+		return false
+	}
+	params := map[string]string{}
+	for _, p := range splitParams(strings.TrimPrefix(header, "Digest ")) {
+		k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+		params[k] = strings.Trim(v, `"`)
+	}
+	ha1 := md5Hex(testUser + ":" + testRealm + ":" + testPassword)
+	ha2 := md5Hex(r.Method + ":" + r.URL.RequestURI())
+	want := md5Hex(strings.Join([]string{ha1, testNonce, params["nc"], params["cnonce"], "auth", ha2}, ":"))
+	return params["username"] == testUser && params["uri"] == r.URL.RequestURI() && params["response"] == want
+}
+
+// digestServer wraps handler with digest authentication and counts challenges.
+func digestServer(t *testing.T, challenges *int32, handler http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !digestOK(r) {
+			atomic.AddInt32(challenges, 1)
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="%s", qop="auth", nonce="%s", opaque="5f0e"`, testRealm, testNonce))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return testClient(t, srv, Config{Username: testUser, Password: testPassword})
+}
+
+// testClient returns a client whose admin and manage ports point at srv.
+func testClient(t *testing.T, srv *httptest.Server, cfg Config) *Client {
+	t.Helper()
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	host, port, err := net.SplitHostPort(u.Host)
+	require.NoError(t, err)
+	var p int
+	_, err = fmt.Sscan(port, &p)
+	require.NoError(t, err)
+	cfg.Host = host
+	cfg.AdminPort, cfg.ManagePort, cfg.AppServicesPort = p, p, p
+	if cfg.RetryInterval == 0 {
+		cfg.RetryInterval = time.Millisecond
+	}
+	c, err := New(cfg)
+	require.NoError(t, err)
+	return c
+}
+
+func TestNewRequiresHost(t *testing.T) {
+	_, err := New(Config{})
+	require.Error(t, err)
+}
+
+func TestDigestAuthReusesChallenge(t *testing.T) {
+	var challenges int32
+	c := digestServer(t, &challenges, func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "2024-05-01T10:00:00.000000Z")
+	})
+
+	for i := 0; i < 3; i++ {
+		ts, err := c.Timestamp(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "2024-05-01T10:00:00.000000Z", ts)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&challenges))
+}
+
+func TestBasicAuth(t *testing.T) {
+	var challenges int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != testUser || pass != testPassword {
+			atomic.AddInt32(&challenges, 1)
+			w.Header().Set("WWW-Authenticate", `Basic realm="public"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "ts")
+	}))
+	defer srv.Close()
+
+	anyAuth := testClient(t, srv, Config{Username: testUser, Password: testPassword})
+	for i := 0; i < 2; i++ {
+		_, err := anyAuth.Timestamp(context.Background())
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&challenges))
+
+	digestOnly := testClient(t, srv, Config{Username: testUser, Password: testPassword, AuthMethod: AuthDigest})
+	_, err := digestOnly.Timestamp(context.Background())
+	assert.True(t, IsUnauthorized(err))
+
+	preemptive := testClient(t, srv, Config{Username: testUser, Password: testPassword, AuthMethod: AuthBasic})
+	_, err = preemptive.Timestamp(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&challenges))
+}
+
+func TestErrorResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"errorResponse":{"statusCode":404,"status":"Not Found","messageCode":"XDMP-NOSUCHHOST","message":"XDMP-NOSUCHHOST: No such host ml-1"}}`)
+	}))
+	defer srv.Close()
+
+	c := testClient(t, srv, Config{})
+	_, err := c.HostProperties(context.Background(), "ml-1")
+	require.Error(t, err)
+	assert.True(t, IsNotFound(err))
+	assert.False(t, IsUnreachable(err))
+
+	var apiErr *Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "XDMP-NOSUCHHOST", apiErr.MessageCode)
+	assert.Contains(t, err.Error(), "No such host ml-1")
+}
+
+func TestRetriesUnavailable(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ts")
+	}))
+	defer srv.Close()
+
+	_, err := testClient(t, srv, Config{}).Timestamp(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	atomic.StoreInt32(&calls, 0)
+	_, err = testClient(t, srv, Config{MaxRetries: -1}).Timestamp(context.Background())
+	assert.Equal(t, http.StatusServiceUnavailable, StatusCode(err))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestDoesNotRetryPost(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusGatewayTimeout)
+	}))
+	defer srv.Close()
+
+	// The backup may have started behind the proxy that timed out.
+	_, err := testClient(t, srv, Config{}).Backup(context.Background(), "Documents", BackupRequest{BackupDir: "/backups"})
+	assert.Equal(t, http.StatusGatewayTimeout, StatusCode(err))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// A request that could not be sent is retried.
+	post := request{method: http.MethodPost}
+	assert.True(t, retryable(post, &ConnectionError{Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}))
+	assert.False(t, retryable(post, &ConnectionError{Err: &net.OpError{Op: "read", Err: errors.New("connection reset")}}))
+}
+
+func TestUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	c := testClient(t, srv, Config{MaxRetries: 1})
+	srv.Close()
+
+	_, err := c.Timestamp(context.Background())
+	require.Error(t, err)
+	assert.True(t, IsUnreachable(err))
+	assert.Equal(t, 0, StatusCode(err))
+}
+
+func TestContextCancelStopsRetries(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c := testClient(t, srv, Config{MaxRetries: 100, RetryInterval: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.Timestamp(ctx)
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,315 @@
+package mlclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DatabaseProperties are the properties of a database in /manage/v2/databases,
+// keyed by their Management API names. The API has too many properties to
+// model them all, so they are kept as decoded JSON.
+type DatabaseProperties map[string]interface{}
+
+// DatabaseProperties returns the properties of the named database. An *Error
+// with status 404 means the database does not exist.
+func (c *Client) DatabaseProperties(ctx context.Context, name string) (DatabaseProperties, error) {
+	props := DatabaseProperties{}
+	if err := c.getJSON(ctx, "/manage/v2/databases/"+url.PathEscape(name)+"/properties", nil, &props); err != nil {
+		return nil, err
+	}
+	return props, nil
+}
+
+// CreateDatabase creates a database. props must contain database-name.
+func (c *Client) CreateDatabase(ctx context.Context, props DatabaseProperties) error {
+	_, err := c.sendJSON(ctx, http.MethodPost, "/manage/v2/databases", nil, props, http.StatusCreated)
+	return err
+}
+
+// UpdateDatabaseProperties updates the named database. It returns a non-nil
+// Restart when the change restarts hosts.
+func (c *Client) UpdateDatabaseProperties(ctx context.Context, name string, props DatabaseProperties) (*Restart, error) {
+	resp, err := c.sendJSON(ctx, http.MethodPut, "/manage/v2/databases/"+url.PathEscape(name)+"/properties", nil, props,
+		http.StatusNoContent, http.StatusAccepted)
+	if err != nil {
+		return nil, err
+	}
+	return restart(resp), nil
+}
+
+// DatabaseAvailable is the state DatabaseState reports for a database whose
+// forests are all available.
+const DatabaseAvailable = "available"
+
+type databaseStatus struct {
+	Status struct {
+		Properties struct {
+			State struct {
+				Value string `json:"value"`
+			} `json:"state"`
+		} `json:"status-properties"`
+	} `json:"database-status"`
+}
+
+// DatabaseState returns the state of the named database, such as
+// DatabaseAvailable.
+func (c *Client) DatabaseState(ctx context.Context, name string) (string, error) {
+	status := &databaseStatus{}
+	if err := c.getJSON(ctx, "/manage/v2/databases/"+url.PathEscape(name), url.Values{"view": []string{"status"}}, status); err != nil {
+		return "", err
+	}
+	return status.Status.Properties.State.Value, nil
+}
+
+type databaseList struct {
+	List struct {
+		Items struct {
+			Item []struct {
+				NameRef string `json:"nameref"`
+			} `json:"list-item"`
+		} `json:"list-items"`
+	} `json:"database-default-list"`
+}
+
+// Databases returns the names of the databases of the cluster.
+func (c *Client) Databases(ctx context.Context) ([]string, error) {
+	list := &databaseList{}
+	if err := c.getJSON(ctx, "/manage/v2/databases", nil, list); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, item := range list.List.Items.Item {
+		names = append(names, item.NameRef)
+	}
+	return names, nil
+}
+
+// Forest deletion levels of DeleteDatabase.
+const (
+	ForestDeleteNone          = ""
+	ForestDeleteConfiguration = "configuration"
+	ForestDeleteData          = "data"
+)
+
+// DeleteDatabase deletes the named database. forestDelete selects whether the
+// forests of the database are kept, removed from the configuration or deleted
+// with their data. Deleting a database that does not exist is not an error.
+func (c *Client) DeleteDatabase(ctx context.Context, name, forestDelete string) error {
+	var query url.Values
+	if forestDelete != ForestDeleteNone {
+		query = url.Values{"forest-delete": []string{forestDelete}}
+	}
+	_, err := c.do(ctx, request{
+		method: http.MethodDelete,
+		port:   c.cfg.ManagePort,
+		path:   "/manage/v2/databases/" + url.PathEscape(name),
+		query:  query,
+		expect: []int{http.StatusNoContent, http.StatusAccepted, http.StatusNotFound},
+	})
+	return err
+}
+
+// Backup job states reported by BackupStatus.
+const (
+	JobInProgress = "in-progress"
+	JobCompleted  = "completed"
+	JobFailed     = "failed"
+	JobCancelled  = "cancelled"
+)
+
+// BackupRequest describes a backup-database operation.
+type BackupRequest struct {
+	BackupDir       string
+	IncludeReplicas bool
+	Incremental     bool
+	IncrementalDir  string
+}
+
+// RestoreRequest describes a restore-database operation.
+type RestoreRequest struct {
+	BackupDir       string
+	IncludeReplicas bool
+	Incremental     bool
+	IncrementalDir  string
+	// RestoreToTime restores the database to its state at this xs:dateTime,
+	// which requires journal archiving. Empty restores the whole backup.
+	RestoreToTime string
+}
+
+// Job identifies a backup or restore job started on a host.
+type Job struct {
+	JobID    string `json:"job-id"`
+	HostName string `json:"host-name"`
+}
+
+// JobStatus is the status of a backup or restore job.
+type JobStatus struct {
+	JobID    string `json:"job-id"`
+	HostName string `json:"host-name"`
+	Status   string `json:"status"`
+}
+
+// databaseOperation is the wire format of POST /manage/v2/databases/{id}.
+// The Management API takes the boolean flags as strings.
+type databaseOperation struct {
+	Operation       string `json:"operation"`
+	BackupDir       string `json:"backup-dir,omitempty"`
+	IncludeReplicas string `json:"include-replicas,omitempty"`
+	Incremental     string `json:"incremental,omitempty"`
+	IncrementalDir  string `json:"incremental-dir,omitempty"`
+	RestoreToTime   string `json:"restore-to-time,omitempty"`
+	JobID           string `json:"job-id,omitempty"`
+	HostName        string `json:"host-name,omitempty"`
+}
+
+func optionalBool(b bool) string {
+	if !b {
+		return ""
+	}
+	return strconv.FormatBool(b)
+}
+
+func (c *Client) databaseOperation(ctx context.Context, database string, op databaseOperation, out interface{}) error {
+	resp, err := c.sendJSON(ctx, http.MethodPost, "/manage/v2/databases/"+url.PathEscape(database), nil, op, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	return decodeJSON(resp, out)
+}
+
+// Backup starts a backup of database.
+func (c *Client) Backup(ctx context.Context, database string, in BackupRequest) (*Job, error) {
+	job := &Job{}
+	err := c.databaseOperation(ctx, database, databaseOperation{
+		Operation:       "backup-database",
+		BackupDir:       in.BackupDir,
+		IncludeReplicas: strconv.FormatBool(in.IncludeReplicas),
+		Incremental:     optionalBool(in.Incremental),
+		IncrementalDir:  in.IncrementalDir,
+	}, job)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// BackupStatus returns the status of a backup job of database.
+func (c *Client) BackupStatus(ctx context.Context, database string, job Job) (*JobStatus, error) {
+	status := &JobStatus{}
+	err := c.databaseOperation(ctx, database, databaseOperation{
+		Operation: "backup-status",
+		JobID:     job.JobID,
+		HostName:  job.HostName,
+	}, status)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Restore starts a restore of database.
+func (c *Client) Restore(ctx context.Context, database string, in RestoreRequest) (*Job, error) {
+	job := &Job{}
+	err := c.databaseOperation(ctx, database, databaseOperation{
+		Operation:       "restore-database",
+		BackupDir:       in.BackupDir,
+		IncludeReplicas: strconv.FormatBool(in.IncludeReplicas),
+		Incremental:     optionalBool(in.Incremental),
+		IncrementalDir:  in.IncrementalDir,
+		RestoreToTime:   in.RestoreToTime,
+	}, job)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// ForestJobStatus is the status of a backup or restore job on one forest.
+type ForestJobStatus struct {
+	ForestName string `json:"forest-name"`
+	HostName   string `json:"host-name"`
+	Status     string `json:"status"`
+}
+
+// RestoreStatus is the status of a restore job, overall and per forest.
+type RestoreStatus struct {
+	JobStatus
+	Forests []ForestJobStatus `json:"forest,omitempty"`
+}
+
+// RestoreStatus returns the status of a restore job of database.
+func (c *Client) RestoreStatus(ctx context.Context, database string, job Job) (*RestoreStatus, error) {
+	status := &RestoreStatus{}
+	err := c.databaseOperation(ctx, database, databaseOperation{
+		Operation: "restore-status",
+		JobID:     job.JobID,
+		HostName:  job.HostName,
+	}, status)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// ValidationOkay is the status of a forest that passed a validation.
+const ValidationOkay = "okay"
+
+// ForestValidation is the result of a validation for one forest.
+type ForestValidation struct {
+	ForestName string `json:"forest-name"`
+	HostName   string `json:"host-name,omitempty"`
+	Status     string `json:"status"`
+	Message    string `json:"message,omitempty"`
+}
+
+// BackupValidation is the result of a backup-validate operation.
+type BackupValidation struct {
+	Forests []ForestValidation `json:"forest"`
+}
+
+// Failed returns the forests that did not pass the validation.
+func (v *BackupValidation) Failed() []ForestValidation {
+	var failed []ForestValidation
+	for _, f := range v.Forests {
+		if f.Status != ValidationOkay {
+			failed = append(failed, f)
+		}
+	}
+	return failed
+}
+
+// ValidateBackup checks with backup-validate that the backup described by in
+// can be used for database on every forest.
+func (c *Client) ValidateBackup(ctx context.Context, database string, in RestoreRequest) (*BackupValidation, error) {
+	validation := &BackupValidation{}
+	err := c.databaseOperation(ctx, database, databaseOperation{
+		Operation:       "backup-validate",
+		BackupDir:       in.BackupDir,
+		IncludeReplicas: strconv.FormatBool(in.IncludeReplicas),
+		Incremental:     optionalBool(in.Incremental),
+		IncrementalDir:  in.IncrementalDir,
+	}, validation)
+	if err != nil {
+		return nil, err
+	}
+	return validation, nil
+}
+
+// PurgeBackups deletes all but the keep most recent backups of database in
+// dir with xdmp:database-backup-purge.
+func (c *Client) PurgeBackups(ctx context.Context, database, dir string, keep int) error {
+	query := fmt.Sprintf(`xdmp:database-backup-purge(%s, %d, xdmp:database-forests(xdmp:database(%s)))`,
+		xqueryString(dir), keep, xqueryString(database))
+	_, err := c.Eval(ctx, query)
+	return err
+}
+
+// xqueryString quotes s as an XQuery string literal.
+func xqueryString(s string) string {
+	s = strings.ReplaceAll(s, "&", "&amp;")
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
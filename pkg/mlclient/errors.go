@@ -0,0 +1,108 @@
+package mlclient
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Error is returned when MarkLogic answers with an unexpected status code.
+// MessageCode and Message are filled from the MarkLogic error response body
+// when it can be decoded.
+type Error struct {
+	Method      string
+	URL         string
+	StatusCode  int
+	MessageCode string
+	Message     string
+	Body        []byte
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s %s: unexpected status %d", e.Method, e.URL, e.StatusCode)
+	if e.MessageCode != "" {
+		msg += " " + e.MessageCode
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// ConnectionError is returned when MarkLogic could not be reached, which the
+// chart scripts observe as curl response code 000.
+type ConnectionError struct {
+	Method string
+	URL    string
+	Err    error
+}
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Method, e.URL, e.Err)
+}
+
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+type errorResponse struct {
+	StatusCode  int    `json:"statusCode" xml:"status-code"`
+	Status      string `json:"status" xml:"status"`
+	MessageCode string `json:"messageCode" xml:"message-code"`
+	Message     string `json:"message" xml:"message"`
+}
+
+func newError(method, url string, resp *response) *Error {
+	e := &Error{Method: method, URL: url, StatusCode: resp.statusCode, Body: resp.body}
+	var body struct {
+		ErrorResponse errorResponse `json:"errorResponse"`
+	}
+	var xmlBody errorResponse
+	switch {
+	case json.Unmarshal(resp.body, &body) == nil:
+		e.MessageCode = body.ErrorResponse.MessageCode
+		e.Message = body.ErrorResponse.Message
+	case xml.Unmarshal(resp.body, &xmlBody) == nil:
+		e.MessageCode = xmlBody.MessageCode
+		e.Message = xmlBody.Message
+	}
+	e.Message = strings.TrimSpace(e.Message)
+	return e
+}
+
+// StatusCode returns the HTTP status code carried by err, or 0 when err does
+// not come from a MarkLogic response, e.g. because the host is unreachable.
+func StatusCode(err error) int {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// IsUnreachable reports whether err is a connection failure.
+func IsUnreachable(err error) bool {
+	var connErr *ConnectionError
+	return errors.As(err, &connErr)
+}
+
+// IsNotFound reports whether MarkLogic answered 404.
+func IsNotFound(err error) bool {
+	return StatusCode(err) == http.StatusNotFound
+}
+
+// IsUnauthorized reports whether MarkLogic answered 401. Before security is
+// initialized any credentials are accepted, so a 401 also tells that the
+// security database is installed.
+func IsUnauthorized(err error) bool {
+	return StatusCode(err) == http.StatusUnauthorized
+}
+
+// IsForbidden reports whether MarkLogic answered 403. A plain http request
+// to an app server with TLS enabled is answered with 403.
+func IsForbidden(err error) bool {
+	return StatusCode(err) == http.StatusForbidden
+}
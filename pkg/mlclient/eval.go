@@ -0,0 +1,27 @@
+package mlclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Eval evaluates an XQuery main module with POST /v1/eval on the App-Services
+// app server and returns the raw multipart response.
+func (c *Client) Eval(ctx context.Context, xquery string) ([]byte, error) {
+	form := url.Values{}
+	form.Set("xquery", xquery)
+	resp, err := c.do(ctx, request{
+		method:      http.MethodPost,
+		port:        c.cfg.AppServicesPort,
+		path:        "/v1/eval",
+		body:        []byte(form.Encode()),
+		contentType: "application/x-www-form-urlencoded",
+		accept:      "multipart/mixed; boundary=BOUNDARY",
+		expect:      []int{http.StatusOK},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.body, nil
+}
@@ -0,0 +1,51 @@
+package mlclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// ExternalSecurityProperties are the properties of an external security
+// configuration in /manage/v2/external-security, keyed by their Management
+// API names. Passwords are never returned by the API.
+type ExternalSecurityProperties map[string]interface{}
+
+// ExternalSecurityProperties returns the properties of the named external
+// security configuration. An *Error with status 404 means it does not exist.
+func (c *Client) ExternalSecurityProperties(ctx context.Context, name string) (ExternalSecurityProperties, error) {
+	props := ExternalSecurityProperties{}
+	if err := c.getJSON(ctx, "/manage/v2/external-security/"+url.PathEscape(name)+"/properties", nil, &props); err != nil {
+		return nil, err
+	}
+	return props, nil
+}
+
+// CreateExternalSecurity creates an external security configuration. props
+// must contain external-security-name, authentication, cache-timeout and
+// authorization.
+func (c *Client) CreateExternalSecurity(ctx context.Context, props ExternalSecurityProperties) error {
+	_, err := c.sendJSON(ctx, http.MethodPost, "/manage/v2/external-security", nil, props, http.StatusCreated)
+	return err
+}
+
+// UpdateExternalSecurityProperties updates the named external security
+// configuration.
+func (c *Client) UpdateExternalSecurityProperties(ctx context.Context, name string, props ExternalSecurityProperties) error {
+	_, err := c.sendJSON(ctx, http.MethodPut, "/manage/v2/external-security/"+url.PathEscape(name)+"/properties", nil, props,
+		http.StatusNoContent)
+	return err
+}
+
+// DeleteExternalSecurity deletes the named external security configuration,
+// which no app server may use anymore. Deleting one that does not exist is
+// not an error.
+func (c *Client) DeleteExternalSecurity(ctx context.Context, name string) error {
+	_, err := c.do(ctx, request{
+		method: http.MethodDelete,
+		port:   c.cfg.ManagePort,
+		path:   "/manage/v2/external-security/" + url.PathEscape(name),
+		expect: []int{http.StatusNoContent, http.StatusNotFound},
+	})
+	return err
+}
@@ -0,0 +1,188 @@
+package mlclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// ForestProperties are the properties of a forest in /manage/v2/forests.
+type ForestProperties struct {
+	ForestName    string `json:"forest-name,omitempty"`
+	Host          string `json:"host,omitempty"`
+	Database      string `json:"database,omitempty"`
+	DataDirectory string `json:"data-directory,omitempty"`
+	// Replicas are the local-disk failover replicas of the forest.
+	Replicas []ForestReplica `json:"forest-replica,omitempty"`
+}
+
+// ForestReplica is a local-disk failover replica of a forest.
+type ForestReplica struct {
+	ReplicaName string `json:"replica-name"`
+	Host        string `json:"host,omitempty"`
+}
+
+// Forest states reported by ForestState.
+const (
+	// ForestOpen is the state of a forest serving its database, including
+	// a replica acting as master after a failover.
+	ForestOpen = "open"
+	// ForestSyncReplicating is the state of a replica that is in sync with
+	// its master and can take over from it.
+	ForestSyncReplicating = "sync replicating"
+)
+
+// ForestProperties returns the properties of the named forest. An *Error with
+// status 404 means the forest does not exist.
+func (c *Client) ForestProperties(ctx context.Context, name string) (*ForestProperties, error) {
+	props := &ForestProperties{}
+	if err := c.getJSON(ctx, "/manage/v2/forests/"+url.PathEscape(name)+"/properties", nil, props); err != nil {
+		return nil, err
+	}
+	return props, nil
+}
+
+// CreateForest creates a forest on props.Host and attaches it to
+// props.Database when set.
+func (c *Client) CreateForest(ctx context.Context, props ForestProperties) error {
+	_, err := c.sendJSON(ctx, http.MethodPost, "/manage/v2/forests", nil, props, http.StatusCreated)
+	return err
+}
+
+// SetForestReplicas replaces the local-disk failover replicas of the named
+// forest. Replicas left out are detached from it and kept as forests of no
+// database.
+func (c *Client) SetForestReplicas(ctx context.Context, name string, replicas []ForestReplica) error {
+	if replicas == nil {
+		replicas = []ForestReplica{}
+	}
+	body := struct {
+		Replicas []ForestReplica `json:"forest-replica"`
+	}{replicas}
+	_, err := c.sendJSON(ctx, http.MethodPut, "/manage/v2/forests/"+url.PathEscape(name)+"/properties", nil, body, http.StatusNoContent)
+	return err
+}
+
+// AttachForest attaches the named forest to database.
+func (c *Client) AttachForest(ctx context.Context, name, database string) error {
+	return c.forestState(ctx, name, url.Values{"state": []string{"attach"}, "database": []string{database}})
+}
+
+// RetireForest retires the named forest. The rebalancer of its database
+// moves the documents of a retired forest to the other forests.
+func (c *Client) RetireForest(ctx context.Context, name string) error {
+	return c.forestState(ctx, name, url.Values{"state": []string{"retire"}})
+}
+
+// EmployForest takes the named forest back into service after RetireForest.
+func (c *Client) EmployForest(ctx context.Context, name string) error {
+	return c.forestState(ctx, name, url.Values{"state": []string{"employ"}})
+}
+
+// DetachForest detaches the named forest from its database.
+func (c *Client) DetachForest(ctx context.Context, name string) error {
+	return c.forestState(ctx, name, url.Values{"state": []string{"detach"}})
+}
+
+// DeleteForest deletes the named forest with its data. Deleting a forest
+// that does not exist is not an error.
+func (c *Client) DeleteForest(ctx context.Context, name string) error {
+	_, err := c.do(ctx, request{
+		method: http.MethodDelete,
+		port:   c.cfg.ManagePort,
+		path:   "/manage/v2/forests/" + url.PathEscape(name),
+		query:  url.Values{"level": []string{"full"}},
+		expect: []int{http.StatusNoContent, http.StatusAccepted, http.StatusNotFound},
+	})
+	return err
+}
+
+type forestList struct {
+	List struct {
+		Items struct {
+			Item []struct {
+				NameRef string `json:"nameref"`
+			} `json:"list-item"`
+		} `json:"list-items"`
+	} `json:"forest-default-list"`
+}
+
+// Forests returns the names of the forests of the cluster.
+func (c *Client) Forests(ctx context.Context) ([]string, error) {
+	return c.forests(ctx, nil)
+}
+
+// HostForests returns the names of the forests on host.
+func (c *Client) HostForests(ctx context.Context, host string) ([]string, error) {
+	return c.forests(ctx, url.Values{"host-id": []string{host}})
+}
+
+func (c *Client) forests(ctx context.Context, query url.Values) ([]string, error) {
+	list := &forestList{}
+	if err := c.getJSON(ctx, "/manage/v2/forests", query, list); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, item := range list.List.Items.Item {
+		names = append(names, item.NameRef)
+	}
+	return names, nil
+}
+
+type forestCounts struct {
+	Counts struct {
+		Properties struct {
+			DocumentCount struct {
+				Value int64 `json:"value"`
+			} `json:"document-count"`
+		} `json:"count-properties"`
+	} `json:"forest-counts"`
+}
+
+// ForestDocumentCount returns the number of documents in the named forest.
+func (c *Client) ForestDocumentCount(ctx context.Context, name string) (int64, error) {
+	counts := &forestCounts{}
+	if err := c.getJSON(ctx, "/manage/v2/forests/"+url.PathEscape(name), url.Values{"view": []string{"counts"}}, counts); err != nil {
+		return 0, err
+	}
+	return counts.Counts.Properties.DocumentCount.Value, nil
+}
+
+type forestStatus struct {
+	Status struct {
+		Properties struct {
+			State struct {
+				Value string `json:"value"`
+			} `json:"state"`
+		} `json:"status-properties"`
+	} `json:"forest-status"`
+}
+
+// ForestState returns the state of the named forest, such as ForestOpen or
+// ForestSyncReplicating.
+func (c *Client) ForestState(ctx context.Context, name string) (string, error) {
+	status := &forestStatus{}
+	if err := c.getJSON(ctx, "/manage/v2/forests/"+url.PathEscape(name), url.Values{"view": []string{"status"}}, status); err != nil {
+		return "", err
+	}
+	return status.Status.Properties.State.Value, nil
+}
+
+// RestartForest restarts the named forest. While it restarts, a replica in
+// ForestSyncReplicating state takes over as master.
+func (c *Client) RestartForest(ctx context.Context, name string) error {
+	return c.forestState(ctx, name, url.Values{"state": []string{"restart"}})
+}
+
+// forestState changes the state of a forest with POST /manage/v2/forests/{name}.
+func (c *Client) forestState(ctx context.Context, name string, form url.Values) error {
+	_, err := c.do(ctx, request{
+		method:      http.MethodPost,
+		port:        c.cfg.ManagePort,
+		path:        "/manage/v2/forests/" + url.PathEscape(name),
+		body:        []byte(form.Encode()),
+		contentType: "application/x-www-form-urlencoded; charset=utf-8",
+		expect:      []int{http.StatusOK, http.StatusNoContent},
+	})
+	return err
+}
@@ -0,0 +1,260 @@
+package mlclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// getJSON decodes the JSON response of a Management API GET into out.
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, out interface{}) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("format", "json")
+	resp, err := c.do(ctx, request{
+		method: http.MethodGet,
+		port:   c.cfg.ManagePort,
+		path:   path,
+		query:  query,
+		accept: "application/json",
+		expect: []int{http.StatusOK},
+	})
+	if err != nil {
+		return err
+	}
+	return decodeJSON(resp, out)
+}
+
+func decodeJSON(resp *response, out interface{}) error {
+	if err := json.Unmarshal(resp.body, out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
+// sendJSON sends in as the JSON body of a Management API call.
+func (c *Client) sendJSON(ctx context.Context, method, path string, query url.Values, in interface{}, expect ...int) (*response, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(ctx, request{
+		method:      method,
+		port:        c.cfg.ManagePort,
+		path:        path,
+		query:       query,
+		body:        body,
+		contentType: "application/json",
+		accept:      "application/json",
+		expect:      expect,
+	})
+}
+
+// GroupProperties are the properties of a group in /manage/v2/groups.
+type GroupProperties struct {
+	GroupName      string `json:"group-name,omitempty"`
+	XdqpSSLEnabled *bool  `json:"xdqp-ssl-enabled,omitempty"`
+}
+
+// GroupProperties returns the properties of the named group.
+func (c *Client) GroupProperties(ctx context.Context, name string) (*GroupProperties, error) {
+	props := &GroupProperties{}
+	if err := c.getJSON(ctx, "/manage/v2/groups/"+url.PathEscape(name)+"/properties", nil, props); err != nil {
+		return nil, err
+	}
+	return props, nil
+}
+
+// CreateGroup creates a new group.
+func (c *Client) CreateGroup(ctx context.Context, props GroupProperties) error {
+	_, err := c.sendJSON(ctx, http.MethodPost, "/manage/v2/groups", nil, props, http.StatusCreated)
+	return err
+}
+
+// UpdateGroupProperties updates the named group. It returns a non-nil Restart
+// when the change restarts the hosts of the group.
+func (c *Client) UpdateGroupProperties(ctx context.Context, name string, props GroupProperties) (*Restart, error) {
+	resp, err := c.sendJSON(ctx, http.MethodPut, "/manage/v2/groups/"+url.PathEscape(name)+"/properties", nil, props,
+		http.StatusNoContent, http.StatusAccepted)
+	if err != nil {
+		return nil, err
+	}
+	return restart(resp), nil
+}
+
+// HostProperties are the properties of a host in /manage/v2/hosts.
+type HostProperties struct {
+	HostName        string `json:"host-name,omitempty"`
+	Group           string `json:"group,omitempty"`
+	BindPort        int    `json:"bind-port,omitempty"`
+	ForeignBindPort int    `json:"foreign-bind-port,omitempty"`
+	Zone            string `json:"zone,omitempty"`
+}
+
+// HostProperties returns the properties of the named host. An *Error with
+// status 404 means the host has not joined the cluster.
+func (c *Client) HostProperties(ctx context.Context, host string) (*HostProperties, error) {
+	props := &HostProperties{}
+	if err := c.getJSON(ctx, "/manage/v2/hosts/"+url.PathEscape(host)+"/properties", nil, props); err != nil {
+		return nil, err
+	}
+	return props, nil
+}
+
+type hostList struct {
+	List struct {
+		Items struct {
+			Item []struct {
+				NameRef string `json:"nameref"`
+			} `json:"list-item"`
+		} `json:"list-items"`
+	} `json:"host-default-list"`
+}
+
+// Hosts returns the names of the hosts of the cluster.
+func (c *Client) Hosts(ctx context.Context) ([]string, error) {
+	list := &hostList{}
+	if err := c.getJSON(ctx, "/manage/v2/hosts", nil, list); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, item := range list.List.Items.Item {
+		names = append(names, item.NameRef)
+	}
+	return names, nil
+}
+
+type hostStatus struct {
+	Status struct {
+		Properties struct {
+			Online struct {
+				Value bool `json:"value"`
+			} `json:"online"`
+		} `json:"status-properties"`
+	} `json:"host-status"`
+}
+
+// HostOnline reports whether the cluster sees host as online.
+func (c *Client) HostOnline(ctx context.Context, host string) (bool, error) {
+	status := &hostStatus{}
+	if err := c.getJSON(ctx, "/manage/v2/hosts/"+url.PathEscape(host), url.Values{"view": []string{"status"}}, status); err != nil {
+		return false, err
+	}
+	return status.Status.Properties.Online.Value, nil
+}
+
+// ShutdownHost stops MarkLogic on host. With failover, the replicas of the
+// forests of the host take over from them.
+func (c *Client) ShutdownHost(ctx context.Context, host string, failover bool) error {
+	form := url.Values{"state": []string{"shutdown"}, "failover": []string{strconv.FormatBool(failover)}}
+	_, err := c.do(ctx, request{
+		method:      http.MethodPost,
+		port:        c.cfg.ManagePort,
+		path:        "/manage/v2/hosts/" + url.PathEscape(host),
+		body:        []byte(form.Encode()),
+		contentType: "application/x-www-form-urlencoded",
+		expect:      []int{http.StatusAccepted},
+	})
+	return err
+}
+
+// ServerProperties are the properties of an app server in /manage/v2/servers
+// this package models, a typed view of AppServerProperties.
+type ServerProperties struct {
+	ServerName             string `json:"server-name,omitempty"`
+	GroupName              string `json:"group-name,omitempty"`
+	ServerType             string `json:"server-type,omitempty"`
+	Port                   int    `json:"port,omitempty"`
+	Root                   string `json:"root,omitempty"`
+	ContentDatabase        string `json:"content-database,omitempty"`
+	ModulesDatabase        string `json:"modules-database,omitempty"`
+	Authentication         string `json:"authentication,omitempty"`
+	SSLCertificateTemplate string `json:"ssl-certificate-template,omitempty"`
+}
+
+func groupQuery(group string) url.Values {
+	return url.Values{"group-id": []string{group}}
+}
+
+// AppServerProperties are all properties of an app server in
+// /manage/v2/servers, keyed by their Management API names. Unlike
+// ServerProperties they cover properties this package does not model.
+type AppServerProperties map[string]interface{}
+
+// AppServerProperties returns all properties of the named app server in
+// group. An *Error with status 404 means the app server does not exist.
+func (c *Client) AppServerProperties(ctx context.Context, name, group string) (AppServerProperties, error) {
+	props := AppServerProperties{}
+	if err := c.getJSON(ctx, "/manage/v2/servers/"+url.PathEscape(name)+"/properties", groupQuery(group), &props); err != nil {
+		return nil, err
+	}
+	return props, nil
+}
+
+// CreateAppServer creates an app server in group. props must contain
+// server-name, server-type, root, port and content-database.
+func (c *Client) CreateAppServer(ctx context.Context, group string, props AppServerProperties) error {
+	_, err := c.sendJSON(ctx, http.MethodPost, "/manage/v2/servers", groupQuery(group), props, http.StatusCreated)
+	return err
+}
+
+// UpdateAppServerProperties updates the named app server in group. It returns
+// a non-nil Restart when the change restarts the hosts of the group.
+func (c *Client) UpdateAppServerProperties(ctx context.Context, name, group string, props AppServerProperties) (*Restart, error) {
+	resp, err := c.sendJSON(ctx, http.MethodPut, "/manage/v2/servers/"+url.PathEscape(name)+"/properties", groupQuery(group), props,
+		http.StatusNoContent, http.StatusAccepted)
+	if err != nil {
+		return nil, err
+	}
+	return restart(resp), nil
+}
+
+// ServerProperties returns the properties of the named app server in group
+// that ServerProperties models. It is a view of AppServerProperties.
+func (c *Client) ServerProperties(ctx context.Context, name, group string) (*ServerProperties, error) {
+	all, err := c.AppServerProperties(ctx, name, group)
+	if err != nil {
+		return nil, err
+	}
+	props := &ServerProperties{}
+	if err := convertJSON(all, props); err != nil {
+		return nil, err
+	}
+	return props, nil
+}
+
+// UpdateServerProperties updates the properties set in props of the named app
+// server in group with UpdateAppServerProperties.
+func (c *Client) UpdateServerProperties(ctx context.Context, name, group string, props ServerProperties) (*Restart, error) {
+	update := AppServerProperties{}
+	if err := convertJSON(props, &update); err != nil {
+		return nil, err
+	}
+	return c.UpdateAppServerProperties(ctx, name, group, update)
+}
+
+// convertJSON copies in to out through their JSON form.
+func convertJSON(in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// DeleteAppServer deletes the named app server in group. Deleting an app
+// server that does not exist is not an error.
+func (c *Client) DeleteAppServer(ctx context.Context, name, group string) error {
+	_, err := c.do(ctx, request{
+		method: http.MethodDelete,
+		port:   c.cfg.ManagePort,
+		path:   "/manage/v2/servers/" + url.PathEscape(name),
+		query:  groupQuery(group),
+		expect: []int{http.StatusNoContent, http.StatusAccepted, http.StatusNotFound},
+	})
+	return err
+}
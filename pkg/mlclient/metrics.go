@@ -0,0 +1,85 @@
+package mlclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"sort"
+)
+
+// Metric is the latest sample of a metric of the Management API.
+type Metric struct {
+	// Name is the Management API name, e.g. total-cpu-stat-user.
+	Name string
+	// Units is the unit of the value as reported, e.g. percentage or MB/sec.
+	Units string
+	Value float64
+}
+
+// metricsList is the metrics view of a resource type. It nests the metrics
+// under the list name of the resource, e.g. host-metrics-list.
+type metricsList struct {
+	Relations map[string]struct {
+		Metrics []map[string]json.RawMessage `json:"metrics"`
+	} `json:"metrics-relations"`
+}
+
+// metricSeries is one metric of a metrics view with its raw samples.
+type metricSeries struct {
+	Units   string `json:"units"`
+	Summary struct {
+		Data struct {
+			Entry []struct {
+				Value float64 `json:"value"`
+			} `json:"entry"`
+		} `json:"data"`
+	} `json:"summary"`
+}
+
+// HostMetrics returns the latest metrics of the named host.
+func (c *Client) HostMetrics(ctx context.Context, host string) ([]Metric, error) {
+	return c.metrics(ctx, "hosts", "host", host)
+}
+
+// DatabaseMetrics returns the latest metrics of the named database.
+func (c *Client) DatabaseMetrics(ctx context.Context, name string) ([]Metric, error) {
+	return c.metrics(ctx, "databases", "database", name)
+}
+
+// ForestMetrics returns the latest metrics of the named forest.
+func (c *Client) ForestMetrics(ctx context.Context, name string) ([]Metric, error) {
+	return c.metrics(ctx, "forests", "forest", name)
+}
+
+// metrics reads the raw samples of the metrics view of resources filtered on
+// the named one and keeps the latest sample of each metric. Metrics without
+// numeric samples are skipped.
+func (c *Client) metrics(ctx context.Context, resources, kind, name string) ([]Metric, error) {
+	query := url.Values{
+		"view":       []string{"metrics"},
+		"period":     []string{"raw"},
+		"detail":     []string{"false"},
+		kind + "-id": []string{name},
+	}
+	views := map[string]metricsList{}
+	if err := c.getJSON(ctx, "/manage/v2/"+resources, query, &views); err != nil {
+		return nil, err
+	}
+	list := kind + "-metrics-list"
+	var metrics []Metric
+	for _, m := range views[list].Relations[list].Metrics {
+		for metric, raw := range m {
+			series := &metricSeries{}
+			if err := json.Unmarshal(raw, series); err != nil {
+				continue
+			}
+			entries := series.Summary.Data.Entry
+			if len(entries) == 0 {
+				continue
+			}
+			metrics = append(metrics, Metric{Name: metric, Units: series.Units, Value: entries[len(entries)-1].Value})
+		}
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Name < metrics[j].Name })
+	return metrics, nil
+}
@@ -0,0 +1,45 @@
+package mlclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// RoleProperties are the properties of a role in /manage/v2/roles, keyed by
+// their Management API names.
+type RoleProperties map[string]interface{}
+
+// RoleProperties returns the properties of the named role. An *Error with
+// status 404 means the role does not exist.
+func (c *Client) RoleProperties(ctx context.Context, name string) (RoleProperties, error) {
+	props := RoleProperties{}
+	if err := c.getJSON(ctx, "/manage/v2/roles/"+url.PathEscape(name)+"/properties", nil, &props); err != nil {
+		return nil, err
+	}
+	return props, nil
+}
+
+// CreateRole creates a role. props must contain role-name.
+func (c *Client) CreateRole(ctx context.Context, props RoleProperties) error {
+	_, err := c.sendJSON(ctx, http.MethodPost, "/manage/v2/roles", nil, props, http.StatusCreated)
+	return err
+}
+
+// UpdateRoleProperties updates the named role.
+func (c *Client) UpdateRoleProperties(ctx context.Context, name string, props RoleProperties) error {
+	_, err := c.sendJSON(ctx, http.MethodPut, "/manage/v2/roles/"+url.PathEscape(name)+"/properties", nil, props, http.StatusNoContent)
+	return err
+}
+
+// DeleteRole deletes the named role. Deleting a role that does not exist is
+// not an error.
+func (c *Client) DeleteRole(ctx context.Context, name string) error {
+	_, err := c.do(ctx, request{
+		method: http.MethodDelete,
+		port:   c.cfg.ManagePort,
+		path:   "/manage/v2/roles/" + url.PathEscape(name),
+		expect: []int{http.StatusNoContent, http.StatusNotFound},
+	})
+	return err
+}
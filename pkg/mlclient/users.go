@@ -0,0 +1,46 @@
+package mlclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// UserProperties are the properties of a user in /manage/v2/users, keyed by
+// their Management API names. Passwords are never returned by the API.
+type UserProperties map[string]interface{}
+
+// UserProperties returns the properties of the named user. An *Error with
+// status 404 means the user does not exist.
+func (c *Client) UserProperties(ctx context.Context, name string) (UserProperties, error) {
+	props := UserProperties{}
+	if err := c.getJSON(ctx, "/manage/v2/users/"+url.PathEscape(name)+"/properties", nil, &props); err != nil {
+		return nil, err
+	}
+	return props, nil
+}
+
+// CreateUser creates a user. props must contain user-name and password.
+func (c *Client) CreateUser(ctx context.Context, props UserProperties) error {
+	_, err := c.sendJSON(ctx, http.MethodPost, "/manage/v2/users", nil, props, http.StatusCreated)
+	return err
+}
+
+// UpdateUserProperties updates the named user. Setting password changes the
+// password of the user on every host of the cluster.
+func (c *Client) UpdateUserProperties(ctx context.Context, name string, props UserProperties) error {
+	_, err := c.sendJSON(ctx, http.MethodPut, "/manage/v2/users/"+url.PathEscape(name)+"/properties", nil, props, http.StatusNoContent)
+	return err
+}
+
+// DeleteUser deletes the named user. Deleting a user that does not exist is
+// not an error.
+func (c *Client) DeleteUser(ctx context.Context, name string) error {
+	_, err := c.do(ctx, request{
+		method: http.MethodDelete,
+		port:   c.cfg.ManagePort,
+		path:   "/manage/v2/users/" + url.PathEscape(name),
+		expect: []int{http.StatusNoContent, http.StatusNotFound},
+	})
+	return err
+}
@@ -0,0 +1,130 @@
+// Package restart detects restarts of MarkLogic hosts through the timestamp
+// service of the Admin API.
+//
+// Admin API calls such as init, instance-admin and cluster-config answer
+// 202 Accepted when they restart the host. The restart happens after the
+// response, so callers compare GET /admin/v1/timestamp against the startup
+// time from before the call until it changes, like restart_check in the chart
+// scripts. Call wraps such a call and Wait polls for a changed timestamp.
+package restart
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// Default backoff settings, the same as N_RETRY and RETRY_INTERVAL of the
+// chart scripts.
+const (
+	DefaultInterval = 10 * time.Second
+	DefaultSteps    = 10
+)
+
+// ErrNotRestarted is returned by Wait when the host reports the baseline
+// timestamp after all steps of the backoff.
+var ErrNotRestarted = errors.New("no restart detected")
+
+// Timestamper reads the last startup time of a host. *mlclient.Client
+// implements it.
+type Timestamper interface {
+	Timestamp(ctx context.Context) (string, error)
+}
+
+// Backoff configures how the timestamp service is polled. The first check
+// waits Interval, every following check waits Factor times longer, capped at
+// MaxInterval when set.
+type Backoff struct {
+	// Interval is the delay before the first check. Defaults to
+	// DefaultInterval.
+	Interval time.Duration
+	// Factor multiplies the delay after each check. Values below 1 keep the
+	// delay constant.
+	Factor float64
+	// MaxInterval caps the delay. Zero means no cap.
+	MaxInterval time.Duration
+	// Steps is the number of checks before giving up. Defaults to
+	// DefaultSteps.
+	Steps int
+}
+
+func (b Backoff) withDefaults() Backoff {
+	if b.Interval <= 0 {
+		b.Interval = DefaultInterval
+	}
+	if b.Factor < 1 {
+		b.Factor = 1
+	}
+	if b.Steps <= 0 {
+		b.Steps = DefaultSteps
+	}
+	return b
+}
+
+// next returns the delay that follows d.
+func (b Backoff) next(d time.Duration) time.Duration {
+	d = time.Duration(float64(d) * b.Factor)
+	if b.MaxInterval > 0 && d > b.MaxInterval {
+		d = b.MaxInterval
+	}
+	return d
+}
+
+// Wait polls the timestamp service of c until it reports a startup time other
+// than baseline. Errors of the timestamp service are expected while the host
+// restarts and count as checks without a restart. An empty baseline accepts
+// any timestamp. Wait returns an error wrapping ErrNotRestarted when the
+// backoff is exhausted, and the context error when ctx is done first.
+func Wait(ctx context.Context, c Timestamper, baseline string, b Backoff) error {
+	b = b.withDefaults()
+	var last string
+	var lastErr error
+	delay := b.Interval
+	for i := 0; i < b.Steps; i++ {
+		if err := sleep(ctx, delay); err != nil {
+			return fmt.Errorf("waiting for restart: %w", err)
+		}
+		delay = b.next(delay)
+
+		last, lastErr = c.Timestamp(ctx)
+		if lastErr == nil && last != "" && last != baseline {
+			return nil
+		}
+	}
+	if lastErr != nil {
+		return fmt.Errorf("%w after %d checks: %w", ErrNotRestarted, b.Steps, lastErr)
+	}
+	return fmt.Errorf("%w after %d checks, last startup %q", ErrNotRestarted, b.Steps, last)
+}
+
+// Call runs an Admin API call that may restart the host behind c and waits
+// for the restart when the call reports one. The timestamp taken directly
+// before the call is the baseline, unless the response names the startup the
+// restart replaces. Call reports whether a restart happened.
+func Call(ctx context.Context, c Timestamper, b Backoff, call func(context.Context) (*mlclient.Restart, error)) (bool, error) {
+	// The host may not answer before it is initialized or secured, in which
+	// case any timestamp after the call means it restarted.
+	baseline, _ := c.Timestamp(ctx)
+	r, err := call(ctx)
+	if err != nil || r == nil {
+		return false, err
+	}
+	if r.LastStartup != "" {
+		baseline = r.LastStartup
+	}
+	return true, Wait(ctx, c, baseline, b)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,135 @@
+package restart
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+)
+
+// fakeHost answers the timestamp service from a list of answers, repeating
+// the last one.
+type fakeHost struct {
+	mu      sync.Mutex
+	answers []answer
+	checks  int
+}
+
+type answer struct {
+	timestamp string
+	err       error
+}
+
+func (f *fakeHost) Timestamp(context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a := f.answers[min(f.checks, len(f.answers)-1)]
+	f.checks++
+	return a.timestamp, a.err
+}
+
+var fast = Backoff{Interval: time.Millisecond, Steps: 5}
+
+func TestWait(t *testing.T) {
+	down := &mlclient.ConnectionError{Err: errors.New("connection refused")}
+	tests := []struct {
+		name     string
+		baseline string
+		answers  []answer
+		checks   int
+		want     error
+	}{
+		{name: "restarted", baseline: "t0", answers: []answer{{"t0", nil}, {"", down}, {"t1", nil}}, checks: 3},
+		{name: "no baseline", answers: []answer{{"", down}, {"t1", nil}}, checks: 2},
+		{name: "not restarted", baseline: "t0", answers: []answer{{"t0", nil}}, checks: 5, want: ErrNotRestarted},
+		{name: "down", baseline: "t0", answers: []answer{{"", down}}, checks: 5, want: down},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := &fakeHost{answers: tt.answers}
+			err := Wait(context.Background(), host, tt.baseline, fast)
+			if tt.want == nil {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, tt.want)
+				assert.ErrorIs(t, err, ErrNotRestarted)
+			}
+			assert.Equal(t, tt.checks, host.checks)
+		})
+	}
+}
+
+func TestWaitCanceled(t *testing.T) {
+	host := &fakeHost{answers: []answer{{"t0", nil}}}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := Wait(ctx, host, "t0", Backoff{Interval: 5 * time.Millisecond, Steps: 1000})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, host.checks, 1000)
+}
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Interval: time.Second, Factor: 2, MaxInterval: 5 * time.Second}.withDefaults()
+	d := b.Interval
+	var delays []time.Duration
+	for i := 0; i < 4; i++ {
+		d = b.next(d)
+		delays = append(delays, d)
+	}
+	assert.Equal(t, []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, delays)
+	assert.Equal(t, DefaultSteps, b.Steps)
+
+	b = Backoff{}.withDefaults()
+	assert.Equal(t, DefaultInterval, b.Interval)
+	assert.Equal(t, DefaultInterval, b.next(b.Interval))
+}
+
+func TestCall(t *testing.T) {
+	t.Run("restart", func(t *testing.T) {
+		host := &fakeHost{answers: []answer{{"t0", nil}, {"t0", nil}, {"t1", nil}}}
+		restarted, err := Call(context.Background(), host, fast, func(context.Context) (*mlclient.Restart, error) {
+			return &mlclient.Restart{}, nil
+		})
+		require.NoError(t, err)
+		assert.True(t, restarted)
+		assert.Equal(t, 3, host.checks)
+	})
+
+	t.Run("baseline from response", func(t *testing.T) {
+		// The host restarted between the baseline check and the call.
+		host := &fakeHost{answers: []answer{{"t0", nil}, {"t1", nil}, {"t2", nil}}}
+		restarted, err := Call(context.Background(), host, fast, func(context.Context) (*mlclient.Restart, error) {
+			return &mlclient.Restart{LastStartup: "t1"}, nil
+		})
+		require.NoError(t, err)
+		assert.True(t, restarted)
+		assert.Equal(t, 3, host.checks)
+	})
+
+	t.Run("no restart", func(t *testing.T) {
+		host := &fakeHost{answers: []answer{{"t0", nil}}}
+		restarted, err := Call(context.Background(), host, fast, func(context.Context) (*mlclient.Restart, error) {
+			return nil, nil
+		})
+		require.NoError(t, err)
+		assert.False(t, restarted)
+		assert.Equal(t, 1, host.checks)
+	})
+
+	t.Run("call fails", func(t *testing.T) {
+		host := &fakeHost{answers: []answer{{"t0", nil}}}
+		failure := &mlclient.Error{StatusCode: 400}
+		restarted, err := Call(context.Background(), host, fast, func(context.Context) (*mlclient.Restart, error) {
+			return nil, failure
+		})
+		require.ErrorIs(t, err, failure)
+		assert.False(t, restarted)
+	})
+}
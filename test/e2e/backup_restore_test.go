@@ -1,8 +1,8 @@
 package e2e
 
 import (
+	"context"
 	"crypto/tls"
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -16,24 +16,11 @@ import (
 	"github.com/gruntwork-io/terratest/modules/k8s"
 	"github.com/gruntwork-io/terratest/modules/random"
 	"github.com/imroc/req/v3"
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
 	"github.com/marklogic/marklogic-kubernetes/test/testUtil"
 	"github.com/stretchr/testify/assert"
-	"github.com/tidwall/gjson"
 )
 
-type BackupRestoreReq struct {
-	Operation       string `json:"operation"`
-	BackupDir       string `json:"backup-dir"`
-	IncludeReplicas string `json:"include-replicas"`
-	Incremental     string `json:"incremental,omitempty"`
-	IncrementalDir  string `json:"incremental-dir,omitempty"`
-}
-type BackupRestoreStatusReq struct {
-	Operation string `json:"operation"`
-	JobID     string `json:"job-id"`
-	HostName  string `json:"host-name,omitempty"`
-}
-
 func PutDocs(docPath string, docName string, client *req.Client, qConsoleEndpoint string) (string, error) {
 	result := ""
 	xmlData, err := os.ReadFile(docPath + docName)
@@ -87,68 +74,20 @@ func DeleteDocs(client *req.Client, deleteEndpoint string) (string, error) {
 	return result, err
 }
 
-func RunRequests(t *testing.T, client *req.Client, dbReq string, hostsEndpoint string) (string, error) {
-	var err error
-	var body []byte
-	headerMap := map[string]string{
-		"Content-Type": "application/json",
-		"Accept":       "application/json",
-	}
-	result := ""
-	status := ""
-	operation := (gjson.Get(dbReq, `operation`)).Str
-	var retryFn = (func(resp *req.Response, err error) bool {
+// WaitForBackup : polls the status of a backup job until it is no longer in progress
+func WaitForBackup(t *testing.T, client *mlclient.Client, database string, job mlclient.Job) string {
+	for i := 0; i < 10; i++ {
+		status, err := client.BackupStatus(context.Background(), database, job)
 		if err != nil {
 			t.Fatalf(err.Error())
-			return true
-		}
-		if resp == nil || resp.Body == nil {
-			t.Fatalf("error in getting response body")
-			return true
 		}
-		body, err = io.ReadAll(resp.Body)
-		if err != nil {
-			t.Fatalf("error reading response body, %s", err.Error())
-			return true
+		if status.Status != mlclient.JobInProgress {
+			return status.Status
 		}
-		result = (string(body))
-		return false
-	})
-
-	if operation == "backup-status" {
-		retryFn = (func(resp *req.Response, err error) bool {
-			if err != nil {
-				t.Fatalf(err.Error())
-			}
-			if resp == nil || resp.Body == nil {
-				t.Fatalf("error in getting response body")
-				return true
-			}
-			body, err := io.ReadAll(resp.Body)
-			if body == nil || err != nil {
-				t.Fatalf("error reading response body")
-				return true
-			}
-			status = (gjson.Get(string(body), `status`)).Str
-			if status != "completed" {
-				fmt.Println("Waiting for backup to be completed")
-			}
-			result = (string(body))
-			return status != "completed"
-		})
+		fmt.Println("Waiting for backup to be completed")
+		time.Sleep(10 * time.Second)
 	}
-
-	resp, err := client.R().
-		AddRetryCondition(retryFn).
-		SetHeaders(headerMap).
-		SetBodyString(dbReq).
-		Post(hostsEndpoint)
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	defer resp.Body.Close()
-
-	return result, err
+	return mlclient.JobInProgress
 }
 
 func TestMlDbBackupRestore(t *testing.T) {
@@ -305,39 +244,30 @@ func TestMlDbBackupRestore(t *testing.T) {
 	tunnel8002 := k8s.NewTunnel(kubectlOptions, k8s.ResourceTypePod, podName, 8002, 8002)
 	defer tunnel8002.Close()
 	tunnel8002.ForwardPort(t)
-	manageEndpoint := fmt.Sprintf("http://%s/manage/v2/databases/Documents", tunnel8002.Endpoint())
-	fmt.Println(manageEndpoint)
-
-	bkupReq := &BackupRestoreReq{
-		Operation:       "backup-database",
-		BackupDir:       "/tmp/backup",
-		IncludeReplicas: "true"}
-	bkupReqRes, _ := json.Marshal(bkupReq)
-
-	t.Log("====Full backup for Documents DB")
-	//full backup for Documents DB
-	result, err = RunRequests(t, client, string(bkupReqRes), manageEndpoint)
+	manageClient, err := mlclient.New(mlclient.Config{
+		Host:          "localhost",
+		Username:      username,
+		Password:      password,
+		ManagePort:    8002,
+		MaxRetries:    10,
+		RetryInterval: 10 * time.Second,
+	})
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
-	jobID := (gjson.Get(result, `job-id`))
-	hostName := (gjson.Get(result, `host-name`))
+	ctx := context.Background()
 
-	bkupStatusReq := &BackupRestoreStatusReq{
-		Operation: "backup-status",
-		JobID:     jobID.String(),
-		HostName:  hostName.String()}
-	bkupStatusReqRes, _ := json.Marshal(bkupStatusReq)
-
-	//get status of full backup job
-	result, err = RunRequests(t, client, string(bkupStatusReqRes), manageEndpoint)
+	t.Log("====Full backup for Documents DB")
+	//full backup for Documents DB
+	job, err := manageClient.Backup(ctx, "Documents", mlclient.BackupRequest{
+		BackupDir:       "/tmp/backup",
+		IncludeReplicas: true})
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
-	bkupStatus := (gjson.Get(result, `status`)).Str
 
 	//verify full backup is completed
-	assert.Equal(t, "completed", bkupStatus)
+	assert.Equal(t, mlclient.JobCompleted, WaitForBackup(t, manageClient, "Documents", *job))
 
 	t.Log("====Delete a document from Documents DB")
 	deleteEndpoint := fmt.Sprintf("http://%s/v1/documents?database=Documents&uri=%s", tunnel8000.Endpoint(), docs[1])
@@ -345,37 +275,17 @@ func TestMlDbBackupRestore(t *testing.T) {
 
 	//incremental backup
 	t.Log("====Incremental backup for Documents DB")
-	incrBkupReq := &BackupRestoreReq{
-		Operation:       "backup-database",
+	job, err = manageClient.Backup(ctx, "Documents", mlclient.BackupRequest{
 		BackupDir:       "/tmp/backup",
-		IncludeReplicas: "true",
-		Incremental:     "true",
-		IncrementalDir:  "/tmp/backup/incrBackup"}
-	incrBkupReqRes, _ := json.Marshal(incrBkupReq)
-
-	//incremnetal backup for Documents DB
-	result, err = RunRequests(t, client, string(incrBkupReqRes), manageEndpoint)
+		IncludeReplicas: true,
+		Incremental:     true,
+		IncrementalDir:  "/tmp/backup/incrBackup"})
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
-	jobID = (gjson.Get(result, `job-id`))
-	hostName = (gjson.Get(result, `host-name`))
-
-	incrBkupStatusReq := &BackupRestoreStatusReq{
-		Operation: "backup-status",
-		JobID:     jobID.String(),
-		HostName:  hostName.String()}
-	incrBkupStatusReqRes, _ := json.Marshal(incrBkupStatusReq)
-
-	//get status of backup job
-	result, err = RunRequests(t, client, string(incrBkupStatusReqRes), manageEndpoint)
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	incrBkupStatus := (gjson.Get(result, `status`)).Str
 
 	//verify backup is completed
-	assert.Equal(t, "completed", incrBkupStatus)
+	assert.Equal(t, mlclient.JobCompleted, WaitForBackup(t, manageClient, "Documents", *job))
 
 	//delete a document from Documents DB
 	result, err = DeleteDocs(client, deleteEndpoint)
@@ -384,19 +294,14 @@ func TestMlDbBackupRestore(t *testing.T) {
 	}
 	assert.Equal(t, "Deleted", result)
 
-	rstrReq := &BackupRestoreReq{
-		Operation:       "restore-database",
-		BackupDir:       "/tmp/backup",
-		IncludeReplicas: "true"}
-	brstrReqRes, _ := json.Marshal(rstrReq)
-
 	//restore Documents DB from incremental backup
-	result, err = RunRequests(t, client, string(brstrReqRes), manageEndpoint)
+	restoreJob, err := manageClient.Restore(ctx, "Documents", mlclient.RestoreRequest{
+		BackupDir:       "/tmp/backup",
+		IncludeReplicas: true})
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
-	restoreJobID := (gjson.Get(result, `job-id`)).Str
-	assert.NotEqual(t, "", restoreJobID)
+	assert.NotEqual(t, "", restoreJob.JobID)
 
 	result, err = GetDocs(client, getEndpoint, "multipart/mixed")
 	if err != nil {
@@ -409,5 +314,5 @@ func TestMlDbBackupRestore(t *testing.T) {
 
 	tlsConfig := tls.Config{}
 	// restart pods in the cluster and verify its ready and MarkLogic server is healthy
-	testUtil.RestartPodAndVerify(t, false, []string{podName}, namespaceName, kubectlOptions, &tlsConfig)
+	testUtil.RestartPodAndVerify(t, false, []string{podName}, namespaceName, kubectlOptions, &tlsConfig, username, password)
 }
@@ -173,5 +173,5 @@ func TestClusterJoin(t *testing.T) {
 
 	tlsConfig := tls.Config{}
 	// restart all pods in the cluster and verify its ready and MarkLogic server is healthy
-	testUtil.RestartPodAndVerify(t, true, []string{podZeroName, podOneName}, namespaceName, kubectlOptions, &tlsConfig)
+	testUtil.RestartPodAndVerify(t, true, []string{podZeroName, podOneName}, namespaceName, kubectlOptions, &tlsConfig, username, password)
 }
@@ -177,5 +177,5 @@ func TestHelmInstall(t *testing.T) {
 	assert.Equal(t, true, xdqpEnabledValue, "xdqp-ssl-enabled should be set to true")
 
 	// restart all pods in the cluster and verify its ready and MarkLogic server is healthy
-	testUtil.RestartPodAndVerify(t, true, []string{podZeroName, podOneName}, namespaceName, kubectlOptions, &tlsConfig)
+	testUtil.RestartPodAndVerify(t, true, []string{podZeroName, podOneName}, namespaceName, kubectlOptions, &tlsConfig, username, password)
 }
@@ -154,7 +154,7 @@ func TestPathBasedRouting(t *testing.T) {
 
 	tlsConfig := tls.Config{}
 	// restart all pods at once in the cluster and verify its ready and MarkLogic server is healthy
-	testUtil.RestartPodAndVerify(t, true, []string{podZeroName, podOneName, podTwoName}, namespaceName, kubectlOptions, &tlsConfig)
+	testUtil.RestartPodAndVerify(t, true, []string{podZeroName, podOneName, podTwoName}, namespaceName, kubectlOptions, &tlsConfig, username, password)
 }
 
 func TestPathBasedRoutAppServers(t *testing.T) {
@@ -281,7 +281,7 @@ func TestPathBasedRoutAppServers(t *testing.T) {
 
 	tlsConfig := tls.Config{}
 	// restart all pods at once in the cluster and verify its ready and MarkLogic server is healthy
-	testUtil.RestartPodAndVerify(t, true, []string{podZeroName, podOneName}, namespaceName, kubectlOptions, &tlsConfig)
+	testUtil.RestartPodAndVerify(t, true, []string{podZeroName, podOneName}, namespaceName, kubectlOptions, &tlsConfig, username, password)
 }
 
 func TestPathBasedRoutingWithTLS(t *testing.T) {
@@ -416,5 +416,5 @@ func TestPathBasedRoutingWithTLS(t *testing.T) {
 
 	tlsConfig := tls.Config{}
 	// restart all pods at once in the cluster and verify its ready and MarkLogic server is healthy
-	testUtil.RestartPodAndVerify(t, true, []string{podZeroName, podOneName, podTwoName}, namespaceName, kubectlOptions, &tlsConfig)
+	testUtil.RestartPodAndVerify(t, true, []string{podZeroName, podOneName, podTwoName}, namespaceName, kubectlOptions, &tlsConfig, username, password)
 }
@@ -2,8 +2,6 @@ package e2e
 
 import (
 	"crypto/tls"
-	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -14,7 +12,6 @@ import (
 	"github.com/gruntwork-io/terratest/modules/helm"
 	"github.com/gruntwork-io/terratest/modules/k8s"
 	"github.com/gruntwork-io/terratest/modules/random"
-	"github.com/imroc/req/v3"
 	"github.com/marklogic/marklogic-kubernetes/test/testUtil"
 )
 
@@ -24,8 +21,6 @@ func TestMarklogicReady(t *testing.T) {
 	if e != nil {
 		t.Fatalf(e.Error())
 	}
-	var body []byte
-	var err error
 	var initialChartVersion string
 	imageRepo, repoPres := os.LookupEnv("dockerRepository")
 	imageTag, tagPres := os.LookupEnv("dockerVersion")
@@ -112,35 +107,7 @@ func TestMarklogicReady(t *testing.T) {
 		testUtil.HelmUpgrade(t, helmUpgradeOptions, releaseName, kubectlOptions, []string{podZeroName, podOneName}, initialChartVersion)
 	}
 
-	tunnel := k8s.NewTunnel(
-		kubectlOptions, k8s.ResourceTypePod, podOneName, 8001, 8001)
-	defer tunnel.Close()
-	tunnel.ForwardPort(t)
-	endpoint := fmt.Sprintf("http://%s/admin/v1/timestamp", tunnel.Endpoint())
-	t.Logf(`Endpoint: %s`, endpoint)
-
-	// Make request to server as soon as it is ready
-	client := req.C().
-		SetCommonDigestAuth(username, password).
-		SetCommonRetryCount(10).
-		SetCommonRetryFixedInterval(10 * time.Second)
-
-	// Make request to server as soon as it is ready
-	resp, err := client.R().
-		Get(endpoint)
-
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	defer resp.Body.Close()
-
-	if body, err = io.ReadAll(resp.Body); err != nil {
-		t.Fatalf(err.Error())
-	}
-
-	t.Logf("Timestamp response:\n" + string(body))
-
 	tlsConfig := tls.Config{}
 	// restart all pods at once in the cluster and verify its ready and MarkLogic server is healthy
-	testUtil.RestartPodAndVerify(t, true, []string{podZeroName, podOneName}, namespaceName, kubectlOptions, &tlsConfig)
+	testUtil.RestartPodAndVerify(t, true, []string{podZeroName, podOneName}, namespaceName, kubectlOptions, &tlsConfig, username, password)
 }
@@ -141,7 +141,7 @@ func TestTLSEnabledWithSelfSigned(t *testing.T) {
 	fmt.Println("StatusCode: ", resp.GetStatusCode())
 
 	// restart pod in the cluster and verify its ready and MarkLogic server is healthy
-	testUtil.RestartPodAndVerify(t, false, []string{podName}, namespaceName, kubectlOptions, &tlsConfig)
+	testUtil.RestartPodAndVerify(t, false, []string{podName}, namespaceName, kubectlOptions, &tlsConfig, username, password)
 }
 
 func GenerateCACertificate(caPath string) error {
@@ -403,7 +403,7 @@ func TestTLSEnabledWithNamedCert(t *testing.T) {
 	}
 
 	// restart all pods at once in the cluster and verify its ready and MarkLogic server is healthy
-	testUtil.RestartPodAndVerify(t, true, []string{podName, podOneName}, namespaceName, kubectlOptions, &tlsConfig)
+	testUtil.RestartPodAndVerify(t, true, []string{podName, podOneName}, namespaceName, kubectlOptions, &tlsConfig, "admin", "admin")
 }
 
 func TestTlsOnEDnode(t *testing.T) {
@@ -603,5 +603,5 @@ func TestTlsOnEDnode(t *testing.T) {
 
 	tlsConfig := tls.Config{}
 	// restart all pods at once in the cluster and verify its ready and MarkLogic server is healthy
-	testUtil.RestartPodAndVerify(t, true, []string{dnodePodName, enodePodName0, enodePodName1}, namespaceName, kubectlOptions, &tlsConfig)
+	testUtil.RestartPodAndVerify(t, true, []string{dnodePodName, enodePodName0, enodePodName1}, namespaceName, kubectlOptions, &tlsConfig, "admin", "admin")
 }
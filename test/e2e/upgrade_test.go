@@ -150,7 +150,7 @@ func TestHelmUpgrade(t *testing.T) {
 	}
 
 	// restart all pods at once in the cluster and verify its ready and MarkLogic server is healthy
-	testUtil.RestartPodAndVerify(t, true, []string{podZeroName, podOneName}, namespaceName, kubectlOptions, &tlsConfig)
+	testUtil.RestartPodAndVerify(t, true, []string{podZeroName, podOneName}, namespaceName, kubectlOptions, &tlsConfig, username, passwordAfterUpgrade)
 }
 
 func TestMLupgrade(t *testing.T) {
@@ -283,5 +283,5 @@ func TestMLupgrade(t *testing.T) {
 
 	tlsConfig := tls.Config{}
 	// restart pod in the cluster and verify its ready and MarkLogic server is healthy
-	testUtil.RestartPodAndVerify(t, false, []string{podName}, namespaceName, kubectlOptions, &tlsConfig)
+	testUtil.RestartPodAndVerify(t, false, []string{podName}, namespaceName, kubectlOptions, &tlsConfig, username, password)
 }
@@ -164,5 +164,5 @@ func TestHugePagesSettings(t *testing.T) {
 	}
 
 	// restart pod in the cluster and verify its ready and MarkLogic server is healthy
-	testUtil.RestartPodAndVerify(t, false, []string{podName}, namespaceName, kubectlOptions, &tlsConfig)
+	testUtil.RestartPodAndVerify(t, false, []string{podName}, namespaceName, kubectlOptions, &tlsConfig, username, password)
 }
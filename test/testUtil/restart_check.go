@@ -0,0 +1,66 @@
+// Package testUtil contains utility functions for all the tests in this repo
+package testUtil
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/k8s"
+
+	"github.com/marklogic/marklogic-kubernetes/pkg/mlclient"
+	"github.com/marklogic/marklogic-kubernetes/pkg/restart"
+)
+
+// adminClient : testUtil function to create a MarkLogic client for the Admin API of a pod through a port forward
+func adminClient(t *testing.T, kubectlOpt *k8s.KubectlOptions, podName string, username string, password string) (*mlclient.Client, *k8s.Tunnel) {
+	port := k8s.GetAvailablePort(t)
+	tunnel := k8s.NewTunnel(kubectlOpt, k8s.ResourceTypePod, podName, port, mlclient.DefaultAdminPort)
+	tunnel.ForwardPort(t)
+	cfg := mlclient.Config{
+		Host:      "localhost",
+		AdminPort: port,
+		Username:  username,
+		Password:  password,
+		// MarkLogic may still be starting when the port forward is ready
+		MaxRetries:    10,
+		RetryInterval: 10 * time.Second,
+	}
+	client, err := mlclient.New(cfg)
+	if err == nil {
+		// the Admin API answers plain http with 403 when TLS is enabled, the
+		// certificate of the pod does not name the port forward
+		if _, tsErr := client.Timestamp(context.Background()); mlclient.IsForbidden(tsErr) {
+			cfg.HTTPS = true
+			cfg.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+			client, err = mlclient.New(cfg)
+		}
+	}
+	if err != nil {
+		tunnel.Close()
+		t.Fatal(err)
+	}
+	return client, tunnel
+}
+
+// MLTimestamp : testUtil function to get the last startup timestamp of MarkLogic in a pod for e2e tests
+func MLTimestamp(t *testing.T, kubectlOpt *k8s.KubectlOptions, podName string, username string, password string) string {
+	client, tunnel := adminClient(t, kubectlOpt, podName, username, password)
+	defer tunnel.Close()
+	timestamp, err := client.Timestamp(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get timestamp of %s: %s", podName, err.Error())
+	}
+	return timestamp
+}
+
+// VerifyMLRestart : testUtil function to verify that MarkLogic in a pod started after the baseline timestamp for e2e tests
+func VerifyMLRestart(t *testing.T, kubectlOpt *k8s.KubectlOptions, podName string, username string, password string, baseline string) {
+	client, tunnel := adminClient(t, kubectlOpt, podName, username, password)
+	defer tunnel.Close()
+	err := restart.Wait(context.Background(), client, baseline, restart.Backoff{Interval: 5 * time.Second, Steps: 20})
+	if err != nil {
+		t.Fatalf("MarkLogic in %s did not restart: %s", podName, err.Error())
+	}
+}
@@ -10,8 +10,14 @@ import (
 	"github.com/gruntwork-io/terratest/modules/k8s"
 )
 
-// RestartPodAndVerify : testUtil function to restart pods and verify its ready and healthy for e2e tests
-func RestartPodAndVerify(t *testing.T, delAtOnce bool, podList []string, namespaceName string, kubectlOpt *k8s.KubectlOptions, tlsConfig *tls.Config) {
+// RestartPodAndVerify : testUtil function to restart pods and verify MarkLogic restarted in them and is healthy for e2e tests
+func RestartPodAndVerify(t *testing.T, delAtOnce bool, podList []string, namespaceName string, kubectlOpt *k8s.KubectlOptions, tlsConfig *tls.Config, username string, password string) {
+
+	// the startup time of MarkLogic before the restart, a pod that is ready again could still report it
+	baselines := map[string]string{}
+	for _, pod := range podList {
+		baselines[pod] = MLTimestamp(t, kubectlOpt, pod, username, password)
+	}
 
 	if delAtOnce {
 		// delete all pods at once to allow restart
@@ -30,9 +36,10 @@ func RestartPodAndVerify(t *testing.T, delAtOnce bool, podList []string, namespa
 		}
 	}
 
-	// wait until the pod is in Ready status and MarkLogic server is ready
+	// wait until the pod is in Ready status, MarkLogic server restarted and is ready
 	for _, pod := range podList {
 		k8s.WaitUntilPodAvailable(t, kubectlOpt, pod, 15, 15*time.Second)
+		VerifyMLRestart(t, kubectlOpt, pod, username, password, baselines[pod])
 		if strings.HasSuffix(pod, "-0") {
 			_, err := MLReadyCheck(t, kubectlOpt, pod, tlsConfig)
 			if err != nil {